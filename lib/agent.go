@@ -0,0 +1,817 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+agent.go splits tc_reader into agents and an aggregator, for polling the TC statistics of many CPE devices centrally.
+An agent posts the parsed data of each parse cycle to the aggregator, which merges the data of all the agents into its
+own tree. The names of the Qdiscs / Classes, users, groups and interfaces of an agent are scoped by the name of its
+host, e.g. "cpe1/eth0:2:3" or "cpe1/john", so that each gets its own index.
+
+The agents post the reports as JSON over HTTP to the path /v1/agent of the aggregator, e.g.:
+{"host": "cpe1", "agent": "cpe1", "time": "2013-01-01T00:00:00Z", "interval": 10, "data": [
+  {"name": "eth0:2:3", "kind": "class", "sentBytes": 1500, "sentPkt": 10, "ifIndex": 2},
+  {"name": "john", "kind": "user", "direction": "down", "sentBytes": 1500, "sentPkt": 10}]}
+
+The kind is one of "class", "user", "group" or "iface". The aggregator merges the last report of each agent into every
+parse cycle until the report is older than three of its intervals, then the data of the agent is removed.
+
+The requests carry "Authorization: Bearer <token>" if a token is configured, the aggregator rejects the reports without
+it, see enroll.go for the agents with their own identities.
+
+The agent is the name of the agent, which tells apart the agents reporting the same host, e.g. the two routers of a HA
+pair, see conflict.go for the policies that resolve them.
+
+With the agentFullSync the reports are numbered by their "generation" and a report answered by a 2xx status is
+acknowledged. The following reports are deltas against the acknowledged generation, its "base": they carry only the
+data whose counters changed and the keys of the "removed" data, e.g.:
+{"host": "cpe1", "time": "2013-01-01T00:00:10Z", "interval": 10, "generation": 2, "base": 1, "data": [
+  {"name": "eth0:2:3", "kind": "class", "sentBytes": 3000, "sentPkt": 20, "ifIndex": 2}],
+  "removed": [{"name": "john", "kind": "user", "direction": "down"}]}
+
+The aggregator answers a delta whose base isn't its last generation of the agent, e.g. after it restarted or missed a
+report, by 409 Conflict and the agent posts the data again in full. A full report, without the base, is also posted
+once per agentFullSync, so that the data of both sides can't diverge for longer.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// agentPath is the path of the aggregator the agents post their reports to.
+	agentPath = "/v1/agent"
+
+	// agentQueueSize is the number of reports waiting to be posted, the further ones are dropped.
+	agentQueueSize = 4
+
+	// agentTimeout is the timeout of posting a report and of reading a report by the aggregator.
+	agentTimeout = 10 * time.Second
+
+	// agentMaxReportSize is the maximum size of a report accepted by the aggregator.
+	agentMaxReportSize = 1 << 22
+
+	// agentStaleIntervals is the number of the intervals of an agent after which its last report is removed.
+	agentStaleIntervals = 3
+
+	// agentHostSeparator separates the name of the host of an agent from the names of its data.
+	agentHostSeparator = "/"
+
+	// agentMaxErrorSize is the maximum size of the message of an error answered by the aggregator that is logged.
+	agentMaxErrorSize = 512
+)
+
+// errAgentBase is returned when the aggregator doesn't have the base generation of a delta.
+var errAgentBase = errors.New("the aggregator doesn't have the base generation of the delta")
+
+// The kinds of the data in the reports.
+const (
+	agentKindClass = "class"
+	agentKindUser  = "user"
+	agentKindGroup = "group"
+	agentKindIface = "iface"
+)
+
+// reAgentHostName matches the valid names of the hosts of the agents.
+var reAgentHostName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// AgentOptions are the options of posting the parsed data to an aggregator.
+type AgentOptions struct {
+	// Target is the URL of the aggregator, e.g. "http://aggregator.example.com:9100". The reports are posted to
+	// its agentPath. The data isn't posted if this isn't set.
+	Target string
+
+	// Host is the name the data is scoped by in the aggregator. Defaults to the hostname.
+	Host string
+
+	// Name is the name of the agent, which tells apart the agents reporting the same Host. Defaults to the hostname.
+	Name string
+
+	// Master marks the agent whose data is preferred by the aggregator over the other agents reporting the same Host.
+	Master bool
+
+	// Token is the bearer token sent with the reports, none is sent if this isn't set.
+	Token string
+
+	// EnrollToken is the token the agent enrolls at the aggregator with, if the TokenFile doesn't exist yet.
+	EnrollToken string
+
+	// TokenFile keeps the token of the enrolled agent, which is sent with the reports instead of the Token.
+	TokenFile string
+
+	// CAFile is the file with the PEM encoded CA certificates that verify the aggregator. Defaults to the system roots.
+	CAFile string
+
+	// CertFile and KeyFile are the PEM encoded client certificate and its key the agent is identified by.
+	CertFile string
+	KeyFile  string
+
+	// FullSync is the period of the full reports, the reports in between are deltas against the last acknowledged
+	// one. Every report is full if this isn't set.
+	FullSync time.Duration
+}
+
+// AggregatorOptions are the options of the server merging the data of the agents.
+type AggregatorOptions struct {
+	// Listen is the address the server listens on, e.g. ":9100". The server isn't started if this isn't set.
+	Listen string
+
+	// Token is the shared bearer token the reports of any host may carry. The reports are accepted without any token
+	// if neither this nor the EnrollToken is set.
+	Token string
+
+	// EnrollToken is the token the agents enroll with, the agents can't enroll if this isn't set.
+	EnrollToken string
+
+	// TokenFile keeps the hashes of the tokens of the enrolled agents, they are forgotten on restart if this isn't set.
+	TokenFile string
+
+	// CertFile and KeyFile are the PEM encoded certificate and its key of serving the agents over TLS. The agents are
+	// served over plain HTTP if these aren't set.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the file with the PEM encoded CA certificates that verify the client certificates of the agents.
+	// The agents need no certificates if this isn't set.
+	ClientCAFile string
+
+	// Conflicts is the policy of the hosts reported by several agents, one of the conflict* constants. Defaults to
+	// conflictPreferMaster.
+	Conflicts string
+}
+
+// agentReport is the parsed data of a parse cycle of an agent.
+type agentReport struct {
+	// Host is the name of the host of the agent.
+	Host string `json:"host"`
+
+	// Agent is the name of the agent, the aggregator sets it to the address of the agent if it is empty.
+	Agent string `json:"agent,omitempty"`
+
+	// Master indicates that the data of the agent is preferred over the other agents reporting the host.
+	Master bool `json:"master,omitempty"`
+
+	// Time is the time of the parse cycle.
+	Time time.Time `json:"time"`
+
+	// Interval is the parse interval of the agent in seconds.
+	Interval int `json:"interval"`
+
+	// Generation is the number of the report, zero if the agent doesn't post deltas.
+	Generation uint64 `json:"generation,omitempty"`
+
+	// Base is the generation the report is a delta against, zero for the full reports.
+	Base uint64 `json:"base,omitempty"`
+
+	// Data are the counters of the parse cycle, only those that changed since the base for the deltas.
+	Data []agentEntry `json:"data"`
+
+	// Removed are the keys of the data of the base that the parse cycle doesn't have, empty for the full reports.
+	Removed []agentKey `json:"removed,omitempty"`
+}
+
+// agentKey identifies a Qdisc / Class, user, group or interface of an agent.
+type agentKey struct {
+	// Name is the name of the Qdisc / Class, user, group or interface on the agent.
+	Name string `json:"name"`
+
+	// Kind is one of the agentKind* constants.
+	Kind string `json:"kind"`
+
+	// Direction is "up" or "down" for the users, empty otherwise.
+	Direction string `json:"direction,omitempty"`
+}
+
+// agentEntry are the counters of a Qdisc / Class, user, group or interface of an agent.
+type agentEntry struct {
+	// Name is the name of the Qdisc / Class, user, group or interface on the agent.
+	Name string `json:"name"`
+
+	// Kind is one of the agentKind* constants.
+	Kind string `json:"kind"`
+
+	// Direction is "up" or "down" for the users, empty otherwise.
+	Direction string `json:"direction,omitempty"`
+
+	// SentBytes is the number of sent bytes.
+	SentBytes int64 `json:"sentBytes"`
+
+	// SentPkt is the number of sent packets.
+	SentPkt int64 `json:"sentPkt"`
+
+	// DroppedPkt is the number of dropped packets.
+	DroppedPkt int64 `json:"droppedPkt"`
+
+	// OverLimitPkt is the number of over limit packets.
+	OverLimitPkt int64 `json:"overLimitPkt"`
+
+	// IfIndex is the kernel ifIndex of the interface of a Qdisc / Class on the agent, zero if it wasn't resolved.
+	IfIndex int `json:"ifIndex,omitempty"`
+}
+
+// newAgentEntry returns the entry of the parsedData of the kind.
+func newAgentEntry(kind string, data *parsedData) agentEntry {
+	entry := agentEntry{
+		Name:         data.name,
+		Kind:         kind,
+		SentBytes:    data.sentBytes,
+		SentPkt:      data.sentPkt,
+		DroppedPkt:   data.droppedPkt,
+		OverLimitPkt: data.overLimitPkt,
+		IfIndex:      data.ifIndex,
+	}
+	if data.userClass != nil {
+		entry.Direction = "up"
+		if data.userClass.Direction == DownloadDirection {
+			entry.Direction = "down"
+		}
+	}
+	return entry
+}
+
+// key returns the key of the entry.
+func (e *agentEntry) key() agentKey {
+	return agentKey{Name: e.Name, Kind: e.Kind, Direction: e.Direction}
+}
+
+// parsedData returns the parsedData of the entry with the name scoped by the host.
+func (e *agentEntry) parsedData(host string) (*parsedData, error) {
+	data := &parsedData{
+		name:         host + agentHostSeparator + e.Name,
+		sentBytes:    e.SentBytes,
+		sentPkt:      e.SentPkt,
+		droppedPkt:   e.DroppedPkt,
+		overLimitPkt: e.OverLimitPkt,
+	}
+	switch e.Kind {
+	case agentKindClass:
+		data.ifIndex = e.IfIndex
+	case agentKindUser:
+		user := &UserClass{Direction: UploadDirection, Name: data.name}
+		switch e.Direction {
+		case "up":
+		case "down":
+			user.Direction = DownloadDirection
+		default:
+			return nil, fmt.Errorf("the user %s has an unknown direction '%s'", e.Name, e.Direction)
+		}
+		data.userClass = user
+	case agentKindGroup, agentKindIface:
+	default:
+		return nil, fmt.Errorf("%s has an unknown kind '%s'", e.Name, e.Kind)
+	}
+	return data, nil
+}
+
+// agentSink implements dataSink, it posts the data of each parse cycle to the aggregator.
+type agentSink struct {
+	// options holds the configurable options.
+	options *AgentOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// host is the name of the host sent in the reports.
+	host string
+
+	// name is the name of the agent sent in the reports.
+	name string
+
+	// interval is the parse interval in seconds sent in the reports.
+	interval int
+
+	// client posts the reports.
+	client *http.Client
+
+	// pseudonymizer replaces the names of the users in the reports, nil if these are kept.
+	pseudonymizer *pseudonymizer
+
+	// enrolledToken is the token of the enrolled agent, empty until it is read from the TokenFile or enrolled.
+	enrolledToken string
+
+	// entries are the data added in the current parse cycle.
+	entries []agentEntry
+
+	// parsed indicates that the data was erased and added again in the current parse cycle, the cycles that were
+	// skipped aren't posted.
+	parsed bool
+
+	// queue holds the data of the parse cycles until they are posted.
+	queue chan *agentSnapshot
+
+	// generation is the generation of the last posted report. It and the following fields are used only by send.
+	generation uint64
+
+	// acked are the data of the last acknowledged report keyed by their keys, nil if none was acknowledged.
+	acked map[agentKey]agentEntry
+
+	// ackedGeneration is the generation of the last acknowledged report.
+	ackedGeneration uint64
+
+	// fullSynced is the time of the parse cycle of the last acknowledged full report.
+	fullSynced time.Time
+}
+
+// agentSnapshot is the data of a parse cycle waiting to be posted.
+type agentSnapshot struct {
+	// time is the time of the parse cycle.
+	time time.Time
+
+	// entries are the data of the parse cycle.
+	entries []agentEntry
+}
+
+// newAgentSink creates an agentSink and starts posting the reports in the background. The pseudonymizer replaces the
+// names of the users unless it is nil.
+func newAgentSink(options *AgentOptions, interval int, pseudonymizer *pseudonymizer, logger sysLogger) *agentSink {
+	host, name := options.Host, options.Name
+	if host == emptyString || name == emptyString {
+		hostname, err := os.Hostname()
+		if err != nil {
+			logger.Err(fmt.Sprintf("newAgentSink(): Unable to determine the hostname for the reports, error: %s", err))
+		}
+		if host == emptyString {
+			host = hostname
+		}
+		if name == emptyString {
+			name = hostname
+		}
+	}
+	a := &agentSink{
+		options:       options,
+		logger:        logger,
+		host:          host,
+		name:          name,
+		interval:      interval,
+		client:        &http.Client{Timeout: agentTimeout},
+		pseudonymizer: pseudonymizer,
+		queue:         make(chan *agentSnapshot, agentQueueSize),
+	}
+	// The TLS config was validated by TcParserOptions.validate.
+	if tlsConfig, _ := options.clientTLSConfig(); tlsConfig != nil {
+		a.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	go a.send()
+	return a
+}
+
+// begin starts collecting the data of a parse cycle.
+func (a *agentSink) begin() {
+	a.entries = a.entries[:0]
+	a.parsed = false
+}
+
+// commit queues the data of the parse cycle for posting. The data is dropped if the queue is full.
+func (a *agentSink) commit() {
+	if !a.parsed {
+		return
+	}
+	snapshot := &agentSnapshot{time: time.Now().UTC(), entries: append([]agentEntry(nil), a.entries...)}
+	select {
+	case a.queue <- snapshot:
+	default:
+		a.logger.Err(fmt.Sprintf("commit(): Too many reports waiting, dropping the report for %s.", a.options.Target))
+	}
+}
+
+// erase marks the parse cycle as parsed.
+func (a *agentSink) erase() {
+	a.parsed = true
+}
+
+// addData adds the counters of a Qdisc / Class or user.
+func (a *agentSink) addData(data *parsedData) {
+	if data.userClass == nil {
+		a.entries = append(a.entries, newAgentEntry(agentKindClass, data))
+		return
+	}
+	entry := newAgentEntry(agentKindUser, data)
+	if a.pseudonymizer != nil {
+		entry.Name = a.pseudonymizer.name(entry.Name)
+	}
+	a.entries = append(a.entries, entry)
+}
+
+// addGroupData adds the counters of an interface group.
+func (a *agentSink) addGroupData(data *parsedData) {
+	a.entries = append(a.entries, newAgentEntry(agentKindGroup, data))
+}
+
+// addIfaceData adds the counters of an interface.
+func (a *agentSink) addIfaceData(data *parsedData) {
+	a.entries = append(a.entries, newAgentEntry(agentKindIface, data))
+}
+
+// addXstats ignores the xstats, only the counters are posted.
+func (a *agentSink) addXstats(name string, xstats []xstat) {}
+
+// addNetDevData ignores the kernel counters, only the counters of TC are posted.
+func (a *agentSink) addNetDevData(stats *netDevStats) {}
+
+// addConntrackData ignores the conntrack counters, only the counters of TC are posted.
+func (a *agentSink) addConntrackData(data *parsedData) {}
+
+// addWarning ignores the warning, the agent logs it.
+func (a *agentSink) addWarning(message string) {}
+
+// setMaintenance ignores the maintenance, no reports are posted meanwhile.
+func (a *agentSink) setMaintenance(active bool) {}
+
+// setHealth ignores the health, no reports are posted while backing off.
+func (a *agentSink) setHealth(failures int, backoff int) {}
+
+// setUnmatchedLines ignores the unmatched lines, the agent logs them.
+func (a *agentSink) setUnmatchedLines(lines int) {}
+
+// send posts the queued data to the aggregator.
+func (a *agentSink) send() {
+	for snapshot := range a.queue {
+		a.sendSnapshot(snapshot)
+	}
+}
+
+// sendSnapshot posts the data of a parse cycle, as a delta unless a full report is due. The data is posted again in
+// full if the aggregator doesn't have the base of the delta.
+func (a *agentSink) sendSnapshot(snapshot *agentSnapshot) {
+	full := a.fullSyncDue(snapshot.time)
+	err := a.post(a.report(snapshot, full))
+	if !full && errors.Is(err, errAgentBase) {
+		a.logger.Info(fmt.Sprintf("sendSnapshot(): The aggregator %s doesn't have the generation %d, posting the full report.", a.options.Target, a.ackedGeneration))
+		full = true
+		err = a.post(a.report(snapshot, full))
+	}
+	if err != nil {
+		a.logger.Err(fmt.Sprintf("sendSnapshot(): Unable to post the report to %s, error: %s", a.options.Target, err))
+		return
+	}
+	if a.options.FullSync <= 0 {
+		return
+	}
+	a.acked = make(map[agentKey]agentEntry, len(snapshot.entries))
+	for _, entry := range snapshot.entries {
+		a.acked[entry.key()] = entry
+	}
+	a.ackedGeneration = a.generation
+	if full {
+		a.fullSynced = snapshot.time
+	}
+}
+
+// fullSyncDue returns true if the data of the parse cycle at the time must be posted in full.
+func (a *agentSink) fullSyncDue(now time.Time) bool {
+	return a.options.FullSync <= 0 || a.acked == nil || now.Sub(a.fullSynced) >= a.options.FullSync
+}
+
+// report returns the next report of the data, the delta against the acknowledged report unless full is set.
+func (a *agentSink) report(snapshot *agentSnapshot, full bool) *agentReport {
+	report := &agentReport{Host: a.host, Agent: a.name, Master: a.options.Master, Time: snapshot.time, Interval: a.interval}
+	if a.options.FullSync > 0 {
+		a.generation++
+		report.Generation = a.generation
+	}
+	if full {
+		report.Data = snapshot.entries
+		return report
+	}
+	report.Base = a.ackedGeneration
+	seen := make(map[agentKey]bool, len(snapshot.entries))
+	for _, entry := range snapshot.entries {
+		key := entry.key()
+		seen[key] = true
+		if acked, ok := a.acked[key]; !ok || acked != entry {
+			report.Data = append(report.Data, entry)
+		}
+	}
+	for key := range a.acked {
+		if !seen[key] {
+			report.Removed = append(report.Removed, key)
+		}
+	}
+	sort.Slice(report.Removed, func(i, j int) bool {
+		if report.Removed[i].Kind != report.Removed[j].Kind {
+			return report.Removed[i].Kind < report.Removed[j].Kind
+		}
+		if report.Removed[i].Name != report.Removed[j].Name {
+			return report.Removed[i].Name < report.Removed[j].Name
+		}
+		return report.Removed[i].Direction < report.Removed[j].Direction
+	})
+	return report
+}
+
+// post posts a report to the aggregator. Returns errAgentBase if the aggregator doesn't have the base of a delta.
+func (a *agentSink) post(report *agentReport) error {
+	token, err := a.token()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, a.options.Target+agentPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token != emptyString {
+		request.Header.Set("Authorization", bearerPrefix+token)
+	}
+	response, err := a.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusConflict && report.Base != 0 {
+		return errAgentBase
+	}
+	if response.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, agentMaxErrorSize))
+		return fmt.Errorf("the aggregator answered %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// reportSource identifies the reports of an agent for a host.
+type reportSource struct {
+	// host is the name of the host.
+	host string
+
+	// agent is the name of the agent.
+	agent string
+}
+
+// receivedReport is the last report of an agent for a host.
+type receivedReport struct {
+	// report is the report.
+	report *agentReport
+
+	// received is the time the report was received.
+	received time.Time
+
+	// since is the time the agent started reporting the host, the agents reporting it longer are preferred.
+	since time.Time
+}
+
+// stale returns true if the report is older than agentStaleIntervals of its interval at the time.
+func (r *receivedReport) stale(now time.Time) bool {
+	return now.Sub(r.received) > agentStaleIntervals*time.Duration(r.report.Interval)*time.Second
+}
+
+// aggregator receives the reports of the agents.
+type aggregator struct {
+	// options holds the configurable options.
+	options *AggregatorOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// server serves the agents.
+	server *http.Server
+
+	// enrolled are the enrolled agents.
+	enrolled *enrolledAgents
+
+	// mu protects reports and rejected.
+	mu sync.Mutex
+
+	// reports are the last reports of the agents keyed by their hosts and agents.
+	reports map[reportSource]*receivedReport
+
+	// rejected are the last reports rejected by the conflictReject policy keyed by their hosts and agents.
+	rejected map[reportSource]*receivedReport
+}
+
+// newAggregator creates an aggregator and starts serving the agents.
+func newAggregator(options *AggregatorOptions, logger sysLogger) (*aggregator, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := options.serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	enrolled, err := loadEnrolledAgents(options.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", options.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	a := &aggregator{
+		options:  options,
+		logger:   logger,
+		enrolled: enrolled,
+		reports:  make(map[reportSource]*receivedReport),
+		rejected: make(map[reportSource]*receivedReport),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(agentPath, a.serveReport)
+	if options.EnrollToken != emptyString {
+		mux.HandleFunc(enrollPath, a.serveEnroll)
+	}
+	a.server = &http.Server{
+		Handler:      mux,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  agentTimeout,
+		WriteTimeout: agentTimeout,
+	}
+	go func() {
+		if err := a.server.Serve(listener); err != http.ErrServerClosed {
+			logger.Err(fmt.Sprintf("newAggregator(): The aggregator on %s stopped, error: %s", options.Listen, err))
+		}
+	}()
+	return a, nil
+}
+
+// close stops serving the agents.
+func (a *aggregator) close(ctx context.Context) error {
+	return a.server.Shutdown(ctx)
+}
+
+// serveReport stores the report of an agent, the deltas are applied to the last report of the agent. The report is
+// rejected if the conflictReject policy applies to its host.
+func (a *aggregator) serveReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	identity, err := a.authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	report := &agentReport{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, agentMaxReportSize)).Decode(report); err != nil {
+		http.Error(w, fmt.Sprintf("invalid report: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := report.validate(); err != nil {
+		http.Error(w, fmt.Sprintf("invalid report: %s", err), http.StatusBadRequest)
+		return
+	}
+	if identity != emptyString && report.Host != identity {
+		http.Error(w, fmt.Sprintf("the agent %s can't report the host %s", identity, report.Host), http.StatusForbidden)
+		return
+	}
+	if report.Agent == emptyString {
+		report.Agent, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	source := reportSource{host: report.Host, agent: report.Agent}
+	now := time.Now()
+	a.mu.Lock()
+	if incumbent := a.incumbent(source, now); incumbent != emptyString {
+		_, known := a.rejected[source]
+		a.rejected[source] = &receivedReport{report: report, received: now}
+		a.mu.Unlock()
+		if !known {
+			a.logger.Info(fmt.Sprintf("serveReport(): Rejected the agent %s reporting %s, it is reported by %s.", report.Agent, report.Host, incumbent))
+		}
+		http.Error(w, fmt.Sprintf("the host %s is reported by the agent %s", report.Host, incumbent), http.StatusConflict)
+		return
+	}
+	delete(a.rejected, source)
+	previous, known := a.reports[source]
+	if report.Base != 0 {
+		if !known || previous.report.Generation != report.Base {
+			a.mu.Unlock()
+			http.Error(w, fmt.Sprintf("unknown base generation %d", report.Base), http.StatusConflict)
+			return
+		}
+		report = previous.report.apply(report)
+	}
+	since := now
+	if known {
+		since = previous.since
+	}
+	a.reports[source] = &receivedReport{report: report, received: now, since: since}
+	a.mu.Unlock()
+	if !known {
+		a.logger.Info(fmt.Sprintf("serveReport(): The agent %s started reporting %s from %s.", report.Agent, report.Host, r.RemoteAddr))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validate returns an error if the report can't be merged.
+func (r *agentReport) validate() error {
+	if !reAgentHostName.MatchString(r.Host) {
+		return fmt.Errorf("the host '%s' must be a non-empty name of letters, digits, '.', '_' and '-'", r.Host)
+	}
+	if r.Agent != emptyString && !reAgentHostName.MatchString(r.Agent) {
+		return fmt.Errorf("the agent '%s' must be a name of letters, digits, '.', '_' and '-'", r.Agent)
+	}
+	if r.Interval <= 0 {
+		return fmt.Errorf("the interval must be positive, got %d", r.Interval)
+	}
+	if r.Base != 0 && r.Generation <= r.Base {
+		return fmt.Errorf("the generation %d must follow the base %d", r.Generation, r.Base)
+	}
+	for i := range r.Data {
+		if r.Data[i].Name == emptyString {
+			return errors.New("the names of the data must not be empty")
+		}
+		if _, err := r.Data[i].parsedData(r.Host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply returns the report with the delta applied to it. The report isn't modified, it may be merged meanwhile.
+func (r *agentReport) apply(delta *agentReport) *agentReport {
+	changed := make(map[agentKey]agentEntry, len(delta.Data))
+	for _, entry := range delta.Data {
+		changed[entry.key()] = entry
+	}
+	removed := make(map[agentKey]bool, len(delta.Removed))
+	for _, key := range delta.Removed {
+		removed[key] = true
+	}
+	applied := &agentReport{
+		Host:       delta.Host,
+		Agent:      delta.Agent,
+		Master:     delta.Master,
+		Time:       delta.Time,
+		Interval:   delta.Interval,
+		Generation: delta.Generation,
+		Data:       make([]agentEntry, 0, len(r.Data)+len(delta.Data)),
+	}
+	for _, entry := range r.Data {
+		key := entry.key()
+		if removed[key] {
+			continue
+		}
+		if update, ok := changed[key]; ok {
+			entry = update
+			delete(changed, key)
+		}
+		applied.Data = append(applied.Data, entry)
+	}
+	// The new data follows the data of the base in the order of the delta.
+	for _, entry := range delta.Data {
+		if _, ok := changed[entry.key()]; ok {
+			applied.Data = append(applied.Data, entry)
+		}
+	}
+	return applied
+}
+
+// addAgents adds the data of the current reports of the agents to the parse cycle.
+func (t *tcParser) addAgents() {
+	if t.aggregator == nil {
+		return
+	}
+	reports, conflicts := t.aggregator.current(time.Now())
+	for _, conflict := range conflicts {
+		t.sink.addWarning(conflict.String())
+	}
+	for _, report := range reports {
+		for i := range report.Data {
+			entry := &report.Data[i]
+			// The reports were validated when they were received.
+			data, _ := entry.parsedData(report.Host)
+			// The clock of the agent isn't comparable, the data belongs to the parse cycle of the aggregator.
+			data.monotonic = t.cycleMonotonic
+			switch entry.Kind {
+			case agentKindGroup:
+				t.sink.addGroupData(data)
+			case agentKindIface:
+				t.sink.addIfaceData(data)
+			default:
+				t.sink.addData(data)
+			}
+		}
+	}
+}
@@ -0,0 +1,415 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// newTestAggregator returns an aggregator served by a test server.
+func newTestAggregator(t *testing.T, token string) (*aggregator, *httptest.Server) {
+	t.Helper()
+	a := &aggregator{
+		options:  &AggregatorOptions{Token: token},
+		logger:   &fakeSyslog{},
+		enrolled: &enrolledAgents{hashes: make(map[string]string)},
+		reports:  make(map[reportSource]*receivedReport),
+		rejected: make(map[reportSource]*receivedReport),
+	}
+	server := httptest.NewServer(http.HandlerFunc(a.serveReport))
+	t.Cleanup(server.Close)
+	return a, server
+}
+
+func TestAgentToAggregator(t *testing.T) {
+	a, server := newTestAggregator(t, "secret")
+	sink := newAgentSink(&AgentOptions{Target: server.URL, Host: "cpe1", Token: "secret"}, 10, nil, &fakeSyslog{})
+
+	sink.begin()
+	sink.erase()
+	sink.addData(&parsedData{name: "eth0:2:3", sentBytes: 1500, sentPkt: 10, droppedPkt: 1, overLimitPkt: 2, ifIndex: 2})
+	sink.addData(&parsedData{name: "john", sentBytes: 500, sentPkt: 5, userClass: &UserClass{DownloadDirection, "john"}})
+	sink.addGroupData(&parsedData{name: "office", sentBytes: 3000, sentPkt: 20})
+	sink.addIfaceData(&parsedData{name: "eth0", sentBytes: 4000, sentPkt: 30})
+	sink.commit()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for reports, _ := a.current(time.Now()); len(reports) == 0; reports, _ = a.current(time.Now()) {
+		if time.Now().After(deadline) {
+			t.Fatal("the aggregator didn't receive the report of the agent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fs := &fakeDataSink{}
+	tp := newTcParser(&TcParserOptions{}, &fakeSyslog{}, fs)
+	tp.aggregator = a
+	tp.addAgents()
+
+	wantData := []parsedData{
+		{name: "cpe1/eth0:2:3", sentBytes: 1500, sentPkt: 10, droppedPkt: 1, overLimitPkt: 2, ifIndex: 2},
+		{name: "cpe1/john", sentBytes: 500, sentPkt: 5, userClass: &UserClass{DownloadDirection, "cpe1/john"}},
+	}
+	if diff := pretty.Compare(wantData, fs.data); diff != "" {
+		t.Errorf("addAgents => unexpected data, diff(-want, +got):\n%s", diff)
+	}
+	wantGroups := []parsedData{{name: "cpe1/office", sentBytes: 3000, sentPkt: 20}}
+	if diff := pretty.Compare(wantGroups, fs.groups); diff != "" {
+		t.Errorf("addAgents => unexpected groups, diff(-want, +got):\n%s", diff)
+	}
+	wantIfaces := []parsedData{{name: "cpe1/eth0", sentBytes: 4000, sentPkt: 30}}
+	if diff := pretty.Compare(wantIfaces, fs.ifaces); diff != "" {
+		t.Errorf("addAgents => unexpected interfaces, diff(-want, +got):\n%s", diff)
+	}
+}
+
+func TestAgentSinkSkippedCycle(t *testing.T) {
+	sink := &agentSink{options: &AgentOptions{}, logger: &fakeSyslog{}, queue: make(chan *agentSnapshot, agentQueueSize)}
+	sink.begin()
+	sink.addData(&parsedData{name: "eth0:2:3"})
+	sink.commit()
+	if len(sink.queue) != 0 {
+		t.Errorf("commit of a skipped parse cycle => got %d queued reports, want none", len(sink.queue))
+	}
+}
+
+func TestAgentSinkUserNames(t *testing.T) {
+	sink := &agentSink{options: &AgentOptions{}, logger: &fakeSyslog{}, queue: make(chan *agentSnapshot, agentQueueSize), pseudonymizer: &pseudonymizer{}}
+	sink.begin()
+	sink.erase()
+	sink.addData(&parsedData{name: "eth0:2:3", sentBytes: 1500})
+	sink.addData(&parsedData{name: "john", sentBytes: 500, userClass: &UserClass{DownloadDirection, "john"}})
+	sink.commit()
+
+	want := []agentEntry{
+		{Name: "eth0:2:3", Kind: agentKindClass, SentBytes: 1500},
+		{Name: "96d9632f363564cc", Kind: agentKindUser, Direction: "down", SentBytes: 500},
+	}
+	if diff := pretty.Compare(want, (<-sink.queue).entries); diff != "" {
+		t.Errorf("commit => unexpected entries, diff(-want, +got):\n%s", diff)
+	}
+}
+
+func TestAgentSinkReport(t *testing.T) {
+	now := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink := &agentSink{options: &AgentOptions{FullSync: time.Minute}, host: "cpe1", interval: 10}
+	sink.generation = 3
+	sink.ackedGeneration = 3
+	sink.acked = map[agentKey]agentEntry{
+		{Name: "eth0:2:3", Kind: agentKindClass}:               {Name: "eth0:2:3", Kind: agentKindClass, SentBytes: 1500},
+		{Name: "eth0:2:4", Kind: agentKindClass}:               {Name: "eth0:2:4", Kind: agentKindClass, SentBytes: 100},
+		{Name: "john", Kind: agentKindUser, Direction: "down"}: {Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 500},
+		{Name: "office", Kind: agentKindGroup}:                 {Name: "office", Kind: agentKindGroup, SentBytes: 1600},
+	}
+	snapshot := &agentSnapshot{
+		time: now,
+		entries: []agentEntry{
+			{Name: "eth0:2:3", Kind: agentKindClass, SentBytes: 3000},
+			{Name: "eth0:2:4", Kind: agentKindClass, SentBytes: 100},
+			{Name: "eth0:2:5", Kind: agentKindClass, SentBytes: 10},
+			{Name: "office", Kind: agentKindGroup, SentBytes: 3100},
+		},
+	}
+
+	want := &agentReport{
+		Host:       "cpe1",
+		Time:       now,
+		Interval:   10,
+		Generation: 4,
+		Base:       3,
+		Data: []agentEntry{
+			{Name: "eth0:2:3", Kind: agentKindClass, SentBytes: 3000},
+			{Name: "eth0:2:5", Kind: agentKindClass, SentBytes: 10},
+			{Name: "office", Kind: agentKindGroup, SentBytes: 3100},
+		},
+		Removed: []agentKey{{Name: "john", Kind: agentKindUser, Direction: "down"}},
+	}
+	if diff := pretty.Compare(want, sink.report(snapshot, false)); diff != "" {
+		t.Errorf("report of a delta => unexpected report, diff(-want, +got):\n%s", diff)
+	}
+
+	want = &agentReport{Host: "cpe1", Time: now, Interval: 10, Generation: 5, Data: snapshot.entries}
+	if diff := pretty.Compare(want, sink.report(snapshot, true)); diff != "" {
+		t.Errorf("report of a full sync => unexpected report, diff(-want, +got):\n%s", diff)
+	}
+}
+
+func TestAgentSinkFullSyncDue(t *testing.T) {
+	now := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc     string
+		fullSync time.Duration
+		acked    map[agentKey]agentEntry
+		synced   time.Time
+		want     bool
+	}{
+		{
+			desc:   "deltas aren't configured",
+			acked:  map[agentKey]agentEntry{},
+			synced: now,
+			want:   true,
+		},
+		{
+			desc:     "nothing was acknowledged",
+			fullSync: time.Minute,
+			synced:   now,
+			want:     true,
+		},
+		{
+			desc:     "the full sync isn't due",
+			fullSync: time.Minute,
+			acked:    map[agentKey]agentEntry{},
+			synced:   now.Add(-59 * time.Second),
+		},
+		{
+			desc:     "the full sync is due",
+			fullSync: time.Minute,
+			acked:    map[agentKey]agentEntry{},
+			synced:   now.Add(-time.Minute),
+			want:     true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			sink := &agentSink{options: &AgentOptions{FullSync: tc.fullSync}, acked: tc.acked, fullSynced: tc.synced}
+			if got := sink.fullSyncDue(now); got != tc.want {
+				t.Errorf("fullSyncDue => got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAgentDeltasToAggregator(t *testing.T) {
+	a, server := newTestAggregator(t, "")
+	sink := &agentSink{
+		options:  &AgentOptions{Target: server.URL, FullSync: time.Hour},
+		logger:   &fakeSyslog{},
+		host:     "cpe1",
+		interval: 10,
+		client:   server.Client(),
+	}
+	now := time.Now().UTC()
+	cycles := [][]agentEntry{
+		{
+			{Name: "eth0:2:3", Kind: agentKindClass, SentBytes: 1500},
+			{Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 500},
+			{Name: "eth0", Kind: agentKindIface, SentBytes: 4000},
+		},
+		{
+			{Name: "eth0:2:3", Kind: agentKindClass, SentBytes: 3000},
+			{Name: "eth0", Kind: agentKindIface, SentBytes: 5500},
+			{Name: "eth0:2:4", Kind: agentKindClass, SentBytes: 10},
+		},
+	}
+	for i, entries := range cycles {
+		sink.sendSnapshot(&agentSnapshot{time: now, entries: entries})
+		reports, _ := a.current(time.Now())
+		if len(reports) != 1 {
+			t.Fatalf("cycle %d => got %d reports, want 1", i, len(reports))
+		}
+		if diff := pretty.Compare(entries, reports[0].Data); diff != "" {
+			t.Errorf("cycle %d => unexpected data, diff(-want, +got):\n%s", i, diff)
+		}
+	}
+	if sink.fullSynced != now || sink.ackedGeneration != 2 {
+		t.Errorf("sendSnapshot => got the full sync at %v and the generation %d acknowledged, want %v and 2", sink.fullSynced, sink.ackedGeneration, now)
+	}
+
+	// The aggregator restarted, it doesn't have the base of the next delta.
+	a.reports = make(map[reportSource]*receivedReport)
+	later := now.Add(10 * time.Second)
+	entries := []agentEntry{{Name: "eth0:2:3", Kind: agentKindClass, SentBytes: 4500}}
+	sink.sendSnapshot(&agentSnapshot{time: later, entries: entries})
+	reports, _ := a.current(time.Now())
+	if len(reports) != 1 {
+		t.Fatalf("after the restart => got %d reports, want 1", len(reports))
+	}
+	if diff := pretty.Compare(entries, reports[0].Data); diff != "" {
+		t.Errorf("after the restart => unexpected data, diff(-want, +got):\n%s", diff)
+	}
+	if sink.fullSynced != later {
+		t.Errorf("after the restart => got the full sync at %v, want %v", sink.fullSynced, later)
+	}
+}
+
+func TestAgentReportApply(t *testing.T) {
+	base := &agentReport{
+		Host:       "cpe1",
+		Interval:   10,
+		Generation: 1,
+		Data: []agentEntry{
+			{Name: "eth0:2:3", Kind: agentKindClass, SentBytes: 1500},
+			{Name: "john", Kind: agentKindUser, Direction: "up", SentBytes: 100},
+			{Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 500},
+		},
+	}
+	delta := &agentReport{
+		Host:       "cpe1",
+		Interval:   10,
+		Generation: 2,
+		Base:       1,
+		Data: []agentEntry{
+			{Name: "eth0:2:4", Kind: agentKindClass, SentBytes: 10},
+			{Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 700},
+		},
+		Removed: []agentKey{{Name: "john", Kind: agentKindUser, Direction: "up"}},
+	}
+	want := &agentReport{
+		Host:       "cpe1",
+		Interval:   10,
+		Generation: 2,
+		Data: []agentEntry{
+			{Name: "eth0:2:3", Kind: agentKindClass, SentBytes: 1500},
+			{Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 700},
+			{Name: "eth0:2:4", Kind: agentKindClass, SentBytes: 10},
+		},
+	}
+	if diff := pretty.Compare(want, base.apply(delta)); diff != "" {
+		t.Errorf("apply => unexpected report, diff(-want, +got):\n%s", diff)
+	}
+	if len(base.Data) != 3 || base.Data[2].SentBytes != 500 {
+		t.Errorf("apply => the base was modified: %v", base.Data)
+	}
+}
+
+func TestAggregatorServeReport(t *testing.T) {
+	tests := []struct {
+		desc       string
+		method     string
+		token      string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc:       "valid report",
+			method:     http.MethodPost,
+			token:      "secret",
+			body:       `{"host": "cpe1", "interval": 10, "data": [{"name": "eth0:2:3", "kind": "class", "sentBytes": 1}]}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			desc:       "not a POST",
+			method:     http.MethodGet,
+			token:      "secret",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   "only POST is supported",
+		},
+		{
+			desc:       "wrong token",
+			method:     http.MethodPost,
+			token:      "guess",
+			body:       `{"host": "cpe1", "interval": 10, "data": []}`,
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   "invalid token",
+		},
+		{
+			desc:       "invalid host",
+			method:     http.MethodPost,
+			token:      "secret",
+			body:       `{"host": "cpe 1", "interval": 10, "data": []}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid report: the host 'cpe 1' must be a non-empty name of letters, digits, '.', '_' and '-'",
+		},
+		{
+			desc:       "missing interval",
+			method:     http.MethodPost,
+			token:      "secret",
+			body:       `{"host": "cpe1", "data": []}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid report: the interval must be positive, got 0",
+		},
+		{
+			desc:       "unknown kind",
+			method:     http.MethodPost,
+			token:      "secret",
+			body:       `{"host": "cpe1", "interval": 10, "data": [{"name": "eth0:2:3", "kind": "qdisc"}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid report: eth0:2:3 has an unknown kind 'qdisc'",
+		},
+		{
+			desc:       "delta without its base",
+			method:     http.MethodPost,
+			token:      "secret",
+			body:       `{"host": "cpe1", "interval": 10, "generation": 2, "base": 1, "data": []}`,
+			wantStatus: http.StatusConflict,
+			wantBody:   "unknown base generation 1",
+		},
+		{
+			desc:       "delta older than its base",
+			method:     http.MethodPost,
+			token:      "secret",
+			body:       `{"host": "cpe1", "interval": 10, "generation": 1, "base": 1, "data": []}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid report: the generation 1 must follow the base 1",
+		},
+		{
+			desc:       "user without a direction",
+			method:     http.MethodPost,
+			token:      "secret",
+			body:       `{"host": "cpe1", "interval": 10, "data": [{"name": "john", "kind": "user"}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid report: the user john has an unknown direction ''",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			a, _ := newTestAggregator(t, "secret")
+			request := httptest.NewRequest(tc.method, agentPath, strings.NewReader(tc.body))
+			request.Header.Set("Authorization", "Bearer "+tc.token)
+			recorder := httptest.NewRecorder()
+			a.serveReport(recorder, request)
+			if recorder.Code != tc.wantStatus {
+				t.Errorf("serveReport => got status %d, want %d", recorder.Code, tc.wantStatus)
+			}
+			if got := strings.TrimSpace(recorder.Body.String()); got != tc.wantBody {
+				t.Errorf("serveReport => got body '%s', want '%s'", got, tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestAggregatorCurrent(t *testing.T) {
+	now := time.Now()
+	a, _ := newTestAggregator(t, "")
+	a.reports[reportSource{"cpe2", "cpe2"}] = &receivedReport{report: &agentReport{Host: "cpe2", Agent: "cpe2", Interval: 10}, received: now.Add(-20 * time.Second)}
+	a.reports[reportSource{"cpe1", "cpe1"}] = &receivedReport{report: &agentReport{Host: "cpe1", Agent: "cpe1", Interval: 10}, received: now}
+	a.reports[reportSource{"cpe3", "cpe3"}] = &receivedReport{report: &agentReport{Host: "cpe3", Agent: "cpe3", Interval: 10}, received: now.Add(-31 * time.Second)}
+
+	var hosts []string
+	reports, conflicts := a.current(now)
+	if len(conflicts) != 0 {
+		t.Errorf("current => got conflicts %v, want none", conflicts)
+	}
+	for _, report := range reports {
+		hosts = append(hosts, report.Host)
+	}
+	if diff := pretty.Compare([]string{"cpe1", "cpe2"}, hosts); diff != "" {
+		t.Errorf("current => unexpected hosts, diff(-want, +got):\n%s", diff)
+	}
+	if _, ok := a.reports[reportSource{"cpe3", "cpe3"}]; ok {
+		t.Error("current => the stale report of cpe3 wasn't removed")
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+alert.go evaluates the configured thresholds on the exported metrics after each parse cycle.
+
+An alert rule names a metric, a pattern of the names of the Qdiscs / Classes, users or groups, an optional rate,
+a comparison and an action, e.g.:
+"droppedPkt" "eth0:2:*" rate > 100/s "syslog"
+"userQuotaUsed" "*" >= 90 "exec" "/usr/local/bin/quota_hook"
+
+The action fires when the comparison becomes true and fires again only after it was false in between.
+*/
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	// alertSyslog logs the alert to Syslog.
+	alertSyslog = "syslog"
+
+	// alertTrap sends the tcAlertTrap notification.
+	alertTrap = "trap"
+
+	// alertExec runs a hook script with the metric, the name, the value and the threshold as arguments.
+	alertExec = "exec"
+
+	// alertHookTimeout is the time after which a hook script is killed.
+	alertHookTimeout = 30 * time.Second
+)
+
+// namedMetric is a metric that the alert rules can be defined on and that the sinks send.
+type namedMetric struct {
+	// leaf is the SNMP leaf number where the metric is stored.
+	leaf int
+
+	// nameLeaf is the SNMP leaf number where the names are stored under the same indexes as the metric.
+	nameLeaf int
+
+	// counter indicates that the metric only grows, so that its change per second is meaningful.
+	counter bool
+}
+
+// namedMetrics maps the names of the metrics used in the alert rules and by the sinks to the metrics.
+var namedMetrics = map[string]namedMetric{
+	"sentBytes":            {sentBytesLeaf, tcNameLeaf, true},
+	"sentPkt":              {sentPktLeaf, tcNameLeaf, true},
+	"droppedPkt":           {droppedPktLeaf, tcNameLeaf, true},
+	"overLimitPkt":         {overLimitPktLeaf, tcNameLeaf, true},
+	"efficiency":           {tcEfficiencyLeaf, tcNameLeaf, false},
+	"dropRate":             {tcDropRateLeaf, tcNameLeaf, false},
+	"dropOverlimit":        {tcDropOverlimitLeaf, tcNameLeaf, true},
+	"ecnMark":              {tcEcnMarkLeaf, tcNameLeaf, true},
+	"newFlowCount":         {tcNewFlowCountLeaf, tcNameLeaf, true},
+	"lended":               {tcLendedLeaf, tcNameLeaf, true},
+	"borrowed":             {tcBorrowedLeaf, tcNameLeaf, true},
+	"giants":               {tcGiantsLeaf, tcNameLeaf, true},
+	"rate1m":               {tcRate1mLeaf, tcNameLeaf, false},
+	"rate5m":               {tcRate5mLeaf, tcNameLeaf, false},
+	"rate15m":              {tcRate15mLeaf, tcNameLeaf, false},
+	"peakRate":             {tcPeakRateLeaf, tcNameLeaf, false},
+	"deltaBytes":           {tcDeltaBytesLeaf, tcNameLeaf, false},
+	"deltaPkt":             {tcDeltaPktLeaf, tcNameLeaf, false},
+	"userDownBytes":        {tcUserDownBytesLeaf, tcUserNameLeaf, true},
+	"userDownPkt":          {tcUserDownPktLeaf, tcUserNameLeaf, true},
+	"userDownDroppedPkt":   {tcUserDownDroppedPktLeaf, tcUserNameLeaf, true},
+	"userDownOverLimitPkt": {tcUserDownOverLimitPktLeaf, tcUserNameLeaf, true},
+	"userUpBytes":          {tcUserUpBytesLeaf, tcUserNameLeaf, true},
+	"userUpPkt":            {tcUserUpPktLeaf, tcUserNameLeaf, true},
+	"userUpDroppedPkt":     {tcUserUpDroppedPktLeaf, tcUserNameLeaf, true},
+	"userUpOverLimitPkt":   {tcUserUpOverLimitPktLeaf, tcUserNameLeaf, true},
+	"userDownAvgPktSize":   {tcUserDownAvgPktSizeLeaf, tcUserNameLeaf, false},
+	"userUpAvgPktSize":     {tcUserUpAvgPktSizeLeaf, tcUserNameLeaf, false},
+	"userDownMonthBytes":   {tcUserDownMonthBytesLeaf, tcUserNameLeaf, true},
+	"userUpMonthBytes":     {tcUserUpMonthBytesLeaf, tcUserNameLeaf, true},
+	"userQuotaUsed":        {tcUserQuotaUsedLeaf, tcUserNameLeaf, false},
+	"userDownRate1m":       {tcUserDownRate1mLeaf, tcUserNameLeaf, false},
+	"userDownRate5m":       {tcUserDownRate5mLeaf, tcUserNameLeaf, false},
+	"userDownRate15m":      {tcUserDownRate15mLeaf, tcUserNameLeaf, false},
+	"userDownPeakRate":     {tcUserDownPeakRateLeaf, tcUserNameLeaf, false},
+	"userUpRate1m":         {tcUserUpRate1mLeaf, tcUserNameLeaf, false},
+	"userUpRate5m":         {tcUserUpRate5mLeaf, tcUserNameLeaf, false},
+	"userUpRate15m":        {tcUserUpRate15mLeaf, tcUserNameLeaf, false},
+	"userUpPeakRate":       {tcUserUpPeakRateLeaf, tcUserNameLeaf, false},
+	"userDownDeltaBytes":   {tcUserDownDeltaBytesLeaf, tcUserNameLeaf, false},
+	"userDownDeltaPkt":     {tcUserDownDeltaPktLeaf, tcUserNameLeaf, false},
+	"userUpDeltaBytes":     {tcUserUpDeltaBytesLeaf, tcUserNameLeaf, false},
+	"userUpDeltaPkt":       {tcUserUpDeltaPktLeaf, tcUserNameLeaf, false},
+	"groupBytes":           {tcGroupBytesLeaf, tcGroupNameLeaf, true},
+	"groupPkt":             {tcGroupPktLeaf, tcGroupNameLeaf, true},
+	"groupDroppedPkt":      {tcGroupDroppedPktLeaf, tcGroupNameLeaf, true},
+	"groupOverLimitPkt":    {tcGroupOverLimitPktLeaf, tcGroupNameLeaf, true},
+	"ifaceBytes":           {tcIfaceBytesLeaf, tcIfaceNameLeaf, true},
+	"ifacePkt":             {tcIfacePktLeaf, tcIfaceNameLeaf, true},
+	"ifaceDroppedPkt":      {tcIfaceDroppedPktLeaf, tcIfaceNameLeaf, true},
+	"ifaceOverLimitPkt":    {tcIfaceOverLimitPktLeaf, tcIfaceNameLeaf, true},
+	"monotonic":            {tcMonotonicLeaf, tcNameLeaf, false},
+	"userMonotonic":        {tcUserMonotonicLeaf, tcUserNameLeaf, false},
+	"groupMonotonic":       {tcGroupMonotonicLeaf, tcGroupNameLeaf, false},
+	"ifaceMonotonic":       {tcIfaceMonotonicLeaf, tcIfaceNameLeaf, false},
+}
+
+// alertComparisons maps the comparison operators to their implementations.
+var alertComparisons = map[string]func(value, threshold float64) bool{
+	">":  func(value, threshold float64) bool { return value > threshold },
+	">=": func(value, threshold float64) bool { return value >= threshold },
+	"<":  func(value, threshold float64) bool { return value < threshold },
+	"<=": func(value, threshold float64) bool { return value <= threshold },
+	"==": func(value, threshold float64) bool { return value == threshold },
+	"!=": func(value, threshold float64) bool { return value != threshold },
+}
+
+// alertRule is a threshold on an exported metric.
+type alertRule struct {
+	// metric is the name of the metric, one of the namedMetrics.
+	metric string
+
+	// pattern is the shell pattern the names of the Qdiscs / Classes, users or groups are matched against, see path.Match().
+	pattern string
+
+	// rate indicates that the threshold is compared with the change of the metric per second instead of its value.
+	rate bool
+
+	// comparison is the comparison operator, one of the alertComparisons.
+	comparison string
+
+	// threshold is the value the metric is compared with.
+	threshold float64
+
+	// action is what happens when the comparison becomes true, one of alertSyslog, alertTrap or alertExec.
+	action string
+
+	// command is the hook script run by the alertExec action.
+	command string
+}
+
+// validMetrics returns an error if any of the metrics is unknown.
+func validMetrics(metrics []string) error {
+	for _, metric := range metrics {
+		if _, ok := namedMetrics[metric]; !ok {
+			return fmt.Errorf("unknown metric '%s'", metric)
+		}
+	}
+	return nil
+}
+
+// newAlertRule validates the parts of an alert rule and creates it.
+func newAlertRule(metric, pattern string, rate bool, comparison, threshold, action, command string) (alertRule, error) {
+	if _, ok := namedMetrics[metric]; !ok {
+		return alertRule{}, fmt.Errorf("unknown metric '%s'", metric)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return alertRule{}, fmt.Errorf("invalid pattern '%s'", pattern)
+	}
+	if _, ok := alertComparisons[comparison]; !ok {
+		return alertRule{}, fmt.Errorf("unknown comparison '%s'", comparison)
+	}
+	value, err := strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return alertRule{}, fmt.Errorf("invalid threshold '%s'", threshold)
+	}
+	switch action {
+	case alertSyslog, alertTrap:
+		if command != "" {
+			return alertRule{}, fmt.Errorf("the %s action doesn't take a command", action)
+		}
+	case alertExec:
+		if command == "" {
+			return alertRule{}, fmt.Errorf("the %s action needs a command", action)
+		}
+	default:
+		return alertRule{}, fmt.Errorf("unknown action '%s'", action)
+	}
+	return alertRule{
+		metric:     metric,
+		pattern:    pattern,
+		rate:       rate,
+		comparison: comparison,
+		threshold:  value,
+		action:     action,
+		command:    command,
+	}, nil
+}
+
+// String returns the rule in a form suitable for the log messages, e.g. "droppedPkt rate > 100/s".
+func (a alertRule) String() string {
+	if a.rate {
+		return fmt.Sprintf("%s rate %s %g/s", a.metric, a.comparison, a.threshold)
+	}
+	return fmt.Sprintf("%s %s %g", a.metric, a.comparison, a.threshold)
+}
+
+// alertSample is the last evaluated value of a metric, the rates are computed against it.
+type alertSample struct {
+	// value is the value of the metric.
+	value float64
+
+	// at is the time the value was evaluated.
+	at time.Time
+}
+
+// alertValue converts the value stored in the SNMP tree into a number, returns false if it isn't a number.
+func alertValue(objectValue interface{}) (float64, bool) {
+	switch value := objectValue.(type) {
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	}
+	return 0, false
+}
+
+// runHook runs the hook script in the background, it is killed after the alertHookTimeout.
+func runHook(logger sysLogger, command string, args ...string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), alertHookTimeout)
+		defer cancel()
+		if output, err := exec.CommandContext(ctx, command, args...).CombinedOutput(); err != nil {
+			logger.Err(fmt.Sprintf("runHook(): Hook %s failed, error: %s, output: %s", command, err, output))
+		}
+	}()
+}
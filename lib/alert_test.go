@@ -0,0 +1,139 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"testing"
+)
+
+func TestNewAlertRule(t *testing.T) {
+	testData := []struct {
+		desc       string
+		metric     string
+		pattern    string
+		rate       bool
+		comparison string
+		threshold  string
+		action     string
+		command    string
+		want       string
+		wantErr    string
+	}{
+		{
+			desc:       "rate",
+			metric:     "droppedPkt",
+			pattern:    "eth0:2:*",
+			rate:       true,
+			comparison: ">",
+			threshold:  "100",
+			action:     "syslog",
+			want:       "droppedPkt rate > 100/s",
+		},
+		{
+			desc:       "value with a hook",
+			metric:     "userQuotaUsed",
+			pattern:    "*",
+			comparison: ">=",
+			threshold:  "90.5",
+			action:     "exec",
+			command:    "/bin/hook",
+			want:       "userQuotaUsed >= 90.5",
+		},
+		{
+			desc:       "unknown metric",
+			metric:     "bogus",
+			pattern:    "*",
+			comparison: ">",
+			threshold:  "1",
+			action:     "trap",
+			wantErr:    "unknown metric 'bogus'",
+		},
+		{
+			desc:       "invalid pattern",
+			metric:     "sentBytes",
+			pattern:    "eth0:[",
+			comparison: ">",
+			threshold:  "1",
+			action:     "trap",
+			wantErr:    "invalid pattern 'eth0:['",
+		},
+		{
+			desc:       "unknown comparison",
+			metric:     "sentBytes",
+			pattern:    "*",
+			comparison: "=",
+			threshold:  "1",
+			action:     "trap",
+			wantErr:    "unknown comparison '='",
+		},
+		{
+			desc:       "unknown action",
+			metric:     "sentBytes",
+			pattern:    "*",
+			comparison: ">",
+			threshold:  "1",
+			action:     "mail",
+			wantErr:    "unknown action 'mail'",
+		},
+		{
+			desc:       "command without exec",
+			metric:     "sentBytes",
+			pattern:    "*",
+			comparison: ">",
+			threshold:  "1",
+			action:     "syslog",
+			command:    "/bin/hook",
+			wantErr:    "the syslog action doesn't take a command",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := newAlertRule(tc.metric, tc.pattern, tc.rate, tc.comparison, tc.threshold, tc.action, tc.command)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("newAlertRule => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newAlertRule => unexpected error: %s", err)
+			}
+			if got.String() != tc.want {
+				t.Errorf("newAlertRule => got: %s, want: %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAlertValue(t *testing.T) {
+	testData := []struct {
+		value  interface{}
+		want   float64
+		wantOk bool
+	}{
+		{3, 3, true},
+		{int64(1 << 40), 1 << 40, true},
+		{"eth0:1:0", 0, false},
+	}
+
+	for _, tc := range testData {
+		if got, ok := alertValue(tc.value); got != tc.want || ok != tc.wantOk {
+			t.Errorf("alertValue(%v) => got: %g, %v, want: %g, %v", tc.value, got, ok, tc.want, tc.wantOk)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+blackout.go contains the maintenance windows during which the collection of the statistics is paused.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"time"
+)
+
+// minutesPerDay is the number of minutes in a day.
+const minutesPerDay = 24 * 60
+
+// blackoutWindow is a daily maintenance window in the local time, e.g. "02:00-03:30".
+type blackoutWindow struct {
+	// start is the beginning of the window in minutes since midnight.
+	start int
+
+	// end is the end of the window in minutes since midnight, the window ends before this minute.
+	// If end is lower than start, the window spans midnight.
+	end int
+}
+
+// parseBlackoutWindow parses a window in the "HH:MM-HH:MM" format.
+func parseBlackoutWindow(window string) (blackoutWindow, error) {
+	var startHour, startMinute, endHour, endMinute int
+	n, err := fmt.Sscanf(window, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute)
+	if err != nil || n != 4 {
+		return blackoutWindow{}, fmt.Errorf("unable to parse blackout window '%s', expected the HH:MM-HH:MM format", window)
+	}
+	for _, hour := range []int{startHour, endHour} {
+		if hour < 0 || hour > 23 {
+			return blackoutWindow{}, fmt.Errorf("invalid hour %d in blackout window '%s'", hour, window)
+		}
+	}
+	for _, minute := range []int{startMinute, endMinute} {
+		if minute < 0 || minute > 59 {
+			return blackoutWindow{}, fmt.Errorf("invalid minute %d in blackout window '%s'", minute, window)
+		}
+	}
+	b := blackoutWindow{
+		start: startHour*60 + startMinute,
+		end:   endHour*60 + endMinute,
+	}
+	if b.start == b.end {
+		return blackoutWindow{}, fmt.Errorf("blackout window '%s' is empty", window)
+	}
+	return b, nil
+}
+
+// contains determines whether the time falls into the window.
+func (b blackoutWindow) contains(t time.Time) bool {
+	minute := (t.Hour()*60 + t.Minute()) % minutesPerDay
+	if b.start < b.end {
+		return minute >= b.start && minute < b.end
+	}
+	return minute >= b.start || minute < b.end
+}
+
+// String returns the window in the "HH:MM-HH:MM" format.
+func (b blackoutWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", b.start/60, b.start%60, b.end/60, b.end%60)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBlackoutWindow(t *testing.T) {
+	testData := []struct {
+		desc    string
+		window  string
+		want    blackoutWindow
+		wantErr bool
+	}{
+		{
+			desc:   "window within a day",
+			window: "02:00-03:30",
+			want:   blackoutWindow{120, 210},
+		},
+		{
+			desc:   "window spanning midnight",
+			window: "23:45-00:15",
+			want:   blackoutWindow{1425, 15},
+		},
+		{
+			desc:    "garbage",
+			window:  "tonight",
+			wantErr: true,
+		},
+		{
+			desc:    "invalid hour",
+			window:  "24:00-01:00",
+			wantErr: true,
+		},
+		{
+			desc:    "invalid minute",
+			window:  "02:60-03:00",
+			wantErr: true,
+		},
+		{
+			desc:    "empty window",
+			window:  "02:00-02:00",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseBlackoutWindow(tc.window)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseBlackoutWindow(%s) => unexpected err: %v, wantErr: %v", tc.window, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("parseBlackoutWindow(%s) => got: %v, want: %v", tc.window, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBlackoutWindowContains(t *testing.T) {
+	testData := []struct {
+		desc   string
+		window blackoutWindow
+		hour   int
+		minute int
+		want   bool
+	}{
+		{"before the window", blackoutWindow{120, 210}, 1, 59, false},
+		{"start of the window", blackoutWindow{120, 210}, 2, 0, true},
+		{"inside the window", blackoutWindow{120, 210}, 3, 29, true},
+		{"end of the window", blackoutWindow{120, 210}, 3, 30, false},
+		{"before midnight in a window spanning midnight", blackoutWindow{1425, 15}, 23, 50, true},
+		{"after midnight in a window spanning midnight", blackoutWindow{1425, 15}, 0, 10, true},
+		{"outside a window spanning midnight", blackoutWindow{1425, 15}, 12, 0, false},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			now := time.Date(2013, 5, 1, tc.hour, tc.minute, 0, 0, time.Local)
+			if got := tc.window.contains(now); got != tc.want {
+				t.Errorf("%v.contains(%s) => got: %v, want: %v", tc.window, now.Format("15:04"), got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+cache.go persists the exported data to a file after each parse cycle. A restarted tc_reader answers the SNMP daemon
+from the persisted data until its first parse cycle completes, so that the requests arriving in the first seconds
+after a restart don't see an empty tree. The time since the last successful parse cycle in tcLastUpdateLeaf keeps
+growing meanwhile, which tells the pollers that the data is stale. A file older than the historyRetention is removed
+instead of being served.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// cacheFileMode are the permissions of the file with the persisted data.
+const cacheFileMode = 0644
+
+// cachedData is the persisted form of a snmpData.
+type cachedData struct {
+	// OID is the OID of the data.
+	OID string `json:"oid"`
+
+	// Type is the object type of the data.
+	Type string `json:"type"`
+
+	// Value is the object value of the data, a number, a string or a time in RFC 3339 for the timeticks.
+	Value interface{} `json:"value"`
+}
+
+// saveCache persists the data of the snapshot to the file.
+func saveCache(path string, sn *snapshot) error {
+	cached := make([]cachedData, 0, len(sn.data))
+	for _, data := range sn.data {
+		cached = append(cached, cachedData{data.oid, data.objectType, data.objectValue})
+	}
+	content, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, cacheFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// expireFile removes the file if it wasn't updated since the time, e.g. so that the data of a tc_reader that was
+// stopped doesn't stay on the disk for good. Returns true if the file was removed.
+func expireFile(path string, before time.Time) (bool, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.ModTime().Before(before) {
+		return false, nil
+	}
+	return true, os.Remove(path)
+}
+
+// loadCache returns a snapshot of the data persisted in the file, nil if the file doesn't exist.
+func loadCache(path string) (*snapshot, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var cached []cachedData
+	if err := decoder.Decode(&cached); err != nil {
+		return nil, err
+	}
+
+	sn := &snapshot{data: make([]snmpData, 0, len(cached))}
+	for i, data := range cached {
+		value, err := cachedValue(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %s", data.OID, err)
+		}
+		// The OIDs were persisted in order, the snapshot is searched by binary search.
+		if i > 0 && !oidLess(cached[i-1].OID, data.OID) {
+			return nil, fmt.Errorf("the OID %s is out of order", data.OID)
+		}
+		sn.data = append(sn.data, snmpData{data.OID, data.Type, value})
+	}
+	return sn, nil
+}
+
+// cachedValue returns the object value of the persisted data in the type that printData expects for its object type.
+func cachedValue(data cachedData) (interface{}, error) {
+	switch data.Type {
+	case "string":
+		if value, ok := data.Value.(string); ok {
+			return value, nil
+		}
+	case "integer":
+		if number, ok := data.Value.(json.Number); ok {
+			value, err := number.Int64()
+			return int(value), err
+		}
+	case "counter64", "gauge":
+		if number, ok := data.Value.(json.Number); ok {
+			return number.Int64()
+		}
+	case "timeticks":
+		if value, ok := data.Value.(string); ok {
+			return time.Parse(time.RFC3339Nano, value)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type '%s'", data.Type)
+	}
+	return nil, fmt.Errorf("unexpected %s value %v", data.Type, data.Value)
+}
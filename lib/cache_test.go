@@ -0,0 +1,125 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCacheSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	sn, err := loadCache(path)
+	if err != nil || sn != nil {
+		t.Fatalf("loadCache of a missing file => got: %v, %v, want: nil, nil", sn, err)
+	}
+
+	want := &snapshot{data: []snmpData{
+		{".1.3.6.1.4.1.2021.255.2", "integer", 1},
+		{".1.3.6.1.4.1.2021.255.3.1", "string", "eth0:1:3"},
+		{".1.3.6.1.4.1.2021.255.4.1", "counter64", int64(9)},
+		{".1.3.6.1.4.1.2021.255.56.1", "gauge", int64(80)},
+		{".1.3.6.1.4.1.2021.255.71", "timeticks", time.Date(2013, 5, 2, 0, 0, 0, 0, time.UTC)},
+	}}
+	if err := saveCache(path, want); err != nil {
+		t.Fatalf("saveCache => unexpected error: %s", err)
+	}
+	got, err := loadCache(path)
+	if err != nil {
+		t.Fatalf("loadCache => unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadCache => got: %v, want: %v", got.data, want.data)
+	}
+
+	for _, content := range []string{
+		"garbage",
+		`[{"oid": ".1.3.6.1.4.1.2021.255.2", "type": "integer", "value": "one"}]`,
+		`[{"oid": ".1.3.6.1.4.1.2021.255.2", "type": "ipaddress", "value": "127.0.0.1"}]`,
+		`[{"oid": ".1.3.6.1.4.1.2021.255.3", "type": "integer", "value": 1}, {"oid": ".1.3.6.1.4.1.2021.255.2", "type": "integer", "value": 1}]`,
+	} {
+		if err := ioutil.WriteFile(path, []byte(content), cacheFileMode); err != nil {
+			t.Fatalf("WriteFile => unexpected error: %s", err)
+		}
+		if _, err := loadCache(path); err == nil {
+			t.Errorf("loadCache of %s => got no error, want an error", content)
+		}
+	}
+}
+
+func TestExpireFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	now := time.Now()
+	if expired, err := expireFile(path, now); expired || err != nil {
+		t.Errorf("expireFile of a missing file => got: %v, %v, want: false, nil", expired, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("[]"), cacheFileMode); err != nil {
+		t.Fatalf("WriteFile => unexpected error: %s", err)
+	}
+	if err := os.Chtimes(path, now.Add(-2*time.Hour), now.Add(-2*time.Hour)); err != nil {
+		t.Fatalf("Chtimes => unexpected error: %s", err)
+	}
+	if expired, err := expireFile(path, now.Add(-3*time.Hour)); expired || err != nil {
+		t.Errorf("expireFile of a recent file => got: %v, %v, want: false, nil", expired, err)
+	}
+	if expired, err := expireFile(path, now.Add(-time.Hour)); !expired || err != nil {
+		t.Errorf("expireFile of an old file => got: %v, %v, want: true, nil", expired, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Stat after expireFile => got: %v, want the file removed", err)
+	}
+}
+
+func TestSnmpServesCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	options := &SnmpOptions{CacheFile: path}
+	nameOID := ".1.3.6.1.4.1.2021.255.3.1"
+
+	s, err := NewSnmp(&fakeSyslog{}, WithSnmpOptions(options))
+	if err != nil {
+		t.Fatalf("NewSnmp => unexpected error: %s", err)
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:3", 9, 10, 11, 12, nil, 7, 0})
+	s.commit()
+
+	// The restarted snmp serves the persisted data until its first parse cycle.
+	s, err = NewSnmp(&fakeSyslog{}, WithSnmpOptions(options))
+	if err != nil {
+		t.Fatalf("NewSnmp => unexpected error: %s", err)
+	}
+	if _, found := s.snapshot().find(nameOID); !found {
+		t.Errorf("NewSnmp => the persisted OID %s isn't served", nameOID)
+	}
+	if _, found := s.snapshot().find(leafOID(tcLastUpdateLeaf)); !found {
+		t.Errorf("NewSnmp => the persisted time of the last update isn't served")
+	}
+
+	s.begin()
+	s.erase()
+	s.commit()
+	if _, found := s.snapshot().find(nameOID); found {
+		t.Errorf("commit => the persisted OID %s is still served after the first parse cycle", nameOID)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+check.go validates the configuration against the system it runs on, e.g. in a provisioning pipeline before the
+configuration is deployed. It finds the problems that reading the config file doesn't, like a TC command that isn't
+executable or interfaces that aren't present.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// reCheckTcName is regexp that matches the plausible names of the Qdiscs and Classes, e.g. "eth0:2:3".
+var reCheckTcName = regexp.MustCompile("^(?P<iface>[^:@]+):[0-9a-f]+:[0-9a-f]+(?:#[0-9]+)?$")
+
+// Check returns the problems of the configuration on this system, none if it can be used.
+func (c *config) Check() []string {
+	options := c.TcParserOptions()
+	if len(options.ExecWrapper) > 0 {
+		return c.check(newIpLinkReader(wrappedExecute(options, &systemCommand{}), options.ipCmdPath()))
+	}
+	return c.check(&sysfsIfaceReader{})
+}
+
+// check returns the problems of the configuration, the interfaces are read by the ifaceReader.
+func (c *config) check(ifaceReader ifaceReader) []string {
+	var problems []string
+	options := c.TcParserOptions()
+	if err := options.validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if options.Aggregator != nil {
+		if _, err := options.Aggregator.serverTLSConfig(); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid TLS of the aggregator: %s", err))
+		}
+	}
+	if c.BaseOID != "" && !validOID(c.BaseOID) {
+		problems = append(problems, fmt.Sprintf("the base OID %s isn't valid", c.BaseOID))
+	}
+	if c.LeaderDir != "" && (c.Instance == "" || c.CacheFile == "") {
+		problems = append(problems, "the leaderDir needs the instance and the cacheFile to register with the leader")
+	}
+
+	tc := options.tcCmdPath()
+	if info, err := os.Stat(tc); err != nil {
+		problems = append(problems, fmt.Sprintf("the TC command %s can't be found: %s", tc, err))
+	} else if info.IsDir() || info.Mode()&0111 == 0 {
+		problems = append(problems, fmt.Sprintf("the TC command %s isn't executable", tc))
+	}
+
+	var counterPaths []string
+	for _, command := range options.counterCommands() {
+		counterPaths = append(counterPaths, command.args[0])
+	}
+	if len(options.ConntrackUsers) > 0 {
+		counterPaths = append(counterPaths, options.conntrackCmdPath())
+	}
+	checked := make(map[string]bool)
+	for _, path := range counterPaths {
+		if checked[path] {
+			continue
+		}
+		checked[path] = true
+		if info, err := os.Stat(path); err != nil {
+			problems = append(problems, fmt.Sprintf("the counter command %s can't be found: %s", path, err))
+		} else if info.IsDir() || info.Mode()&0111 == 0 {
+			problems = append(problems, fmt.Sprintf("the counter command %s isn't executable", path))
+		}
+	}
+
+	if len(options.ExecWrapper) > 0 {
+		if _, err := exec.LookPath(options.ExecWrapper[0]); err != nil {
+			problems = append(problems, fmt.Sprintf("the exec wrapper %s can't be found: %s", options.ExecWrapper[0], err))
+		}
+	}
+
+	present, err := ifaceReader.list()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("unable to list the interfaces: %s", err))
+	}
+	configured := options.ifaces()
+	auto := len(configured) == 1 && configured[0] == autoIfaces
+	if !auto {
+		for _, iface := range configured {
+			if !matchesIface(iface, present) {
+				problems = append(problems, fmt.Sprintf("the interface %s isn't present", iface))
+			}
+		}
+	}
+
+	var tcNames []string
+	for tcName := range options.userNameClass() {
+		tcNames = append(tcNames, tcName)
+	}
+	sort.Strings(tcNames)
+	for _, tcName := range tcNames {
+		user := options.userNameClass()[tcName]
+		if strings.HasPrefix(tcName, "@") {
+			continue
+		}
+		match := reCheckTcName.FindStringSubmatch(tcName)
+		if match == nil {
+			problems = append(problems, fmt.Sprintf("the user %s references %s, which isn't the name of a Qdisc or Class like eth0:2:3", user.Name, tcName))
+			continue
+		}
+		if !auto && !matchesIface(match[1], configured) {
+			problems = append(problems, fmt.Sprintf("the user %s references %s on the interface %s, which isn't monitored", user.Name, tcName, match[1]))
+		}
+	}
+
+	if c.RunAs != "" {
+		if _, _, err := lookupAccount(c.RunAs, c.RunAsGroup); err != nil {
+			problems = append(problems, fmt.Sprintf("tc_reader can't switch to the runAs account: %s", err))
+		}
+	}
+
+	hidden := hiddenLeaves(c.ExportMetrics)
+	for _, rule := range c.Alerts {
+		if hidden[namedMetrics[rule.metric].leaf] {
+			problems = append(problems, fmt.Sprintf("the alert on %s never fires, the metric isn't in exportMetrics", rule.metric))
+		}
+	}
+	return problems
+}
+
+// matchesIface returns true if the interface, or one of the interfaces matching the pattern, is in the list. Patterns
+// in the list match the interface too.
+func matchesIface(iface string, ifaces []string) bool {
+	for _, candidate := range ifaces {
+		if candidate == iface {
+			return true
+		}
+		if match, _ := filepath.Match(iface, candidate); match {
+			return true
+		}
+		if match, _ := filepath.Match(candidate, iface); match {
+			return true
+		}
+	}
+	return false
+}
+
+// Dump returns the options set by the config files, the environment and the flags, one "option = value" line for
+// each of them. The options that aren't listed have their defaults.
+func (c *config) Dump() string {
+	var buf strings.Builder
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() || value.Field(i).IsZero() {
+			continue
+		}
+		option := value.Field(i)
+		if option.Kind() == reflect.Pointer {
+			option = option.Elem()
+		}
+		fmt.Fprintf(&buf, "%s = %v\n", strings.ToLower(field.Name[:1])+field.Name[1:], option.Interface())
+	}
+	return buf.String()
+}
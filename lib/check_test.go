@@ -0,0 +1,143 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigCheck(t *testing.T) {
+	dir := t.TempDir()
+	tc := filepath.Join(dir, "tc")
+	if err := os.WriteFile(tc, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("WriteFile => unexpected error: %s", err)
+	}
+	notExecutable := filepath.Join(dir, "tc.txt")
+	if err := os.WriteFile(notExecutable, nil, 0644); err != nil {
+		t.Fatalf("WriteFile => unexpected error: %s", err)
+	}
+
+	testData := []struct {
+		desc    string
+		content string
+		want    []string
+	}{
+		{
+			desc:    "valid configuration",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0 ppp*\"\nuser = \"user1\" \"eth0:2:3\" \"ppp0:1:10\"\nuser = \"user2\" \"@10.0.0.5:1:10\" \"eth0:2:4\"",
+		},
+		{
+			desc:    "automatic interfaces",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"auto\"\nuser = \"user1\" \"eth9:2:3\" \"eth9:2:4\"",
+		},
+		{
+			desc:    "missing TC command",
+			content: "tcCmdPath = \"" + filepath.Join(dir, "missing") + "\"\nifaces = \"eth0\"",
+			want:    []string{"the TC command " + filepath.Join(dir, "missing") + " can't be found"},
+		},
+		{
+			desc:    "TC command isn't executable",
+			content: "tcCmdPath = \"" + notExecutable + "\"\nifaces = \"eth0\"",
+			want:    []string{"the TC command " + notExecutable + " isn't executable"},
+		},
+		{
+			desc:    "missing interfaces",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0 eth1 wlan*\"",
+			want:    []string{"the interface eth1 isn't present", "the interface wlan* isn't present"},
+		},
+		{
+			desc:    "implausible user classes",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0\"\nuser = \"user1\" \"eth0-2-3\" \"ppp0:1:10\"",
+			want: []string{
+				"the user user1 references eth0-2-3, which isn't the name of a Qdisc or Class like eth0:2:3",
+				"the user user1 references ppp0:1:10 on the interface ppp0, which isn't monitored",
+			},
+		},
+		{
+			desc:    "invalid base OID",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0\"\nbaseOID = \".1.3.99999999999\"",
+			want:    []string{"the base OID .1.3.99999999999 isn't valid"},
+		},
+		{
+			desc:    "leaderDir without the cacheFile",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0\"\ninstance = \"tenant1\"\nleaderDir = \"/run/tc_reader/instances\"",
+			want:    []string{"the leaderDir needs the instance and the cacheFile to register with the leader"},
+		},
+		{
+			desc:    "alert on a metric that isn't exported",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0\"\nexportMetrics = \"sentBytes\"\nalert = \"droppedPkt\" \"*\" > 1 \"syslog\"",
+			want:    []string{"the alert on droppedPkt never fires, the metric isn't in exportMetrics"},
+		},
+		{
+			desc:    "pseudonyms without a key",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0\"\nuserNames = pseudonym",
+			want:    []string{"the pseudonyms of the user names need the userNameKey"},
+		},
+		{
+			desc:    "missing exec wrapper",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0\"\nexecWrapper = \"no-such-tc-reader-wrapper -n\"",
+			want:    []string{"the exec wrapper no-such-tc-reader-wrapper can't be found"},
+		},
+		{
+			desc:    "missing counter commands",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0\"\nnftCmdPath = \"" + filepath.Join(dir, "nft") + "\"\niptablesCmdPath = \"" + notExecutable + "\"\nuserCounter = \"user1\" \"nft:inet:filter:up,ipt:mangle:ACCT:up\" \"ipt:mangle:OUT:down\"",
+			want:    []string{"the counter command " + filepath.Join(dir, "nft") + " can't be found", "the counter command " + notExecutable + " isn't executable"},
+		},
+		{
+			desc:    "missing conntrack command",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0\"\nconntrackCmdPath = \"" + filepath.Join(dir, "conntrack") + "\"\nuserConntrack = \"user1\" \"10.0.0.0/24\"",
+			want:    []string{"the counter command " + filepath.Join(dir, "conntrack") + " can't be found"},
+		},
+		{
+			desc:    "unknown runAs user",
+			content: "tcCmdPath = \"" + tc + "\"\nifaces = \"eth0\"\nrunAs = \"no-such-tc-reader-user\"",
+			want:    []string{"tc_reader can't switch to the runAs account: unknown user no-such-tc-reader-user"},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := newConfig("test")
+			if err := c.parseConfig(tc.content); err != nil {
+				t.Fatalf("parseConfig => unexpected error: %s", err)
+			}
+			got := c.check(&fakeIfaceReader{ifaces: []string{"eth0", "ppp0"}})
+			if len(got) != len(tc.want) {
+				t.Fatalf("check => got: %q, want: %q", got, tc.want)
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tc.want[i]) {
+					t.Errorf("check => got: %q, want prefix: %q", got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConfigDump(t *testing.T) {
+	c := newConfig("test")
+	if err := c.parseConfig("ifaces = \"eth0 eth1\"\nparseInterval = 10\nexportUsers = false\ndebug = true"); err != nil {
+		t.Fatalf("parseConfig => unexpected error: %s", err)
+	}
+	want := "parseInterval = 10s\nifaces = [eth0 eth1]\nexportUsers = false\ndebug = true\n"
+	if got := c.Dump(); got != want {
+		t.Errorf("Dump => got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+collector.go is the public API for embedding the parsing of the TC statistics into other Go programs, e.g.:
+
+	collector, err := lib.NewCollector(&lib.TcParserOptions{Ifaces: []string{"eth0"}}, logger, sink)
+	if err != nil {
+		...
+	}
+	for range time.Tick(5 * time.Second) {
+		collector.Collect()
+	}
+
+The Sink receives the counters of the Qdiscs / Classes, users and interface groups of every parse cycle as ParsedData.
+*/
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Logger receives the log messages, e.g. *syslog.Writer or the Logger created by NewLogger. The warnings and the debug
+// messages are logged by the Warning and Debug methods if the Logger has them, by Info otherwise.
+type Logger interface {
+	// Info logs an informational message.
+	Info(m string) error
+
+	// Err logs an error.
+	Err(m string) error
+}
+
+// ParsedData are the counters of a Qdisc / Class, user or interface group in a parse cycle.
+type ParsedData struct {
+	// Name is the tcName of the Qdisc / Class, e.g. "eth0:2:3", or the name of the user or group.
+	Name string
+
+	// SentBytes is the number of sent bytes.
+	SentBytes int64
+
+	// SentPkt is the number of sent packets.
+	SentPkt int64
+
+	// DroppedPkt is the number of dropped packets.
+	DroppedPkt int64
+
+	// OverLimitPkt is the number of over limit packets.
+	OverLimitPkt int64
+
+	// User is set if the counters are summed for a user in one direction, nil otherwise.
+	User *UserClass
+
+	// Group indicates that the counters are summed for an interface group.
+	Group bool
+
+	// IfIndex is the kernel ifIndex of the interface of a Qdisc / Class, zero if it couldn't be resolved.
+	IfIndex int
+
+	// Monotonic is the monotonic timestamp of the parse cycle, the same for all the data of the cycle. It is the time
+	// since the boot including the suspended time on Linux, it isn't stepped by NTP, so the rates computed from the
+	// differences of the timestamps stay correct.
+	Monotonic time.Duration
+}
+
+// Sink receives the parsed data of the parse cycles from a Collector.
+type Sink interface {
+	// Begin starts a parse cycle.
+	Begin()
+
+	// Add adds the counters of a Qdisc / Class, user or group in the current parse cycle.
+	Add(data ParsedData)
+
+	// Commit ends the parse cycle, all its data were added.
+	Commit()
+}
+
+// Collector runs the TC commands and passes the parsed data to the Sinks.
+type Collector struct {
+	// parser parses the output of the TC commands.
+	parser *tcParser
+}
+
+// NewCollector creates a Collector, returns an error if the options are invalid. Nil options use the defaults.
+// The parse cycles are run by Collect.
+func NewCollector(options *TcParserOptions, logger Logger, sinks ...Sink) (*Collector, error) {
+	if options == nil {
+		options = &TcParserOptions{}
+	}
+	if logger == nil {
+		return nil, errors.New("a Logger is required")
+	}
+	if len(sinks) == 0 {
+		return nil, errors.New("at least one Sink is required")
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	var dataSinks []dataSink
+	for _, sink := range sinks {
+		dataSinks = append(dataSinks, &sinkAdapter{sink: sink})
+	}
+	return &Collector{
+		parser: newTcParser(options, logger, dataSinks...),
+	}, nil
+}
+
+// Collect runs a parse cycle now and passes the parsed data to the Sinks. The Sinks don't receive anything during
+// a blackout window or while backing off after TC failures.
+func (c *Collector) Collect() {
+	c.parser.parseTc()
+}
+
+// validate returns an error if the options are invalid.
+func (o *TcParserOptions) validate() error {
+	if o.ParseInterval < 0 {
+		return fmt.Errorf("the parse interval must not be negative, got %s", o.ParseInterval)
+	}
+	if o.ParseInterval > 0 && o.ParseInterval < minParseInterval {
+		return fmt.Errorf("the parse interval must be at least %s, got %s", minParseInterval, o.ParseInterval)
+	}
+	if o.ParseJitter < 0 || o.ParseJitter >= o.parseInterval() {
+		return fmt.Errorf("the parse jitter must be shorter than the parse interval of %s, got %s", o.parseInterval(), o.ParseJitter)
+	}
+	if o.DiscoveryInterval < 0 {
+		return fmt.Errorf("the discovery interval must not be negative, got %d", o.DiscoveryInterval)
+	}
+	for _, arg := range o.ExecWrapper {
+		if arg == "" {
+			return errors.New("the arguments of the exec wrapper must not be empty")
+		}
+	}
+	for _, iface := range o.Ifaces {
+		if iface == "" {
+			return errors.New("the interface names must not be empty")
+		}
+	}
+	for tcName, user := range o.UserNameClass {
+		if user.Name == "" {
+			return fmt.Errorf("the user of %s has no name", tcName)
+		}
+		if user.Direction != UploadDirection && user.Direction != DownloadDirection {
+			return fmt.Errorf("the user %s of %s has an unknown direction %d", user.Name, tcName, user.Direction)
+		}
+	}
+	for group, members := range o.Groups {
+		if len(members) == 0 {
+			return fmt.Errorf("the group %s has no interfaces", group)
+		}
+	}
+	patterns := []struct {
+		pattern string
+		groups  []string
+	}{
+		{o.QdiscPattern, qdiscHeaderGroups},
+		{o.ClassPattern, classHeaderGroups},
+		{o.StatsPattern, statsGroups},
+	}
+	for _, p := range patterns {
+		if p.pattern == emptyString {
+			continue
+		}
+		if err := validPattern(p.pattern, p.groups); err != nil {
+			return fmt.Errorf("invalid pattern of the TC output: %s", err)
+		}
+	}
+	if o.RecordDir != emptyString && o.ReplayDir != emptyString {
+		return errors.New("the replayed TC outputs can't be recorded again, set either the record or the replay directory")
+	}
+	if o.Agent != nil {
+		if err := o.Agent.validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := newPseudonymizer(o.UserNames, o.UserNameKey); err != nil {
+		return err
+	}
+	switch o.IfaceTotals {
+	case emptyString, ifaceTotalsRoot, ifaceTotalsLeaves:
+	default:
+		return fmt.Errorf("the interface totals must sum the %s Qdiscs or the %s, got '%s'", ifaceTotalsRoot, ifaceTotalsLeaves, o.IfaceTotals)
+	}
+	return nil
+}
+
+// export returns a copy of the parsedData that can be retained.
+func (p *parsedData) export() ParsedData {
+	data := ParsedData{
+		Name:         p.name,
+		SentBytes:    p.sentBytes,
+		SentPkt:      p.sentPkt,
+		DroppedPkt:   p.droppedPkt,
+		OverLimitPkt: p.overLimitPkt,
+		IfIndex:      p.ifIndex,
+		Monotonic:    p.monotonic,
+	}
+	if p.userClass != nil {
+		user := *p.userClass
+		data.User = &user
+	}
+	return data
+}
+
+// sinkAdapter implements dataSink, it passes the parse cycles with fresh data to a Sink.
+type sinkAdapter struct {
+	// sink receives the data.
+	sink Sink
+
+	// fresh indicates that the current parse cycle erased the data, i.e. that the Sink began a parse cycle.
+	fresh bool
+}
+
+// begin starts an update, it doesn't necessarily bring fresh data.
+func (a *sinkAdapter) begin() {
+	a.fresh = false
+}
+
+// commit commits the parse cycle of the Sink if it was begun.
+func (a *sinkAdapter) commit() {
+	if a.fresh {
+		a.sink.Commit()
+	}
+}
+
+// erase begins a parse cycle of the Sink.
+func (a *sinkAdapter) erase() {
+	a.fresh = true
+	a.sink.Begin()
+}
+
+// addData passes the data of a Qdisc / Class or user to the Sink.
+func (a *sinkAdapter) addData(data *parsedData) {
+	a.sink.Add(data.export())
+}
+
+// addGroupData passes the data of an interface group to the Sink.
+func (a *sinkAdapter) addGroupData(data *parsedData) {
+	exported := data.export()
+	exported.Group = true
+	a.sink.Add(exported)
+}
+
+// addIfaceData ignores the interface totals, the Sink can sum the Qdiscs / Classes it receives.
+func (a *sinkAdapter) addIfaceData(data *parsedData) {}
+
+// addXstats ignores the xstats, they aren't part of the ParsedData.
+func (a *sinkAdapter) addXstats(name string, xstats []xstat) {}
+
+// addNetDevData ignores the kernel counters, they aren't part of the ParsedData.
+func (a *sinkAdapter) addNetDevData(stats *netDevStats) {}
+
+// addConntrackData ignores the conntrack counters, they aren't part of the ParsedData.
+func (a *sinkAdapter) addConntrackData(data *parsedData) {}
+
+// addWarning ignores the warning, it isn't part of the ParsedData.
+func (a *sinkAdapter) addWarning(message string) {}
+
+// setMaintenance ignores the maintenance, the Sink doesn't receive any parse cycles meanwhile.
+func (a *sinkAdapter) setMaintenance(active bool) {}
+
+// setHealth ignores the health, the Sink doesn't receive any parse cycles while backing off.
+func (a *sinkAdapter) setHealth(failures int, backoff int) {}
+
+// setUnmatchedLines ignores the unmatched lines, they aren't part of the ParsedData.
+func (a *sinkAdapter) setUnmatchedLines(lines int) {}
@@ -0,0 +1,222 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingSink implements Sink and is used in tests.
+type recordingSink struct {
+	// calls are the calls of Begin and Commit and the names of the added data in order.
+	calls []string
+
+	// data are the added data.
+	data []ParsedData
+}
+
+func (r *recordingSink) Begin() {
+	r.calls = append(r.calls, "Begin")
+}
+
+func (r *recordingSink) Add(data ParsedData) {
+	r.calls = append(r.calls, "Add "+data.Name)
+	r.data = append(r.data, data)
+}
+
+func (r *recordingSink) Commit() {
+	r.calls = append(r.calls, "Commit")
+}
+
+func TestNewCollector(t *testing.T) {
+	testData := []struct {
+		desc    string
+		options *TcParserOptions
+		logger  Logger
+		sinks   []Sink
+		wantErr string
+	}{
+		{
+			desc:   "default options",
+			logger: &fakeSyslog{},
+			sinks:  []Sink{&recordingSink{}},
+		},
+		{
+			desc: "valid options",
+			options: &TcParserOptions{
+				Ifaces:        []string{"eth0", "eth1"},
+				UserNameClass: map[string]UserClass{"eth0:1:1": {DownloadDirection, "john"}},
+				Groups:        map[string][]string{"all": {"eth0", "eth1"}},
+			},
+			logger: &fakeSyslog{},
+			sinks:  []Sink{&recordingSink{}, &recordingSink{}},
+		},
+		{
+			desc:    "no logger",
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "a Logger is required",
+		},
+		{
+			desc:    "no sinks",
+			logger:  &fakeSyslog{},
+			wantErr: "at least one Sink is required",
+		},
+		{
+			desc:    "negative parse interval",
+			options: &TcParserOptions{ParseInterval: -time.Second},
+			logger:  &fakeSyslog{},
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "the parse interval must not be negative, got -1s",
+		},
+		{
+			desc:    "jitter longer than the parse interval",
+			options: &TcParserOptions{ParseInterval: time.Second, ParseJitter: 2 * time.Second},
+			logger:  &fakeSyslog{},
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "the parse jitter must be shorter than the parse interval of 1s, got 2s",
+		},
+		{
+			desc:    "empty interface name",
+			options: &TcParserOptions{Ifaces: []string{"eth0", ""}},
+			logger:  &fakeSyslog{},
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "the interface names must not be empty",
+		},
+		{
+			desc:    "user without a name",
+			options: &TcParserOptions{UserNameClass: map[string]UserClass{"eth0:1:1": {DownloadDirection, ""}}},
+			logger:  &fakeSyslog{},
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "the user of eth0:1:1 has no name",
+		},
+		{
+			desc:    "unknown direction",
+			options: &TcParserOptions{UserNameClass: map[string]UserClass{"eth0:1:1": {2, "john"}}},
+			logger:  &fakeSyslog{},
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "the user john of eth0:1:1 has an unknown direction 2",
+		},
+		{
+			desc:    "empty group",
+			options: &TcParserOptions{Groups: map[string][]string{"all": {}}},
+			logger:  &fakeSyslog{},
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "the group all has no interfaces",
+		},
+		{
+			desc:    "unknown interface totals",
+			options: &TcParserOptions{IfaceTotals: "all"},
+			logger:  &fakeSyslog{},
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "the interface totals must sum the root Qdiscs or the leaves, got 'all'",
+		},
+		{
+			desc:    "recording the replay",
+			options: &TcParserOptions{RecordDir: "/tmp/new", ReplayDir: "/tmp/old"},
+			logger:  &fakeSyslog{},
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "the replayed TC outputs can't be recorded again, set either the record or the replay directory",
+		},
+		{
+			desc:    "pattern without the named groups",
+			options: &TcParserOptions{StatsPattern: "Sent ([0-9]+) bytes"},
+			logger:  &fakeSyslog{},
+			sinks:   []Sink{&recordingSink{}},
+			wantErr: "invalid pattern of the TC output: the pattern 'Sent ([0-9]+) bytes' has no group named sentBytes",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			c, err := NewCollector(tc.options, tc.logger, tc.sinks...)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("NewCollector => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewCollector => unexpected error: %s", err)
+			}
+			if c == nil {
+				t.Errorf("NewCollector => got nil Collector")
+			}
+		})
+	}
+}
+
+func TestCollectorCollect(t *testing.T) {
+	qdiscOutput := `qdisc htb 1: root refcnt 2 r2q 10 default 0 direct_packets_stat 0
+ Sent 1500 bytes 10 pkt (dropped 1, overlimits 2 requeues 0)
+`
+	classOutput := `class htb 1:1 root prio 0 rate 1Mbit ceil 1Mbit burst 1600b cburst 1600b
+ Sent 1000 bytes 8 pkt (dropped 0, overlimits 0 requeues 0)
+`
+	sink := &recordingSink{}
+	c, err := NewCollector(&TcParserOptions{
+		Ifaces:        []string{"eth0"},
+		MaxCommands:   1,
+		UserNameClass: map[string]UserClass{"eth0:1:1": {DownloadDirection, "john"}},
+		Groups:        map[string][]string{"all": {"eth0"}},
+	}, &fakeSyslog{}, sink)
+	if err != nil {
+		t.Fatalf("NewCollector => unexpected error: %s", err)
+	}
+	c.parser.executer = &fakeExecuter{
+		output: []string{qdiscOutput, classOutput, "", ""},
+		err:    []error{nil, nil, errors.New("tc failed"), errors.New("tc failed")},
+	}
+	c.parser.ifaceReader = &fakeIfaceReader{index: 2}
+
+	c.Collect()
+	wantCalls := []string{"Begin", "Add eth0:1:0", "Add eth0:1:1", "Add all", "Add john", "Commit"}
+	if !reflect.DeepEqual(sink.calls, wantCalls) {
+		t.Errorf("Collect => got calls: %v, want: %v", sink.calls, wantCalls)
+	}
+	wantData := []ParsedData{
+		{Name: "eth0:1:0", SentBytes: 1500, SentPkt: 10, DroppedPkt: 1, OverLimitPkt: 2, IfIndex: 2},
+		{Name: "eth0:1:1", SentBytes: 1000, SentPkt: 8, IfIndex: 2},
+		{Name: "all", SentBytes: 1500, SentPkt: 10, DroppedPkt: 1, OverLimitPkt: 2, Group: true},
+		{Name: "john", SentBytes: 1000, SentPkt: 8, User: &UserClass{DownloadDirection, "john"}},
+	}
+	// All the data of the parse cycle have its monotonic timestamp.
+	monotonic := sink.data[0].Monotonic
+	if monotonic <= 0 {
+		t.Errorf("Collect => got the monotonic timestamp %s, want a positive one", monotonic)
+	}
+	for i := range sink.data {
+		if sink.data[i].Monotonic != monotonic {
+			t.Errorf("Collect => got the monotonic timestamp %s for %s, want %s as for the whole parse cycle", sink.data[i].Monotonic, sink.data[i].Name, monotonic)
+		}
+		sink.data[i].Monotonic = 0
+	}
+	if !reflect.DeepEqual(sink.data, wantData) {
+		t.Errorf("Collect => got data: %+v, want: %+v", sink.data, wantData)
+	}
+
+	// The failed parse cycles are committed without data, the cycle after the second failure is skipped.
+	sink.calls = nil
+	c.Collect()
+	c.Collect()
+	c.Collect()
+	if wantCalls := []string{"Begin", "Commit", "Begin", "Commit"}; !reflect.DeepEqual(sink.calls, wantCalls) {
+		t.Errorf("Collect => got calls: %v, want: %v", sink.calls, wantCalls)
+	}
+}
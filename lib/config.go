@@ -15,16 +15,44 @@ limitations under the License.
 
 
 config.go reads the config file.
+
+The config file can be split into fragments, e.g. dropped in by packaging or provisioning tools. The fragments are the
+files matching "*.conf" or "*.toml" in the directory named after the config file with a ".d" suffix, e.g.
+/etc/tc_reader.conf.d/10-users.conf. They are read in lexical order after the config file, which may be missing. The
+path can also be the directory itself. An option can only be set once across all the files, the repeated options like
+user or alert are collected from all of them.
 */
 
 package lib
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	// configDirSuffix is appended to the path of the config file to get the directory of its fragments.
+	configDirSuffix = ".d"
+
+	// configFragmentPattern matches the names of the fragments in the directory.
+	configFragmentPattern = "*.conf"
+
+	// configTomlFragmentPattern matches the names of the fragments in TOML in the directory.
+	configTomlFragmentPattern = "*" + tomlExtension
 )
 
 const (
@@ -37,8 +65,29 @@ const (
 	// reTcCmdPath is regexp that matches line that defines tcCmdPath.
 	reTcCmdPath = "^tcCmdPath = \"(?P<tcCmdPath>.*)\"$"
 
+	// reIpCmdPath is regexp that matches line that defines ipCmdPath.
+	reIpCmdPath = "^ipCmdPath = \"(?P<ipCmdPath>.*)\"$"
+
+	// reNftCmdPath is regexp that matches line that defines nftCmdPath.
+	reNftCmdPath = "^nftCmdPath = \"(?P<nftCmdPath>.*)\"$"
+
+	// reIptablesCmdPath is regexp that matches line that defines iptablesCmdPath.
+	reIptablesCmdPath = "^iptablesCmdPath = \"(?P<iptablesCmdPath>.*)\"$"
+
+	// reConntrackCmdPath is regexp that matches line that defines conntrackCmdPath.
+	reConntrackCmdPath = "^conntrackCmdPath = \"(?P<conntrackCmdPath>.*)\"$"
+
+	// reExecWrapper is regexp that matches line that defines execWrapper.
+	reExecWrapper = "^execWrapper = \"(?P<execWrapper>.*)\"$"
+
 	// reParseInterval is regexp that matches line that defines parseInterval.
-	reParseInterval = "^parseInterval = (?P<parseInterval>[0-9]+)$"
+	reParseInterval = "^parseInterval = (?:(?P<parseInterval>[0-9]+)|\"(?P<parseDuration>(?:[0-9.]+[a-zµ]+)+)\")$"
+
+	// reAlignCycles is regexp that matches line that defines alignCycles.
+	reAlignCycles = "^alignCycles = (?P<alignCycles>true|false)$"
+
+	// reParseJitter is regexp that matches line that defines parseJitter.
+	reParseJitter = "^parseJitter = (?:(?P<parseJitter>[0-9]+)|\"(?P<jitterDuration>(?:[0-9.]+[a-zµ]+)+)\")$"
 
 	// reTcQdiscStats is regexp that matches line that defines tcQdiscStats.
 	reTcQdiscStats = "^tcQdiscStats = \"(?P<tcQdiscStats>.*)\"$"
@@ -46,12 +95,315 @@ const (
 	// reTcClassStats is regexp that matches line that defines tcClassStats.
 	reTcClassStats = "^tcClassStats = \"(?P<tcClassStats>.*)\"$"
 
+	// reTcFilterShow is regexp that matches line that defines tcFilterShow.
+	reTcFilterShow = "^tcFilterShow = \"(?P<tcFilterShow>.*)\"$"
+
+	// reQdiscPattern is regexp that matches line that defines qdiscPattern.
+	reQdiscPattern = "^qdiscPattern = \"(?P<qdiscPattern>.+)\"$"
+
+	// reClassPattern is regexp that matches line that defines classPattern.
+	reClassPattern = "^classPattern = \"(?P<classPattern>.+)\"$"
+
+	// reStatsPattern is regexp that matches line that defines statsPattern.
+	reStatsPattern = "^statsPattern = \"(?P<statsPattern>.+)\"$"
+
 	// reIfaces is regexp that matches line that defines ifaces.
 	reIfaces = "^ifaces = \"(?P<ifaces>.*)\"$"
 
+	// reDiscoveryInterval is regexp that matches line that defines discoveryInterval.
+	reDiscoveryInterval = "^discoveryInterval = (?P<discoveryInterval>[0-9]+)$"
+
+	// reMaxParallel is regexp that matches line that defines maxParallel.
+	reMaxParallel = "^maxParallel = (?P<maxParallel>[0-9]+)$"
+
+	// reMaxCommands is regexp that matches line that defines maxCommands.
+	reMaxCommands = "^maxCommands = (?P<maxCommands>[0-9]+)$"
+
+	// reCommandTimeout is regexp that matches line that defines commandTimeout.
+	reCommandTimeout = "^commandTimeout = (?P<commandTimeout>[0-9]+)$"
+
+	// reSingleInvocation is regexp that matches line that defines singleInvocation.
+	reSingleInvocation = "^singleInvocation = (?P<singleInvocation>true|false)$"
+
+	// reIdlePause is regexp that matches line that defines idlePause.
+	reIdlePause = "^idlePause = (?P<idlePause>[0-9]+)$"
+
+	// reMaxBackoff is regexp that matches line that defines maxBackoff.
+	reMaxBackoff = "^maxBackoff = (?P<maxBackoff>[0-9]+)$"
+
+	// reMaxDataAge is regexp that matches line that defines maxDataAge.
+	reMaxDataAge = "^maxDataAge = (?P<maxDataAge>[0-9]+)$"
+
+	// reRetention is regexp that matches line that defines retention.
+	reRetention = "^retention = (?P<retention>[0-9]+)$"
+
+	// reExportGeneric is regexp that matches line that defines exportGeneric.
+	reExportGeneric = "^exportGeneric = (?P<exportGeneric>true|false)$"
+
+	// reExportUsers is regexp that matches line that defines exportUsers.
+	reExportUsers = "^exportUsers = (?P<exportUsers>true|false)$"
+
+	// reExportMetrics is regexp that matches line that defines exportMetrics.
+	reExportMetrics = "^exportMetrics = \"(?P<exportMetrics>[a-zA-Z0-9]+(?: [a-zA-Z0-9]+)*)\"$"
+
+	// reExportDeltas is regexp that matches line that defines exportDeltas.
+	reExportDeltas = "^exportDeltas = (?P<exportDeltas>true|false)$"
+
+	// reRateSamples is regexp that matches line that defines rateSamples.
+	reRateSamples = "^rateSamples = (?P<rateSamples>[0-9]+)$"
+
+	// reCacheFile is regexp that matches line that defines cacheFile.
+	reCacheFile = "^cacheFile = \"(?P<cacheFile>.*)\"$"
+
+	// reQuotaFile is regexp that matches line that defines quotaFile.
+	reQuotaFile = "^quotaFile = \"(?P<quotaFile>.*)\"$"
+
+	// reQuota is regexp that matches line that defines the quota of an user.
+	reQuota = "^quota[\t ]+=[\t ]+\"(?P<userName>.+)\"[\t ]+\"(?P<limit>[0-9]+)(?P<unit>[KMGT]?)\"$"
+
+	// reQuotaResetDay is regexp that matches line that defines quotaResetDay.
+	reQuotaResetDay = "^quotaResetDay = (?P<quotaResetDay>[0-9]+)$"
+
+	// reTrapTarget is regexp that matches line that defines trapTarget.
+	reTrapTarget = "^trapTarget = \"(?P<trapTarget>.*)\"$"
+
+	// reTrapVersion is regexp that matches line that defines trapVersion.
+	reTrapVersion = "^trapVersion = (?P<trapVersion>2c|3)$"
+
+	// reTrapCommunity is regexp that matches line that defines trapCommunity.
+	reTrapCommunity = "^trapCommunity = \"(?P<trapCommunity>.*)\"$"
+
+	// reTrapUser is regexp that matches line that defines the SNMPv3 user of the notifications.
+	reTrapUser = "^trapUser = \"(?P<userName>[^\"]+)\"(?: \"(?P<authProtocol>MD5|SHA)\" \"(?P<authPassword>[^\"]+)\"(?: \"(?P<privProtocol>AES)\" \"(?P<privPassword>[^\"]+)\")?)?$"
+
+	// reTrapEngineID is regexp that matches line that defines trapEngineID.
+	reTrapEngineID = "^trapEngineID = \"(?:0x)?(?P<trapEngineID>(?:[0-9a-fA-F]{2}){5,32})\"$"
+
+	// reTrapContext is regexp that matches line that defines the SNMPv3 context of the notifications.
+	reTrapContext = "^trapContext = \"(?P<contextName>[^\"]+)\"(?: \"(?:0x)?(?P<contextEngineID>(?:[0-9a-fA-F]{2}){5,32})\")?$"
+
+	// reTrapDropRate is regexp that matches line that defines trapDropRate.
+	reTrapDropRate = "^trapDropRate = (?P<trapDropRate>[0-9]+)$"
+
+	// reGraphiteTarget is regexp that matches line that defines graphiteTarget.
+	reGraphiteTarget = "^graphiteTarget = \"(?P<graphiteTarget>.*)\"$"
+
+	// reGraphitePath is regexp that matches line that defines graphitePath.
+	reGraphitePath = "^graphitePath = \"(?P<graphitePath>[^\" ]+)\"$"
+
+	// reMqttBroker is regexp that matches line that defines mqttBroker.
+	reMqttBroker = "^mqttBroker = \"(?P<mqttBroker>.*)\"$"
+
+	// reMqttTopic is regexp that matches line that defines mqttTopic.
+	reMqttTopic = "^mqttTopic = \"(?P<mqttTopic>[^\" ]+)\"$"
+
+	// reMqttRetain is regexp that matches line that defines mqttRetain.
+	reMqttRetain = "^mqttRetain = (?P<mqttRetain>true|false)$"
+
+	// reMqttTLS is regexp that matches line that defines mqttTLS.
+	reMqttTLS = "^mqttTLS = (?P<mqttTLS>true|false)$"
+
+	// reMqttCAFile is regexp that matches line that defines mqttCAFile.
+	reMqttCAFile = "^mqttCAFile = \"(?P<mqttCAFile>.*)\"$"
+
+	// reMqttUser is regexp that matches line that defines the user and the password to authenticate to the MQTT broker.
+	reMqttUser = "^mqttUser = \"(?P<user>[^\"]+)\" \"(?P<password>[^\"]*)\"$"
+
+	// reMqttClientID is regexp that matches line that defines mqttClientID.
+	reMqttClientID = "^mqttClientID = \"(?P<mqttClientID>[^\"]+)\"$"
+
+	// reSampleFile is regexp that matches line that defines sampleFile.
+	reSampleFile = "^sampleFile = \"(?P<sampleFile>.+)\"$"
+
+	// reSampleFormat is regexp that matches line that defines sampleFormat.
+	reSampleFormat = "^sampleFormat = (?P<sampleFormat>csv|json)$"
+
+	// reSampleMaxSize is regexp that matches line that defines sampleMaxSize.
+	reSampleMaxSize = "^sampleMaxSize = (?P<size>[0-9]+)(?P<unit>[KMGT]?)$"
+
+	// reSampleFields is regexp that matches line that defines sampleFields.
+	reSampleFields = "^sampleFields = \"(?P<sampleFields>[a-z]+(?: [a-z]+)*)\"$"
+
+	// reRrdDir is regexp that matches line that defines rrdDir.
+	reRrdDir = "^rrdDir = \"(?P<rrdDir>.+)\"$"
+
+	// reRrdCmdPath is regexp that matches line that defines rrdCmdPath.
+	reRrdCmdPath = "^rrdCmdPath = \"(?P<rrdCmdPath>.+)\"$"
+
+	// reZabbixServer is regexp that matches line that defines zabbixServer.
+	reZabbixServer = "^zabbixServer = \"(?P<zabbixServer>.+)\"$"
+
+	// reZabbixHost is regexp that matches line that defines zabbixHost.
+	reZabbixHost = "^zabbixHost = \"(?P<zabbixHost>.+)\"$"
+
+	// reZabbixKey is regexp that matches line that defines zabbixKey.
+	reZabbixKey = "^zabbixKey = \"(?P<zabbixKey>[^\" ]+)\"$"
+
+	// reHttpListen is regexp that matches line that defines httpListen.
+	reHttpListen = "^httpListen = \"(?P<httpListen>.+)\"$"
+
+	// reGrpcListen is regexp that matches line that defines grpcListen.
+	reGrpcListen = "^grpcListen = \"(?P<grpcListen>.+)\"$"
+
+	// rePprofListen is regexp that matches line that defines pprofListen.
+	rePprofListen = "^pprofListen = \"(?P<pprofListen>.+)\"$"
+
+	// reRuntimeStats is regexp that matches line that defines runtimeStats.
+	reRuntimeStats = "^runtimeStats = (?P<runtimeStats>true|false)$"
+
+	// rePrometheusFile is regexp that matches line that defines prometheusFile.
+	rePrometheusFile = "^prometheusFile = \"(?P<prometheusFile>.+)\"$"
+
+	// reUserNames is regexp that matches line that defines userNames.
+	reUserNames = "^userNames = (?P<userNames>plain|hash|pseudonym)$"
+
+	// reUserNameKey is regexp that matches line that defines userNameKey.
+	reUserNameKey = "^userNameKey = \"(?P<userNameKey>.+)\"$"
+
+	// reHistoryRetention is regexp that matches line that defines historyRetention.
+	reHistoryRetention = "^historyRetention = (?:(?P<historyRetention>[0-9]+)|\"(?P<retentionDuration>(?:[0-9.]+[a-zµ]+)+)\")$"
+
+	// reAgentTarget is regexp that matches line that defines agentTarget.
+	reAgentTarget = "^agentTarget = \"(?P<agentTarget>https?://.+)\"$"
+
+	// reAgentHost is regexp that matches line that defines agentHost.
+	reAgentHost = "^agentHost = \"(?P<agentHost>[a-zA-Z0-9._-]+)\"$"
+
+	// reAgentName is regexp that matches line that defines agentName.
+	reAgentName = "^agentName = \"(?P<agentName>[a-zA-Z0-9._-]+)\"$"
+
+	// reAgentMaster is regexp that matches line that defines agentMaster.
+	reAgentMaster = "^agentMaster = (?P<agentMaster>true|false)$"
+
+	// reAgentToken is regexp that matches line that defines agentToken.
+	reAgentToken = "^agentToken = \"(?P<agentToken>.+)\"$"
+
+	// reAgentFullSync is regexp that matches line that defines agentFullSync.
+	reAgentFullSync = "^agentFullSync = (?:(?P<agentFullSync>[0-9]+)|\"(?P<fullSyncDuration>(?:[0-9.]+[a-zµ]+)+)\")$"
+
+	// reAgentEnrollToken is regexp that matches line that defines agentEnrollToken.
+	reAgentEnrollToken = "^agentEnrollToken = \"(?P<agentEnrollToken>.+)\"$"
+
+	// reAgentTokenFile is regexp that matches line that defines agentTokenFile.
+	reAgentTokenFile = "^agentTokenFile = \"(?P<agentTokenFile>.+)\"$"
+
+	// reAgentCAFile is regexp that matches line that defines agentCAFile.
+	reAgentCAFile = "^agentCAFile = \"(?P<agentCAFile>.+)\"$"
+
+	// reAgentCertFile is regexp that matches line that defines agentCertFile.
+	reAgentCertFile = "^agentCertFile = \"(?P<agentCertFile>.+)\"$"
+
+	// reAgentKeyFile is regexp that matches line that defines agentKeyFile.
+	reAgentKeyFile = "^agentKeyFile = \"(?P<agentKeyFile>.+)\"$"
+
+	// reAggregatorListen is regexp that matches line that defines aggregatorListen.
+	reAggregatorListen = "^aggregatorListen = \"(?P<aggregatorListen>.+)\"$"
+
+	// reAggregatorToken is regexp that matches line that defines aggregatorToken.
+	reAggregatorToken = "^aggregatorToken = \"(?P<aggregatorToken>.+)\"$"
+
+	// reAggregatorConflicts is regexp that matches line that defines aggregatorConflicts.
+	reAggregatorConflicts = "^aggregatorConflicts = (?P<aggregatorConflicts>prefer-master|merge|reject)$"
+
+	// reAggregatorEnrollToken is regexp that matches line that defines aggregatorEnrollToken.
+	reAggregatorEnrollToken = "^aggregatorEnrollToken = \"(?P<aggregatorEnrollToken>.+)\"$"
+
+	// reAggregatorTokenFile is regexp that matches line that defines aggregatorTokenFile.
+	reAggregatorTokenFile = "^aggregatorTokenFile = \"(?P<aggregatorTokenFile>.+)\"$"
+
+	// reAggregatorCertFile is regexp that matches line that defines aggregatorCertFile.
+	reAggregatorCertFile = "^aggregatorCertFile = \"(?P<aggregatorCertFile>.+)\"$"
+
+	// reAggregatorKeyFile is regexp that matches line that defines aggregatorKeyFile.
+	reAggregatorKeyFile = "^aggregatorKeyFile = \"(?P<aggregatorKeyFile>.+)\"$"
+
+	// reAggregatorClientCAFile is regexp that matches line that defines aggregatorClientCAFile.
+	reAggregatorClientCAFile = "^aggregatorClientCAFile = \"(?P<aggregatorClientCAFile>.+)\"$"
+
+	// reControlSocket is regexp that matches line that defines controlSocket.
+	reControlSocket = "^controlSocket = \"(?P<controlSocket>.+)\"$"
+
+	// reRunAs is regexp that matches line that defines the user and optionally the group tc_reader switches to.
+	reRunAs = "^runAs = \"(?P<user>[^\"]+)\"(?: \"(?P<group>[^\"]+)\")?$"
+
+	// reLogTarget is regexp that matches line that defines logTarget.
+	reLogTarget = "^logTarget = \"(?P<logTarget>syslog|stderr|journald|file:.+)\"$"
+
+	// reLogLevel is regexp that matches line that defines logLevel.
+	reLogLevel = "^logLevel = (?P<logLevel>error|warning|info|debug)$"
+
+	// reSyslogFacility is regexp that matches line that defines syslogFacility.
+	reSyslogFacility = "^syslogFacility = (?P<syslogFacility>kern|user|mail|daemon|auth|syslog|lpr|news|uucp|cron|authpriv|ftp|local[0-7])$"
+
+	// reSyslogTag is regexp that matches line that defines syslogTag.
+	reSyslogTag = "^syslogTag = \"(?P<syslogTag>[^\"]+)\"$"
+
+	// reBaseOID is regexp that matches line that defines baseOID.
+	reBaseOID = "^baseOID = \"(?P<baseOID>(?:\\.[0-9]+)+)\"$"
+
+	// reInclude is regexp that matches line that includes other config files.
+	reInclude = "^include = \"(?P<include>[^\"]+)\"$"
+
+	// reOption is regexp that matches line that looks like it defines an option, it is used to find unknown options.
+	reOption = "^(?P<option>[a-zA-Z][a-zA-Z0-9]*) = "
+
+	// reAlert is regexp that matches line that defines an alert rule.
+	reAlert = "^alert = \"(?P<metric>[A-Za-z]+)\" \"(?P<pattern>[^\"]+)\" (?:(?P<rate>rate) )?(?P<comparison>[<>=!]=?) (?P<threshold>-?[0-9]+(?:\\.[0-9]+)?)(?P<perSecond>/s)? \"(?P<action>[a-z]+)\"(?: \"(?P<command>[^\"]+)\")?$"
+
 	// reUserNameClass is regexp that matches line that defines user name.
 	reUserNameClass = "^user[\t ]+=[\t ]+\"(?P<userName>.*)\"[\t ]+\"(?P<uploadClass>.*)\"[\t ]+\"(?P<downloadClass>.*)\"$"
 
+	// reUserAddr is regexp that matches line that defines an user by IP addresses.
+	reUserAddr = "^userAddr[\t ]+=[\t ]+\"(?P<userName>.*)\"[\t ]+\"(?P<addrs>.*)\"$"
+
+	// reUserCounter is regexp that matches line that defines an user by netfilter counters.
+	reUserCounter = "^userCounter[\t ]+=[\t ]+\"(?P<userName>.*)\"[\t ]+\"(?P<uploadCounters>.*)\"[\t ]+\"(?P<downloadCounters>.*)\"$"
+
+	// reUserConntrack is regexp that matches line that defines an user by the IP address ranges counted by conntrack.
+	reUserConntrack = "^userConntrack[\t ]+=[\t ]+\"(?P<userName>.*)\"[\t ]+\"(?P<ranges>.*)\"$"
+
+	// classSeparator separates multiple Classes in one direction of an user definition.
+	classSeparator = ","
+
+	// reIfaceTotals is regexp that matches line that defines ifaceTotals.
+	reIfaceTotals = "^ifaceTotals = \"(?P<ifaceTotals>root|leaves)\"$"
+
+	// reXstats is regexp that matches line that defines xstats.
+	reXstats = "^xstats = (?P<xstats>true|false)$"
+
+	// reNetDev is regexp that matches line that defines netDev.
+	reNetDev = "^netDev = (?P<netDev>true|false)$"
+
+	// reLinkStats is regexp that matches line that defines linkStats.
+	reLinkStats = "^linkStats = (?P<linkStats>true|false)$"
+
+	// reBlackout is regexp that matches line that defines a blackout window.
+	reBlackout = "^blackout = \"(?P<blackout>.*)\"$"
+
+	// reGroup is regexp that matches line that defines an interface group.
+	reGroup = "^group[\t ]+=[\t ]+\"(?P<groupName>.+)\"[\t ]+\"(?P<members>.+)\"$"
+
+	// reMaxWarnings is regexp that matches line that defines maxWarnings.
+	reMaxWarnings = "^maxWarnings = (?P<maxWarnings>[0-9]+)$"
+
+	// rePktSizeBuckets is regexp that matches line that defines pktSizeBuckets.
+	rePktSizeBuckets = "^pktSizeBuckets = \"(?P<pktSizeBuckets>[0-9]+ [0-9]+)\"$"
+
+	// reIdentity is regexp that matches line that defines identity.
+	reIdentity = "^identity = \"(?P<identity>.*)\"$"
+
+	// reLabels is regexp that matches line that defines labels.
+	reLabels = "^labels = \"(?P<labels>.*)\"$"
+
+	// reInstance is regexp that matches line that defines instance.
+	reInstance = "^instance = \"(?P<instance>[a-zA-Z0-9._-]+)\"$"
+
+	// reLeaderDir is regexp that matches line that defines leaderDir.
+	reLeaderDir = "^leaderDir = \"(?P<leaderDir>.+)\"$"
+
+	// reEncodeIfaceNames is regexp that matches line that defines encodeIfaceNames.
+	reEncodeIfaceNames = "^encodeIfaceNames = (?P<encodeIfaceNames>true|false)$"
+
 	// reDebug is regexp that matches line that defines debug..
 	reDebug = "^debug = (?P<debug>true|false)$"
 
@@ -62,13 +414,38 @@ const (
 	falseString = "false"
 )
 
+// Config is the parsed configuration file, it lets the callers of NewConfig name the type, e.g. to override the
+// parsed values by the command line flags.
+type Config = config
+
 // config parses the configuration file and stores the parsed values.
 type config struct {
 	// TcCmdPath is the parsed tcCmdPath, defaults to empty string so that parser will use its internal default.
 	TcCmdPath string
 
+	// IpCmdPath is the parsed ipCmdPath, defaults to empty string so that parser will use its internal default.
+	IpCmdPath string
+
+	// NftCmdPath is the parsed nftCmdPath, defaults to empty string so that parser will use its internal default.
+	NftCmdPath string
+
+	// IptablesCmdPath is the parsed iptablesCmdPath, defaults to empty string so that parser will use its internal default.
+	IptablesCmdPath string
+
+	// ConntrackCmdPath is the parsed conntrackCmdPath, defaults to empty string so that parser will use its internal default.
+	ConntrackCmdPath string
+
+	// ExecWrapper is the parsed execWrapper, defaults to nil so that the commands are executed directly.
+	ExecWrapper []string
+
 	// ParseInterval is the parsed ParseInterval, defaults to zero so that parser will use its internal default.
-	ParseInterval int
+	ParseInterval time.Duration
+
+	// AlignCycles is the parsed alignCycles, defaults to false so that the parse cycles aren't aligned.
+	AlignCycles bool
+
+	// ParseJitter is the parsed parseJitter, defaults to zero so that the parse cycles aren't delayed.
+	ParseJitter time.Duration
 
 	// TcQdiscStats is the parsed TcQdiscStats, defaults to nil so that parser will use its internal default.
 	TcQdiscStats []string
@@ -76,11 +453,316 @@ type config struct {
 	// TcClassStats is the parsed TcClassStats, defaults to nil so that parser will use its internal default.
 	TcClassStats []string
 
+	// TcFilterShow is the parsed TcFilterShow, defaults to nil so that parser will use its internal default.
+	TcFilterShow []string
+
+	// QdiscPattern is the parsed qdiscPattern, defaults to empty so that parser will use its internal default.
+	QdiscPattern string
+
+	// ClassPattern is the parsed classPattern, defaults to empty so that parser will use its internal default.
+	ClassPattern string
+
+	// StatsPattern is the parsed statsPattern, defaults to empty so that parser will use its internal default.
+	StatsPattern string
+
 	// Ifaces is the parsed Ifaces, defaults to nil so that parser will use its internal default.
 	Ifaces []string
 
+	// DiscoveryInterval is the parsed discoveryInterval, defaults to zero so that parser will use its internal default.
+	DiscoveryInterval int
+
+	// MaxParallel is the parsed maxParallel, defaults to zero so that parser will use its internal default.
+	MaxParallel int
+
+	// MaxCommands is the parsed maxCommands, defaults to zero so that parser will use its internal default.
+	MaxCommands int
+
+	// CommandTimeout is the parsed commandTimeout, defaults to zero so that parser will use its internal default.
+	CommandTimeout int
+
+	// SingleInvocation is the parsed singleInvocation, defaults to false.
+	SingleInvocation bool
+
+	// IdlePause is the parsed idlePause, defaults to zero so that the collection isn't paused.
+	IdlePause int
+
+	// MaxBackoff is the parsed maxBackoff, defaults to zero so that parser will use its internal default.
+	MaxBackoff int
+
+	// MaxDataAge is the parsed maxDataAge, defaults to zero so that the data isn't refreshed on demand.
+	MaxDataAge int
+
+	// Retention is the parsed retention, defaults to zero so that the disappeared Qdiscs / Classes are dropped immediately.
+	Retention int
+
+	// ExportGeneric is the parsed exportGeneric, defaults to nil so that the Qdiscs / Classes are exported.
+	ExportGeneric *bool
+
+	// ExportUsers is the parsed exportUsers, defaults to nil so that the users are exported.
+	ExportUsers *bool
+
+	// ExportMetrics is the parsed exportMetrics, defaults to nil so that all the metrics are exported.
+	ExportMetrics []string
+
+	// ExportDeltas is the parsed exportDeltas, defaults to false.
+	ExportDeltas bool
+
+	// RateSamples is the parsed rateSamples, defaults to zero so that the moving average rates aren't exported.
+	RateSamples int
+
+	// CacheFile is the parsed cacheFile, defaults to empty so that the exported data isn't persisted.
+	CacheFile string
+
+	// QuotaFile is the parsed quotaFile, defaults to empty so that the monthly totals of the users aren't accounted.
+	QuotaFile string
+
+	// Quotas are the parsed quotas of the users in bytes per period, defaults to nil so that no quotas are checked.
+	Quotas map[string]int64
+
+	// QuotaResetDay is the parsed quotaResetDay, defaults to zero so that snmp will use its internal default.
+	QuotaResetDay int
+
+	// TrapTarget is the parsed trapTarget, defaults to empty so that no notifications are sent.
+	TrapTarget string
+
+	// TrapVersion is the parsed trapVersion, defaults to empty so that snmp will use its internal default.
+	TrapVersion string
+
+	// TrapCommunity is the parsed trapCommunity, defaults to empty so that snmp will use its internal default.
+	TrapCommunity string
+
+	// TrapUser is the parsed SNMPv3 user of the notifications: the user name, optionally followed by the authentication
+	// protocol and password, optionally followed by the privacy protocol and password. Defaults to nil.
+	TrapUser []string
+
+	// TrapEngineID is the parsed trapEngineID, defaults to nil so that snmp will use its internal default.
+	TrapEngineID []byte
+
+	// TrapContext is the parsed SNMPv3 context name of the notifications, defaults to the empty default context.
+	TrapContext string
+
+	// TrapContextEngineID is the parsed SNMPv3 context engine ID of the notifications, defaults to nil so that the
+	// engine ID is used.
+	TrapContextEngineID []byte
+
+	// TrapDropRate is the parsed trapDropRate, defaults to zero so that the drop rate isn't checked.
+	TrapDropRate int
+
+	// Alerts are the parsed alert rules, defaults to nil.
+	Alerts []alertRule
+
+	// GraphiteTarget is the parsed graphiteTarget, defaults to empty so that the metrics aren't sent to Graphite.
+	GraphiteTarget string
+
+	// GraphitePath is the parsed graphitePath, defaults to empty so that snmp will use its internal default.
+	GraphitePath string
+
+	// MqttBroker is the parsed mqttBroker, defaults to empty so that the metrics aren't published to MQTT.
+	MqttBroker string
+
+	// MqttTopic is the parsed mqttTopic, defaults to empty so that snmp will use its internal default.
+	MqttTopic string
+
+	// MqttRetain is the parsed mqttRetain, defaults to false.
+	MqttRetain bool
+
+	// MqttTLS is the parsed mqttTLS, defaults to false.
+	MqttTLS bool
+
+	// MqttCAFile is the parsed mqttCAFile, defaults to empty so that the system roots verify the broker.
+	MqttCAFile string
+
+	// MqttUser is the parsed user of mqttUser, defaults to empty so that no authentication is used.
+	MqttUser string
+
+	// MqttPassword is the parsed password of mqttUser.
+	MqttPassword string
+
+	// MqttClientID is the parsed mqttClientID, defaults to empty so that snmp will use its internal default.
+	MqttClientID string
+
+	// SampleFile is the parsed sampleFile, defaults to empty so that the samples aren't written.
+	SampleFile string
+
+	// SampleFormat is the parsed sampleFormat, defaults to empty so that snmp will use its internal default.
+	SampleFormat string
+
+	// SampleMaxSize is the parsed sampleMaxSize in bytes, defaults to zero so that the sample file isn't rotated.
+	SampleMaxSize int64
+
+	// SampleFields is the parsed sampleFields, defaults to nil so that snmp will use its internal default.
+	SampleFields []string
+
+	// RrdDir is the parsed rrdDir, defaults to empty so that the RRD files aren't updated.
+	RrdDir string
+
+	// RrdCmdPath is the parsed rrdCmdPath, defaults to empty so that snmp will use its internal default.
+	RrdCmdPath string
+
+	// ZabbixServer is the parsed zabbixServer, defaults to empty so that the metrics aren't pushed to Zabbix.
+	ZabbixServer string
+
+	// ZabbixHost is the parsed zabbixHost, defaults to empty so that snmp will use the hostname.
+	ZabbixHost string
+
+	// ZabbixKey is the parsed zabbixKey, defaults to empty so that snmp will use its internal default.
+	ZabbixKey string
+
+	// HttpListen is the parsed httpListen, defaults to empty so that the HTTP server isn't started.
+	HttpListen string
+
+	// GrpcListen is the parsed grpcListen, defaults to empty so that the gRPC server isn't started.
+	GrpcListen string
+
+	// PprofListen is the parsed pprofListen, defaults to empty so that the pprof server isn't started.
+	PprofListen string
+
+	// RuntimeStats is the parsed runtimeStats, defaults to false.
+	RuntimeStats bool
+
+	// PrometheusFile is the parsed prometheusFile, defaults to empty so that the Prometheus textfile isn't written.
+	PrometheusFile string
+
+	// UserNames is the parsed userNames, defaults to empty so that the names of the users are written as they are.
+	UserNames string
+
+	// UserNameKey is the parsed userNameKey, defaults to empty.
+	UserNameKey string
+
+	// HistoryRetention is the parsed historyRetention, defaults to zero so that the history isn't removed.
+	HistoryRetention time.Duration
+
+	// AgentTarget is the parsed agentTarget, defaults to empty so that the parsed data isn't posted to an aggregator.
+	AgentTarget string
+
+	// AgentHost is the parsed agentHost, defaults to empty so that the hostname is used.
+	AgentHost string
+
+	// AgentName is the parsed agentName, defaults to empty so that the hostname is used.
+	AgentName string
+
+	// AgentMaster is the parsed agentMaster, defaults to false.
+	AgentMaster bool
+
+	// AgentToken is the parsed agentToken, defaults to empty so that no token is sent.
+	AgentToken string
+
+	// AgentFullSync is the parsed agentFullSync, defaults to zero so that every report is posted in full.
+	AgentFullSync time.Duration
+
+	// AgentEnrollToken is the parsed agentEnrollToken, defaults to empty so that the agent doesn't enroll.
+	AgentEnrollToken string
+
+	// AgentTokenFile is the parsed agentTokenFile, defaults to empty.
+	AgentTokenFile string
+
+	// AgentCAFile is the parsed agentCAFile, defaults to empty so that the system roots verify the aggregator.
+	AgentCAFile string
+
+	// AgentCertFile is the parsed agentCertFile, defaults to empty so that the agent sends no certificate.
+	AgentCertFile string
+
+	// AgentKeyFile is the parsed agentKeyFile, defaults to empty.
+	AgentKeyFile string
+
+	// AggregatorListen is the parsed aggregatorListen, defaults to empty so that the aggregator isn't started.
+	AggregatorListen string
+
+	// AggregatorToken is the parsed aggregatorToken, defaults to empty so that the reports are accepted without a token.
+	AggregatorToken string
+
+	// AggregatorConflicts is the parsed aggregatorConflicts, defaults to empty so that the masters are preferred.
+	AggregatorConflicts string
+
+	// AggregatorEnrollToken is the parsed aggregatorEnrollToken, defaults to empty so that the agents can't enroll.
+	AggregatorEnrollToken string
+
+	// AggregatorTokenFile is the parsed aggregatorTokenFile, defaults to empty so that the enrollments aren't kept.
+	AggregatorTokenFile string
+
+	// AggregatorCertFile is the parsed aggregatorCertFile, defaults to empty so that TLS isn't served.
+	AggregatorCertFile string
+
+	// AggregatorKeyFile is the parsed aggregatorKeyFile, defaults to empty.
+	AggregatorKeyFile string
+
+	// AggregatorClientCAFile is the parsed aggregatorClientCAFile, defaults to empty so that no certificates are required.
+	AggregatorClientCAFile string
+
+	// ControlSocket is the parsed controlSocket, defaults to empty so that the control socket isn't served.
+	ControlSocket string
+
+	// RunAs is the parsed user of runAs, defaults to empty so that tc_reader keeps running as the user it was started as.
+	RunAs string
+
+	// RunAsGroup is the parsed group of runAs, defaults to empty so that the primary group of the user is used.
+	RunAsGroup string
+
+	// LogTarget is the parsed logTarget, defaults to empty so that the logger will use its internal default.
+	LogTarget string
+
+	// LogLevel is the parsed logLevel, defaults to empty so that the logger will use its internal default.
+	LogLevel string
+
+	// SyslogFacility is the parsed syslogFacility, defaults to empty so that the logger will use its internal default.
+	SyslogFacility string
+
+	// SyslogTag is the parsed syslogTag, defaults to empty so that the logger will use its internal default.
+	SyslogTag string
+
+	// BaseOID is the parsed baseOID, defaults to empty so that snmp will use its internal default.
+	BaseOID string
+
 	// UserNameClass are the parsed user definitions, defaults to nil so that parser will use its internal default.
-	UserNameClass map[string]userClass
+	UserNameClass map[string]UserClass
+
+	// AddrUsers are the parsed user addresses, defaults to nil so that no users are defined by addresses.
+	AddrUsers map[string]string
+
+	// CounterUsers are the parsed user counters, defaults to nil so that no users are defined by netfilter counters.
+	CounterUsers map[string]UserClass
+
+	// ConntrackUsers are the parsed user address ranges, defaults to nil so that conntrack isn't read.
+	ConntrackUsers map[string]string
+
+	// IfaceTotals is the parsed ifaceTotals, defaults to empty so that the interface totals aren't exported.
+	IfaceTotals string
+
+	// Xstats is the parsed xstats, defaults to false.
+	Xstats bool
+
+	// NetDev is the parsed netDev, defaults to false.
+	NetDev bool
+
+	// LinkStats is the parsed linkStats, defaults to false.
+	LinkStats bool
+
+	// Blackouts are the parsed blackout windows, defaults to nil.
+	Blackouts []blackoutWindow
+
+	// Groups are the parsed interface group definitions, defaults to nil so that parser will use its internal default.
+	Groups map[string][]string
+
+	// MaxWarnings is the parsed maxWarnings, defaults to zero so that snmp will use its internal default.
+	MaxWarnings int
+
+	// PktSizeBuckets are the parsed pktSizeBuckets, defaults to nil which disables the packet size buckets.
+	PktSizeBuckets []int
+
+	// Identity is the parsed identity template, defaults to empty string so that snmp will use its internal default.
+	Identity string
+
+	// Labels is the parsed labels, defaults to empty string.
+	Labels string
+
+	// Instance is the parsed instance, defaults to empty string so that this is the only instance on the host.
+	Instance string
+
+	// LeaderDir is the parsed leaderDir, defaults to empty string so that the instance isn't registered with a leader.
+	LeaderDir string
+
+	// EncodeIfaceNames is the parsed encodeIfaceNames, defaults to false.
+	EncodeIfaceNames bool
 
 	// Debug is the parsed Debug, defaults to false.
 	Debug bool
@@ -88,6 +770,19 @@ type config struct {
 	// filename is the config file name.
 	filename string
 
+	// reading are the absolute paths of the config files being read, the outermost first. It detects the files that
+	// include themselves.
+	reading []string
+
+	// digest is the SHA-256 hash of the content of the config files in the order they were read.
+	digest hash.Hash
+
+	// modified is the most recent modification time of the config files.
+	modified time.Time
+
+	// warnings are the problems found in the config files that don't prevent using the configuration.
+	warnings []string
+
 	// reComment is the compiled version of reComment constant.
 	reComment *regexp.Regexp
 
@@ -97,111 +792,1335 @@ type config struct {
 	// reTcCmdPath is the compiled version of reTcCmdPath constant.
 	reTcCmdPath *regexp.Regexp
 
+	// reIpCmdPath is the compiled version of reIpCmdPath constant.
+	reIpCmdPath *regexp.Regexp
+
+	// reNftCmdPath is the compiled version of reNftCmdPath constant.
+	reNftCmdPath *regexp.Regexp
+
+	// reIptablesCmdPath is the compiled version of reIptablesCmdPath constant.
+	reIptablesCmdPath *regexp.Regexp
+
+	// reConntrackCmdPath is the compiled version of reConntrackCmdPath constant.
+	reConntrackCmdPath *regexp.Regexp
+
+	// reExecWrapper is the compiled version of reExecWrapper constant.
+	reExecWrapper *regexp.Regexp
+
 	// reParseInterval is the compiled version of reParseInterval constant.
 	reParseInterval *regexp.Regexp
 
+	// reAlignCycles is the compiled version of reAlignCycles constant.
+	reAlignCycles *regexp.Regexp
+
+	// reParseJitter is the compiled version of reParseJitter constant.
+	reParseJitter *regexp.Regexp
+
 	// reTcQdiscStats is the compiled version of reTcQdiscStats constant.
 	reTcQdiscStats *regexp.Regexp
 
 	// reTcClassStats is the compiled version of reTcClassStats constant.
 	reTcClassStats *regexp.Regexp
 
+	// reTcFilterShow is the compiled version of reTcFilterShow constant.
+	reTcFilterShow *regexp.Regexp
+
+	// reQdiscPattern is the compiled version of reQdiscPattern constant.
+	reQdiscPattern *regexp.Regexp
+
+	// reClassPattern is the compiled version of reClassPattern constant.
+	reClassPattern *regexp.Regexp
+
+	// reStatsPattern is the compiled version of reStatsPattern constant.
+	reStatsPattern *regexp.Regexp
+
 	// reIfaces is the compiled version of reIfaces constant.
 	reIfaces *regexp.Regexp
 
-	// reUserNameClass is the compiled version of reUserNameClass constant.
-	reUserNameClass *regexp.Regexp
+	// reDiscoveryInterval is the compiled version of reDiscoveryInterval constant.
+	reDiscoveryInterval *regexp.Regexp
 
-	// reDebug is the compiled version of reDebug constant.
-	reDebug *regexp.Regexp
-}
+	// reMaxParallel is the compiled version of reMaxParallel constant.
+	reMaxParallel *regexp.Regexp
 
-// readConfig reads the configuration file and parses its content.
-func (c *config) readConfig() error {
-	content, err := ioutil.ReadFile(c.filename)
-	if err != nil {
-		return err
-	}
-	err = c.parseConfig(string(content))
-	if err != nil {
-		return err
-	}
-	return nil
-}
+	// reMaxCommands is the compiled version of reMaxCommands constant.
+	reMaxCommands *regexp.Regexp
 
-// parseContent parses the content of the config file.
-func (c *config) parseConfig(content string) error {
-	lines := strings.Split(content, "\n")
-	var err error
-	for n, line := range lines {
-		lineNumber := n + 1
-		switch {
-		// Ignore empty lines.
-		case c.reEmpty.MatchString(line):
+	// reCommandTimeout is the compiled version of reCommandTimeout constant.
+	reCommandTimeout *regexp.Regexp
+
+	// reSingleInvocation is the compiled version of reSingleInvocation constant.
+	reSingleInvocation *regexp.Regexp
+
+	// reIdlePause is the compiled version of reIdlePause constant.
+	reIdlePause *regexp.Regexp
+
+	// reMaxBackoff is the compiled version of reMaxBackoff constant.
+	reMaxBackoff *regexp.Regexp
+
+	// reMaxDataAge is the compiled version of reMaxDataAge constant.
+	reMaxDataAge *regexp.Regexp
+
+	// reRetention is the compiled version of reRetention constant.
+	reRetention *regexp.Regexp
+
+	// reExportGeneric is the compiled version of reExportGeneric constant.
+	reExportGeneric *regexp.Regexp
+
+	// reExportUsers is the compiled version of reExportUsers constant.
+	reExportUsers *regexp.Regexp
+
+	// reExportDeltas is the compiled version of reExportDeltas constant.
+	reExportDeltas *regexp.Regexp
+
+	// reExportMetrics is the compiled version of reExportMetrics constant.
+	reExportMetrics *regexp.Regexp
+
+	// reRateSamples is the compiled version of reRateSamples constant.
+	reRateSamples *regexp.Regexp
+
+	// reCacheFile is the compiled version of reCacheFile constant.
+	reCacheFile *regexp.Regexp
+
+	// reQuotaFile is the compiled version of reQuotaFile constant.
+	reQuotaFile *regexp.Regexp
+
+	// reQuota is the compiled version of reQuota constant.
+	reQuota *regexp.Regexp
+
+	// reQuotaResetDay is the compiled version of reQuotaResetDay constant.
+	reQuotaResetDay *regexp.Regexp
+
+	// reTrapTarget is the compiled version of reTrapTarget constant.
+	reTrapTarget *regexp.Regexp
+
+	// reTrapVersion is the compiled version of reTrapVersion constant.
+	reTrapVersion *regexp.Regexp
+
+	// reTrapCommunity is the compiled version of reTrapCommunity constant.
+	reTrapCommunity *regexp.Regexp
+
+	// reTrapUser is the compiled version of reTrapUser constant.
+	reTrapUser *regexp.Regexp
+
+	// reTrapEngineID is the compiled version of reTrapEngineID constant.
+	reTrapEngineID *regexp.Regexp
+
+	// reTrapContext is the compiled version of reTrapContext constant.
+	reTrapContext *regexp.Regexp
+
+	// reTrapDropRate is the compiled version of reTrapDropRate constant.
+	reTrapDropRate *regexp.Regexp
+
+	// reGraphiteTarget is the compiled version of reGraphiteTarget constant.
+	reGraphiteTarget *regexp.Regexp
+
+	// reGraphitePath is the compiled version of reGraphitePath constant.
+	reGraphitePath *regexp.Regexp
+
+	// reMqttBroker is the compiled version of reMqttBroker constant.
+	reMqttBroker *regexp.Regexp
+
+	// reMqttTopic is the compiled version of reMqttTopic constant.
+	reMqttTopic *regexp.Regexp
+
+	// reMqttRetain is the compiled version of reMqttRetain constant.
+	reMqttRetain *regexp.Regexp
+
+	// reMqttTLS is the compiled version of reMqttTLS constant.
+	reMqttTLS *regexp.Regexp
+
+	// reMqttCAFile is the compiled version of reMqttCAFile constant.
+	reMqttCAFile *regexp.Regexp
+
+	// reMqttUser is the compiled version of reMqttUser constant.
+	reMqttUser *regexp.Regexp
+
+	// reMqttClientID is the compiled version of reMqttClientID constant.
+	reMqttClientID *regexp.Regexp
+
+	// reSampleFile is the compiled version of reSampleFile constant.
+	reSampleFile *regexp.Regexp
+
+	// reSampleFormat is the compiled version of reSampleFormat constant.
+	reSampleFormat *regexp.Regexp
+
+	// reSampleMaxSize is the compiled version of reSampleMaxSize constant.
+	reSampleMaxSize *regexp.Regexp
+
+	// reSampleFields is the compiled version of reSampleFields constant.
+	reSampleFields *regexp.Regexp
+
+	// reRrdDir is the compiled version of reRrdDir constant.
+	reRrdDir *regexp.Regexp
+
+	// reRrdCmdPath is the compiled version of reRrdCmdPath constant.
+	reRrdCmdPath *regexp.Regexp
+
+	// reZabbixServer is the compiled version of reZabbixServer constant.
+	reZabbixServer *regexp.Regexp
+
+	// reZabbixHost is the compiled version of reZabbixHost constant.
+	reZabbixHost *regexp.Regexp
+
+	// reZabbixKey is the compiled version of reZabbixKey constant.
+	reZabbixKey *regexp.Regexp
+
+	// reHttpListen is the compiled version of reHttpListen constant.
+	reHttpListen *regexp.Regexp
+
+	// reGrpcListen is the compiled version of reGrpcListen constant.
+	reGrpcListen *regexp.Regexp
+
+	// rePprofListen is the compiled version of rePprofListen constant.
+	rePprofListen *regexp.Regexp
+
+	// reRuntimeStats is the compiled version of reRuntimeStats constant.
+	reRuntimeStats *regexp.Regexp
+
+	// rePrometheusFile is the compiled version of rePrometheusFile constant.
+	rePrometheusFile *regexp.Regexp
+
+	// reUserNames is the compiled version of reUserNames constant.
+	reUserNames *regexp.Regexp
+
+	// reUserNameKey is the compiled version of reUserNameKey constant.
+	reUserNameKey *regexp.Regexp
+
+	// reHistoryRetention is the compiled version of reHistoryRetention constant.
+	reHistoryRetention *regexp.Regexp
+
+	// reAgentTarget is the compiled version of reAgentTarget constant.
+	reAgentTarget *regexp.Regexp
+
+	// reAgentHost is the compiled version of reAgentHost constant.
+	reAgentHost *regexp.Regexp
+
+	// reAgentName is the compiled version of reAgentName constant.
+	reAgentName *regexp.Regexp
+
+	// reAgentMaster is the compiled version of reAgentMaster constant.
+	reAgentMaster *regexp.Regexp
+
+	// reAgentToken is the compiled version of reAgentToken constant.
+	reAgentToken *regexp.Regexp
+
+	// reAgentFullSync is the compiled version of reAgentFullSync constant.
+	reAgentFullSync *regexp.Regexp
+
+	// reAgentEnrollToken is the compiled version of reAgentEnrollToken constant.
+	reAgentEnrollToken *regexp.Regexp
+
+	// reAgentTokenFile is the compiled version of reAgentTokenFile constant.
+	reAgentTokenFile *regexp.Regexp
+
+	// reAgentCAFile is the compiled version of reAgentCAFile constant.
+	reAgentCAFile *regexp.Regexp
+
+	// reAgentCertFile is the compiled version of reAgentCertFile constant.
+	reAgentCertFile *regexp.Regexp
+
+	// reAgentKeyFile is the compiled version of reAgentKeyFile constant.
+	reAgentKeyFile *regexp.Regexp
+
+	// reAggregatorListen is the compiled version of reAggregatorListen constant.
+	reAggregatorListen *regexp.Regexp
+
+	// reAggregatorToken is the compiled version of reAggregatorToken constant.
+	reAggregatorToken *regexp.Regexp
+
+	// reAggregatorConflicts is the compiled version of reAggregatorConflicts constant.
+	reAggregatorConflicts *regexp.Regexp
+
+	// reAggregatorEnrollToken is the compiled version of reAggregatorEnrollToken constant.
+	reAggregatorEnrollToken *regexp.Regexp
+
+	// reAggregatorTokenFile is the compiled version of reAggregatorTokenFile constant.
+	reAggregatorTokenFile *regexp.Regexp
+
+	// reAggregatorCertFile is the compiled version of reAggregatorCertFile constant.
+	reAggregatorCertFile *regexp.Regexp
+
+	// reAggregatorKeyFile is the compiled version of reAggregatorKeyFile constant.
+	reAggregatorKeyFile *regexp.Regexp
+
+	// reAggregatorClientCAFile is the compiled version of reAggregatorClientCAFile constant.
+	reAggregatorClientCAFile *regexp.Regexp
+
+	// reControlSocket is the compiled version of reControlSocket constant.
+	reControlSocket *regexp.Regexp
+
+	// reRunAs is the compiled version of reRunAs constant.
+	reRunAs *regexp.Regexp
+
+	// reLogTarget is the compiled version of reLogTarget constant.
+	reLogTarget *regexp.Regexp
+
+	// reLogLevel is the compiled version of reLogLevel constant.
+	reLogLevel *regexp.Regexp
+
+	// reSyslogFacility is the compiled version of reSyslogFacility constant.
+	reSyslogFacility *regexp.Regexp
+
+	// reSyslogTag is the compiled version of reSyslogTag constant.
+	reSyslogTag *regexp.Regexp
+
+	// reBaseOID is the compiled version of reBaseOID constant.
+	reBaseOID *regexp.Regexp
+
+	// reInclude is the compiled version of reInclude constant.
+	reInclude *regexp.Regexp
+
+	// reOption is the compiled version of reOption constant.
+	reOption *regexp.Regexp
+
+	// reAlert is the compiled version of reAlert constant.
+	reAlert *regexp.Regexp
+
+	// reUserNameClass is the compiled version of reUserNameClass constant.
+	reUserNameClass *regexp.Regexp
+
+	// reUserAddr is the compiled version of reUserAddr constant.
+	reUserAddr *regexp.Regexp
+
+	// reUserCounter is the compiled version of reUserCounter constant.
+	reUserCounter *regexp.Regexp
+
+	// reUserConntrack is the compiled version of reUserConntrack constant.
+	reUserConntrack *regexp.Regexp
+
+	// reIfaceTotals is the compiled version of reIfaceTotals constant.
+	reIfaceTotals *regexp.Regexp
+
+	// reXstats is the compiled version of reXstats constant.
+	reXstats *regexp.Regexp
+
+	// reNetDev is the compiled version of reNetDev constant.
+	reNetDev *regexp.Regexp
+
+	// reLinkStats is the compiled version of reLinkStats constant.
+	reLinkStats *regexp.Regexp
+
+	// reBlackout is the compiled version of reBlackout constant.
+	reBlackout *regexp.Regexp
+
+	// reGroup is the compiled version of reGroup constant.
+	reGroup *regexp.Regexp
+
+	// reMaxWarnings is the compiled version of reMaxWarnings constant.
+	reMaxWarnings *regexp.Regexp
+
+	// rePktSizeBuckets is the compiled version of rePktSizeBuckets constant.
+	rePktSizeBuckets *regexp.Regexp
+
+	// reIdentity is the compiled version of reIdentity constant.
+	reIdentity *regexp.Regexp
+
+	// reLabels is the compiled version of reLabels constant.
+	reLabels *regexp.Regexp
+
+	// reInstance is the compiled version of reInstance constant.
+	reInstance *regexp.Regexp
+
+	// reLeaderDir is the compiled version of reLeaderDir constant.
+	reLeaderDir *regexp.Regexp
+
+	// reEncodeIfaceNames is the compiled version of reEncodeIfaceNames constant.
+	reEncodeIfaceNames *regexp.Regexp
+
+	// reDebug is the compiled version of reDebug constant.
+	reDebug *regexp.Regexp
+}
+
+// readConfig reads the configuration file and its fragments and parses their content. All the files are read, the
+// returned error joins the errors found in each of them.
+func (c *config) readConfig() error {
+	files, err := configFiles(c.filename)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, file := range files {
+		if err := c.readFile(file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readFile reads the config file and parses its content, in TOML if it has the .toml extension.
+func (c *config) readFile(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(file); err == nil && info.ModTime().After(c.modified) {
+		c.modified = info.ModTime()
+	}
+	if c.digest == nil {
+		c.digest = sha256.New()
+	}
+	c.digest.Write(content)
+	path := c.filename
+	c.filename = file
+	c.reading = append(c.reading, abs)
+	defer func() {
+		c.filename = path
+		c.reading = c.reading[:len(c.reading)-1]
+	}()
+	if filepath.Ext(file) == tomlExtension {
+		return c.parseToml(string(content))
+	}
+	return c.parseConfig(string(content))
+}
+
+// include reads the config files matching the pattern on the include line in lexical order. Relative patterns are
+// relative to the directory of the including file. A pattern that matches no files isn't an error, so that a
+// provisioning system can start with an empty directory.
+func (c *config) include(lineNumber int, line string) error {
+	pattern := c.reInclude.FindStringSubmatch(line)[1]
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(c.filename), pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("Error in config file %s on line %d: invalid pattern, error: %s. Line: '%s'", c.filename, lineNumber, err, line)
+	}
+	var errs []error
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if slices.Contains(c.reading, abs) {
+			errs = append(errs, fmt.Errorf("Error in config file %s on line %d: %s includes itself. Line: '%s'", c.filename, lineNumber, file, line))
 			continue
+		}
+		if err := c.readFile(file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// configFiles returns the config file followed by its fragments in lexical order. Returns only the fragments if the
+// path is a directory, or if the config file doesn't exist but the directory of its fragments does.
+func configFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		fragments, err := configFragments(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(fragments) == 0 {
+			return nil, fmt.Errorf("the config directory %s has no %s files", path, configFragmentPattern)
+		}
+		return fragments, nil
+	}
+	fragments, err := configFragments(path + configDirSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) && len(fragments) > 0 {
+		return fragments, nil
+	}
+	return append([]string{path}, fragments...), nil
+}
+
+// configFragments returns the fragments in the directory in lexical order.
+func configFragments(dir string) ([]string, error) {
+	var fragments []string
+	for _, pattern := range []string{configFragmentPattern, configTomlFragmentPattern} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, matches...)
+	}
+	sort.Strings(fragments)
+	return fragments, nil
+}
+
+// parseContent parses the content of the config file. All the lines are parsed, the returned error joins the errors
+// of the invalid ones.
+func (c *config) parseConfig(content string) error {
+	var errs []error
+	lines := strings.Split(content, "\n")
+	for n, line := range lines {
+		if err := c.parseLine(n, line); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseLine parses the line of the config file with the zero based number n.
+func (c *config) parseLine(n int, line string) error {
+	lineNumber := n + 1
+	var err error
+	switch {
+	// Ignore empty lines.
+	case c.reEmpty.MatchString(line):
+		return nil
+
+	// Ignore comments,
+	case c.reComment.MatchString(line):
+		return nil
+
+	// Line that defines path to the TC command.
+	case c.reTcCmdPath.MatchString(line):
+		err = c.getTcCmdPath(n, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines path to the ip command.
+	case c.reIpCmdPath.MatchString(line):
+		err = c.getString(&c.IpCmdPath, c.reIpCmdPath, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines path to the nft command.
+	case c.reNftCmdPath.MatchString(line):
+		err = c.getString(&c.NftCmdPath, c.reNftCmdPath, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines path to the iptables command.
+	case c.reIptablesCmdPath.MatchString(line):
+		err = c.getString(&c.IptablesCmdPath, c.reIptablesCmdPath, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines path to the conntrack command.
+	case c.reConntrackCmdPath.MatchString(line):
+		err = c.getString(&c.ConntrackCmdPath, c.reConntrackCmdPath, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the command prefix of the TC and ip commands.
+	case c.reExecWrapper.MatchString(line):
+		err = c.getListOfStrings(&c.ExecWrapper, c.reExecWrapper, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines parse interval.
+	case c.reParseInterval.MatchString(line):
+		err = c.getParseInterval(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether the parse cycles are aligned to the wall-clock boundaries.
+	case c.reAlignCycles.MatchString(line):
+		err = c.getBool(&c.AlignCycles, c.reAlignCycles, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the random delay of the parse cycles.
+	case c.reParseJitter.MatchString(line):
+		err = c.getDuration(&c.ParseJitter, c.reParseJitter, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines TC parameters to get Qdisc statistics.
+	case c.reTcQdiscStats.MatchString(line):
+		err = c.getListOfStrings(&c.TcQdiscStats, c.reTcQdiscStats, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines TC parameters to get Class statistics.
+	case c.reTcClassStats.MatchString(line):
+		err = c.getListOfStrings(&c.TcClassStats, c.reTcClassStats, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines TC parameters to get the filters.
+	case c.reTcFilterShow.MatchString(line):
+		err = c.getListOfStrings(&c.TcFilterShow, c.reTcFilterShow, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the pattern matching the header line of a Qdisc.
+	case c.reQdiscPattern.MatchString(line):
+		err = c.getPattern(&c.QdiscPattern, qdiscHeaderGroups, c.reQdiscPattern, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the pattern matching the header line of a Class.
+	case c.reClassPattern.MatchString(line):
+		err = c.getPattern(&c.ClassPattern, classHeaderGroups, c.reClassPattern, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the pattern matching the statistics of a Qdisc or Class.
+	case c.reStatsPattern.MatchString(line):
+		err = c.getPattern(&c.StatsPattern, statsGroups, c.reStatsPattern, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines interfaces.
+	case c.reIfaces.MatchString(line):
+		err = c.getListOfStrings(&c.Ifaces, c.reIfaces, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the interface discovery interval.
+	case c.reDiscoveryInterval.MatchString(line):
+		err = c.getInt(&c.DiscoveryInterval, c.reDiscoveryInterval, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the number of interfaces collected concurrently.
+	case c.reMaxParallel.MatchString(line):
+		err = c.getInt(&c.MaxParallel, c.reMaxParallel, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the number of TC commands executed concurrently.
+	case c.reMaxCommands.MatchString(line):
+		err = c.getInt(&c.MaxCommands, c.reMaxCommands, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the timeout of the executed commands.
+	case c.reCommandTimeout.MatchString(line):
+		err = c.getInt(&c.CommandTimeout, c.reCommandTimeout, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether a single TC command shows all the interfaces.
+	case c.reSingleInvocation.MatchString(line):
+		err = c.getBool(&c.SingleInvocation, c.reSingleInvocation, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the minutes without SNMP requests after which the collection pauses.
+	case c.reIdlePause.MatchString(line):
+		err = c.getInt(&c.IdlePause, c.reIdlePause, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the maximum backoff after TC failures.
+	case c.reMaxBackoff.MatchString(line):
+		err = c.getInt(&c.MaxBackoff, c.reMaxBackoff, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the age after which the data is refreshed on demand.
+	case c.reMaxDataAge.MatchString(line):
+		err = c.getInt(&c.MaxDataAge, c.reMaxDataAge, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines for how many cycles the disappeared Qdiscs / Classes are retained.
+	case c.reRetention.MatchString(line):
+		err = c.getInt(&c.Retention, c.reRetention, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether the Qdiscs / Classes are exported.
+	case c.reExportGeneric.MatchString(line):
+		err = c.getOptionalBool(&c.ExportGeneric, c.reExportGeneric, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether the users are exported.
+	case c.reExportUsers.MatchString(line):
+		err = c.getOptionalBool(&c.ExportUsers, c.reExportUsers, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether the values during the last interval are exported.
+	case c.reExportDeltas.MatchString(line):
+		err = c.getBool(&c.ExportDeltas, c.reExportDeltas, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the exported metrics.
+	case c.reExportMetrics.MatchString(line):
+		err = c.getListOfStrings(&c.ExportMetrics, c.reExportMetrics, lineNumber, line)
+		if err != nil {
+			return err
+		}
+		if err := validMetrics(c.ExportMetrics); err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+		}
+
+	// Line that defines the number of parse cycles kept for the moving average rates.
+	case c.reRateSamples.MatchString(line):
+		err = c.getInt(&c.RateSamples, c.reRateSamples, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the file the exported data is persisted to.
+	case c.reCacheFile.MatchString(line):
+		err = c.getString(&c.CacheFile, c.reCacheFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the file the quota totals are persisted to.
+	case c.reQuotaFile.MatchString(line):
+		err = c.getString(&c.QuotaFile, c.reQuotaFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the quota of an user.
+	case c.reQuota.MatchString(line):
+		err = c.getQuota(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the day of the month on which the quota period starts.
+	case c.reQuotaResetDay.MatchString(line):
+		err = c.getInt(&c.QuotaResetDay, c.reQuotaResetDay, lineNumber, line)
+		if err != nil {
+			return err
+		}
+		if c.QuotaResetDay < 1 || c.QuotaResetDay > maxQuotaResetDay {
+			return fmt.Errorf("Error in config file %s on line %d: the quota reset day must be between 1 and %d. Line: '%s'", c.filename, lineNumber, maxQuotaResetDay, line)
+		}
+
+	// Line that defines the target of the notifications.
+	case c.reTrapTarget.MatchString(line):
+		err = c.getString(&c.TrapTarget, c.reTrapTarget, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the SNMP version of the notifications.
+	case c.reTrapVersion.MatchString(line):
+		err = c.getString(&c.TrapVersion, c.reTrapVersion, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the community of the notifications.
+	case c.reTrapCommunity.MatchString(line):
+		err = c.getString(&c.TrapCommunity, c.reTrapCommunity, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the SNMPv3 user of the notifications.
+	case c.reTrapUser.MatchString(line):
+		err = c.getTrapUser(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the engine ID of the notifications.
+	case c.reTrapEngineID.MatchString(line):
+		err = c.getTrapEngineID(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the SNMPv3 context of the notifications.
+	case c.reTrapContext.MatchString(line):
+		err = c.getTrapContext(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the drop rate above which a notification is sent.
+	case c.reTrapDropRate.MatchString(line):
+		err = c.getInt(&c.TrapDropRate, c.reTrapDropRate, lineNumber, line)
+		if err != nil {
+			return err
+		}
+		if c.TrapDropRate < 1 || c.TrapDropRate > 100 {
+			return fmt.Errorf("Error in config file %s on line %d: the drop rate must be between 1 and 100. Line: '%s'", c.filename, lineNumber, line)
+		}
+
+	// Line that defines the Graphite Carbon daemon the metrics are sent to.
+	case c.reGraphiteTarget.MatchString(line):
+		err = c.getString(&c.GraphiteTarget, c.reGraphiteTarget, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the template of the Graphite metric paths.
+	case c.reGraphitePath.MatchString(line):
+		err = c.getString(&c.GraphitePath, c.reGraphitePath, lineNumber, line)
+		if err != nil {
+			return err
+		}
+		if err := validMetricPath(c.GraphitePath); err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+		}
+
+	// Line that defines the MQTT broker the metrics are published to.
+	case c.reMqttBroker.MatchString(line):
+		err = c.getString(&c.MqttBroker, c.reMqttBroker, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the template of the MQTT topics.
+	case c.reMqttTopic.MatchString(line):
+		err = c.getString(&c.MqttTopic, c.reMqttTopic, lineNumber, line)
+		if err != nil {
+			return err
+		}
+		if err := validMqttTopic(c.MqttTopic); err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+		}
+
+	// Line that defines whether the broker retains the published metrics.
+	case c.reMqttRetain.MatchString(line):
+		err = c.getBool(&c.MqttRetain, c.reMqttRetain, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether the broker is connected over TLS.
+	case c.reMqttTLS.MatchString(line):
+		err = c.getBool(&c.MqttTLS, c.reMqttTLS, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the CA certificates that verify the broker.
+	case c.reMqttCAFile.MatchString(line):
+		err = c.getString(&c.MqttCAFile, c.reMqttCAFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the user to authenticate to the broker.
+	case c.reMqttUser.MatchString(line):
+		err = c.getMqttUser(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the client identifier presented to the broker.
+	case c.reMqttClientID.MatchString(line):
+		err = c.getString(&c.MqttClientID, c.reMqttClientID, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the file the samples are appended to.
+	case c.reSampleFile.MatchString(line):
+		err = c.getString(&c.SampleFile, c.reSampleFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the format of the samples.
+	case c.reSampleFormat.MatchString(line):
+		err = c.getString(&c.SampleFormat, c.reSampleFormat, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the size over which the sample file is rotated.
+	case c.reSampleMaxSize.MatchString(line):
+		err = c.getSampleMaxSize(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the fields of the samples.
+	case c.reSampleFields.MatchString(line):
+		err = c.getListOfStrings(&c.SampleFields, c.reSampleFields, lineNumber, line)
+		if err != nil {
+			return err
+		}
+		if err := validSampleFields(c.SampleFields); err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+		}
+
+	// Line that defines the directory of the RRD files.
+	case c.reRrdDir.MatchString(line):
+		err = c.getString(&c.RrdDir, c.reRrdDir, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the path to rrdtool.
+	case c.reRrdCmdPath.MatchString(line):
+		err = c.getString(&c.RrdCmdPath, c.reRrdCmdPath, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the Zabbix server the metrics are pushed to.
+	case c.reZabbixServer.MatchString(line):
+		err = c.getString(&c.ZabbixServer, c.reZabbixServer, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the host in Zabbix.
+	case c.reZabbixHost.MatchString(line):
+		err = c.getString(&c.ZabbixHost, c.reZabbixHost, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the template of the Zabbix item keys.
+	case c.reZabbixKey.MatchString(line):
+		err = c.getString(&c.ZabbixKey, c.reZabbixKey, lineNumber, line)
+		if err != nil {
+			return err
+		}
+		if err := validMetricPath(c.ZabbixKey); err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+		}
+
+	// Line that defines the address of the HTTP server.
+	case c.reHttpListen.MatchString(line):
+		err = c.getString(&c.HttpListen, c.reHttpListen, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the address of the gRPC server.
+	case c.reGrpcListen.MatchString(line):
+		err = c.getString(&c.GrpcListen, c.reGrpcListen, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the address of the pprof server.
+	case c.rePprofListen.MatchString(line):
+		err = c.getString(&c.PprofListen, c.rePprofListen, lineNumber, line)
+		if err != nil {
+			return err
+		}
+		if err := checkLoopback(c.PprofListen); err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+		}
+
+	// Line that defines whether the statistics of the Go runtime are exported.
+	case c.reRuntimeStats.MatchString(line):
+		err = c.getBool(&c.RuntimeStats, c.reRuntimeStats, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the Prometheus textfile.
+	case c.rePrometheusFile.MatchString(line):
+		err = c.getString(&c.PrometheusFile, c.rePrometheusFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines how the names of the users are written by the outputs other than SNMP.
+	case c.reUserNames.MatchString(line):
+		err = c.getString(&c.UserNames, c.reUserNames, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the key of the pseudonyms of the user names.
+	case c.reUserNameKey.MatchString(line):
+		err = c.getString(&c.UserNameKey, c.reUserNameKey, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the time after which the history with the names of the users is removed.
+	case c.reHistoryRetention.MatchString(line):
+		err = c.getDuration(&c.HistoryRetention, c.reHistoryRetention, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the URL of the aggregator the parsed data is posted to.
+	case c.reAgentTarget.MatchString(line):
+		err = c.getString(&c.AgentTarget, c.reAgentTarget, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the name of the host the posted data is scoped by.
+	case c.reAgentHost.MatchString(line):
+		err = c.getString(&c.AgentHost, c.reAgentHost, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the name of the agent.
+	case c.reAgentName.MatchString(line):
+		err = c.getString(&c.AgentName, c.reAgentName, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether the data of the agent is preferred by the aggregator.
+	case c.reAgentMaster.MatchString(line):
+		err = c.getBool(&c.AgentMaster, c.reAgentMaster, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the token sent to the aggregator.
+	case c.reAgentToken.MatchString(line):
+		err = c.getString(&c.AgentToken, c.reAgentToken, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the period of the full reports posted to the aggregator.
+	case c.reAgentFullSync.MatchString(line):
+		err = c.getDuration(&c.AgentFullSync, c.reAgentFullSync, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the token the agent enrolls at the aggregator with.
+	case c.reAgentEnrollToken.MatchString(line):
+		err = c.getString(&c.AgentEnrollToken, c.reAgentEnrollToken, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the file keeping the token of the enrolled agent.
+	case c.reAgentTokenFile.MatchString(line):
+		err = c.getString(&c.AgentTokenFile, c.reAgentTokenFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the CA certificates verifying the aggregator.
+	case c.reAgentCAFile.MatchString(line):
+		err = c.getString(&c.AgentCAFile, c.reAgentCAFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the client certificate of the agent.
+	case c.reAgentCertFile.MatchString(line):
+		err = c.getString(&c.AgentCertFile, c.reAgentCertFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the key of the client certificate of the agent.
+	case c.reAgentKeyFile.MatchString(line):
+		err = c.getString(&c.AgentKeyFile, c.reAgentKeyFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the address of the aggregator.
+	case c.reAggregatorListen.MatchString(line):
+		err = c.getString(&c.AggregatorListen, c.reAggregatorListen, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the token the agents must send.
+	case c.reAggregatorToken.MatchString(line):
+		err = c.getString(&c.AggregatorToken, c.reAggregatorToken, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the policy of the hosts reported by several agents.
+	case c.reAggregatorConflicts.MatchString(line):
+		err = c.getString(&c.AggregatorConflicts, c.reAggregatorConflicts, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the token the agents enroll with.
+	case c.reAggregatorEnrollToken.MatchString(line):
+		err = c.getString(&c.AggregatorEnrollToken, c.reAggregatorEnrollToken, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the file keeping the hashes of the tokens of the enrolled agents.
+	case c.reAggregatorTokenFile.MatchString(line):
+		err = c.getString(&c.AggregatorTokenFile, c.reAggregatorTokenFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the certificate of the aggregator.
+	case c.reAggregatorCertFile.MatchString(line):
+		err = c.getString(&c.AggregatorCertFile, c.reAggregatorCertFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the key of the certificate of the aggregator.
+	case c.reAggregatorKeyFile.MatchString(line):
+		err = c.getString(&c.AggregatorKeyFile, c.reAggregatorKeyFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the CA certificates verifying the client certificates of the agents.
+	case c.reAggregatorClientCAFile.MatchString(line):
+		err = c.getString(&c.AggregatorClientCAFile, c.reAggregatorClientCAFile, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the path of the control socket.
+	case c.reControlSocket.MatchString(line):
+		err = c.getString(&c.ControlSocket, c.reControlSocket, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the user and the group tc_reader switches to.
+	case c.reRunAs.MatchString(line):
+		err = c.getRunAs(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the log target.
+	case c.reLogTarget.MatchString(line):
+		err = c.getString(&c.LogTarget, c.reLogTarget, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the log level.
+	case c.reLogLevel.MatchString(line):
+		err = c.getString(&c.LogLevel, c.reLogLevel, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the Syslog facility.
+	case c.reSyslogFacility.MatchString(line):
+		err = c.getString(&c.SyslogFacility, c.reSyslogFacility, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the Syslog tag.
+	case c.reSyslogTag.MatchString(line):
+		err = c.getString(&c.SyslogTag, c.reSyslogTag, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the base OID.
+	case c.reBaseOID.MatchString(line):
+		err = c.getString(&c.BaseOID, c.reBaseOID, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that includes other config files.
+	case c.reInclude.MatchString(line):
+		err = c.include(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines an alert rule.
+	case c.reAlert.MatchString(line):
+		err = c.getAlert(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines an user.
+	case c.reUserNameClass.MatchString(line):
+		err = c.getUserName(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines an user by IP addresses.
+	case c.reUserAddr.MatchString(line):
+		err = c.getUserAddr(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines an user by netfilter counters.
+	case c.reUserCounter.MatchString(line):
+		err = c.getUserCounter(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines an user by the IP address ranges counted by conntrack.
+	case c.reUserConntrack.MatchString(line):
+		err = c.getUserConntrack(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines how the interface totals are summed.
+	case c.reIfaceTotals.MatchString(line):
+		err = c.getString(&c.IfaceTotals, c.reIfaceTotals, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether xstats are parsed.
+	case c.reXstats.MatchString(line):
+		err = c.getBool(&c.Xstats, c.reXstats, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether the kernel counters of the interfaces are collected.
+	case c.reNetDev.MatchString(line):
+		err = c.getBool(&c.NetDev, c.reNetDev, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines whether the kernel counters of the interfaces are collected by the ip command.
+	case c.reLinkStats.MatchString(line):
+		err = c.getBool(&c.LinkStats, c.reLinkStats, lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines a blackout window.
+	case c.reBlackout.MatchString(line):
+		err = c.getBlackout(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines an interface group.
+	case c.reGroup.MatchString(line):
+		err = c.getGroup(lineNumber, line)
+		if err != nil {
+			return err
+		}
+
+	// Line that defines the number of exported parse warnings.
+	case c.reMaxWarnings.MatchString(line):
+		err = c.getInt(&c.MaxWarnings, c.reMaxWarnings, lineNumber, line)
+		if err != nil {
+			return err
+		}
 
-		// Ignore comments,
-		case c.reComment.MatchString(line):
-			continue
+	// Line that defines the packet size buckets.
+	case c.rePktSizeBuckets.MatchString(line):
+		err = c.getPktSizeBuckets(lineNumber, line)
+		if err != nil {
+			return err
+		}
 
-		// Line that defines path to the TC command.
-		case c.reTcCmdPath.MatchString(line):
-			err = c.getTcCmdPath(n, line)
-			if err != nil {
-				return err
-			}
+	// Line that defines the identity.
+	case c.reIdentity.MatchString(line):
+		err = c.getString(&c.Identity, c.reIdentity, lineNumber, line)
+		if err != nil {
+			return err
+		}
 
-		// Line that defines parse interval.
-		case c.reParseInterval.MatchString(line):
-			err = c.getParseInterval(lineNumber, line)
-			if err != nil {
-				return err
-			}
+	// Line that defines the labels.
+	case c.reLabels.MatchString(line):
+		err = c.getString(&c.Labels, c.reLabels, lineNumber, line)
+		if err != nil {
+			return err
+		}
 
-		// Line that defines TC parameters to get Qdisc statistics.
-		case c.reTcQdiscStats.MatchString(line):
-			err = c.getListOfStrings(&c.TcQdiscStats, c.reTcQdiscStats, lineNumber, line)
-			if err != nil {
-				return err
-			}
+	// Line that defines the name of the instance.
+	case c.reInstance.MatchString(line):
+		err = c.getString(&c.Instance, c.reInstance, lineNumber, line)
+		if err != nil {
+			return err
+		}
 
-		// Line that defines TC parameters to get Class statistics.
-		case c.reTcClassStats.MatchString(line):
-			err = c.getListOfStrings(&c.TcClassStats, c.reTcClassStats, lineNumber, line)
-			if err != nil {
-				return err
-			}
+	// Line that defines the directory the instance registers with the leader in.
+	case c.reLeaderDir.MatchString(line):
+		err = c.getString(&c.LeaderDir, c.reLeaderDir, lineNumber, line)
+		if err != nil {
+			return err
+		}
 
-		// Line that defines interfaces.
-		case c.reIfaces.MatchString(line):
-			err = c.getListOfStrings(&c.Ifaces, c.reIfaces, lineNumber, line)
-			if err != nil {
-				return err
-			}
+	// Line that defines whether interface names are encoded.
+	case c.reEncodeIfaceNames.MatchString(line):
+		err = c.getBool(&c.EncodeIfaceNames, c.reEncodeIfaceNames, lineNumber, line)
+		if err != nil {
+			return err
+		}
 
-		// Line that defines an user.
-		case c.reUserNameClass.MatchString(line):
-			err = c.getUserName(lineNumber, line)
-			if err != nil {
-				return err
-			}
+	// Line that defines debug.
+	case c.reDebug.MatchString(line):
+		err = c.getDebug(lineNumber, line)
+		if err != nil {
+			return err
+		}
 
-		// Line that defines debug.
-		case c.reDebug.MatchString(line):
-			err = c.getDebug(lineNumber, line)
-			if err != nil {
-				return err
-			}
+	// Line that defines an unknown option, e.g. one of a newer version, is ignored with a warning.
+	case c.reOption.MatchString(line) && !knownOption(c.reOption.FindStringSubmatch(line)[1]):
+		c.warnings = append(c.warnings, fmt.Sprintf("Warning in config file %s on line %d: ignoring the unknown option. Line: '%s'", c.filename, lineNumber, line))
 
-		// Any other line.
-		default:
-			return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, n, line)
+	// Any other line.
+	default:
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, n, line)
 
-		}
 	}
 	return nil
 }
 
+// configRepeatedOptions are the options that can be repeated, they are parsed into the fields not named after them.
+var configRepeatedOptions = map[string]bool{
+	"user":          true,
+	"userAddr":      true,
+	"userCounter":   true,
+	"userConntrack": true,
+	"quota":         true,
+	"alert":         true,
+	"blackout":      true,
+	"group":         true,
+}
+
+// knownOption returns true if the option is parsed into the field of the config named after it, or if it can be
+// repeated.
+func knownOption(option string) bool {
+	if configRepeatedOptions[option] {
+		return true
+	}
+	field, ok := reflect.TypeOf(config{}).FieldByName(strings.ToUpper(option[:1]) + option[1:])
+	return ok && field.IsExported()
+}
+
 // getTcCmdPath parses line that contains tcCmdPath.
 func (c *config) getTcCmdPath(lineNumber int, line string) error {
 	if c.TcCmdPath != "" {
@@ -223,11 +2142,24 @@ func (c *config) getParseInterval(lineNumber int, line string) error {
 	}
 	if match := c.reParseInterval.FindAllStringSubmatch(line, -1); match != nil {
 		matchSlice := match[0]
-		parseInterval, err := strconv.ParseInt(matchSlice[1], 10, 32)
-		if err != nil {
-			return fmt.Errorf("Error in config file %s on line %d: unable to parse the parseInterval value. Line: '%s', err: %s", c.filename, lineNumber, line, err)
+		var parseInterval time.Duration
+		if matchSlice[1] != emptyString {
+			// A number without a unit is the number of seconds.
+			seconds, err := strconv.ParseInt(matchSlice[1], 10, 32)
+			if err != nil {
+				return fmt.Errorf("Error in config file %s on line %d: unable to parse the parseInterval value. Line: '%s', err: %s", c.filename, lineNumber, line, err)
+			}
+			parseInterval = time.Duration(seconds) * time.Second
+		} else {
+			var err error
+			if parseInterval, err = time.ParseDuration(matchSlice[2]); err != nil {
+				return fmt.Errorf("Error in config file %s on line %d: unable to parse the parseInterval value. Line: '%s', err: %s", c.filename, lineNumber, line, err)
+			}
 		}
-		c.ParseInterval = int(parseInterval)
+		if parseInterval > 0 && parseInterval < minParseInterval {
+			return fmt.Errorf("Error in config file %s on line %d: the parseInterval must be at least %s. Line: '%s'", c.filename, lineNumber, minParseInterval, line)
+		}
+		c.ParseInterval = parseInterval
 	} else {
 		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
 	}
@@ -248,31 +2180,218 @@ func (c *config) getListOfStrings(target *[]string, re *regexp.Regexp, lineNumbe
 	return nil
 }
 
+// getString parses line that contains a single string.
+func (c *config) getString(target *string, re *regexp.Regexp, lineNumber int, line string) error {
+	if *target != "" {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	if match := re.FindAllStringSubmatch(line, -1); match != nil {
+		matchSlice := match[0]
+		*target = matchSlice[1]
+	} else {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	return nil
+}
+
+// getPattern parses line that contains a single RE, returns an error if it doesn't have all the named groups.
+func (c *config) getPattern(target *string, groups []string, re *regexp.Regexp, lineNumber int, line string) error {
+	if err := c.getString(target, re, lineNumber, line); err != nil {
+		return err
+	}
+	if err := validPattern(*target, groups); err != nil {
+		return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+	}
+	return nil
+}
+
+// getInt parses line that contains a single integer.
+func (c *config) getInt(target *int, re *regexp.Regexp, lineNumber int, line string) error {
+	if *target != 0 {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	if match := re.FindAllStringSubmatch(line, -1); match != nil {
+		matchSlice := match[0]
+		value, err := strconv.ParseInt(matchSlice[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: unable to parse the value. Line: '%s', err: %s", c.filename, lineNumber, line, err)
+		}
+		*target = int(value)
+	} else {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	return nil
+}
+
+// getDuration parses line that contains a duration, either a number of seconds or a quoted duration like "500ms", and
+// stores it in the target.
+func (c *config) getDuration(target *time.Duration, re *regexp.Regexp, lineNumber int, line string) error {
+	if *target != 0 {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	match := re.FindAllStringSubmatch(line, -1)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	matchSlice := match[0]
+	if matchSlice[1] != emptyString {
+		seconds, err := strconv.ParseInt(matchSlice[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: unable to parse the value. Line: '%s', err: %s", c.filename, lineNumber, line, err)
+		}
+		*target = time.Duration(seconds) * time.Second
+		return nil
+	}
+	value, err := time.ParseDuration(matchSlice[2])
+	if err != nil {
+		return fmt.Errorf("Error in config file %s on line %d: unable to parse the value. Line: '%s', err: %s", c.filename, lineNumber, line, err)
+	}
+	*target = value
+	return nil
+}
+
+// getBlackout parses line that contains a blackout window.
+func (c *config) getBlackout(lineNumber int, line string) error {
+	match := c.reBlackout.FindAllStringSubmatch(line, -1)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	window, err := parseBlackoutWindow(match[0][1])
+	if err != nil {
+		return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+	}
+	c.Blackouts = append(c.Blackouts, window)
+	return nil
+}
+
+// getAlert parses line that contains an alert rule.
+func (c *config) getAlert(lineNumber int, line string) error {
+	match := c.reAlert.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	rate := match[3] != ""
+	if match[6] != "" && !rate {
+		return fmt.Errorf("Error in config file %s on line %d: only a rate can be compared per second. Line: '%s'", c.filename, lineNumber, line)
+	}
+	rule, err := newAlertRule(match[1], match[2], rate, match[4], match[5], match[7], match[8])
+	if err != nil {
+		return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+	}
+	c.Alerts = append(c.Alerts, rule)
+	return nil
+}
+
+// getGroup parses line that contains an interface group definition.
+func (c *config) getGroup(lineNumber int, line string) error {
+	match := c.reGroup.FindAllStringSubmatch(line, -1)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	name := match[0][1]
+	if _, ok := c.Groups[name]; ok {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate definition of group %s. Line: '%s'", c.filename, lineNumber, name, line)
+	}
+	if c.Groups == nil {
+		c.Groups = make(map[string][]string)
+	}
+	c.Groups[name] = strings.Fields(match[0][2])
+	return nil
+}
+
+// getQuota parses line that contains the quota of an user.
+func (c *config) getQuota(lineNumber int, line string) error {
+	match := c.reQuota.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	name := match[1]
+	if _, ok := c.Quotas[name]; ok {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate definition of quota for user %s. Line: '%s'", c.filename, lineNumber, name, line)
+	}
+	limit, err := strconv.ParseInt(match[2], 10, 64)
+	if err != nil || limit == 0 {
+		return fmt.Errorf("Error in config file %s on line %d: invalid quota. Line: '%s'", c.filename, lineNumber, line)
+	}
+	if c.Quotas == nil {
+		c.Quotas = make(map[string]int64)
+	}
+	c.Quotas[name] = limit * quotaUnits[match[3]]
+	return nil
+}
+
+// getPktSizeBuckets parses line that contains pktSizeBuckets.
+func (c *config) getPktSizeBuckets(lineNumber int, line string) error {
+	if c.PktSizeBuckets != nil {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	match := c.rePktSizeBuckets.FindAllStringSubmatch(line, -1)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	var buckets []int
+	for _, field := range strings.Split(match[0][1], " ") {
+		value, err := strconv.ParseInt(field, 10, 32)
+		if err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: unable to parse the value. Line: '%s', err: %s", c.filename, lineNumber, line, err)
+		}
+		buckets = append(buckets, int(value))
+	}
+	if buckets[0] >= buckets[1] {
+		return fmt.Errorf("Error in config file %s on line %d: the small packet size must be lower than the large packet size. Line: '%s'", c.filename, lineNumber, line)
+	}
+	c.PktSizeBuckets = buckets
+	return nil
+}
+
+// getBool parses line that contains a single boolean.
+func (c *config) getBool(target *bool, re *regexp.Regexp, lineNumber int, line string) error {
+	if match := re.FindAllStringSubmatch(line, -1); match != nil {
+		matchSlice := match[0]
+		*target = matchSlice[1] == trueString
+	} else {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	return nil
+}
+
+// getOptionalBool parses line that contains a single boolean of an option that defaults to true.
+func (c *config) getOptionalBool(target **bool, re *regexp.Regexp, lineNumber int, line string) error {
+	var value bool
+	if err := c.getBool(&value, re, lineNumber, line); err != nil {
+		return err
+	}
+	*target = &value
+	return nil
+}
+
 // getUserName parses line that contains user name definition.
 func (c *config) getUserName(lineNumber int, line string) error {
 	if match := c.reUserNameClass.FindAllStringSubmatch(line, -1); match != nil {
 		matchSlice := match[0]
 		name := matchSlice[1]
-		uploadClass := matchSlice[2]
-		downloadClass := matchSlice[3]
-		// Is this a duplicate entry for this Class name ?
-		if _, ok := c.UserNameClass[uploadClass]; ok {
-			return fmt.Errorf("Error in config file %s on line %d: found duplicate definition of class %s. Line: '%s'", c.filename, lineNumber, uploadClass, line)
-		}
-		if _, ok := c.UserNameClass[downloadClass]; ok {
-			return fmt.Errorf("Error in config file %s on line %d: found duplicate definition of class %s. Line: '%s'", c.filename, lineNumber, downloadClass, line)
+		// Each direction can list multiple Classes separated by commas, their counters are summed.
+		classes := make(map[string]UserClass)
+		for direction, classList := range []string{UploadDirection: matchSlice[2], DownloadDirection: matchSlice[3]} {
+			for _, class := range strings.Split(classList, classSeparator) {
+				class = strings.TrimSpace(class)
+				// Is this a duplicate entry for this Class name ?
+				_, configured := c.UserNameClass[class]
+				if _, listed := classes[class]; configured || listed {
+					return fmt.Errorf("Error in config file %s on line %d: found duplicate definition of class %s. Line: '%s'", c.filename, lineNumber, class, line)
+				}
+				classes[class] = UserClass{
+					Direction: direction,
+					Name:      name,
+				}
+			}
 		}
 
 		if c.UserNameClass == nil {
-			c.UserNameClass = make(map[string]userClass)
-		}
-		c.UserNameClass[uploadClass] = userClass{
-			direction: uploadDirection,
-			name:      name,
+			c.UserNameClass = make(map[string]UserClass)
 		}
-		c.UserNameClass[downloadClass] = userClass{
-			direction: downloadDirection,
-			name:      name,
+		for class, user := range classes {
+			c.UserNameClass[class] = user
 		}
 	} else {
 		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
@@ -280,6 +2399,208 @@ func (c *config) getUserName(lineNumber int, line string) error {
 	return nil
 }
 
+// getUserAddr parses line that contains definition of an user by IP addresses.
+func (c *config) getUserAddr(lineNumber int, line string) error {
+	match := c.reUserAddr.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	name := match[1]
+	addrs := make(map[string]string)
+	for _, field := range strings.Split(match[2], classSeparator) {
+		ip := net.ParseIP(strings.TrimSpace(field))
+		if ip == nil {
+			return fmt.Errorf("Error in config file %s on line %d: invalid IP address '%s'. Line: '%s'", c.filename, lineNumber, strings.TrimSpace(field), line)
+		}
+		addr := ip.String()
+		// Is this a duplicate entry for this address ?
+		_, configured := c.AddrUsers[addr]
+		if _, listed := addrs[addr]; configured || listed {
+			return fmt.Errorf("Error in config file %s on line %d: found duplicate definition of address %s. Line: '%s'", c.filename, lineNumber, addr, line)
+		}
+		addrs[addr] = name
+	}
+
+	if c.AddrUsers == nil {
+		c.AddrUsers = make(map[string]string)
+	}
+	for addr, name := range addrs {
+		c.AddrUsers[addr] = name
+	}
+	return nil
+}
+
+// getUserCounter parses line that contains definition of an user by netfilter counters. Either direction can be empty.
+func (c *config) getUserCounter(lineNumber int, line string) error {
+	match := c.reUserCounter.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	name := match[1]
+	counters := make(map[string]UserClass)
+	for direction, counterList := range []string{UploadDirection: match[2], DownloadDirection: match[3]} {
+		if strings.TrimSpace(counterList) == "" {
+			continue
+		}
+		for _, field := range strings.Split(counterList, classSeparator) {
+			counter, err := parseCounterRef(strings.TrimSpace(field))
+			if err != nil {
+				return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+			}
+			ref := counter.key()
+			// Is this a duplicate entry for this counter ?
+			_, configured := c.CounterUsers[ref]
+			if _, listed := counters[ref]; configured || listed {
+				return fmt.Errorf("Error in config file %s on line %d: found duplicate definition of counter %s. Line: '%s'", c.filename, lineNumber, ref, line)
+			}
+			counters[ref] = UserClass{
+				Direction: direction,
+				Name:      name,
+			}
+		}
+	}
+	if len(counters) == 0 {
+		return fmt.Errorf("Error in config file %s on line %d: the user %s has no counters. Line: '%s'", c.filename, lineNumber, name, line)
+	}
+
+	if c.CounterUsers == nil {
+		c.CounterUsers = make(map[string]UserClass)
+	}
+	for ref, user := range counters {
+		c.CounterUsers[ref] = user
+	}
+	return nil
+}
+
+// getUserConntrack parses line that contains definition of an user by the IP address ranges counted by conntrack.
+func (c *config) getUserConntrack(lineNumber int, line string) error {
+	match := c.reUserConntrack.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	name := match[1]
+	ranges := make(map[string]string)
+	for _, field := range strings.Split(match[2], classSeparator) {
+		prefix, err := parseConntrackRange(strings.TrimSpace(field))
+		if err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: %s. Line: '%s'", c.filename, lineNumber, err, line)
+		}
+		addrs := prefix.String()
+		// Is this a duplicate entry for this range ?
+		_, configured := c.ConntrackUsers[addrs]
+		if _, listed := ranges[addrs]; configured || listed {
+			return fmt.Errorf("Error in config file %s on line %d: found duplicate definition of range %s. Line: '%s'", c.filename, lineNumber, addrs, line)
+		}
+		ranges[addrs] = name
+	}
+
+	if c.ConntrackUsers == nil {
+		c.ConntrackUsers = make(map[string]string)
+	}
+	for addrs, name := range ranges {
+		c.ConntrackUsers[addrs] = name
+	}
+	return nil
+}
+
+// getTrapUser parses line that contains the SNMPv3 user of the notifications.
+func (c *config) getTrapUser(lineNumber int, line string) error {
+	if c.TrapUser != nil {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	match := c.reTrapUser.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	for _, password := range []string{match[3], match[5]} {
+		if password != "" && len(password) < minPasswordLen {
+			return fmt.Errorf("Error in config file %s on line %d: the passwords must have at least %d characters. Line: '%s'", c.filename, lineNumber, minPasswordLen, line)
+		}
+	}
+	c.TrapUser = match[1:]
+	return nil
+}
+
+// getSampleMaxSize parses line that contains sampleMaxSize, the size can have the same units as the quotas.
+func (c *config) getSampleMaxSize(lineNumber int, line string) error {
+	if c.SampleMaxSize != 0 {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	match := c.reSampleMaxSize.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	size, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil || size == 0 {
+		return fmt.Errorf("Error in config file %s on line %d: invalid size. Line: '%s'", c.filename, lineNumber, line)
+	}
+	c.SampleMaxSize = size * quotaUnits[match[2]]
+	return nil
+}
+
+// getMqttUser parses line that contains the user and the password to authenticate to the MQTT broker.
+func (c *config) getMqttUser(lineNumber int, line string) error {
+	if c.MqttUser != "" {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	match := c.reMqttUser.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	c.MqttUser, c.MqttPassword = match[1], match[2]
+	return nil
+}
+
+// getTrapEngineID parses line that contains trapEngineID.
+func (c *config) getTrapEngineID(lineNumber int, line string) error {
+	if c.TrapEngineID != nil {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	match := c.reTrapEngineID.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	engineID, err := hex.DecodeString(match[1])
+	if err != nil {
+		return fmt.Errorf("Error in config file %s on line %d: unable to parse the value. Line: '%s', err: %s", c.filename, lineNumber, line, err)
+	}
+	c.TrapEngineID = engineID
+	return nil
+}
+
+// getRunAs parses line that contains the user and optionally the group tc_reader switches to.
+func (c *config) getRunAs(lineNumber int, line string) error {
+	if c.RunAs != "" {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	match := c.reRunAs.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	c.RunAs, c.RunAsGroup = match[1], match[2]
+	return nil
+}
+
+// getTrapContext parses line that contains trapContext.
+func (c *config) getTrapContext(lineNumber int, line string) error {
+	if c.TrapContext != "" {
+		return fmt.Errorf("Error in config file %s on line %d: found duplicate entry. Line: '%s'", c.filename, lineNumber, line)
+	}
+	match := c.reTrapContext.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("Error in config file %s on line %d: cannot parse this line: '%s'", c.filename, lineNumber, line)
+	}
+	if match[2] != "" {
+		engineID, err := hex.DecodeString(match[2])
+		if err != nil {
+			return fmt.Errorf("Error in config file %s on line %d: unable to parse the value. Line: '%s', err: %s", c.filename, lineNumber, line, err)
+		}
+		c.TrapContextEngineID = engineID
+	}
+	c.TrapContext = match[1]
+	return nil
+}
+
 // getDebug parses line that contains debug.
 func (c *config) getDebug(lineNumber int, line string) error {
 	if match := c.reDebug.FindAllStringSubmatch(line, -1); match != nil {
@@ -293,20 +2614,266 @@ func (c *config) getDebug(lineNumber int, line string) error {
 	return nil
 }
 
+// newConfig returns new config with compiled regular expressions that didn't read the config file yet.
+func newConfig(filename string) *config {
+	return &config{
+		filename:                 filename,
+		reComment:                regexp.MustCompile(reComment),
+		reEmpty:                  regexp.MustCompile(reEmpty),
+		reTcCmdPath:              regexp.MustCompile(reTcCmdPath),
+		reIpCmdPath:              regexp.MustCompile(reIpCmdPath),
+		reNftCmdPath:             regexp.MustCompile(reNftCmdPath),
+		reIptablesCmdPath:        regexp.MustCompile(reIptablesCmdPath),
+		reConntrackCmdPath:       regexp.MustCompile(reConntrackCmdPath),
+		reExecWrapper:            regexp.MustCompile(reExecWrapper),
+		reParseInterval:          regexp.MustCompile(reParseInterval),
+		reAlignCycles:            regexp.MustCompile(reAlignCycles),
+		reParseJitter:            regexp.MustCompile(reParseJitter),
+		reTcQdiscStats:           regexp.MustCompile(reTcQdiscStats),
+		reTcClassStats:           regexp.MustCompile(reTcClassStats),
+		reTcFilterShow:           regexp.MustCompile(reTcFilterShow),
+		reQdiscPattern:           regexp.MustCompile(reQdiscPattern),
+		reClassPattern:           regexp.MustCompile(reClassPattern),
+		reStatsPattern:           regexp.MustCompile(reStatsPattern),
+		reIfaces:                 regexp.MustCompile(reIfaces),
+		reDiscoveryInterval:      regexp.MustCompile(reDiscoveryInterval),
+		reMaxParallel:            regexp.MustCompile(reMaxParallel),
+		reMaxCommands:            regexp.MustCompile(reMaxCommands),
+		reCommandTimeout:         regexp.MustCompile(reCommandTimeout),
+		reSingleInvocation:       regexp.MustCompile(reSingleInvocation),
+		reIdlePause:              regexp.MustCompile(reIdlePause),
+		reMaxBackoff:             regexp.MustCompile(reMaxBackoff),
+		reMaxDataAge:             regexp.MustCompile(reMaxDataAge),
+		reRetention:              regexp.MustCompile(reRetention),
+		reExportGeneric:          regexp.MustCompile(reExportGeneric),
+		reExportUsers:            regexp.MustCompile(reExportUsers),
+		reExportDeltas:           regexp.MustCompile(reExportDeltas),
+		reExportMetrics:          regexp.MustCompile(reExportMetrics),
+		reRateSamples:            regexp.MustCompile(reRateSamples),
+		reCacheFile:              regexp.MustCompile(reCacheFile),
+		reQuotaFile:              regexp.MustCompile(reQuotaFile),
+		reQuota:                  regexp.MustCompile(reQuota),
+		reQuotaResetDay:          regexp.MustCompile(reQuotaResetDay),
+		reTrapTarget:             regexp.MustCompile(reTrapTarget),
+		reTrapVersion:            regexp.MustCompile(reTrapVersion),
+		reTrapCommunity:          regexp.MustCompile(reTrapCommunity),
+		reTrapUser:               regexp.MustCompile(reTrapUser),
+		reTrapEngineID:           regexp.MustCompile(reTrapEngineID),
+		reTrapContext:            regexp.MustCompile(reTrapContext),
+		reTrapDropRate:           regexp.MustCompile(reTrapDropRate),
+		reGraphiteTarget:         regexp.MustCompile(reGraphiteTarget),
+		reGraphitePath:           regexp.MustCompile(reGraphitePath),
+		reMqttBroker:             regexp.MustCompile(reMqttBroker),
+		reMqttTopic:              regexp.MustCompile(reMqttTopic),
+		reMqttRetain:             regexp.MustCompile(reMqttRetain),
+		reMqttTLS:                regexp.MustCompile(reMqttTLS),
+		reMqttCAFile:             regexp.MustCompile(reMqttCAFile),
+		reMqttUser:               regexp.MustCompile(reMqttUser),
+		reMqttClientID:           regexp.MustCompile(reMqttClientID),
+		reSampleFile:             regexp.MustCompile(reSampleFile),
+		reSampleFormat:           regexp.MustCompile(reSampleFormat),
+		reSampleMaxSize:          regexp.MustCompile(reSampleMaxSize),
+		reSampleFields:           regexp.MustCompile(reSampleFields),
+		reRrdDir:                 regexp.MustCompile(reRrdDir),
+		reRrdCmdPath:             regexp.MustCompile(reRrdCmdPath),
+		reZabbixServer:           regexp.MustCompile(reZabbixServer),
+		reZabbixHost:             regexp.MustCompile(reZabbixHost),
+		reZabbixKey:              regexp.MustCompile(reZabbixKey),
+		reHttpListen:             regexp.MustCompile(reHttpListen),
+		reGrpcListen:             regexp.MustCompile(reGrpcListen),
+		rePprofListen:            regexp.MustCompile(rePprofListen),
+		reRuntimeStats:           regexp.MustCompile(reRuntimeStats),
+		rePrometheusFile:         regexp.MustCompile(rePrometheusFile),
+		reUserNames:              regexp.MustCompile(reUserNames),
+		reUserNameKey:            regexp.MustCompile(reUserNameKey),
+		reHistoryRetention:       regexp.MustCompile(reHistoryRetention),
+		reAgentTarget:            regexp.MustCompile(reAgentTarget),
+		reAgentHost:              regexp.MustCompile(reAgentHost),
+		reAgentName:              regexp.MustCompile(reAgentName),
+		reAgentMaster:            regexp.MustCompile(reAgentMaster),
+		reAgentToken:             regexp.MustCompile(reAgentToken),
+		reAgentFullSync:          regexp.MustCompile(reAgentFullSync),
+		reAgentEnrollToken:       regexp.MustCompile(reAgentEnrollToken),
+		reAgentTokenFile:         regexp.MustCompile(reAgentTokenFile),
+		reAgentCAFile:            regexp.MustCompile(reAgentCAFile),
+		reAgentCertFile:          regexp.MustCompile(reAgentCertFile),
+		reAgentKeyFile:           regexp.MustCompile(reAgentKeyFile),
+		reAggregatorListen:       regexp.MustCompile(reAggregatorListen),
+		reAggregatorToken:        regexp.MustCompile(reAggregatorToken),
+		reAggregatorConflicts:    regexp.MustCompile(reAggregatorConflicts),
+		reAggregatorEnrollToken:  regexp.MustCompile(reAggregatorEnrollToken),
+		reAggregatorTokenFile:    regexp.MustCompile(reAggregatorTokenFile),
+		reAggregatorCertFile:     regexp.MustCompile(reAggregatorCertFile),
+		reAggregatorKeyFile:      regexp.MustCompile(reAggregatorKeyFile),
+		reAggregatorClientCAFile: regexp.MustCompile(reAggregatorClientCAFile),
+		reControlSocket:          regexp.MustCompile(reControlSocket),
+		reRunAs:                  regexp.MustCompile(reRunAs),
+		reLogTarget:              regexp.MustCompile(reLogTarget),
+		reLogLevel:               regexp.MustCompile(reLogLevel),
+		reSyslogFacility:         regexp.MustCompile(reSyslogFacility),
+		reSyslogTag:              regexp.MustCompile(reSyslogTag),
+		reBaseOID:                regexp.MustCompile(reBaseOID),
+		reInclude:                regexp.MustCompile(reInclude),
+		reOption:                 regexp.MustCompile(reOption),
+		reAlert:                  regexp.MustCompile(reAlert),
+		reUserNameClass:          regexp.MustCompile(reUserNameClass),
+		reUserAddr:               regexp.MustCompile(reUserAddr),
+		reUserCounter:            regexp.MustCompile(reUserCounter),
+		reUserConntrack:          regexp.MustCompile(reUserConntrack),
+		reIfaceTotals:            regexp.MustCompile(reIfaceTotals),
+		reXstats:                 regexp.MustCompile(reXstats),
+		reNetDev:                 regexp.MustCompile(reNetDev),
+		reLinkStats:              regexp.MustCompile(reLinkStats),
+		reBlackout:               regexp.MustCompile(reBlackout),
+		reGroup:                  regexp.MustCompile(reGroup),
+		reMaxWarnings:            regexp.MustCompile(reMaxWarnings),
+		rePktSizeBuckets:         regexp.MustCompile(rePktSizeBuckets),
+		reIdentity:               regexp.MustCompile(reIdentity),
+		reLabels:                 regexp.MustCompile(reLabels),
+		reInstance:               regexp.MustCompile(reInstance),
+		reLeaderDir:              regexp.MustCompile(reLeaderDir),
+		reEncodeIfaceNames:       regexp.MustCompile(reEncodeIfaceNames),
+		reDebug:                  regexp.MustCompile(reDebug),
+	}
+}
+
 // NewConfig returns new config.
 func NewConfig(filename string) (*config, error) {
-	c := &config{
-		filename:        filename,
-		reComment:       regexp.MustCompile(reComment),
-		reEmpty:         regexp.MustCompile(reEmpty),
-		reTcCmdPath:     regexp.MustCompile(reTcCmdPath),
-		reParseInterval: regexp.MustCompile(reParseInterval),
-		reTcQdiscStats:  regexp.MustCompile(reTcQdiscStats),
-		reTcClassStats:  regexp.MustCompile(reTcClassStats),
-		reIfaces:        regexp.MustCompile(reIfaces),
-		reUserNameClass: regexp.MustCompile(reUserNameClass),
-		reDebug:         regexp.MustCompile(reDebug),
-	}
+	c := newConfig(filename)
 	err := c.readConfig()
 	return c, err
 }
+
+// Info returns the description of the running configuration.
+func (c *config) Info() *ConfigInfo {
+	options := c.TcParserOptions()
+	users := make(map[string]bool)
+	for _, user := range c.UserNameClass {
+		users[user.Name] = true
+	}
+	for _, user := range c.AddrUsers {
+		users[user] = true
+	}
+	for _, user := range c.CounterUsers {
+		users[user.Name] = true
+	}
+	for _, user := range c.ConntrackUsers {
+		users[user] = true
+	}
+	info := &ConfigInfo{
+		ParseInterval: options.parseIntervalSeconds(),
+		Ifaces:        options.ifaces(),
+		Users:         len(users),
+		File:          c.filename,
+		Modified:      c.modified,
+	}
+	if c.digest != nil {
+		info.Checksum = hex.EncodeToString(c.digest.Sum(nil))
+	}
+	return info
+}
+
+// Warnings returns the problems found in the config files that didn't prevent reading them, e.g. the unknown options.
+func (c *config) Warnings() []string {
+	return c.warnings
+}
+
+// TcParserOptions returns the options of the tcParser configured by the config file.
+func (c *config) TcParserOptions() *TcParserOptions {
+	// Configure the Prometheus textfile.
+	var prometheus *PrometheusOptions
+	if c.PrometheusFile != "" {
+		prometheus = &PrometheusOptions{
+			File:     c.PrometheusFile,
+			Instance: c.Instance,
+		}
+	}
+	// Configure posting the parsed data to an aggregator.
+	var agent *AgentOptions
+	if c.AgentTarget != "" {
+		agent = &AgentOptions{
+			Target:      c.AgentTarget,
+			Host:        c.AgentHost,
+			Name:        c.AgentName,
+			Master:      c.AgentMaster,
+			Token:       c.AgentToken,
+			FullSync:    c.AgentFullSync,
+			EnrollToken: c.AgentEnrollToken,
+			TokenFile:   c.AgentTokenFile,
+			CAFile:      c.AgentCAFile,
+			CertFile:    c.AgentCertFile,
+			KeyFile:     c.AgentKeyFile,
+		}
+	}
+	// Configure the aggregator.
+	var aggregator *AggregatorOptions
+	if c.AggregatorListen != "" {
+		aggregator = &AggregatorOptions{
+			Listen:       c.AggregatorListen,
+			Token:        c.AggregatorToken,
+			EnrollToken:  c.AggregatorEnrollToken,
+			TokenFile:    c.AggregatorTokenFile,
+			CertFile:     c.AggregatorCertFile,
+			KeyFile:      c.AggregatorKeyFile,
+			ClientCAFile: c.AggregatorClientCAFile,
+			Conflicts:    c.AggregatorConflicts,
+		}
+	}
+	return &TcParserOptions{
+		TcCmdPath:         c.TcCmdPath,
+		IpCmdPath:         c.IpCmdPath,
+		NftCmdPath:        c.NftCmdPath,
+		IptablesCmdPath:   c.IptablesCmdPath,
+		ConntrackCmdPath:  c.ConntrackCmdPath,
+		ExecWrapper:       c.ExecWrapper,
+		ParseInterval:     c.ParseInterval,
+		AlignCycles:       c.AlignCycles,
+		ParseJitter:       c.ParseJitter,
+		TcQdiscStats:      c.TcQdiscStats,
+		TcClassStats:      c.TcClassStats,
+		TcFilterShow:      c.TcFilterShow,
+		QdiscPattern:      c.QdiscPattern,
+		ClassPattern:      c.ClassPattern,
+		StatsPattern:      c.StatsPattern,
+		Ifaces:            c.Ifaces,
+		DiscoveryInterval: c.DiscoveryInterval,
+		MaxParallel:       c.MaxParallel,
+		MaxCommands:       c.MaxCommands,
+		CommandTimeout:    c.CommandTimeout,
+		SingleInvocation:  c.SingleInvocation,
+		MaxBackoff:        c.MaxBackoff,
+		IdlePause:         c.IdlePause,
+		UserNameClass:     c.UserNameClass,
+		AddrUsers:         c.AddrUsers,
+		CounterUsers:      c.CounterUsers,
+		ConntrackUsers:    c.ConntrackUsers,
+		Groups:            c.Groups,
+		IfaceTotals:       c.IfaceTotals,
+		Blackouts:         c.Blackouts,
+		Xstats:            c.Xstats,
+		NetDev:            c.NetDev,
+		LinkStats:         c.LinkStats,
+		Prometheus:        prometheus,
+		Agent:             agent,
+		Aggregator:        aggregator,
+		UserNames:         c.UserNames,
+		UserNameKey:       c.UserNameKey,
+		EncodeIfaceNames:  c.EncodeIfaceNames,
+		Debug:             c.debug(),
+	}
+}
+
+// LogOptions returns the options of the Logger configured by the config file.
+func (c *config) LogOptions() *LogOptions {
+	return &LogOptions{
+		Target:   c.LogTarget,
+		Level:    c.LogLevel,
+		Tag:      c.SyslogTag,
+		Facility: c.SyslogFacility,
+	}
+}
+
+// debug returns whether the debug logging is on, either by the debug option or by the debug log level.
+func (c *config) debug() bool {
+	return c.Debug || c.LogLevel == logLevelDebug
+}
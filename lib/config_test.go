@@ -17,21 +17,30 @@ limitations under the License.
 package lib
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfig(t *testing.T) {
 	testData := []struct {
-		configFile            string
-		expectedErr           string
-		expectedTcCmdPath     string
-		expectedParseInterval int
-		expectedTcQdiscStats  []string
-		expectedTcClassStats  []string
-		expectedIfaces        []string
-		expectedUserNameClass map[string]userClass
-		expectedDebug         bool
+		configFile                string
+		expectedErr               string
+		expectedTcCmdPath         string
+		expectedParseInterval     time.Duration
+		expectedTcQdiscStats      []string
+		expectedTcClassStats      []string
+		expectedIfaces            []string
+		expectedDiscoveryInterval int
+		expectedUserNameClass     map[string]UserClass
+		expectedIdentity          string
+		expectedLabels            string
+		expectedDebug             bool
 	}{
 
 		// A test case with completely valid config file.
@@ -39,16 +48,19 @@ func TestConfig(t *testing.T) {
 			"testdata/config_valid",
 			"",
 			"/sbin/tc",
-			5,
+			5 * time.Second,
 			[]string{"-s", "qdisc", "show", "dev"},
 			[]string{"-s", "class", "show", "dev"},
 			[]string{"eth0", "eth1", "eth2"},
-			map[string]userClass{
-				"eth0:2:3": {uploadDirection, "user1"},
-				"eth0:2:4": {uploadDirection, "user2"},
-				"eth1:2:3": {downloadDirection, "user1"},
-				"eth1:2:4": {downloadDirection, "user2"},
+			30,
+			map[string]UserClass{
+				"eth0:2:3": {UploadDirection, "user1"},
+				"eth0:2:4": {UploadDirection, "user2"},
+				"eth1:2:3": {DownloadDirection, "user1"},
+				"eth1:2:4": {DownloadDirection, "user2"},
 			},
+			"tc_reader {version} on {hostname} ({labels})",
+			"site=ams1 role=edge",
 			true,
 		},
 
@@ -61,7 +73,10 @@ func TestConfig(t *testing.T) {
 			nil,
 			nil,
 			nil,
+			0,
 			nil,
+			"",
+			"",
 			false,
 		},
 
@@ -74,7 +89,10 @@ func TestConfig(t *testing.T) {
 			nil,
 			nil,
 			nil,
+			0,
 			nil,
+			"",
+			"",
 			false,
 		},
 
@@ -87,7 +105,10 @@ func TestConfig(t *testing.T) {
 			nil,
 			nil,
 			nil,
+			0,
 			nil,
+			"",
+			"",
 			false,
 		},
 
@@ -100,7 +121,73 @@ func TestConfig(t *testing.T) {
 			nil,
 			nil,
 			nil,
+			0,
 			nil,
+			"",
+			"",
+			false,
+		},
+
+		// A test case with config file in TOML.
+		{
+			"testdata/config_valid.toml",
+			"",
+			"/sbin/tc",
+			5 * time.Second,
+			[]string{"-s", "qdisc", "show", "dev"},
+			[]string{"-s", "class", "show", "dev"},
+			[]string{"eth0", "eth1", "eth2"},
+			30,
+			map[string]UserClass{
+				"eth0:2:3": {UploadDirection, "user1"},
+				"eth0:2:4": {UploadDirection, "user2"},
+				"eth1:2:3": {DownloadDirection, "user1"},
+				"eth1:2:4": {DownloadDirection, "user2"},
+			},
+			"tc_reader {version} on {hostname} ({labels})",
+			"site=ams1 role=edge",
+			true,
+		},
+
+		// A test case with config file that has fragments.
+		{
+			"testdata/config_fragments",
+			"",
+			"/sbin/tc",
+			5 * time.Second,
+			nil,
+			nil,
+			[]string{"eth0", "eth1"},
+			30,
+			map[string]UserClass{
+				"eth0:2:3": {UploadDirection, "user1"},
+				"eth0:2:4": {UploadDirection, "user2"},
+				"eth1:2:3": {DownloadDirection, "user1"},
+				"eth1:2:4": {DownloadDirection, "user2"},
+			},
+			"",
+			"",
+			false,
+		},
+
+		// A test case with the directory of the fragments.
+		{
+			"testdata/config_fragments.d",
+			"",
+			"",
+			0,
+			nil,
+			nil,
+			[]string{"eth0", "eth1"},
+			30,
+			map[string]UserClass{
+				"eth0:2:3": {UploadDirection, "user1"},
+				"eth0:2:4": {UploadDirection, "user2"},
+				"eth1:2:3": {DownloadDirection, "user1"},
+				"eth1:2:4": {DownloadDirection, "user2"},
+			},
+			"",
+			"",
 			false,
 		},
 	}
@@ -126,12 +213,922 @@ func TestConfig(t *testing.T) {
 			if !reflect.DeepEqual(c.Ifaces, params.expectedIfaces) {
 				t.Errorf("TestConfig(testCase %d) Ifaces got: '%v' want: '%v'", i, c.Ifaces, params.expectedIfaces)
 			}
+			if !reflect.DeepEqual(c.DiscoveryInterval, params.expectedDiscoveryInterval) {
+				t.Errorf("TestConfig(testCase %d) DiscoveryInterval got: '%v' want: '%v'", i, c.DiscoveryInterval, params.expectedDiscoveryInterval)
+			}
 			if !reflect.DeepEqual(c.UserNameClass, params.expectedUserNameClass) {
 				t.Errorf("TestConfig(testCase %d) UserNameClass \n got: '%v' \nwant: '%v'", i, c.UserNameClass, params.expectedUserNameClass)
 			}
+			if !reflect.DeepEqual(c.Identity, params.expectedIdentity) {
+				t.Errorf("TestConfig(testCase %d) Identity got: '%v' want: '%v'", i, c.Identity, params.expectedIdentity)
+			}
+			if !reflect.DeepEqual(c.Labels, params.expectedLabels) {
+				t.Errorf("TestConfig(testCase %d) Labels got: '%v' want: '%v'", i, c.Labels, params.expectedLabels)
+			}
 			if !reflect.DeepEqual(c.Debug, params.expectedDebug) {
 				t.Errorf("TestConfig(testCase %d) Debug got: '%v' want: '%v'", i, c.Debug, params.expectedDebug)
 			}
 		}
 	}
 }
+
+func TestConfigFragments(t *testing.T) {
+	testData := []struct {
+		desc    string
+		files   map[string]string
+		path    string
+		want    []string
+		wantErr string
+	}{
+		{
+			desc: "fragments without the config file",
+			files: map[string]string{
+				"tc_reader.conf.d/10-ifaces.conf": "ifaces = \"eth1\"",
+			},
+			path: "tc_reader.conf",
+			want: []string{"eth1"},
+		},
+		{
+			desc: "option set in the config file and in a fragment",
+			files: map[string]string{
+				"tc_reader.conf":                  "ifaces = \"eth0\"",
+				"tc_reader.conf.d/10-ifaces.conf": "ifaces = \"eth1\"",
+			},
+			path:    "tc_reader.conf",
+			wantErr: "Error in config file {dir}/tc_reader.conf.d/10-ifaces.conf on line 1: found duplicate entry. Line: 'ifaces = \"eth1\"'",
+		},
+		{
+			desc: "included files",
+			files: map[string]string{
+				"tc_reader.conf":                 "include = \"users.d/*.conf\"",
+				"users.d/10-ifaces.conf":         "ifaces = \"eth1\"",
+				"users.d/20-users.conf":          "user = \"user1\" \"eth1:2:3\" \"eth1:2:4\"",
+				"users.d/README":                 "ifaces = \"eth2\"",
+				"tc_reader.conf.d/30-user2.conf": "user = \"user2\" \"eth1:2:5\" \"eth1:2:6\"",
+			},
+			path: "tc_reader.conf",
+			want: []string{"eth1"},
+		},
+		{
+			desc: "include matching no files",
+			files: map[string]string{
+				"tc_reader.conf": "include = \"users.d/*.conf\"\nifaces = \"eth1\"",
+			},
+			path: "tc_reader.conf",
+			want: []string{"eth1"},
+		},
+		{
+			desc: "option set in the config file and in an included file",
+			files: map[string]string{
+				"tc_reader.conf": "ifaces = \"eth0\"\ninclude = \"ifaces.conf\"",
+				"ifaces.conf":    "ifaces = \"eth1\"",
+			},
+			path:    "tc_reader.conf",
+			wantErr: "Error in config file {dir}/ifaces.conf on line 1: found duplicate entry. Line: 'ifaces = \"eth1\"'",
+		},
+		{
+			desc: "file including itself",
+			files: map[string]string{
+				"tc_reader.conf": "include = \"*.conf\"",
+				"ifaces.conf":    "ifaces = \"eth1\"",
+			},
+			path:    "tc_reader.conf",
+			wantErr: "Error in config file {dir}/tc_reader.conf on line 1: {dir}/tc_reader.conf includes itself. Line: 'include = \"*.conf\"'",
+		},
+		{
+			desc: "empty directory",
+			files: map[string]string{
+				"tc_reader.conf.d/README": "",
+			},
+			path:    "tc_reader.conf.d",
+			wantErr: "the config directory {dir}/tc_reader.conf.d has no *.conf files",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("MkdirAll => unexpected error: %s", err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatalf("WriteFile => unexpected error: %s", err)
+				}
+			}
+			c, err := NewConfig(filepath.Join(dir, tc.path))
+			if tc.wantErr != "" {
+				if want := strings.ReplaceAll(tc.wantErr, "{dir}", dir); err == nil || err.Error() != want {
+					t.Fatalf("NewConfig => got error: %v, want: %s", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewConfig => unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(c.Ifaces, tc.want) {
+				t.Errorf("NewConfig => got ifaces: %q, want: %q", c.Ifaces, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigInfo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tc_reader.conf")
+	content := "ifaces = \"eth0 eth1\"\nuser = \"user1\" \"eth0:2:3\" \"eth1:2:3\"\nuserAddr = \"user2\" \"10.0.0.2\"\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile => unexpected error: %s", err)
+	}
+	modified := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatalf("Chtimes => unexpected error: %s", err)
+	}
+	c, err := NewConfig(path)
+	if err != nil {
+		t.Fatalf("NewConfig => unexpected error: %s", err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	want := &ConfigInfo{
+		ParseInterval: 5,
+		Ifaces:        []string{"eth0", "eth1"},
+		Users:         2,
+		File:          path,
+		Checksum:      hex.EncodeToString(sum[:]),
+		Modified:      modified,
+	}
+	got := c.Info()
+	got.Modified = got.Modified.UTC()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Info => got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestConfigParseConfig(t *testing.T) {
+	testData := []struct {
+		desc    string
+		content string
+		get     func(c *config) interface{}
+		want    interface{}
+		wantErr string
+	}{
+		{
+			desc:    "ipCmdPath is parsed",
+			content: "ipCmdPath = \"/usr/sbin/ip\"",
+			get:     func(c *config) interface{} { return c.IpCmdPath },
+			want:    "/usr/sbin/ip",
+		},
+		{
+			desc:    "user with a peer address",
+			content: "user = \"user1\" \"@10.0.0.5:1:10\" \"eth1:2:3\"",
+			get:     func(c *config) interface{} { return c.UserNameClass },
+			want: map[string]UserClass{
+				"@10.0.0.5:1:10": {UploadDirection, "user1"},
+				"eth1:2:3":       {DownloadDirection, "user1"},
+			},
+		},
+		{
+			desc:    "user with multiple classes per direction",
+			content: "user = \"user1\" \"eth0:1:10,eth0:1:11\" \"eth1:2:3, eth2:2:3\"",
+			get:     func(c *config) interface{} { return c.UserNameClass },
+			want: map[string]UserClass{
+				"eth0:1:10": {UploadDirection, "user1"},
+				"eth0:1:11": {UploadDirection, "user1"},
+				"eth1:2:3":  {DownloadDirection, "user1"},
+				"eth2:2:3":  {DownloadDirection, "user1"},
+			},
+		},
+		{
+			desc:    "user addresses are parsed",
+			content: "userAddr = \"user1\" \"10.0.0.5, 2001:DB8:0::5\"\nuserAddr = \"user2\" \"10.0.0.6\"",
+			get:     func(c *config) interface{} { return c.AddrUsers },
+			want: map[string]string{
+				"10.0.0.5":    "user1",
+				"2001:db8::5": "user1",
+				"10.0.0.6":    "user2",
+			},
+		},
+		{
+			desc:    "invalid user address",
+			content: "userAddr = \"user1\" \"10.0.0.300\"",
+			wantErr: "Error in config file test on line 1: invalid IP address '10.0.0.300'. Line: 'userAddr = \"user1\" \"10.0.0.300\"'",
+		},
+		{
+			desc:    "duplicate user address",
+			content: "userAddr = \"user1\" \"10.0.0.5\"\nuserAddr = \"user2\" \"10.0.0.5\"",
+			wantErr: "Error in config file test on line 2: found duplicate definition of address 10.0.0.5. Line: 'userAddr = \"user2\" \"10.0.0.5\"'",
+		},
+		{
+			desc:    "user counters are parsed",
+			content: "userCounter = \"user1\" \"nft:inet:filter:up1, ipt:mangle:ACCT:up1\" \"nft:inet:filter:down1\"\nuserCounter = \"user2\" \"\" \"ipt:mangle:ACCT:down2\"",
+			get:     func(c *config) interface{} { return c.CounterUsers },
+			want: map[string]UserClass{
+				"nft:inet:filter:up1":   {UploadDirection, "user1"},
+				"ipt:mangle:ACCT:up1":   {UploadDirection, "user1"},
+				"nft:inet:filter:down1": {DownloadDirection, "user1"},
+				"ipt:mangle:ACCT:down2": {DownloadDirection, "user2"},
+			},
+		},
+		{
+			desc:    "invalid user counter",
+			content: "userCounter = \"user1\" \"nft:inet:up1\" \"\"",
+			wantErr: "Error in config file test on line 1: invalid counter 'nft:inet:up1', want nft:family:table:name or ipt:table:chain:comment. Line: 'userCounter = \"user1\" \"nft:inet:up1\" \"\"'",
+		},
+		{
+			desc:    "duplicate user counter",
+			content: "userCounter = \"user1\" \"nft:inet:filter:up1\" \"\"\nuserCounter = \"user2\" \"\" \"nft:inet:filter:up1\"",
+			wantErr: "Error in config file test on line 2: found duplicate definition of counter nft:inet:filter:up1. Line: 'userCounter = \"user2\" \"\" \"nft:inet:filter:up1\"'",
+		},
+		{
+			desc:    "user without counters",
+			content: "userCounter = \"user1\" \"\" \" \"",
+			wantErr: "Error in config file test on line 1: the user user1 has no counters. Line: 'userCounter = \"user1\" \"\" \" \"'",
+		},
+		{
+			desc:    "user conntrack ranges are parsed",
+			content: "userConntrack = \"user1\" \"10.0.0.0/24, 10.0.1.7\"\nuserConntrack = \"user2\" \"2001:db8::1/64\"",
+			get:     func(c *config) interface{} { return c.ConntrackUsers },
+			want: map[string]string{
+				"10.0.0.0/24":   "user1",
+				"10.0.1.7/32":   "user1",
+				"2001:db8::/64": "user2",
+			},
+		},
+		{
+			desc:    "invalid user conntrack range",
+			content: "userConntrack = \"user1\" \"10.0.0.0/33\"",
+			wantErr: "Error in config file test on line 1: invalid IP address range '10.0.0.0/33'. Line: 'userConntrack = \"user1\" \"10.0.0.0/33\"'",
+		},
+		{
+			desc:    "duplicate user conntrack range",
+			content: "userConntrack = \"user1\" \"10.0.0.7\"\nuserConntrack = \"user2\" \"10.0.0.7/32\"",
+			wantErr: "Error in config file test on line 2: found duplicate definition of range 10.0.0.7/32. Line: 'userConntrack = \"user2\" \"10.0.0.7/32\"'",
+		},
+		{
+			desc:    "nftCmdPath and iptablesCmdPath are parsed",
+			content: "nftCmdPath = \"/sbin/nft\"\niptablesCmdPath = \"/sbin/iptables-legacy\"",
+			get:     func(c *config) interface{} { return []string{c.NftCmdPath, c.IptablesCmdPath} },
+			want:    []string{"/sbin/nft", "/sbin/iptables-legacy"},
+		},
+		{
+			desc:    "tcFilterShow is parsed",
+			content: "tcFilterShow = \"filter show dev\"",
+			get:     func(c *config) interface{} { return c.TcFilterShow },
+			want:    []string{"filter", "show", "dev"},
+		},
+		{
+			desc:    "class listed in both directions",
+			content: "user = \"user1\" \"eth0:1:10,eth1:2:3\" \"eth1:2:3\"",
+			wantErr: "Error in config file test on line 1: found duplicate definition of class eth1:2:3. Line: 'user = \"user1\" \"eth0:1:10,eth1:2:3\" \"eth1:2:3\"'",
+		},
+		{
+			desc:    "maxWarnings is parsed",
+			content: "maxWarnings = 25",
+			get:     func(c *config) interface{} { return c.MaxWarnings },
+			want:    25,
+		},
+		{
+			desc:    "duplicate maxWarnings",
+			content: "maxWarnings = 25\nmaxWarnings = 25",
+			wantErr: "Error in config file test on line 2: found duplicate entry. Line: 'maxWarnings = 25'",
+		},
+		{
+			desc:    "maxParallel is parsed",
+			content: "maxParallel = 8",
+			get:     func(c *config) interface{} { return c.MaxParallel },
+			want:    8,
+		},
+		{
+			desc:    "maxCommands is parsed",
+			content: "maxCommands = 2",
+			get:     func(c *config) interface{} { return c.MaxCommands },
+			want:    2,
+		},
+		{
+			desc:    "commandTimeout is parsed",
+			content: "commandTimeout = 3",
+			get:     func(c *config) interface{} { return c.CommandTimeout },
+			want:    3,
+		},
+		{
+			desc:    "singleInvocation is parsed",
+			content: "singleInvocation = true",
+			get:     func(c *config) interface{} { return c.SingleInvocation },
+			want:    true,
+		},
+		{
+			desc:    "idlePause is parsed",
+			content: "idlePause = 30",
+			get:     func(c *config) interface{} { return c.IdlePause },
+			want:    30,
+		},
+		{
+			desc:    "maxBackoff is parsed",
+			content: "maxBackoff = 600",
+			get:     func(c *config) interface{} { return c.MaxBackoff },
+			want:    600,
+		},
+		{
+			desc:    "maxDataAge is parsed",
+			content: "maxDataAge = 15",
+			get:     func(c *config) interface{} { return c.MaxDataAge },
+			want:    15,
+		},
+		{
+			desc:    "cacheFile is parsed",
+			content: "cacheFile = \"/var/lib/tc_reader/cache.json\"",
+			get:     func(c *config) interface{} { return c.CacheFile },
+			want:    "/var/lib/tc_reader/cache.json",
+		},
+		{
+			desc:    "duplicate cacheFile",
+			content: "cacheFile = \"a.json\"\ncacheFile = \"b.json\"",
+			wantErr: "Error in config file test on line 2: found duplicate entry. Line: 'cacheFile = \"b.json\"'",
+		},
+		{
+			desc:    "quotaFile is parsed",
+			content: "quotaFile = \"/var/lib/tc_reader/quota.json\"",
+			get:     func(c *config) interface{} { return c.QuotaFile },
+			want:    "/var/lib/tc_reader/quota.json",
+		},
+		{
+			desc:    "quotaResetDay is parsed",
+			content: "quotaResetDay = 15",
+			get:     func(c *config) interface{} { return c.QuotaResetDay },
+			want:    15,
+		},
+		{
+			desc:    "quotas are parsed",
+			content: "quota = \"user1\" \"100G\"\nquota = \"user2\" \"5000\"",
+			get:     func(c *config) interface{} { return c.Quotas },
+			want:    map[string]int64{"user1": 100000000000, "user2": 5000},
+		},
+		{
+			desc:    "duplicate quota",
+			content: "quota = \"user1\" \"100G\"\nquota = \"user1\" \"1T\"",
+			wantErr: "Error in config file test on line 2: found duplicate definition of quota for user user1. Line: 'quota = \"user1\" \"1T\"'",
+		},
+		{
+			desc:    "quotaResetDay out of range",
+			content: "quotaResetDay = 31",
+			wantErr: "Error in config file test on line 1: the quota reset day must be between 1 and 28. Line: 'quotaResetDay = 31'",
+		},
+		{
+			desc:    "trap options are parsed",
+			content: "trapTarget = \"nms:162\"\ntrapVersion = 2c\ntrapCommunity = \"secret\"\ntrapDropRate = 10",
+			get: func(c *config) interface{} {
+				return []interface{}{c.TrapTarget, c.TrapVersion, c.TrapCommunity, c.TrapDropRate}
+			},
+			want: []interface{}{"nms:162", "2c", "secret", 10},
+		},
+		{
+			desc:    "unsupported trapVersion",
+			content: "trapVersion = 1",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'trapVersion = 1'",
+		},
+		{
+			desc:    "graphite options are parsed",
+			content: "graphiteTarget = \"graphite:2003\"\ngraphitePath = \"tc.{host}.{iface}.{class}.bytes\"",
+			get: func(c *config) interface{} {
+				return []interface{}{c.GraphiteTarget, c.GraphitePath}
+			},
+			want: []interface{}{"graphite:2003", "tc.{host}.{iface}.{class}.bytes"},
+		},
+		{
+			desc:    "graphitePath with an unknown placeholder",
+			content: "graphitePath = \"tc.{hostname}.{metric}\"",
+			wantErr: "Error in config file test on line 1: unknown placeholder {hostname} in the metric path. Line: 'graphitePath = \"tc.{hostname}.{metric}\"'",
+		},
+		{
+			desc:    "mqtt options are parsed",
+			content: "mqttBroker = \"broker:8883\"\nmqttTopic = \"home/{class}/{metric}\"\nmqttRetain = true\nmqttTLS = true\nmqttCAFile = \"/etc/ca.pem\"\nmqttUser = \"tc\" \"secret\"\nmqttClientID = \"router\"",
+			get: func(c *config) interface{} {
+				return []interface{}{c.MqttBroker, c.MqttTopic, c.MqttRetain, c.MqttTLS, c.MqttCAFile, c.MqttUser, c.MqttPassword, c.MqttClientID}
+			},
+			want: []interface{}{"broker:8883", "home/{class}/{metric}", true, true, "/etc/ca.pem", "tc", "secret", "router"},
+		},
+		{
+			desc:    "mqttTopic with a wildcard",
+			content: "mqttTopic = \"home/+/{metric}\"",
+			wantErr: "Error in config file test on line 1: the topic must not contain the wildcards + and #. Line: 'mqttTopic = \"home/+/{metric}\"'",
+		},
+		{
+			desc:    "duplicate mqttUser",
+			content: "mqttUser = \"tc\" \"secret\"\nmqttUser = \"tc\" \"other\"",
+			wantErr: "Error in config file test on line 2: found duplicate entry. Line: 'mqttUser = \"tc\" \"other\"'",
+		},
+		{
+			desc:    "sample file options are parsed",
+			content: "sampleFile = \"/var/log/samples.json\"\nsampleFormat = json\nsampleMaxSize = 10M\nsampleFields = \"time name value\"",
+			get: func(c *config) interface{} {
+				return []interface{}{c.SampleFile, c.SampleFormat, c.SampleMaxSize, c.SampleFields}
+			},
+			want: []interface{}{"/var/log/samples.json", "json", int64(10000000), []string{"time", "name", "value"}},
+		},
+		{
+			desc:    "unknown sample field",
+			content: "sampleFields = \"time bytes\"",
+			wantErr: "Error in config file test on line 1: unknown sample field 'bytes'. Line: 'sampleFields = \"time bytes\"'",
+		},
+		{
+			desc:    "zero sampleMaxSize",
+			content: "sampleMaxSize = 0K",
+			wantErr: "Error in config file test on line 1: invalid size. Line: 'sampleMaxSize = 0K'",
+		},
+		{
+			desc:    "rrd options are parsed",
+			content: "rrdDir = \"/var/lib/rrd\"\nrrdCmdPath = \"/opt/bin/rrdtool\"",
+			get: func(c *config) interface{} {
+				return []interface{}{c.RrdDir, c.RrdCmdPath}
+			},
+			want: []interface{}{"/var/lib/rrd", "/opt/bin/rrdtool"},
+		},
+		{
+			desc:    "zabbix options are parsed",
+			content: "zabbixServer = \"zabbix\"\nzabbixHost = \"router\"\nzabbixKey = \"shaping.{metric}[{class}]\"",
+			get: func(c *config) interface{} {
+				return []interface{}{c.ZabbixServer, c.ZabbixHost, c.ZabbixKey}
+			},
+			want: []interface{}{"zabbix", "router", "shaping.{metric}[{class}]"},
+		},
+		{
+			desc:    "httpListen is parsed",
+			content: "httpListen = \"127.0.0.1:8080\"",
+			get:     func(c *config) interface{} { return c.HttpListen },
+			want:    "127.0.0.1:8080",
+		},
+		{
+			desc:    "grpcListen is parsed",
+			content: "grpcListen = \"127.0.0.1:9090\"",
+			get:     func(c *config) interface{} { return c.GrpcListen },
+			want:    "127.0.0.1:9090",
+		},
+		{
+			desc:    "pprof options are parsed",
+			content: "pprofListen = \"localhost:6060\"\nruntimeStats = true",
+			get: func(c *config) interface{} {
+				return []interface{}{c.PprofListen, c.RuntimeStats}
+			},
+			want: []interface{}{"localhost:6060", true},
+		},
+		{
+			desc:    "pprofListen isn't a loopback address",
+			content: "pprofListen = \"0.0.0.0:6060\"",
+			wantErr: "Error in config file test on line 1: the address must be a loopback one, got '0.0.0.0:6060'. Line: 'pprofListen = \"0.0.0.0:6060\"'",
+		},
+		{
+			desc:    "prometheusFile is parsed",
+			content: "prometheusFile = \"/var/lib/node_exporter/textfile/tc_reader.prom\"",
+			get:     func(c *config) interface{} { return c.PrometheusFile },
+			want:    "/var/lib/node_exporter/textfile/tc_reader.prom",
+		},
+		{
+			desc:    "agent options are parsed",
+			content: "agentTarget = \"http://aggregator:9100\"\nagentHost = \"cpe1\"\nagentToken = \"secret\"\nagentFullSync = 600",
+			get:     func(c *config) interface{} { return c.TcParserOptions().Agent },
+			want:    &AgentOptions{Target: "http://aggregator:9100", Host: "cpe1", Token: "secret", FullSync: 10 * time.Minute},
+		},
+		{
+			desc:    "agent authentication options are parsed",
+			content: "agentTarget = \"https://aggregator:9100\"\nagentEnrollToken = \"enroll\"\nagentTokenFile = \"/var/lib/tc_reader/agent.token\"\nagentCAFile = \"/etc/ca.pem\"\nagentCertFile = \"/etc/cpe1.pem\"\nagentKeyFile = \"/etc/cpe1.key\"",
+			get:     func(c *config) interface{} { return c.TcParserOptions().Agent },
+			want: &AgentOptions{
+				Target:      "https://aggregator:9100",
+				EnrollToken: "enroll",
+				TokenFile:   "/var/lib/tc_reader/agent.token",
+				CAFile:      "/etc/ca.pem",
+				CertFile:    "/etc/cpe1.pem",
+				KeyFile:     "/etc/cpe1.key",
+			},
+		},
+		{
+			desc:    "agent conflict options are parsed",
+			content: "agentTarget = \"http://aggregator:9100\"\nagentHost = \"site1\"\nagentName = \"router1\"\nagentMaster = true\naggregatorListen = \":9100\"\naggregatorConflicts = merge",
+			get: func(c *config) interface{} {
+				return []interface{}{c.TcParserOptions().Agent, c.TcParserOptions().Aggregator}
+			},
+			want: []interface{}{
+				&AgentOptions{Target: "http://aggregator:9100", Host: "site1", Name: "router1", Master: true},
+				&AggregatorOptions{Listen: ":9100", Conflicts: "merge"},
+			},
+		},
+		{
+			desc:    "agentTarget isn't a HTTP URL",
+			content: "agentTarget = \"aggregator:9100\"",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'agentTarget = \"aggregator:9100\"'",
+		},
+		{
+			desc:    "aggregator options are parsed",
+			content: "aggregatorListen = \":9100\"\naggregatorToken = \"secret\"",
+			get:     func(c *config) interface{} { return c.TcParserOptions().Aggregator },
+			want:    &AggregatorOptions{Listen: ":9100", Token: "secret"},
+		},
+		{
+			desc:    "aggregator authentication options are parsed",
+			content: "aggregatorListen = \":9100\"\naggregatorEnrollToken = \"enroll\"\naggregatorTokenFile = \"/var/lib/tc_reader/agents.json\"\naggregatorCertFile = \"/etc/aggregator.pem\"\naggregatorKeyFile = \"/etc/aggregator.key\"\naggregatorClientCAFile = \"/etc/agents-ca.pem\"",
+			get:     func(c *config) interface{} { return c.TcParserOptions().Aggregator },
+			want: &AggregatorOptions{
+				Listen:       ":9100",
+				EnrollToken:  "enroll",
+				TokenFile:    "/var/lib/tc_reader/agents.json",
+				CertFile:     "/etc/aggregator.pem",
+				KeyFile:      "/etc/aggregator.key",
+				ClientCAFile: "/etc/agents-ca.pem",
+			},
+		},
+		{
+			desc:    "instance options are parsed",
+			content: "instance = \"tenant1\"\nleaderDir = \"/run/tc_reader/instances\"\nprometheusFile = \"/tmp/tc_reader.prom\"",
+			get: func(c *config) interface{} {
+				return []interface{}{c.Instance, c.LeaderDir, c.TcParserOptions().Prometheus.Instance}
+			},
+			want: []interface{}{"tenant1", "/run/tc_reader/instances", "tenant1"},
+		},
+		{
+			desc:    "instance isn't a valid name",
+			content: "instance = \"tenant 1\"",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'instance = \"tenant 1\"'",
+		},
+		{
+			desc:    "controlSocket is parsed",
+			content: "controlSocket = \"/run/tc_reader.sock\"",
+			get:     func(c *config) interface{} { return c.ControlSocket },
+			want:    "/run/tc_reader.sock",
+		},
+		{
+			desc:    "logTarget is parsed",
+			content: "logTarget = \"file:/var/log/tc_reader.log\"",
+			get:     func(c *config) interface{} { return c.LogTarget },
+			want:    "file:/var/log/tc_reader.log",
+		},
+		{
+			desc:    "logTarget is unknown",
+			content: "logTarget = \"console\"",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'logTarget = \"console\"'",
+		},
+		{
+			desc:    "logLevel is parsed",
+			content: "logLevel = warning",
+			get:     func(c *config) interface{} { return c.LogLevel },
+			want:    "warning",
+		},
+		{
+			desc:    "debug logLevel turns on the debug logging",
+			content: "logLevel = debug",
+			get:     func(c *config) interface{} { return c.TcParserOptions().Debug },
+			want:    true,
+		},
+		{
+			desc:    "syslogFacility is parsed",
+			content: "syslogFacility = local3",
+			get:     func(c *config) interface{} { return c.LogOptions().Facility },
+			want:    "local3",
+		},
+		{
+			desc:    "syslogFacility is unknown",
+			content: "syslogFacility = local8",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'syslogFacility = local8'",
+		},
+		{
+			desc:    "syslogTag is parsed",
+			content: "syslogTag = \"tc_reader-wan\"",
+			get:     func(c *config) interface{} { return c.LogOptions().Tag },
+			want:    "tc_reader-wan",
+		},
+		{
+			desc:    "baseOID is parsed",
+			content: "baseOID = \".1.3.6.1.4.1.99999.1\"",
+			get:     func(c *config) interface{} { return c.BaseOID },
+			want:    ".1.3.6.1.4.1.99999.1",
+		},
+		{
+			desc:    "baseOID without the leading dot",
+			content: "baseOID = \"1.3.6.1.4.1.99999.1\"",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'baseOID = \"1.3.6.1.4.1.99999.1\"'",
+		},
+		{
+			desc:    "all the invalid lines are reported",
+			content: "parseInterval = often\nifaces = \"eth0\"\nmaxParallel = many",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'parseInterval = often'\nError in config file test on line 2: cannot parse this line: 'maxParallel = many'",
+		},
+		{
+			desc:    "unknown option is ignored with a warning",
+			content: "ifaces = \"eth0\"\ncolour = \"blue\"",
+			get:     func(c *config) interface{} { return append(c.Ifaces, c.Warnings()...) },
+			want:    []string{"eth0", "Warning in config file test on line 2: ignoring the unknown option. Line: 'colour = \"blue\"'"},
+		},
+		{
+			desc:    "unknown line that doesn't look like an option",
+			content: "colour blue",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'colour blue'",
+		},
+		{
+			desc:    "trapDropRate out of range",
+			content: "trapDropRate = 101",
+			wantErr: "Error in config file test on line 1: the drop rate must be between 1 and 100. Line: 'trapDropRate = 101'",
+		},
+		{
+			desc:    "trapUser with authentication and privacy",
+			content: "trapUser = \"tc_reader\" \"SHA\" \"authpassword\" \"AES\" \"privpassword\"",
+			get:     func(c *config) interface{} { return c.TrapUser },
+			want:    []string{"tc_reader", "SHA", "authpassword", "AES", "privpassword"},
+		},
+		{
+			desc:    "trapUser without authentication",
+			content: "trapUser = \"tc_reader\"",
+			get:     func(c *config) interface{} { return c.TrapUser },
+			want:    []string{"tc_reader", "", "", "", ""},
+		},
+		{
+			desc:    "trapUser with a short password",
+			content: "trapUser = \"tc_reader\" \"MD5\" \"short\"",
+			wantErr: "Error in config file test on line 1: the passwords must have at least 8 characters. Line: 'trapUser = \"tc_reader\" \"MD5\" \"short\"'",
+		},
+		{
+			desc:    "alerts are parsed",
+			content: "alert = \"droppedPkt\" \"eth0:2:*\" rate > 100/s \"syslog\"\nalert = \"userQuotaUsed\" \"*\" >= 90.5 \"exec\" \"/bin/hook\"",
+			get:     func(c *config) interface{} { return c.Alerts },
+			want: []alertRule{
+				{metric: "droppedPkt", pattern: "eth0:2:*", rate: true, comparison: ">", threshold: 100, action: "syslog"},
+				{metric: "userQuotaUsed", pattern: "*", comparison: ">=", threshold: 90.5, action: "exec", command: "/bin/hook"},
+			},
+		},
+		{
+			desc:    "alert on an unknown metric",
+			content: "alert = \"bogus\" \"*\" > 1 \"trap\"",
+			wantErr: "Error in config file test on line 1: unknown metric 'bogus'. Line: 'alert = \"bogus\" \"*\" > 1 \"trap\"'",
+		},
+		{
+			desc:    "alert per second without a rate",
+			content: "alert = \"sentBytes\" \"*\" > 1/s \"trap\"",
+			wantErr: "Error in config file test on line 1: only a rate can be compared per second. Line: 'alert = \"sentBytes\" \"*\" > 1/s \"trap\"'",
+		},
+		{
+			desc:    "alert with exec without a command",
+			content: "alert = \"sentBytes\" \"*\" > 1 \"exec\"",
+			wantErr: "Error in config file test on line 1: the exec action needs a command. Line: 'alert = \"sentBytes\" \"*\" > 1 \"exec\"'",
+		},
+		{
+			desc:    "trapEngineID is parsed",
+			content: "trapEngineID = \"0x800007e50474637264\"",
+			get:     func(c *config) interface{} { return c.TrapEngineID },
+			want:    []byte{0x80, 0x00, 0x07, 0xe5, 0x04, 0x74, 0x63, 0x72, 0x64},
+		},
+		{
+			desc:    "execWrapper is parsed",
+			content: "execWrapper = \"ip netns exec blue\"",
+			get:     func(c *config) interface{} { return c.ExecWrapper },
+			want:    []string{"ip", "netns", "exec", "blue"},
+		},
+		{
+			desc:    "runAs is parsed",
+			content: "runAs = \"tc_reader\"",
+			get:     func(c *config) interface{} { return []string{c.RunAs, c.RunAsGroup} },
+			want:    []string{"tc_reader", ""},
+		},
+		{
+			desc:    "runAs with a group is parsed",
+			content: "runAs = \"tc_reader\" \"snmp\"",
+			get:     func(c *config) interface{} { return []string{c.RunAs, c.RunAsGroup} },
+			want:    []string{"tc_reader", "snmp"},
+		},
+		{
+			desc:    "trapContext is parsed",
+			content: "trapContext = \"shapers\"",
+			get:     func(c *config) interface{} { return []interface{}{c.TrapContext, c.TrapContextEngineID} },
+			want:    []interface{}{"shapers", []byte(nil)},
+		},
+		{
+			desc:    "trapContext with a context engine ID is parsed",
+			content: "trapContext = \"shapers\" \"0x800007e504\"",
+			get:     func(c *config) interface{} { return []interface{}{c.TrapContext, c.TrapContextEngineID} },
+			want:    []interface{}{"shapers", []byte{0x80, 0x00, 0x07, 0xe5, 0x04}},
+		},
+		{
+			desc:    "duplicate trapContext",
+			content: "trapContext = \"shapers\"\ntrapContext = \"other\"",
+			wantErr: "Error in config file test on line 2: found duplicate entry. Line: 'trapContext = \"other\"'",
+		},
+		{
+			desc:    "rateSamples is parsed",
+			content: "rateSamples = 180",
+			get:     func(c *config) interface{} { return c.RateSamples },
+			want:    180,
+		},
+		{
+			desc:    "retention is parsed",
+			content: "retention = 3",
+			get:     func(c *config) interface{} { return c.Retention },
+			want:    3,
+		},
+		{
+			desc:    "exportGeneric is parsed",
+			content: "exportGeneric = false",
+			get:     func(c *config) interface{} { return *c.ExportGeneric },
+			want:    false,
+		},
+		{
+			desc:    "exportUsers is parsed",
+			content: "exportUsers = true",
+			get:     func(c *config) interface{} { return *c.ExportUsers },
+			want:    true,
+		},
+		{
+			desc:    "qdiscPattern is parsed",
+			content: "qdiscPattern = \"qdisc (?P<kind>[a-z-]+) (?P<qdiscHandle>[0-9a-f]+):\"",
+			get:     func(c *config) interface{} { return c.QdiscPattern },
+			want:    "qdisc (?P<kind>[a-z-]+) (?P<qdiscHandle>[0-9a-f]+):",
+		},
+		{
+			desc:    "classPattern without the classHandle group",
+			content: "classPattern = \"class [a-z]+ (?P<qdiscHandle>[0-9a-f]+):\"",
+			wantErr: "Error in config file test on line 1: the pattern 'class [a-z]+ (?P<qdiscHandle>[0-9a-f]+):' has no group named classHandle. Line: 'classPattern = \"class [a-z]+ (?P<qdiscHandle>[0-9a-f]+):\"'",
+		},
+		{
+			desc:    "statsPattern is parsed",
+			content: "statsPattern = \"Sent (?P<sentBytes>[0-9]+) bytes (?P<sentPkt>[0-9]+) pkt .dropped (?P<droppedPkt>[0-9]+), overlimits (?P<overLimitPkt>[0-9]+)\"",
+			get:     func(c *config) interface{} { return c.StatsPattern },
+			want:    "Sent (?P<sentBytes>[0-9]+) bytes (?P<sentPkt>[0-9]+) pkt .dropped (?P<droppedPkt>[0-9]+), overlimits (?P<overLimitPkt>[0-9]+)",
+		},
+		{
+			desc:    "exportMetrics is parsed",
+			content: "exportMetrics = \"sentBytes droppedPkt\"",
+			get:     func(c *config) interface{} { return c.ExportMetrics },
+			want:    []string{"sentBytes", "droppedPkt"},
+		},
+		{
+			desc:    "exportMetrics with an unknown metric",
+			content: "exportMetrics = \"sentBytes bogus\"",
+			wantErr: "Error in config file test on line 1: unknown metric 'bogus'. Line: 'exportMetrics = \"sentBytes bogus\"'",
+		},
+		{
+			desc:    "ifaceTotals is parsed",
+			content: "ifaceTotals = \"leaves\"",
+			get:     func(c *config) interface{} { return c.IfaceTotals },
+			want:    "leaves",
+		},
+		{
+			desc:    "xstats is parsed",
+			content: "xstats = true",
+			get:     func(c *config) interface{} { return c.Xstats },
+			want:    true,
+		},
+		{
+			desc:    "netDev is parsed",
+			content: "netDev = true",
+			get:     func(c *config) interface{} { return c.NetDev },
+			want:    true,
+		},
+		{
+			desc:    "linkStats is parsed",
+			content: "linkStats = true",
+			get:     func(c *config) interface{} { return c.LinkStats },
+			want:    true,
+		},
+		{
+			desc:    "parseInterval in seconds",
+			content: "parseInterval = 10",
+			get:     func(c *config) interface{} { return c.ParseInterval },
+			want:    10 * time.Second,
+		},
+		{
+			desc:    "sub-second parseInterval",
+			content: "parseInterval = \"500ms\"",
+			get:     func(c *config) interface{} { return c.ParseInterval },
+			want:    500 * time.Millisecond,
+		},
+		{
+			desc:    "parseInterval in minutes",
+			content: "parseInterval = \"2m\"",
+			get:     func(c *config) interface{} { return c.ParseInterval },
+			want:    2 * time.Minute,
+		},
+		{
+			desc:    "invalid parseInterval duration",
+			content: "parseInterval = \"2 minutes\"",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'parseInterval = \"2 minutes\"'",
+		},
+		{
+			desc:    "parseInterval with an unknown unit",
+			content: "parseInterval = \"2d\"",
+			wantErr: "Error in config file test on line 1: unable to parse the parseInterval value. Line: 'parseInterval = \"2d\"', err: time: unknown unit \"d\" in duration \"2d\"",
+		},
+		{
+			desc:    "too short parseInterval",
+			content: "parseInterval = \"10ms\"",
+			wantErr: "Error in config file test on line 1: the parseInterval must be at least 100ms. Line: 'parseInterval = \"10ms\"'",
+		},
+		{
+			desc:    "alignCycles and parseJitter are parsed",
+			content: "alignCycles = true\nparseJitter = \"750ms\"",
+			get:     func(c *config) interface{} { return []interface{}{c.AlignCycles, c.ParseJitter} },
+			want:    []interface{}{true, 750 * time.Millisecond},
+		},
+		{
+			desc:    "parseJitter in seconds",
+			content: "parseJitter = 2",
+			get:     func(c *config) interface{} { return c.ParseJitter },
+			want:    2 * time.Second,
+		},
+		{
+			desc:    "invalid parseJitter",
+			content: "parseJitter = \"1x\"",
+			wantErr: "Error in config file test on line 1: unable to parse the value. Line: 'parseJitter = \"1x\"', err: time: unknown unit \"x\" in duration \"1x\"",
+		},
+		{
+			desc:    "exportDeltas is parsed",
+			content: "exportDeltas = true",
+			get:     func(c *config) interface{} { return c.ExportDeltas },
+			want:    true,
+		},
+		{
+			desc:    "userNames and userNameKey are parsed",
+			content: "userNames = pseudonym\nuserNameKey = \"secret\"",
+			get:     func(c *config) interface{} { return []string{c.UserNames, c.UserNameKey} },
+			want:    []string{"pseudonym", "secret"},
+		},
+		{
+			desc:    "historyRetention is parsed",
+			content: "historyRetention = \"720h\"",
+			get:     func(c *config) interface{} { return c.HistoryRetention },
+			want:    720 * time.Hour,
+		},
+		{
+			desc:    "blackout windows are parsed",
+			content: "blackout = \"02:00-03:30\"\nblackout = \"23:45-00:15\"",
+			get:     func(c *config) interface{} { return c.Blackouts },
+			want:    []blackoutWindow{{120, 210}, {1425, 15}},
+		},
+		{
+			desc:    "invalid blackout window",
+			content: "blackout = \"02:00-25:00\"",
+			wantErr: "Error in config file test on line 1: invalid hour 25 in blackout window '02:00-25:00'. Line: 'blackout = \"02:00-25:00\"'",
+		},
+		{
+			desc:    "groups are parsed",
+			content: "group = \"wan\" \"ppp0 eth1\"\ngroup\t=\t\"lan\"\t\"eth2\"",
+			get:     func(c *config) interface{} { return c.Groups },
+			want: map[string][]string{
+				"wan": {"ppp0", "eth1"},
+				"lan": {"eth2"},
+			},
+		},
+		{
+			desc:    "duplicate group",
+			content: "group = \"wan\" \"ppp0\"\ngroup = \"wan\" \"eth1\"",
+			wantErr: "Error in config file test on line 2: found duplicate definition of group wan. Line: 'group = \"wan\" \"eth1\"'",
+		},
+		{
+			desc:    "encodeIfaceNames is parsed",
+			content: "encodeIfaceNames = true",
+			get:     func(c *config) interface{} { return c.EncodeIfaceNames },
+			want:    true,
+		},
+		{
+			desc:    "user with a VLAN interface",
+			content: "user = \"user1\" \"eth0.100:1:10\" \"eth1%2E200:2:3\"",
+			get:     func(c *config) interface{} { return c.UserNameClass },
+			want: map[string]UserClass{
+				"eth0.100:1:10":  {UploadDirection, "user1"},
+				"eth1%2E200:2:3": {DownloadDirection, "user1"},
+			},
+		},
+		{
+			desc:    "pktSizeBuckets is parsed",
+			content: "pktSizeBuckets = \"128 1024\"",
+			get:     func(c *config) interface{} { return c.PktSizeBuckets },
+			want:    []int{128, 1024},
+		},
+		{
+			desc:    "pktSizeBuckets are not ascending",
+			content: "pktSizeBuckets = \"1024 128\"",
+			wantErr: "Error in config file test on line 1: the small packet size must be lower than the large packet size. Line: 'pktSizeBuckets = \"1024 128\"'",
+		},
+		{
+			desc:    "pktSizeBuckets with a single value",
+			content: "pktSizeBuckets = \"128\"",
+			wantErr: "Error in config file test on line 0: cannot parse this line: 'pktSizeBuckets = \"128\"'",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := newConfig("test")
+			err := c.parseConfig(tc.content)
+			if err != nil {
+				if err.Error() != tc.wantErr {
+					t.Fatalf("parseConfig => unexpected err got: '%s', want: '%s'", err, tc.wantErr)
+				}
+				return
+			}
+			if tc.wantErr != "" {
+				t.Fatalf("parseConfig => got no error, want: '%s'", tc.wantErr)
+			}
+			if got := tc.get(c); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseConfig => got: '%v', want: '%v'", got, tc.want)
+			}
+		})
+	}
+}
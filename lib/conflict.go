@@ -0,0 +1,273 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+conflict.go resolves the hosts reported by several agents at the aggregator, e.g. by the two routers of a HA pair or
+by the old and the new router during a migration. The aggregator keeps the last report of each agent of a host and
+merges only one counter of every Qdisc / Class, user, group and interface of the host, so that the usage of the
+subscribers isn't counted twice. The policies are:
+prefer-master - The data of the preferred agent is merged, the data of the others is ignored.
+merge         - The data of all the agents is merged, the data reported by several agents is taken from the preferred
+                one. E.g. the interfaces of a host are split between two agents.
+reject        - The reports of the other agents are rejected by 409 Conflict until the agent reporting the host stops.
+
+The preferred agent is the one with agentMaster set, or the one that has reported the host for the longest time
+among several masters or none. Every conflict is added to the warnings of the parse cycle, e.g. "cpe1: reported by the
+agents r1, r2, using r1 (prefer-master)", and "tc_reader ctl agents" lists the agents of every host.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The policies of the hosts reported by several agents.
+const (
+	// conflictPreferMaster merges the data of the preferred agent.
+	conflictPreferMaster = "prefer-master"
+
+	// conflictMerge merges the data of all the agents, the preferred agent wins the data reported by several.
+	conflictMerge = "merge"
+
+	// conflictReject rejects the reports of the agents other than the one reporting the host.
+	conflictReject = "reject"
+)
+
+// The states of the agents listed by the agents command of the control socket.
+const (
+	agentStateUsed     = "used"
+	agentStateMerged   = "merged"
+	agentStateIgnored  = "ignored"
+	agentStateRejected = "rejected"
+)
+
+// agentConflict is a host reported by several agents in a parse cycle.
+type agentConflict struct {
+	// host is the name of the host.
+	host string
+
+	// policy is the policy that resolved the conflict.
+	policy string
+
+	// agents are the agents whose reports are kept, in the order of preference.
+	agents []string
+
+	// duplicates is the number of the data reported by several agents that were merged from the preferred one.
+	duplicates int
+
+	// rejected are the agents whose reports were rejected, sorted.
+	rejected []string
+}
+
+// String describes the conflict in the warnings.
+func (c *agentConflict) String() string {
+	switch c.policy {
+	case conflictMerge:
+		return fmt.Sprintf("%s: reported by the agents %s, merged %d duplicates from the preferred ones (%s)", c.host, strings.Join(c.agents, ", "), c.duplicates, c.policy)
+	case conflictReject:
+		return fmt.Sprintf("%s: reported by the agent %s, rejected %s (%s)", c.host, c.agents[0], strings.Join(c.rejected, ", "), c.policy)
+	}
+	return fmt.Sprintf("%s: reported by the agents %s, using %s (%s)", c.host, strings.Join(c.agents, ", "), c.agents[0], c.policy)
+}
+
+// agentState is an agent reporting a host, listed by the agents command of the control socket.
+type agentState struct {
+	// host is the name of the host.
+	host string
+
+	// agent is the name of the agent.
+	agent string
+
+	// master indicates that the agent is a master.
+	master bool
+
+	// received is the time of the last report.
+	received time.Time
+
+	// state is one of the agentState* constants.
+	state string
+}
+
+// validate returns an error if the options can't be used.
+func (o *AggregatorOptions) validate() error {
+	switch o.Conflicts {
+	case emptyString, conflictPreferMaster, conflictMerge, conflictReject:
+		return nil
+	}
+	return fmt.Errorf("the conflict policy must be %s, %s or %s, got '%s'", conflictPreferMaster, conflictMerge, conflictReject, o.Conflicts)
+}
+
+// conflicts returns the policy of the hosts reported by several agents.
+func (o *AggregatorOptions) conflicts() string {
+	if o.Conflicts == emptyString {
+		return conflictPreferMaster
+	}
+	return o.Conflicts
+}
+
+// preferred returns true if the report of an agent is preferred over the other one for the same host.
+func preferred(r, other *receivedReport) bool {
+	if r.report.Master != other.report.Master {
+		return r.report.Master
+	}
+	if !r.since.Equal(other.since) {
+		return r.since.Before(other.since)
+	}
+	return r.report.Agent < other.report.Agent
+}
+
+// incumbent returns the agent reporting the host of the source at the time whose reports reject those of the source,
+// empty if the source isn't rejected. mu must be held.
+func (a *aggregator) incumbent(source reportSource, now time.Time) string {
+	if a.options.conflicts() != conflictReject {
+		return emptyString
+	}
+	var incumbent *receivedReport
+	for other, received := range a.reports {
+		if other.host != source.host || other.agent == source.agent || received.stale(now) {
+			continue
+		}
+		if incumbent == nil || preferred(received, incumbent) {
+			incumbent = received
+		}
+	}
+	if incumbent == nil {
+		return emptyString
+	}
+	return incumbent.report.Agent
+}
+
+// removeStale removes the reports that are stale at the time. mu must be held.
+func (a *aggregator) removeStale(now time.Time) {
+	for source, received := range a.reports {
+		if received.stale(now) {
+			a.logger.Info(fmt.Sprintf("removeStale(): The agent %s stopped reporting %s, removing its data.", source.agent, source.host))
+			delete(a.reports, source)
+		}
+	}
+	for source, received := range a.rejected {
+		if received.stale(now) {
+			delete(a.rejected, source)
+		}
+	}
+}
+
+// byHost returns the reports of every host in the order of preference, and the sorted hosts. mu must be held.
+func (a *aggregator) byHost() ([]string, map[string][]*receivedReport) {
+	reports := make(map[string][]*receivedReport)
+	for source, received := range a.reports {
+		reports[source.host] = append(reports[source.host], received)
+	}
+	hosts := make([]string, 0, len(reports))
+	for host, received := range reports {
+		hosts = append(hosts, host)
+		sort.Slice(received, func(i, j int) bool { return preferred(received[i], received[j]) })
+	}
+	sort.Strings(hosts)
+	return hosts, reports
+}
+
+// rejectedAgents returns the sorted agents whose reports of the host were rejected. mu must be held.
+func (a *aggregator) rejectedAgents(host string) []string {
+	var agents []string
+	for source := range a.rejected {
+		if source.host == host {
+			agents = append(agents, source.agent)
+		}
+	}
+	sort.Strings(agents)
+	return agents
+}
+
+// current returns the report of every host that isn't stale sorted by the hosts, with the conflicts of the hosts
+// reported by several agents resolved by the policy. The stale reports are removed.
+func (a *aggregator) current(now time.Time) ([]*agentReport, []*agentConflict) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removeStale(now)
+	hosts, byHost := a.byHost()
+	reports := make([]*agentReport, 0, len(hosts))
+	var conflicts []*agentConflict
+	for _, host := range hosts {
+		received := byHost[host]
+		rejected := a.rejectedAgents(host)
+		if len(received) == 1 && len(rejected) == 0 {
+			reports = append(reports, received[0].report)
+			continue
+		}
+		conflict := &agentConflict{host: host, policy: a.options.conflicts(), rejected: rejected}
+		for _, r := range received {
+			conflict.agents = append(conflict.agents, r.report.Agent)
+		}
+		report := received[0].report
+		if conflict.policy == conflictMerge {
+			report, conflict.duplicates = mergeReports(received)
+		}
+		reports = append(reports, report)
+		conflicts = append(conflicts, conflict)
+	}
+	return reports, conflicts
+}
+
+// mergeReports returns the report with the data of all the reports of a host, in the order of preference, and the
+// number of the data reported by several agents. Only the data of the first report that has it is merged.
+func mergeReports(received []*receivedReport) (*agentReport, int) {
+	first := received[0].report
+	merged := &agentReport{Host: first.Host, Agent: first.Agent, Master: first.Master, Time: first.Time, Interval: first.Interval}
+	seen := make(map[agentKey]bool)
+	duplicates := 0
+	for _, r := range received {
+		for _, entry := range r.report.Data {
+			key := entry.key()
+			if seen[key] {
+				duplicates++
+				continue
+			}
+			seen[key] = true
+			merged.Data = append(merged.Data, entry)
+		}
+	}
+	return merged, duplicates
+}
+
+// agents returns the state of every agent at the time, sorted by the hosts in the order of preference.
+func (a *aggregator) agents(now time.Time) []agentState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removeStale(now)
+	hosts, byHost := a.byHost()
+	var states []agentState
+	for _, host := range hosts {
+		for i, r := range byHost[host] {
+			state := agentStateUsed
+			switch {
+			case i > 0 && a.options.conflicts() == conflictMerge:
+				state = agentStateMerged
+			case i > 0:
+				state = agentStateIgnored
+			}
+			states = append(states, agentState{host: host, agent: r.report.Agent, master: r.report.Master, received: r.received, state: state})
+		}
+		for _, agent := range a.rejectedAgents(host) {
+			r := a.rejected[reportSource{host: host, agent: agent}]
+			states = append(states, agentState{host: host, agent: agent, master: r.report.Master, received: r.received, state: agentStateRejected})
+		}
+	}
+	return states
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// addTestReport adds the report of the agent for the host, which started reporting it at since.
+func addTestReport(a *aggregator, host, agent string, master bool, since time.Time, data ...agentEntry) {
+	report := &agentReport{Host: host, Agent: agent, Master: master, Interval: 10, Data: data}
+	a.reports[reportSource{host, agent}] = &receivedReport{report: report, received: time.Now(), since: since}
+}
+
+func TestAggregatorConflicts(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	tests := []struct {
+		desc         string
+		policy       string
+		master       string
+		wantData     []agentEntry
+		wantConflict string
+	}{
+		{
+			desc:   "the longest reporting agent is preferred",
+			policy: conflictPreferMaster,
+			wantData: []agentEntry{
+				{Name: "eth0", Kind: agentKindIface, SentBytes: 100},
+				{Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 10},
+			},
+			wantConflict: "site1: reported by the agents r1, r2, using r1 (prefer-master)",
+		},
+		{
+			desc:   "the master is preferred",
+			master: "r2",
+			wantData: []agentEntry{
+				{Name: "eth1", Kind: agentKindIface, SentBytes: 300},
+				{Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 30},
+			},
+			wantConflict: "site1: reported by the agents r2, r1, using r2 (prefer-master)",
+		},
+		{
+			desc:   "the data is merged",
+			policy: conflictMerge,
+			master: "r2",
+			wantData: []agentEntry{
+				{Name: "eth1", Kind: agentKindIface, SentBytes: 300},
+				{Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 30},
+				{Name: "eth0", Kind: agentKindIface, SentBytes: 100},
+			},
+			wantConflict: "site1: reported by the agents r2, r1, merged 1 duplicates from the preferred ones (merge)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			a, _ := newTestAggregator(t, "")
+			a.options.Conflicts = tc.policy
+			addTestReport(a, "cpe1", "cpe1", false, start, agentEntry{Name: "eth0", Kind: agentKindIface, SentBytes: 1})
+			addTestReport(a, "site1", "r1", tc.master == "r1", start,
+				agentEntry{Name: "eth0", Kind: agentKindIface, SentBytes: 100},
+				agentEntry{Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 10})
+			addTestReport(a, "site1", "r2", tc.master == "r2", start.Add(time.Minute),
+				agentEntry{Name: "eth1", Kind: agentKindIface, SentBytes: 300},
+				agentEntry{Name: "john", Kind: agentKindUser, Direction: "down", SentBytes: 30})
+
+			reports, conflicts := a.current(time.Now())
+			if len(reports) != 2 || reports[0].Host != "cpe1" || reports[1].Host != "site1" {
+				t.Fatalf("current => got reports %v, want those of cpe1 and site1", reports)
+			}
+			if diff := pretty.Compare(tc.wantData, reports[1].Data); diff != "" {
+				t.Errorf("current => unexpected data of site1, diff(-want, +got):\n%s", diff)
+			}
+			if len(conflicts) != 1 || conflicts[0].String() != tc.wantConflict {
+				t.Fatalf("current => got conflicts %v, want '%s'", conflicts, tc.wantConflict)
+			}
+		})
+	}
+}
+
+func TestAggregatorRejectConflicts(t *testing.T) {
+	a, _ := newTestAggregator(t, "")
+	a.options.Conflicts = conflictReject
+	post := func(agent string) *httptest.ResponseRecorder {
+		body := `{"host": "site1", "agent": "` + agent + `", "interval": 10, "data": [{"name": "eth0", "kind": "iface", "sentBytes": 1}]}`
+		recorder := httptest.NewRecorder()
+		a.serveReport(recorder, httptest.NewRequest(http.MethodPost, agentPath, strings.NewReader(body)))
+		return recorder
+	}
+
+	if recorder := post("r1"); recorder.Code != http.StatusNoContent {
+		t.Fatalf("serveReport of r1 => got status %d, want %d", recorder.Code, http.StatusNoContent)
+	}
+	recorder := post("r2")
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("serveReport of r2 => got status %d, want %d", recorder.Code, http.StatusConflict)
+	}
+	if got, want := strings.TrimSpace(recorder.Body.String()), "the host site1 is reported by the agent r1"; got != want {
+		t.Errorf("serveReport of r2 => got body '%s', want '%s'", got, want)
+	}
+	reports, conflicts := a.current(time.Now())
+	if len(reports) != 1 || reports[0].Agent != "r1" {
+		t.Errorf("current => got reports %v, want the one of r1", reports)
+	}
+	want := "site1: reported by the agent r1, rejected r2 (reject)"
+	if len(conflicts) != 1 || conflicts[0].String() != want {
+		t.Errorf("current => got conflicts %v, want '%s'", conflicts, want)
+	}
+
+	// The agent r1 stopped reporting, r2 takes over.
+	a.reports[reportSource{"site1", "r1"}].received = time.Now().Add(-time.Minute)
+	if recorder := post("r2"); recorder.Code != http.StatusNoContent {
+		t.Fatalf("serveReport of r2 after r1 stopped => got status %d, want %d", recorder.Code, http.StatusNoContent)
+	}
+	reports, conflicts = a.current(time.Now())
+	if len(reports) != 1 || reports[0].Agent != "r2" || len(conflicts) != 0 {
+		t.Errorf("current after r1 stopped => got reports %v and conflicts %v, want the one of r2 without conflicts", reports, conflicts)
+	}
+}
+
+func TestAggregatorAgents(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	a, _ := newTestAggregator(t, "")
+	a.options.Conflicts = conflictMerge
+	addTestReport(a, "site1", "r1", false, start)
+	addTestReport(a, "site1", "r2", true, start.Add(time.Minute))
+	addTestReport(a, "cpe1", "cpe1", false, start)
+	a.rejected[reportSource{"site1", "r3"}] = &receivedReport{report: &agentReport{Host: "site1", Agent: "r3", Interval: 10}, received: time.Now()}
+
+	var got []string
+	for _, state := range a.agents(time.Now()) {
+		got = append(got, state.host+" "+state.agent+" "+state.state)
+	}
+	want := []string{"cpe1 cpe1 used", "site1 r2 used", "site1 r1 merged", "site1 r3 rejected"}
+	if diff := pretty.Compare(want, got); diff != "" {
+		t.Errorf("agents => unexpected states, diff(-want, +got):\n%s", diff)
+	}
+}
+
+func TestAggregatorOptionsValidate(t *testing.T) {
+	for _, policy := range []string{"", conflictPreferMaster, conflictMerge, conflictReject} {
+		if err := (&AggregatorOptions{Conflicts: policy}).validate(); err != nil {
+			t.Errorf("validate of '%s' => unexpected error: %s", policy, err)
+		}
+	}
+	want := "the conflict policy must be prefer-master, merge or reject, got 'sum'"
+	if err := (&AggregatorOptions{Conflicts: "sum"}).validate(); err == nil || err.Error() != want {
+		t.Errorf("validate of 'sum' => got error %v, want '%s'", err, want)
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+conntrack.go counts the traffic of the users from the byte and packet counters of the connections tracked by
+netfilter, as a cross-check of the counters of their Qdiscs / Classes when the TC classification is suspected to be
+wrong. The users are defined by the ranges of their IP addresses, the flows are listed in every parse cycle, right
+after the TC commands, by "conntrack -L", and by "conntrack -L -f ipv6" if some of the ranges are IPv6. The counters
+are only listed if the accounting of conntrack is enabled, i.e. sysctl net.netfilter.nf_conntrack_acct=1.
+
+Example of conntrack -L:
+tcp      6 431999 ESTABLISHED src=10.0.0.7 dst=198.51.100.1 sport=40000 dport=443 packets=10 bytes=1200 src=198.51.100.1 dst=203.0.113.1 sport=443 dport=40000 packets=20 bytes=30000 [ASSURED] mark=0 use=1
+
+The first tuple is the original direction of the flow, the second one the reply direction. A flow whose original
+source is in the range of an user was opened by the user, it is counted as uploaded in the original and downloaded in
+the reply direction. A flow whose reply source is in the range, e.g. after DNAT, was opened towards the user, it is
+counted the other way around. The most specific range of the address wins.
+
+The counters of a flow start from zero and vanish once the flow expires, so the increase of each flow since the
+previous parse cycle is added to monotonic totals of the users. The traffic of a flow after the last parse cycle
+before it expired isn't counted.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// conntrackCmdPath is the default path to the conntrack binary.
+const conntrackCmdPath = "/usr/sbin/conntrack"
+
+// conntrackRange is a range of IP addresses of an user.
+type conntrackRange struct {
+	// prefix are the addresses of the range.
+	prefix *net.IPNet
+
+	// user is the name of the user.
+	user string
+}
+
+// parseConntrackRange parses a range of IP addresses, either in the CIDR notation or a single address.
+func parseConntrackRange(field string) (*net.IPNet, error) {
+	if strings.Contains(field, "/") {
+		_, prefix, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address range '%s'", field)
+		}
+		return prefix, nil
+	}
+	ip := net.ParseIP(field)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address range '%s'", field)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// conntrackCmdPath returns the configured conntrackCmdPath, or the default one if it wasn't set.
+func (o *TcParserOptions) conntrackCmdPath() string {
+	if o != nil && o.ConntrackCmdPath != emptyString {
+		return o.ConntrackCmdPath
+	}
+	return conntrackCmdPath
+}
+
+// conntrackRanges returns the ranges of the ConntrackUsers, the most specific ones first.
+func (o *TcParserOptions) conntrackRanges() []conntrackRange {
+	var ranges []conntrackRange
+	for field, user := range o.ConntrackUsers {
+		prefix, err := parseConntrackRange(field)
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, conntrackRange{prefix: prefix, user: user})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		iOnes, _ := ranges[i].prefix.Mask.Size()
+		jOnes, _ := ranges[j].prefix.Mask.Size()
+		if iOnes != jOnes {
+			return iOnes > jOnes
+		}
+		return ranges[i].prefix.String() < ranges[j].prefix.String()
+	})
+	return ranges
+}
+
+// conntrackCommands returns the arguments of the commands that list the flows of the ConntrackUsers.
+func (o *TcParserOptions) conntrackCommands() [][]string {
+	commands := [][]string{{"-L"}}
+	for _, r := range o.conntrackRanges() {
+		if r.prefix.IP.To4() == nil {
+			return append(commands, []string{"-L", "-f", "ipv6"})
+		}
+	}
+	return commands
+}
+
+// conntrackUser returns the name of the user of the most specific range containing the address, empty if none does.
+func conntrackUser(ranges []conntrackRange, ip net.IP) string {
+	if ip == nil {
+		return emptyString
+	}
+	for _, r := range ranges {
+		if r.prefix.Contains(ip) {
+			return r.user
+		}
+	}
+	return emptyString
+}
+
+// conntrackFlow are the counters of a flow tracked by conntrack.
+type conntrackFlow struct {
+	// key identifies the flow, it is the protocol and the original tuple.
+	key string
+
+	// origSrc is the source address in the original direction.
+	origSrc net.IP
+
+	// replySrc is the source address in the reply direction.
+	replySrc net.IP
+
+	// orig are the counters of the original direction.
+	orig sample
+
+	// reply are the counters of the reply direction.
+	reply sample
+}
+
+// parseConntrack parses the output of "conntrack -L". The flows without counters are skipped, their number is returned.
+func parseConntrack(output io.Reader) ([]conntrackFlow, int, error) {
+	var flows []conntrackFlow
+	var skipped int
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		flow := conntrackFlow{}
+		key := []string{fields[0]}
+		var tuple int
+		var counted bool
+		for _, field := range fields[1:] {
+			name, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			if name == "src" {
+				tuple++
+			}
+			counters := &flow.orig
+			if tuple > 1 {
+				counters = &flow.reply
+			}
+			switch name {
+			case "src":
+				if tuple == 1 {
+					flow.origSrc = net.ParseIP(value)
+				} else if tuple == 2 {
+					flow.replySrc = net.ParseIP(value)
+				}
+			case "packets", "bytes":
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, 0, fmt.Errorf("invalid %s in line '%s'", name, scanner.Text())
+				}
+				if name == "packets" {
+					counters.pkt = n
+				} else {
+					counters.bytes = n
+				}
+				counted = true
+				continue
+			}
+			if tuple == 1 && !counted {
+				key = append(key, field)
+			}
+		}
+		if !counted {
+			skipped++
+			continue
+		}
+		flow.key = strings.Join(key, " ")
+		flows = append(flows, flow)
+	}
+	return flows, skipped, scanner.Err()
+}
+
+// collectConntrack lists the flows and adds their increase since the previous parse cycle to the totals of the
+// ConntrackUsers. Returns the totals, or nil if the flows couldn't be listed. The failures don't fail the parse cycle.
+func (t *tcParser) collectConntrack() map[UserClass]sample {
+	if len(t.options.ConntrackUsers) == 0 {
+		return nil
+	}
+	var flows []conntrackFlow
+	for _, args := range t.options.conntrackCommands() {
+		output, err := t.execute(t.options.conntrackCmdPath(), args...)
+		var listed []conntrackFlow
+		var skipped int
+		if err == nil {
+			listed, skipped, err = parseConntrack(output)
+		}
+		if err != nil {
+			t.logger.Err(fmt.Sprintf("collectConntrack(): Unable to list the flows by %s %s, error: %s", t.options.conntrackCmdPath(), strings.Join(args, " "), err))
+			return nil
+		}
+		if skipped > 0 {
+			t.logIfDebug(fmt.Sprintf("collectConntrack(): %d flows have no counters, is net.netfilter.nf_conntrack_acct enabled?", skipped))
+		}
+		flows = append(flows, listed...)
+	}
+
+	// The totals of the users that are no longer configured are dropped.
+	totals := make(map[UserClass]sample)
+	for _, user := range t.options.ConntrackUsers {
+		for _, direction := range []int{UploadDirection, DownloadDirection} {
+			key := UserClass{Direction: direction, Name: user}
+			totals[key] = t.conntrackTotals[key]
+		}
+	}
+	t.conntrackTotals = totals
+	ranges := t.options.conntrackRanges()
+	previous := t.conntrackFlows
+	t.conntrackFlows = make(map[string][2]sample, len(flows))
+	for _, flow := range flows {
+		last := previous[flow.key]
+		current := [2]sample{flow.orig, flow.reply}
+		t.conntrackFlows[flow.key] = current
+		up, down := conntrackDelta(last[0], current[0]), conntrackDelta(last[1], current[1])
+		user := conntrackUser(ranges, flow.origSrc)
+		if user == emptyString {
+			if user = conntrackUser(ranges, flow.replySrc); user == emptyString {
+				continue
+			}
+			up, down = down, up
+		}
+		upload, download := UserClass{Direction: UploadDirection, Name: user}, UserClass{Direction: DownloadDirection, Name: user}
+		t.conntrackTotals[upload] = t.conntrackTotals[upload].add(up)
+		t.conntrackTotals[download] = t.conntrackTotals[download].add(down)
+	}
+	return t.conntrackTotals
+}
+
+// conntrackDelta returns the increase of the counters of a flow since the previous parse cycle. Decreased counters
+// belong to a new flow with the same tuple, these are counted from zero.
+func conntrackDelta(previous, current sample) sample {
+	if current.bytes < previous.bytes || current.pkt < previous.pkt {
+		return current
+	}
+	return current.sub(previous)
+}
+
+// addConntrackUsers adds the conntrack totals of the users sorted by their names and directions, none are added if
+// the flows weren't listed in this parse cycle.
+func (t *tcParser) addConntrackUsers(totals map[UserClass]sample) {
+	users := make([]UserClass, 0, len(totals))
+	for user := range totals {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name != users[j].Name {
+			return users[i].Name < users[j].Name
+		}
+		return users[i].Direction < users[j].Direction
+	})
+	for _, user := range users {
+		user := user
+		total := totals[user]
+		t.sink.addConntrackData(&parsedData{
+			name:      user.Name,
+			sentBytes: total.bytes,
+			sentPkt:   total.pkt,
+			userClass: &user,
+			monotonic: t.cycleMonotonic,
+		})
+	}
+}
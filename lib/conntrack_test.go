@@ -0,0 +1,110 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// conntrackContent is the output of "conntrack -L" used in the tests.
+const conntrackContent = `tcp      6 431999 ESTABLISHED src=10.0.0.7 dst=198.51.100.1 sport=40000 dport=443 packets=10 bytes=1200 src=198.51.100.1 dst=203.0.113.1 sport=443 dport=40000 packets=20 bytes=30000 [ASSURED] mark=0 use=1
+udp      17 25 src=198.51.100.2 dst=203.0.113.1 sport=5000 dport=53 packets=1 bytes=70 src=10.0.1.5 dst=198.51.100.2 sport=53 dport=5000 packets=1 bytes=150 mark=0 use=1
+icmp     1 29 src=10.0.2.9 dst=198.51.100.3 type=8 code=0 id=7 packets=2 bytes=168 src=198.51.100.3 dst=10.0.2.9 type=0 code=0 id=7 packets=2 bytes=168 mark=0 use=1
+tcp      6 118 SYN_SENT src=10.0.0.8 dst=198.51.100.1 sport=40001 dport=80 [UNREPLIED] src=198.51.100.1 dst=203.0.113.1 sport=80 dport=40001 mark=0 use=1
+`
+
+func TestParseConntrack(t *testing.T) {
+	flows, skipped, err := parseConntrack(strings.NewReader(conntrackContent))
+	if err != nil {
+		t.Fatalf("parseConntrack => unexpected error: %v", err)
+	}
+	if skipped != 1 {
+		t.Errorf("parseConntrack => skipped: %d flows, want: 1", skipped)
+	}
+	var keys []string
+	for _, flow := range flows {
+		keys = append(keys, flow.key)
+	}
+	wantKeys := []string{
+		"tcp src=10.0.0.7 dst=198.51.100.1 sport=40000 dport=443",
+		"udp src=198.51.100.2 dst=203.0.113.1 sport=5000 dport=53",
+		"icmp src=10.0.2.9 dst=198.51.100.3 type=8 code=0 id=7",
+	}
+	if diff := pretty.Compare(wantKeys, keys); diff != "" {
+		t.Errorf("parseConntrack => unexpected flows, diff (-want, +got):\n%s", diff)
+	}
+	if got := flows[1]; got.origSrc.String() != "198.51.100.2" || got.replySrc.String() != "10.0.1.5" || got.orig != (sample{bytes: 70, pkt: 1}) || got.reply != (sample{bytes: 150, pkt: 1}) {
+		t.Errorf("parseConntrack => got flow: %+v, want the addresses and the counters of both directions", got)
+	}
+
+	if _, _, err := parseConntrack(strings.NewReader("tcp 6 10 src=10.0.0.7 packets=many bytes=1\n")); err == nil {
+		t.Errorf("parseConntrack => got no error for invalid counters")
+	}
+}
+
+func TestTcParserCollectConntrack(t *testing.T) {
+	second := `tcp      6 431999 ESTABLISHED src=10.0.0.7 dst=198.51.100.1 sport=40000 dport=443 packets=15 bytes=1700 src=198.51.100.1 dst=203.0.113.1 sport=443 dport=40000 packets=30 bytes=45000 [ASSURED] mark=0 use=1
+udp      17 25 src=198.51.100.2 dst=203.0.113.1 sport=5000 dport=53 packets=1 bytes=60 src=10.0.1.5 dst=198.51.100.2 sport=53 dport=5000 packets=1 bytes=100 mark=0 use=1
+`
+	executer := &fakeExecuter{
+		output: []string{conntrackContent, "", "", second, ""},
+		err:    []error{nil, nil, errors.New("conntrack failed"), nil, nil},
+	}
+	logger := &fakeSyslog{}
+	fs := &fakeDataSink{}
+	p := &tcParser{
+		logger:   logger,
+		executer: executer,
+		sink:     fs,
+		options: &TcParserOptions{
+			ConntrackCmdPath: "/sbin/conntrack",
+			ConntrackUsers: map[string]string{
+				"10.0.0.0/16":   "user1",
+				"10.0.1.0/24":   "user2",
+				"2001:db8::/64": "user3",
+			},
+		},
+	}
+	p.collectConntrack()
+	if totals := p.collectConntrack(); totals != nil {
+		t.Errorf("collectConntrack => got totals %v after conntrack failed, want nil", totals)
+	}
+	p.addConntrackUsers(p.collectConntrack())
+
+	want := []parsedData{
+		{name: "user1", sentBytes: 1868, sentPkt: 17, userClass: &UserClass{UploadDirection, "user1"}},
+		{name: "user1", sentBytes: 45168, sentPkt: 32, userClass: &UserClass{DownloadDirection, "user1"}},
+		{name: "user2", sentBytes: 250, sentPkt: 2, userClass: &UserClass{UploadDirection, "user2"}},
+		{name: "user2", sentBytes: 130, sentPkt: 2, userClass: &UserClass{DownloadDirection, "user2"}},
+		{name: "user3", userClass: &UserClass{UploadDirection, "user3"}},
+		{name: "user3", userClass: &UserClass{DownloadDirection, "user3"}},
+	}
+	if diff := pretty.Compare(want, fs.conntrack); diff != "" {
+		t.Errorf("addConntrackUsers => unexpected data, diff (-want, +got):\n%s", diff)
+	}
+	wantArgs := [][]string{{"-L"}, {"-L", "-f", "ipv6"}, {"-L"}, {"-L"}, {"-L", "-f", "ipv6"}}
+	if diff := pretty.Compare(wantArgs, executer.args); diff != "" {
+		t.Errorf("collectConntrack => unexpected arguments, diff (-want, +got):\n%s", diff)
+	}
+	if len(logger.err) != 1 {
+		t.Errorf("collectConntrack => logged errors: %q, want one for the failed command", logger.err)
+	}
+}
@@ -0,0 +1,362 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+control.go serves the control socket, a Unix socket that accepts commands to the running tc_reader.
+
+The client sends a single line with the command and its arguments, e.g. "set-debug on". The server answers with
+"OK" or "ERROR <message>" on the first line, followed by the output of the command, and closes the connection.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The responses of the control socket.
+const (
+	// controlOk starts the response to a successful command.
+	controlOk = "OK"
+
+	// controlError starts the response to a failed command, it is followed by the error message.
+	controlError = "ERROR "
+)
+
+// controlTimeout is the time limit of a command, including the parse cycle run by flush and reload.
+const controlTimeout = time.Minute
+
+// controlSocketMode are the permissions of the control socket, only its owner can send commands.
+const controlSocketMode = 0600
+
+// controlCommands describes the commands, keyed by their names.
+var controlCommands = map[string]string{
+	"status":    "Prints the state of the collection.",
+	"reload":    "Reads the configuration file again and applies the options of the TC parser and the debug option.",
+	"flush":     "Runs a parse cycle now.",
+	"dump":      "Prints the exported SNMP data.",
+	"agents":    "Prints the agents reporting to the aggregator and how the hosts reported by several are resolved.",
+	"set-debug": "Turns the debug logging on or off until the next reload, takes \"on\" or \"off\".",
+	"help":      "Prints the commands.",
+}
+
+// ControlOptions are the options of the control socket.
+type ControlOptions struct {
+	// Socket is the path of the Unix socket, e.g. "/run/tc_reader.sock".
+	Socket string
+
+	// Reload reads the configuration again and applies it, it runs the reload command. The reload command fails if
+	// this isn't set.
+	Reload func() error
+}
+
+// debugSwitch is the Debug option that can be turned on or off at runtime.
+type debugSwitch struct {
+	// configured is the value of the Debug option.
+	configured atomic.Bool
+
+	// override is the value set at runtime, nil if the configured value applies.
+	override atomic.Pointer[bool]
+}
+
+// enabled returns the value set at runtime, or the configured one if none was set.
+func (d *debugSwitch) enabled() bool {
+	if override := d.override.Load(); override != nil {
+		return *override
+	}
+	return d.configured.Load()
+}
+
+// configure sets the value of the Debug option and drops the value set at runtime.
+func (d *debugSwitch) configure(on bool) {
+	d.configured.Store(on)
+	d.override.Store(nil)
+}
+
+// set overrides the configured value.
+func (d *debugSwitch) set(on bool) {
+	d.override.Store(&on)
+}
+
+// controlServer serves the control socket.
+type controlServer struct {
+	// options holds the configurable options.
+	options *ControlOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// snmp is the SNMP handler whose data is inspected.
+	snmp *snmp
+
+	// parser is the tcParser that is controlled.
+	parser *tcParser
+
+	// start is the time the server was created, the uptime is measured from it.
+	start time.Time
+
+	// listener accepts the connections.
+	listener net.Listener
+
+	// running waits for the connections being served.
+	running sync.WaitGroup
+}
+
+// NewControlServer starts serving the control socket in the background, returns an error if the socket can't be
+// created. A stale socket left behind by a previous process is replaced.
+func NewControlServer(options *ControlOptions, logger Logger, snmp *snmp, parser *tcParser) (*controlServer, error) {
+	if logger == nil {
+		return nil, errors.New("a Logger is required")
+	}
+	if options == nil || options.Socket == "" {
+		return nil, errors.New("the path of the control socket is required")
+	}
+	if snmp == nil || parser == nil {
+		return nil, errors.New("the SNMP handler and the parser are required")
+	}
+	if info, err := os.Lstat(options.Socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(options.Socket); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", options.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(options.Socket, controlSocketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	c := &controlServer{
+		options:  options,
+		logger:   logger,
+		snmp:     snmp,
+		parser:   parser,
+		start:    time.Now(),
+		listener: listener,
+	}
+	go c.serve()
+	return c, nil
+}
+
+// Close stops accepting the commands, waits for the running ones and removes the socket.
+func (c *controlServer) Close() error {
+	err := c.listener.Close()
+	c.running.Wait()
+	return err
+}
+
+// serve accepts the connections until the listener is closed.
+func (c *controlServer) serve() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				c.logger.Err(fmt.Sprintf("serve(): Stopped accepting the commands on %s, error: %s", c.options.Socket, err))
+			}
+			return
+		}
+		c.running.Add(1)
+		go func() {
+			defer c.running.Done()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(controlTimeout))
+			c.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn reads a command and writes the response.
+func (c *controlServer) serveConn(conn io.ReadWriter) {
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	output, err := c.run(strings.Fields(line))
+	if err != nil {
+		fmt.Fprintf(conn, "%s%s\n", controlError, err)
+		return
+	}
+	fmt.Fprintf(conn, "%s\n%s", controlOk, output)
+}
+
+// run runs the command and returns its output.
+func (c *controlServer) run(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("empty command, try help")
+	}
+	command, args := args[0], args[1:]
+	if _, ok := controlCommands[command]; !ok {
+		return "", fmt.Errorf("unknown command '%s', try help", command)
+	}
+	if command != "set-debug" && len(args) > 0 {
+		return "", fmt.Errorf("the %s command takes no arguments", command)
+	}
+	c.logger.Info(fmt.Sprintf("run(): Received the command %s %s on the control socket.", command, strings.Join(args, " ")))
+
+	switch command {
+	case "status":
+		return c.status(), nil
+
+	case "reload":
+		if c.options.Reload == nil {
+			return "", errors.New("reloading isn't supported")
+		}
+		if err := c.options.Reload(); err != nil {
+			return "", err
+		}
+		c.parser.parseTc()
+		return "reloaded\n", nil
+
+	case "flush":
+		c.parser.parseTc()
+		return fmt.Sprintf("last parse: %s\n", c.parser.status().lastParse.Format(time.RFC3339)), nil
+
+	case "dump":
+		return c.dump(), nil
+
+	case "agents":
+		if c.parser.aggregator == nil {
+			return "", errors.New("the aggregator isn't started")
+		}
+		return c.agents(), nil
+
+	case "set-debug":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return "", errors.New("set-debug takes \"on\" or \"off\"")
+		}
+		on := args[0] == "on"
+		c.snmp.SetDebug(on)
+		c.parser.SetDebug(on)
+		return fmt.Sprintf("debug: %s\n", args[0]), nil
+	}
+	return controlHelp(), nil
+}
+
+// status returns the state of the collection.
+func (c *controlServer) status() string {
+	parser := c.parser.status()
+	current := c.snmp.snapshot()
+	count := func(leaf int) interface{} {
+		if position, ok := current.find(leafOID(leaf)); ok {
+			return current.data[position].objectValue
+		}
+		return 0
+	}
+	lastParse := "never"
+	if !parser.lastParse.IsZero() {
+		lastParse = fmt.Sprintf("%s (%s ago)", parser.lastParse.Format(time.RFC3339), time.Since(parser.lastParse).Round(time.Second))
+	}
+	debug := "off"
+	if c.parser.DebugEnabled() {
+		debug = "on"
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "version: %s\n", c.snmp.options.Version)
+	fmt.Fprintf(&buf, "uptime: %s\n", time.Since(c.start).Round(time.Second))
+	fmt.Fprintf(&buf, "debug: %s\n", debug)
+	fmt.Fprintf(&buf, "last parse: %s\n", lastParse)
+	fmt.Fprintf(&buf, "failures: %d\n", parser.failures)
+	fmt.Fprintf(&buf, "backing off: %t\n", parser.backingOff)
+	fmt.Fprintf(&buf, "maintenance: %t\n", parser.inBlackout)
+	fmt.Fprintf(&buf, "idle: %t\n", parser.idle)
+	fmt.Fprintf(&buf, "qdiscs / classes: %v\n", count(tcNumIndexLeaf))
+	fmt.Fprintf(&buf, "users: %v\n", count(tcUserNumIndexLeaf))
+	fmt.Fprintf(&buf, "groups: %v\n", count(tcGroupNumIndexLeaf))
+	fmt.Fprintf(&buf, "oids: %d\n", len(current.data))
+	return buf.String()
+}
+
+// dump returns the exported SNMP data, one "OID = type: value" line for each OID.
+func (c *controlServer) dump() string {
+	var buf strings.Builder
+	for _, data := range c.snmp.snapshot().data {
+		fmt.Fprintf(&buf, "%s = %s: %v\n", data.oid, data.objectType, data.objectValue)
+	}
+	return buf.String()
+}
+
+// agents returns the agents reporting to the aggregator, one "host agent state" line for each with the age of its
+// last report.
+func (c *controlServer) agents() string {
+	var buf strings.Builder
+	now := time.Now()
+	fmt.Fprintf(&buf, "conflicts: %s\n", c.parser.aggregator.options.conflicts())
+	for _, agent := range c.parser.aggregator.agents(now) {
+		master := emptyString
+		if agent.master {
+			master = " master"
+		}
+		fmt.Fprintf(&buf, "%s %s%s: %s, last report %s ago\n", agent.host, agent.agent, master, agent.state, now.Sub(agent.received).Round(time.Second))
+	}
+	return buf.String()
+}
+
+// controlHelp describes the commands.
+func controlHelp() string {
+	var names []string
+	for name := range controlCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%-10s %s\n", name, controlCommands[name])
+	}
+	return buf.String()
+}
+
+// ControlCommand sends the command to the control socket and returns its output. Returns an error if the command
+// failed or if the socket couldn't be reached.
+func ControlCommand(ctx context.Context, socket string, args ...string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(controlTimeout)
+	}
+	conn.SetDeadline(deadline)
+	if _, err := fmt.Fprintf(conn, "%s\n", strings.Join(args, " ")); err != nil {
+		return "", err
+	}
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	status, output, _ := strings.Cut(string(response), "\n")
+	if message, ok := strings.CutPrefix(status, controlError); ok {
+		return "", errors.New(message)
+	}
+	if status != controlOk {
+		return "", fmt.Errorf("unexpected response '%s'", status)
+	}
+	return output, nil
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestControlServer returns a controlServer of a snmp with a single Qdisc and of a tcParser without data.
+func newTestControlServer(t *testing.T, options *ControlOptions) *controlServer {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{Version: "1.2.3"},
+		identity: myName,
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:0", 1500, 10, 1, 0, nil, 2, 0})
+	s.commit()
+	p, err := NewTcParser(&fakeSyslog{}, WithTcParserOptions(&TcParserOptions{Ifaces: []string{"eth0"}, MaxCommands: 1}), WithSink(&recordingSink{}))
+	if err != nil {
+		t.Fatalf("NewTcParser => unexpected error: %s", err)
+	}
+	p.executer = &fakeExecuter{output: []string{"", ""}, err: []error{nil, nil}}
+	p.ifaceReader = &fakeIfaceReader{}
+	return &controlServer{
+		options: options,
+		logger:  &fakeSyslog{},
+		snmp:    s,
+		parser:  p,
+	}
+}
+
+func TestControlServerAgents(t *testing.T) {
+	c := newTestControlServer(t, &ControlOptions{})
+	if _, err := c.run([]string{"agents"}); err == nil || err.Error() != "the aggregator isn't started" {
+		t.Fatalf("run(agents) without the aggregator => got error: %v, want: the aggregator isn't started", err)
+	}
+
+	a, _ := newTestAggregator(t, "")
+	addTestReport(a, "site1", "r1", true, time.Now())
+	addTestReport(a, "site1", "r2", false, time.Now())
+	c.parser.aggregator = a
+	got, err := c.run([]string{"agents"})
+	if err != nil {
+		t.Fatalf("run(agents) => unexpected error: %s", err)
+	}
+	for _, want := range []string{"conflicts: prefer-master\n", "site1 r1 master: used, last report 0s ago\n", "site1 r2: ignored, last report 0s ago\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("run(agents) => got:\n%s\nwant it to contain: %q", got, want)
+		}
+	}
+}
+
+func TestControlServerRun(t *testing.T) {
+	testData := []struct {
+		desc    string
+		args    []string
+		reload  func() error
+		want    []string
+		wantErr string
+	}{
+		{
+			desc: "status",
+			args: []string{"status"},
+			want: []string{"version: 1.2.3\n", "debug: off\n", "last parse: never\n", "failures: 0\n", "qdiscs / classes: 1\n", "users: 0\n"},
+		},
+		{
+			desc: "dump",
+			args: []string{"dump"},
+			want: []string{".1.3.6.1.4.1.2021.255.3.1 = string: eth0:1:0\n", ".1.3.6.1.4.1.2021.255.4.1 = counter64: 1500\n"},
+		},
+		{
+			desc: "flush",
+			args: []string{"flush"},
+			want: []string{"last parse: "},
+		},
+		{
+			desc: "set-debug",
+			args: []string{"set-debug", "on"},
+			want: []string{"debug: on\n"},
+		},
+		{
+			desc: "help",
+			args: []string{"help"},
+			want: []string{"set-debug", "status"},
+		},
+		{
+			desc:   "reload",
+			args:   []string{"reload"},
+			reload: func() error { return nil },
+			want:   []string{"reloaded\n"},
+		},
+		{
+			desc:    "failed reload",
+			args:    []string{"reload"},
+			reload:  func() error { return errors.New("syntax error") },
+			wantErr: "syntax error",
+		},
+		{
+			desc:    "reload not supported",
+			args:    []string{"reload"},
+			wantErr: "reloading isn't supported",
+		},
+		{
+			desc:    "invalid debug value",
+			args:    []string{"set-debug", "yes"},
+			wantErr: "set-debug takes \"on\" or \"off\"",
+		},
+		{
+			desc:    "unexpected argument",
+			args:    []string{"status", "now"},
+			wantErr: "the status command takes no arguments",
+		},
+		{
+			desc:    "unknown command",
+			args:    []string{"restart"},
+			wantErr: "unknown command 'restart', try help",
+		},
+		{
+			desc:    "empty command",
+			wantErr: "empty command, try help",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := newTestControlServer(t, &ControlOptions{Reload: tc.reload})
+			got, err := c.run(tc.args)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("run(%v) => got error: %v, want: %s", tc.args, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("run(%v) => unexpected error: %s", tc.args, err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("run(%v) => got:\n%s\nwant it to contain: %q", tc.args, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestControlServerSetDebug(t *testing.T) {
+	c := newTestControlServer(t, &ControlOptions{})
+	if _, err := c.run([]string{"set-debug", "on"}); err != nil {
+		t.Fatalf("run(set-debug on) => unexpected error: %s", err)
+	}
+	if !c.snmp.debug.enabled() || !c.parser.debug.enabled() {
+		t.Errorf("run(set-debug on) => the debug logging is off, want on")
+	}
+
+	// Reloading the parser applies the configured value again.
+	if err := c.parser.Reload(&TcParserOptions{}); err != nil {
+		t.Fatalf("Reload => unexpected error: %s", err)
+	}
+	if c.parser.debug.enabled() {
+		t.Errorf("Reload => the debug logging is on, want off")
+	}
+}
+
+func TestControlCommand(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "tc_reader.sock")
+	// A stale socket of a previous process is replaced.
+	stale := newTestControlServer(t, &ControlOptions{Socket: socket})
+	if _, err := NewControlServer(stale.options, &fakeSyslog{}, stale.snmp, stale.parser); err != nil {
+		t.Fatalf("NewControlServer => unexpected error: %s", err)
+	}
+
+	c := newTestControlServer(t, &ControlOptions{Socket: socket})
+	server, err := NewControlServer(c.options, &fakeSyslog{}, c.snmp, c.parser)
+	if err != nil {
+		t.Fatalf("NewControlServer with a stale socket => unexpected error: %s", err)
+	}
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("Stat => unexpected error: %s", err)
+	}
+	if got := info.Mode().Perm(); got != controlSocketMode {
+		t.Errorf("NewControlServer => got socket permissions: %o, want: %o", got, controlSocketMode)
+	}
+
+	output, err := ControlCommand(context.Background(), socket, "set-debug", "on")
+	if err != nil {
+		t.Fatalf("ControlCommand => unexpected error: %s", err)
+	}
+	if want := "debug: on\n"; output != want {
+		t.Errorf("ControlCommand => got: %q, want: %q", output, want)
+	}
+	if _, err := ControlCommand(context.Background(), socket, "restart"); err == nil || err.Error() != "unknown command 'restart', try help" {
+		t.Errorf("ControlCommand(restart) => got error: %v, want: unknown command 'restart', try help", err)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Errorf("Close => unexpected error: %s", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Close => the socket wasn't removed, error: %v", err)
+	}
+	if _, err := ControlCommand(context.Background(), socket, "status"); err == nil {
+		t.Errorf("ControlCommand after Close => got no error")
+	}
+}
+
+func TestNewControlServer(t *testing.T) {
+	c := newTestControlServer(t, nil)
+	testData := []struct {
+		desc    string
+		options *ControlOptions
+		logger  Logger
+		wantErr string
+	}{
+		{"no logger", &ControlOptions{Socket: "tc_reader.sock"}, nil, "a Logger is required"},
+		{"no options", nil, &fakeSyslog{}, "the path of the control socket is required"},
+		{"no socket", &ControlOptions{}, &fakeSyslog{}, "the path of the control socket is required"},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := NewControlServer(tc.options, tc.logger, c.snmp, c.parser); err == nil || err.Error() != tc.wantErr {
+				t.Errorf("NewControlServer => got error: %v, want: %s", err, tc.wantErr)
+			}
+		})
+	}
+	if _, err := NewControlServer(&ControlOptions{Socket: filepath.Join(t.TempDir(), "missing", "tc_reader.sock")}, &fakeSyslog{}, c.snmp, c.parser); err == nil {
+		t.Errorf("NewControlServer in a missing directory => got no error")
+	}
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+enroll.go authenticates the agents at the aggregator, so that every agent can report only the data of its own host.
+An agent is identified by:
+certificate - The Common Name of its client certificate, if the aggregator serves TLS and verifies the clients with
+              the aggregatorClientCAFile.
+token       - The host it enrolled as. The agent posts {"host": "cpe1"} with "Authorization: Bearer <enroll token>" to
+              the path /v1/enroll of the aggregator once, which answers {"token": "..."} with a random token of the
+              agent. The agent keeps the token in its agentTokenFile and the aggregator keeps its SHA-256 hash in the
+              aggregatorTokenFile. A host can't enroll again until it is removed from the aggregatorTokenFile and the
+              aggregator is restarted.
+
+The aggregator rejects the reports of the identified agents for the other hosts by 403 Forbidden, so the names in the
+merged tree are scoped by the identities of the agents, e.g. "cpe1/eth0:2:3". The agents with the shared
+aggregatorToken, or all of them without any authentication configured, aren't identified and report any host.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// enrollPath is the path of the aggregator the agents enroll at.
+	enrollPath = "/v1/enroll"
+
+	// enrollTokenBytes is the number of the random bytes of the tokens of the agents.
+	enrollTokenBytes = 32
+
+	// enrollFileMode is the mode of the files with the tokens.
+	enrollFileMode = 0600
+
+	// bearerPrefix is the prefix of the Authorization header with a token.
+	bearerPrefix = "Bearer "
+)
+
+// enrollRequest is the request of an agent enrolling at the aggregator.
+type enrollRequest struct {
+	// Host is the name of the host the agent enrolls as.
+	Host string `json:"host"`
+}
+
+// enrollResponse is the answer of the aggregator to an enrolled agent.
+type enrollResponse struct {
+	// Token is the token of the agent.
+	Token string `json:"token"`
+}
+
+// clientTLSConfig returns the TLS config of posting to the aggregator, nil if the defaults are used.
+func (o *AgentOptions) clientTLSConfig() (*tls.Config, error) {
+	if o.CAFile == emptyString && o.CertFile == emptyString && o.KeyFile == emptyString {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if o.CAFile != emptyString {
+		pool, err := loadCertPool(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if o.CertFile != emptyString || o.KeyFile != emptyString {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// validate returns an error if the options can't be used.
+func (o *AgentOptions) validate() error {
+	if o.EnrollToken != emptyString && o.TokenFile == emptyString {
+		return errors.New("the enrollment of the agent needs the agentTokenFile to keep its token")
+	}
+	if o.EnrollToken != emptyString && o.Token != emptyString {
+		return errors.New("the agent is either enrolled or uses the agentToken, not both")
+	}
+	if _, err := o.clientTLSConfig(); err != nil {
+		return fmt.Errorf("invalid TLS of the agent: %s", err)
+	}
+	return nil
+}
+
+// serverTLSConfig returns the TLS config of serving the agents, nil if they are served over plain HTTP.
+func (o *AggregatorOptions) serverTLSConfig() (*tls.Config, error) {
+	if o.CertFile == emptyString && o.KeyFile == emptyString {
+		if o.ClientCAFile != emptyString {
+			return nil, errors.New("the client certificates can be verified only over TLS, the aggregatorCertFile is required")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the certificate: %s", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if o.ClientCAFile != emptyString {
+		pool, err := loadCertPool(o.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// loadCertPool returns the pool of the PEM encoded certificates in the file.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of the token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token of the Authorization header of the request, empty if it has none.
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), bearerPrefix)
+	if !ok {
+		return emptyString
+	}
+	return token
+}
+
+// enrolledAgents are the hashes of the tokens of the enrolled agents, persisted to the aggregatorTokenFile.
+type enrolledAgents struct {
+	// path is the path of the file, the tokens aren't persisted if it is empty.
+	path string
+
+	// mu protects hashes.
+	mu sync.Mutex
+
+	// hashes are the hashes of the tokens keyed by the hosts of the agents.
+	hashes map[string]string
+}
+
+// loadEnrolledAgents returns the agents enrolled in the file. A missing file has no agents.
+func loadEnrolledAgents(path string) (*enrolledAgents, error) {
+	e := &enrolledAgents{path: path, hashes: make(map[string]string)}
+	if path == emptyString {
+		return e, nil
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &e.hashes); err != nil {
+		return nil, fmt.Errorf("unable to parse the tokens in %s: %s", path, err)
+	}
+	return e, nil
+}
+
+// identify returns the host of the agent with the token, empty if the token isn't known.
+func (e *enrolledAgents) identify(token string) string {
+	hash := hashToken(token)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for host, known := range e.hashes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(known)) == 1 {
+			return host
+		}
+	}
+	return emptyString
+}
+
+// enroll returns a new token of the host and persists its hash. Returns an error if the host is already enrolled.
+func (e *enrolledAgents) enroll(host string) (string, error) {
+	random := make([]byte, enrollTokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return emptyString, err
+	}
+	token := hex.EncodeToString(random)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.hashes[host]; ok {
+		return emptyString, fmt.Errorf("the host %s is already enrolled", host)
+	}
+	e.hashes[host] = hashToken(token)
+	if err := e.save(); err != nil {
+		delete(e.hashes, host)
+		return emptyString, err
+	}
+	return token, nil
+}
+
+// save persists the hashes to the file, mu must be held.
+func (e *enrolledAgents) save() error {
+	if e.path == emptyString {
+		return nil
+	}
+	content, err := json.Marshal(e.hashes)
+	if err != nil {
+		return err
+	}
+	temp := e.path + ".tmp"
+	if err := os.WriteFile(temp, content, enrollFileMode); err != nil {
+		return err
+	}
+	return os.Rename(temp, e.path)
+}
+
+// serveEnroll enrolls an agent with the enrollment token.
+func (a *aggregator) serveEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(bearerPrefix+a.options.EnrollToken)) != 1 {
+		http.Error(w, "invalid enrollment token", http.StatusUnauthorized)
+		return
+	}
+	request := &enrollRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, agentMaxReportSize)).Decode(request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid enrollment: %s", err), http.StatusBadRequest)
+		return
+	}
+	if !reAgentHostName.MatchString(request.Host) {
+		http.Error(w, fmt.Sprintf("invalid enrollment: the host '%s' must be a non-empty name of letters, digits, '.', '_' and '-'", request.Host), http.StatusBadRequest)
+		return
+	}
+	token, err := a.enrolled.enroll(request.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	a.logger.Info(fmt.Sprintf("serveEnroll(): Enrolled the agent %s from %s.", request.Host, r.RemoteAddr))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&enrollResponse{Token: token})
+}
+
+// authenticate returns the identity of the agent posting the request, empty if the agent isn't identified. Returns
+// an error if the agent can't post. The identities of the certificate and of the token must agree.
+func (a *aggregator) authenticate(r *http.Request) (string, error) {
+	var identity string
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		identity = r.TLS.PeerCertificates[0].Subject.CommonName
+		if identity == emptyString {
+			return emptyString, errors.New("the client certificate has no Common Name")
+		}
+	}
+	if a.options.Token == emptyString && a.options.EnrollToken == emptyString {
+		return identity, nil
+	}
+	token := bearerToken(r)
+	if token == emptyString {
+		return emptyString, errors.New("invalid token")
+	}
+	if a.options.Token != emptyString && subtle.ConstantTimeCompare([]byte(token), []byte(a.options.Token)) == 1 {
+		return identity, nil
+	}
+	enrolled := a.enrolled.identify(token)
+	if enrolled == emptyString {
+		return emptyString, errors.New("invalid token")
+	}
+	if identity != emptyString && identity != enrolled {
+		return emptyString, fmt.Errorf("the certificate of %s doesn't match the token of %s", identity, enrolled)
+	}
+	return enrolled, nil
+}
+
+// token returns the token sent with the reports, enrolling the agent first if it has no token yet. Returns an error
+// if the agent can't enroll, the reports are posted after the next parse cycles then.
+func (a *agentSink) token() (string, error) {
+	if a.options.TokenFile == emptyString {
+		return a.options.Token, nil
+	}
+	if a.enrolledToken != emptyString {
+		return a.enrolledToken, nil
+	}
+	content, err := os.ReadFile(a.options.TokenFile)
+	if err == nil {
+		a.enrolledToken = strings.TrimSpace(string(content))
+		return a.enrolledToken, nil
+	}
+	if !os.IsNotExist(err) || a.options.EnrollToken == emptyString {
+		return emptyString, err
+	}
+	token, err := a.enroll()
+	if err != nil {
+		return emptyString, fmt.Errorf("unable to enroll at %s: %s", a.options.Target, err)
+	}
+	if err := os.WriteFile(a.options.TokenFile, []byte(token+"\n"), enrollFileMode); err != nil {
+		return emptyString, err
+	}
+	a.logger.Info(fmt.Sprintf("token(): Enrolled the agent %s at %s.", a.host, a.options.Target))
+	a.enrolledToken = token
+	return token, nil
+}
+
+// enroll enrolls the host of the agent at the aggregator and returns its token.
+func (a *agentSink) enroll() (string, error) {
+	body, err := json.Marshal(&enrollRequest{Host: a.host})
+	if err != nil {
+		return emptyString, err
+	}
+	request, err := http.NewRequest(http.MethodPost, a.options.Target+enrollPath, bytes.NewReader(body))
+	if err != nil {
+		return emptyString, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", bearerPrefix+a.options.EnrollToken)
+	response, err := a.client.Do(request)
+	if err != nil {
+		return emptyString, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return emptyString, fmt.Errorf("the aggregator answered %s", response.Status)
+	}
+	enrolled := &enrollResponse{}
+	if err := json.NewDecoder(response.Body).Decode(enrolled); err != nil {
+		return emptyString, err
+	}
+	if enrolled.Token == emptyString {
+		return emptyString, errors.New("the aggregator answered no token")
+	}
+	return enrolled.Token, nil
+}
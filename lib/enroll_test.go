@@ -0,0 +1,354 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate written to PEM files.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert writes a certificate with the Common Name, signed by the CA or self-signed if it is nil, to the
+// directory.
+func newTestCert(t *testing.T, dir, name string, ca *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey => unexpected error: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("CreateCertificate => unexpected error: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate => unexpected error: %s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey => unexpected error: %s", err)
+	}
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	if err := os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("WriteFile => unexpected error: %s", err)
+	}
+	if err := os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("WriteFile => unexpected error: %s", err)
+	}
+	return c
+}
+
+func TestAggregatorServeEnroll(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "agents.json")
+	a, _ := newTestAggregator(t, "")
+	a.options.EnrollToken = "enroll"
+	a.enrolled.path = tokenFile
+
+	tests := []struct {
+		desc       string
+		token      string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc:       "wrong enrollment token",
+			token:      "guess",
+			body:       `{"host": "cpe1"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   "invalid enrollment token",
+		},
+		{
+			desc:       "invalid host",
+			token:      "enroll",
+			body:       `{"host": "cpe/1"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid enrollment: the host 'cpe/1' must be a non-empty name of letters, digits, '.', '_' and '-'",
+		},
+		{
+			desc:       "enrolled",
+			token:      "enroll",
+			body:       `{"host": "cpe1"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:       "enrolled again",
+			token:      "enroll",
+			body:       `{"host": "cpe1"}`,
+			wantStatus: http.StatusConflict,
+			wantBody:   "the host cpe1 is already enrolled",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, enrollPath, strings.NewReader(tc.body))
+			request.Header.Set("Authorization", "Bearer "+tc.token)
+			recorder := httptest.NewRecorder()
+			a.serveEnroll(recorder, request)
+			if recorder.Code != tc.wantStatus {
+				t.Fatalf("serveEnroll => got status %d, want %d", recorder.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				if got := strings.TrimSpace(recorder.Body.String()); got != tc.wantBody {
+					t.Errorf("serveEnroll => got body '%s', want '%s'", got, tc.wantBody)
+				}
+				return
+			}
+			response := &enrollResponse{}
+			if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+				t.Fatalf("serveEnroll => unable to decode the answer: %s", err)
+			}
+			if got := a.enrolled.identify(response.Token); got != "cpe1" {
+				t.Errorf("identify of the enrolled token => got '%s', want 'cpe1'", got)
+			}
+			loaded, err := loadEnrolledAgents(tokenFile)
+			if err != nil {
+				t.Fatalf("loadEnrolledAgents => unexpected error: %s", err)
+			}
+			if got := loaded.identify(response.Token); got != "cpe1" {
+				t.Errorf("identify of the persisted token => got '%s', want 'cpe1'", got)
+			}
+		})
+	}
+}
+
+func TestAggregatorAuthenticate(t *testing.T) {
+	tests := []struct {
+		desc         string
+		token        string
+		enrollToken  string
+		sent         string
+		wantIdentity string
+		wantErr      string
+	}{
+		{
+			desc: "no authentication",
+			sent: "anything",
+		},
+		{
+			desc:  "shared token",
+			token: "secret",
+			sent:  "secret",
+		},
+		{
+			desc:    "missing token",
+			token:   "secret",
+			wantErr: "invalid token",
+		},
+		{
+			desc:         "enrolled token",
+			token:        "secret",
+			enrollToken:  "enroll",
+			sent:         "cpe1-token",
+			wantIdentity: "cpe1",
+		},
+		{
+			desc:        "unknown token",
+			enrollToken: "enroll",
+			sent:        "enroll",
+			wantErr:     "invalid token",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			a, _ := newTestAggregator(t, tc.token)
+			a.options.EnrollToken = tc.enrollToken
+			a.enrolled.hashes["cpe1"] = hashToken("cpe1-token")
+			request := httptest.NewRequest(http.MethodPost, agentPath, nil)
+			if tc.sent != "" {
+				request.Header.Set("Authorization", "Bearer "+tc.sent)
+			}
+			identity, err := a.authenticate(request)
+			if (err != nil) != (tc.wantErr != "") || (err != nil && err.Error() != tc.wantErr) {
+				t.Fatalf("authenticate => got error %v, want '%s'", err, tc.wantErr)
+			}
+			if identity != tc.wantIdentity {
+				t.Errorf("authenticate => got identity '%s', want '%s'", identity, tc.wantIdentity)
+			}
+		})
+	}
+}
+
+func TestAgentEnrollToAggregator(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := newTestAggregator(t, "")
+	a.options.EnrollToken = "enroll"
+	mux := http.NewServeMux()
+	mux.HandleFunc(agentPath, a.serveReport)
+	mux.HandleFunc(enrollPath, a.serveEnroll)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	tokenFile := filepath.Join(dir, "agent.token")
+	sink := &agentSink{
+		options:  &AgentOptions{Target: server.URL, EnrollToken: "enroll", TokenFile: tokenFile},
+		logger:   &fakeSyslog{},
+		host:     "cpe1",
+		interval: 10,
+		client:   server.Client(),
+	}
+	if err := sink.post(&agentReport{Host: "cpe1", Interval: 10}); err != nil {
+		t.Fatalf("post of the enrolled agent => unexpected error: %s", err)
+	}
+	content, err := os.ReadFile(tokenFile)
+	if err != nil {
+		t.Fatalf("ReadFile of the token => unexpected error: %s", err)
+	}
+	if got := a.enrolled.identify(strings.TrimSpace(string(content))); got != "cpe1" {
+		t.Errorf("identify of the kept token => got '%s', want 'cpe1'", got)
+	}
+
+	err = sink.post(&agentReport{Host: "cpe2", Interval: 10})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("post of another host => got error %v, want 403 Forbidden", err)
+	}
+
+	// A restarted agent reads the kept token instead of enrolling again.
+	restarted := &agentSink{options: sink.options, logger: &fakeSyslog{}, host: "cpe1", interval: 10, client: server.Client()}
+	if err := restarted.post(&agentReport{Host: "cpe1", Interval: 10}); err != nil {
+		t.Errorf("post of the restarted agent => unexpected error: %s", err)
+	}
+}
+
+func TestAgentMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	serverCert := newTestCert(t, dir, "aggregator", ca)
+	clientCert := newTestCert(t, dir, "cpe1", ca)
+
+	a, _ := newTestAggregator(t, "")
+	a.options.CertFile = serverCert.certFile
+	a.options.KeyFile = serverCert.keyFile
+	a.options.ClientCAFile = ca.certFile
+	tlsConfig, err := a.options.serverTLSConfig()
+	if err != nil {
+		t.Fatalf("serverTLSConfig => unexpected error: %s", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(a.serveReport))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	options := &AgentOptions{Target: server.URL, CAFile: ca.certFile, CertFile: clientCert.certFile, KeyFile: clientCert.keyFile}
+	if err := options.validate(); err != nil {
+		t.Fatalf("validate => unexpected error: %s", err)
+	}
+	sink := newAgentSink(options, 10, nil, &fakeSyslog{})
+	if err := sink.post(&agentReport{Host: "cpe1", Interval: 10}); err != nil {
+		t.Errorf("post with the certificate of the host => unexpected error: %s", err)
+	}
+	err = sink.post(&agentReport{Host: "cpe2", Interval: 10})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("post of another host => got error %v, want 403 Forbidden", err)
+	}
+
+	anonymous := newAgentSink(&AgentOptions{Target: server.URL, CAFile: ca.certFile}, 10, nil, &fakeSyslog{})
+	if err := anonymous.post(&agentReport{Host: "cpe1", Interval: 10}); err == nil {
+		t.Error("post without a certificate => got no error, want the handshake to fail")
+	}
+}
+
+func TestAgentAuthOptionsValidate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	tests := []struct {
+		desc     string
+		validate func() error
+		wantErr  string
+	}{
+		{
+			desc:     "enrollment without a token file",
+			validate: (&AgentOptions{EnrollToken: "enroll"}).validate,
+			wantErr:  "the enrollment of the agent needs the agentTokenFile to keep its token",
+		},
+		{
+			desc:     "enrollment with a shared token",
+			validate: (&AgentOptions{EnrollToken: "enroll", TokenFile: "agent.token", Token: "secret"}).validate,
+			wantErr:  "the agent is either enrolled or uses the agentToken, not both",
+		},
+		{
+			desc:     "the CA file has no certificates",
+			validate: (&AgentOptions{CAFile: ca.keyFile}).validate,
+			wantErr:  "invalid TLS of the agent: no certificates found in " + ca.keyFile,
+		},
+		{
+			desc:     "valid agent",
+			validate: (&AgentOptions{CAFile: ca.certFile, CertFile: ca.certFile, KeyFile: ca.keyFile}).validate,
+		},
+		{
+			desc: "client CA without TLS",
+			validate: func() error {
+				_, err := (&AggregatorOptions{ClientCAFile: ca.certFile}).serverTLSConfig()
+				return err
+			},
+			wantErr: "the client certificates can be verified only over TLS, the aggregatorCertFile is required",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.validate()
+			if (err != nil) != (tc.wantErr != "") || (err != nil && err.Error() != tc.wantErr) {
+				t.Errorf("validate => got error %v, want '%s'", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+env.go overrides the options of the config file by environment variables, e.g. in containers or in the uci wrappers
+of OpenWrt, where editing the config file is awkward.
+
+The variables are named after the options in upper case with the words separated by underscores and the TC_READER_
+prefix, e.g. TC_READER_PARSE_INTERVAL=10, TC_READER_IFACES="eth0 eth1" or TC_READER_BASE_OID=.1.3.6.1.4.1.2021.255.
+The values are written as in the config file without the quotes. TC_READER_INTERVAL is a shorthand for
+TC_READER_PARSE_INTERVAL. The options that can be repeated, e.g. user or alert, can't be overridden.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// envPrefix is the prefix of the environment variables that override the options.
+const envPrefix = "TC_READER_"
+
+// envIgnored are the environment variables with the envPrefix that don't override options.
+var envIgnored = map[string]bool{
+	// TC_READER_CONFIG is the path of the config file.
+	"CONFIG": true,
+}
+
+// envAliases are the shorthands of the environment variables.
+var envAliases = map[string]string{
+	"INTERVAL": "PARSE_INTERVAL",
+}
+
+// ApplyEnv overrides the options by the TC_READER_ variables of the environment, given as "NAME=value" pairs like
+// those returned by os.Environ. Returns an error if a variable doesn't name an option or if its value is invalid.
+func (c *config) ApplyEnv(environ []string) error {
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		option, ok := strings.CutPrefix(name, envPrefix)
+		if !ok || envIgnored[option] {
+			continue
+		}
+		if alias, ok := envAliases[option]; ok {
+			option = alias
+		}
+		if err := c.override(option, value); err != nil {
+			return fmt.Errorf("Error in environment variable %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// override sets the option named in upper case with the words separated by underscores, e.g. PARSE_INTERVAL, to the
+// value. The value is parsed as the line of the option in a config file of its own and the fields it set replace the
+// parsed ones.
+func (c *config) override(option string, value string) error {
+	name := strings.ReplaceAll(option, "_", "")
+	field, ok := reflect.TypeOf(*c).FieldByNameFunc(func(field string) bool {
+		return strings.EqualFold(field, name)
+	})
+	if !ok || !field.IsExported() {
+		return fmt.Errorf("unknown option %s", option)
+	}
+	key := strings.ToLower(field.Name[:1]) + field.Name[1:]
+
+	// The value is quoted in the lines of some options and not in the others.
+	var override *config
+	for _, line := range []string{fmt.Sprintf("%s = \"%s\"", key, value), fmt.Sprintf("%s = %s", key, value)} {
+		override = newConfig(c.filename)
+		if err := override.parseLine(0, line); err == nil {
+			break
+		}
+		override = nil
+	}
+	if override == nil {
+		return fmt.Errorf("cannot set the option %s to '%s'", key, value)
+	}
+
+	parsed, overridden := reflect.ValueOf(c).Elem(), reflect.ValueOf(override).Elem()
+	for i := 0; i < parsed.NumField(); i++ {
+		if parsed.Type().Field(i).IsExported() && (i == field.Index[0] || !overridden.Field(i).IsZero()) {
+			parsed.Field(i).Set(overridden.Field(i))
+		}
+	}
+	return nil
+}
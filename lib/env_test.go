@@ -0,0 +1,118 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	testData := []struct {
+		desc    string
+		content string
+		environ []string
+		want    func(c *config) interface{}
+		wantVal interface{}
+		wantErr string
+	}{
+		{
+			desc:    "list of strings replaces the config file",
+			content: "ifaces = \"eth0\"",
+			environ: []string{"TC_READER_IFACES=eth1 ppp0"},
+			want:    func(c *config) interface{} { return c.Ifaces },
+			wantVal: []string{"eth1", "ppp0"},
+		},
+		{
+			desc:    "integer",
+			content: "parseInterval = 5",
+			environ: []string{"TC_READER_PARSE_INTERVAL=10"},
+			want:    func(c *config) interface{} { return c.ParseInterval },
+			wantVal: 10 * time.Second,
+		},
+		{
+			desc:    "interval shorthand",
+			environ: []string{"TC_READER_INTERVAL=30"},
+			want:    func(c *config) interface{} { return c.ParseInterval },
+			wantVal: 30 * time.Second,
+		},
+		{
+			desc:    "boolean turned off",
+			content: "debug = true",
+			environ: []string{"TC_READER_DEBUG=false"},
+			want:    func(c *config) interface{} { return c.Debug },
+			wantVal: false,
+		},
+		{
+			desc:    "name with an acronym",
+			environ: []string{"TC_READER_BASE_OID=.1.3.6.1.4.1.2021.254"},
+			want:    func(c *config) interface{} { return c.BaseOID },
+			wantVal: ".1.3.6.1.4.1.2021.254",
+		},
+		{
+			desc:    "option that sets two fields",
+			environ: []string{"TC_READER_MQTT_USER=\"tc\" \"secret\""},
+			want:    func(c *config) interface{} { return []string{c.MqttUser, c.MqttPassword} },
+			wantVal: []string{"tc", "secret"},
+		},
+		{
+			desc:    "unrelated variables are ignored",
+			content: "identity = \"router\"",
+			environ: []string{"HOME=/root", "TC_READER_CONFIG=/etc/tc_reader.conf"},
+			want:    func(c *config) interface{} { return c.Identity },
+			wantVal: "router",
+		},
+		{
+			desc:    "unknown option",
+			environ: []string{"TC_READER_COLOR=red"},
+			wantErr: "Error in environment variable TC_READER_COLOR: unknown option COLOR",
+		},
+		{
+			desc:    "repeated option",
+			environ: []string{"TC_READER_USER=alice"},
+			wantErr: "Error in environment variable TC_READER_USER: unknown option USER",
+		},
+		{
+			desc:    "invalid value",
+			environ: []string{"TC_READER_PARSE_INTERVAL=often"},
+			wantErr: "Error in environment variable TC_READER_PARSE_INTERVAL: cannot set the option parseInterval to 'often'",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := newConfig("test")
+			if err := c.parseConfig(tc.content); err != nil {
+				t.Fatalf("parseConfig => unexpected error: %s", err)
+			}
+			err := c.ApplyEnv(tc.environ)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("ApplyEnv => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyEnv => unexpected error: %s", err)
+			}
+			if got := tc.want(c); !reflect.DeepEqual(got, tc.wantVal) {
+				t.Errorf("ApplyEnv => got: %v, want: %v", got, tc.wantVal)
+			}
+		})
+	}
+}
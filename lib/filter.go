@@ -0,0 +1,147 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+filter.go parses the TC filters to find the Classes that the traffic of single IP addresses is directed to.
+Only the filters matching a single address are considered, the source address means the upload direction and
+the destination address means the download direction.
+
+Example of u32 filters, the IPv4 source address is at offset 12 and the destination address at offset 16:
+filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::800 order 2048 key ht 800 bkt 0 flowid 1:10 not_in_hw
+  match 0a000005/ffffffff at 16
+
+Example of a flower filter:
+filter parent 1: protocol ip pref 2 flower chain 0 handle 0x1 classid 1:20
+  eth_type ipv4
+  src_ip 10.0.0.5
+  not_in_hw
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// filterPrefix is the prefix of the header line of a filter in the TC output.
+	filterPrefix = "filter "
+
+	// reFilterClassStr is string version of the RE to match the Class a filter directs the traffic to.
+	reFilterClassStr = " (?:flowid|classid) (?P<qdiscHandle>[0-9a-f]+):(?P<classHandle>[0-9a-f]*)"
+
+	// reU32MatchStr is string version of the RE to match an IPv4 address match of an u32 filter.
+	reU32MatchStr = "^match (?P<value>[0-9a-f]{8})/ffffffff at (?P<offset>12|16)$"
+
+	// reFlowerMatchStr is string version of the RE to match an address match of a flower filter.
+	reFlowerMatchStr = "^(?P<key>src_ip|dst_ip) (?P<addr>[0-9a-fA-F.:]+)(?:/(?:32|128))?$"
+
+	// u32SrcOffset is the offset of the IPv4 source address in the u32 matches.
+	u32SrcOffset = "12"
+)
+
+var (
+	// reFilterClass is the compiled version of reFilterClassStr.
+	reFilterClass = regexp.MustCompile(reFilterClassStr)
+
+	// reU32Match is the compiled version of reU32MatchStr.
+	reU32Match = regexp.MustCompile(reU32MatchStr)
+
+	// reFlowerMatch is the compiled version of reFlowerMatchStr.
+	reFlowerMatch = regexp.MustCompile(reFlowerMatchStr)
+)
+
+// filterMatch is a single address matched by a filter.
+type filterMatch struct {
+	// addr is the matched IP address in its canonical form, see net.IP.String().
+	addr string
+
+	// direction is UploadDirection for a source address or DownloadDirection for a destination address.
+	direction int
+
+	// handle is the Qdisc and Class handle the traffic is directed to in the form of the tcNames, e.g. "1:10".
+	handle string
+}
+
+// parseFilters parses the output of 'tc filter show' into the single addresses matched by the filters.
+func parseFilters(output io.Reader) ([]filterMatch, error) {
+	var matches []filterMatch
+
+	// handle is the Qdisc and Class handle of the last seen filter, empty if it doesn't direct the traffic to a Class.
+	var handle string
+
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, filterPrefix) {
+			handle = emptyString
+			if match := reFilterClass.FindStringSubmatch(line); match != nil {
+				handle = filterHandle(match[1], match[2])
+			}
+			continue
+		}
+		if handle == emptyString {
+			continue
+		}
+
+		field := strings.TrimSpace(line)
+		if match := reU32Match.FindStringSubmatch(field); match != nil {
+			addr, err := hex.DecodeString(match[1])
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, filterMatch{
+				addr:      net.IP(addr).String(),
+				direction: matchDirection(match[2] == u32SrcOffset),
+				handle:    handle,
+			})
+		} else if match := reFlowerMatch.FindStringSubmatch(field); match != nil {
+			addr := net.ParseIP(match[2])
+			if addr == nil {
+				continue
+			}
+			matches = append(matches, filterMatch{
+				addr:      addr.String(),
+				direction: matchDirection(match[1] == "src_ip"),
+				handle:    handle,
+			})
+		}
+	}
+	return matches, scanner.Err()
+}
+
+// filterHandle converts the Qdisc and Class handles printed by TC into the form used in the tcNames, e.g. "1:0a" into "1:a".
+func filterHandle(qdiscHandle, classHandle string) string {
+	qdisc, _ := strconv.ParseInt(qdiscHandle, 16, 64)
+	var class int64
+	if classHandle != emptyString {
+		class, _ = strconv.ParseInt(classHandle, 16, 64)
+	}
+	return strconv.FormatInt(qdisc, 16) + ":" + strconv.FormatInt(class, 16)
+}
+
+// matchDirection returns the direction of traffic of a filter matching the source or the destination address.
+func matchDirection(source bool) int {
+	if source {
+		return UploadDirection
+	}
+	return DownloadDirection
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFilters(t *testing.T) {
+	testData := []struct {
+		desc   string
+		output string
+		want   []filterMatch
+	}{
+		{
+			desc: "empty output",
+		},
+		{
+			desc: "u32 filters",
+			output: `filter parent 1: protocol ip pref 1 u32 chain 0
+filter parent 1: protocol ip pref 1 u32 chain 0 fh 800: ht divisor 1
+filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::800 order 2048 key ht 800 bkt 0 flowid 1:10 not_in_hw
+  match 0a000005/ffffffff at 16
+filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::801 order 2049 key ht 800 bkt 0 flowid 1:1a not_in_hw
+  match c0a80102/ffffffff at 12
+`,
+			want: []filterMatch{
+				{"10.0.0.5", DownloadDirection, "1:10"},
+				{"192.168.1.2", UploadDirection, "1:1a"},
+			},
+		},
+		{
+			desc: "u32 filters matching networks or ports are skipped",
+			output: `filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::800 order 2048 key ht 800 bkt 0 flowid 1:10 not_in_hw
+  match 0a000000/ffffff00 at 16
+filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::801 order 2049 key ht 800 bkt 0 flowid 1:20 not_in_hw
+  match 00160000/ffff0000 at 20
+`,
+		},
+		{
+			desc: "flower filters",
+			output: `filter parent 1: protocol ip pref 2 flower chain 0
+filter parent 1: protocol ip pref 2 flower chain 0 handle 0x1 classid 1:20
+  eth_type ipv4
+  src_ip 10.0.0.5
+  not_in_hw
+filter parent 1: protocol ipv6 pref 3 flower chain 0 handle 0x1 classid 1:0030
+  eth_type ipv6
+  dst_ip 2001:db8::5/128
+  not_in_hw
+filter parent 1: protocol ip pref 4 flower chain 0 handle 0x1 classid 1:40
+  eth_type ipv4
+  dst_ip 10.0.1.0/24
+  not_in_hw
+`,
+			want: []filterMatch{
+				{"10.0.0.5", UploadDirection, "1:20"},
+				{"2001:db8::5", DownloadDirection, "1:30"},
+			},
+		},
+		{
+			desc: "filters without a class are skipped",
+			output: `filter parent ffff: protocol ip pref 1 flower chain 0 handle 0x1
+  eth_type ipv4
+  src_ip 10.0.0.5
+  not_in_hw
+	action order 1: mirred (Egress Redirect to device ifb0) stolen
+`,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseFilters(strings.NewReader(tc.output))
+			if err != nil {
+				t.Fatalf("parseFilters => unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseFilters => got: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+graphite.go sends the exported metrics to a Graphite Carbon daemon after each parse cycle, using the plaintext
+protocol over TCP. Many shaping dashboards are built on Graphite.
+
+The metric paths are created from a template, e.g. "tc.{host}.{iface}.{class}.{metric}", see sink.go for the
+placeholders. The characters that Graphite doesn't allow in the path components are replaced with "_", e.g. "eth0:2:3"
+is sent as the class "2_3".
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"time"
+)
+
+const (
+	// graphitePort is the default port of the Carbon plaintext protocol.
+	graphitePort = "2003"
+
+	// graphiteQueueSize is the number of parse cycles waiting to be sent, the further ones are dropped.
+	graphiteQueueSize = 4
+
+	// graphiteTimeout is the timeout of connecting to and writing to the Carbon daemon.
+	graphiteTimeout = 10 * time.Second
+)
+
+var (
+	// graphitePath is the default template of the metric paths.
+	graphitePath = "tc.{host}.{iface}.{class}.{metric}"
+
+	// reGraphiteUnsafe matches the characters that are replaced in the components of the metric paths.
+	reGraphiteUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+)
+
+// GraphiteOptions are the options of the Graphite Carbon sink.
+type GraphiteOptions struct {
+	// Target is the host and port of the Carbon daemon, e.g. "graphite.example.com:2003".
+	// The metrics aren't sent if this isn't set.
+	Target string
+
+	// Path is the template of the metric paths. Defaults to graphitePath.
+	Path string
+}
+
+// path returns the configured template of the metric paths, or the default one if it wasn't set.
+func (o *GraphiteOptions) path() string {
+	if o.Path != "" {
+		return o.Path
+	}
+	return graphitePath
+}
+
+// target returns the configured target with the default port if it doesn't specify one.
+func (o *GraphiteOptions) target() string {
+	if _, _, err := net.SplitHostPort(o.Target); err != nil {
+		return net.JoinHostPort(o.Target, graphitePort)
+	}
+	return o.Target
+}
+
+// carbonSink implements metricSink.
+type carbonSink struct {
+	// options holds the configurable options.
+	options *GraphiteOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// host is the hostname used for the {host} placeholder, escaped by graphiteComponent.
+	host string
+
+	// conn is the TCP connection to the Carbon daemon, nil if it isn't connected.
+	conn net.Conn
+
+	// queue holds the encoded parse cycles until they are sent.
+	queue chan []byte
+
+	// done is closed when all the queued parse cycles were sent.
+	done chan struct{}
+}
+
+// newCarbonSink creates a carbonSink and starts sending the metrics in the background.
+// The connection is established when the first metrics are sent and reestablished after errors.
+func newCarbonSink(options *GraphiteOptions, logger sysLogger) *carbonSink {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Err(fmt.Sprintf("newCarbonSink(): Unable to determine the hostname for the metric paths, error: %s", err))
+	}
+	c := &carbonSink{
+		options: options,
+		logger:  logger,
+		host:    graphiteComponent(hostname),
+		queue:   make(chan []byte, graphiteQueueSize),
+		done:    make(chan struct{}),
+	}
+	go c.send()
+	return c
+}
+
+// flush encodes the values and queues them for sending. The values are dropped if the queue is full.
+func (c *carbonSink) flush(values []metricValue, at time.Time) {
+	if len(values) == 0 {
+		return
+	}
+	select {
+	case c.queue <- c.lines(values, at):
+	default:
+		c.logger.Err(fmt.Sprintf("flush(): Too many parse cycles waiting, dropping the metrics for %s.", c.options.target()))
+	}
+}
+
+// lines encodes the values in the Carbon plaintext protocol, one "path value timestamp" line for each.
+func (c *carbonSink) lines(values []metricValue, at time.Time) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		fmt.Fprintf(&buf, "%s %v %d\n", metricPath(c.options.path(), c.host, v, graphiteComponent), v.value, at.Unix())
+	}
+	return buf.Bytes()
+}
+
+// close sends the queued parse cycles and closes the connection.
+func (c *carbonSink) close(ctx context.Context) error {
+	close(c.queue)
+	return awaitSender(ctx, c.done)
+}
+
+// send writes the queued parse cycles to the Carbon daemon until the queue is closed.
+func (c *carbonSink) send() {
+	defer close(c.done)
+	defer func() {
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	}()
+	for lines := range c.queue {
+		if c.conn == nil {
+			conn, err := net.DialTimeout("tcp", c.options.target(), graphiteTimeout)
+			if err != nil {
+				c.logger.Err(fmt.Sprintf("send(): Unable to connect to %s, dropping the metrics, error: %s", c.options.target(), err))
+				continue
+			}
+			c.conn = conn
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+		if _, err := c.conn.Write(lines); err != nil {
+			c.logger.Err(fmt.Sprintf("send(): Unable to send the metrics to %s, error: %s", c.options.target(), err))
+			c.conn.Close()
+			c.conn = nil
+		}
+	}
+}
+
+// graphiteComponent replaces the characters that Graphite doesn't allow in a component of the metric path.
+func graphiteComponent(component string) string {
+	return reGraphiteUnsafe.ReplaceAllString(component, "_")
+}
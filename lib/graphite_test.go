@@ -0,0 +1,127 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGraphiteOptions(t *testing.T) {
+	testData := []struct {
+		options    *GraphiteOptions
+		wantTarget string
+		wantPath   string
+	}{
+		{&GraphiteOptions{Target: "graphite"}, "graphite:2003", "tc.{host}.{iface}.{class}.{metric}"},
+		{&GraphiteOptions{Target: "graphite:2103", Path: "{metric}"}, "graphite:2103", "{metric}"},
+		{&GraphiteOptions{Target: "::1"}, "[::1]:2003", "tc.{host}.{iface}.{class}.{metric}"},
+	}
+
+	for _, tc := range testData {
+		if got := tc.options.target(); got != tc.wantTarget {
+			t.Errorf("target(%q) => got: %s, want: %s", tc.options.Target, got, tc.wantTarget)
+		}
+		if got := tc.options.path(); got != tc.wantPath {
+			t.Errorf("path(%q) => got: %s, want: %s", tc.options.Path, got, tc.wantPath)
+		}
+	}
+}
+
+func TestCarbonSinkLines(t *testing.T) {
+	c := &carbonSink{
+		options: &GraphiteOptions{},
+		host:    "router_example_com",
+	}
+	values := []metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1500)},
+		{"sentBytes", tcNameLeaf, "eth0:1:0#2", int64(10)},
+		{"efficiency", tcNameLeaf, "ppp0", int64(97)},
+		{"userQuotaUsed", tcUserNameLeaf, "john.doe", int64(42)},
+		{"groupBytes", tcGroupNameLeaf, "uplinks", int64(1 << 40)},
+	}
+	want := "tc.router_example_com.eth0.2_3.sentBytes 1500 1357000000\n" +
+		"tc.router_example_com.eth0.1_0_2.sentBytes 10 1357000000\n" +
+		"tc.router_example_com.ppp0..efficiency 97 1357000000\n" +
+		"tc.router_example_com.users.john_doe.userQuotaUsed 42 1357000000\n" +
+		"tc.router_example_com.groups.uplinks.groupBytes 1099511627776 1357000000\n"
+	if got := string(c.lines(values, time.Unix(1357000000, 0))); got != want {
+		t.Errorf("lines => got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCarbonSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen => unexpected error: %s", err)
+	}
+	defer listener.Close()
+
+	c := newCarbonSink(&GraphiteOptions{Target: listener.Addr().String(), Path: "tc.{class}.{metric}"}, &fakeSyslog{})
+	// Nothing is sent for an empty cycle.
+	c.flush(nil, time.Unix(1357000000, 0))
+	c.flush([]metricValue{{"sentPkt", tcNameLeaf, "eth0:1:1", int64(5)}}, time.Unix(1357000010, 0))
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept => unexpected error: %s", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString => unexpected error: %s", err)
+	}
+	if want := "tc.1_1.sentPkt 5 1357000010\n"; line != want {
+		t.Errorf("carbonSink => sent: %q, want: %q", line, want)
+	}
+}
+
+func TestCarbonSinkClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen => unexpected error: %s", err)
+	}
+	defer listener.Close()
+
+	c := newCarbonSink(&GraphiteOptions{Target: listener.Addr().String(), Path: "tc.{class}.{metric}"}, &fakeSyslog{})
+	c.flush([]metricValue{{"sentPkt", tcNameLeaf, "eth0:1:1", int64(5)}}, time.Unix(1357000010, 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.close(ctx); err != nil {
+		t.Fatalf("close => unexpected error: %s", err)
+	}
+
+	// The queued metrics were sent before the connection was closed.
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept => unexpected error: %s", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	sent, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("ReadAll => unexpected error: %s", err)
+	}
+	if want := "tc.1_1.sentPkt 5 1357000010\n"; string(sent) != want {
+		t.Errorf("close => sent: %q, want: %q", sent, want)
+	}
+}
@@ -0,0 +1,520 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+grpc.go serves the metrics over gRPC, so that other services can consume them in a typed way. The service is
+defined in proto/tc_reader.proto:
+GetSnapshot   - Returns the samples of the last parse cycle.
+StreamUpdates - Streams the samples after each parse cycle, starting with the last one.
+
+The server speaks gRPC over cleartext HTTP/2 and encodes the few messages itself, so that tc_reader doesn't depend on
+the protobuf and gRPC libraries. Compressed messages aren't supported.
+*/
+
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// grpcService is the prefix of the paths of the methods of the service.
+	grpcService = "/tc_reader.v1.TcReader/"
+
+	// grpcMaxRequestSize is the maximum size of a request message.
+	grpcMaxRequestSize = 1 << 16
+
+	// grpcStreamBuffer is the number of snapshots queued for a streaming client, newer snapshots are dropped when it
+	// is full.
+	grpcStreamBuffer = 4
+)
+
+// The gRPC status codes used by the server.
+const (
+	grpcStatusOK              = 0
+	grpcStatusInvalidArgument = 3
+	grpcStatusUnimplemented   = 12
+	grpcStatusUnavailable     = 14
+)
+
+// The protobuf wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// GrpcOptions are the options of the gRPC server.
+type GrpcOptions struct {
+	// Listen is the address the gRPC server listens on, e.g. "127.0.0.1:9090".
+	// The gRPC server isn't started if this isn't set.
+	Listen string
+}
+
+// grpcSample is the Sample message.
+type grpcSample struct {
+	// kind is "class", "user", "group" or "iface", see metricValue.kind().
+	kind string
+
+	// name is the name of the Qdisc / Class, user or group.
+	name string
+
+	// iface and class are the values of the {iface} and {class} placeholders, see metricValue.components().
+	iface, class string
+
+	// metric is the name of the metric.
+	metric string
+
+	// value is the value of the metric.
+	value int64
+
+	// rate is the change per second since the previous parse cycle, valid if hasRate is set.
+	rate float64
+
+	// hasRate indicates that the rate is set.
+	hasRate bool
+}
+
+// grpcSnapshot is the Snapshot message.
+type grpcSnapshot struct {
+	// at is the time the parse cycle started.
+	at time.Time
+
+	// interval is the number of seconds since the previous parse cycle.
+	interval float64
+
+	// samples are the samples of the parse cycle.
+	samples []grpcSample
+}
+
+// grpcRequest is the SnapshotRequest message.
+type grpcRequest struct {
+	// kind selects the samples of the kind, empty selects all the kinds.
+	kind string
+
+	// pattern is the shell pattern the names are matched against, empty matches all the names.
+	pattern string
+}
+
+// grpcError is an error returned to the client with its gRPC status code.
+type grpcError struct {
+	// code is the gRPC status code.
+	code int
+
+	// message describes the error.
+	message string
+}
+
+// Error implements error.
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+// grpcServer implements metricSink.
+type grpcServer struct {
+	// options holds the configurable options.
+	options *GrpcOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// latest is the last parse cycle, nil before the first one.
+	latest atomic.Pointer[grpcSnapshot]
+
+	// server serves the service.
+	server *http.Server
+
+	// cancel cancels the contexts of the calls, this ends the streaming calls.
+	cancel context.CancelFunc
+
+	// streamsMu protects streams.
+	streamsMu sync.Mutex
+
+	// streams are the queues of the snapshots of the streaming clients.
+	streams map[chan *grpcSnapshot]bool
+}
+
+// newGrpcServer starts the gRPC server in the background.
+func newGrpcServer(options *GrpcOptions, logger sysLogger) (*grpcServer, error) {
+	listener, err := net.Listen("tcp", options.Listen)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &grpcServer{
+		options: options,
+		logger:  logger,
+		cancel:  cancel,
+	}
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	g.server = &http.Server{
+		Handler:      g.handler(),
+		ReadTimeout:  httpTimeout,
+		WriteTimeout: httpTimeout,
+		Protocols:    protocols,
+		BaseContext:  func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		if err := g.server.Serve(listener); err != http.ErrServerClosed {
+			logger.Err(fmt.Sprintf("newGrpcServer(): The gRPC server on %s stopped, error: %s", options.Listen, err))
+		}
+	}()
+	return g, nil
+}
+
+// flush keeps the samples of the parse cycle for the following requests and sends them to the streaming clients.
+func (g *grpcServer) flush(values []metricValue, at time.Time) {
+	snapshot := newGrpcSnapshot(g.latest.Load(), values, at)
+	g.latest.Store(snapshot)
+	g.streamsMu.Lock()
+	defer g.streamsMu.Unlock()
+	for stream := range g.streams {
+		select {
+		case stream <- snapshot:
+		default:
+		}
+	}
+}
+
+// newGrpcSnapshot creates the snapshot of the values, the rates of the counters are computed against the previous
+// snapshot.
+func newGrpcSnapshot(previous *grpcSnapshot, values []metricValue, at time.Time) *grpcSnapshot {
+	type key struct{ kind, name, metric string }
+	previousValues := make(map[key]int64)
+	snapshot := &grpcSnapshot{at: at}
+	if previous != nil && at.After(previous.at) {
+		snapshot.interval = at.Sub(previous.at).Seconds()
+		for _, sample := range previous.samples {
+			previousValues[key{sample.kind, sample.name, sample.metric}] = sample.value
+		}
+	}
+	for _, v := range values {
+		var value int64
+		switch number := v.value.(type) {
+		case int:
+			value = int64(number)
+		case int64:
+			value = number
+		default:
+			continue
+		}
+		iface, class := v.components()
+		sample := grpcSample{
+			kind:   v.kind(),
+			name:   v.name,
+			iface:  iface,
+			class:  class,
+			metric: v.metric,
+			value:  value,
+		}
+		previousValue, ok := previousValues[key{sample.kind, sample.name, sample.metric}]
+		// A counter that went down was reset, it has no meaningful change.
+		if ok && v.counter() && value >= previousValue {
+			sample.rate = float64(sample.value-previousValue) / snapshot.interval
+			sample.hasRate = true
+		}
+		snapshot.samples = append(snapshot.samples, sample)
+	}
+	return snapshot
+}
+
+// close ends the streaming calls and stops the server after the other calls were answered.
+func (g *grpcServer) close(ctx context.Context) error {
+	g.cancel()
+	return g.server.Shutdown(ctx)
+}
+
+// subscribe registers a streaming client and returns the queue of its snapshots.
+func (g *grpcServer) subscribe() chan *grpcSnapshot {
+	stream := make(chan *grpcSnapshot, grpcStreamBuffer)
+	g.streamsMu.Lock()
+	defer g.streamsMu.Unlock()
+	if g.streams == nil {
+		g.streams = make(map[chan *grpcSnapshot]bool)
+	}
+	g.streams[stream] = true
+	return stream
+}
+
+// unsubscribe removes a streaming client.
+func (g *grpcServer) unsubscribe(stream chan *grpcSnapshot) {
+	g.streamsMu.Lock()
+	defer g.streamsMu.Unlock()
+	delete(g.streams, stream)
+}
+
+// handler returns the handler of the methods of the service.
+func (g *grpcServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(grpcService+"GetSnapshot", g.serveMethod(g.getSnapshot))
+	mux.HandleFunc(grpcService+"StreamUpdates", g.serveMethod(g.streamUpdates))
+	return mux
+}
+
+// serveMethod returns the handler of a method, it reads the request and writes the status of the method to the
+// trailers of the response.
+func (g *grpcServer) serveMethod(method func(*grpcRequest, http.ResponseWriter, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+
+		message, err := grpcReadMessage(r.Body)
+		if err == nil {
+			var request *grpcRequest
+			if request, err = grpcDecodeRequest(message); err == nil {
+				err = method(request, w, r)
+			}
+		}
+		code := grpcStatusOK
+		if err != nil {
+			code = grpcStatusUnavailable
+			if e, ok := err.(*grpcError); ok {
+				code = e.code
+			}
+			w.Header().Set("Grpc-Message", err.Error())
+		}
+		w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	}
+}
+
+// getSnapshot implements the GetSnapshot method.
+func (g *grpcServer) getSnapshot(request *grpcRequest, w http.ResponseWriter, r *http.Request) error {
+	latest := g.latest.Load()
+	if latest == nil {
+		return &grpcError{grpcStatusUnavailable, "no data was parsed yet"}
+	}
+	_, err := w.Write(grpcFrame(grpcEncodeSnapshot(latest, request)))
+	return err
+}
+
+// streamUpdates implements the StreamUpdates method, it returns when the client cancels the call.
+func (g *grpcServer) streamUpdates(request *grpcRequest, w http.ResponseWriter, r *http.Request) error {
+	// The stream outlives the write timeout of the server.
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		return err
+	}
+	stream := g.subscribe()
+	defer g.unsubscribe(stream)
+	send := func(snapshot *grpcSnapshot) error {
+		if _, err := w.Write(grpcFrame(grpcEncodeSnapshot(snapshot, request))); err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+
+	var sent time.Time
+	if latest := g.latest.Load(); latest != nil {
+		if err := send(latest); err != nil {
+			return err
+		}
+		sent = latest.at
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case snapshot := <-stream:
+			// The last parse cycle may have been queued after it was sent.
+			if !snapshot.at.After(sent) {
+				continue
+			}
+			if err := send(snapshot); err != nil {
+				return err
+			}
+			sent = snapshot.at
+		}
+	}
+}
+
+// grpcReadMessage reads a length-prefixed message.
+func grpcReadMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, &grpcError{grpcStatusInvalidArgument, fmt.Sprintf("unable to read the message, error: %s", err)}
+	}
+	if header[0] != 0 {
+		return nil, &grpcError{grpcStatusUnimplemented, "compressed messages aren't supported"}
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > grpcMaxRequestSize {
+		return nil, &grpcError{grpcStatusInvalidArgument, fmt.Sprintf("the message of %d bytes is too large", length)}
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, &grpcError{grpcStatusInvalidArgument, fmt.Sprintf("unable to read the message, error: %s", err)}
+	}
+	return message, nil
+}
+
+// grpcFrame prefixes the message with the uncompressed flag and its length.
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// grpcDecodeRequest decodes and validates the SnapshotRequest message.
+func grpcDecodeRequest(message []byte) (*grpcRequest, error) {
+	request := &grpcRequest{}
+	err := protoFields(message, func(field, wireType int, _ uint64, data []byte) error {
+		switch {
+		case field == 1 && wireType == protoBytes:
+			request.kind = string(data)
+		case field == 2 && wireType == protoBytes:
+			request.pattern = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &grpcError{grpcStatusInvalidArgument, err.Error()}
+	}
+	switch request.kind {
+	case "", "class", "user", "group", "iface":
+	default:
+		return nil, &grpcError{grpcStatusInvalidArgument, fmt.Sprintf("unknown kind '%s'", request.kind)}
+	}
+	if _, err := path.Match(request.pattern, ""); err != nil {
+		return nil, &grpcError{grpcStatusInvalidArgument, fmt.Sprintf("invalid name pattern '%s'", request.pattern)}
+	}
+	return request, nil
+}
+
+// grpcEncodeSnapshot encodes the Snapshot message with the samples selected by the request.
+func grpcEncodeSnapshot(snapshot *grpcSnapshot, request *grpcRequest) []byte {
+	var message []byte
+	message = protoAppendVarint(message, 1, uint64(snapshot.at.UnixNano()))
+	message = protoAppendDouble(message, 2, snapshot.interval, false)
+	for _, sample := range snapshot.samples {
+		if request.kind != "" && sample.kind != request.kind {
+			continue
+		}
+		if request.pattern != "" {
+			if matched, _ := path.Match(request.pattern, sample.name); !matched {
+				continue
+			}
+		}
+		var encoded []byte
+		encoded = protoAppendBytes(encoded, 1, []byte(sample.kind))
+		encoded = protoAppendBytes(encoded, 2, []byte(sample.name))
+		encoded = protoAppendBytes(encoded, 3, []byte(sample.iface))
+		encoded = protoAppendBytes(encoded, 4, []byte(sample.class))
+		encoded = protoAppendBytes(encoded, 5, []byte(sample.metric))
+		encoded = protoAppendVarint(encoded, 6, uint64(sample.value))
+		encoded = protoAppendDouble(encoded, 7, sample.rate, sample.hasRate)
+		message = protoAppendTag(message, 3, protoBytes)
+		message = binary.AppendUvarint(message, uint64(len(encoded)))
+		message = append(message, encoded...)
+	}
+	return message
+}
+
+// protoAppendTag appends the tag of the field.
+func protoAppendTag(message []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(message, uint64(field)<<3|uint64(wireType))
+}
+
+// protoAppendVarint appends the varint field unless it has the default value.
+func protoAppendVarint(message []byte, field int, value uint64) []byte {
+	if value == 0 {
+		return message
+	}
+	return binary.AppendUvarint(protoAppendTag(message, field, protoVarint), value)
+}
+
+// protoAppendDouble appends the double field unless it has the default value and isn't explicitly present.
+func protoAppendDouble(message []byte, field int, value float64, present bool) []byte {
+	if value == 0 && !present {
+		return message
+	}
+	return binary.LittleEndian.AppendUint64(protoAppendTag(message, field, protoFixed64), math.Float64bits(value))
+}
+
+// protoAppendBytes appends the length-delimited field unless it is empty.
+func protoAppendBytes(message []byte, field int, data []byte) []byte {
+	if len(data) == 0 {
+		return message
+	}
+	message = binary.AppendUvarint(protoAppendTag(message, field, protoBytes), uint64(len(data)))
+	return append(message, data...)
+}
+
+// protoFields calls the function for every field of the message. The value is set for the varint and fixed fields,
+// the data for the length-delimited fields.
+func protoFields(message []byte, f func(field, wireType int, value uint64, data []byte) error) error {
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return fmt.Errorf("invalid tag")
+		}
+		message = message[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+		var value uint64
+		var data []byte
+		switch wireType {
+		case protoVarint:
+			if value, n = binary.Uvarint(message); n <= 0 {
+				return fmt.Errorf("invalid varint of the field %d", field)
+			}
+			message = message[n:]
+		case protoFixed64:
+			if len(message) < 8 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			value, message = binary.LittleEndian.Uint64(message), message[8:]
+		case protoFixed32:
+			if len(message) < 4 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			value, message = uint64(binary.LittleEndian.Uint32(message)), message[4:]
+		case protoBytes:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			data, message = message[n:n+int(length)], message[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported wire type %d of the field %d", wireType, field)
+		}
+		if err := f(field, wireType, value, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// decodeGrpcSnapshot decodes the Snapshot message.
+func decodeGrpcSnapshot(t *testing.T, message []byte) *grpcSnapshot {
+	t.Helper()
+	snapshot := &grpcSnapshot{}
+	var nanos int64
+	err := protoFields(message, func(field, wireType int, value uint64, data []byte) error {
+		switch field {
+		case 1:
+			nanos = int64(value)
+		case 2:
+			snapshot.interval = math.Float64frombits(value)
+		case 3:
+			snapshot.samples = append(snapshot.samples, grpcSample{})
+			return protoFields(data, func(field, wireType int, value uint64, data []byte) error {
+				s := &snapshot.samples[len(snapshot.samples)-1]
+				switch field {
+				case 1:
+					s.kind = string(data)
+				case 2:
+					s.name = string(data)
+				case 3:
+					s.iface = string(data)
+				case 4:
+					s.class = string(data)
+				case 5:
+					s.metric = string(data)
+				case 6:
+					s.value = int64(value)
+				case 7:
+					s.rate, s.hasRate = math.Float64frombits(value), true
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("protoFields => unexpected error: %s", err)
+	}
+	snapshot.at = time.Unix(0, nanos).UTC()
+	return snapshot
+}
+
+func TestNewGrpcSnapshot(t *testing.T) {
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	previous := newGrpcSnapshot(nil, []metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1000)},
+		{"efficiency", tcNameLeaf, "eth0:2:3", 50},
+		{"userDownBytes", tcUserNameLeaf, "john", int64(500)},
+	}, start)
+	got := newGrpcSnapshot(previous, []metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(3000)},
+		{"efficiency", tcNameLeaf, "eth0:2:3", 60},
+		{"userDownBytes", tcUserNameLeaf, "john", int64(100)},
+		{"groupBytes", tcGroupNameLeaf, "office", int64(10)},
+	}, start.Add(10*time.Second))
+
+	want := &grpcSnapshot{
+		at:       start.Add(10 * time.Second),
+		interval: 10,
+		samples: []grpcSample{
+			{"class", "eth0:2:3", "eth0", "2:3", "sentBytes", 3000, 200, true},
+			{"class", "eth0:2:3", "eth0", "2:3", "efficiency", 60, 0, false},
+			{"user", "john", "users", "john", "userDownBytes", 100, 0, false},
+			{"group", "office", "groups", "office", "groupBytes", 10, 0, false},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newGrpcSnapshot => got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestGrpcEncodeSnapshot(t *testing.T) {
+	snapshot := &grpcSnapshot{
+		at:       time.Date(2013, 1, 1, 0, 0, 10, 0, time.UTC),
+		interval: 10,
+		samples: []grpcSample{
+			{"class", "eth0:2:3", "eth0", "2:3", "sentBytes", 3000, 200, true},
+			{"class", "eth0:2:3", "eth0", "2:3", "droppedPkt", 0, 0, true},
+			{"class", "eth1:1:0", "eth1", "1:0", "sentBytes", 10, 0, false},
+			{"user", "john", "users", "john", "userDownBytes", 100, 0, false},
+		},
+	}
+
+	testData := []struct {
+		desc    string
+		request *grpcRequest
+		want    []grpcSample
+	}{
+		{
+			desc:    "all the samples",
+			request: &grpcRequest{},
+			want:    snapshot.samples,
+		},
+		{
+			desc:    "samples of a kind",
+			request: &grpcRequest{kind: "user"},
+			want:    snapshot.samples[3:],
+		},
+		{
+			desc:    "samples matching the pattern",
+			request: &grpcRequest{pattern: "eth0:*"},
+			want:    snapshot.samples[:2],
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := decodeGrpcSnapshot(t, grpcEncodeSnapshot(snapshot, tc.request))
+			want := &grpcSnapshot{at: snapshot.at, interval: snapshot.interval, samples: tc.want}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("grpcEncodeSnapshot => got: %+v, want: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestGrpcDecodeRequest(t *testing.T) {
+	testData := []struct {
+		desc    string
+		message []byte
+		want    *grpcRequest
+		wantErr string
+	}{
+		{
+			desc:    "empty request",
+			message: nil,
+			want:    &grpcRequest{},
+		},
+		{
+			desc:    "kind and pattern",
+			message: []byte("\x0a\x04user\x12\x02j*"),
+			want:    &grpcRequest{kind: "user", pattern: "j*"},
+		},
+		{
+			desc:    "unknown fields are skipped",
+			message: []byte("\x18\x96\x01\x21\x00\x00\x00\x00\x00\x00\x00\x00\x2d\x00\x00\x00\x00\x0a\x05class"),
+			want:    &grpcRequest{kind: "class"},
+		},
+		{
+			desc:    "unknown kind",
+			message: []byte("\x0a\x05qdisc"),
+			wantErr: "unknown kind 'qdisc'",
+		},
+		{
+			desc:    "invalid pattern",
+			message: []byte("\x12\x01["),
+			wantErr: "invalid name pattern '['",
+		},
+		{
+			desc:    "truncated field",
+			message: []byte("\x0a\x05use"),
+			wantErr: "truncated field 1",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := grpcDecodeRequest(tc.message)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("grpcDecodeRequest => got error: %v, want: %s", err, tc.wantErr)
+				}
+				if e, ok := err.(*grpcError); !ok || e.code != grpcStatusInvalidArgument {
+					t.Errorf("grpcDecodeRequest => got error: %#v, want the status %d", err, grpcStatusInvalidArgument)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("grpcDecodeRequest => unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("grpcDecodeRequest => got: %+v, want: %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGrpcReadMessage(t *testing.T) {
+	testData := []struct {
+		desc     string
+		frame    []byte
+		want     []byte
+		wantCode int
+	}{
+		{"message", grpcFrame([]byte("\x0a\x04user")), []byte("\x0a\x04user"), grpcStatusOK},
+		{"compressed", []byte("\x01\x00\x00\x00\x00"), nil, grpcStatusUnimplemented},
+		{"too large", []byte("\x00\x01\x00\x00\x01"), nil, grpcStatusInvalidArgument},
+		{"truncated", []byte("\x00\x00\x00\x00\x05ab"), nil, grpcStatusInvalidArgument},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := grpcReadMessage(bytes.NewReader(tc.frame))
+			if tc.wantCode != grpcStatusOK {
+				if e, ok := err.(*grpcError); !ok || e.code != tc.wantCode {
+					t.Errorf("grpcReadMessage => got error: %#v, want the status %d", err, tc.wantCode)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, tc.want) {
+				t.Errorf("grpcReadMessage => got: %q, %v, want: %q, nil", got, err, tc.want)
+			}
+		})
+	}
+}
+
+// grpcCall calls the method of the service over cleartext HTTP/2 and returns the response.
+func grpcCall(t *testing.T, ctx context.Context, address, method string, request []byte) *http.Response {
+	t.Helper()
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+address+grpcService+method, bytes.NewReader(grpcFrame(request)))
+	if err != nil {
+		t.Fatalf("http.NewRequest => unexpected error: %s", err)
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+	response, err := client.Do(r)
+	if err != nil {
+		t.Fatalf("%s => unexpected error: %s", method, err)
+	}
+	if response.ProtoMajor != 2 {
+		t.Errorf("%s => got protocol: %s, want HTTP/2", method, response.Proto)
+	}
+	return response
+}
+
+// grpcReadSnapshot reads the next Snapshot message of the response.
+func grpcReadSnapshot(t *testing.T, response *http.Response) *grpcSnapshot {
+	t.Helper()
+	message, err := grpcReadMessage(response.Body)
+	if err != nil {
+		t.Fatalf("grpcReadMessage => unexpected error: %s", err)
+	}
+	return decodeGrpcSnapshot(t, message)
+}
+
+func TestGrpcServer(t *testing.T) {
+	g := &grpcServer{options: &GrpcOptions{}, logger: &fakeSyslog{}}
+	server := httptest.NewUnstartedServer(g.handler())
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+	address := server.Listener.Addr().String()
+	ctx := context.Background()
+
+	response := grpcCall(t, ctx, address, "GetSnapshot", nil)
+	io.ReadAll(response.Body)
+	response.Body.Close()
+	if got := response.Trailer.Get("Grpc-Status"); got != "14" {
+		t.Errorf("GetSnapshot => got status: %s, want: 14", got)
+	}
+	if got := response.Trailer.Get("Grpc-Message"); got != "no data was parsed yet" {
+		t.Errorf("GetSnapshot => got message: %s, want: no data was parsed yet", got)
+	}
+
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	g.flush([]metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1000)},
+		{"userDownBytes", tcUserNameLeaf, "john", int64(500)},
+	}, start)
+
+	response = grpcCall(t, ctx, address, "GetSnapshot", []byte("\x0a\x04user"))
+	got := grpcReadSnapshot(t, response)
+	io.ReadAll(response.Body)
+	response.Body.Close()
+	want := &grpcSnapshot{at: start, samples: []grpcSample{{"user", "john", "users", "john", "userDownBytes", 500, 0, false}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetSnapshot => got: %+v, want: %+v", got, want)
+	}
+	if got := response.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("GetSnapshot => got status: %s, want: 0", got)
+	}
+
+	response = grpcCall(t, ctx, address, "GetSnapshot", []byte("\x0a\x05qdisc"))
+	io.ReadAll(response.Body)
+	response.Body.Close()
+	if got := response.Trailer.Get("Grpc-Status"); got != "3" {
+		t.Errorf("GetSnapshot => got status: %s, want: 3", got)
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	response = grpcCall(t, streamCtx, address, "StreamUpdates", []byte("\x12\x08eth0:2:3"))
+	defer response.Body.Close()
+	if got := grpcReadSnapshot(t, response); !got.at.Equal(start) {
+		t.Errorf("StreamUpdates => got the first snapshot at: %s, want: %s", got.at, start)
+	}
+	g.flush([]metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(3000)},
+		{"userDownBytes", tcUserNameLeaf, "john", int64(2000)},
+	}, start.Add(10*time.Second))
+	got = grpcReadSnapshot(t, response)
+	want = &grpcSnapshot{
+		at:       start.Add(10 * time.Second),
+		interval: 10,
+		samples:  []grpcSample{{"class", "eth0:2:3", "eth0", "2:3", "sentBytes", 3000, 200, true}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamUpdates => got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestNewGrpcServer(t *testing.T) {
+	g, err := newGrpcServer(&GrpcOptions{Listen: "127.0.0.1:0"}, &fakeSyslog{})
+	if err != nil {
+		t.Fatalf("newGrpcServer => unexpected error: %s", err)
+	}
+	defer g.server.Close()
+
+	if _, err := newGrpcServer(&GrpcOptions{Listen: "256.0.0.1:80"}, &fakeSyslog{}); err == nil {
+		t.Errorf("newGrpcServer => got no error for an invalid address")
+	}
+}
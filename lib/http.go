@@ -0,0 +1,538 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+http.go serves the exported metrics of the last parse cycle as JSON over HTTP, so that scripts and dashboards don't
+have to go through SNMP.
+
+The endpoints are:
+/              - A minimal dashboard with the Qdiscs / Classes, the users and the sparklines of their rates.
+/v1/interfaces - The interfaces with the names of their Qdiscs / Classes and their totals.
+/v1/classes    - The Qdiscs / Classes.
+/v1/users      - The users.
+/v1/groups     - The interface groups.
+/v1/stream     - A stream of server-sent events with the changes per second of the counters after each parse cycle.
+/v1/history    - The recent events of the stream.
+
+Every item has its name and the metrics as in the alert rules, e.g.:
+{"time": "2013-01-01T00:00:00Z", "data": [{"name": "eth0:2:3", "iface": "eth0", "class": "2:3", "sentBytes": 1500}]}
+
+The items can be filtered by the query parameters:
+name   - A shell pattern the names are matched against, e.g. "eth0:2:*".
+iface  - The interface of the Qdiscs / Classes.
+fields - A comma separated list of the fields of the items, e.g. "name,sentBytes". Defaults to all the fields.
+
+The events of the stream carry the items of all the kinds that changed since the previous parse cycle, e.g.:
+event: rates
+data: {"time": "2013-01-01T00:00:10Z", "interval": 10, "data": [{"name": "john", "kind": "user", "userDownBytes": 150}]}
+
+Besides the filters above, the stream and the history can be limited to a kind of items by the query parameter
+kind, one of "class", "user", "group" or "iface". A client that doesn't keep up misses the events.
+*/
+
+package lib
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// httpTimeout is the timeout of reading a request and writing a response.
+	httpTimeout = 10 * time.Second
+
+	// httpStreamKeepalive is the interval of the comments sent to the stream clients to keep the connections open
+	// when no parse cycles happen.
+	httpStreamKeepalive = 30 * time.Second
+
+	// httpStreamBuffer is the number of events queued for a stream client, newer events are dropped when it is full.
+	httpStreamBuffer = 4
+
+	// httpHistorySize is the number of the recent events of the stream kept for /v1/history.
+	httpHistorySize = 120
+)
+
+// dashboardPage is the single page of the dashboard.
+//
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// HttpOptions are the options of the embedded HTTP server.
+type HttpOptions struct {
+	// Listen is the address the HTTP server listens on, e.g. "127.0.0.1:8080".
+	// The HTTP server isn't started if this isn't set.
+	Listen string
+}
+
+// httpCycle are the values of a parse cycle.
+type httpCycle struct {
+	// at is the time the parse cycle started.
+	at time.Time
+
+	// values are the values of the metrics.
+	values []metricValue
+}
+
+// httpResponse is the response of the endpoints.
+type httpResponse struct {
+	// Time is the time the parse cycle started.
+	Time time.Time `json:"time"`
+
+	// Data are the items.
+	Data []map[string]interface{} `json:"data"`
+}
+
+// httpRates is an event of the stream.
+type httpRates struct {
+	// Time is the time the parse cycle started.
+	Time time.Time `json:"time"`
+
+	// Interval is the number of seconds since the previous parse cycle.
+	Interval float64 `json:"interval"`
+
+	// Data are the items with the changes per second of the counters.
+	Data []map[string]interface{} `json:"data"`
+}
+
+// httpHistory is the response of /v1/history.
+type httpHistory struct {
+	// Data are the events, the oldest one first.
+	Data []httpRates `json:"data"`
+}
+
+// httpFilter selects the items by the query parameters.
+type httpFilter struct {
+	// kind is the kind of the items of the events, empty matches all the kinds.
+	kind string
+
+	// pattern is the shell pattern the names are matched against, empty matches all the names.
+	pattern string
+
+	// iface is the interface of the Qdiscs / Classes, empty matches all the items.
+	iface string
+
+	// fields are the fields of the selected items, empty selects all the fields.
+	fields []string
+}
+
+// httpServer implements metricSink.
+type httpServer struct {
+	// options holds the configurable options.
+	options *HttpOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// latest is the last parse cycle, nil before the first one.
+	latest atomic.Pointer[httpCycle]
+
+	// server serves the endpoints.
+	server *http.Server
+
+	// cancel cancels the contexts of the requests, this ends the streams.
+	cancel context.CancelFunc
+
+	// streamsMu protects streams and history.
+	streamsMu sync.Mutex
+
+	// streams are the queues of the events of the connected stream clients.
+	streams map[chan *httpRates]bool
+
+	// history are the last httpHistorySize events, the oldest one first.
+	history []*httpRates
+}
+
+// newHttpServer starts the HTTP server in the background.
+func newHttpServer(options *HttpOptions, logger sysLogger) (*httpServer, error) {
+	listener, err := net.Listen("tcp", options.Listen)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &httpServer{
+		options: options,
+		logger:  logger,
+		cancel:  cancel,
+	}
+	h.server = &http.Server{
+		Handler:      h.handler(),
+		ReadTimeout:  httpTimeout,
+		WriteTimeout: httpTimeout,
+		BaseContext:  func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		if err := h.server.Serve(listener); err != http.ErrServerClosed {
+			logger.Err(fmt.Sprintf("newHttpServer(): The HTTP server on %s stopped, error: %s", options.Listen, err))
+		}
+	}()
+	return h, nil
+}
+
+// flush keeps the values of the parse cycle for the following requests and sends their changes since the previous
+// parse cycle to the stream clients.
+func (h *httpServer) flush(values []metricValue, at time.Time) {
+	current := &httpCycle{at, values}
+	previous := h.latest.Swap(current)
+	if previous == nil {
+		return
+	}
+	rates := cycleRates(previous, current)
+	if rates == nil {
+		return
+	}
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+	if len(h.history) == httpHistorySize {
+		h.history = h.history[1:]
+	}
+	h.history = append(h.history, rates)
+	for stream := range h.streams {
+		select {
+		case stream <- rates:
+		default:
+		}
+	}
+}
+
+// close ends the streams and stops the server after the other requests were answered.
+func (h *httpServer) close(ctx context.Context) error {
+	h.cancel()
+	return h.server.Shutdown(ctx)
+}
+
+// subscribe registers a stream client and returns the queue of its events.
+func (h *httpServer) subscribe() chan *httpRates {
+	stream := make(chan *httpRates, httpStreamBuffer)
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+	if h.streams == nil {
+		h.streams = make(map[chan *httpRates]bool)
+	}
+	h.streams[stream] = true
+	return stream
+}
+
+// unsubscribe removes a stream client.
+func (h *httpServer) unsubscribe(stream chan *httpRates) {
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+	delete(h.streams, stream)
+}
+
+// handler returns the handler of the endpoints.
+func (h *httpServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/interfaces", h.serveItems(h.interfaces))
+	mux.HandleFunc("/v1/classes", h.serveItems(h.kindItems("class")))
+	mux.HandleFunc("/v1/users", h.serveItems(h.kindItems("user")))
+	mux.HandleFunc("/v1/groups", h.serveItems(h.kindItems("group")))
+	mux.HandleFunc("/v1/stream", h.serveStream)
+	mux.HandleFunc("/v1/history", h.serveHistory)
+	mux.HandleFunc("/", h.serveDashboard)
+	return mux
+}
+
+// serveItems returns the handler of an endpoint serving the items created by the function, filtered by the query.
+func (h *httpServer) serveItems(items func([]metricValue) []map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		latest := h.latest.Load()
+		if latest == nil {
+			http.Error(w, "no data was parsed yet", http.StatusServiceUnavailable)
+			return
+		}
+		filter, err := newHttpFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response := httpResponse{Time: latest.at, Data: filter.apply(items(latest.values))}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			h.logger.Err(fmt.Sprintf("serveItems(): Unable to write the response to %s, error: %s", r.RemoteAddr, err))
+		}
+	}
+}
+
+// serveStream sends the events to a stream client until it disconnects.
+func (h *httpServer) serveStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := newHttpFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The stream outlives the write timeout of the server.
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		h.logger.Err(fmt.Sprintf("serveStream(): Unable to clear the write deadline for %s, error: %s", r.RemoteAddr, err))
+		return
+	}
+
+	stream := h.subscribe()
+	defer h.unsubscribe(stream)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		h.logger.Err(fmt.Sprintf("serveStream(): Unable to start the stream to %s, error: %s", r.RemoteAddr, err))
+		return
+	}
+
+	keepalive := time.NewTicker(httpStreamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case rates := <-stream:
+			var data []byte
+			if data, err = json.Marshal(filter.applyRates(rates)); err == nil {
+				_, err = fmt.Fprintf(w, "event: rates\ndata: %s\n\n", data)
+			}
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// serveHistory serves the recent events of the stream, filtered by the query.
+func (h *httpServer) serveHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := newHttpFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.streamsMu.Lock()
+	history := h.history
+	h.streamsMu.Unlock()
+
+	response := httpHistory{Data: []httpRates{}}
+	for _, rates := range history {
+		response.Data = append(response.Data, filter.applyRates(rates))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Err(fmt.Sprintf("serveHistory(): Unable to write the response to %s, error: %s", r.RemoteAddr, err))
+	}
+}
+
+// serveDashboard serves the page of the dashboard.
+func (h *httpServer) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write(dashboardPage); err != nil {
+		h.logger.Err(fmt.Sprintf("serveDashboard(): Unable to write the response to %s, error: %s", r.RemoteAddr, err))
+	}
+}
+
+// cycleRates creates an item of every Qdisc / Class, user or group with the changes per second of its counters
+// between the parse cycles. Returns nil if no time elapsed between them.
+func cycleRates(previous, current *httpCycle) *httpRates {
+	interval := current.at.Sub(previous.at).Seconds()
+	if interval <= 0 {
+		return nil
+	}
+	type key struct{ kind, name, metric string }
+	previousValues := make(map[key]float64)
+	for _, v := range previous.values {
+		if value, ok := alertValue(v.value); ok && v.counter() {
+			previousValues[key{v.kind(), v.name, v.metric}] = value
+		}
+	}
+
+	rates := &httpRates{Time: current.at, Interval: interval}
+	positions := make(map[key]int)
+	for _, v := range current.values {
+		value, ok := alertValue(v.value)
+		if !ok || !v.counter() {
+			continue
+		}
+		previousValue, ok := previousValues[key{v.kind(), v.name, v.metric}]
+		// A counter that went down was reset, it has no meaningful change.
+		if !ok || value < previousValue {
+			continue
+		}
+		itemKey := key{kind: v.kind(), name: v.name}
+		position, ok := positions[itemKey]
+		if !ok {
+			position = len(rates.Data)
+			positions[itemKey] = position
+			item := map[string]interface{}{"name": v.name, "kind": v.kind()}
+			if v.kind() == "class" {
+				item["iface"], item["class"] = v.components()
+			}
+			rates.Data = append(rates.Data, item)
+		}
+		rates.Data[position][v.metric] = (value - previousValue) / interval
+	}
+	return rates
+}
+
+// kindItems returns a function that creates an item of every Qdisc / Class, user or group of the kind, in the order
+// they first appear in the values.
+func (h *httpServer) kindItems(kind string) func([]metricValue) []map[string]interface{} {
+	return func(values []metricValue) []map[string]interface{} {
+		var items []map[string]interface{}
+		positions := make(map[string]int)
+		for _, v := range values {
+			if v.kind() != kind {
+				continue
+			}
+			position, ok := positions[v.name]
+			if !ok {
+				position = len(items)
+				positions[v.name] = position
+				item := map[string]interface{}{"name": v.name}
+				if kind == "class" {
+					item["iface"], item["class"] = v.components()
+				}
+				items = append(items, item)
+			}
+			items[position][v.metric] = v.value
+		}
+		return items
+	}
+}
+
+// interfaces creates an item of every interface with the names of its Qdiscs / Classes and its totals if these are
+// exported.
+func (h *httpServer) interfaces(values []metricValue) []map[string]interface{} {
+	var items []map[string]interface{}
+	positions := make(map[string]int)
+	position := func(iface string) int {
+		p, ok := positions[iface]
+		if !ok {
+			p = len(items)
+			positions[iface] = p
+			items = append(items, map[string]interface{}{"name": iface, "iface": iface, "classes": []string{}})
+		}
+		return p
+	}
+	for _, item := range h.kindItems("class")(values) {
+		p := position(item["iface"].(string))
+		items[p]["classes"] = append(items[p]["classes"].([]string), item["name"].(string))
+	}
+	for _, totals := range h.kindItems("iface")(values) {
+		p := position(totals["name"].(string))
+		for metric, value := range totals {
+			items[p][metric] = value
+		}
+	}
+	return items
+}
+
+// newHttpFilter creates the filter from the query parameters kind, name, iface and fields.
+func newHttpFilter(query url.Values) (*httpFilter, error) {
+	kind := query.Get("kind")
+	switch kind {
+	case "", "class", "user", "group", "iface":
+	default:
+		return nil, fmt.Errorf("unknown kind '%s'", kind)
+	}
+	pattern := query.Get("name")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid name pattern '%s'", pattern)
+	}
+	filter := &httpFilter{
+		kind:    kind,
+		pattern: pattern,
+		iface:   query.Get("iface"),
+	}
+	if query.Get("fields") != "" {
+		filter.fields = strings.Split(query.Get("fields"), ",")
+	}
+	return filter, nil
+}
+
+// apply returns the selected fields of the items that match the filter.
+func (f *httpFilter) apply(items []map[string]interface{}) []map[string]interface{} {
+	selected := []map[string]interface{}{}
+	for _, item := range items {
+		if f.pattern != "" {
+			if matched, _ := path.Match(f.pattern, item["name"].(string)); !matched {
+				continue
+			}
+		}
+		if f.iface != "" && item["iface"] != f.iface {
+			continue
+		}
+		selected = append(selected, selectFields(item, f.fields))
+	}
+	return selected
+}
+
+// applyRates returns the event with the selected fields of the items of the kind that match the filter.
+func (f *httpFilter) applyRates(rates *httpRates) httpRates {
+	var items []map[string]interface{}
+	for _, item := range rates.Data {
+		if f.kind == "" || item["kind"] == f.kind {
+			items = append(items, item)
+		}
+	}
+	return httpRates{Time: rates.Time, Interval: rates.Interval, Data: f.apply(items)}
+}
+
+// selectFields returns the item with only the fields, or the whole item if no fields are selected.
+func selectFields(item map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return item
+	}
+	selected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if value, ok := item[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpServerEndpoints(t *testing.T) {
+	h := &httpServer{options: &HttpOptions{}, logger: &fakeSyslog{}}
+	h.flush([]metricValue{
+		{"droppedPkt", tcNameLeaf, "eth0:2:3", int64(1)},
+		{"droppedPkt", tcNameLeaf, "eth1:1:0", int64(0)},
+		{"droppedPkt", tcNameLeaf, "eth0:2:4", int64(2)},
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1500)},
+		{"sentBytes", tcNameLeaf, "eth1:1:0", int64(10)},
+		{"sentBytes", tcNameLeaf, "eth0:2:4", int64(3000)},
+		{"userDownBytes", tcUserNameLeaf, "john", int64(500)},
+	}, time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	testData := []struct {
+		desc       string
+		method     string
+		url        string
+		wantStatus int
+		want       string
+	}{
+		{
+			desc:       "classes",
+			url:        "/v1/classes",
+			wantStatus: http.StatusOK,
+			want: `{"time":"2013-01-01T00:00:00Z","data":[` +
+				`{"class":"2:3","droppedPkt":1,"iface":"eth0","name":"eth0:2:3","sentBytes":1500},` +
+				`{"class":"1:0","droppedPkt":0,"iface":"eth1","name":"eth1:1:0","sentBytes":10},` +
+				`{"class":"2:4","droppedPkt":2,"iface":"eth0","name":"eth0:2:4","sentBytes":3000}]}`,
+		},
+		{
+			desc:       "classes filtered by the name with selected fields",
+			url:        "/v1/classes?name=eth0:2:*&fields=name,sentBytes,bogus",
+			wantStatus: http.StatusOK,
+			want:       `{"time":"2013-01-01T00:00:00Z","data":[{"name":"eth0:2:3","sentBytes":1500},{"name":"eth0:2:4","sentBytes":3000}]}`,
+		},
+		{
+			desc:       "classes filtered by the interface",
+			url:        "/v1/classes?iface=eth1&fields=name",
+			wantStatus: http.StatusOK,
+			want:       `{"time":"2013-01-01T00:00:00Z","data":[{"name":"eth1:1:0"}]}`,
+		},
+		{
+			desc:       "interfaces",
+			url:        "/v1/interfaces",
+			wantStatus: http.StatusOK,
+			want:       `{"time":"2013-01-01T00:00:00Z","data":[{"classes":["eth0:2:3","eth0:2:4"],"iface":"eth0","name":"eth0"},{"classes":["eth1:1:0"],"iface":"eth1","name":"eth1"}]}`,
+		},
+		{
+			desc:       "users",
+			url:        "/v1/users",
+			wantStatus: http.StatusOK,
+			want:       `{"time":"2013-01-01T00:00:00Z","data":[{"name":"john","userDownBytes":500}]}`,
+		},
+		{
+			desc:       "no groups",
+			url:        "/v1/groups",
+			wantStatus: http.StatusOK,
+			want:       `{"time":"2013-01-01T00:00:00Z","data":[]}`,
+		},
+		{
+			desc:       "invalid pattern",
+			url:        "/v1/users?name=%5B",
+			wantStatus: http.StatusBadRequest,
+			want:       "invalid name pattern '['",
+		},
+		{
+			desc:       "unknown endpoint",
+			url:        "/v1/qdiscs",
+			wantStatus: http.StatusNotFound,
+			want:       "404 page not found",
+		},
+		{
+			desc:       "not a GET",
+			method:     http.MethodPost,
+			url:        "/v1/classes",
+			wantStatus: http.StatusMethodNotAllowed,
+			want:       "method not allowed",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			recorder := httptest.NewRecorder()
+			h.handler().ServeHTTP(recorder, httptest.NewRequest(method, tc.url, nil))
+			if recorder.Code != tc.wantStatus {
+				t.Errorf("%s %s => got status: %d, want: %d", method, tc.url, recorder.Code, tc.wantStatus)
+			}
+			if got := strings.TrimSpace(recorder.Body.String()); got != tc.want {
+				t.Errorf("%s %s => got:\n%s\nwant:\n%s", method, tc.url, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHttpServerNoData(t *testing.T) {
+	h := &httpServer{options: &HttpOptions{}, logger: &fakeSyslog{}}
+	recorder := httptest.NewRecorder()
+	h.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/classes", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /v1/classes => got status: %d, want: %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestCycleRates(t *testing.T) {
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	previous := &httpCycle{start, []metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1000)},
+		{"efficiency", tcNameLeaf, "eth0:2:3", 50},
+		{"sentBytes", tcNameLeaf, "eth0:2:4", int64(5000)},
+		{"userDownBytes", tcUserNameLeaf, "john", int64(500)},
+	}}
+	current := &httpCycle{start.Add(10 * time.Second), []metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(3000)},
+		{"efficiency", tcNameLeaf, "eth0:2:3", 60},
+		{"sentBytes", tcNameLeaf, "eth0:2:4", int64(10)},
+		{"sentBytes", tcNameLeaf, "eth1:1:0", int64(10)},
+		{"userDownBytes", tcUserNameLeaf, "john", int64(2000)},
+	}}
+
+	want := &httpRates{
+		Time:     start.Add(10 * time.Second),
+		Interval: 10,
+		Data: []map[string]interface{}{
+			{"name": "eth0:2:3", "kind": "class", "iface": "eth0", "class": "2:3", "sentBytes": float64(200)},
+			{"name": "john", "kind": "user", "userDownBytes": float64(150)},
+		},
+	}
+	if got := cycleRates(previous, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("cycleRates => got: %v, want: %v", got, want)
+	}
+	if got := cycleRates(current, current); got != nil {
+		t.Errorf("cycleRates => got: %v for no elapsed time, want: nil", got)
+	}
+}
+
+func TestHttpServerStream(t *testing.T) {
+	h := &httpServer{options: &HttpOptions{}, logger: &fakeSyslog{}}
+	server := httptest.NewServer(h.handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/v1/stream?kind=user&fields=name,userDownBytes")
+	if err != nil {
+		t.Fatalf("GET /v1/stream => unexpected error: %s", err)
+	}
+	defer response.Body.Close()
+	if got := response.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("GET /v1/stream => got Content-Type: %s, want: text/event-stream", got)
+	}
+
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	h.flush([]metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1000)},
+		{"userDownBytes", tcUserNameLeaf, "john", int64(500)},
+	}, start)
+	h.flush([]metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(3000)},
+		{"userDownBytes", tcUserNameLeaf, "john", int64(2000)},
+	}, start.Add(10*time.Second))
+
+	reader := bufio.NewReader(response.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("GET /v1/stream => unexpected error: %s", err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	want := []string{
+		"event: rates",
+		`data: {"time":"2013-01-01T00:00:10Z","interval":10,"data":[{"name":"john","userDownBytes":150}]}`,
+		"",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("GET /v1/stream => got:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestHttpServerStreamInvalid(t *testing.T) {
+	h := &httpServer{options: &HttpOptions{}, logger: &fakeSyslog{}}
+	testData := []struct {
+		method     string
+		url        string
+		wantStatus int
+		want       string
+	}{
+		{http.MethodGet, "/v1/stream?kind=qdisc", http.StatusBadRequest, "unknown kind 'qdisc'"},
+		{http.MethodGet, "/v1/stream?name=%5B", http.StatusBadRequest, "invalid name pattern '['"},
+		{http.MethodHead, "/v1/stream", http.StatusMethodNotAllowed, "method not allowed"},
+	}
+
+	for _, tc := range testData {
+		recorder := httptest.NewRecorder()
+		h.handler().ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.url, nil))
+		if recorder.Code != tc.wantStatus {
+			t.Errorf("%s %s => got status: %d, want: %d", tc.method, tc.url, recorder.Code, tc.wantStatus)
+		}
+		if got := strings.TrimSpace(recorder.Body.String()); tc.method != http.MethodHead && got != tc.want {
+			t.Errorf("%s %s => got: %s, want: %s", tc.method, tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestHttpServerHistory(t *testing.T) {
+	h := &httpServer{options: &HttpOptions{}, logger: &fakeSyslog{}}
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= httpHistorySize+1; i++ {
+		h.flush([]metricValue{
+			{"sentBytes", tcNameLeaf, "eth0:2:3", int64(i * 1000)},
+			{"userDownBytes", tcUserNameLeaf, "john", int64(i * 100)},
+		}, start.Add(time.Duration(i)*10*time.Second))
+	}
+	if len(h.history) != httpHistorySize {
+		t.Errorf("flush => got %d events in the history, want: %d", len(h.history), httpHistorySize)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/history?kind=user&fields=userDownBytes", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /v1/history => got status: %d, want: %d", recorder.Code, http.StatusOK)
+	}
+	lines := strings.Split(recorder.Body.String(), `},{"time"`)
+	if len(lines) != httpHistorySize {
+		t.Errorf("GET /v1/history => got %d events, want: %d", len(lines), httpHistorySize)
+	}
+	wantFirst := `{"data":[{"time":"2013-01-01T00:00:20Z","interval":10,"data":[{"userDownBytes":10}]`
+	if !strings.HasPrefix(recorder.Body.String(), wantFirst) {
+		t.Errorf("GET /v1/history => got:\n%s\nwant prefix:\n%s", recorder.Body.String(), wantFirst)
+	}
+
+	recorder = httptest.NewRecorder()
+	h.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/history?kind=qdisc", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("GET /v1/history?kind=qdisc => got status: %d, want: %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHttpServerDashboard(t *testing.T) {
+	h := &httpServer{options: &HttpOptions{}, logger: &fakeSyslog{}}
+	recorder := httptest.NewRecorder()
+	h.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("GET / => got status: %d, want: %d", recorder.Code, http.StatusOK)
+	}
+	if got := recorder.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("GET / => got Content-Type: %s, want: text/html; charset=utf-8", got)
+	}
+	if !strings.Contains(recorder.Body.String(), "<title>tc_reader</title>") {
+		t.Errorf("GET / => got:\n%s\nwant the dashboard", recorder.Body.String())
+	}
+}
+
+func TestNewHttpServer(t *testing.T) {
+	h, err := newHttpServer(&HttpOptions{Listen: "127.0.0.1:0"}, &fakeSyslog{})
+	if err != nil {
+		t.Fatalf("newHttpServer => unexpected error: %s", err)
+	}
+	defer h.server.Close()
+
+	if _, err := newHttpServer(&HttpOptions{Listen: "256.0.0.1:80"}, &fakeSyslog{}); err == nil {
+		t.Errorf("newHttpServer => got no error for an invalid address")
+	}
+}
+
+func TestHttpServerClose(t *testing.T) {
+	h, err := newHttpServer(&HttpOptions{Listen: "127.0.0.1:0"}, &fakeSyslog{})
+	if err != nil {
+		t.Fatalf("newHttpServer => unexpected error: %s", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen => unexpected error: %s", err)
+	}
+	go h.server.Serve(listener)
+
+	response, err := http.Get("http://" + listener.Addr().String() + "/v1/stream")
+	if err != nil {
+		t.Fatalf("GET /v1/stream => unexpected error: %s", err)
+	}
+	defer response.Body.Close()
+
+	// The open stream doesn't hold up the shutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.close(ctx); err != nil {
+		t.Fatalf("close => unexpected error: %s", err)
+	}
+	if _, err := io.ReadAll(response.Body); err != nil {
+		t.Errorf("GET /v1/stream after close => unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+iface.go contains structs and methods used to get information about the network interfaces from the kernel.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// linkReceiveTimeout is how long the linkWatcher waits for the notifications, bounds the time it takes to stop it.
+const linkReceiveTimeout = time.Second
+
+// sysClassNet is the directory where the kernel exports information about network interfaces.
+var sysClassNet = "/sys/class/net"
+
+// ifaceReader is an interface that reads information about the network interfaces.
+type ifaceReader interface {
+	// ifIndex resolves the interface name to the kernel ifIndex.
+	ifIndex(iface string) (int, error)
+
+	// list returns names of all network interfaces present in the system.
+	list() ([]string, error)
+
+	// present determines whether the interface is currently present in the system.
+	present(iface string) bool
+}
+
+// sysfsIfaceReader implements ifaceReader by reading /sys/class/net.
+type sysfsIfaceReader struct {
+}
+
+// ifIndex returns the kernel ifIndex of the interface, this is the same index that is used in the IF-MIB ifTable.
+func (si *sysfsIfaceReader) ifIndex(iface string) (int, error) {
+	content, err := ioutil.ReadFile(filepath.Join(sysClassNet, iface, "ifindex"))
+	if err != nil {
+		return 0, err
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse ifindex of interface %s, err: %s", iface, err)
+	}
+	return index, nil
+}
+
+// list returns names of all network interfaces present in the system sorted by name.
+func (si *sysfsIfaceReader) list() ([]string, error) {
+	entries, err := ioutil.ReadDir(sysClassNet)
+	if err != nil {
+		return nil, err
+	}
+	var ifaces []string
+	for _, entry := range entries {
+		ifaces = append(ifaces, entry.Name())
+	}
+	return ifaces, nil
+}
+
+// present determines whether the interface is currently present in the system.
+func (si *sysfsIfaceReader) present(iface string) bool {
+	_, err := os.Stat(filepath.Join(sysClassNet, iface))
+	return err == nil
+}
+
+// linkEvent is a notification about an interface that appeared or disappeared.
+type linkEvent struct {
+	// name is the name of the interface.
+	name string
+
+	// removed is true if the interface was removed, false if it was added or changed.
+	removed bool
+}
+
+// linkWatcher is an interface that receives notifications about interfaces being added or removed.
+type linkWatcher interface {
+	// subscribe starts receiving the notifications. Must be called before receive.
+	subscribe() error
+
+	// receive blocks until the next notifications arrive and returns them. Returns no notifications if none arrived
+	// within linkReceiveTimeout.
+	receive() ([]linkEvent, error)
+
+	// close stops receiving the notifications.
+	close() error
+}
+
+// ifaceNameEncoder escapes the characters in interface names that NMS tools confuse with the OID structure, e.g. "eth0.100".
+var ifaceNameEncoder = strings.NewReplacer("%", "%25", ".", "%2E")
+
+// ifaceNameDecoder reverses ifaceNameEncoder.
+var ifaceNameDecoder = strings.NewReplacer("%25", "%", "%2E", ".", "%2e", ".")
+
+// encodeIfaceName escapes the interface name, e.g. "eth0.100" becomes "eth0%2E100".
+func encodeIfaceName(iface string) string {
+	return ifaceNameEncoder.Replace(iface)
+}
+
+// decodeIfaceName reverses encodeIfaceName, names that aren't encoded are returned unchanged.
+func decodeIfaceName(iface string) string {
+	return ifaceNameDecoder.Replace(iface)
+}
+
+// isIfacePattern determines whether the configured interface name is a shell pattern, e.g. "ppp*".
+func isIfacePattern(iface string) bool {
+	return strings.ContainsAny(iface, "*?[")
+}
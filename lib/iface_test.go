@@ -0,0 +1,144 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSysfsIfaceReaderIfIndex(t *testing.T) {
+	testData := []struct {
+		desc    string
+		iface   string
+		want    int
+		wantErr bool
+	}{
+		{
+			desc:  "ifindex is read and parsed",
+			iface: "eth0",
+			want:  2,
+		},
+		{
+			desc:    "ifindex file contains garbage",
+			iface:   "eth1",
+			wantErr: true,
+		},
+		{
+			desc:    "interface does not exist",
+			iface:   "eth2",
+			wantErr: true,
+		},
+	}
+
+	origSysClassNet := sysClassNet
+	defer func() { sysClassNet = origSysClassNet }()
+	sysClassNet = "testdata/sys_class_net"
+
+	si := &sysfsIfaceReader{}
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := si.ifIndex(tc.iface)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ifIndex(%s) => unexpected err: %v, wantErr: %v", tc.iface, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("ifIndex(%s) => got: %d, want: %d", tc.iface, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSysfsIfaceReaderList(t *testing.T) {
+	testData := []struct {
+		desc        string
+		sysClassNet string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			desc:        "interfaces are listed",
+			sysClassNet: "testdata/sys_class_net",
+			want:        []string{"eth0", "eth1"},
+		},
+		{
+			desc:        "sysfs directory does not exist",
+			sysClassNet: "testdata/not_existing",
+			wantErr:     true,
+		},
+	}
+
+	origSysClassNet := sysClassNet
+	defer func() { sysClassNet = origSysClassNet }()
+
+	si := &sysfsIfaceReader{}
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			sysClassNet = tc.sysClassNet
+			got, err := si.list()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("list => unexpected err: %v, wantErr: %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("list => got: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSysfsIfaceReaderPresent(t *testing.T) {
+	testData := []struct {
+		iface string
+		want  bool
+	}{
+		{"eth0", true},
+		{"ppp0", false},
+	}
+
+	origSysClassNet := sysClassNet
+	defer func() { sysClassNet = origSysClassNet }()
+	sysClassNet = "testdata/sys_class_net"
+
+	si := &sysfsIfaceReader{}
+	for _, tc := range testData {
+		if got := si.present(tc.iface); got != tc.want {
+			t.Errorf("present(%s) => got: %v, want: %v", tc.iface, got, tc.want)
+		}
+	}
+}
+
+func TestEncodeIfaceName(t *testing.T) {
+	testData := []struct {
+		iface string
+		want  string
+	}{
+		{"eth0", "eth0"},
+		{"eth0.100", "eth0%2E100"},
+		{"eth0.100.200", "eth0%2E100%2E200"},
+		{"weird%2E", "weird%252E"},
+	}
+
+	for _, tc := range testData {
+		got := encodeIfaceName(tc.iface)
+		if got != tc.want {
+			t.Errorf("encodeIfaceName(%q) => got: %q, want: %q", tc.iface, got, tc.want)
+		}
+		if decoded := decodeIfaceName(got); decoded != tc.iface {
+			t.Errorf("decodeIfaceName(%q) => got: %q, want: %q", got, decoded, tc.iface)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+init.go generates a starter config file for a new deployment. It probes the system for the TC command and for the
+interfaces that have a Qdisc installed, and writes them with comments describing the options to edit next.
+*/
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// tcSearchPath are the paths where the TC command is looked for before the directories in $PATH.
+var tcSearchPath = []string{"/sbin/tc", "/usr/sbin/tc", "/bin/tc", "/usr/bin/tc"}
+
+// initConfigTemplate is the starter config file. It is formatted with the path of the TC command, the monitored
+// interfaces and an example class on the first of them.
+const initConfigTemplate = `# Configuration for the tc_reader, generated by tc_reader -init.
+# See tc_reader.conf in the sources for all the options.
+
+# tcCmdPath is the path to the TC command, found on this system.
+tcCmdPath = "%s"
+
+# ParseInterval is the interval in which tc_reader executes the TC command and
+# gets the Qdisc and Class statistics, in seconds or as a quoted duration like
+# "500ms" or "2m".
+parseInterval = 5
+
+# Ifaces are the interfaces whose Qdiscs and Classes are exported, separated by
+# spaces. These had a Qdisc installed when the file was generated. Patterns like
+# "ppp*" match the interfaces that come and go, "auto" monitors all the
+# interfaces that have a Qdisc installed.
+ifaces = "%s"
+
+# Users name the traffic of Classes in the upload and download direction, e.g.:
+# user = "user1" "%s" "%s"
+
+# logTarget is where the log messages are written: syslog, stderr, journald or
+# file:<path>.
+# logTarget = "syslog"
+
+# controlSocket is the Unix socket of the tc_reader ctl commands.
+# controlSocket = "/run/tc_reader.sock"
+`
+
+// InitConfig returns a starter config file for this system. Returns an error if the TC command can't be found.
+func InitConfig(logger Logger) (string, error) {
+	tc, err := findTcCmd()
+	if err != nil {
+		return "", err
+	}
+	p := newTcParser(&TcParserOptions{TcCmdPath: tc}, logger)
+	ifaces, err := p.discoverIfaces()
+	if err != nil {
+		return "", fmt.Errorf("unable to discover the interfaces with a Qdisc, error: %s", err)
+	}
+	return initConfig(tc, ifaces), nil
+}
+
+// initConfig formats the starter config file with the TC command and the interfaces that have a Qdisc installed. All
+// the interfaces are discovered automatically if none has a Qdisc yet.
+func initConfig(tc string, ifaces []string) string {
+	monitored := strings.Join(ifaces, " ")
+	upload, download := "eth0:2:3", "eth1:2:3"
+	if len(ifaces) == 0 {
+		monitored = autoIfaces
+	} else {
+		upload = fmt.Sprintf("%s:1:10", ifaces[0])
+		download = fmt.Sprintf("%s:1:11", ifaces[len(ifaces)-1])
+	}
+	return fmt.Sprintf(initConfigTemplate, tc, monitored, upload, download)
+}
+
+// findTcCmd returns the path of the TC command.
+func findTcCmd() (string, error) {
+	for _, path := range tcSearchPath {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	path, err := exec.LookPath("tc")
+	if err != nil {
+		return "", errors.New("the TC command wasn't found, is iproute2 installed?")
+	}
+	return path, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInitConfig(t *testing.T) {
+	testData := []struct {
+		desc        string
+		ifaces      []string
+		wantIfaces  []string
+		wantExample string
+	}{
+		{
+			desc:        "interfaces with a Qdisc",
+			ifaces:      []string{"eth0", "ifb0"},
+			wantIfaces:  []string{"eth0", "ifb0"},
+			wantExample: "# user = \"user1\" \"eth0:1:10\" \"ifb0:1:11\"",
+		},
+		{
+			desc:        "no interface has a Qdisc",
+			wantIfaces:  []string{"auto"},
+			wantExample: "# user = \"user1\" \"eth0:2:3\" \"eth1:2:3\"",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			content := initConfig("/usr/sbin/tc", tc.ifaces)
+			c := newConfig("test")
+			if err := c.parseConfig(content); err != nil {
+				t.Fatalf("parseConfig => unexpected error: %s", err)
+			}
+			if len(c.Warnings()) != 0 {
+				t.Errorf("parseConfig => unexpected warnings: %q", c.Warnings())
+			}
+			if c.TcCmdPath != "/usr/sbin/tc" {
+				t.Errorf("initConfig => got tcCmdPath: %s, want: /usr/sbin/tc", c.TcCmdPath)
+			}
+			if !reflect.DeepEqual(c.Ifaces, tc.wantIfaces) {
+				t.Errorf("initConfig => got ifaces: %q, want: %q", c.Ifaces, tc.wantIfaces)
+			}
+			if !strings.Contains(content, tc.wantExample) {
+				t.Errorf("initConfig => got:\n%s\nwant the example: %s", content, tc.wantExample)
+			}
+		})
+	}
+}
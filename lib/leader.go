@@ -0,0 +1,352 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+leader.go runs several tc_reader instances on one host, e.g. one per network namespace or per tenant, behind a single
+pass_persist script. Each instance runs as an exporter with its own instance name, base OID and cacheFile, and
+registers itself by writing a file named after the instance to the leaderDir, e.g.
+/run/tc_reader/instances/tenant1.json:
+{"instance": "tenant1", "baseOID": ".1.3.6.1.4.1.2021.255.1", "cacheFile": "/var/lib/tc_reader/tenant1.cache", "pid": 42}
+
+The leader is registered in snmpd.conf under an OID that contains the base OIDs of the instances:
+pass_persist .1.3.6.1.4.1.2021.255 /path/to/tc_reader -leader
+
+It answers the requests under the base OID of each instance from its cacheFile, and a GET-NEXT past the last OID of an
+instance continues with the first OID of the next one, so that a walk covers all the instances. The leader doesn't
+parse TC, it only picks up the registrations and the cacheFiles when they change.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// leaderExtension is the extension of the registration files in the leaderDir.
+	leaderExtension = ".json"
+
+	// leaderRefreshInterval is how often the leader checks the registrations and the cacheFiles for changes.
+	leaderRefreshInterval = time.Second
+
+	// leaderFileMode are the permissions of the registration files.
+	leaderFileMode = 0644
+)
+
+// reInstanceName matches the valid names of the instances.
+var reInstanceName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// InstanceRegistration registers a tc_reader instance with the leader.
+type InstanceRegistration struct {
+	// Instance is the name of the instance, of letters, digits, '.', '_' and '-'.
+	Instance string `json:"instance"`
+
+	// BaseOID is the OID the instance exports its data under, distinct from the base OIDs of the other instances.
+	BaseOID string `json:"baseOID"`
+
+	// CacheFile is the file the instance persists its exported data to, the leader answers from it.
+	CacheFile string `json:"cacheFile"`
+
+	// Pid is the process ID of the instance.
+	Pid int `json:"pid"`
+}
+
+// validate returns an error if the leader can't answer for the instance.
+func (r *InstanceRegistration) validate() error {
+	if !reInstanceName.MatchString(r.Instance) {
+		return fmt.Errorf("the instance '%s' must be a non-empty name of letters, digits, '.', '_' and '-'", r.Instance)
+	}
+	if !validOID(r.BaseOID) {
+		return fmt.Errorf("the base OID must be a numeric OID with a leading dot, got '%s'", r.BaseOID)
+	}
+	if r.CacheFile == emptyString {
+		return errors.New("the leader answers from the cacheFile, which isn't set")
+	}
+	return nil
+}
+
+// registrationPath returns the path of the registration file of the instance in the directory.
+func registrationPath(dir, instance string) string {
+	return filepath.Join(dir, instance+leaderExtension)
+}
+
+// RegisterInstance writes the registration file of the instance to the directory, replacing the one of a previous
+// run. Returns an error if the registration is invalid or the file can't be written.
+func RegisterInstance(dir string, registration *InstanceRegistration) error {
+	if err := registration.validate(); err != nil {
+		return err
+	}
+	content, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+	path := registrationPath(dir, registration.Instance)
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, content, leaderFileMode); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// UnregisterInstance removes the registration file of the instance from the directory. A missing file isn't an error.
+func UnregisterInstance(dir, instance string) error {
+	if err := os.Remove(registrationPath(dir, instance)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// leaderInstance is a registered instance with its persisted data.
+type leaderInstance struct {
+	// registration is the registration of the instance.
+	registration InstanceRegistration
+
+	// modTime is the modification time of the cacheFile when it was loaded.
+	modTime time.Time
+
+	// data is the data loaded from the cacheFile, empty if it isn't persisted yet.
+	data *snapshot
+}
+
+// Leader answers the SNMP daemon for the instances registered in a directory.
+type Leader struct {
+	// dir is the directory of the registration files.
+	dir string
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// snmpTalker talks to the SNMP daemon.
+	snmpTalker snmpTalker
+
+	// instances are the registered instances ordered by their base OIDs.
+	instances []*leaderInstance
+
+	// refreshed is the time the registrations were last checked.
+	refreshed time.Time
+
+	// now returns the current time, it is replaced in tests.
+	now func() time.Time
+}
+
+// NewLeader creates a Leader answering the SNMP daemon over the reader and the writer, e.g. the standard input and
+// output, for the instances registered in the directory. Returns an error if the directory can't be read.
+func NewLeader(dir string, logger Logger, r io.Reader, w io.Writer) (*Leader, error) {
+	if logger == nil {
+		return nil, errors.New("a Logger is required")
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		return nil, err
+	}
+	return &Leader{
+		dir:        dir,
+		logger:     logger,
+		snmpTalker: newStreamTalker(r, w),
+		now:        time.Now,
+	}, nil
+}
+
+// Listen answers the commands of the SNMP daemon until it sends an empty line.
+func (l *Leader) Listen() {
+	for {
+		switch command := l.snmpTalker.getLine(); command {
+		case emptyLine:
+			return
+
+		case pingRequst:
+			l.snmpTalker.putLine(pingResponse)
+
+		case getCommand:
+			oid := l.snmpTalker.getLine()
+			l.refresh()
+			l.answer(l.get(oid))
+
+		case getNextCommand:
+			oid := l.snmpTalker.getLine()
+			l.refresh()
+			l.answer(l.next(oid))
+
+		default:
+			logWarning(l.logger, fmt.Sprintf("Listen(): got an unexpected command %s", command))
+			l.snmpTalker.putLine(emptyLine)
+		}
+	}
+}
+
+// answer prints the OID, the object type and the value, or an empty line if there is no such OID.
+func (l *Leader) answer(oid, objectType, value string, ok bool) {
+	if !ok {
+		l.snmpTalker.putLine(emptyLine)
+		return
+	}
+	l.snmpTalker.putLine(oid)
+	l.snmpTalker.putLine(objectType)
+	l.snmpTalker.putLine(value)
+}
+
+// get returns the formatted data of the OID, false if no instance stores it.
+func (l *Leader) get(oid string) (string, string, string, bool) {
+	for _, instance := range l.instances {
+		base := instance.registration.BaseOID
+		if oid != base && !strings.HasPrefix(oid, base+".") {
+			continue
+		}
+		if position, ok := instance.data.find(rebaseOID(oid, base, myOID)); ok {
+			o, t, v := formatSnmpData(&instance.data.data[position], base)
+			return o, t, v, true
+		}
+		break
+	}
+	return emptyString, emptyString, emptyString, false
+}
+
+// next returns the formatted data following the OID in the instances, false if the OID is past the last one.
+func (l *Leader) next(oid string) (string, string, string, bool) {
+	for _, instance := range l.instances {
+		base := instance.registration.BaseOID
+		position := 0
+		switch {
+		case oid == base || strings.HasPrefix(oid, base+"."):
+			var found bool
+			position, found = instance.data.find(rebaseOID(oid, base, myOID))
+			if found {
+				position++
+			}
+		case oidLess(base, oid):
+			// The instance precedes the OID.
+			continue
+		}
+		if position < len(instance.data.data) {
+			o, t, v := formatSnmpData(&instance.data.data[position], base)
+			return o, t, v, true
+		}
+	}
+	return emptyString, emptyString, emptyString, false
+}
+
+// refresh reads the registrations and the changed cacheFiles again, at most once per leaderRefreshInterval.
+func (l *Leader) refresh() {
+	now := l.now()
+	if now.Sub(l.refreshed) < leaderRefreshInterval {
+		return
+	}
+	l.refreshed = now
+
+	registrations, err := l.registrations()
+	if err != nil {
+		l.logger.Err(fmt.Sprintf("refresh(): Unable to read the registrations in %s, keeping the previous ones, error: %s", l.dir, err))
+		return
+	}
+	previous := make(map[string]*leaderInstance, len(l.instances))
+	for _, instance := range l.instances {
+		previous[instance.registration.Instance] = instance
+	}
+	var instances []*leaderInstance
+	for _, registration := range registrations {
+		instance, ok := previous[registration.Instance]
+		if !ok || instance.registration != registration {
+			instance = &leaderInstance{registration: registration, data: &snapshot{}}
+			l.logger.Info(fmt.Sprintf("refresh(): Answering for the instance %s under %s.", registration.Instance, registration.BaseOID))
+		}
+		l.load(instance)
+		instances = append(instances, instance)
+	}
+	l.instances = instances
+}
+
+// registrations returns the valid registrations in the directory ordered by their base OIDs. The registrations whose
+// base OIDs overlap with the one of another instance are skipped.
+func (l *Leader) registrations() ([]InstanceRegistration, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var registrations []InstanceRegistration
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != leaderExtension {
+			continue
+		}
+		path := filepath.Join(l.dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			l.logger.Err(fmt.Sprintf("registrations(): Unable to read the registration %s, error: %s", path, err))
+			continue
+		}
+		var registration InstanceRegistration
+		if err := json.Unmarshal(content, &registration); err != nil {
+			l.logger.Err(fmt.Sprintf("registrations(): Unable to decode the registration %s, error: %s", path, err))
+			continue
+		}
+		if err := registration.validate(); err != nil {
+			l.logger.Err(fmt.Sprintf("registrations(): Invalid registration %s, error: %s", path, err))
+			continue
+		}
+		registrations = append(registrations, registration)
+	}
+	sort.SliceStable(registrations, func(i, j int) bool {
+		return oidLess(registrations[i].BaseOID, registrations[j].BaseOID)
+	})
+
+	var valid []InstanceRegistration
+	for _, registration := range registrations {
+		if n := len(valid); n > 0 && overlappingOIDs(valid[n-1].BaseOID, registration.BaseOID) {
+			l.logger.Err(fmt.Sprintf("registrations(): The base OID %s of the instance %s overlaps with the instance %s, skipping it.", registration.BaseOID, registration.Instance, valid[n-1].Instance))
+			continue
+		}
+		valid = append(valid, registration)
+	}
+	return valid, nil
+}
+
+// load loads the cacheFile of the instance if it changed since it was last loaded. The previous data is kept if it
+// can't be loaded.
+func (l *Leader) load(instance *leaderInstance) {
+	path := instance.registration.CacheFile
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		// The instance didn't complete a parse cycle yet.
+		return
+	}
+	if err != nil {
+		l.logger.Err(fmt.Sprintf("load(): Unable to check the cacheFile %s of the instance %s, error: %s", path, instance.registration.Instance, err))
+		return
+	}
+	if info.ModTime().Equal(instance.modTime) {
+		return
+	}
+	data, err := loadCache(path)
+	if err != nil {
+		l.logger.Err(fmt.Sprintf("load(): Unable to load the cacheFile %s of the instance %s, error: %s", path, instance.registration.Instance, err))
+		return
+	}
+	if data != nil {
+		instance.data = data
+	}
+	instance.modTime = info.ModTime()
+}
+
+// overlappingOIDs returns true if one of the OIDs is equal to or under the other.
+func overlappingOIDs(first, second string) bool {
+	return first == second || strings.HasPrefix(first, second+".") || strings.HasPrefix(second, first+".")
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// registerTestInstance registers an instance in the directory with a cacheFile holding the data.
+func registerTestInstance(t *testing.T, dir, instance, baseOID string, data ...snmpData) {
+	t.Helper()
+	cacheFile := filepath.Join(t.TempDir(), instance+".cache")
+	if err := saveCache(cacheFile, &snapshot{data: data}); err != nil {
+		t.Fatalf("saveCache => unexpected error: %s", err)
+	}
+	if err := RegisterInstance(dir, &InstanceRegistration{Instance: instance, BaseOID: baseOID, CacheFile: cacheFile}); err != nil {
+		t.Fatalf("RegisterInstance => unexpected error: %s", err)
+	}
+}
+
+func TestLeaderListen(t *testing.T) {
+	dir := t.TempDir()
+	registerTestInstance(t, dir, "tenant2", ".1.3.6.1.4.1.2021.255.2",
+		snmpData{myOID, "string", "tenant2"},
+		snmpData{myOID + ".3.1", "string", "eth1:1:"},
+	)
+	registerTestInstance(t, dir, "tenant1", ".1.3.6.1.4.1.2021.255.1",
+		snmpData{myOID, "string", "tenant1"},
+		snmpData{myOID + ".3.1", "string", "eth0:1:"},
+	)
+	// The base OID of the instance overlaps with tenant1.
+	registerTestInstance(t, dir, "overlap", ".1.3.6.1.4.1.2021.255.1.3",
+		snmpData{myOID, "string", "overlap"},
+	)
+
+	requests := []string{
+		"PING",
+		"get", ".1.3.6.1.4.1.2021.255.1.3.1",
+		"get", ".1.3.6.1.4.1.2021.255.1.3.2",
+		"getnext", ".1.3.6.1.4.1.2021.255",
+		"getnext", ".1.3.6.1.4.1.2021.255.1",
+		"getnext", ".1.3.6.1.4.1.2021.255.1.3.1",
+		"getnext", ".1.3.6.1.4.1.2021.255.2.3.1",
+		"",
+	}
+	logger := &fakeSyslog{}
+	var out bytes.Buffer
+	l, err := NewLeader(dir, logger, strings.NewReader(strings.Join(requests, "\n")+"\n"), &out)
+	if err != nil {
+		t.Fatalf("NewLeader => unexpected error: %s", err)
+	}
+	l.Listen()
+
+	want := []string{
+		"PONG",
+		".1.3.6.1.4.1.2021.255.1.3.1", "string", "eth0:1:",
+		"",
+		".1.3.6.1.4.1.2021.255.1", "string", "tenant1",
+		".1.3.6.1.4.1.2021.255.1.3.1", "string", "eth0:1:",
+		".1.3.6.1.4.1.2021.255.2", "string", "tenant2",
+		"",
+		"",
+	}
+	if got := out.String(); got != strings.Join(want, "\n") {
+		t.Errorf("Listen => got:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+	if len(logger.err) != 1 || !strings.Contains(logger.err[0], "overlaps with the instance tenant1") {
+		t.Errorf("Listen => got errors: %v, want the overlapping instance", logger.err)
+	}
+}
+
+func TestRegisterInstance(t *testing.T) {
+	dir := t.TempDir()
+	registration := &InstanceRegistration{Instance: "tenant1", BaseOID: ".1.3.6.1.4.1.2021.255.1", CacheFile: "/tmp/tenant1.cache", Pid: 42}
+	if err := RegisterInstance(dir, registration); err != nil {
+		t.Fatalf("RegisterInstance => unexpected error: %s", err)
+	}
+	want := `{"instance":"tenant1","baseOID":".1.3.6.1.4.1.2021.255.1","cacheFile":"/tmp/tenant1.cache","pid":42}`
+	if got := readFile(t, filepath.Join(dir, "tenant1.json")); got != want {
+		t.Errorf("RegisterInstance => got: %s, want: %s", got, want)
+	}
+
+	if err := UnregisterInstance(dir, "tenant1"); err != nil {
+		t.Fatalf("UnregisterInstance => unexpected error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tenant1.json")); !os.IsNotExist(err) {
+		t.Errorf("UnregisterInstance => the registration wasn't removed, stat error: %v", err)
+	}
+	if err := UnregisterInstance(dir, "tenant1"); err != nil {
+		t.Errorf("UnregisterInstance of a missing registration => unexpected error: %s", err)
+	}
+
+	invalid := []*InstanceRegistration{
+		{Instance: "tenant 1", BaseOID: ".1.3.6", CacheFile: "/tmp/cache"},
+		{Instance: "tenant1", BaseOID: "1.3.6", CacheFile: "/tmp/cache"},
+		{Instance: "tenant1", BaseOID: ".1.3.6"},
+	}
+	for _, registration := range invalid {
+		if err := RegisterInstance(dir, registration); err == nil {
+			t.Errorf("RegisterInstance(%+v) => got no error, want an invalid registration", registration)
+		}
+	}
+}
+
+func TestOverlappingOIDs(t *testing.T) {
+	tests := []struct {
+		first, second string
+		want          bool
+	}{
+		{".1.3.6.1", ".1.3.6.1", true},
+		{".1.3.6.1", ".1.3.6.1.2", true},
+		{".1.3.6.1.2", ".1.3.6.1", true},
+		{".1.3.6.1", ".1.3.6.10", false},
+		{".1.3.6.1", ".1.3.6.2", false},
+	}
+	for _, tc := range tests {
+		if got := overlappingOIDs(tc.first, tc.second); got != tc.want {
+			t.Errorf("overlappingOIDs(%s, %s) => got: %v, want: %v", tc.first, tc.second, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+log.go writes the log messages to one of the targets:
+syslog      - The local Syslog daemon, with the configured facility and tag.
+stderr      - The standard error, a line with the time, the level and the message for each message.
+journald    - The systemd journal, the level is recorded as the PRIORITY of the entries.
+file:<path> - A file, e.g. "file:/var/log/tc_reader.log", in the format of stderr. The file is rotated to the same path
+              with a ".1" suffix when it would grow over the configured size, the previous rotated file is overwritten.
+
+The messages less severe than the configured level (error, warning, info or debug) are dropped. The debug messages are
+written whenever the debug logging is on, i.e. with the debug option, after SIGUSR2 or with the "debug" level.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The log targets.
+const (
+	// logTargetSyslog writes the messages to Syslog.
+	logTargetSyslog = "syslog"
+
+	// logTargetStderr writes the messages to the standard error.
+	logTargetStderr = "stderr"
+
+	// logTargetJournald writes the messages to the systemd journal.
+	logTargetJournald = "journald"
+
+	// logTargetFile is followed by the path of the file the messages are appended to.
+	logTargetFile = "file:"
+)
+
+// The log levels from the most severe one.
+const (
+	// logLevelError logs the errors.
+	logLevelError = "error"
+
+	// logLevelWarning logs the problems that don't prevent the collection, e.g. a missing interface.
+	logLevelWarning = "warning"
+
+	// logLevelInfo logs the changes of the state, e.g. the start of a blackout window.
+	logLevelInfo = "info"
+
+	// logLevelDebug logs the details of every parse cycle and SNMP command.
+	logLevelDebug = "debug"
+)
+
+const (
+	// defaultLogTag identifies the messages of the tc_reader in Syslog and in the journal.
+	defaultLogTag = "tc_reader"
+
+	// defaultSyslogFacility is the facility of the messages sent to Syslog.
+	defaultSyslogFacility = "kern"
+
+	// defaultLogMaxSize is the size in bytes over which the log file is rotated.
+	defaultLogMaxSize = 10 << 20
+
+	// logRotatedSuffix is appended to the path of the rotated log file.
+	logRotatedSuffix = ".1"
+
+	// journaldSocket is the Unix socket of the native protocol of the systemd journal.
+	journaldSocket = "/run/systemd/journal/socket"
+)
+
+// logSeverities are the log levels ordered from the most severe one.
+var logSeverities = map[string]int{
+	logLevelError:   0,
+	logLevelWarning: 1,
+	logLevelInfo:    2,
+	logLevelDebug:   3,
+}
+
+// syslogFacilities are the Syslog facilities by their names.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// journaldPriorities are the syslog priorities of the log levels recorded in the journal.
+var journaldPriorities = map[string]int{
+	logLevelError:   3,
+	logLevelWarning: 4,
+	logLevelInfo:    6,
+	logLevelDebug:   7,
+}
+
+// LogOptions are the options of the Logger created by NewLogger.
+type LogOptions struct {
+	// Target is where the messages are written, one of "syslog", "stderr", "journald" or "file:" followed by the path
+	// of the file. Defaults to "syslog".
+	Target string
+
+	// Level is the least severe level that is logged, one of "error", "warning", "info" or "debug". Defaults to
+	// "info".
+	Level string
+
+	// Tag identifies the messages in Syslog and in the journal, e.g. to tell multiple instances apart. Defaults to
+	// defaultLogTag.
+	Tag string
+
+	// Facility is the Syslog facility of the messages, e.g. "daemon" or "local0" to "local7". Defaults to
+	// defaultSyslogFacility.
+	Facility string
+
+	// MaxSize is the size in bytes over which the log file is rotated. Defaults to defaultLogMaxSize.
+	MaxSize int64
+}
+
+// target returns the configured target, or the default one if it wasn't set.
+func (o *LogOptions) target() string {
+	if o.Target != "" {
+		return o.Target
+	}
+	return logTargetSyslog
+}
+
+// level returns the configured level, or the default one if it wasn't set.
+func (o *LogOptions) level() string {
+	if o.Level != "" {
+		return o.Level
+	}
+	return logLevelInfo
+}
+
+// tag returns the configured tag, or the default one if it wasn't set.
+func (o *LogOptions) tag() string {
+	if o.Tag != "" {
+		return o.Tag
+	}
+	return defaultLogTag
+}
+
+// facility returns the configured facility, or the default one if it wasn't set.
+func (o *LogOptions) facility() string {
+	if o.Facility != "" {
+		return o.Facility
+	}
+	return defaultSyslogFacility
+}
+
+// maxSize returns the configured size, or the default one if it wasn't set.
+func (o *LogOptions) maxSize() int64 {
+	if o.MaxSize > 0 {
+		return o.MaxSize
+	}
+	return defaultLogMaxSize
+}
+
+// logBackend writes the log messages to a target.
+type logBackend interface {
+	// write writes the message of the level.
+	write(level string, message string) error
+
+	// close releases the target.
+	close() error
+}
+
+// warningLogger is a Logger that has a warning level, e.g. *syslog.Writer.
+type warningLogger interface {
+	// Warning logs a warning.
+	Warning(m string) error
+}
+
+// debugLogger is a Logger that has a debug level, e.g. *syslog.Writer.
+type debugLogger interface {
+	// Debug logs a debug message.
+	Debug(m string) error
+}
+
+// logWarning logs the message as a warning, or as an informational message if the logger has no warning level.
+func logWarning(logger sysLogger, message string) {
+	if l, ok := logger.(warningLogger); ok {
+		l.Warning(message)
+		return
+	}
+	logger.Info(message)
+}
+
+// logDebug logs the message as a debug message, or as an informational message if the logger has no debug level.
+func logDebug(logger sysLogger, message string) {
+	if l, ok := logger.(debugLogger); ok {
+		l.Debug(message)
+		return
+	}
+	logger.Info(message)
+}
+
+// leveledLogger implements Logger, it drops the messages less severe than the configured level.
+type leveledLogger struct {
+	// severity is the position of the configured level in logSeverities.
+	severity int
+
+	// backend writes the messages.
+	backend logBackend
+}
+
+// NewLogger creates a Logger that writes to the configured target, returns an error if the options are invalid or if
+// the target can't be opened. Nil options use the defaults. The Logger should be closed when it is no longer used.
+func NewLogger(options *LogOptions) (*leveledLogger, error) {
+	if options == nil {
+		options = &LogOptions{}
+	}
+	severity, ok := logSeverities[options.level()]
+	if !ok {
+		return nil, fmt.Errorf("unknown log level '%s'", options.level())
+	}
+	backend, err := newLogBackend(options)
+	if err != nil {
+		return nil, err
+	}
+	return &leveledLogger{
+		severity: severity,
+		backend:  backend,
+	}, nil
+}
+
+// newLogBackend opens the configured target.
+func newLogBackend(options *LogOptions) (logBackend, error) {
+	target := options.target()
+	switch {
+	case target == logTargetSyslog:
+		facility, ok := syslogFacilities[options.facility()]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility '%s'", options.facility())
+		}
+		writer, err := syslog.New(facility|syslog.LOG_INFO, options.tag())
+		if err != nil {
+			return nil, err
+		}
+		return &syslogBackend{writer: writer}, nil
+
+	case target == logTargetStderr:
+		return &streamBackend{writer: os.Stderr}, nil
+
+	case target == logTargetJournald:
+		return newJournaldBackend(journaldSocket, options.tag())
+
+	case strings.HasPrefix(target, logTargetFile):
+		path := strings.TrimPrefix(target, logTargetFile)
+		if path == "" {
+			return nil, errors.New("the path of the log file is required")
+		}
+		return newFileBackend(path, options.maxSize())
+	}
+	return nil, fmt.Errorf("unknown log target '%s'", target)
+}
+
+// Err logs an error.
+func (l *leveledLogger) Err(m string) error {
+	return l.log(logLevelError, m)
+}
+
+// Warning logs a warning.
+func (l *leveledLogger) Warning(m string) error {
+	return l.log(logLevelWarning, m)
+}
+
+// Info logs an informational message.
+func (l *leveledLogger) Info(m string) error {
+	return l.log(logLevelInfo, m)
+}
+
+// Debug logs a debug message. The callers only log the debug messages while the debug logging is on, so they are
+// written regardless of the level.
+func (l *leveledLogger) Debug(m string) error {
+	return l.backend.write(logLevelDebug, m)
+}
+
+// Close closes the target.
+func (l *leveledLogger) Close() error {
+	return l.backend.close()
+}
+
+// log writes the message unless the level is less severe than the configured one.
+func (l *leveledLogger) log(level string, m string) error {
+	if logSeverities[level] > l.severity {
+		return nil
+	}
+	return l.backend.write(level, m)
+}
+
+// formatLogLine formats the message as a line with the time and the level.
+func formatLogLine(at time.Time, level string, message string) string {
+	return fmt.Sprintf("%s %s %s\n", at.Format(time.RFC3339), strings.ToUpper(level), message)
+}
+
+// syslogBackend implements logBackend, it writes to Syslog.
+type syslogBackend struct {
+	// writer is the connection to Syslog.
+	writer *syslog.Writer
+}
+
+// write writes the message with the priority of the level.
+func (s *syslogBackend) write(level string, message string) error {
+	switch level {
+	case logLevelError:
+		return s.writer.Err(message)
+	case logLevelWarning:
+		return s.writer.Warning(message)
+	case logLevelDebug:
+		return s.writer.Debug(message)
+	}
+	return s.writer.Info(message)
+}
+
+// close closes the connection to Syslog.
+func (s *syslogBackend) close() error {
+	return s.writer.Close()
+}
+
+// streamBackend implements logBackend, it writes the formatted lines to a stream, e.g. the standard error.
+type streamBackend struct {
+	// mu serializes the lines.
+	mu sync.Mutex
+
+	// writer receives the lines.
+	writer io.Writer
+}
+
+// write writes the line of the message.
+func (s *streamBackend) write(level string, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.writer, formatLogLine(time.Now(), level, message))
+	return err
+}
+
+// close doesn't close the stream, it is owned by the process.
+func (s *streamBackend) close() error {
+	return nil
+}
+
+// fileBackend implements logBackend, it appends the formatted lines to a file and rotates it.
+type fileBackend struct {
+	// mu protects the file.
+	mu sync.Mutex
+
+	// path is the path of the file.
+	path string
+
+	// maxSize is the size in bytes over which the file is rotated.
+	maxSize int64
+
+	// file is the open file.
+	file *os.File
+
+	// size is the current size of the file.
+	size int64
+}
+
+// newFileBackend opens the file for appending.
+func newFileBackend(path string, maxSize int64) (*fileBackend, error) {
+	f := &fileBackend{
+		path:    path,
+		maxSize: maxSize,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file for appending.
+func (f *fileBackend) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// write appends the line of the message, rotating the file first if it would grow over the maximum size.
+func (f *fileBackend) write(level string, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	line := formatLogLine(time.Now(), level, message)
+	if f.file != nil && f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		f.file.Close()
+		f.file = nil
+		if err := os.Rename(f.path, f.path+logRotatedSuffix); err != nil {
+			return err
+		}
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	n, err := io.WriteString(f.file, line)
+	f.size += int64(n)
+	return err
+}
+
+// close closes the file.
+func (f *fileBackend) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// journaldBackend implements logBackend, it sends the messages to the systemd journal.
+type journaldBackend struct {
+	// conn is the connection to the socket of the journal.
+	conn net.Conn
+
+	// tag is recorded as the SYSLOG_IDENTIFIER of the entries.
+	tag string
+}
+
+// newJournaldBackend connects to the socket of the journal.
+func newJournaldBackend(socket string, tag string) (*journaldBackend, error) {
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, err
+	}
+	return &journaldBackend{
+		conn: conn,
+		tag:  tag,
+	}, nil
+}
+
+// write sends the message as an entry of the journal.
+func (j *journaldBackend) write(level string, message string) error {
+	_, err := j.conn.Write(journaldEntry(level, j.tag, message))
+	return err
+}
+
+// close closes the connection.
+func (j *journaldBackend) close() error {
+	return j.conn.Close()
+}
+
+// journaldEntry encodes the message in the native protocol of the journal. The fields are "NAME=value" lines, the
+// values that contain a newline are written as the name, a newline, the length as a little endian uint64 and the value.
+func journaldEntry(level string, tag string, message string) []byte {
+	var buf bytes.Buffer
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"PRIORITY", fmt.Sprint(journaldPriorities[level])},
+		{"SYSLOG_IDENTIFIER", tag},
+		{"MESSAGE", message},
+	}
+	for _, field := range fields {
+		if !strings.Contains(field.value, "\n") {
+			fmt.Fprintf(&buf, "%s=%s\n", field.name, field.value)
+			continue
+		}
+		buf.WriteString(field.name + "\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(field.value)))
+		buf.WriteString(field.value + "\n")
+	}
+	return buf.Bytes()
+}
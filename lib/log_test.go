@@ -0,0 +1,232 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLogger(t *testing.T) {
+	dir := t.TempDir()
+	testData := []struct {
+		desc    string
+		options *LogOptions
+		wantErr string
+	}{
+		{
+			desc:    "stderr",
+			options: &LogOptions{Target: "stderr", Level: "debug"},
+		},
+		{
+			desc:    "file",
+			options: &LogOptions{Target: "file:" + filepath.Join(dir, "tc_reader.log")},
+		},
+		{
+			desc:    "unknown target",
+			options: &LogOptions{Target: "console"},
+			wantErr: "unknown log target 'console'",
+		},
+		{
+			desc:    "file without path",
+			options: &LogOptions{Target: "file:"},
+			wantErr: "the path of the log file is required",
+		},
+		{
+			desc:    "unknown syslog facility",
+			options: &LogOptions{Facility: "local8"},
+			wantErr: "unknown syslog facility 'local8'",
+		},
+		{
+			desc:    "unknown level",
+			options: &LogOptions{Target: "stderr", Level: "notice"},
+			wantErr: "unknown log level 'notice'",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			logger, err := NewLogger(tc.options)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("NewLogger => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewLogger => unexpected error: %s", err)
+			}
+			if err := logger.Close(); err != nil {
+				t.Errorf("Close => unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestLeveledLogger(t *testing.T) {
+	testData := []struct {
+		level string
+		want  []string
+	}{
+		{"error", []string{"ERROR error", "DEBUG debug"}},
+		{"warning", []string{"ERROR error", "WARNING warning", "DEBUG debug"}},
+		{"info", []string{"ERROR error", "WARNING warning", "INFO info", "DEBUG debug"}},
+		{"debug", []string{"ERROR error", "WARNING warning", "INFO info", "DEBUG debug"}},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.level, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := &leveledLogger{
+				severity: logSeverities[tc.level],
+				backend:  &streamBackend{writer: &buf},
+			}
+			l.Err("error")
+			l.Warning("warning")
+			l.Info("info")
+			l.Debug("debug")
+
+			var got []string
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+				fields := strings.SplitN(line, " ", 2)
+				if _, err := time.Parse(time.RFC3339, fields[0]); err != nil {
+					t.Errorf("line %q => unable to parse the time, error: %s", line, err)
+				}
+				got = append(got, fields[1])
+			}
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("leveledLogger => got: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLogHelpers(t *testing.T) {
+	var buf bytes.Buffer
+	l := &leveledLogger{
+		severity: logSeverities[logLevelInfo],
+		backend:  &streamBackend{writer: &buf},
+	}
+	logWarning(l, "warning")
+	logDebug(l, "debug")
+	if got := buf.String(); !strings.Contains(got, " WARNING warning\n") || !strings.Contains(got, " DEBUG debug\n") {
+		t.Errorf("logWarning and logDebug => got: %q, want a warning and a debug line", got)
+	}
+
+	// Loggers without the levels receive the messages as informational ones.
+	f := &fakeSyslog{}
+	logWarning(f, "warning")
+	logDebug(f, "debug")
+	if got, want := strings.Join(f.info, "|"), "warning|debug"; got != want {
+		t.Errorf("logWarning and logDebug => got info: %q, want: %q", got, want)
+	}
+}
+
+func TestFileBackendRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tc_reader.log")
+	f, err := newFileBackend(path, 160)
+	if err != nil {
+		t.Fatalf("newFileBackend => unexpected error: %s", err)
+	}
+	defer f.close()
+
+	message := strings.Repeat("x", 40)
+	for i := 0; i < 3; i++ {
+		if err := f.write(logLevelInfo, message); err != nil {
+			t.Fatalf("write => unexpected error: %s", err)
+		}
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) => unexpected error: %s", path, err)
+	}
+	rotated, err := os.ReadFile(path + logRotatedSuffix)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) => unexpected error: %s", path+logRotatedSuffix, err)
+	}
+	if got := strings.Count(string(rotated), message); got != 2 {
+		t.Errorf("rotated file => got %d messages, want: 2", got)
+	}
+	if got := strings.Count(string(current), message); got != 1 {
+		t.Errorf("current file => got %d messages, want: 1", got)
+	}
+}
+
+func TestJournaldEntry(t *testing.T) {
+	testData := []struct {
+		desc    string
+		level   string
+		message string
+		want    string
+	}{
+		{
+			desc:    "single line",
+			level:   logLevelWarning,
+			message: "Interface eth1 isn't present.",
+			want:    "PRIORITY=4\nSYSLOG_IDENTIFIER=tc_reader\nMESSAGE=Interface eth1 isn't present.\n",
+		},
+		{
+			desc:    "multiple lines",
+			level:   logLevelError,
+			message: "a\nb",
+			want:    "PRIORITY=3\nSYSLOG_IDENTIFIER=tc_reader\nMESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := string(journaldEntry(tc.level, "tc_reader", tc.message)); got != tc.want {
+				t.Errorf("journaldEntry => got: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJournaldBackend(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Fatalf("ListenPacket => unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	j, err := newJournaldBackend(socket, "tc_reader")
+	if err != nil {
+		t.Fatalf("newJournaldBackend => unexpected error: %s", err)
+	}
+	defer j.close()
+	if err := j.write(logLevelInfo, "Starting the tc_reader."); err != nil {
+		t.Fatalf("write => unexpected error: %s", err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom => unexpected error: %s", err)
+	}
+	want := "PRIORITY=6\nSYSLOG_IDENTIFIER=tc_reader\nMESSAGE=Starting the tc_reader.\n"
+	if got := string(buf[:n]); got != want {
+		t.Errorf("journaldBackend => got: %q, want: %q", got, want)
+	}
+}
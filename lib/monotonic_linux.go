@@ -0,0 +1,41 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+monotonic_linux.go reads the monotonic timestamps of the parse cycles from the CLOCK_BOOTTIME of the kernel. Unlike the
+wall clock, it isn't stepped by NTP, and unlike the monotonic clock of Go, it keeps counting while the system is
+suspended, so that the intervals between the parse cycles include the suspended time.
+*/
+
+package lib
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// clockBoottime is the CLOCK_BOOTTIME clock ID of clock_gettime(2).
+const clockBoottime = 7
+
+// monotonicNow returns the time since the boot, including the time the system was suspended. It falls back to the
+// time since the start of tc_reader if the clock can't be read.
+func monotonicNow() time.Duration {
+	var ts syscall.Timespec
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockBoottime, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		return monotonicFallback()
+	}
+	return time.Duration(ts.Nano())
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonotonicNow(t *testing.T) {
+	first := monotonicNow()
+	// The system was booted before tc_reader started.
+	if uptime := monotonicFallback(); first < uptime {
+		t.Errorf("monotonicNow => got: %s, want at least the time since the start of tc_reader: %s", first, uptime)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if second := monotonicNow(); second-first < 10*time.Millisecond {
+		t.Errorf("monotonicNow => got %s after sleeping for 10ms, want at least 10ms after %s", second, first)
+	}
+}
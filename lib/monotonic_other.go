@@ -0,0 +1,30 @@
+//go:build !linux
+
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+monotonic_other.go is used on systems without CLOCK_BOOTTIME, the monotonic timestamps of the parse cycles are read from
+the monotonic clock of Go there. They aren't stepped by NTP, but they may not include the time the system was suspended.
+*/
+
+package lib
+
+import "time"
+
+// monotonicNow returns the time since the start of tc_reader.
+func monotonicNow() time.Duration {
+	return monotonicFallback()
+}
@@ -0,0 +1,341 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+mqtt.go publishes the exported metrics to a MQTT broker after each parse cycle, e.g. for Home Assistant on routers
+that have a MQTT broker but no SNMP NMS. It speaks MQTT 3.1.1 over TCP or TLS and publishes with QoS 0, every value
+to its own topic created from a template, e.g. "tc_reader/{host}/{iface}/{class}/{metric}", see sink.go for the
+placeholders. The payload is the value in decimal.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// mqttPort is the default port of MQTT.
+	mqttPort = "1883"
+
+	// mqttTLSPort is the default port of MQTT over TLS.
+	mqttTLSPort = "8883"
+
+	// mqttQueueSize is the number of parse cycles waiting to be published, the further ones are dropped.
+	mqttQueueSize = 4
+
+	// mqttTimeout is the timeout of connecting to and writing to the broker.
+	mqttTimeout = 10 * time.Second
+
+	// mqttProtocolLevel is the protocol level of MQTT 3.1.1.
+	mqttProtocolLevel = 4
+
+	// The MQTT control packet types, shifted into the fixed header.
+	mqttConnect = 0x10
+	mqttConnAck = 0x20
+	mqttPublish = 0x30
+
+	// mqttRetainFlag is the flag in the fixed header of PUBLISH that asks the broker to retain the message.
+	mqttRetainFlag = 0x01
+
+	// The flags of CONNECT.
+	mqttCleanSession = 0x02
+	mqttPasswordFlag = 0x40
+	mqttUserFlag     = 0x80
+)
+
+// mqttTopic is the default template of the topics.
+var mqttTopic = "tc_reader/{host}/{iface}/{class}/{metric}"
+
+// MqttOptions are the options of the MQTT sink.
+type MqttOptions struct {
+	// Broker is the host and port of the MQTT broker, e.g. "broker.example.com:1883".
+	// The metrics aren't published if this isn't set.
+	Broker string
+
+	// Topic is the template of the topics. Defaults to mqttTopic.
+	Topic string
+
+	// Retain asks the broker to retain the last published values for the new subscribers.
+	Retain bool
+
+	// TLS connects to the broker over TLS.
+	TLS bool
+
+	// CAFile is the file with the PEM encoded CA certificates that verify the broker. Defaults to the system roots.
+	CAFile string
+
+	// User is the user name to authenticate to the broker, no authentication if this isn't set.
+	User string
+
+	// Password is the password of the User.
+	Password string
+
+	// ClientID is the client identifier presented to the broker. Defaults to "tc_reader-" followed by the hostname.
+	ClientID string
+}
+
+// topic returns the configured template of the topics, or the default one if it wasn't set.
+func (o *MqttOptions) topic() string {
+	if o.Topic != "" {
+		return o.Topic
+	}
+	return mqttTopic
+}
+
+// broker returns the configured broker with the default port if it doesn't specify one.
+func (o *MqttOptions) broker() string {
+	if _, _, err := net.SplitHostPort(o.Broker); err != nil {
+		if o.TLS {
+			return net.JoinHostPort(o.Broker, mqttTLSPort)
+		}
+		return net.JoinHostPort(o.Broker, mqttPort)
+	}
+	return o.Broker
+}
+
+// clientID returns the configured client identifier, or the default one for the hostname if it wasn't set.
+func (o *MqttOptions) clientID(hostname string) string {
+	if o.ClientID != "" {
+		return o.ClientID
+	}
+	return "tc_reader-" + hostname
+}
+
+// validMqttTopic returns an error if the template of the topics contains an unknown placeholder or a wildcard.
+func validMqttTopic(template string) error {
+	if err := validMetricPath(template); err != nil {
+		return err
+	}
+	if strings.ContainsAny(template, "+#") {
+		return errors.New("the topic must not contain the wildcards + and #")
+	}
+	return nil
+}
+
+// mqttSink implements metricSink.
+type mqttSink struct {
+	// options holds the configurable options.
+	options *MqttOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// host is the hostname used for the {host} placeholder, escaped by mqttComponent.
+	host string
+
+	// clientID is the client identifier presented to the broker.
+	clientID string
+
+	// tlsConfig configures the TLS connections, nil if TLS isn't used.
+	tlsConfig *tls.Config
+
+	// conn is the connection to the broker, nil if it isn't connected.
+	conn net.Conn
+
+	// queue holds the encoded parse cycles until they are published.
+	queue chan []byte
+
+	// done is closed when all the queued parse cycles were published.
+	done chan struct{}
+}
+
+// newMqttSink creates a mqttSink and starts publishing the metrics in the background.
+// The connection is established when the first metrics are published and reestablished after errors.
+func newMqttSink(options *MqttOptions, logger sysLogger) (*mqttSink, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Err(fmt.Sprintf("newMqttSink(): Unable to determine the hostname for the topics, error: %s", err))
+	}
+	m := &mqttSink{
+		options:  options,
+		logger:   logger,
+		host:     mqttComponent(hostname),
+		clientID: options.clientID(hostname),
+		queue:    make(chan []byte, mqttQueueSize),
+		done:     make(chan struct{}),
+	}
+	if options.TLS {
+		m.tlsConfig = &tls.Config{}
+		if options.CAFile != "" {
+			pem, err := os.ReadFile(options.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			m.tlsConfig.RootCAs = x509.NewCertPool()
+			if !m.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+			}
+		}
+	}
+	go m.send()
+	return m, nil
+}
+
+// flush encodes the values into PUBLISH packets and queues them. The values are dropped if the queue is full.
+func (m *mqttSink) flush(values []metricValue, at time.Time) {
+	if len(values) == 0 {
+		return
+	}
+	select {
+	case m.queue <- m.packets(values):
+	default:
+		m.logger.Err(fmt.Sprintf("flush(): Too many parse cycles waiting, dropping the metrics for %s.", m.options.broker()))
+	}
+}
+
+// packets encodes the values into PUBLISH packets, one for each.
+func (m *mqttSink) packets(values []metricValue) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		topic := metricPath(m.options.topic(), m.host, v, mqttComponent)
+		buf.Write(mqttPublishPacket(topic, []byte(fmt.Sprint(v.value)), m.options.Retain))
+	}
+	return buf.Bytes()
+}
+
+// close publishes the queued parse cycles and closes the connection.
+func (m *mqttSink) close(ctx context.Context) error {
+	close(m.queue)
+	return awaitSender(ctx, m.done)
+}
+
+// send writes the queued parse cycles to the broker until the queue is closed.
+func (m *mqttSink) send() {
+	defer close(m.done)
+	defer func() {
+		if m.conn != nil {
+			m.conn.Close()
+		}
+	}()
+	for packets := range m.queue {
+		if m.conn == nil {
+			conn, err := m.connect()
+			if err != nil {
+				m.logger.Err(fmt.Sprintf("send(): Unable to connect to %s, dropping the metrics, error: %s", m.options.broker(), err))
+				continue
+			}
+			m.conn = conn
+		}
+		m.conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+		if _, err := m.conn.Write(packets); err != nil {
+			m.logger.Err(fmt.Sprintf("send(): Unable to publish the metrics to %s, error: %s", m.options.broker(), err))
+			m.conn.Close()
+			m.conn = nil
+		}
+	}
+}
+
+// connect establishes the connection to the broker and waits until the broker accepts it.
+func (m *mqttSink) connect() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	var conn net.Conn
+	var err error
+	if m.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.options.broker(), m.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", m.options.broker())
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	if _, err := conn.Write(mqttConnectPacket(m.clientID, m.options.User, m.options.Password)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	connAck := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connAck); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if connAck[0] != mqttConnAck || connAck[1] != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected response 0x%x to CONNECT", connAck)
+	}
+	if connAck[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("the broker refused the connection with the return code %d", connAck[3])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// mqttConnectPacket encodes a CONNECT packet with a clean session and without keep alive.
+// The user and the password are sent only if the user is set.
+func mqttConnectPacket(clientID, user, password string) []byte {
+	flags := byte(mqttCleanSession)
+	payload := [][]byte{mqttString(clientID)}
+	if user != "" {
+		flags |= mqttUserFlag | mqttPasswordFlag
+		payload = append(payload, mqttString(user), mqttString(password))
+	}
+	header := append(mqttString("MQTT"), mqttProtocolLevel, flags, 0, 0)
+	return mqttPacket(mqttConnect, append([][]byte{header}, payload...)...)
+}
+
+// mqttPublishPacket encodes a PUBLISH packet with QoS 0.
+func mqttPublishPacket(topic string, payload []byte, retain bool) []byte {
+	packetType := byte(mqttPublish)
+	if retain {
+		packetType |= mqttRetainFlag
+	}
+	return mqttPacket(packetType, mqttString(topic), payload)
+}
+
+// mqttPacket encodes a control packet from the first byte of the fixed header and the parts of the rest.
+func mqttPacket(packetType byte, parts ...[]byte) []byte {
+	var length int
+	for _, part := range parts {
+		length += len(part)
+	}
+	packet := []byte{packetType}
+	// The remaining length is encoded in 7 bits per byte, the highest bit tells that more bytes follow.
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	for _, part := range parts {
+		packet = append(packet, part...)
+	}
+	return packet
+}
+
+// mqttString encodes the string prefixed by its length in two bytes.
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// mqttComponent replaces the characters that aren't allowed in a level of a topic.
+func mqttComponent(component string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(component)
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMqttOptions(t *testing.T) {
+	testData := []struct {
+		options      *MqttOptions
+		wantBroker   string
+		wantTopic    string
+		wantClientID string
+	}{
+		{&MqttOptions{Broker: "broker"}, "broker:1883", "tc_reader/{host}/{iface}/{class}/{metric}", "tc_reader-router"},
+		{&MqttOptions{Broker: "broker", TLS: true}, "broker:8883", "tc_reader/{host}/{iface}/{class}/{metric}", "tc_reader-router"},
+		{&MqttOptions{Broker: "broker:1884", Topic: "tc/{metric}", ClientID: "shaper"}, "broker:1884", "tc/{metric}", "shaper"},
+	}
+
+	for _, tc := range testData {
+		if got := tc.options.broker(); got != tc.wantBroker {
+			t.Errorf("broker(%+v) => got: %s, want: %s", tc.options, got, tc.wantBroker)
+		}
+		if got := tc.options.topic(); got != tc.wantTopic {
+			t.Errorf("topic(%+v) => got: %s, want: %s", tc.options, got, tc.wantTopic)
+		}
+		if got := tc.options.clientID("router"); got != tc.wantClientID {
+			t.Errorf("clientID(%+v) => got: %s, want: %s", tc.options, got, tc.wantClientID)
+		}
+	}
+}
+
+func TestValidMqttTopic(t *testing.T) {
+	testData := []struct {
+		template string
+		wantErr  string
+	}{
+		{"tc_reader/{host}/{iface}/{class}/{metric}", ""},
+		{"home/{name}", "unknown placeholder {name} in the metric path"},
+		{"home/#", "the topic must not contain the wildcards + and #"},
+	}
+
+	for _, tc := range testData {
+		err := validMqttTopic(tc.template)
+		if (err == nil && tc.wantErr != "") || (err != nil && err.Error() != tc.wantErr) {
+			t.Errorf("validMqttTopic(%s) => got error: %v, want: %q", tc.template, err, tc.wantErr)
+		}
+	}
+}
+
+func TestMqttPackets(t *testing.T) {
+	testData := []struct {
+		desc   string
+		packet []byte
+		want   []byte
+	}{
+		{
+			desc:   "CONNECT without an user",
+			packet: mqttConnectPacket("tc", "", ""),
+			want:   []byte{0x10, 0x0e, 0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 0, 0, 2, 't', 'c'},
+		},
+		{
+			desc:   "CONNECT with an user",
+			packet: mqttConnectPacket("tc", "u", "p"),
+			want:   []byte{0x10, 0x14, 0, 4, 'M', 'Q', 'T', 'T', 4, 0xc2, 0, 0, 0, 2, 't', 'c', 0, 1, 'u', 0, 1, 'p'},
+		},
+		{
+			desc:   "PUBLISH",
+			packet: mqttPublishPacket("a/b", []byte("42"), false),
+			want:   []byte{0x30, 0x07, 0, 3, 'a', '/', 'b', '4', '2'},
+		},
+		{
+			desc:   "retained PUBLISH",
+			packet: mqttPublishPacket("a", []byte("1"), true),
+			want:   []byte{0x31, 0x04, 0, 1, 'a', '1'},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if !bytes.Equal(tc.packet, tc.want) {
+				t.Errorf("packet => got: %#v, want: %#v", tc.packet, tc.want)
+			}
+		})
+	}
+}
+
+func TestMqttPacketRemainingLength(t *testing.T) {
+	testData := []struct {
+		length int
+		want   []byte
+	}{
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+	}
+
+	for _, tc := range testData {
+		packet := mqttPacket(mqttPublish, make([]byte, tc.length))
+		if got := packet[1 : 1+len(tc.want)]; !bytes.Equal(got, tc.want) || len(packet) != 1+len(tc.want)+tc.length {
+			t.Errorf("mqttPacket(%d bytes) => got remaining length: %#v, want: %#v", tc.length, got, tc.want)
+		}
+	}
+}
+
+func TestMqttComponent(t *testing.T) {
+	if got, want := mqttComponent("eth0:1/2+#"), "eth0:1_2__"; got != want {
+		t.Errorf("mqttComponent => got: %s, want: %s", got, want)
+	}
+}
+
+func TestMqttSinkConnect(t *testing.T) {
+	testData := []struct {
+		desc     string
+		response []byte
+		wantErr  string
+	}{
+		{
+			desc:     "accepted",
+			response: []byte{mqttConnAck, 2, 0, 0},
+		},
+		{
+			desc:     "refused",
+			response: []byte{mqttConnAck, 2, 0, 5},
+			wantErr:  "the broker refused the connection with the return code 5",
+		},
+		{
+			desc:     "not a CONNACK",
+			response: []byte{mqttPublish, 2, 0, 0},
+			wantErr:  "unexpected response 0x30020000 to CONNECT",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen => unexpected error: %s", err)
+			}
+			defer listener.Close()
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				connect := make([]byte, len(mqttConnectPacket("tc", "u", "p")))
+				if _, err := io.ReadFull(conn, connect); err != nil {
+					return
+				}
+				conn.Write(tc.response)
+				io.Copy(io.Discard, conn)
+			}()
+
+			m := &mqttSink{
+				options:  &MqttOptions{Broker: listener.Addr().String(), User: "u", Password: "p"},
+				clientID: "tc",
+			}
+			conn, err := m.connect()
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("connect => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("connect => unexpected error: %s", err)
+			}
+			conn.Close()
+		})
+	}
+}
+
+func TestMqttSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen => unexpected error: %s", err)
+	}
+	defer listener.Close()
+
+	m, err := newMqttSink(&MqttOptions{Broker: listener.Addr().String(), Topic: "tc/{class}/{metric}", Retain: true, ClientID: "tc"}, &fakeSyslog{})
+	if err != nil {
+		t.Fatalf("newMqttSink => unexpected error: %s", err)
+	}
+	// Nothing is published for an empty cycle.
+	m.flush(nil, time.Unix(1357000000, 0))
+	m.flush([]metricValue{{"sentPkt", tcNameLeaf, "eth0:1:1", int64(5)}}, time.Unix(1357000010, 0))
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept => unexpected error: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	wantConnect := mqttConnectPacket("tc", "", "")
+	connect := make([]byte, len(wantConnect))
+	if _, err := io.ReadFull(conn, connect); err != nil {
+		t.Fatalf("ReadFull => unexpected error: %s", err)
+	}
+	if !bytes.Equal(connect, wantConnect) {
+		t.Errorf("mqttSink => sent CONNECT: %#v, want: %#v", connect, wantConnect)
+	}
+	if _, err := conn.Write([]byte{mqttConnAck, 2, 0, 0}); err != nil {
+		t.Fatalf("Write => unexpected error: %s", err)
+	}
+
+	wantPublish := mqttPublishPacket("tc/1:1/sentPkt", []byte("5"), true)
+	publish := make([]byte, len(wantPublish))
+	if _, err := io.ReadFull(conn, publish); err != nil {
+		t.Fatalf("ReadFull => unexpected error: %s", err)
+	}
+	if !bytes.Equal(publish, wantPublish) {
+		t.Errorf("mqttSink => sent PUBLISH: %#v, want: %#v", publish, wantPublish)
+	}
+}
+
+func TestNewMqttSinkCAFile(t *testing.T) {
+	if _, err := newMqttSink(&MqttOptions{Broker: "broker", TLS: true, CAFile: "testdata/missing.pem"}, &fakeSyslog{}); err == nil {
+		t.Errorf("newMqttSink => got no error for a missing CA file")
+	}
+}
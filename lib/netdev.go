@@ -0,0 +1,261 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+netdev.go collects the kernel counters of the monitored interfaces from /proc/net/dev, the same counters as in
+/sys/class/net/<iface>/statistics. These are read right after the TC commands in the same parse cycle, so that the
+packets lost by the NIC or the driver can be told apart from the ones dropped by the shaping.
+
+Example of /proc/net/dev:
+Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0: 1527361    9764    0    2    0     0          0         3   602841    4211    0    0    0     0       0          0
+
+With LinkStats the counters are parsed from "ip -s -s link show" instead, which adds the packets missed by the NIC and
+the carrier transitions. The counters are looked up by the names in the headers, these differ between the versions
+of iproute2, e.g. "missed" moved from the "RX errors:" to the "RX:" header.
+
+Example of ip -s -s link show:
+2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc fq_codel state UP mode DEFAULT group default qlen 1000
+    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff
+    RX:  bytes packets errors dropped  missed   mcast
+       1527361    9764      0       2       7       3
+    RX errors:  length    crc   frame    fifo overrun
+                     0      0       0       0       0
+    TX:  bytes packets errors dropped carrier collsns
+        602841    4211      0       0       0       0
+    TX errors: aborted   fifo  window heartbt transns
+                     0      0       0       0       3
+*/
+
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// netDevPath is the file with the kernel counters of the network interfaces, see proc(5).
+	netDevPath = "/proc/net/dev"
+
+	// netDevFields is the number of the counters of an interface in netDevPath.
+	netDevFields = 16
+)
+
+// netDevStats are the kernel counters of a network interface.
+type netDevStats struct {
+	// iface is the name of the interface.
+	iface string
+
+	// ifIndex is the kernel ifIndex of the interface.
+	ifIndex int
+
+	// rxBytes is the number of received bytes.
+	rxBytes int64
+
+	// rxPkt is the number of received packets.
+	rxPkt int64
+
+	// rxErrors is the number of receive errors.
+	rxErrors int64
+
+	// rxDropped is the number of received packets dropped by the kernel or the driver.
+	rxDropped int64
+
+	// txBytes is the number of transmitted bytes.
+	txBytes int64
+
+	// txPkt is the number of transmitted packets.
+	txPkt int64
+
+	// txErrors is the number of transmit errors.
+	txErrors int64
+
+	// txDropped is the number of packets dropped by the kernel or the driver on transmit.
+	txDropped int64
+
+	// linkStats indicates that the counters were parsed from the ip command, so that the ones below are set.
+	linkStats bool
+
+	// rxMissed is the number of received packets missed by the NIC, e.g. for the lack of the receive buffers.
+	rxMissed int64
+
+	// carrierChanges is the number of the carrier transitions, i.e. the link going down or up.
+	carrierChanges int64
+}
+
+// readNetDev opens netDevPath, or executes cat on it through the ExecWrapper, so that the counters are read in the
+// network namespace of the TC commands.
+func (t *tcParser) readNetDev() (io.Reader, error) {
+	if len(t.options.ExecWrapper) > 0 {
+		return t.execute("cat", netDevPath)
+	}
+	content, err := os.ReadFile(netDevPath)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(string(content)), nil
+}
+
+// collectNetDev returns the kernel counters of the interfaces whose TC commands succeeded, in the same order, if
+// NetDev or LinkStats is set. The interfaces without a kernel ifIndex are left out. A failure is logged and doesn't
+// fail the parse cycle.
+func (t *tcParser) collectNetDev(outputs []ifaceOutput) []netDevStats {
+	if !t.options.NetDev && !t.options.LinkStats {
+		return nil
+	}
+	source, read, parse := netDevPath, t.netDevReader, parseNetDev
+	if read == nil {
+		read = t.readNetDev
+	}
+	if t.options.LinkStats {
+		source, parse = t.options.ipCmdPath()+" -s -s link show", parseLinkStats
+		read = func() (io.Reader, error) {
+			return t.execute(t.options.ipCmdPath(), "-s", "-s", "link", "show")
+		}
+	}
+	content, err := read()
+	if err != nil {
+		t.logger.Err(fmt.Sprintf("collectNetDev(): Unable to read %s, error: %s", source, err))
+		return nil
+	}
+	counters, err := parse(content)
+	if err != nil {
+		t.logger.Err(fmt.Sprintf("collectNetDev(): Unable to parse %s, error: %s", source, err))
+		return nil
+	}
+	var stats []netDevStats
+	for _, output := range outputs {
+		if output.err != nil || output.ifIndex == 0 {
+			continue
+		}
+		iface, ok := counters[output.iface]
+		if !ok {
+			t.logIfDebug(fmt.Sprintf("collectNetDev(): The interface %s isn't in %s.", output.iface, source))
+			continue
+		}
+		iface.ifIndex = output.ifIndex
+		stats = append(stats, iface)
+	}
+	return stats
+}
+
+// parseNetDev parses the content of netDevPath into the counters of the interfaces keyed by their names.
+func parseNetDev(content io.Reader) (map[string]netDevStats, error) {
+	counters := make(map[string]netDevStats)
+	scanner := bufio.NewScanner(content)
+	for scanner.Scan() {
+		iface, values, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.Contains(iface, "|") {
+			continue
+		}
+		fields := strings.Fields(values)
+		if len(fields) < netDevFields {
+			return nil, fmt.Errorf("got %d counters of the interface %s, want %d", len(fields), strings.TrimSpace(iface), netDevFields)
+		}
+		var numbers [netDevFields]int64
+		for i := range numbers {
+			number, err := strconv.ParseInt(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid counter of the interface %s: %s", strings.TrimSpace(iface), err)
+			}
+			numbers[i] = number
+		}
+		name := strings.TrimSpace(iface)
+		counters[name] = netDevStats{
+			iface:     name,
+			rxBytes:   numbers[0],
+			rxPkt:     numbers[1],
+			rxErrors:  numbers[2],
+			rxDropped: numbers[3],
+			txBytes:   numbers[8],
+			txPkt:     numbers[9],
+			txErrors:  numbers[10],
+			txDropped: numbers[11],
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return counters, nil
+}
+
+// parseLinkStats parses the output of "ip -s -s link show" into the counters of the interfaces keyed by their names.
+// The counters that the version of iproute2 doesn't print are left zero.
+func parseLinkStats(content io.Reader) (map[string]netDevStats, error) {
+	counters := make(map[string]netDevStats)
+	var iface string
+	var values map[string]int64
+	var section string
+	var names []string
+	add := func() {
+		if iface == emptyString {
+			return
+		}
+		counters[iface] = netDevStats{
+			iface:          iface,
+			rxBytes:        values["RX bytes"],
+			rxPkt:          values["RX packets"],
+			rxErrors:       values["RX errors"],
+			rxDropped:      values["RX dropped"],
+			txBytes:        values["TX bytes"],
+			txPkt:          values["TX packets"],
+			txErrors:       values["TX errors"],
+			txDropped:      values["TX dropped"],
+			linkStats:      true,
+			rxMissed:       values["RX missed"] + values["RX errors missed"],
+			carrierChanges: values["TX errors transns"],
+		}
+	}
+	scanner := bufio.NewScanner(content)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := reIpLink.FindStringSubmatch(line); match != nil {
+			add()
+			iface, values, names = match[2], make(map[string]int64), nil
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if header, columns, ok := strings.Cut(trimmed, ":"); ok && (strings.HasPrefix(header, "RX") || strings.HasPrefix(header, "TX")) {
+			section, names = header, strings.Fields(columns)
+			continue
+		}
+		if names == nil || iface == emptyString {
+			continue
+		}
+		fields := strings.Fields(trimmed)
+		if len(fields) != len(names) {
+			return nil, fmt.Errorf("got %d %s counters of the interface %s, want %d", len(fields), section, iface, len(names))
+		}
+		for i, field := range fields {
+			value, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s counter of the interface %s: %s", section, iface, err)
+			}
+			values[section+" "+names[i]] = value
+		}
+		names = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	add()
+	return counters, nil
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// netDevContent is the content of /proc/net/dev used in the tests.
+const netDevContent = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:  2776770   11307    0    0    0     0          0         0  2776770   11307    0    0    0     0       0          0
+  eth0: 1527361    9764    1    2    0     0          0         3   602841    4211    4    5    0     0       0          0
+ eth1.100:100 1 0 0 0 0 0 0 200 2 0 0 0 0 0 0
+`
+
+func TestParseNetDev(t *testing.T) {
+	testData := []struct {
+		desc    string
+		content string
+		want    map[string]netDevStats
+		wantErr bool
+	}{
+		{
+			desc:    "interfaces are parsed",
+			content: netDevContent,
+			want: map[string]netDevStats{
+				"lo":       {iface: "lo", rxBytes: 2776770, rxPkt: 11307, txBytes: 2776770, txPkt: 11307},
+				"eth0":     {iface: "eth0", rxBytes: 1527361, rxPkt: 9764, rxErrors: 1, rxDropped: 2, txBytes: 602841, txPkt: 4211, txErrors: 4, txDropped: 5},
+				"eth1.100": {iface: "eth1.100", rxBytes: 100, rxPkt: 1, txBytes: 200, txPkt: 2},
+			},
+		},
+		{
+			desc:    "only the headers",
+			content: "Inter-|   Receive  |  Transmit\n face |bytes    packets|bytes    packets\n",
+			want:    map[string]netDevStats{},
+		},
+		{
+			desc:    "missing counters",
+			content: "  eth0: 1527361    9764    1    2\n",
+			wantErr: true,
+		},
+		{
+			desc:    "invalid counter",
+			content: "  eth0: 1527361 many 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseNetDev(strings.NewReader(tc.content))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseNetDev => got error: %v, want error: %v", err, tc.wantErr)
+			}
+			if diff := pretty.Compare(tc.want, got); !tc.wantErr && diff != "" {
+				t.Errorf("parseNetDev => unexpected counters, diff (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// linkStatsContent is the output of "ip -s -s link show" of a recent iproute2 used in the tests.
+const linkStatsContent = `1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN mode DEFAULT group default qlen 1000
+    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00
+    RX:  bytes packets errors dropped  missed   mcast
+       2776770   11307      0       0       0       0
+    TX:  bytes packets errors dropped carrier collsns
+       2776770   11307      0       0       0       0
+2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc fq_codel state UP mode DEFAULT group default qlen 1000
+    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff
+    altname enp0s3
+    RX:  bytes packets errors dropped  missed   mcast
+       1527361    9764      1       2       7       3
+    RX errors:  length    crc   frame    fifo overrun
+                     0      0       0       0       0
+    TX:  bytes packets errors dropped carrier collsns
+        602841    4211      4       5       0       0
+    TX errors: aborted   fifo  window heartbt transns
+                     0      0       0       0       3
+`
+
+func TestParseLinkStats(t *testing.T) {
+	testData := []struct {
+		desc    string
+		content string
+		want    map[string]netDevStats
+		wantErr bool
+	}{
+		{
+			desc:    "recent iproute2",
+			content: linkStatsContent,
+			want: map[string]netDevStats{
+				"lo":   {iface: "lo", rxBytes: 2776770, rxPkt: 11307, txBytes: 2776770, txPkt: 11307, linkStats: true},
+				"eth0": {iface: "eth0", rxBytes: 1527361, rxPkt: 9764, rxErrors: 1, rxDropped: 2, txBytes: 602841, txPkt: 4211, txErrors: 4, txDropped: 5, linkStats: true, rxMissed: 7, carrierChanges: 3},
+			},
+		},
+		{
+			desc: "older iproute2 with missed in the RX errors",
+			content: `3: veth0@if2: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP mode DEFAULT group default qlen 1000
+    link/ether 52:54:00:12:34:57 brd ff:ff:ff:ff:ff:ff link-netnsid 0
+    RX: bytes  packets  errors  dropped overrun mcast
+    100        1        0       0       0       0
+    RX errors: length   crc     frame   fifo    missed
+               0        0       0       0       6
+    TX: bytes  packets  errors  dropped carrier collsns
+    200        2        0       0       0       0
+    TX errors: aborted  fifo   window heartbeat transns
+               0        0       0       0       1
+`,
+			want: map[string]netDevStats{
+				"veth0": {iface: "veth0", rxBytes: 100, rxPkt: 1, txBytes: 200, txPkt: 2, linkStats: true, rxMissed: 6, carrierChanges: 1},
+			},
+		},
+		{
+			desc:    "empty output",
+			content: "",
+			want:    map[string]netDevStats{},
+		},
+		{
+			desc:    "missing counters",
+			content: "2: eth0: <UP> mtu 1500\n    RX:  bytes packets errors dropped  missed   mcast\n       1527361    9764\n",
+			wantErr: true,
+		},
+		{
+			desc:    "invalid counter",
+			content: "2: eth0: <UP> mtu 1500\n    RX:  bytes packets\n       1527361    many\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseLinkStats(strings.NewReader(tc.content))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseLinkStats => got error: %v, want error: %v", err, tc.wantErr)
+			}
+			if diff := pretty.Compare(tc.want, got); !tc.wantErr && diff != "" {
+				t.Errorf("parseLinkStats => unexpected counters, diff (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTcParserCollectNetDev(t *testing.T) {
+	outputs := []ifaceOutput{
+		{iface: "eth0", ifIndex: 2},
+		{iface: "eth1", ifIndex: 3, err: errors.New("tc failed")},
+		{iface: "eth1.100", ifIndex: 0},
+		{iface: "eth2", ifIndex: 4},
+	}
+	testData := []struct {
+		desc      string
+		netDev    bool
+		content   string
+		err       error
+		want      []netDevStats
+		wantError bool
+	}{
+		{
+			desc:    "not configured",
+			content: netDevContent,
+		},
+		{
+			desc:    "interfaces with a kernel ifIndex and a successful TC command",
+			netDev:  true,
+			content: netDevContent,
+			want: []netDevStats{
+				{iface: "eth0", ifIndex: 2, rxBytes: 1527361, rxPkt: 9764, rxErrors: 1, rxDropped: 2, txBytes: 602841, txPkt: 4211, txErrors: 4, txDropped: 5},
+			},
+		},
+		{
+			desc:      "read fails",
+			netDev:    true,
+			err:       errors.New("permission denied"),
+			wantError: true,
+		},
+		{
+			desc:      "parse fails",
+			netDev:    true,
+			content:   "  eth0: 1\n",
+			wantError: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			logger := &fakeSyslog{}
+			p := &tcParser{
+				logger:  logger,
+				options: &TcParserOptions{NetDev: tc.netDev},
+				netDevReader: func() (io.Reader, error) {
+					return strings.NewReader(tc.content), tc.err
+				},
+			}
+			got := p.collectNetDev(outputs)
+			if diff := pretty.Compare(tc.want, got); diff != "" {
+				t.Errorf("collectNetDev => unexpected counters, diff (-want, +got):\n%s", diff)
+			}
+			if gotError := len(logger.err) > 0; gotError != tc.wantError {
+				t.Errorf("collectNetDev => got logged errors: %v, want an error logged: %v", logger.err, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestTcParserParseNetDev(t *testing.T) {
+	qdiscs := "qdisc htb 1: root refcnt 2 r2q 10 default 0\n Sent 300 bytes 3 pkt (dropped 1, overlimits 2 requeues 0)\n"
+	fs := &fakeDataSink{}
+	p := &tcParser{
+		logger: &fakeSyslog{},
+		options: &TcParserOptions{
+			Ifaces:      []string{"eth0"},
+			MaxCommands: 1,
+			NetDev:      true,
+		},
+		sink:          fs,
+		executer:      &fakeExecuter{output: []string{qdiscs, ""}, err: []error{nil, nil}},
+		ifaceReader:   &fakeIfaceReader{index: 2},
+		reQdiscHeader: regexp.MustCompile(reQdiscHeaderStr),
+		reClassHeader: regexp.MustCompile(reClassHeaderStr),
+		reStats:       regexp.MustCompile(reStatsStr),
+		netDevReader: func() (io.Reader, error) {
+			return strings.NewReader(netDevContent), nil
+		},
+	}
+	p.parseTc()
+	want := []netDevStats{
+		{iface: "eth0", ifIndex: 2, rxBytes: 1527361, rxPkt: 9764, rxErrors: 1, rxDropped: 2, txBytes: 602841, txPkt: 4211, txErrors: 4, txDropped: 5},
+	}
+	if diff := pretty.Compare(want, fs.netDev); diff != "" {
+		t.Errorf("parseTc => unexpected kernel counters, diff (-want, +got):\n%s", diff)
+	}
+}
+
+func TestTcParserCollectLinkStats(t *testing.T) {
+	executer := &fakeExecuter{output: []string{linkStatsContent}, err: []error{nil}}
+	p := &tcParser{
+		logger:   &fakeSyslog{},
+		options:  &TcParserOptions{LinkStats: true, IpCmdPath: "/sbin/ip", ExecWrapper: []string{"ip", "netns", "exec", "blue"}},
+		executer: executer,
+	}
+	got := p.collectNetDev([]ifaceOutput{{iface: "eth0", ifIndex: 2}})
+	want := []netDevStats{
+		{iface: "eth0", ifIndex: 2, rxBytes: 1527361, rxPkt: 9764, rxErrors: 1, rxDropped: 2, txBytes: 602841, txPkt: 4211, txErrors: 4, txDropped: 5, linkStats: true, rxMissed: 7, carrierChanges: 3},
+	}
+	if diff := pretty.Compare(want, got); diff != "" {
+		t.Errorf("collectNetDev => unexpected counters, diff (-want, +got):\n%s", diff)
+	}
+	wantArgs := [][]string{{"netns", "exec", "blue", "/sbin/ip", "-s", "-s", "link", "show"}}
+	if diff := pretty.Compare(wantArgs, executer.args); diff != "" {
+		t.Errorf("collectNetDev => unexpected arguments of the ip command, diff (-want, +got):\n%s", diff)
+	}
+}
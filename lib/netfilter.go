@@ -0,0 +1,266 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+netfilter.go counts the traffic of the users from the netfilter counters, for the deployments that account the
+traffic per address in nftables or iptables rather than per Class in TC. The counters are read in every parse cycle,
+right after the TC commands, and summed into the same per-user leaves as the Qdiscs / Classes of the users.
+
+The counters are referenced as:
+nft:family:table:name         - A named nftables counter, e.g. "nft:inet:filter:john_up".
+ipt:table:chain:comment       - The iptables rules of the chain with the comment, e.g. "ipt:mangle:ACCOUNTING:john_up".
+
+The named nftables counters are listed at once by "nft -j list counters". The iptables chains are listed by
+"iptables -w -t table -nvxL chain" once per chain, the rules with the same comment are summed. The commands run
+through the ExecWrapper, if set.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// nftCmdPath is the default path to the nft binary.
+	nftCmdPath = "/usr/sbin/nft"
+
+	// iptablesCmdPath is the default path to the iptables binary.
+	iptablesCmdPath = "/usr/sbin/iptables"
+
+	// nftCounter is the kind of the references to the named nftables counters.
+	nftCounter = "nft"
+
+	// iptCounter is the kind of the references to the commented iptables rules.
+	iptCounter = "ipt"
+)
+
+// reIptRule matches a rule in the output of "iptables -nvxL", e.g. "12 3400 all -- * * 10.0.0.7 0.0.0.0/0 /* john_up */".
+var reIptRule = regexp.MustCompile(`^\s*(?P<pkts>[0-9]+)\s+(?P<bytes>[0-9]+)\s.*/\* (?P<comment>.+?) \*/`)
+
+// counterRef is a parsed reference to a netfilter counter.
+type counterRef struct {
+	// kind is nftCounter or iptCounter.
+	kind string
+
+	// family is the address family of a nftables counter, e.g. "inet", empty for iptables.
+	family string
+
+	// table is the table of the counter, e.g. "filter".
+	table string
+
+	// chain is the chain of the iptables rules, empty for nftables.
+	chain string
+
+	// name is the name of the nftables counter or the comment of the iptables rules.
+	name string
+}
+
+// parseCounterRef parses a reference to a netfilter counter, e.g. "nft:inet:filter:john_up".
+func parseCounterRef(ref string) (counterRef, error) {
+	parts := strings.SplitN(ref, ":", 4)
+	if len(parts) != 4 || parts[1] == emptyString || parts[2] == emptyString || parts[3] == emptyString {
+		return counterRef{}, fmt.Errorf("invalid counter '%s', want nft:family:table:name or ipt:table:chain:comment", ref)
+	}
+	switch parts[0] {
+	case nftCounter:
+		return counterRef{kind: nftCounter, family: parts[1], table: parts[2], name: parts[3]}, nil
+	case iptCounter:
+		return counterRef{kind: iptCounter, table: parts[1], chain: parts[2], name: parts[3]}, nil
+	}
+	return counterRef{}, fmt.Errorf("unknown kind of the counter '%s', want nft or ipt", ref)
+}
+
+// key returns the reference to the counter.
+func (r counterRef) key() string {
+	if r.kind == nftCounter {
+		return strings.Join([]string{nftCounter, r.family, r.table, r.name}, ":")
+	}
+	return strings.Join([]string{iptCounter, r.table, r.chain, r.name}, ":")
+}
+
+// nftCmdPath returns the configured nftCmdPath, or the default one if it wasn't set.
+func (o *TcParserOptions) nftCmdPath() string {
+	if o != nil && o.NftCmdPath != emptyString {
+		return o.NftCmdPath
+	}
+	return nftCmdPath
+}
+
+// iptablesCmdPath returns the configured iptablesCmdPath, or the default one if it wasn't set.
+func (o *TcParserOptions) iptablesCmdPath() string {
+	if o != nil && o.IptablesCmdPath != emptyString {
+		return o.IptablesCmdPath
+	}
+	return iptablesCmdPath
+}
+
+// counterCommand is a command that lists netfilter counters.
+type counterCommand struct {
+	// args are the path to the binary followed by its arguments.
+	args []string
+
+	// parse adds the counters in the output of the command to the counters.
+	parse func(output io.Reader, counters map[string]sample) error
+}
+
+// counterCommands returns the commands that list the counters of the CounterUsers in a stable order.
+func (o *TcParserOptions) counterCommands() []counterCommand {
+	var nft bool
+	chains := make(map[string]counterRef)
+	for ref := range o.CounterUsers {
+		counter, err := parseCounterRef(ref)
+		if err != nil {
+			continue
+		}
+		if counter.kind == nftCounter {
+			nft = true
+			continue
+		}
+		chains[counter.table+" "+counter.chain] = counter
+	}
+	var commands []counterCommand
+	if nft {
+		commands = append(commands, counterCommand{
+			args:  []string{o.nftCmdPath(), "-j", "list", "counters"},
+			parse: parseNftCounters,
+		})
+	}
+	keys := make([]string, 0, len(chains))
+	for key := range chains {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		counter := chains[key]
+		commands = append(commands, counterCommand{
+			args: []string{o.iptablesCmdPath(), "-w", "-t", counter.table, "-nvxL", counter.chain},
+			parse: func(output io.Reader, counters map[string]sample) error {
+				return parseIptCounters(output, counter.table, counter.chain, counters)
+			},
+		})
+	}
+	return commands
+}
+
+// collectCounters returns the netfilter counters of the CounterUsers keyed by their references. The failures are
+// logged and the counters of the failed commands are left out, these don't fail the parse cycle.
+func (t *tcParser) collectCounters() map[string]sample {
+	if len(t.options.CounterUsers) == 0 {
+		return nil
+	}
+	counters := make(map[string]sample)
+	for _, command := range t.options.counterCommands() {
+		output, err := t.execute(command.args[0], command.args[1:]...)
+		if err == nil {
+			err = command.parse(output, counters)
+		}
+		if err != nil {
+			t.logger.Err(fmt.Sprintf("collectCounters(): Unable to read the counters by %s, error: %s", strings.Join(command.args, " "), err))
+		}
+	}
+	return counters
+}
+
+// addCounterUsers adds the netfilter counters to the summed counters of the users they are configured for, in the
+// order of their references. The counters that weren't read are skipped, none are added while replaying a recording.
+func (t *tcParser) addCounterUsers(counters map[string]sample) {
+	if counters == nil {
+		return
+	}
+	refs := make([]string, 0, len(t.options.CounterUsers))
+	for ref := range t.options.CounterUsers {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		counter, ok := counters[ref]
+		if !ok {
+			t.logIfDebug(fmt.Sprintf("addCounterUsers(): The counter %s wasn't found.", ref))
+			continue
+		}
+		t.addUserSample(t.options.CounterUsers[ref], counter)
+	}
+}
+
+// nftOutput is the JSON output of "nft -j list counters".
+type nftOutput struct {
+	// Nftables are the listed objects, only the counters are set.
+	Nftables []struct {
+		// Counter is a named counter, nil for the other objects, e.g. the metainfo.
+		Counter *struct {
+			// Family is the address family of the table, e.g. "inet".
+			Family string `json:"family"`
+
+			// Table is the name of the table.
+			Table string `json:"table"`
+
+			// Name is the name of the counter.
+			Name string `json:"name"`
+
+			// Packets is the number of the counted packets.
+			Packets int64 `json:"packets"`
+
+			// Bytes is the number of the counted bytes.
+			Bytes int64 `json:"bytes"`
+		} `json:"counter"`
+	} `json:"nftables"`
+}
+
+// parseNftCounters adds the named counters in the output of "nft -j list counters" to the counters.
+func parseNftCounters(output io.Reader, counters map[string]sample) error {
+	var parsed nftOutput
+	if err := json.NewDecoder(output).Decode(&parsed); err != nil {
+		return err
+	}
+	for _, object := range parsed.Nftables {
+		if object.Counter == nil {
+			continue
+		}
+		ref := counterRef{kind: nftCounter, family: object.Counter.Family, table: object.Counter.Table, name: object.Counter.Name}
+		counters[ref.key()] = sample{bytes: object.Counter.Bytes, pkt: object.Counter.Packets}
+	}
+	return nil
+}
+
+// parseIptCounters adds the rules with a comment in the output of "iptables -nvxL" of the chain in the table to the
+// counters, the rules with the same comment are summed.
+func parseIptCounters(output io.Reader, table, chain string, counters map[string]sample) error {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		match := reIptRule.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		pkt, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		bytes, err := strconv.ParseInt(match[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		key := counterRef{kind: iptCounter, table: table, chain: chain, name: match[3]}.key()
+		counters[key] = counters[key].add(sample{bytes: bytes, pkt: pkt})
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// nftCountersContent is the output of "nft -j list counters" used in the tests.
+const nftCountersContent = `{"nftables": [{"metainfo": {"version": "1.0.6", "release_name": "Lester Gooch #5", "json_schema_version": 1}}, {"counter": {"family": "inet", "name": "user1_up", "table": "filter", "handle": 3, "packets": 12, "bytes": 3400}}, {"counter": {"family": "inet", "name": "user1_down", "table": "filter", "handle": 4, "packets": 30, "bytes": 45000}}]}`
+
+// iptCountersContent is the output of "iptables -w -t mangle -nvxL ACCT" used in the tests.
+const iptCountersContent = `Chain ACCT (1 references)
+    pkts      bytes target     prot opt in     out     source               destination
+       5      600            all  --  *      *       10.0.0.7             0.0.0.0/0            /* user1_up */
+       2      100            all  --  *      *       2001:db8::7          ::/0                 /* user1_up */
+      40    60000            all  --  *      *       0.0.0.0/0            10.0.0.7             /* user1_down */
+       1       60            all  --  *      *       0.0.0.0/0            10.0.0.9
+`
+
+func TestParseCounterRef(t *testing.T) {
+	testData := []struct {
+		desc    string
+		ref     string
+		want    counterRef
+		wantErr bool
+	}{
+		{"nftables counter", "nft:inet:filter:user1_up", counterRef{kind: nftCounter, family: "inet", table: "filter", name: "user1_up"}, false},
+		{"iptables comment with colons", "ipt:mangle:ACCT:user1:up", counterRef{kind: iptCounter, table: "mangle", chain: "ACCT", name: "user1:up"}, false},
+		{"missing name", "nft:inet:filter", counterRef{}, true},
+		{"empty table", "ipt::ACCT:user1_up", counterRef{}, true},
+		{"unknown kind", "ebt:filter:FORWARD:user1_up", counterRef{}, true},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseCounterRef(tc.ref)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseCounterRef(%q) => got error: %v, want error: %v", tc.ref, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("parseCounterRef(%q) => got: %+v, want: %+v", tc.ref, got, tc.want)
+			}
+			if !tc.wantErr && got.key() != tc.ref {
+				t.Errorf("parseCounterRef(%q).key() => got: %q, want: %q", tc.ref, got.key(), tc.ref)
+			}
+		})
+	}
+}
+
+func TestParseNftCounters(t *testing.T) {
+	counters := make(map[string]sample)
+	if err := parseNftCounters(strings.NewReader(nftCountersContent), counters); err != nil {
+		t.Fatalf("parseNftCounters => unexpected error: %v", err)
+	}
+	want := map[string]sample{
+		"nft:inet:filter:user1_up":   {bytes: 3400, pkt: 12},
+		"nft:inet:filter:user1_down": {bytes: 45000, pkt: 30},
+	}
+	if diff := pretty.Compare(want, counters); diff != "" {
+		t.Errorf("parseNftCounters => unexpected counters, diff (-want, +got):\n%s", diff)
+	}
+
+	if err := parseNftCounters(strings.NewReader("Error: syntax error"), counters); err == nil {
+		t.Errorf("parseNftCounters => got no error for an output that isn't JSON")
+	}
+}
+
+func TestParseIptCounters(t *testing.T) {
+	counters := make(map[string]sample)
+	if err := parseIptCounters(strings.NewReader(iptCountersContent), "mangle", "ACCT", counters); err != nil {
+		t.Fatalf("parseIptCounters => unexpected error: %v", err)
+	}
+	want := map[string]sample{
+		"ipt:mangle:ACCT:user1_up":   {bytes: 700, pkt: 7},
+		"ipt:mangle:ACCT:user1_down": {bytes: 60000, pkt: 40},
+	}
+	if diff := pretty.Compare(want, counters); diff != "" {
+		t.Errorf("parseIptCounters => unexpected counters, diff (-want, +got):\n%s", diff)
+	}
+}
+
+func TestTcParserCollectCounters(t *testing.T) {
+	executer := &fakeExecuter{
+		output: []string{nftCountersContent, "", iptCountersContent},
+		err:    []error{nil, errors.New("iptables: No chain/target/match by that name."), nil},
+	}
+	logger := &fakeSyslog{}
+	p := &tcParser{
+		logger:   logger,
+		executer: executer,
+		options: &TcParserOptions{
+			NftCmdPath: "/sbin/nft",
+			CounterUsers: map[string]UserClass{
+				"nft:inet:filter:user1_up":   {UploadDirection, "user1"},
+				"ipt:mangle:ACCT:user1_down": {DownloadDirection, "user1"},
+				"ipt:filter:MISSING:user2":   {DownloadDirection, "user2"},
+			},
+		},
+	}
+	counters := p.collectCounters()
+	wantArgs := [][]string{
+		{"-j", "list", "counters"},
+		{"-w", "-t", "filter", "-nvxL", "MISSING"},
+		{"-w", "-t", "mangle", "-nvxL", "ACCT"},
+	}
+	if diff := pretty.Compare(wantArgs, executer.args); diff != "" {
+		t.Errorf("collectCounters => unexpected arguments, diff (-want, +got):\n%s", diff)
+	}
+	wantCommands := []string{"/sbin/nft", iptablesCmdPath, iptablesCmdPath}
+	if diff := pretty.Compare(wantCommands, executer.command); diff != "" {
+		t.Errorf("collectCounters => unexpected commands, diff (-want, +got):\n%s", diff)
+	}
+	if len(logger.err) != 1 {
+		t.Errorf("collectCounters => logged errors: %q, want one for the missing chain", logger.err)
+	}
+
+	p.addCounterUsers(counters)
+	want := map[UserClass]sample{
+		{UploadDirection, "user1"}:   {bytes: 3400, pkt: 12},
+		{DownloadDirection, "user1"}: {bytes: 60000, pkt: 40},
+	}
+	if diff := pretty.Compare(want, p.userTotals); diff != "" {
+		t.Errorf("addCounterUsers => unexpected user totals, diff (-want, +got):\n%s", diff)
+	}
+}
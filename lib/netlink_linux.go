@@ -0,0 +1,114 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+netlink_linux.go subscribes to the rtnetlink link notifications in order to learn about interfaces being added or removed.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+)
+
+// rtmgrpLink is the rtnetlink multicast group that receives the link notifications, see rtnetlink.h.
+const rtmgrpLink = 0x1
+
+// netlinkWatcher implements linkWatcher using a rtnetlink socket subscribed to the RTMGRP_LINK group.
+type netlinkWatcher struct {
+	// fd is the file descriptor of the netlink socket.
+	fd int
+
+	// buf is the buffer used to receive the netlink messages.
+	buf []byte
+}
+
+// subscribe opens the netlink socket and joins the link notification group.
+func (nw *netlinkWatcher) subscribe() error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return os.NewSyscallError("bind", err)
+	}
+	timeout := syscall.NsecToTimeval(linkReceiveTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return os.NewSyscallError("setsockopt", err)
+	}
+	nw.fd = fd
+	nw.buf = make([]byte, os.Getpagesize()*4)
+	return nil
+}
+
+// receive blocks until the kernel sends the next batch of link notifications or until linkReceiveTimeout.
+func (nw *netlinkWatcher) receive() ([]linkEvent, error) {
+	n, _, err := syscall.Recvfrom(nw.fd, nw.buf, 0)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, os.NewSyscallError("recvfrom", err)
+	}
+	return parseLinkMessages(nw.buf[:n])
+}
+
+// close closes the netlink socket.
+func (nw *netlinkWatcher) close() error {
+	return syscall.Close(nw.fd)
+}
+
+// parseLinkMessages converts RTM_NEWLINK and RTM_DELLINK netlink messages to linkEvents, any other messages are ignored.
+func parseLinkMessages(buf []byte) ([]linkEvent, error) {
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []linkEvent
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.Header.Type != syscall.RTM_NEWLINK && msg.Header.Type != syscall.RTM_DELLINK {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type != syscall.IFLA_IFNAME {
+				continue
+			}
+			events = append(events, linkEvent{
+				name:    string(bytes.TrimRight(attr.Value, "\x00")),
+				removed: msg.Header.Type == syscall.RTM_DELLINK,
+			})
+		}
+	}
+	return events, nil
+}
+
+// newLinkWatcher creates new linkWatcher.
+func newLinkWatcher() linkWatcher {
+	return &netlinkWatcher{}
+}
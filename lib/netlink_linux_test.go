@@ -0,0 +1,82 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"encoding/binary"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// linkMessage builds a raw netlink message of the given type carrying the IFLA_IFNAME attribute.
+func linkMessage(msgType uint16, name string) []byte {
+	attrLen := syscall.SizeofRtAttr + len(name) + 1
+	attrSpace := (attrLen + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+	msgLen := syscall.SizeofNlMsghdr + syscall.SizeofIfInfomsg + attrSpace
+
+	buf := make([]byte, msgLen)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(msgLen))
+	binary.LittleEndian.PutUint16(buf[4:6], msgType)
+	attr := buf[syscall.SizeofNlMsghdr+syscall.SizeofIfInfomsg:]
+	binary.LittleEndian.PutUint16(attr[0:2], uint16(attrLen))
+	binary.LittleEndian.PutUint16(attr[2:4], syscall.IFLA_IFNAME)
+	copy(attr[syscall.SizeofRtAttr:], name)
+	return buf
+}
+
+func TestParseLinkMessages(t *testing.T) {
+	testData := []struct {
+		desc    string
+		buf     []byte
+		want    []linkEvent
+		wantErr bool
+	}{
+		{
+			desc: "new link",
+			buf:  linkMessage(syscall.RTM_NEWLINK, "ppp0"),
+			want: []linkEvent{{name: "ppp0"}},
+		},
+		{
+			desc: "deleted link",
+			buf:  linkMessage(syscall.RTM_DELLINK, "wwan0"),
+			want: []linkEvent{{name: "wwan0", removed: true}},
+		},
+		{
+			desc: "multiple messages in one buffer, other types are ignored",
+			buf:  append(append(linkMessage(syscall.RTM_NEWLINK, "eth0"), linkMessage(syscall.RTM_NEWADDR, "eth1")...), linkMessage(syscall.RTM_DELLINK, "eth2")...),
+			want: []linkEvent{{name: "eth0"}, {name: "eth2", removed: true}},
+		},
+		{
+			desc:    "truncated message",
+			buf:     linkMessage(syscall.RTM_NEWLINK, "eth0")[:20],
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseLinkMessages(tc.buf)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseLinkMessages => unexpected err: %v, wantErr: %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseLinkMessages => got: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
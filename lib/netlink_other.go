@@ -0,0 +1,50 @@
+//go:build !linux
+
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+netlink_other.go is used on systems without rtnetlink, link notifications are not available there.
+*/
+
+package lib
+
+import (
+	"errors"
+)
+
+// unsupportedWatcher implements linkWatcher on systems without rtnetlink.
+type unsupportedWatcher struct {
+}
+
+// subscribe always fails, link notifications are only available on Linux.
+func (uw *unsupportedWatcher) subscribe() error {
+	return errors.New("link notifications are only supported on Linux")
+}
+
+// receive is never called since subscribe always fails.
+func (uw *unsupportedWatcher) receive() ([]linkEvent, error) {
+	return nil, errors.New("link notifications are only supported on Linux")
+}
+
+// close is never called since subscribe always fails.
+func (uw *unsupportedWatcher) close() error {
+	return nil
+}
+
+// newLinkWatcher creates new linkWatcher.
+func newLinkWatcher() linkWatcher {
+	return &unsupportedWatcher{}
+}
@@ -22,7 +22,6 @@ OIDs have to be sorter numerically even though they are stored as strings.
 package lib
 
 import (
-	"strconv"
 	"strings"
 )
 
@@ -43,33 +42,37 @@ func (o *oidSorter) Swap(i, j int) {
 
 // Less compares two oids.
 func (o *oidSorter) Less(i, j int) bool {
-	parts_first := strings.Split((*o.oids)[i], ".")
-	parts_second := strings.Split((*o.oids)[j], ".")
+	return oidLess((*o.oids)[i], (*o.oids)[j])
+}
 
-	var inverse bool
-	// Swap the parts if the first one is shorter. E.g. always compare the longer to the shorter.
-	if len(parts_first) < len(parts_second) {
-		parts_first, parts_second = parts_second, parts_first
-		inverse = true
+// oidLess determines whether the first oid comes before the second one in the numerical order.
+// The missing parts of the shorter oid are compared as zeros. The oids are compared without any allocations,
+// because this is called O(log n) times for every SNMP GET-NEXT.
+func oidLess(first, second string) bool {
+	var valueFirst, valueSecond int64
+	for first != "" || second != "" {
+		valueFirst, first = nextOIDPart(first)
+		valueSecond, second = nextOIDPart(second)
+		if valueFirst != valueSecond {
+			return valueFirst < valueSecond
+		}
 	}
+	return false
+}
 
-	var int_second int64
-	for x, value_first := range parts_first {
-		int_first, _ := strconv.ParseInt(value_first, 10, 32)
-		int_second = 0
-		if len(parts_second) > x {
-			value_second := parts_second[x]
-			int_second, _ = strconv.ParseInt(value_second, 10, 32)
-		}
-		if int_first == int_second {
-			continue
-		} else {
-			if inverse {
-				return int_first > int_second
-			} else {
-				return int_first < int_second
-			}
+// nextOIDPart returns the numerical value of the first part of the oid and the remaining parts.
+// Parts that aren't numbers, including the empty part before the leading dot, have the value of zero.
+func nextOIDPart(oid string) (int64, string) {
+	part, rest := oid, ""
+	if i := strings.IndexByte(oid, '.'); i >= 0 {
+		part, rest = oid[:i], oid[i+1:]
+	}
+	var value int64
+	for i := 0; i < len(part); i++ {
+		if part[i] < '0' || part[i] > '9' {
+			return 0, rest
 		}
+		value = value*10 + int64(part[i]-'0')
 	}
-	return false
+	return value, rest
 }
@@ -0,0 +1,238 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+once.go runs a single parse cycle and prints the parsed Qdiscs / Classes, users, interfaces and groups, e.g. for
+a quick sanity check of the configuration or in scripts. The formats are:
+json  - An object with the "classes", "users", "interfaces" and "groups" lists.
+table - A table aligned for reading in the terminal, one line per Qdisc / Class, user, interface or group.
+prom  - The text exposition format of Prometheus, the same as the Prometheus textfile.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// The formats of the output of CollectOnce.
+const (
+	// OnceJSON formats the output as JSON.
+	OnceJSON = "json"
+
+	// OnceTable formats the output as a table.
+	OnceTable = "table"
+
+	// OnceProm formats the output in the text exposition format of Prometheus.
+	OnceProm = "prom"
+)
+
+// onceData are the counters of a Qdisc / Class, user, interface or group in the JSON output.
+type onceData struct {
+	// Name is the tcName of the Qdisc / Class, or the name of the user, interface or group.
+	Name string `json:"name"`
+
+	// Direction is the direction of a user, "up" or "down".
+	Direction string `json:"direction,omitempty"`
+
+	// IfIndex is the kernel ifIndex of the interface of a Qdisc / Class, zero if it couldn't be resolved.
+	IfIndex int `json:"ifIndex,omitempty"`
+
+	// SentBytes is the number of sent bytes.
+	SentBytes int64 `json:"sentBytes"`
+
+	// SentPkt is the number of sent packets.
+	SentPkt int64 `json:"sentPkt"`
+
+	// DroppedPkt is the number of dropped packets.
+	DroppedPkt int64 `json:"droppedPkt"`
+
+	// OverLimitPkt is the number of over limit packets.
+	OverLimitPkt int64 `json:"overLimitPkt"`
+}
+
+// onceOutput is the JSON output.
+type onceOutput struct {
+	// Classes are the Qdiscs / Classes in the order of the TC output.
+	Classes []onceData `json:"classes"`
+
+	// Users are the users in the order of the configuration.
+	Users []onceData `json:"users"`
+
+	// Interfaces are the totals of the interfaces, empty unless ifaceTotals is set.
+	Interfaces []onceData `json:"interfaces"`
+
+	// Groups are the interface groups.
+	Groups []onceData `json:"groups"`
+}
+
+// onceSink implements dataSink, it keeps the data of the parse cycle for printing.
+type onceSink struct {
+	// promFileSink keeps the data of the parse cycle for the OnceProm format.
+	*promFileSink
+
+	// output is the data of the parse cycle.
+	output onceOutput
+
+	// failed indicates that the TC commands failed.
+	failed bool
+}
+
+// newOnceSink creates new onceSink, the lists of the JSON output are empty rather than null. The pseudonymizer replaces
+// the names of the users unless it is nil.
+func newOnceSink(pseudonymizer *pseudonymizer, logger sysLogger) *onceSink {
+	return &onceSink{
+		promFileSink: newPromFileSink(&PrometheusOptions{}, pseudonymizer, logger),
+		output: onceOutput{
+			Classes:    []onceData{},
+			Users:      []onceData{},
+			Interfaces: []onceData{},
+			Groups:     []onceData{},
+		},
+	}
+}
+
+// commit doesn't need to do anything, the data is printed once the parse cycle completes.
+func (o *onceSink) commit() {}
+
+// addData adds the counters of a Qdisc / Class or user.
+func (o *onceSink) addData(data *parsedData) {
+	o.promFileSink.addData(data)
+	if data.userClass == nil {
+		o.output.Classes = append(o.output.Classes, newOnceData(data))
+		return
+	}
+	user := newOnceData(data)
+	if o.pseudonymizer != nil {
+		user.Name = o.pseudonymizer.name(user.Name)
+	}
+	user.Direction = "up"
+	if data.userClass.Direction == DownloadDirection {
+		user.Direction = "down"
+	}
+	o.output.Users = append(o.output.Users, user)
+}
+
+// addGroupData adds the counters of an interface group.
+func (o *onceSink) addGroupData(data *parsedData) {
+	o.promFileSink.addGroupData(data)
+	o.output.Groups = append(o.output.Groups, newOnceData(data))
+}
+
+// addIfaceData adds the counters of an interface.
+func (o *onceSink) addIfaceData(data *parsedData) {
+	o.promFileSink.addIfaceData(data)
+	o.output.Interfaces = append(o.output.Interfaces, newOnceData(data))
+}
+
+// setHealth records whether the TC commands failed.
+func (o *onceSink) setHealth(failures int, backoff int) {
+	o.promFileSink.setHealth(failures, backoff)
+	o.failed = failures > 0
+}
+
+// write writes the data of the parse cycle in the format.
+func (o *onceSink) write(format string, w io.Writer) error {
+	switch format {
+	case OnceJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(o.output)
+	case OnceProm:
+		_, err := w.Write(o.promFileSink.content())
+		return err
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "KIND\tNAME\tDIRECTION\tSENT BYTES\tSENT PKT\tDROPPED PKT\tOVERLIMIT PKT")
+	kinds := []struct {
+		kind string
+		data []onceData
+	}{
+		{"class", o.output.Classes},
+		{"user", o.output.Users},
+		{"iface", o.output.Interfaces},
+		{"group", o.output.Groups},
+	}
+	for _, k := range kinds {
+		for _, data := range k.data {
+			direction := data.Direction
+			if direction == emptyString {
+				direction = "-"
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n", k.kind, data.Name, direction, data.SentBytes, data.SentPkt, data.DroppedPkt, data.OverLimitPkt)
+		}
+	}
+	return table.Flush()
+}
+
+// newOnceData returns the counters of the parsedData in the JSON output.
+func newOnceData(data *parsedData) onceData {
+	return onceData{
+		Name:         data.name,
+		IfIndex:      data.ifIndex,
+		SentBytes:    data.sentBytes,
+		SentPkt:      data.sentPkt,
+		DroppedPkt:   data.droppedPkt,
+		OverLimitPkt: data.overLimitPkt,
+	}
+}
+
+// CollectOnce runs a single parse cycle and writes the parsed data in the format, one of OnceJSON, OnceTable or
+// OnceProm. The blackout windows and the IdlePause don't apply. Returns an error if the options or the format are
+// invalid or if the TC commands failed.
+func CollectOnce(options *TcParserOptions, logger Logger, format string, w io.Writer) error {
+	switch format {
+	case OnceJSON, OnceTable, OnceProm:
+	default:
+		return fmt.Errorf("unknown output format '%s', want %s, %s or %s", format, OnceJSON, OnceTable, OnceProm)
+	}
+	if logger == nil {
+		return errors.New("a Logger is required")
+	}
+	once, err := onceOptions(options)
+	if err != nil {
+		return err
+	}
+	// The UserNames were validated by onceOptions.
+	pseudonymizer, _ := newPseudonymizer(once.UserNames, once.UserNameKey)
+	sink := newOnceSink(pseudonymizer, logger)
+	newTcParser(once, logger, sink).parseTc()
+	if sink.failed {
+		return errors.New("the TC commands failed, see the log")
+	}
+	return sink.write(format, w)
+}
+
+// onceOptions returns a copy of the options for a single parse cycle, without the blackout windows, the IdlePause, the
+// Prometheus textfile and the recording. Nil options use the defaults. Returns an error if the options are invalid.
+func onceOptions(options *TcParserOptions) (*TcParserOptions, error) {
+	if options == nil {
+		options = &TcParserOptions{}
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	once := *options
+	once.Blackouts = nil
+	once.IdlePause = 0
+	once.Prometheus = nil
+	once.RecordDir = emptyString
+	once.ReplayDir = emptyString
+	return &once, nil
+}
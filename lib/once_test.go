@@ -0,0 +1,200 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnceSinkWrite(t *testing.T) {
+	qdiscs := "qdisc htb 1: root refcnt 2 r2q 10 default 0\n Sent 300 bytes 3 pkt (dropped 1, overlimits 2 requeues 0)\n"
+	classes := "class htb 1:10 parent 1:1 prio 0 rate 1Mbit\n Sent 150 bytes 2 pkt (dropped 0, overlimits 0 requeues 0)\n"
+
+	testData := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: OnceJSON,
+			want: `{
+  "classes": [
+    {
+      "name": "eth0:1:0",
+      "ifIndex": 2,
+      "sentBytes": 300,
+      "sentPkt": 3,
+      "droppedPkt": 1,
+      "overLimitPkt": 2
+    },
+    {
+      "name": "eth0:1:10",
+      "ifIndex": 2,
+      "sentBytes": 150,
+      "sentPkt": 2,
+      "droppedPkt": 0,
+      "overLimitPkt": 0
+    }
+  ],
+  "users": [
+    {
+      "name": "john",
+      "direction": "down",
+      "sentBytes": 150,
+      "sentPkt": 2,
+      "droppedPkt": 0,
+      "overLimitPkt": 0
+    }
+  ],
+  "interfaces": [],
+  "groups": []
+}
+`,
+		},
+		{
+			format: OnceTable,
+			want: "KIND   NAME       DIRECTION  SENT BYTES  SENT PKT  DROPPED PKT  OVERLIMIT PKT\n" +
+				"class  eth0:1:0   -          300         3         1            2\n" +
+				"class  eth0:1:10  -          150         2         0            0\n" +
+				"user   john       down       150         2         0            0\n",
+		},
+		{
+			format: OnceProm,
+			want:   `tc_user_sent_bytes_total{user="john",direction="down"} 150`,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.format, func(t *testing.T) {
+			sink := newOnceSink(nil, &fakeSyslog{})
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				options: &TcParserOptions{
+					Ifaces:        []string{"eth0"},
+					MaxCommands:   1,
+					UserNameClass: map[string]UserClass{"eth0:1:10": {DownloadDirection, "john"}},
+				},
+				sink:          sink,
+				executer:      &fakeExecuter{output: []string{qdiscs, classes}, err: []error{nil, nil}},
+				ifaceReader:   &fakeIfaceReader{index: 2},
+				reQdiscHeader: regexp.MustCompile(reQdiscHeaderStr),
+				reClassHeader: regexp.MustCompile(reClassHeaderStr),
+				reStats:       regexp.MustCompile(reStatsStr),
+			}
+			p.parseTc()
+			var got bytes.Buffer
+			if err := sink.write(tc.format, &got); err != nil {
+				t.Fatalf("write => unexpected error: %s", err)
+			}
+			if tc.format == OnceProm {
+				if !strings.Contains(got.String(), tc.want) {
+					t.Errorf("write => got:\n%s\nwant it to contain: %s", got.String(), tc.want)
+				}
+				return
+			}
+			if got.String() != tc.want {
+				t.Errorf("write => got:\n%s\nwant:\n%s", got.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestOnceSinkUserNames(t *testing.T) {
+	sink := newOnceSink(&pseudonymizer{}, &fakeSyslog{})
+	sink.begin()
+	sink.erase()
+	sink.addData(&parsedData{name: "john", sentBytes: 150, userClass: &UserClass{DownloadDirection, "john"}})
+	sink.commit()
+
+	for _, format := range []string{OnceJSON, OnceTable, OnceProm} {
+		var got bytes.Buffer
+		if err := sink.write(format, &got); err != nil {
+			t.Fatalf("write of %s => unexpected error: %s", format, err)
+		}
+		if strings.Contains(got.String(), "john") || !strings.Contains(got.String(), "96d9632f363564cc") {
+			t.Errorf("write of %s => got:\n%s\nwant the pseudonym 96d9632f363564cc instead of john", format, got.String())
+		}
+	}
+}
+
+func TestOnceSinkFailed(t *testing.T) {
+	sink := newOnceSink(nil, &fakeSyslog{})
+	p := &tcParser{
+		logger:      &fakeSyslog{},
+		options:     &TcParserOptions{Ifaces: []string{"eth0"}, MaxCommands: 1},
+		sink:        sink,
+		executer:    &fakeExecuter{output: []string{""}, err: []error{errors.New("tc failed")}},
+		ifaceReader: &fakeIfaceReader{},
+	}
+	p.parseTc()
+	if !sink.failed {
+		t.Errorf("parseTc after a TC failure => got failed: false, want: true")
+	}
+}
+
+func TestCollectOnceErrors(t *testing.T) {
+	testData := []struct {
+		desc    string
+		options *TcParserOptions
+		logger  Logger
+		format  string
+		wantErr string
+	}{
+		{
+			desc:    "unknown format",
+			logger:  &fakeSyslog{},
+			format:  "xml",
+			wantErr: "unknown output format 'xml', want json, table or prom",
+		},
+		{
+			desc:    "no logger",
+			format:  OnceJSON,
+			wantErr: "a Logger is required",
+		},
+		{
+			desc:    "invalid options",
+			options: &TcParserOptions{ParseInterval: -time.Second},
+			logger:  &fakeSyslog{},
+			format:  OnceTable,
+			wantErr: "the parse interval must not be negative, got -1s",
+		},
+		{
+			desc:    "TC fails",
+			options: &TcParserOptions{TcCmdPath: "/nonexistent/tc", Ifaces: []string{"eth0"}},
+			logger:  &fakeSyslog{},
+			format:  OnceProm,
+			wantErr: "the TC commands failed, see the log",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			var output bytes.Buffer
+			err := CollectOnce(tc.options, tc.logger, tc.format, &output)
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("CollectOnce => got error: %v, want: %s", err, tc.wantErr)
+			}
+			if output.Len() != 0 {
+				t.Errorf("CollectOnce => got output %q, want none", output.String())
+			}
+		})
+	}
+}
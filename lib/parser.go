@@ -20,12 +20,20 @@ parser.go contains structs and methods used to execute the TC command and parse
 package lib
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log/syslog"
+	"io"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,13 +46,55 @@ const (
 	newLine = "\n"
 
 	// reQdiscHeaderStr is string version of the RE to match header of a Qdisc in Qdisc statistics.
-	reQdiscHeaderStr = "qdisc (?P<qdiscName>[a-zA-Z_]+) (?P<qdiscHandle>[0-9a-f]+):.*"
+	reQdiscHeaderStr = "qdisc (?P<qdiscName>[a-zA-Z0-9_-]+) (?P<qdiscHandle>[0-9a-f]+):.*"
 
 	// reClassHeaderStr is string version of the RE to match header of a Class in Class statistics.
-	reClassHeaderStr = "class (?P<className>[a-zA-Z_]+) (?P<qdiscHandle>[0-9a-f]+):(?P<classHandle>[0-9a-f]+).*"
+	reClassHeaderStr = "class (?P<className>[a-zA-Z0-9_-]+) (?P<qdiscHandle>[0-9a-f]+):(?P<classHandle>[0-9a-f]+).*"
+
+	// qdiscPrefix is the prefix of every Qdisc header line in Qdisc statistics.
+	qdiscPrefix = "qdisc "
+
+	// classPrefix is the prefix of every Class header line in Class statistics.
+	classPrefix = "class "
+
+	// autoIfaces is the value of ifaces that enables discovery of interfaces with non-default Qdiscs.
+	autoIfaces = "auto"
+
+	// rePeerAddrStr is string version of the RE to match a point-to-point peer address in 'ip -o addr show' output.
+	rePeerAddrStr = "^[0-9]+: (?P<iface>[^ \t:]+)[ \t]+inet6? [^ ]+ peer (?P<peer>[^/ ]+)"
+
+	// rootKeyword marks a root Qdisc in the header line of the TC output.
+	rootKeyword = " root "
+
+	// reDevStr is string version of the RE to match the interface in the header line of a Qdisc or Class.
+	reDevStr = " dev (?P<iface>[^ ]+)"
+
+	// devKeyword is the last of the configured TC arguments, it is followed by the name of the interface.
+	devKeyword = "dev"
+
+	// reParentStr is string version of the RE to match the parent Class in the header line of a Class.
+	reParentStr = " parent (?P<qdiscHandle>[0-9a-f]+):(?P<classHandle>[0-9a-f]+)"
+
+	// ifaceTotalsRoot is the value of ifaceTotals that sums the root Qdiscs of each interface.
+	ifaceTotalsRoot = "root"
+
+	// ifaceTotalsLeaves is the value of ifaceTotals that sums the leaf Classes of each interface.
+	ifaceTotalsLeaves = "leaves"
+
+	// peerPrefix marks the interface part of a tcName in the user definitions as a peer address, e.g. "@10.0.0.5:1:10".
+	peerPrefix = "@"
+
+	// sentKeyword starts the statistics line of a Qdisc / Class in the TC output.
+	sentKeyword = "Sent "
+
+	// duplicateSeparator separates the tcName from the sequence number that disambiguates a duplicate tcName, e.g. "eth0:0:0#2".
+	duplicateSeparator = "#"
 
 	// reStatsStr is string version of the RE to match the Qdisc and Class statisticsin TC output.
 	reStatsStr = " Sent (?P<sentBytes>[0-9]+) bytes (?P<sentPkt>[0-9]+) pkt .dropped (?P<droppedPkt>[0-9]+), overlimits (?P<overLimitPkt>[0-9]+) requeues"
+
+	// maxStderr is the maximum number of bytes of the standard error of a failed command included in its error.
+	maxStderr = 1024
 )
 
 // These variables are the default options used by tcParser.
@@ -52,17 +102,44 @@ var (
 	// tcCmdPath is the default path to the TC binary.
 	tcCmdPath = "/sbin/tc"
 
-	// parseInterval is the period in seconds how often we run TC and parse its output which is then stored in SNMP for retrieval.
-	parseInterval = 5
+	// parseInterval is the period how often we run TC and parse its output which is then stored in SNMP for retrieval.
+	parseInterval = 5 * time.Second
+
+	// minParseInterval is the shortest supported parseInterval.
+	minParseInterval = 100 * time.Millisecond
 
 	// tcQdiscStats are the default arguments that should be passed to TC in order to get Qdisc statistics.
 	tcQdiscStats = []string{"-s", "qdisc", "show", "dev"}
 
+	// ipCmdPath is the default path to the iproute2 ip binary.
+	ipCmdPath = "/sbin/ip"
+
 	// tcClassStats are the default arguments that should be passed to TC in order to get Class statistics.
 	tcClassStats = []string{"-s", "class", "show", "dev"}
 
+	// tcFilterShow are the default arguments that should be passed to TC in order to get the filters.
+	tcFilterShow = []string{"filter", "show", "dev"}
+
 	// ifaces is the default slice of interface names that should be monitored.
 	ifaces = []string{"eth0"}
+
+	// discoveryInterval is the period in seconds how often we rediscover the interfaces when ifaces is set to autoIfaces.
+	discoveryInterval = 60
+
+	// userNameClass is the default empty map of user definitions, it is shared so it must not be modified.
+	userNameClass = map[string]UserClass{}
+
+	// maxParallel is the default maximum number of interfaces on which the TC commands are executed concurrently.
+	maxParallel = 4
+
+	// maxCommands is the default maximum number of TC commands that run concurrently.
+	maxCommands = 8
+
+	// commandTimeout is the default number of seconds after which a running command is killed.
+	commandTimeout = 10
+
+	// maxBackoff is the default maximum number of seconds between the collection attempts after repeated TC failures.
+	maxBackoff = 300
 )
 
 // sysLogger is an interface to Syslog.
@@ -73,21 +150,95 @@ type sysLogger interface {
 
 // commandExecuter is an interface that executes system commands.
 type commandExecuter interface {
-	Execute(name string, arg ...string) (string, error)
+	Execute(ctx context.Context, name string, arg ...string) (io.ReadCloser, error)
 }
 
 // systemCommand implements commandExecuter.
 type systemCommand struct {
 }
 
-// Execute runs a system command and returns its standard output.
-func (sc *systemCommand) Execute(name string, arg ...string) (string, error) {
-	cmdOutput, err := exec.Command(name, arg...).Output()
+// Execute starts a system command and returns a reader of its standard output, which is piped from the command while
+// it is read. Closing the reader waits for the command and returns an error if the command failed, with its standard
+// error, or timed out. The command is killed if the context is done before it finishes, or if the reader is closed
+// before the whole output is read.
+func (sc *systemCommand) Execute(ctx context.Context, name string, arg ...string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, name, arg...)
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return emptyString, err
+		return nil, err
+	}
+	output := &commandOutput{ctx: ctx, cmd: cmd, stdout: stdout, stderr: &limitedBuffer{limit: maxStderr}}
+	cmd.Stderr = output.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// commandOutput is the standard output of a command started by systemCommand.
+type commandOutput struct {
+	// ctx is the context the command was started with.
+	ctx context.Context
+
+	// cmd is the running command.
+	cmd *exec.Cmd
+
+	// stdout is the pipe of the standard output of the command.
+	stdout io.Reader
+
+	// stderr holds the beginning of the standard error of the command.
+	stderr *limitedBuffer
+
+	// eof is set once the whole output was read.
+	eof bool
+}
+
+// Read reads the standard output of the command.
+func (o *commandOutput) Read(p []byte) (int, error) {
+	n, err := o.stdout.Read(p)
+	if err == io.EOF {
+		o.eof = true
+	}
+	return n, err
+}
+
+// Close waits for the command and returns its error. The command is killed if its output wasn't read to the end, its
+// exit status is ignored then.
+func (o *commandOutput) Close() error {
+	if !o.eof {
+		o.cmd.Process.Kill()
+	}
+	err := o.cmd.Wait()
+	if o.ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command %s timed out: %v", strings.Join(o.cmd.Args, " "), o.ctx.Err())
+	}
+	if err == nil || !o.eof {
+		return nil
+	}
+	if stderr := strings.TrimSpace(o.stderr.String()); stderr != emptyString {
+		return fmt.Errorf("%v: %s", err, stderr)
+	}
+	return err
+}
+
+// limitedBuffer is a buffer that keeps only the first limit bytes written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+
+	// limit is the maximum number of the bytes kept.
+	limit int
+}
+
+// Write keeps as much of the bytes as fits the limit, the rest is discarded without an error.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
 	}
-	outputString := string(cmdOutput)
-	return outputString, nil
+	return len(p), nil
 }
 
 // TcParserOptions holds the configurable options for the tcParser.
@@ -95,8 +246,25 @@ type TcParserOptions struct {
 	// TcCmdPath is the path to the TC binary.
 	TcCmdPath string
 
-	// ParseInterval is the number of seconds during which we consider data fresh and do not rerun TC command.
-	ParseInterval int
+	// ParseInterval is the period during which we consider data fresh and do not rerun TC command, at least
+	// minParseInterval.
+	ParseInterval time.Duration
+
+	// AlignCycles determines whether the periodic parse cycles start at the multiples of the ParseInterval on the UTC
+	// wall clock, e.g. at :00, :05, :10 seconds for the ParseInterval of 5 seconds.
+	AlignCycles bool
+
+	// ParseJitter is the upper bound of the random delay of every periodic parse cycle, it must be shorter than the
+	// ParseInterval. The parse cycles aren't delayed if this isn't set.
+	ParseJitter time.Duration
+
+	// IpCmdPath is the path to the iproute2 ip binary, used to resolve peer addresses of point-to-point interfaces and
+	// to collect the counters of the interfaces if LinkStats is set.
+	IpCmdPath string
+
+	// ExecWrapper is the command prefix the TC and ip commands are executed with, e.g. "sudo", "-n" or "ip", "netns",
+	// "exec", "blue". The interfaces are listed by the ip command through it too, see wrapper.go.
+	ExecWrapper []string
 
 	// TcQdiscStats are the arguments that should be passed to TC in order to get Qdisc statistics.
 	TcQdiscStats []string
@@ -104,11 +272,131 @@ type TcParserOptions struct {
 	// TcClassStats are the arguments that should be passed to TC in order to get Class statistics.
 	TcClassStats []string
 
-	// Ifaces is a slice of interface names that should be monitored.
+	// TcFilterShow are the arguments that should be passed to TC in order to get the filters, see filter.go.
+	TcFilterShow []string
+
+	// Ifaces is a slice of interface names that should be monitored. A single entry of autoIfaces enables discovery of the interfaces.
 	Ifaces []string
 
-	// UserNameClass is a map of the tcNames (see parseData()) to userClass definitions.
-	UserNameClass map[string]userClass
+	// DiscoveryInterval is the number of seconds after which we rediscover the interfaces when Ifaces is set to autoIfaces.
+	DiscoveryInterval int
+
+	// UserNameClass is a map of the tcNames (see parseData()) to UserClass definitions.
+	UserNameClass map[string]UserClass
+
+	// AddrUsers is a map of IP addresses in their canonical form (see net.IP.String()) to user names. The Classes that the
+	// TC filters direct the traffic of the addresses to are resolved in every parse cycle and counted for the users.
+	AddrUsers map[string]string
+
+	// CounterUsers is a map of the references to netfilter counters (see netfilter.go) to UserClass definitions. The
+	// counters are summed with the Qdiscs / Classes of the users.
+	CounterUsers map[string]UserClass
+
+	// NftCmdPath is the path to the nft binary, used to read the nftables counters of the CounterUsers.
+	NftCmdPath string
+
+	// IptablesCmdPath is the path to the iptables binary, used to read the iptables counters of the CounterUsers.
+	IptablesCmdPath string
+
+	// ConntrackUsers is a map of the IP address ranges to user names. The counters of the flows tracked by conntrack
+	// are summed for the users and exported next to the counters of their Qdiscs / Classes, see conntrack.go.
+	ConntrackUsers map[string]string
+
+	// ConntrackCmdPath is the path to the conntrack binary, used to list the flows of the ConntrackUsers.
+	ConntrackCmdPath string
+
+	// MaxParallel is the maximum number of interfaces on which the TC commands are executed concurrently.
+	MaxParallel int
+
+	// MaxCommands is the maximum number of TC commands that run concurrently across all interfaces.
+	// If set to 1, the Qdisc and Class commands of an interface run one after the other.
+	MaxCommands int
+
+	// CommandTimeout is the number of seconds after which a running TC or ip command is killed, so that a hung
+	// command can't stall the parse cycles.
+	CommandTimeout int
+
+	// QdiscPattern is the RE matching the header line of a Qdisc, it must have the qdiscHandle named group.
+	// The reQdiscHeaderStr is used if this isn't set.
+	QdiscPattern string
+
+	// ClassPattern is the RE matching the header line of a Class, it must have the qdiscHandle and classHandle
+	// named groups. The reClassHeaderStr is used if this isn't set.
+	ClassPattern string
+
+	// StatsPattern is the RE matching the statistics of a Qdisc or Class, it must have the sentBytes, sentPkt,
+	// droppedPkt and overLimitPkt named groups. The reStatsStr is used if this isn't set.
+	StatsPattern string
+
+	// SingleInvocation determines whether the Qdisc and Class statistics of all the interfaces are shown by a single
+	// TC command each, the output is split by the interface names in the header lines. The TC commands are executed
+	// for each interface if this isn't set.
+	SingleInvocation bool
+
+	// MaxBackoff is the maximum number of seconds between the collection attempts when TC fails repeatedly.
+	// The period between the attempts doubles after every failure, starting at ParseInterval.
+	MaxBackoff int
+
+	// IdlePause is the number of minutes without SNMP requests after which the TC commands stop being executed. The
+	// collection resumes on the next request. The collection isn't paused if this isn't set.
+	IdlePause int
+
+	// RecordDir is the directory where the TC outputs of every parse cycle are saved, see record.go. The TC outputs
+	// aren't recorded if this isn't set.
+	RecordDir string
+
+	// ReplayDir is the directory with the recorded TC outputs that are parsed instead of executing the TC commands. The
+	// recorded parse cycles are replayed once after Start, the data of the last one is served afterwards.
+	ReplayDir string
+
+	// ReplayRealtime determines whether the recorded parse cycles are replayed with the pauses between them when they
+	// were recorded. They are replayed as fast as possible if this isn't set.
+	ReplayRealtime bool
+
+	// Groups is a map of group names to the names of the member interfaces. The counters of the root Qdiscs
+	// on the member interfaces are summed and exported under a single group index.
+	Groups map[string][]string
+
+	// IfaceTotals determines how the counters of each interface are summed, ifaceTotalsRoot sums the root Qdiscs and
+	// ifaceTotalsLeaves sums the Classes without child Classes. The interface totals aren't exported if this isn't set.
+	IfaceTotals string
+
+	// Xstats determines whether the extended statistics printed after the standard statistics are parsed, see xstats.go.
+	Xstats bool
+
+	// NetDev determines whether the kernel counters of the monitored interfaces are collected from /proc/net/dev in
+	// every parse cycle, see netdev.go.
+	NetDev bool
+
+	// LinkStats determines whether the kernel counters of the monitored interfaces are collected by the ip command
+	// instead of from /proc/net/dev, adding the missed packets and the carrier transitions. It implies NetDev.
+	LinkStats bool
+
+	// Blackouts are the daily maintenance windows during which the collection is paused, e.g. for nightly shaper reloads.
+	Blackouts []blackoutWindow
+
+	// Prometheus configures writing the counters to a Prometheus textfile after each parse cycle, nil if it isn't
+	// written. The file is written by a dataSink next to the SNMP handler.
+	Prometheus *PrometheusOptions
+
+	// Agent configures posting the parsed data of each parse cycle to an aggregator, nil if it isn't posted. The data
+	// is posted by a dataSink next to the SNMP handler, see agent.go.
+	Agent *AgentOptions
+
+	// UserNames selects how the names of the users are written to the Prometheus textfile, the reports of the Agent
+	// and the output of CollectOnce, one of userNamesPlain, userNamesHash or userNamesPseudonym, see privacy.go.
+	// Defaults to userNamesPlain.
+	UserNames string
+
+	// UserNameKey is the key of the pseudonyms of the user names if UserNames is userNamesPseudonym.
+	UserNameKey string
+
+	// Aggregator configures the server receiving the parsed data of the agents, nil if it isn't started. The data of
+	// the agents is merged into every parse cycle.
+	Aggregator *AggregatorOptions
+
+	// EncodeIfaceNames determines whether the interface names in the tcNames are escaped using encodeIfaceName().
+	EncodeIfaceNames bool
 
 	// Debug determines whether we perform extensive logging to Syslog.
 	Debug bool
@@ -123,13 +411,36 @@ func (o *TcParserOptions) tcCmdPath() string {
 }
 
 // parseInterval returns the configured parseInterval, or the default one if it wasn't set.
-func (o *TcParserOptions) parseInterval() int {
+func (o *TcParserOptions) parseInterval() time.Duration {
 	if o != nil && o.ParseInterval != 0 {
 		return o.ParseInterval
 	}
 	return parseInterval
 }
 
+// parseIntervalSeconds returns the parseInterval rounded up to whole seconds, for the outputs that count in seconds.
+func (o *TcParserOptions) parseIntervalSeconds() int {
+	return ceilSeconds(o.parseInterval())
+}
+
+// monotonicFallback returns the time since the start of tc_reader by the monotonic clock of Go.
+func monotonicFallback() time.Duration {
+	return time.Since(processStart)
+}
+
+// ceilSeconds returns the duration rounded up to whole seconds.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// ipCmdPath returns the configured ipCmdPath, or the default one if it wasn't set.
+func (o *TcParserOptions) ipCmdPath() string {
+	if o != nil && o.IpCmdPath != "" {
+		return o.IpCmdPath
+	}
+	return ipCmdPath
+}
+
 // tcQdiscStats returns the configured tcQdiscStats, or the default one if it wasn't set.
 func (o *TcParserOptions) tcQdiscStats() []string {
 	if o != nil && o.TcQdiscStats != nil {
@@ -146,6 +457,22 @@ func (o *TcParserOptions) tcClassStats() []string {
 	return tcClassStats
 }
 
+// tcFilterShow returns the configured tcFilterShow, or the default one if it wasn't set.
+func (o *TcParserOptions) tcFilterShow() []string {
+	if o != nil && o.TcFilterShow != nil {
+		return o.TcFilterShow
+	}
+	return tcFilterShow
+}
+
+// addrUsers returns the configured addrUsers, nil if there are none.
+func (o *TcParserOptions) addrUsers() map[string]string {
+	if o != nil {
+		return o.AddrUsers
+	}
+	return nil
+}
+
 // ifaces returns the configured ifaces, or the default one if it wasn't set.
 func (o *TcParserOptions) ifaces() []string {
 	if o != nil && o.Ifaces != nil {
@@ -154,15 +481,144 @@ func (o *TcParserOptions) ifaces() []string {
 	return ifaces
 }
 
+// discoveryInterval returns the configured discoveryInterval, or the default one if it wasn't set.
+func (o *TcParserOptions) discoveryInterval() int {
+	if o != nil && o.DiscoveryInterval != 0 {
+		return o.DiscoveryInterval
+	}
+	return discoveryInterval
+}
+
 // userNameClass returns the configured userNameClass, or the default one if it wasn't set.
-func (o *TcParserOptions) userNameClass() map[string]userClass {
+func (o *TcParserOptions) userNameClass() map[string]UserClass {
 	if o != nil && o.UserNameClass != nil {
 		return o.UserNameClass
 	}
-	userNameClass := make(map[string]userClass)
 	return userNameClass
 }
 
+// maxParallel returns the configured maxParallel, or the default one if it wasn't set.
+func (o *TcParserOptions) maxParallel() int {
+	if o != nil && o.MaxParallel > 0 {
+		return o.MaxParallel
+	}
+	return maxParallel
+}
+
+// maxCommands returns the configured maxCommands, or the default one if it wasn't set.
+func (o *TcParserOptions) maxCommands() int {
+	if o != nil && o.MaxCommands > 0 {
+		return o.MaxCommands
+	}
+	return maxCommands
+}
+
+// commandTimeout returns the configured commandTimeout, or the default one if it wasn't set.
+func (o *TcParserOptions) commandTimeout() time.Duration {
+	if o != nil && o.CommandTimeout > 0 {
+		return time.Duration(o.CommandTimeout) * time.Second
+	}
+	return time.Duration(commandTimeout) * time.Second
+}
+
+// maxBackoff returns the configured maxBackoff, or the default one if it wasn't set.
+func (o *TcParserOptions) maxBackoff() int {
+	if o != nil && o.MaxBackoff > 0 {
+		return o.MaxBackoff
+	}
+	return maxBackoff
+}
+
+// qdiscPattern returns the configured QdiscPattern, or the default reQdiscHeaderStr if not set.
+func (o *TcParserOptions) qdiscPattern() string {
+	if o != nil && o.QdiscPattern != emptyString {
+		return o.QdiscPattern
+	}
+	return reQdiscHeaderStr
+}
+
+// classPattern returns the configured ClassPattern, or the default reClassHeaderStr if not set.
+func (o *TcParserOptions) classPattern() string {
+	if o != nil && o.ClassPattern != emptyString {
+		return o.ClassPattern
+	}
+	return reClassHeaderStr
+}
+
+// statsPattern returns the configured StatsPattern, or the default reStatsStr if not set.
+func (o *TcParserOptions) statsPattern() string {
+	if o != nil && o.StatsPattern != emptyString {
+		return o.StatsPattern
+	}
+	return reStatsStr
+}
+
+// idlePause returns the configured IdlePause, zero if the collection isn't paused.
+func (o *TcParserOptions) idlePause() time.Duration {
+	if o != nil && o.IdlePause > 0 {
+		return time.Duration(o.IdlePause) * time.Minute
+	}
+	return 0
+}
+
+// ifaceTotals returns the configured ifaceTotals, empty if the interface totals aren't exported.
+func (o *TcParserOptions) ifaceTotals() string {
+	if o != nil {
+		return o.IfaceTotals
+	}
+	return emptyString
+}
+
+// groups returns the configured groups, or the default one if it wasn't set.
+func (o *TcParserOptions) groups() map[string][]string {
+	if o != nil && o.Groups != nil {
+		return o.Groups
+	}
+	groups := make(map[string][]string)
+	return groups
+}
+
+// reParent is the compiled version of reParentStr.
+var reParent = regexp.MustCompile(reParentStr)
+
+// reDev is the compiled version of reDevStr.
+var reDev = regexp.MustCompile(reDevStr)
+
+// These are the named groups that the patterns matching the TC output must have.
+var (
+	// qdiscHeaderGroups are the named groups of the QdiscPattern.
+	qdiscHeaderGroups = []string{"qdiscHandle"}
+
+	// classHeaderGroups are the named groups of the ClassPattern.
+	classHeaderGroups = []string{"qdiscHandle", "classHandle"}
+
+	// statsGroups are the named groups of the StatsPattern.
+	statsGroups = []string{"sentBytes", "sentPkt", "droppedPkt", "overLimitPkt"}
+)
+
+// unmatchedLine returns true if the line of the TC output that matched no pattern should have matched one, i.e. it
+// isn't indented or it holds the statistics. The other indented lines describe the last Qdisc / Class.
+func unmatchedLine(line string) bool {
+	if line == emptyString {
+		return false
+	}
+	return (line[0] != ' ' && line[0] != '\t') || strings.HasPrefix(strings.TrimSpace(line), sentKeyword)
+}
+
+// validPattern returns an error if the pattern isn't a valid RE or if it doesn't have all the named groups.
+func validPattern(pattern string, groups []string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if re.SubexpIndex(group) < 0 {
+			return fmt.Errorf("the pattern '%s' has no group named %s", pattern, group)
+		}
+	}
+	return nil
+}
+
 // tcParser reads qdisc and class stats from TC command output and provides them to SNMPD.
 type tcParser struct {
 	// logger is the Writer used to log messages to Syslog.
@@ -180,68 +636,1134 @@ type tcParser struct {
 	// reStats is the compiled version of reStatsStr.
 	reStats *regexp.Regexp
 
-	// snmp is the SNMP handler that will store our parsed data and deliver them to the SNMP daemon.
-	snmp snmpHandler
+	// sink receives the parsed data, the SNMP handler and the additional configured dataSinks.
+	sink dataSink
 
 	// executer is interface that runs system commands.
 	executer commandExecuter
+
+	// ifaceReader reads information about the network interfaces, e.g. the kernel ifIndex.
+	ifaceReader ifaceReader
+
+	// netDevReader returns the content of /proc/net/dev, readNetDev if this isn't set.
+	netDevReader func() (io.Reader, error)
+
+	// discoveredIfaces are the interfaces with non-default Qdiscs found by the last discovery when ifaces is set to autoIfaces.
+	discoveredIfaces []string
+
+	// lastDiscovery is the time when the interfaces were last discovered.
+	lastDiscovery time.Time
+
+	// linkWatcher receives notifications about interfaces being added or removed.
+	linkWatcher linkWatcher
+
+	// ifacesLock protects presentIfaces and ifacesChanged which are updated from the link notifications.
+	ifacesLock sync.Mutex
+
+	// presentIfaces are the interfaces currently present in the system, nil if link notifications aren't available.
+	presentIfaces map[string]bool
+
+	// ifacesChanged indicates that an interface appeared or disappeared since the last discovery.
+	ifacesChanged bool
+
+	// missingIfaces are the monitored interfaces that weren't present during the last parse cycle.
+	missingIfaces map[string]bool
+
+	// rePeerAddr is the compiled version of rePeerAddrStr.
+	rePeerAddr *regexp.Regexp
+
+	// userNameClass is the userNameClass with peer addresses resolved to interfaces for the current parse cycle.
+	userNameClass map[string]UserClass
+
+	// peerUsers indicates that some users are defined by peer addresses, userNameClass is resolved again in every
+	// parse cycle if set. Otherwise it is resolved only once.
+	peerUsers bool
+
+	// inBlackout indicates that the last parse cycle was skipped because of a blackout window.
+	inBlackout bool
+
+	// unmatchedLines is the number of lines of the TC output in the current parse cycle that matched neither the header
+	// nor the statistics pattern.
+	unmatchedLines int
+
+	// replayed are the recorded TC outputs parsed by the next parse cycle instead of executing the TC commands, nil
+	// if the TC commands are executed. It is protected by parseMu.
+	replayed []ifaceOutput
+
+	// lastRequest is the time in Unix nanoseconds of the last SNMP request, or of the start when there wasn't any.
+	// Zero if the requests aren't tracked.
+	lastRequest atomic.Int64
+
+	// idle indicates that the parse cycles are skipped because there were no SNMP requests for IdlePause.
+	idle atomic.Bool
+
+	// resume wakes up the periodic parse cycles on an SNMP request after the collection was paused.
+	resume chan struct{}
+
+	// parseMu serializes the parse cycles started periodically and on demand, it protects the fields below.
+	parseMu sync.Mutex
+
+	// lastParse is the time when the TC commands were last executed.
+	lastParse time.Time
+
+	// cycleMonotonic is the monotonic timestamp of the last parse cycle, see monotonicNow. The parsedData of the cycle
+	// are stamped with it.
+	cycleMonotonic time.Duration
+
+	// failures is the number of consecutive parse cycles in which the TC commands failed.
+	failures int
+
+	// skipCycles is the number of parse cycles that are skipped before the next attempt while backing off.
+	skipCycles int
+
+	// slotsOnce initializes commandSlots.
+	slotsOnce sync.Once
+
+	// commandSlots limits the number of TC commands that run concurrently to maxCommands.
+	commandSlots chan struct{}
+
+	// ifaceTotals are the summed counters of the root Qdiscs on each interface in the current parse cycle.
+	ifaceTotals map[string]sample
+
+	// leafTotals are the summed counters of the leaf Classes on each interface in the current parse cycle, nil unless
+	// the interface totals sum the leaf Classes.
+	leafTotals map[string]sample
+
+	// tcNames counts how many times each tcName was seen in the current parse cycle.
+	tcNames map[string]int
+
+	// userTotals are the summed counters of the Qdiscs / Classes of each user and direction in the current parse cycle.
+	userTotals map[UserClass]sample
+
+	// userOrder are the users and directions in userTotals in the order in which their first Qdisc / Class was parsed.
+	userOrder []UserClass
+
+	// conntrackFlows are the counters of the original and the reply direction of each flow listed by conntrack in the
+	// previous parse cycle, keyed by the protocol and the original tuple.
+	conntrackFlows map[string][2]sample
+
+	// conntrackTotals are the summed increases of the conntrack counters of each user and direction since start.
+	conntrackTotals map[UserClass]sample
+
+	// lifecycleMu protects ctx and cancel.
+	lifecycleMu sync.Mutex
+
+	// ctx is done once the tcParser is stopped, the TC commands are canceled then. Nil until the tcParser is started.
+	ctx context.Context
+
+	// cancel stops the goroutines started by Start, nil until the tcParser is started.
+	cancel context.CancelFunc
+
+	// running waits for the goroutines started by Start.
+	running sync.WaitGroup
+
+	// ready is closed once the first parse cycle started by Start completes.
+	ready chan struct{}
+
+	// aggregator receives the parsed data of the agents, nil if the aggregator isn't started.
+	aggregator *aggregator
+
+	// debug turns the debug logging on or off at runtime.
+	debug debugSwitch
+}
+
+// parserStatus is the state of the tcParser.
+type parserStatus struct {
+	// lastParse is the time when the TC commands were last executed, zero if they weren't executed yet.
+	lastParse time.Time
+
+	// failures is the number of consecutive parse cycles in which the TC commands failed.
+	failures int
+
+	// backingOff indicates that parse cycles are skipped after TC failures.
+	backingOff bool
+
+	// inBlackout indicates that the collection is paused during a blackout window.
+	inBlackout bool
+
+	// idle indicates that the collection is paused because there were no SNMP requests.
+	idle bool
+}
+
+// TcParserOption configures the tcParser created by NewTcParser.
+type TcParserOption func(*tcParserSetup) error
+
+// tcParserSetup collects the settings of the TcParserOptions before the tcParser is created.
+type tcParserSetup struct {
+	// options are the configurable options of the tcParser.
+	options *TcParserOptions
+
+	// sinks receive the parsed data.
+	sinks []dataSink
+
+	// snmp is the SNMP handler that receives the parsed data, nil if the data isn't served over SNMP.
+	snmp *snmp
+}
+
+// WithTcParserOptions sets the configurable options of the tcParser, the defaults are used without it.
+func WithTcParserOptions(options *TcParserOptions) TcParserOption {
+	return func(s *tcParserSetup) error {
+		if options == nil {
+			return errors.New("the TcParserOptions must not be nil")
+		}
+		s.options = options
+		return nil
+	}
+}
+
+// WithSnmp adds the parsed data to the SNMP handler, which in turn refreshes stale data through the tcParser.
+func WithSnmp(snmp *snmp) TcParserOption {
+	return func(s *tcParserSetup) error {
+		if snmp == nil {
+			return errors.New("the SNMP handler must not be nil")
+		}
+		s.snmp = snmp
+		s.sinks = append(s.sinks, snmp)
+		return nil
+	}
+}
+
+// WithSink passes the parsed data of every parse cycle to the Sink.
+func WithSink(sink Sink) TcParserOption {
+	return func(s *tcParserSetup) error {
+		if sink == nil {
+			return errors.New("the Sink must not be nil")
+		}
+		s.sinks = append(s.sinks, &sinkAdapter{sink: sink})
+		return nil
+	}
+}
+
+// NewTcParser creates new tcParser, returns an error if the options are invalid. The parse cycles run only after
+// Start is called.
+func NewTcParser(logger Logger, opts ...TcParserOption) (*tcParser, error) {
+	if logger == nil {
+		return nil, errors.New("a Logger is required")
+	}
+	setup := &tcParserSetup{
+		options: &TcParserOptions{},
+	}
+	for _, opt := range opts {
+		if err := opt(setup); err != nil {
+			return nil, err
+		}
+	}
+	if err := setup.options.validate(); err != nil {
+		return nil, err
+	}
+	prometheus := setup.options.Prometheus != nil && setup.options.Prometheus.File != ""
+	agent := setup.options.Agent != nil && setup.options.Agent.Target != ""
+	if len(setup.sinks) == 0 && !prometheus && !agent {
+		return nil, errors.New("the parsed data has no destination, at least one sink is required")
+	}
+	tp := newTcParser(setup.options, logger, setup.sinks...)
+	if err := tp.checkWrapper(); err != nil {
+		return nil, err
+	}
+	if setup.options.Aggregator != nil && setup.options.Aggregator.Listen != "" {
+		aggregator, err := newAggregator(setup.options.Aggregator, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to start the aggregator on %s: %s", setup.options.Aggregator.Listen, err)
+		}
+		tp.aggregator = aggregator
+	}
+	if setup.snmp != nil {
+		setup.snmp.refresher = tp
+	}
+	return tp, nil
 }
 
-// NewTcParser creates new tcParser.
-func NewTcParser(options *TcParserOptions, snmp *snmp, logger *syslog.Writer) *tcParser {
-	tp := &tcParser{
+// newTcParser creates new tcParser that adds the parsed data to the dataSinks and to the ones configured in the
+// options. It doesn't start the parse cycles.
+func newTcParser(options *TcParserOptions, logger sysLogger, sinks ...dataSink) *tcParser {
+	// The UserNames were validated by TcParserOptions.validate.
+	pseudonymizer, _ := newPseudonymizer(options.UserNames, options.UserNameKey)
+	if options.Prometheus != nil && options.Prometheus.File != "" {
+		sinks = append(sinks, newPromFileSink(options.Prometheus, pseudonymizer, logger))
+	}
+	if options.Agent != nil && options.Agent.Target != "" {
+		sinks = append(sinks, newAgentSink(options.Agent, options.parseIntervalSeconds(), pseudonymizer, logger))
+	}
+	t := &tcParser{
 		logger:        logger,
 		options:       options,
-		reQdiscHeader: regexp.MustCompile(reQdiscHeaderStr),
-		reClassHeader: regexp.MustCompile(reClassHeaderStr),
-		reStats:       regexp.MustCompile(reStatsStr),
-		snmp:          snmp,
+		reQdiscHeader: regexp.MustCompile(options.qdiscPattern()),
+		reClassHeader: regexp.MustCompile(options.classPattern()),
+		reStats:       regexp.MustCompile(options.statsPattern()),
+		rePeerAddr:    regexp.MustCompile(rePeerAddrStr),
+		sink:          newDataSink(sinks...),
 		executer:      &systemCommand{},
+		ifaceReader:   &sysfsIfaceReader{},
+		linkWatcher:   newLinkWatcher(),
+		ready:         make(chan struct{}),
+		resume:        make(chan struct{}, 1),
 	}
-	tp.start()
-	return tp
+	if len(options.ExecWrapper) > 0 {
+		t.ifaceReader = newIpLinkReader(t.execute, options.ipCmdPath())
+	}
+	t.debug.configure(options.Debug)
+	return t
 }
 
-// logIfDebug logs a message into Syslog if the debug option is set.
+// logIfDebug logs a debug message if the debug logging is on.
 func (t *tcParser) logIfDebug(message string) {
-	if t.options.Debug {
-		t.logger.Info(message)
+	if t.debug.enabled() {
+		logDebug(t.logger, message)
+	}
+}
+
+// SetDebug turns the debug logging on or off regardless of the Debug option.
+func (t *tcParser) SetDebug(on bool) {
+	t.debug.set(on)
+}
+
+// DebugEnabled returns whether the debug logging is on.
+func (t *tcParser) DebugEnabled() bool {
+	return t.debug.enabled()
+}
+
+// Reload replaces the options, the users and the interfaces are resolved again in the next parse cycle. The
+// ParseInterval, AlignCycles, ParseJitter, MaxCommands, Prometheus, Agent and Aggregator options keep the values they
+// had when the tcParser was created. Returns an error if the options are invalid.
+func (t *tcParser) Reload(options *TcParserOptions) error {
+	if options == nil {
+		return errors.New("the TcParserOptions must not be nil")
+	}
+	if err := options.validate(); err != nil {
+		return err
+	}
+	t.parseMu.Lock()
+	defer t.parseMu.Unlock()
+	t.options = options
+	t.reQdiscHeader = regexp.MustCompile(options.qdiscPattern())
+	t.reClassHeader = regexp.MustCompile(options.classPattern())
+	t.reStats = regexp.MustCompile(options.statsPattern())
+	t.userNameClass = nil
+	t.discoveredIfaces = nil
+	t.debug.configure(options.Debug)
+	t.logger.Info("Reload(): Reloaded the options of the TC parser.")
+	return nil
+}
+
+// status returns the state of the tcParser, it waits for the running parse cycle.
+func (t *tcParser) status() parserStatus {
+	t.parseMu.Lock()
+	defer t.parseMu.Unlock()
+	return parserStatus{
+		lastParse:  t.lastParse,
+		failures:   t.failures,
+		backingOff: t.skipCycles > 0,
+		inBlackout: t.inBlackout,
+		idle:       t.idle.Load(),
 	}
 }
 
-// start starts the periodic execution of TC every ParseInterval.
-func (t *tcParser) start() {
-	t.logger.Info("start(): Starting the tc_reader.")
-	configTemplate := "tc_reader configuration:  tcCmdPath: %s  parseInterval: %d  tcQdiscStats: %s  tcClassStats: %s  ifaces: %s  userNameClass: %v"
-	t.logIfDebug(fmt.Sprintf(configTemplate, t.options.tcCmdPath(), t.options.parseInterval(), t.options.tcQdiscStats(), t.options.tcClassStats(), t.options.ifaces(), t.options.userNameClass()))
-	// One initial run of TC execution and parsing.
-	t.parseTc()
+// Start runs the first parse cycle and then the periodic parse cycles every ParseInterval until the context is done
+// or Stop is called. It returns without waiting for the first parse cycle, so that the SNMP daemon can be answered from
+// the initial tree meanwhile, see Ready. The recorded parse cycles are replayed instead if ReplayDir is set. Returns an
+// error if the tcParser was already started or if the recording can't be loaded.
+func (t *tcParser) Start(ctx context.Context) error {
+	t.lifecycleMu.Lock()
+	if t.cancel != nil {
+		t.lifecycleMu.Unlock()
+		return errors.New("the parser was already started")
+	}
+	var cycles []recordedCycle
+	if t.options.ReplayDir != emptyString {
+		var err error
+		if cycles, err = loadRecording(t.options.ReplayDir); err != nil {
+			t.lifecycleMu.Unlock()
+			return fmt.Errorf("unable to load the recorded TC outputs, error: %s", err)
+		}
+	}
+	t.ctx, t.cancel = context.WithCancel(ctx)
+	ctx = t.ctx
+	t.lifecycleMu.Unlock()
+
+	if cycles != nil {
+		t.logger.Info(fmt.Sprintf("Start(): Replaying %d parse cycles recorded in %s.", len(cycles), t.options.ReplayDir))
+		t.running.Add(1)
+		go func() {
+			defer t.running.Done()
+			t.replay(ctx, cycles)
+		}()
+		return nil
+	}
+
+	t.logger.Info("Start(): Starting the tc_reader.")
+	configTemplate := "tc_reader configuration:  tcCmdPath: %s  parseInterval: %s  tcQdiscStats: %s  tcClassStats: %s  ifaces: %s  discoveryInterval: %d  userNameClass: %v"
+	t.logIfDebug(fmt.Sprintf(configTemplate, t.options.tcCmdPath(), t.options.parseInterval(), t.options.tcQdiscStats(), t.options.tcClassStats(), t.options.ifaces(), t.options.discoveryInterval(), t.options.userNameClass()))
+	t.watchLinks(ctx)
+	t.lastRequest.Store(time.Now().UnixNano())
 
+	t.running.Add(1)
 	go func() {
-		for range time.Tick(time.Duration(t.options.parseInterval()) * time.Second) {
-			t.parseTc()
+		defer t.running.Done()
+		// One initial run of TC execution and parsing, it can take long on a busy system.
+		t.parseTc()
+		close(t.ready)
+
+		schedule := newCycleSchedule(t.options, time.Now())
+		timer := time.NewTimer(time.Until(schedule.next(time.Now())))
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				t.parseTc()
+				timer.Reset(time.Until(schedule.next(time.Now())))
+			case <-t.resume:
+				t.parseTc()
+			}
 		}
 	}()
+	return nil
 }
 
-// executeTc executes the TC commands for an interface and returns the command output.
-func (t *tcParser) executeTc(iface string) (string, string, error) {
-	qdiscStats := append(t.options.tcQdiscStats(), iface)
-	qdiscOutput, err := t.executer.Execute(t.options.tcCmdPath(), qdiscStats...)
-	if err != nil {
-		return emptyString, emptyString, err
-	}
+// Ready returns a channel that is closed once the first parse cycle started by Start completes.
+func (t *tcParser) Ready() <-chan struct{} {
+	return t.ready
+}
 
-	clasStats := append(t.options.tcClassStats(), iface)
-	classOutput, err := t.executer.Execute(t.options.tcCmdPath(), clasStats...)
-	if err != nil {
-		return emptyString, emptyString, err
+// Stop stops the periodic parse cycles, cancels the running TC commands and waits until the running parse cycle
+// finishes. The aggregator stops receiving the data of the agents. The tcParser can't be started again.
+func (t *tcParser) Stop() {
+	if t.aggregator != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), agentTimeout)
+		if err := t.aggregator.close(ctx); err != nil {
+			t.logger.Err(fmt.Sprintf("Stop(): Unable to stop the aggregator, error: %s", err))
+		}
+		cancel()
+	}
+	t.lifecycleMu.Lock()
+	cancel := t.cancel
+	t.lifecycleMu.Unlock()
+	if cancel == nil {
+		return
 	}
-	return qdiscOutput, classOutput, nil
+	cancel()
+	t.running.Wait()
 }
 
-// Executes the TC command to get statistics for Qdiscs and Classes on a interfaces and parses the output.
-//
+// commandArgs returns a copy of the configured arguments with the interface name appended.
+// The copy is needed because the same configured arguments are used concurrently for multiple interfaces.
+func commandArgs(args []string, iface string) []string {
+	result := make([]string, 0, len(args)+1)
+	result = append(result, args...)
+	return append(result, iface)
+}
+
+// execute runs the command to completion and returns a reader of its whole output, see stream.
+func (t *tcParser) execute(name string, arg ...string) (io.Reader, error) {
+	return readCommand(t.stream(t.runContext(), name, arg...))
+}
+
+// readCommand reads the whole output of a started command and closes it. Returns the error of the command first, then
+// the one of reading its output.
+func readCommand(output io.ReadCloser, err error) (io.Reader, error) {
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(output)
+	if closeErr := output.Close(); closeErr != nil {
+		return nil, closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+// stream starts the command once one of the maxCommands slots is available and returns a reader of its output, the
+// command is killed after commandTimeout or once the context is done. The slot is held until the output is closed,
+// closing it waits for the command and returns its error.
+func (t *tcParser) stream(ctx context.Context, name string, arg ...string) (io.ReadCloser, error) {
+	t.slotsOnce.Do(func() {
+		t.commandSlots = make(chan struct{}, t.options.maxCommands())
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case t.commandSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.options.commandTimeout())
+	name, arg = wrapCommand(t.options.ExecWrapper, name, arg)
+	output, err := t.executer.Execute(ctx, name, arg...)
+	if err != nil {
+		cancel()
+		<-t.commandSlots
+		return nil, err
+	}
+	return &slotOutput{ReadCloser: output, release: func() {
+		cancel()
+		<-t.commandSlots
+	}}, nil
+}
+
+// slotOutput is the output of a command that holds one of the maxCommands slots until it is closed.
+type slotOutput struct {
+	io.ReadCloser
+
+	// release releases the slot.
+	release func()
+
+	// once closes the output once, err is the error returned by closing it.
+	once sync.Once
+	err  error
+}
+
+// Close waits for the command and releases its slot, the repeated calls return the same error.
+func (o *slotOutput) Close() error {
+	o.once.Do(func() {
+		o.err = o.ReadCloser.Close()
+		o.release()
+	})
+	return o.err
+}
+
+// runContext returns the context of the TC commands, it is done once the tcParser is stopped.
+func (t *tcParser) runContext() context.Context {
+	t.lifecycleMu.Lock()
+	defer t.lifecycleMu.Unlock()
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// ifaceOutput holds the output of the TC commands executed on a single interface.
+type ifaceOutput struct {
+	// iface is the name of the interface.
+	iface string
+
+	// ifIndex is the kernel ifIndex of the interface, zero if it couldn't be resolved.
+	ifIndex int
+
+	// qdiscOutput is the output of the TC command that shows the Qdisc statistics.
+	qdiscOutput io.ReadCloser
+
+	// classOutput is the output of the TC command that shows the Class statistics.
+	classOutput io.ReadCloser
+
+	// members are the monitored interfaces if the output shows all the interfaces, see SingleInvocation. Their outputs
+	// are split from this one while it is parsed.
+	members []ifaceOutput
+
+	// err is the error encountered while executing the TC commands.
+	err error
+}
+
+// close closes the outputs of the TC commands, the errors were reported by the parse cycle if these were parsed.
+func (o *ifaceOutput) close() {
+	if o.qdiscOutput != nil {
+		o.qdiscOutput.Close()
+	}
+	if o.classOutput != nil {
+		o.classOutput.Close()
+	}
+}
+
+// pendingOutput is the output of a TC command started in the background by startTc. Reading or closing it waits for the
+// command to start.
+type pendingOutput struct {
+	// started is closed once the command is started, or once starting it failed with err.
+	started chan struct{}
+
+	// output is the output of the started command.
+	output io.ReadCloser
+
+	// closed is closed once the output is closed, err is the error returned by closing it.
+	closed    chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// newPendingOutput returns the output of a command that isn't started yet.
+func newPendingOutput() *pendingOutput {
+	return &pendingOutput{started: make(chan struct{}), closed: make(chan struct{})}
+}
+
+// start records the output of the started command, or the error that prevented starting it.
+func (p *pendingOutput) start(output io.ReadCloser, err error) {
+	p.output, p.err = output, err
+	close(p.started)
+}
+
+// wait waits for the command to start and returns the error that prevented starting it.
+func (p *pendingOutput) wait() error {
+	<-p.started
+	if p.output == nil {
+		return p.err
+	}
+	return nil
+}
+
+// Read reads the output of the command once it is started.
+func (p *pendingOutput) Read(b []byte) (int, error) {
+	if err := p.wait(); err != nil {
+		return 0, err
+	}
+	return p.output.Read(b)
+}
+
+// Close waits for the command once it is started and returns its error, or the error that prevented starting it.
+func (p *pendingOutput) Close() error {
+	<-p.started
+	p.closeOnce.Do(func() {
+		if p.err == nil {
+			p.err = p.output.Close()
+		}
+		close(p.closed)
+	})
+	return p.err
+}
+
+// tcCommand is a TC command started in the background by startTc.
+type tcCommand struct {
+	// args are the arguments of the command.
+	args []string
+
+	// output is the output of the command.
+	output *pendingOutput
+}
+
+// resolveIfIndexes returns the outputs of the interfaces without the TC commands, with their ifIndexes resolved.
+func (t *tcParser) resolveIfIndexes(ifaces []string) []ifaceOutput {
+	outputs := make([]ifaceOutput, len(ifaces))
+	for i, iface := range ifaces {
+		// The ifIndex is only informational, failing to resolve it shouldn't prevent us from exporting the statistics.
+		ifIndex, err := t.ifaceReader.ifIndex(iface)
+		if err != nil {
+			t.logIfDebug(fmt.Sprintf("parseTc(): Unable to resolve ifIndex of interface %s, error: %s", iface, err))
+		}
+		outputs[i] = ifaceOutput{iface: iface, ifIndex: ifIndex}
+	}
+	return outputs
+}
+
+// collectTc starts the TC commands on the interfaces returned by resolveIfIndexes and returns their outputs in the same
+// order as the interfaces, so that the parsing stays deterministic. The output is streamed from the commands while it
+// is parsed, the outputs must be closed in their order. The commands of at most maxParallel interfaces run at a time,
+// and all of them are killed once the context is done.
+func (t *tcParser) collectTc(ctx context.Context, ifaces []ifaceOutput) []ifaceOutput {
+	if t.options.SingleInvocation {
+		return t.collectAll(ctx, ifaces)
+	}
+	outputs := make([]ifaceOutput, len(ifaces))
+	commands := make([]tcCommand, 0, 2*len(ifaces))
+	for i, iface := range ifaces {
+		qdisc, class := newPendingOutput(), newPendingOutput()
+		outputs[i] = ifaceOutput{iface: iface.iface, ifIndex: iface.ifIndex, qdiscOutput: qdisc, classOutput: class}
+		commands = append(commands,
+			tcCommand{commandArgs(t.options.tcQdiscStats(), iface.iface), qdisc},
+			tcCommand{commandArgs(t.options.tcClassStats(), iface.iface), class},
+		)
+	}
+	go t.startTc(ctx, commands, 2*t.options.maxParallel())
+	return outputs
+}
+
+// collectAll starts the TC commands once for all the interfaces returned by resolveIfIndexes. The single output has
+// the interfaces as its members, the output of each of them is split from it while it is parsed.
+func (t *tcParser) collectAll(ctx context.Context, ifaces []ifaceOutput) []ifaceOutput {
+	qdisc, class := newPendingOutput(), newPendingOutput()
+	go t.startTc(ctx, []tcCommand{
+		{allIfacesArgs(t.options.tcQdiscStats()), qdisc},
+		{allIfacesArgs(t.options.tcClassStats()), class},
+	}, 2)
+	return []ifaceOutput{{qdiscOutput: qdisc, classOutput: class, members: ifaces}}
+}
+
+// startTc starts the TC commands in their order, a command starts once the command window positions before it is
+// closed and one of the maxCommands slots is available. The outputs are closed in the same order, so that the slots
+// held by the open outputs are always released. The commands that aren't started once the context is done fail, the
+// commands after one that fails to start fail with its error as the parse cycle stops at it.
+func (t *tcParser) startTc(ctx context.Context, commands []tcCommand, window int) {
+	var err error
+	for i, command := range commands {
+		if err != nil {
+			command.output.start(nil, err)
+			continue
+		}
+		if i >= window {
+			select {
+			case <-commands[i-window].output.closed:
+			case <-ctx.Done():
+			}
+		}
+		var output io.ReadCloser
+		output, err = t.stream(ctx, t.options.tcCmdPath(), command.args...)
+		command.output.start(output, err)
+	}
+}
+
+// allIfacesArgs returns a copy of the configured arguments without the trailing dev keyword, so that TC shows the
+// statistics of all the interfaces.
+func allIfacesArgs(args []string) []string {
+	if len(args) > 0 && args[len(args)-1] == devKeyword {
+		args = args[:len(args)-1]
+	}
+	return append([]string(nil), args...)
+}
+
+// headerIface returns the interface named by a header line of the TC output of all the interfaces, empty if the
+// header doesn't name one. Returns false if the line isn't a header line.
+func headerIface(line string) (string, bool) {
+	if !strings.HasPrefix(line, qdiscPrefix) && !strings.HasPrefix(line, classPrefix) {
+		return emptyString, false
+	}
+	if match := reDev.FindStringSubmatch(line); match != nil {
+		return match[1], true
+	}
+	return emptyString, true
+}
+
+// ifaceSegments splits the TC output of all the interfaces into the consecutive segments of the interfaces named by
+// the header lines while it is read. The lines before the first header line that names an interface are dropped.
+type ifaceSegments struct {
+	scanner *bufio.Scanner
+
+	// held is the header line that ends the current segment, if holding is set.
+	held    string
+	holding bool
+}
+
+// newIfaceSegments returns the segments of the TC output of all the interfaces.
+func newIfaceSegments(output io.Reader) *ifaceSegments {
+	return &ifaceSegments{scanner: bufio.NewScanner(output)}
+}
+
+// next skips the rest of the current segment and returns the interface of the next one with a reader of its lines.
+// Returns false at the end of the output, see err.
+func (s *ifaceSegments) next() (string, io.Reader, bool) {
+	for {
+		var line string
+		switch {
+		case s.holding:
+			line, s.holding = s.held, false
+		case s.scanner.Scan():
+			line = s.scanner.Text()
+		default:
+			return emptyString, nil, false
+		}
+		if iface, _ := headerIface(line); iface != emptyString {
+			return iface, &segmentReader{segments: s, iface: iface, pending: []byte(line + newLine)}, true
+		}
+	}
+}
+
+// err returns the error encountered while reading the output.
+func (s *ifaceSegments) err() error {
+	return s.scanner.Err()
+}
+
+// nextLine returns the next line of the current segment of the interface, false at the end of the segment.
+func (s *ifaceSegments) nextLine(iface string) (string, bool) {
+	if s.holding || !s.scanner.Scan() {
+		return emptyString, false
+	}
+	line := s.scanner.Text()
+	if lineIface, header := headerIface(line); header && lineIface != iface {
+		s.held, s.holding = line, true
+		return emptyString, false
+	}
+	return line, true
+}
+
+// segmentReader reads the lines of the current segment of an interface.
+type segmentReader struct {
+	segments *ifaceSegments
+
+	// iface is the interface of the segment.
+	iface string
+
+	// pending is the rest of the line that wasn't read yet.
+	pending []byte
+}
+
+// Read reads the segment line by line, it ends at the end of the segment or with the error reading the output.
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		line, ok := r.segments.nextLine(r.iface)
+		if !ok {
+			if err := r.segments.err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		r.pending = append(r.pending, line...)
+		r.pending = append(r.pending, newLine...)
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// splitByIface splits the TC output of all the interfaces into the outputs of each of them, keyed by the interface
+// names in the header lines. The lines before the first header line are dropped.
+func splitByIface(output io.Reader) (map[string]*bytes.Buffer, error) {
+	outputs := make(map[string]*bytes.Buffer)
+	segments := newIfaceSegments(output)
+	for {
+		iface, segment, ok := segments.next()
+		if !ok {
+			return outputs, segments.err()
+		}
+		if outputs[iface] == nil {
+			outputs[iface] = &bytes.Buffer{}
+		}
+		if _, err := outputs[iface].ReadFrom(segment); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// watchLinks subscribes to the link notifications and runs parseTc whenever a monitored interface appears or disappears
+// until the context is done. If the notifications aren't available, the interfaces are only discovered during the regular parse cycles.
+func (t *tcParser) watchLinks(ctx context.Context) {
+	if err := t.linkWatcher.subscribe(); err != nil {
+		logWarning(t.logger, fmt.Sprintf("watchLinks(): Link notifications are not available, error: %s", err))
+		return
+	}
+	present, err := t.ifaceReader.list()
+	if err != nil {
+		t.logger.Err(fmt.Sprintf("watchLinks(): Unable to list the present interfaces, ignoring link notifications, error: %s", err))
+		return
+	}
+	t.ifacesLock.Lock()
+	t.presentIfaces = make(map[string]bool)
+	for _, iface := range present {
+		t.presentIfaces[iface] = true
+	}
+	t.ifacesLock.Unlock()
+
+	t.running.Add(1)
+	go func() {
+		defer t.running.Done()
+		defer t.linkWatcher.close()
+		for ctx.Err() == nil {
+			events, err := t.linkWatcher.receive()
+			if err != nil {
+				t.logger.Err(fmt.Sprintf("watchLinks(): Stopped receiving link notifications, error: %s", err))
+				t.ifacesLock.Lock()
+				t.presentIfaces = nil
+				t.ifacesLock.Unlock()
+				return
+			}
+			if t.applyLinkEvents(events) {
+				t.parseTc()
+			}
+		}
+	}()
+}
+
+// applyLinkEvents updates the present interfaces according to the link notifications.
+// Returns true if any monitored interface appeared or disappeared, the other interfaces don't trigger a parse cycle.
+func (t *tcParser) applyLinkEvents(events []linkEvent) bool {
+	t.ifacesLock.Lock()
+	defer t.ifacesLock.Unlock()
+
+	var changed bool
+	for _, event := range events {
+		if t.presentIfaces[event.name] == !event.removed {
+			continue
+		}
+		if event.removed {
+			t.logIfDebug(fmt.Sprintf("applyLinkEvents(): Interface %s disappeared.", event.name))
+			delete(t.presentIfaces, event.name)
+		} else {
+			t.logIfDebug(fmt.Sprintf("applyLinkEvents(): Interface %s appeared.", event.name))
+			t.presentIfaces[event.name] = true
+		}
+		if t.monitorsIface(event.name) {
+			changed = true
+		}
+	}
+	if changed {
+		t.ifacesChanged = true
+	}
+	return changed
+}
+
+// monitorsIface returns true if the interface is configured or matches a configured pattern. Every interface is
+// monitored if it gets a non-default Qdisc when ifaces is "auto".
+func (t *tcParser) monitorsIface(iface string) bool {
+	for _, configured := range t.options.ifaces() {
+		if configured == autoIfaces || configured == iface {
+			return true
+		}
+		if match, _ := filepath.Match(configured, iface); isIfacePattern(configured) && match {
+			return true
+		}
+	}
+	return false
+}
+
+// knownIfaces returns the sorted names of the present interfaces as learned from the link notifications.
+// Returns nil if the link notifications aren't available. Also reports and resets whether the interfaces changed.
+func (t *tcParser) knownIfaces() ([]string, bool) {
+	t.ifacesLock.Lock()
+	defer t.ifacesLock.Unlock()
+
+	changed := t.ifacesChanged
+	t.ifacesChanged = false
+	if t.presentIfaces == nil {
+		return nil, changed
+	}
+	known := make([]string, 0, len(t.presentIfaces))
+	for iface := range t.presentIfaces {
+		known = append(known, iface)
+	}
+	sort.Strings(known)
+	return known, changed
+}
+
+// monitoredIfaces returns the interfaces that should be monitored. If ifaces is set to autoIfaces, these are the ones
+// found during the last discovery, otherwise the configured ones with any patterns expanded.
+func (t *tcParser) monitoredIfaces() []string {
+	known, changed := t.knownIfaces()
+	ifaces := t.options.ifaces()
+	if len(ifaces) == 1 && ifaces[0] == autoIfaces {
+		return t.autoIfaces(changed)
+	}
+	return t.expandIfaces(ifaces, known)
+}
+
+// autoIfaces returns the interfaces found during the last discovery. Rediscovers the interfaces every discoveryInterval
+// or immediately if the link notifications reported that the interfaces changed.
+func (t *tcParser) autoIfaces(changed bool) []string {
+	if t.discoveredIfaces != nil && !changed && time.Since(t.lastDiscovery) < time.Duration(t.options.discoveryInterval())*time.Second {
+		return t.discoveredIfaces
+	}
+	discovered, err := t.discoverIfaces()
+	if err != nil {
+		t.logger.Err(fmt.Sprintf("monitoredIfaces(): Unable to discover the interfaces, keeping the previously discovered %v, error: %s", t.discoveredIfaces, err))
+		return t.discoveredIfaces
+	}
+	t.logIfDebug(fmt.Sprintf("monitoredIfaces(): Discovered interfaces with non-default Qdiscs: %v", discovered))
+	t.discoveredIfaces = discovered
+	t.lastDiscovery = time.Now()
+	return t.discoveredIfaces
+}
+
+// expandIfaces expands the configured interface patterns, e.g. "ppp*", to the matching present interfaces.
+// Configured interfaces that are known not to be present are skipped, so we don't try to execute TC on them.
+// The known interfaces are nil if the link notifications aren't available.
+func (t *tcParser) expandIfaces(configured, known []string) []string {
+	var hasPattern bool
+	for _, iface := range configured {
+		if isIfacePattern(iface) {
+			hasPattern = true
+		}
+	}
+	if !hasPattern && known == nil {
+		return configured
+	}
+
+	present := known
+	if present == nil {
+		var err error
+		present, err = t.ifaceReader.list()
+		if err != nil {
+			t.logger.Err(fmt.Sprintf("monitoredIfaces(): Unable to list the interfaces while expanding patterns, error: %s", err))
+		}
+	}
+	isPresent := make(map[string]bool)
+	for _, iface := range present {
+		isPresent[iface] = true
+	}
+
+	ifaces := make([]string, 0)
+	added := make(map[string]bool)
+	for _, iface := range configured {
+		if !isIfacePattern(iface) {
+			if known != nil && !isPresent[iface] {
+				t.logIfDebug(fmt.Sprintf("monitoredIfaces(): Skipping interface %s, it isn't present.", iface))
+				continue
+			}
+			if !added[iface] {
+				added[iface] = true
+				ifaces = append(ifaces, iface)
+			}
+			continue
+		}
+		for _, candidate := range present {
+			if match, _ := filepath.Match(iface, candidate); match && !added[candidate] {
+				added[candidate] = true
+				ifaces = append(ifaces, candidate)
+			}
+		}
+	}
+	return ifaces
+}
+
+// discoverIfaces returns all the interfaces that have a non-default Qdisc installed.
+// The default Qdiscs installed by the kernel (pfifo_fast, noqueue, mq, ...) always have the handle 0. A single TC command
+// shows the Qdiscs of all the interfaces, the header lines are attributed to the interfaces the way splitByIface does.
+func (t *tcParser) discoverIfaces() ([]string, error) {
+	all, err := t.ifaceReader.list()
+	if err != nil {
+		return nil, err
+	}
+	qdiscOutput, err := t.execute(t.options.tcCmdPath(), allIfacesArgs(t.options.tcQdiscStats())...)
+	if err != nil {
+		return nil, err
+	}
+
+	shaped := make(map[string]bool)
+	handleIndex := t.reQdiscHeader.SubexpIndex("qdiscHandle")
+	scanner := bufio.NewScanner(qdiscOutput)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, qdiscPrefix) {
+			continue
+		}
+		dev := reDev.FindStringSubmatch(line)
+		if match := t.reQdiscHeader.FindStringSubmatch(line); dev != nil && match != nil && match[handleIndex] != "0" {
+			shaped[dev[1]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	discovered := make([]string, 0)
+	for _, iface := range all {
+		if shaped[iface] {
+			discovered = append(discovered, iface)
+		}
+	}
+	return discovered, nil
+}
+
+// ifacePresent determines whether the interface is present. Interfaces like ppp0 or wwan0 come and go, so instead
+// of failing to execute TC on them every cycle we skip them and only log when they disappear or return.
+func (t *tcParser) ifacePresent(iface string) bool {
+	present := t.ifaceReader.present(iface)
+	if t.missingIfaces == nil {
+		t.missingIfaces = make(map[string]bool)
+	}
+	switch {
+	case !present && !t.missingIfaces[iface]:
+		logWarning(t.logger, fmt.Sprintf("parseTc(): Interface %s isn't present, skipping it until it returns.", iface))
+		t.missingIfaces[iface] = true
+	case present && t.missingIfaces[iface]:
+		t.logger.Info(fmt.Sprintf("parseTc(): Interface %s is present again.", iface))
+		delete(t.missingIfaces, iface)
+	}
+	return present
+}
+
+// tcIfaceName returns the interface name as it appears in the tcNames.
+func (t *tcParser) tcIfaceName(iface string) string {
+	if t.options != nil && t.options.EncodeIfaceNames {
+		return encodeIfaceName(iface)
+	}
+	return iface
+}
+
+// resolveUserNameClass returns the configured userNameClass with the keys converted to the tcNames produced by parseData.
+// A user definition can refer to a point-to-point interface by its peer address, e.g. "@10.0.0.5:1:10", so that
+// the user is found even if the PPP session comes back on a different interface.
+// The interface names can be configured either plain or encoded, e.g. both "eth0.100:1:10" and "eth0%2E100:1:10" work.
+func (t *tcParser) resolveUserNameClass() map[string]UserClass {
+	configured := t.options.userNameClass()
+	var peers map[string]string
+	t.peerUsers = false
+	for tcName := range configured {
+		if strings.HasPrefix(tcName, peerPrefix) {
+			t.peerUsers = true
+			var err error
+			peers, err = t.peerIfaces()
+			if err != nil {
+				t.logger.Err(fmt.Sprintf("resolveUserNameClass(): Unable to resolve the peer addresses, error: %s", err))
+			}
+			break
+		}
+	}
+
+	resolved := make(map[string]UserClass)
+	for tcName, userClass := range configured {
+		parts := strings.SplitN(tcName, ":", 2)
+		if len(parts) != 2 {
+			resolved[tcName] = userClass
+			continue
+		}
+		iface := parts[0]
+		if strings.HasPrefix(iface, peerPrefix) {
+			var ok bool
+			iface, ok = peers[strings.TrimPrefix(iface, peerPrefix)]
+			if !ok {
+				t.logIfDebug(fmt.Sprintf("resolveUserNameClass(): No interface with peer address for %s.", tcName))
+				continue
+			}
+		}
+		resolved[fmt.Sprintf("%s:%s", t.tcIfaceName(decodeIfaceName(iface)), parts[1])] = userClass
+	}
+	return resolved
+}
+
+// resolveAddrUsers adds the Classes, that the filters on the interfaces direct the traffic of the configured user
+// addresses to, into the resolved userNameClass. The explicitly configured tcNames take precedence.
+func (t *tcParser) resolveAddrUsers(resolved map[string]UserClass, ifaces []string) {
+	addrUsers := t.options.addrUsers()
+	if len(addrUsers) == 0 {
+		return
+	}
+	for _, iface := range ifaces {
+		output, err := t.execute(t.options.tcCmdPath(), commandArgs(t.options.tcFilterShow(), iface)...)
+		if err != nil {
+			t.logger.Err(fmt.Sprintf("resolveAddrUsers(): Unable to get the TC filters on %s, error: %s", iface, err))
+			continue
+		}
+		matches, err := parseFilters(output)
+		if err != nil {
+			t.logger.Err(fmt.Sprintf("resolveAddrUsers(): Unable to parse the TC filters on %s, error: %s", iface, err))
+			continue
+		}
+		for _, match := range matches {
+			name, ok := addrUsers[match.addr]
+			if !ok {
+				continue
+			}
+			tcName := t.tcIfaceName(iface) + ":" + match.handle
+			if user, ok := resolved[tcName]; ok {
+				if user.Name != name {
+					t.logIfDebug(fmt.Sprintf("resolveAddrUsers(): %s of %s is already counted for user %s.", tcName, match.addr, user.Name))
+				}
+				continue
+			}
+			resolved[tcName] = UserClass{
+				Direction: match.direction,
+				Name:      name,
+			}
+		}
+	}
+}
+
+// peerIfaces returns a map of peer addresses to the names of the point-to-point interfaces.
+func (t *tcParser) peerIfaces() (map[string]string, error) {
+	output, err := t.execute(t.options.ipCmdPath(), "-o", "addr", "show")
+	if err != nil {
+		return nil, err
+	}
+	peers := make(map[string]string)
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		if match := t.rePeerAddr.FindStringSubmatch(scanner.Text()); match != nil {
+			peers[match[2]] = match[1]
+		}
+	}
+	return peers, scanner.Err()
+}
+
+// Executes the TC command to get statistics for Qdiscs and Classes on a interfaces and parses the output.
+//
 // Example output of 'tc -s qdisc show dev eth0':
 // qdisc dsmark 1: root refcnt 2 indices 0x0010 default_index 0x0000
 //  Sent 8165477580 bytes 5927092 pkt (dropped 49112, overlimits 0 requeues 0)
@@ -263,35 +1785,350 @@ func (t *tcParser) executeTc(iface string) (string, string, error) {
 //  lended: 0 borrowed: 0 giants: 0
 //  tokens: 388171 ctokens: 388171
 func (t *tcParser) parseTc() {
-	t.snmp.lock()
-	defer t.snmp.unlock()
+	t.parseMu.Lock()
+	defer t.parseMu.Unlock()
+	t.sink.begin()
+	defer t.sink.commit()
+	replayed := t.replayed
+	t.replayed = nil
+
+	if window, ok := t.blackoutWindow(time.Now()); ok {
+		if !t.inBlackout {
+			t.logger.Info(fmt.Sprintf("parseTc(): Pausing the collection during the blackout window %s.", window))
+			t.inBlackout = true
+		}
+		t.sink.setMaintenance(true)
+		return
+	}
+	if t.inBlackout {
+		t.logger.Info("parseTc(): Resuming the collection after the blackout window.")
+		t.inBlackout = false
+		t.sink.setMaintenance(false)
+	}
+
+	if t.idleFor(time.Now()) {
+		if !t.idle.Load() {
+			t.logger.Info(fmt.Sprintf("parseTc(): Pausing the collection, there were no SNMP requests for %s.", t.options.idlePause()))
+			t.idle.Store(true)
+		}
+		return
+	}
+	if t.idle.Load() {
+		t.logger.Info("parseTc(): Resuming the collection after an SNMP request.")
+		t.idle.Store(false)
+	}
+
+	if t.skipCycles > 0 {
+		t.skipCycles--
+		return
+	}
+
+	t.lastParse = time.Now()
+	t.cycleMonotonic = monotonicNow()
 
 	// Erase any previous data.
-	t.snmp.erase()
+	t.sink.erase()
 
-	for _, iface := range t.options.ifaces() {
-		qdiscOutput, classOutput, err := t.executeTc(iface)
-		if err != nil {
-			t.logger.Err(fmt.Sprintf("parseTc(): Unable to get TC command output, error: %s", err))
-			return
+	ifaces := make([]string, 0)
+	if replayed != nil {
+		ifaces = replayedIfaces(replayed)
+	} else {
+		for _, iface := range t.monitoredIfaces() {
+			if t.ifacePresent(iface) {
+				ifaces = append(ifaces, iface)
+			}
 		}
+	}
+	// Users defined by addresses follow the filters, these can direct the addresses to different Classes at any time.
+	if t.userNameClass == nil || t.peerUsers || len(t.options.addrUsers()) > 0 {
+		t.userNameClass = t.resolveUserNameClass()
+		t.resolveAddrUsers(t.userNameClass, ifaces)
+	}
+	t.ifaceTotals = make(map[string]sample)
+	t.leafTotals = nil
+	if t.options.ifaceTotals() == ifaceTotalsLeaves {
+		t.leafTotals = make(map[string]sample)
+	}
+	t.tcNames = make(map[string]int)
+	t.unmatchedLines = 0
+	t.userTotals = make(map[UserClass]sample)
+	t.userOrder = t.userOrder[:0]
 
-		err = t.parseData(qdiscOutput, iface, t.reQdiscHeader, t.reStats)
-		if err != nil {
-			t.logger.Err(fmt.Sprintf("parseTc(): Unable to parse the output of TC commands while getting Qdisc statistics, error: %s", err))
+	var netDev []netDevStats
+	var counters map[string]sample
+	var conntrack map[UserClass]sample
+	outputs := replayed
+	if replayed == nil {
+		// The other commands are collected before the TC commands start, these hold the command slots until parsed.
+		indexed := t.resolveIfIndexes(ifaces)
+		netDev = t.collectNetDev(indexed)
+		counters = t.collectCounters()
+		conntrack = t.collectConntrack()
+		ctx, cancel := context.WithCancel(t.runContext())
+		outputs = t.collectTc(ctx, indexed)
+		defer func() {
+			cancel()
+			for i := range outputs {
+				outputs[i].close()
+			}
+		}()
+	}
+	if t.options.RecordDir != emptyString && replayed == nil {
+		outputs = t.record(t.lastParse, outputs)
+	}
+	for _, output := range outputs {
+		if output.err != nil {
+			t.tcFailed(output.err)
 			return
 		}
-
-		err = t.parseData(classOutput, iface, t.reClassHeader, t.reStats)
-		if err != nil {
-			t.logger.Err(fmt.Sprintf("parseTc(): Unable to parse the output of TC commands while getting Class statistics, error: %s", err))
+		if !t.parseOutput(&output, output.qdiscOutput, t.reQdiscHeader, "Qdisc") {
+			return
+		}
+		if !t.parseOutput(&output, output.classOutput, t.reClassHeader, "Class") {
 			return
 		}
 	}
+	t.addGroups()
+	t.addIfaces()
+	t.addCounterUsers(counters)
+	t.addUsers()
+	t.addConntrackUsers(conntrack)
+	t.addAgents()
+	for i := range netDev {
+		t.sink.addNetDevData(&netDev[i])
+	}
+	t.sink.setUnmatchedLines(t.unmatchedLines)
+
+	if t.failures > 0 {
+		t.logger.Info(fmt.Sprintf("parseTc(): TC commands succeeded after %d failures, the collection is back to normal.", t.failures))
+		t.failures = 0
+		t.sink.setHealth(0, 0)
+	}
+}
+
+// parseOutput parses the output of a TC command and closes it, the output of all the interfaces is split by its
+// members. Returns false if the parse cycle must stop.
+func (t *tcParser) parseOutput(output *ifaceOutput, cmdOutput io.ReadCloser, reHeader *regexp.Regexp, statistics string) bool {
+	// The output of a command that failed to start isn't parsed.
+	if pending, ok := cmdOutput.(*pendingOutput); ok {
+		if err := pending.wait(); err != nil {
+			t.tcFailed(err)
+			return false
+		}
+	}
+	var err error
+	if output.members != nil {
+		err = t.parseAll(cmdOutput, output.members, reHeader)
+	} else {
+		err = t.parseData(cmdOutput, output.iface, output.ifIndex, reHeader, t.reStats)
+	}
+	if closeErr := cmdOutput.Close(); closeErr != nil {
+		t.tcFailed(closeErr)
+		return false
+	}
+	if err != nil {
+		t.logger.Err(fmt.Sprintf("parseTc(): Unable to parse the output of TC commands while getting %s statistics, error: %s", statistics, err))
+		return false
+	}
+	return true
+}
+
+// parseAll parses the TC output of all the interfaces segment by segment, the segments of the interfaces that aren't
+// among the members are skipped.
+func (t *tcParser) parseAll(cmdOutput io.Reader, members []ifaceOutput, reHeader *regexp.Regexp) error {
+	ifIndexes := make(map[string]int, len(members))
+	for _, member := range members {
+		ifIndexes[member.iface] = member.ifIndex
+	}
+	segments := newIfaceSegments(cmdOutput)
+	for {
+		iface, segment, ok := segments.next()
+		if !ok {
+			return segments.err()
+		}
+		if ifIndex, monitored := ifIndexes[iface]; monitored {
+			if err := t.parseData(segment, iface, ifIndex, reHeader, t.reStats); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// tcFailed records the failure of a TC command and backs off the following parse cycles, unless the command was
+// canceled by stopping the tcParser.
+func (t *tcParser) tcFailed(err error) {
+	if t.runContext().Err() != nil {
+		t.logger.Info("parseTc(): The TC commands were canceled, the tc_reader is stopping.")
+		return
+	}
+	t.failures++
+	t.skipCycles = t.backoffCycles(t.failures)
+	backoff := time.Duration(t.skipCycles+1) * t.options.parseInterval()
+	t.logger.Err(fmt.Sprintf("parseTc(): Unable to get TC command output, error: %s. Failed %d times in a row, next attempt in %s.", err, t.failures, backoff))
+	t.sink.setHealth(t.failures, ceilSeconds(backoff))
+}
+
+// refresh runs a parse cycle now if the data was parsed more than maxAge ago. The data isn't refreshed
+// during a blackout window, while backing off after TC failures or while replaying a recording.
+func (t *tcParser) refresh(maxAge time.Duration) {
+	t.parseMu.Lock()
+	stale := time.Since(t.lastParse) >= maxAge && !t.inBlackout && t.skipCycles == 0 && t.options.ReplayDir == emptyString
+	t.parseMu.Unlock()
+	if stale {
+		t.logIfDebug(fmt.Sprintf("refresh(): The data is older than %s, parsing now.", maxAge))
+		t.parseTc()
+	}
+}
+
+// requested records an SNMP request, the collection paused for the lack of requests resumes right away.
+func (t *tcParser) requested() {
+	t.lastRequest.Store(time.Now().UnixNano())
+	if t.idle.Load() {
+		select {
+		case t.resume <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// idleFor returns true if the IdlePause elapsed since the last SNMP request.
+func (t *tcParser) idleFor(now time.Time) bool {
+	pause := t.options.idlePause()
+	last := t.lastRequest.Load()
+	return pause > 0 && last != 0 && now.Sub(time.Unix(0, last)) >= pause
+}
+
+// backoffCycles returns the number of parse cycles to skip after the given number of consecutive failures.
+// The period between the attempts doubles after every failure and is capped at maxBackoff.
+func (t *tcParser) backoffCycles(failures int) int {
+	maxCycles := int(time.Duration(t.options.maxBackoff()) * time.Second / t.options.parseInterval())
+	cycles := 1
+	for i := 1; i < failures && cycles < maxCycles; i++ {
+		cycles *= 2
+	}
+	if cycles > maxCycles {
+		cycles = maxCycles
+	}
+	if cycles < 1 {
+		cycles = 1
+	}
+	return cycles - 1
+}
+
+// blackoutWindow returns the configured blackout window the time falls into, false if there is none.
+func (t *tcParser) blackoutWindow(now time.Time) (blackoutWindow, bool) {
+	if t.options == nil {
+		return blackoutWindow{}, false
+	}
+	for _, window := range t.options.Blackouts {
+		if window.contains(now) {
+			return window, true
+		}
+	}
+	return blackoutWindow{}, false
+}
+
+// addGroups sums the root Qdisc counters of the member interfaces of each configured group.
+// Groups without any member present in this parse cycle aren't exported.
+func (t *tcParser) addGroups() {
+	groups := t.options.groups()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var total sample
+		var found bool
+		for _, iface := range groups[name] {
+			ifaceTotal, ok := t.ifaceTotals[iface]
+			if !ok {
+				continue
+			}
+			found = true
+			total.bytes += ifaceTotal.bytes
+			total.pkt += ifaceTotal.pkt
+			total.droppedPkt += ifaceTotal.droppedPkt
+			total.overLimitPkt += ifaceTotal.overLimitPkt
+		}
+		if !found {
+			t.logIfDebug(fmt.Sprintf("addGroups(): No statistics for any member of group %s.", name))
+			continue
+		}
+		t.sink.addGroupData(&parsedData{
+			name:         name,
+			sentBytes:    total.bytes,
+			sentPkt:      total.pkt,
+			droppedPkt:   total.droppedPkt,
+			overLimitPkt: total.overLimitPkt,
+			monotonic:    t.cycleMonotonic,
+		})
+	}
+}
+
+// addIfaces sums the counters of each interface, as configured by ifaceTotals. The interfaces without any Qdisc / Class
+// to sum in this parse cycle aren't exported.
+func (t *tcParser) addIfaces() {
+	totals := t.ifaceTotals
+	switch t.options.ifaceTotals() {
+	case ifaceTotalsRoot:
+	case ifaceTotalsLeaves:
+		totals = t.leafTotals
+	default:
+		return
+	}
+	ifaces := make([]string, 0, len(totals))
+	for iface := range totals {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Strings(ifaces)
+
+	for _, iface := range ifaces {
+		total := totals[iface]
+		t.sink.addIfaceData(&parsedData{
+			name:         iface,
+			sentBytes:    total.bytes,
+			sentPkt:      total.pkt,
+			droppedPkt:   total.droppedPkt,
+			overLimitPkt: total.overLimitPkt,
+			monotonic:    t.cycleMonotonic,
+		})
+	}
+}
+
+// addUserSample adds the counters of a Qdisc / Class to the summed counters of the user and direction it belongs to.
+func (t *tcParser) addUserSample(user UserClass, current sample) {
+	if t.userTotals == nil {
+		t.userTotals = make(map[UserClass]sample)
+	}
+	total, ok := t.userTotals[user]
+	if !ok {
+		t.userOrder = append(t.userOrder, user)
+	}
+	t.userTotals[user] = total.add(current)
+}
+
+// addUsers stores the summed counters of the Qdiscs / Classes of each user and direction, in the order in which
+// the first Qdisc / Class of each was parsed. Users without any Qdisc / Class in this parse cycle aren't exported.
+func (t *tcParser) addUsers() {
+	for _, user := range t.userOrder {
+		user := user
+		total := t.userTotals[user]
+		t.sink.addData(&parsedData{
+			name:         user.Name,
+			sentBytes:    total.bytes,
+			sentPkt:      total.pkt,
+			droppedPkt:   total.droppedPkt,
+			overLimitPkt: total.overLimitPkt,
+			userClass:    &user,
+			monotonic:    t.cycleMonotonic,
+		})
+	}
 }
 
 // parseData parses data received from the TC command output.
-func (t *tcParser) parseData(cmdOutput string, ifaceName string, reHeader, reData *regexp.Regexp) error {
+func (t *tcParser) parseData(cmdOutput io.Reader, ifaceName string, ifIndex int, reHeader, reData *regexp.Regexp) error {
 
 	// haveHeader indicates that parseData saw the header line for a Qdisc / Class.
 	var haveHeader bool
@@ -305,77 +2142,188 @@ func (t *tcParser) parseData(cmdOutput string, ifaceName string, reHeader, reDat
 	var sentPkt int64
 	var droppedPkt int64
 	var overLimitPkt int64
+	var tcName string
 	var err error
 
-	for _, line := range strings.Split(cmdOutput, newLine) {
+	// isRoot indicates that the last seen header was for a root Qdisc.
+	var isRoot bool
+
+	// handle is the handle of the last seen Class, e.g. "2:3", while the leaf Classes are summed.
+	var handle string
+
+	// leaves are the counters of the Classes and parents are the handles of the Classes with child Classes, these are
+	// only collected while the leaf Classes are summed.
+	var leaves map[string]sample
+	var parents map[string]bool
+
+	// xstatsName is the tcName of the last stored Qdisc / Class, the xstats that follow its statistics belong to it.
+	var xstatsName string
+	var xstats []xstat
+
+	// data is reused for every Qdisc / Class in the output, so that it is allocated once per call.
+	var data parsedData
+
+	// These don't change within the output, so they are computed once outside of the per-line loop.
+	tcIfaceName := t.tcIfaceName(ifaceName)
+	parseXstats := t.options != nil && t.options.Xstats
+	qdiscIndex, classIndex := reHeader.SubexpIndex("qdiscHandle"), reHeader.SubexpIndex("classHandle")
+	sentBytesIndex, sentPktIndex := reData.SubexpIndex("sentBytes"), reData.SubexpIndex("sentPkt")
+	droppedIndex, overLimitIndex := reData.SubexpIndex("droppedPkt"), reData.SubexpIndex("overLimitPkt")
+
+	// The output is parsed line by line as it is read, so that it is never split into a slice of all the lines.
+	scanner := bufio.NewScanner(cmdOutput)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// matched indicates that the line matched the header or the data pattern.
+		matched := false
+
 		// Does this line contain the header ?
-		if match := reHeader.FindAllStringSubmatch(line, -1); match != nil {
-			matchSlice := match[0]
-			qdiscHandle, err = strconv.ParseInt(matchSlice[2], 16, 64)
+		if matchSlice := reHeader.FindStringSubmatch(line); matchSlice != nil {
+			matched = true
+			t.addXstats(xstatsName, xstats)
+			xstatsName, xstats = emptyString, nil
+			if haveHeader && !haveData {
+				t.sink.addWarning(fmt.Sprintf("%s: no statistics found, skipping it", tcName))
+			}
+			qdiscHandle, err = strconv.ParseInt(matchSlice[qdiscIndex], 16, 64)
 			if err != nil {
 				return err
 			}
 			// Class handle is only present in the output for a Class. We assume zero in the output for a Qdisc.
-			if len(matchSlice) == 4 {
-				classHandle, err = strconv.ParseInt(matchSlice[3], 16, 64)
+			if classIndex >= 0 {
+				classHandle, err = strconv.ParseInt(matchSlice[classIndex], 16, 64)
 				if err != nil {
 					return err
 				}
 			}
+			// tcName is the internal name for this Qdisc / Class on an interface. Example: "eth0:2:3" is Class 3, Qdisc 2 on interface eth0.
+			tcName = t.uniqueName(tcIfaceName + ":" + strconv.FormatInt(qdiscHandle, 16) + ":" + strconv.FormatInt(classHandle, 16))
+			isRoot = strings.HasPrefix(line, qdiscPrefix) && strings.Contains(line, rootKeyword)
 			haveHeader = true
+			handle = emptyString
+			if t.leafTotals != nil && classIndex >= 0 {
+				if leaves == nil {
+					leaves, parents = make(map[string]sample), make(map[string]bool)
+				}
+				handle = matchSlice[qdiscIndex] + ":" + matchSlice[classIndex]
+				if parent := reParent.FindStringSubmatch(line); parent != nil {
+					parents[parent[1]+":"+parent[2]] = true
+				}
+			}
+		} else if strings.HasPrefix(line, qdiscPrefix) || strings.HasPrefix(line, classPrefix) {
+			t.addXstats(xstatsName, xstats)
+			xstatsName, xstats = emptyString, nil
+			t.sink.addWarning(fmt.Sprintf("%s: unrecognized header line '%s', skipping it", ifaceName, strings.TrimSpace(line)))
+		} else if xstatsName != emptyString {
+			xstats = append(xstats, parseXstatsLine(line)...)
 		}
 
 		// Does this line contain the data ?
-		if match := reData.FindAllStringSubmatch(line, -1); match != nil {
-			matchSlice := match[0]
-			sentBytes, err = strconv.ParseInt(matchSlice[1], 10, 64)
+		if matchSlice := reData.FindStringSubmatch(line); matchSlice != nil {
+			matched = true
+			sentBytes, err = strconv.ParseInt(matchSlice[sentBytesIndex], 10, 64)
 			if err != nil {
 				return err
 			}
-			sentPkt, err = strconv.ParseInt(matchSlice[2], 10, 64)
+			sentPkt, err = strconv.ParseInt(matchSlice[sentPktIndex], 10, 64)
 			if err != nil {
 				return err
 			}
-			droppedPkt, err = strconv.ParseInt(matchSlice[3], 10, 64)
+			droppedPkt, err = strconv.ParseInt(matchSlice[droppedIndex], 10, 64)
 			if err != nil {
 				return err
 			}
-			overLimitPkt, err = strconv.ParseInt(matchSlice[4], 10, 64)
+			overLimitPkt, err = strconv.ParseInt(matchSlice[overLimitIndex], 10, 64)
 			if err != nil {
 				return err
 			}
 			haveData = true
 		}
+		if !matched && unmatchedLine(line) {
+			t.unmatchedLines++
+			t.logIfDebug(fmt.Sprintf("parseData(): The line '%s' on interface %s matches neither the header pattern %s nor the statistics pattern %s.", strings.TrimSpace(line), ifaceName, reHeader, reData))
+		}
 
 		// Store the data once we parsed both the header and the data.
 		if haveHeader && haveData {
 			haveHeader = false
 			haveData = false
 
-			// tcName is the internal name for this Qdisc / Class on an interface. Example: "eth0:2:3" is Class 3, Qdisc 2 on interface eth0.
-			tcName := fmt.Sprintf("%s:%s:%s", ifaceName, strconv.FormatInt(qdiscHandle, 16), strconv.FormatInt(classHandle, 16))
-			data := &parsedData{
+			data = parsedData{
 				name:         tcName,
 				sentBytes:    sentBytes,
 				sentPkt:      sentPkt,
 				droppedPkt:   droppedPkt,
 				overLimitPkt: overLimitPkt,
+				ifIndex:      ifIndex,
+				monotonic:    t.cycleMonotonic,
 			}
-			t.snmp.addData(data)
-
-			// Store information for an user if this tcName is configured as belonging to an user.
-			if userClass, ok := t.options.userNameClass()[tcName]; ok {
-				userData := &parsedData{
-					name:         tcName,
-					sentBytes:    sentBytes,
-					sentPkt:      sentPkt,
-					droppedPkt:   droppedPkt,
-					overLimitPkt: overLimitPkt,
-					userClass:    &userClass,
-				}
-				t.snmp.addData(userData)
+			t.sink.addData(&data)
+			if parseXstats {
+				xstatsName = tcName
+			}
+
+			// Root Qdiscs see all the traffic on the interface, these are used to compute the group counters.
+			if isRoot && t.ifaceTotals != nil {
+				total := t.ifaceTotals[ifaceName]
+				total.bytes += sentBytes
+				total.pkt += sentPkt
+				total.droppedPkt += droppedPkt
+				total.overLimitPkt += overLimitPkt
+				t.ifaceTotals[ifaceName] = total
+			}
+			if handle != emptyString {
+				leaves[handle] = data.sample()
+			}
+
+			// Count the data for an user if this tcName is configured as belonging to an user.
+			if userClass, ok := t.userNameClass[tcName]; ok {
+				t.addUserSample(userClass, data.sample())
 			}
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if haveHeader && !haveData {
+		t.sink.addWarning(fmt.Sprintf("%s: no statistics found, skipping it", tcName))
+	}
+	t.addXstats(xstatsName, xstats)
+
+	// The Classes that aren't the parent of any other Class are the leaves.
+	if len(leaves) > 0 {
+		total := t.leafTotals[ifaceName]
+		for leaf, counters := range leaves {
+			if !parents[leaf] {
+				total = total.add(counters)
+			}
+		}
+		t.leafTotals[ifaceName] = total
+	}
 	return nil
 }
+
+// uniqueName returns the tcName if it wasn't seen yet in this parse cycle. Otherwise it warns about the duplicate and
+// returns the tcName with the sequence number of the duplicate in the order of the TC output, e.g. "eth0:0:0#2".
+// Duplicates are common with multiqueue Qdiscs, where all the child Qdiscs have the handle 0.
+func (t *tcParser) uniqueName(tcName string) string {
+	if t.tcNames == nil {
+		t.tcNames = make(map[string]int)
+	}
+	t.tcNames[tcName] += 1
+	seen := t.tcNames[tcName]
+	if seen == 1 {
+		return tcName
+	}
+	unique := tcName + duplicateSeparator + strconv.Itoa(seen)
+	t.sink.addWarning(fmt.Sprintf("%s: duplicate name, exporting it as %s", tcName, unique))
+	return unique
+}
+
+// addXstats stores the xstats that followed the statistics of the Qdisc / Class, if there were any.
+func (t *tcParser) addXstats(name string, xstats []xstat) {
+	if name != emptyString && len(xstats) > 0 {
+		t.sink.addXstats(name, xstats)
+	}
+}
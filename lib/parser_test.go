@@ -17,11 +17,20 @@ limitations under the License.
 package lib
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os/exec"
 	"reflect"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/kylelemons/godebug/pretty"
 )
@@ -48,11 +57,14 @@ func TestTcParserOptionsTcCmdPath(t *testing.T) {
 
 func TestTcParserOptionsParseInterval(t *testing.T) {
 	testData := []struct {
-		in  int
-		out int
+		in      time.Duration
+		out     time.Duration
+		seconds int
 	}{
-		{0, parseInterval},
-		{13, 13},
+		{0, parseInterval, 5},
+		{13 * time.Second, 13 * time.Second, 13},
+		{500 * time.Millisecond, 500 * time.Millisecond, 1},
+		{2500 * time.Millisecond, 2500 * time.Millisecond, 3},
 	}
 
 	var o *TcParserOptions
@@ -63,6 +75,9 @@ func TestTcParserOptionsParseInterval(t *testing.T) {
 		if !reflect.DeepEqual(o.parseInterval(), params.out) {
 			t.Errorf("TestTcParserOptionsParseInterval(testCase %d) got: '%v' want: '%v'", i, o.parseInterval(), params.out)
 		}
+		if got := o.parseIntervalSeconds(); got != params.seconds {
+			t.Errorf("TestTcParserOptionsParseInterval(testCase %d) parseIntervalSeconds got: '%v' want: '%v'", i, got, params.seconds)
+		}
 	}
 }
 
@@ -126,15 +141,56 @@ func TestTcParserOptionsIfaces(t *testing.T) {
 	}
 }
 
+func TestTcParserOptionsDiscoveryInterval(t *testing.T) {
+	testData := []struct {
+		in  int
+		out int
+	}{
+		{0, discoveryInterval},
+		{13, 13},
+	}
+
+	var o *TcParserOptions
+	for i, params := range testData {
+		o = &TcParserOptions{
+			DiscoveryInterval: params.in,
+		}
+		if !reflect.DeepEqual(o.discoveryInterval(), params.out) {
+			t.Errorf("TestTcParserOptionsDiscoveryInterval(testCase %d) got: '%v' want: '%v'", i, o.discoveryInterval(), params.out)
+		}
+	}
+}
+
+func TestTcParserOptionsMaxParallel(t *testing.T) {
+	testData := []struct {
+		in  int
+		out int
+	}{
+		{0, maxParallel},
+		{-1, maxParallel},
+		{13, 13},
+	}
+
+	var o *TcParserOptions
+	for i, params := range testData {
+		o = &TcParserOptions{
+			MaxParallel: params.in,
+		}
+		if !reflect.DeepEqual(o.maxParallel(), params.out) {
+			t.Errorf("TestTcParserOptionsMaxParallel(testCase %d) got: '%v' want: '%v'", i, o.maxParallel(), params.out)
+		}
+	}
+}
+
 func TestTcParserOptionsUserNameClass(t *testing.T) {
 	testData := []struct {
-		in  map[string]userClass
-		out map[string]userClass
+		in  map[string]UserClass
+		out map[string]UserClass
 	}{
-		{nil, map[string]userClass{}},
+		{nil, map[string]UserClass{}},
 		{
-			map[string]userClass{"1": {1, "username"}},
-			map[string]userClass{"1": {1, "username"}},
+			map[string]UserClass{"1": {1, "username"}},
+			map[string]UserClass{"1": {1, "username"}},
 		},
 	}
 
@@ -189,6 +245,7 @@ func TestTcParserLogIfDebug(t *testing.T) {
 			logger:  fs,
 			options: o,
 		}
+		p.debug.configure(o.Debug)
 		p.logIfDebug(params.message)
 		if diff := pretty.Compare(params.expInfo, fs.info); diff != "" {
 			t.Errorf("TestTcParserLogIfDebug(testCase %d) unexpected log, diff (-want, +got):\n%s", i, diff)
@@ -209,11 +266,49 @@ type fakeExecuter struct {
 
 	// err is the error that should be returned after the call to Execute(). First call will return the first entry and delete it.
 	err []error
+
+	// deadlines are the deadlines of the contexts used in function calls to Execute(), zero if the context had no deadline.
+	deadlines []time.Time
+
+	// mu protects the fields above.
+	mu sync.Mutex
+}
+
+func TestTcParserDebugEnabled(t *testing.T) {
+	testData := []struct {
+		desc       string
+		configured bool
+		set        []bool
+		want       bool
+	}{
+		{"configured off", false, nil, false},
+		{"configured on", true, nil, true},
+		{"turned on", false, []bool{true}, true},
+		{"turned off", true, []bool{false}, false},
+		{"toggled twice", false, []bool{true, false}, false},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := &tcParser{}
+			p.debug.configure(tc.configured)
+			for _, on := range tc.set {
+				p.SetDebug(on)
+			}
+			if got := p.DebugEnabled(); got != tc.want {
+				t.Errorf("DebugEnabled => got: %t, want: %t", got, tc.want)
+			}
+		})
+	}
 }
 
-func (fe *fakeExecuter) Execute(name string, arg ...string) (string, error) {
+func (fe *fakeExecuter) Execute(ctx context.Context, name string, arg ...string) (io.ReadCloser, error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	deadline, _ := ctx.Deadline()
 	fe.command = append(fe.command, name)
 	fe.args = append(fe.args, arg)
+	fe.deadlines = append(fe.deadlines, deadline)
 	if len(fe.output) < 1 || len(fe.err) < 1 {
 		panic("Execute(): unexpected call to Execute, got no more output data.")
 	}
@@ -221,7 +316,28 @@ func (fe *fakeExecuter) Execute(name string, arg ...string) (string, error) {
 	err := fe.err[0]
 	fe.output = fe.output[1:]
 	fe.err = fe.err[1:]
-	return output, err
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader(output)), nil
+}
+
+// readClose reads the whole command output and closes it, returns the error of the command.
+func readClose(r io.ReadCloser) (string, error) {
+	output, _ := ioutil.ReadAll(r)
+	return string(output), r.Close()
+}
+
+// readOutput reads the whole command output, returns an empty string if there is no output.
+func readOutput(r io.Reader) string {
+	if r == nil {
+		return ""
+	}
+	output, err := ioutil.ReadAll(r)
+	if err != nil {
+		panic(fmt.Sprintf("readOutput(): unable to read the output: %v", err))
+	}
+	return string(output)
 }
 
 func TestTcParserExecuteTc(t *testing.T) {
@@ -247,14 +363,14 @@ func TestTcParserExecuteTc(t *testing.T) {
 			nil,
 		},
 
-		// A test case where the second command execution returns an Error.
+		// A test case where the second command execution returns an Error, the first output is streamed before.
 		{
 			[]string{"qdiscOutput", ""},
 			[]error{nil, fmt.Errorf("an error")},
 			"eth0",
 			[]string{"/sbin/tc", "/sbin/tc"},
 			[][]string{{"-s", "qdisc", "show", "dev", "eth0"}, {"-s", "class", "show", "dev", "eth0"}},
-			"",
+			"qdiscOutput",
 			"",
 			fmt.Errorf("an error"),
 		},
@@ -272,10 +388,11 @@ func TestTcParserExecuteTc(t *testing.T) {
 		},
 	}
 
-	o := &TcParserOptions{}
+	// The fakeExecuter returns the outputs in order, so the commands must run one after the other.
+	o := &TcParserOptions{
+		MaxCommands: 1,
+	}
 	var p *tcParser
-	var qdiscOutput, classOutput string
-	var err error
 	for i, params := range testData {
 		fs := &fakeSyslog{}
 		fe := &fakeExecuter{
@@ -287,12 +404,17 @@ func TestTcParserExecuteTc(t *testing.T) {
 			options:  o,
 			executer: fe,
 		}
-		qdiscOutput, classOutput, err = p.executeTc(params.iface)
-		if !reflect.DeepEqual(qdiscOutput, params.expectedQdiscOutput) {
-			t.Errorf("TestTcParseriExecuteTc(testCase %d) qdiscOutput got: '%v' want: '%v'", i, qdiscOutput, params.expectedQdiscOutput)
+		outputs := p.collectTc(context.Background(), []ifaceOutput{{iface: params.iface}})
+		got, err := readClose(outputs[0].qdiscOutput)
+		if got != params.expectedQdiscOutput {
+			t.Errorf("TestTcParseriExecuteTc(testCase %d) qdiscOutput got: '%v' want: '%v'", i, got, params.expectedQdiscOutput)
 		}
-		if !reflect.DeepEqual(classOutput, params.expectedClassOutput) {
-			t.Errorf("TestTcParseriExecuteTc(testCase %d) classOutput got: '%v' want: '%v'", i, classOutput, params.expectedClassOutput)
+		got, classErr := readClose(outputs[0].classOutput)
+		if err == nil {
+			err = classErr
+		}
+		if got != params.expectedClassOutput {
+			t.Errorf("TestTcParseriExecuteTc(testCase %d) classOutput got: '%v' want: '%v'", i, got, params.expectedClassOutput)
 		}
 		if !reflect.DeepEqual(err, params.expectedErr) {
 			t.Errorf("TestTcParseriExecuteTc(testCase %d) err got: '%v' want: '%v'", i, err, params.expectedErr)
@@ -306,35 +428,202 @@ func TestTcParserExecuteTc(t *testing.T) {
 	}
 }
 
-// fakeSnmp implements snmpHandler interface and is used in tests.
-type fakeSnmp struct {
-	// lockCount is the number of times that lock() was called.
-	lockCount int
+// fakeIfaceReader implements the ifaceReader interface and is used in tests.
+type fakeIfaceReader struct {
+	// index is the ifIndex returned for every interface.
+	index int
+
+	// err is the error returned for every interface.
+	err error
+
+	// ifaces are the interfaces returned by list().
+	ifaces []string
+
+	// listErr is the error returned by list().
+	listErr error
+
+	// missing are the interfaces reported as not present.
+	missing map[string]bool
+}
+
+func (fi *fakeIfaceReader) ifIndex(iface string) (int, error) {
+	return fi.index, fi.err
+}
+
+func (fi *fakeIfaceReader) list() ([]string, error) {
+	return fi.ifaces, fi.listErr
+}
+
+func (fi *fakeIfaceReader) present(iface string) bool {
+	return !fi.missing[iface]
+}
+
+// fakeLinkWatcher implements linkWatcher and is used in tests.
+type fakeLinkWatcher struct {
+	// subscribeErr is the error returned by subscribe().
+	subscribeErr error
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// closed is set when close() was called.
+	closed bool
+}
+
+func (fw *fakeLinkWatcher) subscribe() error {
+	return fw.subscribeErr
+}
+
+func (fw *fakeLinkWatcher) receive() ([]linkEvent, error) {
+	time.Sleep(time.Millisecond)
+	return nil, nil
+}
+
+func (fw *fakeLinkWatcher) close() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.closed = true
+	return nil
+}
+
+// fakeDataSink implements dataSink and is used in tests.
+type fakeDataSink struct {
+	// beginCount is the number of times that begin() was called.
+	beginCount int
 
-	// unlockCount is the number of times that unlock() was called.
-	unlockCount int
+	// commitCount is the number of times that commit() was called.
+	commitCount int
 
 	// eraseCount is the number of times that erase() was called.
 	eraseCount int
 
 	// data contains the stored data added via addData().
 	data []parsedData
+
+	// warnings contains the warnings added via addWarning().
+	warnings []string
+
+	// groups contains the group data added via addGroupData().
+	groups []parsedData
+
+	// ifaces contains the interface data added via addIfaceData().
+	ifaces []parsedData
+
+	// maintenance contains the values passed to setMaintenance().
+	maintenance []bool
+
+	// xstats contains the xstats added via addXstats() keyed by the tcName.
+	xstats map[string][]xstat
+
+	// health contains the failures and backoff values passed to setHealth().
+	health [][2]int
+
+	// unmatchedLines contains the values passed to setUnmatchedLines().
+	unmatchedLines []int
+
+	// netDev contains the kernel counters added via addNetDevData().
+	netDev []netDevStats
+
+	// conntrack contains the conntrack counters added via addConntrackData().
+	conntrack []parsedData
+
+	// monotonic contains the monotonic timestamps of all the added parsedData, the stored copies don't have them so
+	// that they compare to the expected data.
+	monotonic []time.Duration
+}
+
+// record records the monotonic timestamp of the data and returns a copy without it.
+func (fs *fakeDataSink) record(data *parsedData) parsedData {
+	fs.monotonic = append(fs.monotonic, data.monotonic)
+	stored := *data
+	stored.monotonic = 0
+	return stored
+}
+
+func (fs *fakeDataSink) addNetDevData(stats *netDevStats) {
+	fs.netDev = append(fs.netDev, *stats)
 }
 
-func (fs *fakeSnmp) lock() {
-	fs.lockCount += 1
+func (fs *fakeDataSink) addConntrackData(data *parsedData) {
+	fs.conntrack = append(fs.conntrack, fs.record(data))
 }
 
-func (fs *fakeSnmp) unlock() {
-	fs.unlockCount += 1
+func (fs *fakeDataSink) setHealth(failures int, backoff int) {
+	fs.health = append(fs.health, [2]int{failures, backoff})
 }
 
-func (fs *fakeSnmp) erase() {
+func (fs *fakeDataSink) setUnmatchedLines(lines int) {
+	fs.unmatchedLines = append(fs.unmatchedLines, lines)
+}
+
+func (fs *fakeDataSink) addXstats(name string, xstats []xstat) {
+	if fs.xstats == nil {
+		fs.xstats = make(map[string][]xstat)
+	}
+	fs.xstats[name] = append(fs.xstats[name], xstats...)
+}
+
+func (fs *fakeDataSink) setMaintenance(active bool) {
+	fs.maintenance = append(fs.maintenance, active)
+}
+
+func (fs *fakeDataSink) addGroupData(data *parsedData) {
+	fs.groups = append(fs.groups, fs.record(data))
+}
+
+func (fs *fakeDataSink) addIfaceData(data *parsedData) {
+	fs.ifaces = append(fs.ifaces, fs.record(data))
+}
+
+func (fs *fakeDataSink) begin() {
+	fs.beginCount += 1
+}
+
+func (fs *fakeDataSink) commit() {
+	fs.commitCount += 1
+}
+
+func (fs *fakeDataSink) erase() {
 	fs.eraseCount += 1
 }
 
-func (fs *fakeSnmp) addData(data *parsedData) {
-	fs.data = append(fs.data, *data)
+func (fs *fakeDataSink) addData(data *parsedData) {
+	fs.data = append(fs.data, fs.record(data))
+}
+
+func (fs *fakeDataSink) addWarning(message string) {
+	fs.warnings = append(fs.warnings, message)
+}
+
+func TestTcParserMonotonic(t *testing.T) {
+	qdiscOutput := "qdisc htb 1: root refcnt 2 r2q 10 default 0\n Sent 100 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n"
+	classOutput := "class htb 1:10 parent 1:1 prio 0 rate 1Mbit\n Sent 50 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n"
+	fsn := &fakeDataSink{}
+	p := newTcParser(&TcParserOptions{Ifaces: []string{"eth0"}, MaxCommands: 1, Groups: map[string][]string{"all": {"eth0"}}}, &fakeSyslog{}, fsn)
+	p.executer = &fakeExecuter{output: []string{qdiscOutput, classOutput, qdiscOutput, classOutput}, err: make([]error, 4)}
+	p.ifaceReader = &fakeIfaceReader{index: 2}
+
+	p.parseTc()
+	first := fsn.monotonic
+	fsn.monotonic = nil
+	p.parseTc()
+	second := fsn.monotonic
+
+	// The Qdisc, the Class and the group of each parse cycle are stamped with the timestamp of the cycle.
+	for i, stamps := range [][]time.Duration{first, second} {
+		if len(stamps) != 3 {
+			t.Fatalf("parseTc => got %d stamped parsedData in cycle %d, want: 3", len(stamps), i)
+		}
+		for _, stamp := range stamps {
+			if stamp <= 0 || stamp != stamps[0] {
+				t.Errorf("parseTc => got the timestamps %v in cycle %d, want the same positive one", stamps, i)
+				break
+			}
+		}
+	}
+	if second[0] <= first[0] {
+		t.Errorf("parseTc => got the timestamp %s after %s, want it to increase", second[0], first[0])
+	}
 }
 
 func TestTcParserParse(t *testing.T) {
@@ -344,11 +633,12 @@ func TestTcParserParse(t *testing.T) {
 		classOutputFile string
 		qdiscExecError  error
 		classExecError  error
-		userNameClass   map[string]userClass
+		userNameClass   map[string]UserClass
 		wantLog         []string
 		want            []parsedData
-		wantLockCount   int
-		wantUnlockCount int
+		wantWarnings    []string
+		wantBeginCount  int
+		wantCommitCount int
 		wantEraseCount  int
 	}{
 		{
@@ -357,20 +647,36 @@ func TestTcParserParse(t *testing.T) {
 			classOutputFile: "testdata/tc_class_custom",
 			qdiscExecError:  nil,
 			classExecError:  nil,
-			userNameClass:   map[string]userClass{"1": {1, "username"}},
+			userNameClass:   map[string]UserClass{"1": {1, "username"}},
+			want: []parsedData{
+				{"eth0:1:0", 12548819, 124105, 13, 25, nil, 2, 0},
+				{"eth0:2:0", 12548819, 24106, 128, 29, nil, 2, 0},
+				{"eth0:a:0", 123432, 1027, 11, 2048, nil, 2, 0},
+				{"eth0:6e:0", 9397865, 102745, 0, 0, nil, 2, 0},
+				{"eth0:2:1", 931528, 9571, 127, 25, nil, 2, 0},
+				{"eth0:2:2", 11630676, 114607, 13, 5211, nil, 2, 0},
+				{"eth0:4:1", 11601665, 114364, 0, 0, nil, 2, 0},
+				{"eth0:4:a", 1096857, 7059, 0, 0, nil, 2, 0},
+				{"eth0:4:6e", 256, 13, 7, 0, nil, 2, 0},
+			},
+			wantWarnings:    []string{"eth0:3:1: no statistics found, skipping it"},
+			wantBeginCount:  1,
+			wantCommitCount: 1,
+			wantEraseCount:  1,
+		},
+		{
+			desc:            "kinds with hyphens and digits are parsed",
+			qdiscOutputFile: "testdata/tc_qdisc_kinds",
+			classOutputFile: "testdata/tc_class_kinds",
 			want: []parsedData{
-				{"eth0:1:0", 12548819, 124105, 13, 25, nil},
-				{"eth0:2:0", 12548819, 24106, 128, 29, nil},
-				{"eth0:a:0", 123432, 1027, 11, 2048, nil},
-				{"eth0:6e:0", 9397865, 102745, 0, 0, nil},
-				{"eth0:2:1", 931528, 9571, 127, 25, nil},
-				{"eth0:2:2", 11630676, 114607, 13, 5211, nil},
-				{"eth0:4:1", 11601665, 114364, 0, 0, nil},
-				{"eth0:4:a", 1096857, 7059, 0, 0, nil},
-				{"eth0:4:6e", 256, 13, 7, 0, nil},
-			},
-			wantLockCount:   1,
-			wantUnlockCount: 1,
+				{"eth0:1:0", 1000, 10, 1, 2, nil, 2, 0},
+				{"eth0:2:0", 2000, 20, 3, 4, nil, 2, 0},
+				{"eth0:3:0", 3000, 30, 5, 6, nil, 2, 0},
+				{"eth0:1:10", 500, 5, 0, 1, nil, 2, 0},
+			},
+			wantWarnings:    []string{"eth0: unrecognized header line 'qdisc ? 4: parent 1:3', skipping it"},
+			wantBeginCount:  1,
+			wantCommitCount: 1,
 			wantEraseCount:  1,
 		},
 		{
@@ -379,13 +685,13 @@ func TestTcParserParse(t *testing.T) {
 			classOutputFile: "testdata/tc_class_large_values",
 			qdiscExecError:  nil,
 			classExecError:  nil,
-			userNameClass:   map[string]userClass{"1": {1, "username"}},
+			userNameClass:   map[string]UserClass{"1": {1, "username"}},
 			want: []parsedData{
-				{"eth0:1:0", 4791659924490, 4791659924491, 4791659924492, 4791659924493, nil},
-				{"eth0:2:1", 4791659924495, 4791659924496, 4791659924497, 4791659924498, nil},
+				{"eth0:1:0", 4791659924490, 4791659924491, 4791659924492, 4791659924493, nil, 2, 0},
+				{"eth0:2:1", 4791659924495, 4791659924496, 4791659924497, 4791659924498, nil, 2, 0},
 			},
-			wantLockCount:   1,
-			wantUnlockCount: 1,
+			wantBeginCount:  1,
+			wantCommitCount: 1,
 			wantEraseCount:  1,
 		},
 		{
@@ -394,25 +700,26 @@ func TestTcParserParse(t *testing.T) {
 			classOutputFile: "testdata/tc_class_custom",
 			qdiscExecError:  nil,
 			classExecError:  nil,
-			userNameClass: map[string]userClass{
+			userNameClass: map[string]UserClass{
 				"eth0:4:1": {0, "username"},
 				"eth0:4:a": {1, "username"},
 			},
 			want: []parsedData{
-				{"eth0:1:0", 12548819, 124105, 13, 25, nil},
-				{"eth0:2:0", 12548819, 24106, 128, 29, nil},
-				{"eth0:a:0", 123432, 1027, 11, 2048, nil},
-				{"eth0:6e:0", 9397865, 102745, 0, 0, nil},
-				{"eth0:2:1", 931528, 9571, 127, 25, nil},
-				{"eth0:2:2", 11630676, 114607, 13, 5211, nil},
-				{"eth0:4:1", 11601665, 114364, 0, 0, nil},
-				{"eth0:4:1", 11601665, 114364, 0, 0, &userClass{0, "username"}},
-				{"eth0:4:a", 1096857, 7059, 0, 0, nil},
-				{"eth0:4:a", 1096857, 7059, 0, 0, &userClass{1, "username"}},
-				{"eth0:4:6e", 256, 13, 7, 0, nil},
-			},
-			wantLockCount:   1,
-			wantUnlockCount: 1,
+				{"eth0:1:0", 12548819, 124105, 13, 25, nil, 2, 0},
+				{"eth0:2:0", 12548819, 24106, 128, 29, nil, 2, 0},
+				{"eth0:a:0", 123432, 1027, 11, 2048, nil, 2, 0},
+				{"eth0:6e:0", 9397865, 102745, 0, 0, nil, 2, 0},
+				{"eth0:2:1", 931528, 9571, 127, 25, nil, 2, 0},
+				{"eth0:2:2", 11630676, 114607, 13, 5211, nil, 2, 0},
+				{"eth0:4:1", 11601665, 114364, 0, 0, nil, 2, 0},
+				{"eth0:4:a", 1096857, 7059, 0, 0, nil, 2, 0},
+				{"eth0:4:6e", 256, 13, 7, 0, nil, 2, 0},
+				{"username", 11601665, 114364, 0, 0, &UserClass{0, "username"}, 0, 0},
+				{"username", 1096857, 7059, 0, 0, &UserClass{1, "username"}, 0, 0},
+			},
+			wantWarnings:    []string{"eth0:3:1: no statistics found, skipping it"},
+			wantBeginCount:  1,
+			wantCommitCount: 1,
 			wantEraseCount:  1,
 		},
 		{
@@ -421,15 +728,15 @@ func TestTcParserParse(t *testing.T) {
 			classOutputFile: "testdata/tc_no_output",
 			qdiscExecError:  nil,
 			classExecError:  nil,
-			userNameClass: map[string]userClass{
+			userNameClass: map[string]UserClass{
 				"eth0:4:1":  {0, "username"},
 				"eth0:4:10": {1, "username"},
 			},
 			want: []parsedData{
-				{"eth0:0:0", 8214, 48, 0, 10, nil},
+				{"eth0:0:0", 8214, 48, 0, 10, nil, 2, 0},
 			},
-			wantLockCount:   1,
-			wantUnlockCount: 1,
+			wantBeginCount:  1,
+			wantCommitCount: 1,
 			wantEraseCount:  1,
 		},
 		{
@@ -438,16 +745,16 @@ func TestTcParserParse(t *testing.T) {
 			classOutputFile: "testdata/tc_class_custom",
 			qdiscExecError:  fmt.Errorf("cannot execute"),
 			classExecError:  nil,
-			userNameClass: map[string]userClass{
+			userNameClass: map[string]UserClass{
 				"eth0:4:1":  {0, "username"},
 				"eth0:4:10": {1, "username"},
 			},
 			wantLog: []string{
-				"parseTc(): Unable to get TC command output, error: cannot execute",
+				"parseTc(): Unable to get TC command output, error: cannot execute. Failed 1 times in a row, next attempt in 5s.",
 			},
 			want:            []parsedData{},
-			wantLockCount:   1,
-			wantUnlockCount: 1,
+			wantBeginCount:  1,
+			wantCommitCount: 1,
 			wantEraseCount:  1,
 		},
 		{
@@ -456,13 +763,13 @@ func TestTcParserParse(t *testing.T) {
 			classOutputFile: "testdata/tc_no_output",
 			qdiscExecError:  nil,
 			classExecError:  nil,
-			userNameClass: map[string]userClass{
+			userNameClass: map[string]UserClass{
 				"eth0:4:1":  {0, "username"},
 				"eth0:4:10": {1, "username"},
 			},
 			want:            []parsedData{},
-			wantLockCount:   1,
-			wantUnlockCount: 1,
+			wantBeginCount:  1,
+			wantCommitCount: 1,
 			wantEraseCount:  1,
 		},
 	}
@@ -471,7 +778,7 @@ func TestTcParserParse(t *testing.T) {
 	for _, tc := range testData {
 		t.Run(tc.desc, func(t *testing.T) {
 			fs := &fakeSyslog{}
-			fsn := &fakeSnmp{}
+			fsn := &fakeDataSink{}
 
 			qdiscFile, err := ioutil.ReadFile(tc.qdiscOutputFile)
 			if err != nil {
@@ -484,9 +791,11 @@ func TestTcParserParse(t *testing.T) {
 			var outputs []string = []string{string(qdiscFile), string(classFile)}
 			var errors []error = []error{tc.qdiscExecError, tc.classExecError}
 
+			// The fakeExecuter returns the outputs in order, so the commands must run one after the other.
 			o := &TcParserOptions{
 				Ifaces:        []string{"eth0"},
 				UserNameClass: tc.userNameClass,
+				MaxCommands:   1,
 			}
 			fe := &fakeExecuter{
 				output: outputs,
@@ -495,8 +804,9 @@ func TestTcParserParse(t *testing.T) {
 			p = &tcParser{
 				logger:        fs,
 				options:       o,
-				snmp:          fsn,
+				sink:          fsn,
 				executer:      fe,
+				ifaceReader:   &fakeIfaceReader{index: 2},
 				reQdiscHeader: regexp.MustCompile(reQdiscHeaderStr),
 				reClassHeader: regexp.MustCompile(reClassHeaderStr),
 				reStats:       regexp.MustCompile(reStatsStr),
@@ -508,11 +818,14 @@ func TestTcParserParse(t *testing.T) {
 			if diff := pretty.Compare(tc.want, fsn.data); diff != "" {
 				t.Errorf("parseTc => unexpected data, diff(-want, +got):\n%s", diff)
 			}
-			if !reflect.DeepEqual(fsn.lockCount, tc.wantLockCount) {
-				t.Errorf("parseTc => wantLockCount got: '%v' want: '%v'", fsn.lockCount, tc.wantLockCount)
+			if diff := pretty.Compare(tc.wantWarnings, fsn.warnings); diff != "" {
+				t.Errorf("parseTc => unexpected warnings, diff(-want, +got):\n%s", diff)
+			}
+			if !reflect.DeepEqual(fsn.beginCount, tc.wantBeginCount) {
+				t.Errorf("parseTc => wantBeginCount got: '%v' want: '%v'", fsn.beginCount, tc.wantBeginCount)
 			}
-			if !reflect.DeepEqual(fsn.unlockCount, tc.wantUnlockCount) {
-				t.Errorf("parseTc => wantUnlockCount got: '%v' want: '%v'", fsn.unlockCount, tc.wantUnlockCount)
+			if !reflect.DeepEqual(fsn.commitCount, tc.wantCommitCount) {
+				t.Errorf("parseTc => wantCommitCount got: '%v' want: '%v'", fsn.commitCount, tc.wantCommitCount)
 			}
 			if !reflect.DeepEqual(fsn.eraseCount, tc.wantEraseCount) {
 				t.Errorf("parseTc => wantEraseCount got: '%v' want: '%v'", fsn.eraseCount, tc.wantEraseCount)
@@ -520,3 +833,1841 @@ func TestTcParserParse(t *testing.T) {
 		})
 	}
 }
+
+func TestTcParserMonitoredIfaces(t *testing.T) {
+	defaultQdisc := func(iface string) string {
+		return "qdisc pfifo_fast 0: dev " + iface + " root refcnt 2 bands 3 priomap  1 2 2 2 1 2 0 0 1 1 1 1 1 1 1 1\n Sent 8214 bytes 48 pkt (dropped 0, overlimits 10 requeues 0)\n"
+	}
+	shapedQdisc := func(iface string) string {
+		return "qdisc htb 1: dev " + iface + " root refcnt 2 r2q 10 default 0 direct_packets_stat 0\n Sent 8214 bytes 48 pkt (dropped 0, overlimits 10 requeues 0)\n"
+	}
+	allQdiscsArgs := []string{"-s", "qdisc", "show"}
+
+	testData := []struct {
+		desc             string
+		ifaces           []string
+		discovered       []string
+		lastDiscovery    time.Time
+		presentIfaces    map[string]bool
+		ifacesChanged    bool
+		listIfaces       []string
+		listErr          error
+		output           []string
+		err              []error
+		want             []string
+		wantExecutedArgs [][]string
+	}{
+		{
+			desc:   "configured interfaces are returned as they are",
+			ifaces: []string{"eth0", "eth1"},
+			want:   []string{"eth0", "eth1"},
+		},
+		{
+			desc:             "discovers interfaces with non-default qdiscs",
+			ifaces:           []string{autoIfaces},
+			listIfaces:       []string{"eth0", "eth1", "ppp0"},
+			output:           []string{defaultQdisc("eth0") + shapedQdisc("eth1") + shapedQdisc("ppp1")},
+			err:              []error{nil},
+			want:             []string{"eth1"},
+			wantExecutedArgs: [][]string{allQdiscsArgs},
+		},
+		{
+			desc:          "uses the previous discovery until discoveryInterval passes",
+			ifaces:        []string{autoIfaces},
+			discovered:    []string{"eth1"},
+			lastDiscovery: time.Now(),
+			want:          []string{"eth1"},
+		},
+		{
+			desc:             "rediscovers once discoveryInterval passes",
+			ifaces:           []string{autoIfaces},
+			discovered:       []string{"eth1"},
+			lastDiscovery:    time.Now().Add(-time.Hour),
+			listIfaces:       []string{"eth0"},
+			output:           []string{shapedQdisc("eth0")},
+			err:              []error{nil},
+			want:             []string{"eth0"},
+			wantExecutedArgs: [][]string{allQdiscsArgs},
+		},
+		{
+			desc:             "rediscovers immediately when link notifications report a change",
+			ifaces:           []string{autoIfaces},
+			discovered:       []string{"eth1"},
+			lastDiscovery:    time.Now(),
+			presentIfaces:    map[string]bool{"eth0": true, "eth1": true},
+			ifacesChanged:    true,
+			listIfaces:       []string{"eth0", "eth1"},
+			output:           []string{shapedQdisc("eth1") + shapedQdisc("eth0")},
+			err:              []error{nil},
+			want:             []string{"eth0", "eth1"},
+			wantExecutedArgs: [][]string{allQdiscsArgs},
+		},
+		{
+			desc:       "expands patterns against the listed interfaces",
+			ifaces:     []string{"eth0", "ppp*", "eth0"},
+			listIfaces: []string{"eth0", "eth1", "ppp0", "ppp1"},
+			want:       []string{"eth0", "ppp0", "ppp1"},
+		},
+		{
+			desc:          "expands patterns against the interfaces known from link notifications",
+			ifaces:        []string{"ppp*"},
+			presentIfaces: map[string]bool{"eth0": true, "ppp1": true},
+			want:          []string{"ppp1"},
+		},
+		{
+			desc:          "skips configured interfaces that are not present",
+			ifaces:        []string{"eth0", "ppp0"},
+			presentIfaces: map[string]bool{"eth0": true},
+			want:          []string{"eth0"},
+		},
+		{
+			desc:          "keeps the previous discovery when interfaces cannot be listed",
+			ifaces:        []string{autoIfaces},
+			discovered:    []string{"eth1"},
+			lastDiscovery: time.Now().Add(-time.Hour),
+			listErr:       fmt.Errorf("cannot list"),
+			want:          []string{"eth1"},
+		},
+		{
+			desc:             "keeps the previous discovery when TC fails",
+			ifaces:           []string{autoIfaces},
+			discovered:       []string{"eth1"},
+			lastDiscovery:    time.Now().Add(-time.Hour),
+			listIfaces:       []string{"eth0"},
+			output:           []string{""},
+			err:              []error{fmt.Errorf("exit status 1")},
+			want:             []string{"eth1"},
+			wantExecutedArgs: [][]string{allQdiscsArgs},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fe := &fakeExecuter{
+				output: tc.output,
+				err:    tc.err,
+			}
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				options: &TcParserOptions{
+					Ifaces: tc.ifaces,
+				},
+				executer: fe,
+				ifaceReader: &fakeIfaceReader{
+					ifaces:  tc.listIfaces,
+					listErr: tc.listErr,
+				},
+				reQdiscHeader:    regexp.MustCompile(reQdiscHeaderStr),
+				discoveredIfaces: tc.discovered,
+				lastDiscovery:    tc.lastDiscovery,
+				presentIfaces:    tc.presentIfaces,
+				ifacesChanged:    tc.ifacesChanged,
+			}
+			got := p.monitoredIfaces()
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("monitoredIfaces => got: %v, want: %v", got, tc.want)
+			}
+			if !reflect.DeepEqual(fe.args, tc.wantExecutedArgs) {
+				t.Errorf("monitoredIfaces => executed args got: %v, want: %v", fe.args, tc.wantExecutedArgs)
+			}
+		})
+	}
+}
+
+func TestTcParserApplyLinkEvents(t *testing.T) {
+	testData := []struct {
+		desc        string
+		ifaces      []string
+		present     map[string]bool
+		events      []linkEvent
+		want        map[string]bool
+		wantChanged bool
+	}{
+		{
+			desc:        "new interface appears",
+			ifaces:      []string{"eth0", "ppp0"},
+			present:     map[string]bool{"eth0": true},
+			events:      []linkEvent{{name: "ppp0"}},
+			want:        map[string]bool{"eth0": true, "ppp0": true},
+			wantChanged: true,
+		},
+		{
+			desc:        "interface disappears",
+			ifaces:      []string{"eth0", "ppp0"},
+			present:     map[string]bool{"eth0": true, "ppp0": true},
+			events:      []linkEvent{{name: "ppp0", removed: true}},
+			want:        map[string]bool{"eth0": true},
+			wantChanged: true,
+		},
+		{
+			desc:    "state change of a present interface",
+			present: map[string]bool{"eth0": true},
+			events:  []linkEvent{{name: "eth0"}},
+			want:    map[string]bool{"eth0": true},
+		},
+		{
+			desc:    "removal of an unknown interface",
+			present: map[string]bool{"eth0": true},
+			events:  []linkEvent{{name: "ppp0", removed: true}},
+			want:    map[string]bool{"eth0": true},
+		},
+		{
+			desc:    "interface not matching the configured interfaces appears",
+			ifaces:  []string{"eth0", "ppp*"},
+			present: map[string]bool{"eth0": true},
+			events:  []linkEvent{{name: "veth1"}},
+			want:    map[string]bool{"eth0": true, "veth1": true},
+		},
+		{
+			desc:        "interface matching a configured pattern appears",
+			ifaces:      []string{"eth0", "ppp*"},
+			present:     map[string]bool{"eth0": true},
+			events:      []linkEvent{{name: "veth1"}, {name: "ppp1"}},
+			want:        map[string]bool{"eth0": true, "veth1": true, "ppp1": true},
+			wantChanged: true,
+		},
+		{
+			desc:        "configured interface disappears",
+			ifaces:      []string{"eth0", "ppp*"},
+			present:     map[string]bool{"eth0": true, "veth1": true},
+			events:      []linkEvent{{name: "eth0", removed: true}},
+			want:        map[string]bool{"veth1": true},
+			wantChanged: true,
+		},
+		{
+			desc:        "any interface may be discovered",
+			ifaces:      []string{autoIfaces},
+			present:     map[string]bool{"eth0": true},
+			events:      []linkEvent{{name: "veth1"}},
+			want:        map[string]bool{"eth0": true, "veth1": true},
+			wantChanged: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := &tcParser{
+				logger:        &fakeSyslog{},
+				options:       &TcParserOptions{Ifaces: tc.ifaces},
+				presentIfaces: tc.present,
+			}
+			gotChanged := p.applyLinkEvents(tc.events)
+			if gotChanged != tc.wantChanged {
+				t.Errorf("applyLinkEvents => changed got: %v, want: %v", gotChanged, tc.wantChanged)
+			}
+			if gotChanged != p.ifacesChanged {
+				t.Errorf("applyLinkEvents => ifacesChanged got: %v, want: %v", p.ifacesChanged, gotChanged)
+			}
+			if !reflect.DeepEqual(p.presentIfaces, tc.want) {
+				t.Errorf("applyLinkEvents => presentIfaces got: %v, want: %v", p.presentIfaces, tc.want)
+			}
+		})
+	}
+}
+
+func TestTcParserIfacePresent(t *testing.T) {
+	fs := &fakeSyslog{}
+	fi := &fakeIfaceReader{}
+	p := &tcParser{
+		logger:      fs,
+		options:     &TcParserOptions{},
+		ifaceReader: fi,
+	}
+
+	steps := []struct {
+		desc    string
+		missing map[string]bool
+		want    bool
+		wantLog []string
+	}{
+		{
+			desc: "interface is present",
+			want: true,
+		},
+		{
+			desc:    "interface disappears",
+			missing: map[string]bool{"ppp0": true},
+			wantLog: []string{"parseTc(): Interface ppp0 isn't present, skipping it until it returns."},
+		},
+		{
+			desc:    "interface is still missing, nothing is logged",
+			missing: map[string]bool{"ppp0": true},
+		},
+		{
+			desc:    "interface returns",
+			want:    true,
+			wantLog: []string{"parseTc(): Interface ppp0 is present again."},
+		},
+	}
+
+	for _, step := range steps {
+		fs.info = nil
+		fi.missing = step.missing
+		if got := p.ifacePresent("ppp0"); got != step.want {
+			t.Errorf("%s: ifacePresent => got: %v, want: %v", step.desc, got, step.want)
+		}
+		if !reflect.DeepEqual(fs.info, step.wantLog) {
+			t.Errorf("%s: ifacePresent => logged: %v, want: %v", step.desc, fs.info, step.wantLog)
+		}
+	}
+}
+
+func TestTcParserResolveUserNameClass(t *testing.T) {
+	ipOutput := `1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+2: eth0    inet 192.168.1.1/24 brd 192.168.1.255 scope global eth0\       valid_lft forever preferred_lft forever
+7: ppp1    inet 10.64.64.64 peer 10.0.0.5/32 scope global ppp1\       valid_lft forever preferred_lft forever
+`
+
+	testData := []struct {
+		desc             string
+		userNameClass    map[string]UserClass
+		encodeIfaceNames bool
+		output           []string
+		err              []error
+		want             map[string]UserClass
+	}{
+		{
+			desc: "no peer addresses configured",
+			userNameClass: map[string]UserClass{
+				"eth0:1:10": {UploadDirection, "user1"},
+			},
+			want: map[string]UserClass{
+				"eth0:1:10": {UploadDirection, "user1"},
+			},
+		},
+		{
+			desc: "peer addresses are resolved to interfaces",
+			userNameClass: map[string]UserClass{
+				"@10.0.0.5:1:10": {UploadDirection, "user1"},
+				"eth0:2:10":      {DownloadDirection, "user1"},
+				"@10.0.0.6:1:10": {UploadDirection, "user2"},
+			},
+			output: []string{ipOutput},
+			err:    []error{nil},
+			want: map[string]UserClass{
+				"ppp1:1:10": {UploadDirection, "user1"},
+				"eth0:2:10": {DownloadDirection, "user1"},
+			},
+		},
+		{
+			desc: "ip command fails",
+			userNameClass: map[string]UserClass{
+				"@10.0.0.5:1:10": {UploadDirection, "user1"},
+				"eth0:2:10":      {DownloadDirection, "user1"},
+			},
+			output: []string{""},
+			err:    []error{fmt.Errorf("cannot execute")},
+			want: map[string]UserClass{
+				"eth0:2:10": {DownloadDirection, "user1"},
+			},
+		},
+		{
+			desc: "encoded interface names are decoded",
+			userNameClass: map[string]UserClass{
+				"eth0.100:1:10":   {UploadDirection, "user1"},
+				"eth0%2E200:1:10": {UploadDirection, "user2"},
+			},
+			want: map[string]UserClass{
+				"eth0.100:1:10": {UploadDirection, "user1"},
+				"eth0.200:1:10": {UploadDirection, "user2"},
+			},
+		},
+		{
+			desc: "interface names are encoded",
+			userNameClass: map[string]UserClass{
+				"eth0.100:1:10":   {UploadDirection, "user1"},
+				"eth0%2E200:1:10": {UploadDirection, "user2"},
+				"eth1:1:10":       {UploadDirection, "user3"},
+			},
+			encodeIfaceNames: true,
+			want: map[string]UserClass{
+				"eth0%2E100:1:10": {UploadDirection, "user1"},
+				"eth0%2E200:1:10": {UploadDirection, "user2"},
+				"eth1:1:10":       {UploadDirection, "user3"},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fe := &fakeExecuter{
+				output: tc.output,
+				err:    tc.err,
+			}
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				options: &TcParserOptions{
+					UserNameClass:    tc.userNameClass,
+					EncodeIfaceNames: tc.encodeIfaceNames,
+				},
+				executer:   fe,
+				rePeerAddr: regexp.MustCompile(rePeerAddrStr),
+			}
+			got := p.resolveUserNameClass()
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("resolveUserNameClass => got: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTcParserResolveAddrUsers(t *testing.T) {
+	eth0Filters := `filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::800 order 2048 key ht 800 bkt 0 flowid 1:10 not_in_hw
+  match 0a000005/ffffffff at 16
+filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::801 order 2049 key ht 800 bkt 0 flowid 1:20 not_in_hw
+  match 0a000006/ffffffff at 16
+filter parent 1: protocol ip pref 1 u32 chain 0 fh 800::802 order 2050 key ht 800 bkt 0 flowid 1:30 not_in_hw
+  match 0a000007/ffffffff at 16
+`
+	eth1Filters := `filter parent 2: protocol ip pref 2 flower chain 0 handle 0x1 classid 2:10
+  eth_type ipv4
+  src_ip 10.0.0.5
+  not_in_hw
+`
+	fe := &fakeExecuter{
+		output: []string{eth0Filters, "", eth1Filters},
+		err:    []error{nil, fmt.Errorf("cannot execute"), nil},
+	}
+	logger := &fakeSyslog{}
+	p := &tcParser{
+		logger:   logger,
+		executer: fe,
+		options: &TcParserOptions{
+			AddrUsers: map[string]string{
+				"10.0.0.5": "user1",
+				"10.0.0.6": "user2",
+			},
+		},
+	}
+	resolved := map[string]UserClass{
+		"eth0:1:20": {DownloadDirection, "user3"},
+	}
+	p.resolveAddrUsers(resolved, []string{"eth0", "ppp0", "eth1"})
+
+	want := map[string]UserClass{
+		"eth0:1:10": {DownloadDirection, "user1"},
+		"eth0:1:20": {DownloadDirection, "user3"},
+		"eth1:2:10": {UploadDirection, "user1"},
+	}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Errorf("resolveAddrUsers => got: %v, want: %v", resolved, want)
+	}
+	wantArgs := [][]string{
+		{"filter", "show", "dev", "eth0"},
+		{"filter", "show", "dev", "ppp0"},
+		{"filter", "show", "dev", "eth1"},
+	}
+	if !reflect.DeepEqual(fe.args, wantArgs) {
+		t.Errorf("resolveAddrUsers => executed: %v, want: %v", fe.args, wantArgs)
+	}
+	if len(logger.err) != 1 {
+		t.Errorf("resolveAddrUsers => logged errors: %q, want one for ppp0", logger.err)
+	}
+}
+
+func TestTcParserParseResolvesUsers(t *testing.T) {
+	testData := []struct {
+		desc          string
+		userNameClass map[string]UserClass
+		wantCommands  int
+		wantCached    bool
+	}{
+		{
+			desc: "users without peer addresses are resolved once",
+			userNameClass: map[string]UserClass{
+				"eth0:1:10": {UploadDirection, "user1"},
+			},
+			wantCached: true,
+		},
+		{
+			desc: "users with peer addresses are resolved in every cycle",
+			userNameClass: map[string]UserClass{
+				"@10.0.0.5:1:10": {UploadDirection, "user1"},
+			},
+			wantCommands: 2,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fe := &fakeExecuter{
+				output: []string{"", ""},
+				err:    []error{nil, nil},
+			}
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				options: &TcParserOptions{
+					Ifaces:        []string{},
+					UserNameClass: tc.userNameClass,
+				},
+				sink:       &fakeDataSink{},
+				executer:   fe,
+				rePeerAddr: regexp.MustCompile(rePeerAddrStr),
+			}
+			p.parseTc()
+			first := reflect.ValueOf(p.userNameClass).Pointer()
+			p.parseTc()
+			if got := len(fe.command); got != tc.wantCommands {
+				t.Errorf("parseTc => executed %d commands, want: %d", got, tc.wantCommands)
+			}
+			if cached := reflect.ValueOf(p.userNameClass).Pointer() == first; cached != tc.wantCached {
+				t.Errorf("parseTc => userNameClass cached: %v, want: %v", cached, tc.wantCached)
+			}
+		})
+	}
+}
+
+func TestTcParserParseDataDuplicateNames(t *testing.T) {
+	qdiscOutput := `qdisc mq 1: root
+ Sent 300 bytes 3 pkt (dropped 0, overlimits 0 requeues 0)
+qdisc fq_codel 0: parent 1:1 limit 10240p flows 1024 quantum 1514 target 5.0ms interval 100.0ms
+ Sent 100 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)
+qdisc fq_codel 0: parent 1:2 limit 10240p flows 1024 quantum 1514 target 5.0ms interval 100.0ms
+ Sent 200 bytes 2 pkt (dropped 0, overlimits 0 requeues 0)
+qdisc fq_codel 0: parent 1:3 limit 10240p flows 1024 quantum 1514 target 5.0ms interval 100.0ms
+ Sent 0 bytes 0 pkt (dropped 0, overlimits 0 requeues 0)
+`
+	fs := &fakeDataSink{}
+	p := &tcParser{
+		logger:  &fakeSyslog{},
+		sink:    fs,
+		options: &TcParserOptions{},
+	}
+	if err := p.parseData(strings.NewReader(qdiscOutput), "eth0", 2, regexp.MustCompile(reQdiscHeaderStr), regexp.MustCompile(reStatsStr)); err != nil {
+		t.Fatalf("parseData => unexpected error: %s", err)
+	}
+	want := []parsedData{
+		{"eth0:1:0", 300, 3, 0, 0, nil, 2, 0},
+		{"eth0:0:0", 100, 1, 0, 0, nil, 2, 0},
+		{"eth0:0:0#2", 200, 2, 0, 0, nil, 2, 0},
+		{"eth0:0:0#3", 0, 0, 0, 0, nil, 2, 0},
+	}
+	if diff := pretty.Compare(want, fs.data); diff != "" {
+		t.Errorf("parseData => unexpected data, diff (-want, +got):\n%s", diff)
+	}
+	wantWarnings := []string{
+		"eth0:0:0: duplicate name, exporting it as eth0:0:0#2",
+		"eth0:0:0: duplicate name, exporting it as eth0:0:0#3",
+	}
+	if !reflect.DeepEqual(fs.warnings, wantWarnings) {
+		t.Errorf("parseData => warnings got: %q, want: %q", fs.warnings, wantWarnings)
+	}
+}
+
+func TestTcParserAddUsers(t *testing.T) {
+	fs := &fakeDataSink{}
+	p := &tcParser{
+		logger: &fakeSyslog{},
+		sink:   fs,
+		userNameClass: map[string]UserClass{
+			"eth0:1:10": {UploadDirection, "user2"},
+			"eth0:1:11": {UploadDirection, "user2"},
+			"eth1:1:10": {UploadDirection, "user1"},
+			"eth1:2:10": {DownloadDirection, "user2"},
+		},
+	}
+	eth0Output := `class htb 1:10 root prio 0 rate 1000Kbit ceil 1000Kbit burst 1600b cburst 1600b
+ Sent 100 bytes 2 pkt (dropped 1, overlimits 3 requeues 0)
+class htb 1:11 root prio 0 rate 1000Kbit ceil 1000Kbit burst 1600b cburst 1600b
+ Sent 50 bytes 1 pkt (dropped 0, overlimits 1 requeues 0)
+`
+	eth1Output := `class htb 1:10 root prio 0 rate 1000Kbit ceil 1000Kbit burst 1600b cburst 1600b
+ Sent 7 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)
+class htb 2:10 root prio 0 rate 1000Kbit ceil 1000Kbit burst 1600b cburst 1600b
+ Sent 300 bytes 3 pkt (dropped 0, overlimits 0 requeues 0)
+`
+	for iface, output := range map[string]string{"eth0": eth0Output, "eth1": eth1Output} {
+		if err := p.parseData(strings.NewReader(output), iface, 0, regexp.MustCompile(reClassHeaderStr), regexp.MustCompile(reStatsStr)); err != nil {
+			t.Fatalf("parseData => unexpected error: %s", err)
+		}
+	}
+	fs.data = nil
+	p.addUsers()
+
+	got := make(map[UserClass]parsedData)
+	for _, data := range fs.data {
+		got[*data.userClass] = data
+	}
+	want := map[UserClass]parsedData{
+		{UploadDirection, "user2"}:   {"user2", 150, 3, 1, 4, &UserClass{UploadDirection, "user2"}, 0, 0},
+		{DownloadDirection, "user2"}: {"user2", 300, 3, 0, 0, &UserClass{DownloadDirection, "user2"}, 0, 0},
+		{UploadDirection, "user1"}:   {"user1", 7, 1, 0, 0, &UserClass{UploadDirection, "user1"}, 0, 0},
+	}
+	if diff := pretty.Compare(want, got); diff != "" {
+		t.Errorf("addUsers => unexpected data, diff (-want, +got):\n%s", diff)
+	}
+}
+
+func TestTcParserParseDataVlanIface(t *testing.T) {
+	classOutput := `class htb 1:10 root prio 0 rate 1000Kbit ceil 1000Kbit burst 1600b cburst 1600b
+ Sent 100 bytes 2 pkt (dropped 0, overlimits 0 requeues 0)
+`
+	testData := []struct {
+		desc             string
+		encodeIfaceNames bool
+		want             []parsedData
+	}{
+		{
+			desc: "plain interface names",
+			want: []parsedData{
+				{"eth0.100:1:10", 100, 2, 0, 0, nil, 5, 0},
+				{"user1", 100, 2, 0, 0, &UserClass{UploadDirection, "user1"}, 0, 0},
+			},
+		},
+		{
+			desc:             "encoded interface names",
+			encodeIfaceNames: true,
+			want: []parsedData{
+				{"eth0%2E100:1:10", 100, 2, 0, 0, nil, 5, 0},
+				{"user1", 100, 2, 0, 0, &UserClass{UploadDirection, "user1"}, 0, 0},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fs := &fakeDataSink{}
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				sink:   fs,
+				options: &TcParserOptions{
+					UserNameClass: map[string]UserClass{
+						"eth0.100:1:10": {UploadDirection, "user1"},
+					},
+					EncodeIfaceNames: tc.encodeIfaceNames,
+				},
+			}
+			p.userNameClass = p.resolveUserNameClass()
+			if err := p.parseData(strings.NewReader(classOutput), "eth0.100", 5, regexp.MustCompile(reClassHeaderStr), regexp.MustCompile(reStatsStr)); err != nil {
+				t.Fatalf("parseData => unexpected error: %s", err)
+			}
+			p.addUsers()
+			if diff := pretty.Compare(tc.want, fs.data); diff != "" {
+				t.Errorf("parseData => unexpected data, diff (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTcParserAddGroups(t *testing.T) {
+	qdiscOutput := `qdisc htb 1: root refcnt 2 r2q 10 default 0 direct_packets_stat 0
+ Sent 1000 bytes 10 pkt (dropped 1, overlimits 2 requeues 0)
+qdisc sfq 10: parent 1:10 limit 127p quantum 1514b
+ Sent 500 bytes 5 pkt (dropped 1, overlimits 0 requeues 0)
+qdisc ingress ffff: parent ffff:fff1 ----------------
+ Sent 300 bytes 3 pkt (dropped 0, overlimits 0 requeues 0)
+`
+	testData := []struct {
+		desc   string
+		groups map[string][]string
+		ifaces []string
+		want   []parsedData
+	}{
+		{
+			desc:   "no groups configured",
+			ifaces: []string{"eth0"},
+		},
+		{
+			desc: "root Qdiscs of the members are summed",
+			groups: map[string][]string{
+				"wan": {"ppp0", "eth1"},
+				"all": {"ppp0", "eth1", "eth2"},
+			},
+			ifaces: []string{"ppp0", "eth1", "eth2"},
+			want: []parsedData{
+				{"all", 3000, 30, 3, 6, nil, 0, 0},
+				{"wan", 2000, 20, 2, 4, nil, 0, 0},
+			},
+		},
+		{
+			desc: "missing members are skipped",
+			groups: map[string][]string{
+				"wan": {"ppp0", "eth1"},
+			},
+			ifaces: []string{"eth1"},
+			want: []parsedData{
+				{"wan", 1000, 10, 1, 2, nil, 0, 0},
+			},
+		},
+		{
+			desc: "groups without any member aren't exported",
+			groups: map[string][]string{
+				"wan": {"ppp0"},
+			},
+			ifaces: []string{"eth1"},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fs := &fakeDataSink{}
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				sink:   fs,
+				options: &TcParserOptions{
+					Groups: tc.groups,
+				},
+				ifaceTotals: make(map[string]sample),
+			}
+			for _, iface := range tc.ifaces {
+				if err := p.parseData(strings.NewReader(qdiscOutput), iface, 0, regexp.MustCompile(reQdiscHeaderStr), regexp.MustCompile(reStatsStr)); err != nil {
+					t.Fatalf("parseData => unexpected error: %s", err)
+				}
+			}
+			p.addGroups()
+			if diff := pretty.Compare(tc.want, fs.groups); diff != "" {
+				t.Errorf("addGroups => unexpected data, diff (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTcParserAddIfaces(t *testing.T) {
+	qdiscOutput := `qdisc htb 1: root refcnt 2 r2q 10 default 0 direct_packets_stat 0
+ Sent 1000 bytes 10 pkt (dropped 1, overlimits 2 requeues 0)
+qdisc sfq 10: parent 1:10 limit 127p quantum 1514b
+ Sent 500 bytes 5 pkt (dropped 1, overlimits 0 requeues 0)
+`
+	classOutput := `class htb 1:1 root rate 10Mbit ceil 10Mbit burst 1600b cburst 1600b
+ Sent 900 bytes 9 pkt (dropped 0, overlimits 0 requeues 0)
+class htb 1:10 parent 1:1 leaf 10: prio 0 rate 5Mbit ceil 10Mbit burst 1600b cburst 1600b
+ Sent 500 bytes 5 pkt (dropped 1, overlimits 1 requeues 0)
+class htb 1:20 parent 1:1 prio 0 rate 5Mbit ceil 10Mbit burst 1600b cburst 1600b
+ Sent 400 bytes 4 pkt (dropped 0, overlimits 3 requeues 0)
+`
+	testData := []struct {
+		desc        string
+		ifaceTotals string
+		want        []parsedData
+	}{
+		{
+			desc: "no interface totals configured",
+		},
+		{
+			desc:        "root Qdiscs are summed",
+			ifaceTotals: ifaceTotalsRoot,
+			want: []parsedData{
+				{"eth0", 1000, 10, 1, 2, nil, 0, 0},
+				{"eth1", 1000, 10, 1, 2, nil, 0, 0},
+			},
+		},
+		{
+			desc:        "leaf Classes are summed",
+			ifaceTotals: ifaceTotalsLeaves,
+			want: []parsedData{
+				{"eth0", 900, 9, 1, 4, nil, 0, 0},
+				{"eth1", 900, 9, 1, 4, nil, 0, 0},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fs := &fakeDataSink{}
+			p := &tcParser{
+				logger:      &fakeSyslog{},
+				sink:        fs,
+				options:     &TcParserOptions{IfaceTotals: tc.ifaceTotals},
+				ifaceTotals: make(map[string]sample),
+			}
+			if tc.ifaceTotals == ifaceTotalsLeaves {
+				p.leafTotals = make(map[string]sample)
+			}
+			for _, iface := range []string{"eth1", "eth0"} {
+				if err := p.parseData(strings.NewReader(qdiscOutput), iface, 0, regexp.MustCompile(reQdiscHeaderStr), regexp.MustCompile(reStatsStr)); err != nil {
+					t.Fatalf("parseData => unexpected error: %s", err)
+				}
+				if err := p.parseData(strings.NewReader(classOutput), iface, 0, regexp.MustCompile(reClassHeaderStr), regexp.MustCompile(reStatsStr)); err != nil {
+					t.Fatalf("parseData => unexpected error: %s", err)
+				}
+			}
+			p.addIfaces()
+			if diff := pretty.Compare(tc.want, fs.ifaces); diff != "" {
+				t.Errorf("addIfaces => unexpected data, diff (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// concurrentExecuter implements commandExecuter, it is safe for concurrent use and returns output based on the interface name.
+type concurrentExecuter struct {
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// running is the number of the commands that were started and not closed yet.
+	running int
+
+	// maxRunning is the maximum number of the commands that were running concurrently.
+	maxRunning int
+
+	// failIface is the interface for which the commands fail.
+	failIface string
+}
+
+func (ce *concurrentExecuter) Execute(ctx context.Context, name string, arg ...string) (io.ReadCloser, error) {
+	ce.mu.Lock()
+	ce.running += 1
+	if ce.running > ce.maxRunning {
+		ce.maxRunning = ce.running
+	}
+	ce.mu.Unlock()
+
+	iface := arg[len(arg)-1]
+	output := &concurrentOutput{Reader: strings.NewReader(fmt.Sprintf("output of %s %s", arg[1], iface)), executer: ce}
+	if iface == ce.failIface {
+		output.err = fmt.Errorf("cannot execute on %s", iface)
+	}
+	return output, nil
+}
+
+// concurrentOutput is the output of a command started by the concurrentExecuter.
+type concurrentOutput struct {
+	io.Reader
+
+	// executer is the executer that started the command.
+	executer *concurrentExecuter
+
+	// err is the error of the command returned by Close.
+	err error
+}
+
+func (co *concurrentOutput) Close() error {
+	// Give the other commands a chance to start while this one runs.
+	time.Sleep(5 * time.Millisecond)
+
+	co.executer.mu.Lock()
+	co.executer.running -= 1
+	co.executer.mu.Unlock()
+	return co.err
+}
+
+func TestTcParserCollectTcConcurrent(t *testing.T) {
+	testData := []struct {
+		desc           string
+		ifaces         []string
+		failIface      string
+		wantErr        bool
+		wantMaxRunning int
+	}{
+		{
+			desc:           "qdisc and class commands run concurrently",
+			ifaces:         []string{"eth0"},
+			wantMaxRunning: 2,
+		},
+		{
+			desc:           "error is returned",
+			ifaces:         []string{"eth0"},
+			failIface:      "eth0",
+			wantErr:        true,
+			wantMaxRunning: 2,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			ce := &concurrentExecuter{failIface: tc.failIface}
+			p := &tcParser{
+				logger:   &fakeSyslog{},
+				options:  &TcParserOptions{},
+				executer: ce,
+			}
+			var ifaces []ifaceOutput
+			for _, iface := range tc.ifaces {
+				ifaces = append(ifaces, ifaceOutput{iface: iface})
+			}
+			for _, output := range p.collectTc(context.Background(), ifaces) {
+				qdiscOutput, qdiscErr := readClose(output.qdiscOutput)
+				classOutput, classErr := readClose(output.classOutput)
+				if (qdiscErr != nil || classErr != nil) != tc.wantErr {
+					t.Fatalf("collectTc => unexpected errors: %v, %v, wantErr: %v", qdiscErr, classErr, tc.wantErr)
+				}
+				if want := "output of qdisc " + output.iface; qdiscOutput != want {
+					t.Errorf("collectTc => qdiscOutput got: %q, want: %q", qdiscOutput, want)
+				}
+				if want := "output of class " + output.iface; classOutput != want {
+					t.Errorf("collectTc => classOutput got: %q, want: %q", classOutput, want)
+				}
+			}
+			if ce.maxRunning != tc.wantMaxRunning {
+				t.Errorf("collectTc => max concurrent commands got: %d, want: %d", ce.maxRunning, tc.wantMaxRunning)
+			}
+		})
+	}
+}
+
+func TestTcParserOptionsMaxCommands(t *testing.T) {
+	testData := []struct {
+		in  int
+		out int
+	}{
+		{0, maxCommands},
+		{-1, maxCommands},
+		{3, 3},
+	}
+
+	var o *TcParserOptions
+	for i, params := range testData {
+		o = &TcParserOptions{
+			MaxCommands: params.in,
+		}
+		if !reflect.DeepEqual(o.maxCommands(), params.out) {
+			t.Errorf("TestTcParserOptionsMaxCommands(testCase %d) got: '%v' want: '%v'", i, o.maxCommands(), params.out)
+		}
+	}
+}
+
+func TestTcParserOptionsCommandTimeout(t *testing.T) {
+	testData := []struct {
+		in  int
+		out time.Duration
+	}{
+		{0, time.Duration(commandTimeout) * time.Second},
+		{-1, time.Duration(commandTimeout) * time.Second},
+		{3, 3 * time.Second},
+	}
+
+	var o *TcParserOptions
+	for i, params := range testData {
+		o = &TcParserOptions{
+			CommandTimeout: params.in,
+		}
+		if !reflect.DeepEqual(o.commandTimeout(), params.out) {
+			t.Errorf("TestTcParserOptionsCommandTimeout(testCase %d) got: '%v' want: '%v'", i, o.commandTimeout(), params.out)
+		}
+	}
+}
+
+func TestTcParserExecuteDeadline(t *testing.T) {
+	fe := &fakeExecuter{
+		output: []string{"output"},
+		err:    []error{nil},
+	}
+	p := &tcParser{
+		options: &TcParserOptions{
+			CommandTimeout: 30,
+		},
+		executer: fe,
+	}
+	before := time.Now()
+	if _, err := p.execute("/sbin/tc", "-s", "qdisc", "show", "dev", "eth0"); err != nil {
+		t.Fatalf("execute => unexpected error: %v", err)
+	}
+	if len(fe.deadlines) != 1 {
+		t.Fatalf("execute => got %d calls to Execute, want 1", len(fe.deadlines))
+	}
+	deadline := fe.deadlines[0]
+	if deadline.Before(before.Add(30*time.Second)) || deadline.After(time.Now().Add(30*time.Second)) {
+		t.Errorf("execute => context deadline got: %v, want 30s after %v", deadline, before)
+	}
+}
+
+func TestSystemCommandExecuteTimeout(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("the sleep command isn't available")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	sc := &systemCommand{}
+	output, err := sc.Execute(ctx, sleep, "10")
+	if err != nil {
+		t.Fatalf("Execute(%s 10) => unexpected error: %s", sleep, err)
+	}
+	if _, err := readClose(output); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Execute(%s 10) => got error %v, want a timeout", sleep, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Execute(%s 10) => the command wasn't killed, took %v", sleep, elapsed)
+	}
+}
+
+func TestSystemCommandExecute(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("the sh command isn't available")
+	}
+	sc := &systemCommand{}
+
+	// The output is streamed while the command runs, closing it early kills the command.
+	output, err := sc.Execute(context.Background(), sh, "-c", "echo first; sleep 10")
+	if err != nil {
+		t.Fatalf("Execute => unexpected error: %s", err)
+	}
+	start := time.Now()
+	if line, err := bufio.NewReader(output).ReadString('\n'); err != nil || line != "first\n" {
+		t.Errorf("Execute => got the first line %q, error %v, want: %q", line, err, "first\n")
+	}
+	if err := output.Close(); err != nil {
+		t.Errorf("Close before the end of the output => unexpected error: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Execute => the command wasn't killed, took %v", elapsed)
+	}
+
+	// The exit status and the standard error are returned by Close.
+	output, err = sc.Execute(context.Background(), sh, "-c", "echo out; echo oops >&2; exit 3")
+	if err != nil {
+		t.Fatalf("Execute => unexpected error: %s", err)
+	}
+	got, err := readClose(output)
+	if got != "out\n" {
+		t.Errorf("Execute => got output %q, want: %q", got, "out\n")
+	}
+	if want := "exit status 3: oops"; err == nil || err.Error() != want {
+		t.Errorf("Close => got error %v, want: %s", err, want)
+	}
+}
+
+func TestTcParserCollectTc(t *testing.T) {
+	testData := []struct {
+		desc           string
+		maxParallel    int
+		maxCommands    int
+		ifaces         []string
+		failIface      string
+		wantMaxRunning int
+		wantErrIfaces  []string
+	}{
+		{
+			desc: "no interfaces",
+		},
+		{
+			desc:           "sequential collection",
+			maxParallel:    1,
+			maxCommands:    1,
+			ifaces:         []string{"eth0", "eth1", "eth2"},
+			wantMaxRunning: 1,
+		},
+		{
+			desc:           "concurrency is bounded by the number of interfaces",
+			maxParallel:    2,
+			ifaces:         []string{"eth0", "eth1", "eth2", "eth3", "eth4"},
+			wantMaxRunning: 4,
+		},
+		{
+			desc:           "concurrency is bounded by the number of commands",
+			maxParallel:    4,
+			maxCommands:    3,
+			ifaces:         []string{"eth0", "eth1", "eth2", "eth3", "eth4"},
+			wantMaxRunning: 3,
+		},
+		{
+			desc:           "errors are kept per interface",
+			maxParallel:    4,
+			ifaces:         []string{"eth0", "eth1", "eth2"},
+			failIface:      "eth1",
+			wantMaxRunning: 6,
+			wantErrIfaces:  []string{"eth1"},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			ce := &concurrentExecuter{failIface: tc.failIface}
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				options: &TcParserOptions{
+					MaxParallel: tc.maxParallel,
+					MaxCommands: tc.maxCommands,
+				},
+				executer:    ce,
+				ifaceReader: &fakeIfaceReader{index: 2},
+			}
+			outputs := p.collectTc(context.Background(), p.resolveIfIndexes(tc.ifaces))
+			if len(outputs) != len(tc.ifaces) {
+				t.Fatalf("collectTc => got %d outputs, want: %d", len(outputs), len(tc.ifaces))
+			}
+			var errIfaces []string
+			for i, output := range outputs {
+				if output.iface != tc.ifaces[i] {
+					t.Errorf("collectTc => output %d is for interface %s, want: %s", i, output.iface, tc.ifaces[i])
+				}
+				qdiscOutput, qdiscErr := readClose(output.qdiscOutput)
+				classOutput, classErr := readClose(output.classOutput)
+				if qdiscErr != nil || classErr != nil {
+					errIfaces = append(errIfaces, output.iface)
+					continue
+				}
+				if want := fmt.Sprintf("output of qdisc %s", output.iface); qdiscOutput != want {
+					t.Errorf("collectTc => qdiscOutput got: %q, want: %q", qdiscOutput, want)
+				}
+				if want := fmt.Sprintf("output of class %s", output.iface); classOutput != want {
+					t.Errorf("collectTc => classOutput got: %q, want: %q", classOutput, want)
+				}
+			}
+			if !reflect.DeepEqual(errIfaces, tc.wantErrIfaces) {
+				t.Errorf("collectTc => errors for interfaces got: %v, want: %v", errIfaces, tc.wantErrIfaces)
+			}
+			if ce.maxRunning > tc.wantMaxRunning {
+				t.Errorf("collectTc => max concurrent executions got: %d, want at most: %d", ce.maxRunning, tc.wantMaxRunning)
+			}
+		})
+	}
+}
+
+func TestTcParserCustomPatterns(t *testing.T) {
+	fs := &fakeDataSink{}
+	p := newTcParser(&TcParserOptions{
+		QdiscPattern: "^qdisc (?P<kind>[a-z0-9_-]+) (?P<qdiscHandle>[0-9a-f]+):",
+		StatsPattern: "^ *Sent (?P<sentBytes>[0-9]+) bytes (?P<sentPkt>[0-9]+) pkts? .*dropped (?P<droppedPkt>[0-9]+), overlimits (?P<overLimitPkt>[0-9]+)",
+	}, &fakeSyslog{}, fs)
+
+	output := `qdisc fq-codel-v2 1: root refcnt 2
+ Sent 100 bytes 2 pkts (hw dropped 1, overlimits 3 requeues 0)
+`
+	if err := p.parseData(strings.NewReader(output), "eth0", 2, p.reQdiscHeader, p.reStats); err != nil {
+		t.Fatalf("parseData => unexpected error: %s", err)
+	}
+	want := []parsedData{{"eth0:1:0", 100, 2, 1, 3, nil, 2, 0}}
+	if !reflect.DeepEqual(fs.data, want) {
+		t.Errorf("parseData => got: %v, want: %v", fs.data, want)
+	}
+}
+
+func TestValidPattern(t *testing.T) {
+	testData := []struct {
+		desc    string
+		pattern string
+		groups  []string
+		wantErr bool
+	}{
+		{
+			desc:    "the default Class pattern",
+			pattern: reClassHeaderStr,
+			groups:  classHeaderGroups,
+		},
+		{
+			desc:    "the default stats pattern",
+			pattern: reStatsStr,
+			groups:  statsGroups,
+		},
+		{
+			desc:    "missing named group",
+			pattern: reQdiscHeaderStr,
+			groups:  classHeaderGroups,
+			wantErr: true,
+		},
+		{
+			desc:    "invalid RE",
+			pattern: "qdisc (?P<qdiscHandle>[0-9a-f+):",
+			groups:  qdiscHeaderGroups,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if err := validPattern(tc.pattern, tc.groups); (err != nil) != tc.wantErr {
+				t.Errorf("validPattern(%q) => got error: %v, want error: %t", tc.pattern, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTcParserCollectAll(t *testing.T) {
+	qdiscs := "qdisc htb 1: dev eth0 root refcnt 2 r2q 10 default 0\n Sent 100 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n" +
+		"qdisc noqueue 0: dev lo root refcnt 2\n Sent 0 bytes 0 pkt (dropped 0, overlimits 0 requeues 0)\n" +
+		"qdisc htb 1: dev eth1 root refcnt 2 r2q 10 default 0\n Sent 200 bytes 2 pkt (dropped 0, overlimits 0 requeues 0)\n"
+	classes := "class htb 1:10 dev eth1 parent 1:1 prio 0 rate 1Mbit\n Sent 150 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n"
+
+	testData := []struct {
+		desc      string
+		ifaces    []string
+		err       error
+		wantQdisc []string
+		wantClass []string
+		wantErr   bool
+	}{
+		{
+			desc:   "output is split by the interfaces",
+			ifaces: []string{"eth0", "eth1", "eth2"},
+			wantQdisc: []string{
+				"qdisc htb 1: dev eth0 root refcnt 2 r2q 10 default 0\n Sent 100 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n",
+				"qdisc htb 1: dev eth1 root refcnt 2 r2q 10 default 0\n Sent 200 bytes 2 pkt (dropped 0, overlimits 0 requeues 0)\n",
+				"",
+			},
+			wantClass: []string{"", classes, ""},
+		},
+		{
+			desc:    "the error is reported for all the interfaces",
+			ifaces:  []string{"eth0", "eth1"},
+			err:     fmt.Errorf("tc failed"),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fe := &fakeExecuter{
+				output: []string{qdiscs, classes},
+				err:    []error{tc.err, tc.err},
+			}
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				options: &TcParserOptions{
+					MaxCommands:      1,
+					SingleInvocation: true,
+				},
+				executer:    fe,
+				ifaceReader: &fakeIfaceReader{index: 2},
+			}
+			outputs := p.collectTc(context.Background(), p.resolveIfIndexes(tc.ifaces))
+			if len(outputs) != 1 || len(outputs[0].members) != len(tc.ifaces) {
+				t.Fatalf("collectTc => got outputs %v, want one with %d members", outputs, len(tc.ifaces))
+			}
+			ifaces, err := bufferOutput(&outputs[0])
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("bufferOutput => got no error, want an error")
+				}
+				return
+			}
+			wantArgs := [][]string{{"-s", "qdisc", "show"}, {"-s", "class", "show"}}
+			if !reflect.DeepEqual(fe.args, wantArgs) {
+				t.Errorf("collectTc => executed with args: %v, want: %v", fe.args, wantArgs)
+			}
+			if err != nil || len(ifaces) != len(tc.ifaces) {
+				t.Fatalf("bufferOutput => got %d interfaces, error %v, want: %d, no error", len(ifaces), err, len(tc.ifaces))
+			}
+			for i, iface := range ifaces {
+				if iface.Iface != tc.ifaces[i] || iface.IfIndex != 2 {
+					t.Errorf("bufferOutput => interface %d got: %s, %d, want: %s, 2", i, iface.Iface, iface.IfIndex, tc.ifaces[i])
+					continue
+				}
+				if iface.Qdisc != tc.wantQdisc[i] {
+					t.Errorf("bufferOutput => Qdisc output of %s got: %q, want: %q", iface.Iface, iface.Qdisc, tc.wantQdisc[i])
+				}
+				if iface.Class != tc.wantClass[i] {
+					t.Errorf("bufferOutput => Class output of %s got: %q, want: %q", iface.Iface, iface.Class, tc.wantClass[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTcParserParseAll(t *testing.T) {
+	fs := &fakeDataSink{}
+	p := newTcParser(&TcParserOptions{}, &fakeSyslog{}, fs)
+	output := "qdisc htb 1: dev eth0 root refcnt 2 r2q 10 default 0\n Sent 100 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n" +
+		"qdisc htb 1: dev lo root refcnt 2\n Sent 5 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n" +
+		"qdisc htb 2: root refcnt 2\n Sent 7 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n" +
+		"qdisc htb 1: dev eth1 root refcnt 2 r2q 10 default 0\n Sent 200 bytes 2 pkt (dropped 0, overlimits 0 requeues 0)\n" +
+		"qdisc sfq 10: dev eth1 parent 1:10 limit 127p\n Sent 150 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n"
+	members := []ifaceOutput{{iface: "eth0", ifIndex: 2}, {iface: "eth1", ifIndex: 3}}
+	if err := p.parseAll(strings.NewReader(output), members, p.reQdiscHeader); err != nil {
+		t.Fatalf("parseAll => unexpected error: %s", err)
+	}
+	var got []string
+	for _, data := range fs.data {
+		got = append(got, fmt.Sprintf("%s %d %d", data.name, data.sentBytes, data.ifIndex))
+	}
+	want := []string{"eth0:1:0 100 2", "eth1:1:0 200 3", "eth1:10:0 150 3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAll => got data: %v, want: %v", got, want)
+	}
+}
+
+func TestAllIfacesArgs(t *testing.T) {
+	testData := []struct {
+		in   []string
+		want []string
+	}{
+		{[]string{"-s", "qdisc", "show", "dev"}, []string{"-s", "qdisc", "show"}},
+		{[]string{"-s", "-d", "class", "show"}, []string{"-s", "-d", "class", "show"}},
+		{nil, nil},
+	}
+
+	for _, tc := range testData {
+		if got := allIfacesArgs(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("allIfacesArgs(%v) => got: %v, want: %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestTcParserParseBlackout(t *testing.T) {
+	fsn := &fakeDataSink{}
+	fe := &fakeExecuter{
+		output: []string{"", ""},
+		err:    []error{nil, nil},
+	}
+	p := &tcParser{
+		logger: &fakeSyslog{},
+		options: &TcParserOptions{
+			Ifaces:    []string{"eth0"},
+			Blackouts: []blackoutWindow{{0, minutesPerDay}},
+		},
+		sink:          fsn,
+		executer:      fe,
+		ifaceReader:   &fakeIfaceReader{index: 2},
+		reQdiscHeader: regexp.MustCompile(reQdiscHeaderStr),
+		reClassHeader: regexp.MustCompile(reClassHeaderStr),
+		reStats:       regexp.MustCompile(reStatsStr),
+	}
+
+	// Two cycles inside the blackout window.
+	p.parseTc()
+	p.parseTc()
+	if fsn.eraseCount != 0 {
+		t.Errorf("parseTc in blackout => eraseCount got: %d, want: 0", fsn.eraseCount)
+	}
+	if len(fe.command) != 0 {
+		t.Errorf("parseTc in blackout => executed %v, want no commands", fe.command)
+	}
+
+	// The blackout window ended.
+	p.options.Blackouts = nil
+	p.parseTc()
+	if fsn.eraseCount != 1 {
+		t.Errorf("parseTc after blackout => eraseCount got: %d, want: 1", fsn.eraseCount)
+	}
+	if want := []bool{true, true, false}; !reflect.DeepEqual(fsn.maintenance, want) {
+		t.Errorf("parseTc => setMaintenance calls got: %v, want: %v", fsn.maintenance, want)
+	}
+	if fsn.beginCount != 3 || fsn.commitCount != 3 {
+		t.Errorf("parseTc => beginCount: %d, commitCount: %d, want: 3, 3", fsn.beginCount, fsn.commitCount)
+	}
+}
+
+func TestTcParserIdlePause(t *testing.T) {
+	fsn := &fakeDataSink{}
+	fe := &fakeExecuter{
+		output: []string{"", "", "", ""},
+		err:    []error{nil, nil, nil, nil},
+	}
+	p := &tcParser{
+		logger: &fakeSyslog{},
+		options: &TcParserOptions{
+			Ifaces:    []string{"eth0"},
+			IdlePause: 10,
+		},
+		sink:          fsn,
+		executer:      fe,
+		ifaceReader:   &fakeIfaceReader{index: 2},
+		reQdiscHeader: regexp.MustCompile(reQdiscHeaderStr),
+		reClassHeader: regexp.MustCompile(reClassHeaderStr),
+		reStats:       regexp.MustCompile(reStatsStr),
+		resume:        make(chan struct{}, 1),
+	}
+
+	// The last request arrived recently.
+	p.lastRequest.Store(time.Now().Add(-5 * time.Minute).UnixNano())
+	p.parseTc()
+	if fsn.eraseCount != 1 {
+		t.Errorf("parseTc after a recent request => eraseCount got: %d, want: 1", fsn.eraseCount)
+	}
+
+	// No requests for longer than the IdlePause.
+	p.lastRequest.Store(time.Now().Add(-10 * time.Minute).UnixNano())
+	p.parseTc()
+	p.parseTc()
+	if fsn.eraseCount != 1 {
+		t.Errorf("parseTc while idle => eraseCount got: %d, want: 1", fsn.eraseCount)
+	}
+	if !p.status().idle {
+		t.Errorf("status while idle => got idle: false, want: true")
+	}
+
+	// A request wakes up the parse cycles.
+	p.requested()
+	select {
+	case <-p.resume:
+	default:
+		t.Errorf("requested while idle => the parse cycles weren't resumed")
+	}
+	p.parseTc()
+	if fsn.eraseCount != 2 {
+		t.Errorf("parseTc after a request => eraseCount got: %d, want: 2", fsn.eraseCount)
+	}
+	if p.status().idle {
+		t.Errorf("status after a request => got idle: true, want: false")
+	}
+}
+
+func TestTcParserOptionsMaxBackoff(t *testing.T) {
+	testData := []struct {
+		in  int
+		out int
+	}{
+		{0, maxBackoff},
+		{-1, maxBackoff},
+		{60, 60},
+	}
+
+	var o *TcParserOptions
+	for i, params := range testData {
+		o = &TcParserOptions{
+			MaxBackoff: params.in,
+		}
+		if !reflect.DeepEqual(o.maxBackoff(), params.out) {
+			t.Errorf("TestTcParserOptionsMaxBackoff(testCase %d) got: '%v' want: '%v'", i, o.maxBackoff(), params.out)
+		}
+	}
+}
+
+func TestTcParserRefresh(t *testing.T) {
+	testData := []struct {
+		desc         string
+		lastParse    time.Time
+		inBlackout   bool
+		skipCycles   int
+		wantCommands int
+	}{
+		{
+			desc:      "fresh data isn't refreshed",
+			lastParse: time.Now(),
+		},
+		{
+			desc:         "stale data is refreshed",
+			lastParse:    time.Now().Add(-time.Minute),
+			wantCommands: 2,
+		},
+		{
+			desc:         "data is refreshed if it wasn't parsed yet",
+			wantCommands: 2,
+		},
+		{
+			desc:       "data isn't refreshed during a blackout window",
+			lastParse:  time.Now().Add(-time.Minute),
+			inBlackout: true,
+		},
+		{
+			desc:       "data isn't refreshed while backing off",
+			lastParse:  time.Now().Add(-time.Minute),
+			skipCycles: 1,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fe := &fakeExecuter{
+				output: []string{"", ""},
+				err:    []error{nil, nil},
+			}
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				options: &TcParserOptions{
+					Ifaces:      []string{"eth0"},
+					MaxCommands: 1,
+				},
+				sink:          &fakeDataSink{},
+				executer:      fe,
+				ifaceReader:   &fakeIfaceReader{index: 2},
+				reQdiscHeader: regexp.MustCompile(reQdiscHeaderStr),
+				reClassHeader: regexp.MustCompile(reClassHeaderStr),
+				reStats:       regexp.MustCompile(reStatsStr),
+				lastParse:     tc.lastParse,
+				inBlackout:    tc.inBlackout,
+				skipCycles:    tc.skipCycles,
+			}
+			p.refresh(30 * time.Second)
+			if len(fe.command) != tc.wantCommands {
+				t.Errorf("refresh => executed %d commands, want: %d", len(fe.command), tc.wantCommands)
+			}
+		})
+	}
+}
+
+func TestTcParserBackoffCycles(t *testing.T) {
+	testData := []struct {
+		desc     string
+		failures int
+		want     int
+	}{
+		{"first failure retries in the next cycle", 1, 0},
+		{"second failure skips one cycle", 2, 1},
+		{"third failure skips three cycles", 3, 3},
+		{"backoff is capped", 10, 5},
+	}
+
+	p := &tcParser{
+		options: &TcParserOptions{
+			ParseInterval: 5 * time.Second,
+			MaxBackoff:    30,
+		},
+	}
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := p.backoffCycles(tc.failures); got != tc.want {
+				t.Errorf("backoffCycles(%d) => got: %d, want: %d", tc.failures, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTcParserParseBackoff(t *testing.T) {
+	fsn := &fakeDataSink{}
+	tcErr := fmt.Errorf("tc failed")
+	fe := &fakeExecuter{
+		output: []string{"", "", "", "", ""},
+		err:    []error{tcErr, tcErr, tcErr, nil, nil},
+	}
+	p := &tcParser{
+		logger: &fakeSyslog{},
+		options: &TcParserOptions{
+			ParseInterval: 5 * time.Second,
+			Ifaces:        []string{"eth0"},
+			MaxCommands:   1,
+		},
+		sink:          fsn,
+		executer:      fe,
+		ifaceReader:   &fakeIfaceReader{index: 2},
+		reQdiscHeader: regexp.MustCompile(reQdiscHeaderStr),
+		reClassHeader: regexp.MustCompile(reClassHeaderStr),
+		reStats:       regexp.MustCompile(reStatsStr),
+	}
+
+	// The first failure retries in the next cycle, the second one skips a cycle.
+	for i := 0; i < 3; i++ {
+		p.parseTc()
+	}
+	if got, want := len(fe.command), 2; got != want {
+		t.Errorf("parseTc while backing off => executed %d commands, want: %d", got, want)
+	}
+	if want := [][2]int{{1, 5}, {2, 10}}; !reflect.DeepEqual(fsn.health, want) {
+		t.Errorf("parseTc while backing off => setHealth calls got: %v, want: %v", fsn.health, want)
+	}
+
+	// The third failure, then three skipped cycles and a success.
+	for i := 0; i < 5; i++ {
+		p.parseTc()
+	}
+	if got, want := len(fe.command), 5; got != want {
+		t.Errorf("parseTc after backing off => executed %d commands, want: %d", got, want)
+	}
+	if want := [][2]int{{1, 5}, {2, 10}, {3, 20}, {0, 0}}; !reflect.DeepEqual(fsn.health, want) {
+		t.Errorf("parseTc after backing off => setHealth calls got: %v, want: %v", fsn.health, want)
+	}
+}
+
+func TestNewTcParser(t *testing.T) {
+	testData := []struct {
+		desc    string
+		logger  Logger
+		opts    []TcParserOption
+		wantErr string
+	}{
+		{
+			desc:   "sink with default options",
+			logger: &fakeSyslog{},
+			opts:   []TcParserOption{WithSink(&recordingSink{})},
+		},
+		{
+			desc:   "prometheus file without sinks",
+			logger: &fakeSyslog{},
+			opts:   []TcParserOption{WithTcParserOptions(&TcParserOptions{Prometheus: &PrometheusOptions{File: "tc.prom"}})},
+		},
+		{
+			desc:    "no logger",
+			opts:    []TcParserOption{WithSink(&recordingSink{})},
+			wantErr: "a Logger is required",
+		},
+		{
+			desc:    "no sinks",
+			logger:  &fakeSyslog{},
+			opts:    []TcParserOption{WithTcParserOptions(&TcParserOptions{})},
+			wantErr: "the parsed data has no destination, at least one sink is required",
+		},
+		{
+			desc:    "nil options",
+			logger:  &fakeSyslog{},
+			opts:    []TcParserOption{WithTcParserOptions(nil), WithSink(&recordingSink{})},
+			wantErr: "the TcParserOptions must not be nil",
+		},
+		{
+			desc:    "nil snmp",
+			logger:  &fakeSyslog{},
+			opts:    []TcParserOption{WithSnmp(nil)},
+			wantErr: "the SNMP handler must not be nil",
+		},
+		{
+			desc:    "nil sink",
+			logger:  &fakeSyslog{},
+			opts:    []TcParserOption{WithSink(nil)},
+			wantErr: "the Sink must not be nil",
+		},
+		{
+			desc:    "invalid options",
+			logger:  &fakeSyslog{},
+			opts:    []TcParserOption{WithTcParserOptions(&TcParserOptions{ParseInterval: -5 * time.Second}), WithSink(&recordingSink{})},
+			wantErr: "the parse interval must not be negative, got -5s",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			tp, err := NewTcParser(tc.logger, tc.opts...)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("NewTcParser => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewTcParser => unexpected error: %s", err)
+			}
+			if tp == nil {
+				t.Errorf("NewTcParser => got nil tcParser")
+			}
+		})
+	}
+}
+
+func TestNewTcParserWithSnmp(t *testing.T) {
+	s := &snmp{}
+	tp, err := NewTcParser(&fakeSyslog{}, WithSnmp(s))
+	if err != nil {
+		t.Fatalf("NewTcParser => unexpected error: %s", err)
+	}
+	if s.refresher != tp {
+		t.Errorf("NewTcParser => the snmp refreshes through %v, want: %v", s.refresher, tp)
+	}
+}
+
+func TestTcParserStartStop(t *testing.T) {
+	sink := &recordingSink{}
+	tp, err := NewTcParser(&fakeSyslog{}, WithTcParserOptions(&TcParserOptions{
+		ParseInterval: time.Hour,
+		Ifaces:        []string{"eth0"},
+		MaxCommands:   1,
+	}), WithSink(sink))
+	if err != nil {
+		t.Fatalf("NewTcParser => unexpected error: %s", err)
+	}
+	fw := &fakeLinkWatcher{}
+	tp.linkWatcher = fw
+	tp.executer = &fakeExecuter{
+		output: []string{"", ""},
+		err:    []error{nil, nil},
+	}
+	tp.ifaceReader = &fakeIfaceReader{}
+
+	if len(sink.calls) != 0 {
+		t.Errorf("NewTcParser => the sink got calls before Start: %v", sink.calls)
+	}
+	if err := tp.Start(context.Background()); err != nil {
+		t.Fatalf("Start => unexpected error: %s", err)
+	}
+	<-tp.Ready()
+	if want := []string{"Begin", "Commit"}; !reflect.DeepEqual(sink.calls, want) {
+		t.Errorf("Start => got calls: %v, want: %v", sink.calls, want)
+	}
+	if err := tp.Start(context.Background()); err == nil {
+		t.Errorf("Start => expected an error when started twice")
+	}
+
+	tp.Stop()
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if !fw.closed {
+		t.Errorf("Stop => the link watcher wasn't closed")
+	}
+}
+
+// blockingExecuter implements the commandExecuter interface and is used in tests, the commands run until they are
+// canceled.
+type blockingExecuter struct{}
+
+func (be *blockingExecuter) Execute(ctx context.Context, name string, arg ...string) (io.ReadCloser, error) {
+	return &blockingOutput{ctx: ctx}, nil
+}
+
+// blockingOutput is the output of a command started by the blockingExecuter, reading it blocks until the command is
+// canceled.
+type blockingOutput struct {
+	ctx context.Context
+}
+
+func (bo *blockingOutput) Read(p []byte) (int, error) {
+	<-bo.ctx.Done()
+	return 0, io.EOF
+}
+
+func (bo *blockingOutput) Close() error {
+	return bo.ctx.Err()
+}
+
+func TestTcParserStopCancelsCommands(t *testing.T) {
+	sink := &recordingSink{}
+	tp, err := NewTcParser(&fakeSyslog{}, WithTcParserOptions(&TcParserOptions{
+		ParseInterval:  time.Hour,
+		Ifaces:         []string{"eth0"},
+		MaxCommands:    1,
+		CommandTimeout: 3600,
+	}), WithSink(sink))
+	if err != nil {
+		t.Fatalf("NewTcParser => unexpected error: %s", err)
+	}
+	tp.linkWatcher = &fakeLinkWatcher{subscribeErr: fmt.Errorf("not supported")}
+	tp.executer = &blockingExecuter{}
+	tp.ifaceReader = &fakeIfaceReader{}
+
+	// The initial parse cycle runs until the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tp.Start(ctx); err != nil {
+		t.Fatalf("Start => unexpected error: %s", err)
+	}
+	tp.Stop()
+	if tp.failures != 0 {
+		t.Errorf("Stop => got %d failures, want the canceled commands not counted", tp.failures)
+	}
+	logger := tp.logger.(*fakeSyslog)
+	if len(logger.err) != 0 {
+		t.Errorf("Stop => unexpected errors logged: %v", logger.err)
+	}
+}
+
+func TestTcParserParseDataUnmatchedHeader(t *testing.T) {
+	fs := &fakeSyslog{}
+	p := &tcParser{
+		logger:  fs,
+		options: &TcParserOptions{Debug: true},
+		sink:    &fakeDataSink{},
+	}
+	p.debug.configure(true)
+	output := "qdisc ? 4: parent 1:3\n Sent 4000 bytes 40 pkt (dropped 7, overlimits 8 requeues 0)\n backlog 0b 0p requeues 0\n" +
+		"qdisc htb 5: root\n Sent 4000 bytes (dropped 7)\nError: something went wrong.\n\n"
+	if err := p.parseData(strings.NewReader(output), "eth0", 2, regexp.MustCompile(reQdiscHeaderStr), regexp.MustCompile(reStatsStr)); err != nil {
+		t.Fatalf("parseData => unexpected error: %s", err)
+	}
+	if p.unmatchedLines != 3 {
+		t.Errorf("parseData => unmatchedLines got: %d, want: 3", p.unmatchedLines)
+	}
+	var want []string
+	for _, line := range []string{"qdisc ? 4: parent 1:3", "Sent 4000 bytes (dropped 7)", "Error: something went wrong."} {
+		want = append(want, fmt.Sprintf("parseData(): The line '%s' on interface eth0 matches neither the header pattern %s nor the statistics pattern %s.", line, reQdiscHeaderStr, reStatsStr))
+	}
+	if !reflect.DeepEqual(fs.info, want) {
+		t.Errorf("parseData => logged: %q, want: %q", fs.info, want)
+	}
+}
+
+func TestTcParserParseDataReadError(t *testing.T) {
+	p := &tcParser{
+		logger: &fakeSyslog{},
+		sink:   &fakeDataSink{},
+	}
+	readErr := fmt.Errorf("broken pipe")
+	if err := p.parseData(iotest.ErrReader(readErr), "eth0", 2, regexp.MustCompile(reQdiscHeaderStr), regexp.MustCompile(reStatsStr)); err != readErr {
+		t.Errorf("parseData => err got: %v, want: %v", err, readErr)
+	}
+}
+
+func TestTcParserParseDataXstats(t *testing.T) {
+	testData := []struct {
+		desc       string
+		outputFile string
+		reHeader   string
+		xstats     bool
+		want       map[string][]xstat
+	}{
+		{
+			desc:       "xstats aren't parsed unless enabled",
+			outputFile: "testdata/tc_qdisc_xstats",
+			reHeader:   reQdiscHeaderStr,
+		},
+		{
+			desc:       "codel and fq_codel Qdisc xstats",
+			outputFile: "testdata/tc_qdisc_xstats",
+			reHeader:   reQdiscHeaderStr,
+			xstats:     true,
+			want: map[string][]xstat{
+				"eth0:10:0": {
+					{"maxpacket", "1514"}, {"drop_overlimit", "2"}, {"new_flow_count", "42"}, {"ecn_mark", "7"},
+					{"new_flows_len", "0"}, {"old_flows_len", "1"},
+				},
+				"eth0:20:0": {
+					{"count", "1"}, {"lastcount", "0"}, {"ldelay", "2us"}, {"drop_next", "-3us"},
+					{"maxpacket", "256"}, {"ecn_mark", "0"}, {"drop_overlimit", "0"},
+				},
+			},
+		},
+		{
+			desc:       "HTB Class xstats",
+			outputFile: "testdata/tc_class_custom",
+			reHeader:   reClassHeaderStr,
+			xstats:     true,
+			want: map[string][]xstat{
+				"eth0:4:1": {
+					{"lended", "4250"}, {"borrowed", "0"}, {"giants", "0"},
+					{"tokens", "444750"}, {"ctokens", "444750"},
+				},
+				"eth0:4:a": {
+					{"lended", "6865"}, {"borrowed", "194"}, {"giants", "0"},
+					{"tokens", "1335828"}, {"ctokens", "486937"},
+				},
+				"eth0:4:6e": {
+					{"lended", "6865"}, {"borrowed", "194"}, {"giants", "0"},
+					{"tokens", "1335828"}, {"ctokens", "486937"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			output, err := ioutil.ReadFile(tc.outputFile)
+			if err != nil {
+				t.Fatalf("ReadFile %s => unexpected err: %s", tc.outputFile, err)
+			}
+			fs := &fakeDataSink{}
+			p := &tcParser{
+				logger: &fakeSyslog{},
+				sink:   fs,
+				options: &TcParserOptions{
+					Xstats: tc.xstats,
+				},
+			}
+			if err := p.parseData(bytes.NewReader(output), "eth0", 2, regexp.MustCompile(tc.reHeader), regexp.MustCompile(reStatsStr)); err != nil {
+				t.Fatalf("parseData => unexpected error: %s", err)
+			}
+			if diff := pretty.Compare(tc.want, fs.xstats); diff != "" {
+				t.Errorf("parseData => unexpected xstats, diff (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// htbClassOutput generates the TC output with statistics of numClasses HTB Classes.
+func htbClassOutput(numClasses int) string {
+	var b strings.Builder
+	for i := 1; i <= numClasses; i++ {
+		fmt.Fprintf(&b, "class htb 1:%x parent 1:1 prio 0 rate 1000Kbit ceil 1000Kbit burst 1600b cburst 1600b\n", i)
+		fmt.Fprintf(&b, " Sent %d bytes %d pkt (dropped 0, overlimits 0 requeues 0)\n", i*1500, i)
+		b.WriteString(" rate 0bit 0pps backlog 0b 0p requeues 0\n")
+		b.WriteString(" lended: 4250 borrowed: 0 giants: 0\n")
+		b.WriteString(" tokens: 444750 ctokens: 444750\n\n")
+	}
+	return b.String()
+}
+
+func BenchmarkTcParserParseData10kClasses(b *testing.B) {
+	output := htbClassOutput(10000)
+	s := &snmp{
+		snmpTalker: &testTalker{},
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{},
+		identity:   myName,
+	}
+	p := &tcParser{
+		logger:        &fakeSyslog{},
+		sink:          s,
+		options:       &TcParserOptions{},
+		userNameClass: userNameClass,
+	}
+	reHeader := regexp.MustCompile(reClassHeaderStr)
+	reData := regexp.MustCompile(reStatsStr)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.begin()
+		s.erase()
+		if err := p.parseData(strings.NewReader(output), "eth0", 2, reHeader, reData); err != nil {
+			b.Fatalf("parseData => unexpected error: %s", err)
+		}
+		s.commit()
+	}
+}
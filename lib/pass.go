@@ -0,0 +1,79 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+pass.go answers a single request of the simple pass protocol of the SNMP daemon, for the environments that don't
+allow the long running pass_persist scripts. The SNMP daemon runs tc_reader for every request:
+tc_reader -g .1.3.6.1.4.1.2021.255.3.1
+tc_reader -n .1.3.6.1.4.1.2021.255.3
+
+The answer is the OID, the object type and the value on separate lines, nothing if there is no such OID. The data is
+read from the CacheFile, which a tc_reader running as an exporter keeps up to date.
+*/
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The requests of the pass protocol.
+const (
+	// PassGet requests the OID itself.
+	PassGet = getCommand
+
+	// PassGetNext requests the OID that follows the OID.
+	PassGetNext = getNextCommand
+)
+
+// Pass answers a request of the pass protocol, PassGet or PassGetNext, from the data persisted in the CacheFile of the
+// snmpOptions. Nothing is written if there is no data for the request. Returns an error if the CacheFile isn't set or
+// can't be read.
+func Pass(snmpOptions *SnmpOptions, logger Logger, request, oid string, w io.Writer) error {
+	if snmpOptions == nil || snmpOptions.CacheFile == emptyString {
+		return errors.New("the pass protocol is answered from the cacheFile, which isn't set")
+	}
+	if _, err := os.Stat(snmpOptions.CacheFile); err != nil {
+		return fmt.Errorf("no data is persisted in the cacheFile: %s", err)
+	}
+	s, err := NewSnmp(logger, WithSnmpOptions(snmpOptions))
+	if err != nil {
+		return err
+	}
+	return s.pass(request, oid, w)
+}
+
+// pass writes the answer to a request of the pass protocol.
+func (s *snmp) pass(request, oid string, w io.Writer) error {
+	var data *snmpData
+	var ok bool
+	switch request {
+	case PassGet:
+		data, ok = s.get(oid)
+	case PassGetNext:
+		data, ok = s.next(oid)
+	default:
+		return fmt.Errorf("unknown request '%s' of the pass protocol", request)
+	}
+	if !ok {
+		return nil
+	}
+	oid, objectType, value := s.formatData(data)
+	_, err := fmt.Fprintf(w, "%s\n%s\n%s\n", oid, objectType, value)
+	return err
+}
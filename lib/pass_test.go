@@ -0,0 +1,108 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestPass(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	options := &SnmpOptions{BaseOID: ".1.3.6.1.4.1.2021.254", CacheFile: path}
+
+	// The exporter persists the data.
+	s, err := NewSnmp(&fakeSyslog{}, WithSnmpOptions(options))
+	if err != nil {
+		t.Fatalf("NewSnmp => unexpected error: %s", err)
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:3", 9, 10, 11, 12, nil, 7, 0})
+	s.commit()
+
+	testData := []struct {
+		desc    string
+		options *SnmpOptions
+		request string
+		oid     string
+		want    string
+		wantErr bool
+	}{
+		{
+			desc:    "GET",
+			options: options,
+			request: PassGet,
+			oid:     ".1.3.6.1.4.1.2021.254.3.1",
+			want:    ".1.3.6.1.4.1.2021.254.3.1\nstring\neth0:1:3\n",
+		},
+		{
+			desc:    "GET-NEXT",
+			options: options,
+			request: PassGetNext,
+			oid:     ".1.3.6.1.4.1.2021.254.3.1",
+			want:    ".1.3.6.1.4.1.2021.254.4\nstring\nsentBytesLeaf\n",
+		},
+		{
+			desc:    "GET of a missing OID",
+			options: options,
+			request: PassGet,
+			oid:     ".1.3.6.1.4.1.2021.254.3.2",
+		},
+		{
+			desc:    "GET outside of the base OID",
+			options: options,
+			request: PassGet,
+			oid:     ".1.3.6.1.4.1.2021.255.3.1",
+		},
+		{
+			desc:    "unknown request",
+			options: options,
+			request: "set",
+			oid:     ".1.3.6.1.4.1.2021.254.3.1",
+			wantErr: true,
+		},
+		{
+			desc:    "no cacheFile",
+			options: &SnmpOptions{},
+			request: PassGet,
+			oid:     ".1.3.6.1.4.1.2021.255.3.1",
+			wantErr: true,
+		},
+		{
+			desc:    "missing cacheFile",
+			options: &SnmpOptions{CacheFile: filepath.Join(t.TempDir(), "missing.json")},
+			request: PassGet,
+			oid:     ".1.3.6.1.4.1.2021.255.3.1",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			var output bytes.Buffer
+			err := Pass(tc.options, &fakeSyslog{}, tc.request, tc.oid, &output)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Pass => got error: %v, want error: %t", err, tc.wantErr)
+			}
+			if got := output.String(); got != tc.want {
+				t.Errorf("Pass => got: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+pprof.go serves the Go profiles and runtime statistics of tc_reader on a loopback address, to diagnose the memory growth
+of a tc_reader that runs for months on a router.
+
+The endpoints are:
+/debug/pprof/ - The profiles of net/http/pprof, e.g. "go tool pprof http://127.0.0.1:6060/debug/pprof/heap".
+/v1/runtime   - The runtime statistics as JSON, e.g. {"heapAlloc": 1048576, "goroutines": 12, "rss": 8388608, ...}.
+
+The runtime statistics and the resource usage of tc_reader can be exported under tcRuntimeLeaf too, so that leaks or
+runaway parse cycles are caught by the same SNMP poll as the TC statistics.
+*/
+
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// pprofTimeout is the timeout of writing a response, long enough for a 30 seconds CPU profile or execution trace.
+const pprofTimeout = 60 * time.Second
+
+// processStart is the time tc_reader started, approximately.
+var processStart = time.Now()
+
+// PprofOptions are the options of the server of the Go profiles and runtime statistics.
+type PprofOptions struct {
+	// Listen is the loopback address the server listens on, e.g. "127.0.0.1:6060".
+	// The server isn't started if this isn't set.
+	Listen string
+}
+
+// runtimeStats are the statistics of the Go runtime.
+type runtimeStats struct {
+	// HeapAlloc is the number of bytes of the allocated heap objects.
+	HeapAlloc uint64 `json:"heapAlloc"`
+
+	// HeapSys is the number of bytes of the heap memory obtained from the OS.
+	HeapSys uint64 `json:"heapSys"`
+
+	// HeapObjects is the number of the allocated heap objects.
+	HeapObjects uint64 `json:"heapObjects"`
+
+	// Sys is the total number of bytes of the memory obtained from the OS.
+	Sys uint64 `json:"sys"`
+
+	// Goroutines is the number of the goroutines.
+	Goroutines int `json:"goroutines"`
+
+	// NumGC is the number of the completed garbage collections.
+	NumGC uint32 `json:"numGC"`
+
+	// LastPause is the duration of the last garbage collection pause in microseconds.
+	LastPause uint64 `json:"lastPause"`
+
+	// Rss is the number of bytes of the resident memory of tc_reader, zero if it couldn't be read.
+	Rss int64 `json:"rss,omitempty"`
+
+	// CpuTime is the user and system CPU time of tc_reader in milliseconds, zero if it couldn't be read.
+	CpuTime int64 `json:"cpuTime,omitempty"`
+
+	// Uptime is the number of seconds since tc_reader started.
+	Uptime int64 `json:"uptime"`
+}
+
+// readRuntimeStats returns the current statistics of the Go runtime. It briefly stops the world, so it shouldn't be
+// called more than once per parse cycle or request.
+func readRuntimeStats() runtimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := runtimeStats{
+		HeapAlloc:   m.HeapAlloc,
+		HeapSys:     m.HeapSys,
+		HeapObjects: m.HeapObjects,
+		Sys:         m.Sys,
+		Goroutines:  runtime.NumGoroutine(),
+		NumGC:       m.NumGC,
+		Uptime:      int64(time.Since(processStart) / time.Second),
+	}
+	if m.NumGC > 0 {
+		stats.LastPause = m.PauseNs[(m.NumGC+255)%256] / uint64(time.Microsecond)
+	}
+	if rss, cpu, err := readSelfUsage(); err == nil {
+		stats.Rss, stats.CpuTime = rss, cpu.Milliseconds()
+	}
+	return stats
+}
+
+// pprofServer implements metricSink, so that it is stopped with the other servers.
+type pprofServer struct {
+	// server serves the endpoints.
+	server *http.Server
+}
+
+// newPprofServer starts the server of the profiles in the background. Returns an error if the address isn't a
+// loopback one, the profiles reveal the memory of tc_reader including the SNMP communities and keys.
+func newPprofServer(options *PprofOptions, logger sysLogger) (*pprofServer, error) {
+	if err := checkLoopback(options.Listen); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", options.Listen)
+	if err != nil {
+		return nil, err
+	}
+	p := &pprofServer{
+		server: &http.Server{
+			Handler:      pprofHandler(),
+			ReadTimeout:  httpTimeout,
+			WriteTimeout: pprofTimeout,
+		},
+	}
+	go func() {
+		if err := p.server.Serve(listener); err != http.ErrServerClosed {
+			logger.Err(fmt.Sprintf("newPprofServer(): The pprof server on %s stopped, error: %s", options.Listen, err))
+		}
+	}()
+	return p, nil
+}
+
+// checkLoopback returns an error unless the host of the address is a loopback IP address or localhost.
+func checkLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("the address must be a loopback one, got '%s'", address)
+}
+
+// pprofHandler returns the handler of the endpoints. The handlers of net/http/pprof are registered on a dedicated mux,
+// rather than on http.DefaultServeMux by the import, so that the other servers don't serve them.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/v1/runtime", serveRuntimeStats)
+	return mux
+}
+
+// serveRuntimeStats answers with the current statistics of the Go runtime.
+func serveRuntimeStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readRuntimeStats())
+}
+
+// flush ignores the values, the profiles don't depend on the parse cycles.
+func (p *pprofServer) flush(values []metricValue, at time.Time) {}
+
+// close stops the server after the requests were answered, e.g. a running CPU profile.
+func (p *pprofServer) close(ctx context.Context) error {
+	return p.server.Shutdown(ctx)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckLoopback(t *testing.T) {
+	testData := []struct {
+		address string
+		wantErr bool
+	}{
+		{"127.0.0.1:6060", false},
+		{"127.0.0.2:6060", false},
+		{"[::1]:6060", false},
+		{"localhost:6060", false},
+		{"0.0.0.0:6060", true},
+		{":6060", true},
+		{"192.0.2.1:6060", true},
+		{"router:6060", true},
+		{"127.0.0.1", true},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.address, func(t *testing.T) {
+			if err := checkLoopback(tc.address); (err != nil) != tc.wantErr {
+				t.Errorf("checkLoopback => got error: %v, want error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPprofHandler(t *testing.T) {
+	testData := []struct {
+		method       string
+		url          string
+		wantCode     int
+		wantContains string
+	}{
+		{http.MethodGet, "/debug/pprof/", http.StatusOK, "goroutine"},
+		{http.MethodGet, "/debug/pprof/heap?debug=1", http.StatusOK, "heap profile"},
+		{http.MethodGet, "/debug/pprof/cmdline", http.StatusOK, ""},
+		{http.MethodGet, "/v1/runtime", http.StatusOK, `"goroutines":`},
+		{http.MethodPost, "/v1/runtime", http.StatusMethodNotAllowed, "method not allowed"},
+		{http.MethodGet, "/v1/classes", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.method+" "+tc.url, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			pprofHandler().ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.url, nil))
+			if recorder.Code != tc.wantCode {
+				t.Errorf("%s %s => got code: %d, want: %d", tc.method, tc.url, recorder.Code, tc.wantCode)
+			}
+			if !strings.Contains(recorder.Body.String(), tc.wantContains) {
+				t.Errorf("%s %s => got body:\n%s\nwant it to contain: %s", tc.method, tc.url, recorder.Body.String(), tc.wantContains)
+			}
+		})
+	}
+}
+
+func TestPprofServer(t *testing.T) {
+	if _, err := newPprofServer(&PprofOptions{Listen: "0.0.0.0:0"}, &fakeSyslog{}); err == nil {
+		t.Errorf("newPprofServer on a wildcard address => got no error")
+	}
+	p, err := newPprofServer(&PprofOptions{Listen: "127.0.0.1:0"}, &fakeSyslog{})
+	if err != nil {
+		t.Fatalf("newPprofServer => unexpected error: %s", err)
+	}
+	defer p.close(context.Background())
+	server := httptest.NewServer(p.server.Handler)
+	defer server.Close()
+	response, err := http.Get(server.URL + "/v1/runtime")
+	if err != nil {
+		t.Fatalf("GET /v1/runtime => unexpected error: %s", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("GET /v1/runtime => unable to read the body: %s", err)
+	}
+	var stats runtimeStats
+	if err := json.Unmarshal(body, &stats); err != nil {
+		t.Fatalf("GET /v1/runtime => unable to decode %s: %s", body, err)
+	}
+	if stats.HeapAlloc == 0 || stats.Sys == 0 || stats.Goroutines == 0 {
+		t.Errorf("GET /v1/runtime => got: %+v, want non-zero heap, memory and goroutines", stats)
+	}
+}
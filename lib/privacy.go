@@ -0,0 +1,102 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+privacy.go replaces the names of the users in the outputs other than the SNMP tree, i.e. the sinks, the Prometheus
+textfile, the reports of the agents, the output of -once, the notifications and the alert actions, where the data
+protection rules don't allow the subscriber names. The SNMP tree keeps the names, so that the operator can still tell
+the users apart:
+hash      - The name is replaced by the first 16 hex digits of its SHA-256 hash, e.g. "96d9632f363564cc" for "john".
+            The same name gets the same hash everywhere, the hashes of the known names can be computed by anybody though.
+pseudonym - The name is replaced by the first 16 hex digits of its HMAC-SHA256 with the configured key. Only those who
+            know the key can link the pseudonyms to the names.
+*/
+
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	// userNamesPlain keeps the names of the users.
+	userNamesPlain = "plain"
+
+	// userNamesHash replaces the names of the users by their hashes.
+	userNamesHash = "hash"
+
+	// userNamesPseudonym replaces the names of the users by their keyed hashes.
+	userNamesPseudonym = "pseudonym"
+
+	// pseudonymBytes is the number of the bytes of the hash in the replaced names.
+	pseudonymBytes = 8
+)
+
+// pseudonymizer replaces the names of the users.
+type pseudonymizer struct {
+	// key is the key of the pseudonyms, nil for the plain hashes.
+	key []byte
+}
+
+// newPseudonymizer returns the pseudonymizer of the mode, nil if the names are kept. Returns an error if the mode is
+// unknown or if the pseudonyms have no key.
+func newPseudonymizer(mode, key string) (*pseudonymizer, error) {
+	switch mode {
+	case emptyString, userNamesPlain:
+		return nil, nil
+	case userNamesHash:
+		return &pseudonymizer{}, nil
+	case userNamesPseudonym:
+		if key == emptyString {
+			return nil, errors.New("the pseudonyms of the user names need the userNameKey")
+		}
+		return &pseudonymizer{key: []byte(key)}, nil
+	}
+	return nil, fmt.Errorf("the user names must be %s, %s or %s, got '%s'", userNamesPlain, userNamesHash, userNamesPseudonym, mode)
+}
+
+// name returns the replacement of the name of the user.
+func (p *pseudonymizer) name(user string) string {
+	var sum []byte
+	if p.key != nil {
+		mac := hmac.New(sha256.New, p.key)
+		mac.Write([]byte(user))
+		sum = mac.Sum(nil)
+	} else {
+		hash := sha256.Sum256([]byte(user))
+		sum = hash[:]
+	}
+	return hex.EncodeToString(sum[:pseudonymBytes])
+}
+
+// values replaces the names of the users among the values in place. Every name is hashed once.
+func (p *pseudonymizer) values(values []metricValue) {
+	names := make(map[string]string)
+	for i := range values {
+		if values[i].nameLeaf != tcUserNameLeaf {
+			continue
+		}
+		replaced, ok := names[values[i].name]
+		if !ok {
+			replaced = p.name(values[i].name)
+			names[values[i].name] = replaced
+		}
+		values[i].name = replaced
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPseudonymizer(t *testing.T) {
+	testData := []struct {
+		desc    string
+		mode    string
+		key     string
+		want    string
+		wantNil bool
+		wantErr bool
+	}{
+		{
+			desc:    "names are kept by default",
+			wantNil: true,
+		},
+		{
+			desc:    "plain names are kept",
+			mode:    userNamesPlain,
+			wantNil: true,
+		},
+		{
+			desc: "names are hashed",
+			mode: userNamesHash,
+			want: "96d9632f363564cc",
+		},
+		{
+			desc: "the hashes don't depend on the key",
+			mode: userNamesHash,
+			key:  "secret",
+			want: "96d9632f363564cc",
+		},
+		{
+			desc: "the pseudonyms are keyed",
+			mode: userNamesPseudonym,
+			key:  "secret",
+			want: "337e3f715bf0aaed",
+		},
+		{
+			desc:    "the pseudonyms need a key",
+			mode:    userNamesPseudonym,
+			wantErr: true,
+		},
+		{
+			desc:    "unknown mode",
+			mode:    "encrypt",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			p, err := newPseudonymizer(tc.mode, tc.key)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("newPseudonymizer(%q, %q) => got error: %v, want error: %v", tc.mode, tc.key, err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if (p == nil) != tc.wantNil {
+				t.Fatalf("newPseudonymizer(%q, %q) => got: %v, want nil: %v", tc.mode, tc.key, p, tc.wantNil)
+			}
+			if p == nil {
+				return
+			}
+			if got := p.name("john"); got != tc.want {
+				t.Errorf("name(john) => got: %s, want: %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPseudonymizerValues(t *testing.T) {
+	p, err := newPseudonymizer(userNamesHash, "")
+	if err != nil {
+		t.Fatalf("newPseudonymizer => unexpected error: %s", err)
+	}
+	values := []metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:1:1", int64(100)},
+		{"userUpBytes", tcUserNameLeaf, "john", int64(50)},
+		{"userUpPkt", tcUserNameLeaf, "john", int64(1)},
+		{"groupBytes", tcGroupNameLeaf, "office", int64(150)},
+	}
+	p.values(values)
+	want := []metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:1:1", int64(100)},
+		{"userUpBytes", tcUserNameLeaf, "96d9632f363564cc", int64(50)},
+		{"userUpPkt", tcUserNameLeaf, "96d9632f363564cc", int64(1)},
+		{"groupBytes", tcGroupNameLeaf, "office", int64(150)},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values => got: %v, want: %v", values, want)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+privileges.go switches the running tc_reader from root to an unprivileged account once it started up, e.g. when the
+SNMP daemon runs its pass_persist scripts as root. The CAP_NET_ADMIN capability is retained, also for the TC commands
+tc_reader executes, so that the netlink sockets and the TC commands keep working.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// DropPrivileges switches the process to the user and the group, the primary group of the user if the group is empty.
+// It is a no-op if the process already runs as the user and the group. Returns an error if the user or the group
+// don't exist or if the privileges can't be dropped, e.g. when not running as root.
+func DropPrivileges(userName, groupName string) error {
+	uid, gid, err := lookupAccount(userName, groupName)
+	if err != nil {
+		return err
+	}
+	if os.Getuid() == uid && os.Getgid() == gid {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("only root can switch to the user %s, running as uid %d", userName, os.Geteuid())
+	}
+	if err := dropPrivileges(uid, gid); err != nil {
+		return fmt.Errorf("unable to switch to the user %s, error: %s", userName, err)
+	}
+	return nil
+}
+
+// lookupAccount returns the uid of the user and the gid of the group, or of the primary group of the user if the group
+// is empty. The user and the group can also be numeric IDs.
+func lookupAccount(userName, groupName string) (int, int, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if u, err = user.LookupId(userName); err != nil {
+			return 0, 0, fmt.Errorf("unknown user %s", userName)
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("the user %s has a non-numeric uid %s", userName, u.Uid)
+	}
+	gidStr := u.Gid
+	if groupName != emptyString {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return 0, 0, fmt.Errorf("unknown group %s", groupName)
+			}
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("the group of the user %s has a non-numeric gid %s", userName, gidStr)
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+privileges_linux.go drops the privileges while retaining CAP_NET_ADMIN. The capabilities are per thread on Linux, so
+they are set on all the threads of the Go runtime, which isn't possible in the binaries linked with cgo.
+*/
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// capNetAdmin is the number of the CAP_NET_ADMIN capability, see capability.h.
+	capNetAdmin = 12
+
+	// capVersion3 is the version of the 64-bit capabilities of capset, see capability.h.
+	capVersion3 = 0x20080522
+
+	// prCapAmbient is the prctl option of the ambient capabilities, see prctl.h.
+	prCapAmbient = 47
+
+	// prCapAmbientRaise adds a capability to the ambient capabilities, see prctl.h.
+	prCapAmbientRaise = 2
+)
+
+// capHeader is the header of capset, see capability.h.
+type capHeader struct {
+	// version is the version of the capabilities.
+	version uint32
+
+	// pid is the process to set the capabilities of, zero for the calling thread.
+	pid int32
+}
+
+// capData are the capabilities of capset, capVersion3 has two of them for the lower and the upper 32 capabilities.
+type capData struct {
+	// effective are the capabilities used in the permission checks.
+	effective uint32
+
+	// permitted are the capabilities that can become effective.
+	permitted uint32
+
+	// inheritable are the capabilities that can be passed to the executed commands.
+	inheritable uint32
+}
+
+// dropPrivileges switches all the threads to the uid and the gid, and retains CAP_NET_ADMIN. The capability is also
+// raised in the ambient set, so that the executed commands get it as well.
+func dropPrivileges(uid, gid int) error {
+	if err := allThreadsSyscall(syscall.SYS_PRCTL, syscall.PR_SET_KEEPCAPS, 1, 0); err != nil {
+		return fmt.Errorf("unable to keep the capabilities, %s", err)
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return err
+	}
+	if err := syscall.Setresgid(gid, gid, gid); err != nil {
+		return err
+	}
+	if err := syscall.Setresuid(uid, uid, uid); err != nil {
+		return err
+	}
+
+	header := capHeader{version: capVersion3}
+	data := [2]capData{{effective: 1 << capNetAdmin, permitted: 1 << capNetAdmin, inheritable: 1 << capNetAdmin}}
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("unable to retain CAP_NET_ADMIN, %s", threadsError(errno))
+	}
+	if err := allThreadsSyscall(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientRaise, capNetAdmin); err != nil {
+		return fmt.Errorf("unable to pass CAP_NET_ADMIN to the executed commands, %s", err)
+	}
+	return allThreadsSyscall(syscall.SYS_PRCTL, syscall.PR_SET_KEEPCAPS, 0, 0)
+}
+
+// allThreadsSyscall executes the system call without pointer arguments on all the threads, returns an error if it
+// failed.
+func allThreadsSyscall(trap, a1, a2, a3 uintptr) error {
+	if _, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3); errno != 0 {
+		return threadsError(errno)
+	}
+	return nil
+}
+
+// threadsError returns the error of a system call executed on all the threads.
+func threadsError(errno syscall.Errno) error {
+	if errno == syscall.ENOTSUP {
+		return errors.New("the capabilities can't be set on all the threads of a binary linked with cgo, build tc_reader with CGO_ENABLED=0")
+	}
+	return errno
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+privileges_other.go is used on systems without the Linux capabilities, CAP_NET_ADMIN can't be retained there.
+*/
+
+package lib
+
+import (
+	"errors"
+)
+
+// dropPrivileges always fails, CAP_NET_ADMIN can only be retained on Linux.
+func dropPrivileges(uid, gid int) error {
+	return errors.New("dropping the privileges while retaining CAP_NET_ADMIN is only supported on Linux")
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"testing"
+)
+
+func TestLookupAccount(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("user.Current => unexpected error: %s", err)
+	}
+	uid, _ := strconv.Atoi(current.Uid)
+	gid, _ := strconv.Atoi(current.Gid)
+
+	testData := []struct {
+		desc      string
+		user      string
+		group     string
+		wantUID   int
+		wantGID   int
+		wantError bool
+	}{
+		{
+			desc:    "user name with its primary group",
+			user:    current.Username,
+			wantUID: uid,
+			wantGID: gid,
+		},
+		{
+			desc:    "numeric user and group",
+			user:    current.Uid,
+			group:   current.Gid,
+			wantUID: uid,
+			wantGID: gid,
+		},
+		{
+			desc:      "unknown user",
+			user:      "no-such-tc-reader-user",
+			wantError: true,
+		},
+		{
+			desc:      "unknown group",
+			user:      current.Username,
+			group:     "no-such-tc-reader-group",
+			wantError: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			gotUID, gotGID, err := lookupAccount(tc.user, tc.group)
+			if (err != nil) != tc.wantError {
+				t.Fatalf("lookupAccount => got error: %v, want error: %t", err, tc.wantError)
+			}
+			if err == nil && (gotUID != tc.wantUID || gotGID != tc.wantGID) {
+				t.Errorf("lookupAccount => got uid %d gid %d, want uid %d gid %d", gotUID, gotGID, tc.wantUID, tc.wantGID)
+			}
+		})
+	}
+}
+
+func TestDropPrivilegesToCurrentUser(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("user.Current => unexpected error: %s", err)
+	}
+	if current.Gid != strconv.Itoa(os.Getgid()) {
+		t.Skipf("the process doesn't run with the primary group of the user")
+	}
+	// Switching to the account the process already runs as doesn't change anything.
+	if err := DropPrivileges(current.Username, ""); err != nil {
+		t.Errorf("DropPrivileges => unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+prometheus.go writes the counters of each parse cycle to a file in the text exposition format of Prometheus, e.g. for
+the textfile collector of the node_exporter:
+tc_sent_bytes_total{name="eth0:2:3",iface="eth0",class="2:3"} 1500
+tc_user_sent_bytes_total{user="john",direction="down"} 500
+tc_group_sent_bytes_total{group="office"} 3000
+
+Each entry also has the monotonic timestamp of its parse cycle, see monotonicNow, e.g.:
+tc_sample_monotonic_seconds{name="eth0:2:3",iface="eth0",class="2:3"} 5401.500
+
+The names of the users can be replaced by their hashes or pseudonyms, see privacy.go.
+
+The file is replaced atomically, so that the collector never reads a partially written file. The series of an instance
+among several on the host, see leader.go, carry its name in the tc_instance label, so that the files of the instances
+don't collide in the collector.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// promCounters are the names and the descriptions of the exported counters.
+var promCounters = []struct {
+	// name is the name of the counter without the prefix.
+	name string
+
+	// help describes the counter.
+	help string
+
+	// value returns the counter from the sample.
+	value func(sample) int64
+}{
+	{"sent_bytes_total", "The number of sent bytes.", func(s sample) int64 { return s.bytes }},
+	{"sent_packets_total", "The number of sent packets.", func(s sample) int64 { return s.pkt }},
+	{"dropped_packets_total", "The number of dropped packets.", func(s sample) int64 { return s.droppedPkt }},
+	{"overlimit_packets_total", "The number of over limit packets.", func(s sample) int64 { return s.overLimitPkt }},
+}
+
+// promPrefixes are the prefixes of the metric names of the Qdiscs / Classes, users and groups in the order they are
+// written.
+var promPrefixes = []string{"tc_", "tc_user_", "tc_group_"}
+
+// promLabelEscaper escapes the values of the labels.
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// PrometheusOptions are the options of the Prometheus textfile.
+type PrometheusOptions struct {
+	// File is the path of the written file, e.g. "/var/lib/node_exporter/textfile/tc_reader.prom".
+	// The file isn't written if this isn't set.
+	File string
+
+	// Instance is the name of the instance added as the tc_instance label to all the series, none is added if this
+	// isn't set.
+	Instance string
+}
+
+// promEntry are the counters of a Qdisc / Class, user or group.
+type promEntry struct {
+	// prefix is the prefix of the metric names, one of promPrefixes.
+	prefix string
+
+	// labels are the formatted labels, e.g. `{user="john",direction="down"}`.
+	labels string
+
+	// sample are the counters.
+	sample sample
+
+	// monotonic is the monotonic timestamp of the parse cycle of the counters, zero if it isn't known.
+	monotonic time.Duration
+}
+
+// promFileSink implements dataSink.
+type promFileSink struct {
+	// options holds the configurable options.
+	options *PrometheusOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// pseudonymizer replaces the names of the users, nil if these are kept.
+	pseudonymizer *pseudonymizer
+
+	// entries are the counters added in the current parse cycle.
+	entries []promEntry
+
+	// warnings is the number of warnings added in the current parse cycle.
+	warnings int
+
+	// maintenance indicates that the collection is paused.
+	maintenance bool
+
+	// failures is the number of consecutive failed parse cycles.
+	failures int
+
+	// unmatchedLines is the number of lines of the TC output that matched no pattern in the last parse cycle.
+	unmatchedLines int
+}
+
+// newPromFileSink creates new promFileSink, the pseudonymizer replaces the names of the users unless it is nil.
+func newPromFileSink(options *PrometheusOptions, pseudonymizer *pseudonymizer, logger sysLogger) *promFileSink {
+	return &promFileSink{
+		options:       options,
+		logger:        logger,
+		pseudonymizer: pseudonymizer,
+	}
+}
+
+// begin doesn't need to do anything, the file is only written by commit.
+func (p *promFileSink) begin() {}
+
+// commit writes the file.
+func (p *promFileSink) commit() {
+	if err := p.write(); err != nil {
+		p.logger.Err(fmt.Sprintf("commit(): Unable to write the Prometheus textfile %s, error: %s", p.options.File, err))
+	}
+}
+
+// erase removes the counters of the previous parse cycle.
+func (p *promFileSink) erase() {
+	p.entries = p.entries[:0]
+	p.warnings = 0
+}
+
+// addData adds the counters of a Qdisc / Class or user.
+func (p *promFileSink) addData(data *parsedData) {
+	if data.userClass == nil {
+		iface, class := metricValue{name: data.name, nameLeaf: tcNameLeaf}.components()
+		p.add("tc_", data, "name", data.name, "iface", iface, "class", class)
+		return
+	}
+	direction := "up"
+	if data.userClass.Direction == DownloadDirection {
+		direction = "down"
+	}
+	user := data.name
+	if p.pseudonymizer != nil {
+		user = p.pseudonymizer.name(user)
+	}
+	p.add("tc_user_", data, "user", user, "direction", direction)
+}
+
+// addGroupData adds the counters of an interface group.
+func (p *promFileSink) addGroupData(data *parsedData) {
+	p.add("tc_group_", data, "group", data.name)
+}
+
+// addIfaceData adds the counters of an interface.
+func (p *promFileSink) addIfaceData(data *parsedData) {
+	p.add("tc_iface_", data, "iface", data.name)
+}
+
+// addXstats ignores the xstats, only the counters are written.
+func (p *promFileSink) addXstats(name string, xstats []xstat) {}
+
+// addNetDevData ignores the kernel counters, the node_exporter exports them.
+func (p *promFileSink) addNetDevData(stats *netDevStats) {}
+
+// addConntrackData ignores the conntrack counters, only the counters of TC are written.
+func (p *promFileSink) addConntrackData(data *parsedData) {}
+
+// addWarning counts the warning.
+func (p *promFileSink) addWarning(message string) {
+	p.warnings++
+}
+
+// setMaintenance records the maintenance.
+func (p *promFileSink) setMaintenance(active bool) {
+	p.maintenance = active
+}
+
+// setHealth records the number of consecutive failed parse cycles.
+func (p *promFileSink) setHealth(failures int, backoff int) {
+	p.failures = failures
+}
+
+// setUnmatchedLines records the number of lines of the TC output that matched no pattern.
+func (p *promFileSink) setUnmatchedLines(lines int) {
+	p.unmatchedLines = lines
+}
+
+// add adds the counters of the data with the labels given as name and value pairs.
+func (p *promFileSink) add(prefix string, data *parsedData, labels ...string) {
+	var formatted []string
+	if p.options.Instance != emptyString {
+		labels = append([]string{"tc_instance", p.options.Instance}, labels...)
+	}
+	for i := 0; i+1 < len(labels); i += 2 {
+		formatted = append(formatted, fmt.Sprintf(`%s="%s"`, labels[i], promLabelEscaper.Replace(labels[i+1])))
+	}
+	p.entries = append(p.entries, promEntry{prefix, "{" + strings.Join(formatted, ",") + "}", data.sample(), data.monotonic})
+}
+
+// content returns the content of the file.
+func (p *promFileSink) content() []byte {
+	var buf bytes.Buffer
+	for _, prefix := range promPrefixes {
+		for _, counter := range promCounters {
+			var header bool
+			for _, entry := range p.entries {
+				if entry.prefix != prefix {
+					continue
+				}
+				if !header {
+					fmt.Fprintf(&buf, "# HELP %s%s %s\n# TYPE %s%s counter\n", prefix, counter.name, counter.help, prefix, counter.name)
+					header = true
+				}
+				fmt.Fprintf(&buf, "%s%s%s %d\n", prefix, counter.name, entry.labels, counter.value(entry.sample))
+			}
+		}
+		var header bool
+		for _, entry := range p.entries {
+			if entry.prefix != prefix || entry.monotonic == 0 {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(&buf, "# HELP %ssample_monotonic_seconds The monotonic timestamp of the parse cycle of the counters.\n# TYPE %ssample_monotonic_seconds gauge\n", prefix, prefix)
+				header = true
+			}
+			fmt.Fprintf(&buf, "%ssample_monotonic_seconds%s %.3f\n", prefix, entry.labels, entry.monotonic.Seconds())
+		}
+	}
+	maintenance := 0
+	if p.maintenance {
+		maintenance = 1
+	}
+	var labels string
+	if p.options.Instance != emptyString {
+		labels = fmt.Sprintf(`{tc_instance="%s"}`, promLabelEscaper.Replace(p.options.Instance))
+	}
+	fmt.Fprintf(&buf, "# HELP tc_warnings The number of warnings in the last parse cycle.\n# TYPE tc_warnings gauge\ntc_warnings%s %d\n", labels, p.warnings)
+	fmt.Fprintf(&buf, "# HELP tc_maintenance Whether the collection is paused.\n# TYPE tc_maintenance gauge\ntc_maintenance%s %d\n", labels, maintenance)
+	fmt.Fprintf(&buf, "# HELP tc_parse_failures The number of consecutive failed parse cycles.\n# TYPE tc_parse_failures gauge\ntc_parse_failures%s %d\n", labels, p.failures)
+	fmt.Fprintf(&buf, "# HELP tc_unmatched_lines The number of lines of the TC output that matched no pattern in the last parse cycle.\n# TYPE tc_unmatched_lines gauge\ntc_unmatched_lines%s %d\n", labels, p.unmatchedLines)
+	return buf.Bytes()
+}
+
+// write replaces the file with a temporary file holding the new content.
+func (p *promFileSink) write() error {
+	temp := p.options.File + ".tmp"
+	if err := os.WriteFile(temp, p.content(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, p.options.File)
+}
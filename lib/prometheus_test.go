@@ -0,0 +1,187 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tc_reader.prom")
+	p := newPromFileSink(&PrometheusOptions{File: path}, nil, &fakeSyslog{})
+
+	p.begin()
+	p.erase()
+	p.addData(&parsedData{name: "eth0:2:3", sentBytes: 1500, sentPkt: 10, droppedPkt: 1, overLimitPkt: 2})
+	p.addData(&parsedData{name: "john", sentBytes: 500, sentPkt: 5, userClass: &UserClass{DownloadDirection, "john"}})
+	p.addData(&parsedData{name: "john", sentBytes: 100, sentPkt: 1, userClass: &UserClass{UploadDirection, "john"}})
+	p.addGroupData(&parsedData{name: `of"fice`, sentBytes: 3000, sentPkt: 20})
+	p.addWarning("eth0: unrecognized header line")
+	p.setHealth(2, 40)
+	p.setUnmatchedLines(3)
+	p.commit()
+
+	want := []string{
+		"# HELP tc_sent_bytes_total The number of sent bytes.",
+		"# TYPE tc_sent_bytes_total counter",
+		`tc_sent_bytes_total{name="eth0:2:3",iface="eth0",class="2:3"} 1500`,
+		"# HELP tc_sent_packets_total The number of sent packets.",
+		"# TYPE tc_sent_packets_total counter",
+		`tc_sent_packets_total{name="eth0:2:3",iface="eth0",class="2:3"} 10`,
+		"# HELP tc_dropped_packets_total The number of dropped packets.",
+		"# TYPE tc_dropped_packets_total counter",
+		`tc_dropped_packets_total{name="eth0:2:3",iface="eth0",class="2:3"} 1`,
+		"# HELP tc_overlimit_packets_total The number of over limit packets.",
+		"# TYPE tc_overlimit_packets_total counter",
+		`tc_overlimit_packets_total{name="eth0:2:3",iface="eth0",class="2:3"} 2`,
+		"# HELP tc_user_sent_bytes_total The number of sent bytes.",
+		"# TYPE tc_user_sent_bytes_total counter",
+		`tc_user_sent_bytes_total{user="john",direction="down"} 500`,
+		`tc_user_sent_bytes_total{user="john",direction="up"} 100`,
+		"# HELP tc_user_sent_packets_total The number of sent packets.",
+		"# TYPE tc_user_sent_packets_total counter",
+		`tc_user_sent_packets_total{user="john",direction="down"} 5`,
+		`tc_user_sent_packets_total{user="john",direction="up"} 1`,
+		"# HELP tc_user_dropped_packets_total The number of dropped packets.",
+		"# TYPE tc_user_dropped_packets_total counter",
+		`tc_user_dropped_packets_total{user="john",direction="down"} 0`,
+		`tc_user_dropped_packets_total{user="john",direction="up"} 0`,
+		"# HELP tc_user_overlimit_packets_total The number of over limit packets.",
+		"# TYPE tc_user_overlimit_packets_total counter",
+		`tc_user_overlimit_packets_total{user="john",direction="down"} 0`,
+		`tc_user_overlimit_packets_total{user="john",direction="up"} 0`,
+		"# HELP tc_group_sent_bytes_total The number of sent bytes.",
+		"# TYPE tc_group_sent_bytes_total counter",
+		`tc_group_sent_bytes_total{group="of\"fice"} 3000`,
+		"# HELP tc_group_sent_packets_total The number of sent packets.",
+		"# TYPE tc_group_sent_packets_total counter",
+		`tc_group_sent_packets_total{group="of\"fice"} 20`,
+		"# HELP tc_group_dropped_packets_total The number of dropped packets.",
+		"# TYPE tc_group_dropped_packets_total counter",
+		`tc_group_dropped_packets_total{group="of\"fice"} 0`,
+		"# HELP tc_group_overlimit_packets_total The number of over limit packets.",
+		"# TYPE tc_group_overlimit_packets_total counter",
+		`tc_group_overlimit_packets_total{group="of\"fice"} 0`,
+		"# HELP tc_warnings The number of warnings in the last parse cycle.",
+		"# TYPE tc_warnings gauge",
+		"tc_warnings 1",
+		"# HELP tc_maintenance Whether the collection is paused.",
+		"# TYPE tc_maintenance gauge",
+		"tc_maintenance 0",
+		"# HELP tc_parse_failures The number of consecutive failed parse cycles.",
+		"# TYPE tc_parse_failures gauge",
+		"tc_parse_failures 2",
+		"# HELP tc_unmatched_lines The number of lines of the TC output that matched no pattern in the last parse cycle.",
+		"# TYPE tc_unmatched_lines gauge",
+		"tc_unmatched_lines 3",
+		"",
+	}
+	if got := readFile(t, path); got != strings.Join(want, "\n") {
+		t.Errorf("commit => got:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	// A maintenance cycle keeps the counters of the last parse cycle.
+	p.begin()
+	p.setMaintenance(true)
+	p.commit()
+	if got := readFile(t, path); !strings.Contains(got, "tc_maintenance 1\n") || !strings.Contains(got, "tc_sent_bytes_total{") {
+		t.Errorf("commit => got:\n%s\nwant the counters in maintenance", got)
+	}
+
+	p.begin()
+	p.erase()
+	p.setMaintenance(false)
+	p.commit()
+	if got := readFile(t, path); strings.Contains(got, "tc_sent_bytes_total") || strings.Contains(got, "tc_warnings 1") {
+		t.Errorf("commit => got:\n%s\nwant no counters after erase", got)
+	}
+}
+
+func TestPromFileSinkInstance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tc_reader.prom")
+	p := newPromFileSink(&PrometheusOptions{File: path, Instance: "tenant1"}, nil, &fakeSyslog{})
+	p.begin()
+	p.erase()
+	p.addData(&parsedData{name: "eth0:2:3", sentBytes: 1500})
+	p.commit()
+
+	got := readFile(t, path)
+	for _, want := range []string{
+		`tc_sent_bytes_total{tc_instance="tenant1",name="eth0:2:3",iface="eth0",class="2:3"} 1500`,
+		`tc_warnings{tc_instance="tenant1"} 0`,
+		`tc_unmatched_lines{tc_instance="tenant1"} 0`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("commit => got:\n%s\nwant the line: %s", got, want)
+		}
+	}
+}
+
+func TestPromFileSinkUserNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tc_reader.prom")
+	p := newPromFileSink(&PrometheusOptions{File: path}, &pseudonymizer{}, &fakeSyslog{})
+	p.begin()
+	p.erase()
+	p.addData(&parsedData{name: "john", sentBytes: 500, userClass: &UserClass{DownloadDirection, "john"}})
+	p.commit()
+
+	got := readFile(t, path)
+	if want := `tc_user_sent_bytes_total{user="96d9632f363564cc",direction="down"} 500`; !strings.Contains(got, want+"\n") {
+		t.Errorf("commit => got:\n%s\nwant the line: %s", got, want)
+	}
+	if strings.Contains(got, "john") {
+		t.Errorf("commit => got:\n%s\nwant no user names", got)
+	}
+}
+
+func TestPromFileSinkMonotonic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tc_reader.prom")
+	p := newPromFileSink(&PrometheusOptions{File: path}, nil, &fakeSyslog{})
+	p.begin()
+	p.erase()
+	p.addData(&parsedData{name: "eth0:2:3", sentBytes: 1500, monotonic: 5401500 * time.Millisecond})
+	p.addData(&parsedData{name: "eth0:2:4", sentBytes: 500})
+	p.addGroupData(&parsedData{name: "office", sentBytes: 2000, monotonic: 5401500 * time.Millisecond})
+	p.commit()
+
+	got := readFile(t, path)
+	for _, want := range []string{
+		"# TYPE tc_sample_monotonic_seconds gauge",
+		`tc_sample_monotonic_seconds{name="eth0:2:3",iface="eth0",class="2:3"} 5401.500`,
+		`tc_group_sample_monotonic_seconds{group="office"} 5401.500`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("commit => got:\n%s\nwant the line: %s", got, want)
+		}
+	}
+	if strings.Contains(got, `tc_sample_monotonic_seconds{name="eth0:2:4"`) {
+		t.Errorf("commit => got:\n%s\nwant no timestamp of the data that wasn't stamped", got)
+	}
+}
+
+func TestPromFileSinkWriteError(t *testing.T) {
+	logger := &fakeSyslog{}
+	p := newPromFileSink(&PrometheusOptions{File: filepath.Join(t.TempDir(), "missing", "tc_reader.prom")}, nil, logger)
+	p.begin()
+	p.commit()
+	if len(logger.err) != 1 {
+		t.Errorf("commit => got errors: %v, want one error", logger.err)
+	}
+}
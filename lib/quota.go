@@ -0,0 +1,214 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+quota.go accounts the bytes of each user and direction in monthly periods. The totals are persisted to a file,
+so that they survive restarts of tc_reader. The users that weren't seen for longer than the historyRetention are
+removed from the file.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	// maxQuotaResetDay is the last allowed day of the month on which the quota period starts, it exists in every month.
+	maxQuotaResetDay = 28
+
+	// quotaFileMode are the permissions of the file with the persisted quota totals.
+	quotaFileMode = 0644
+)
+
+var (
+	// quotaResetDay is the default day of the month on which the quota period starts.
+	quotaResetDay = 1
+
+	// quotaThresholds are the percentages of the quota that are logged when an user crosses them, in increasing order.
+	quotaThresholds = []int64{80, 100}
+
+	// quotaUnits maps the units of the configured quotas to the numbers of bytes.
+	quotaUnits = map[string]int64{
+		"":  1,
+		"K": 1000,
+		"M": 1000 * 1000,
+		"G": 1000 * 1000 * 1000,
+		"T": 1000 * 1000 * 1000 * 1000,
+	}
+)
+
+// quotaUsage are the accounted bytes of a user in one direction.
+type quotaUsage struct {
+	// Bytes is the number of bytes accounted since the start of the current period.
+	Bytes int64 `json:"bytes"`
+
+	// Last is the last seen raw byte counter, the next sample is accounted as the difference against it.
+	Last int64 `json:"last"`
+
+	// Seen is the day in UTC on which the user was last seen, see expire.
+	Seen time.Time `json:"seen"`
+}
+
+// quotaTracker accounts the bytes of each user and direction since the start of the current period.
+type quotaTracker struct {
+	// path is the file the totals are persisted to.
+	path string
+
+	// resetDay is the day of the month on which a new period starts.
+	resetDay int
+
+	// PeriodStart is the start of the current period.
+	PeriodStart time.Time `json:"periodStart"`
+
+	// Users maps the keys of the users and directions (see UserClass.key()) to their usage.
+	Users map[string]*quotaUsage `json:"users"`
+
+	// changed indicates that the usage changed since the last save().
+	changed bool
+}
+
+// loadQuota creates a quotaTracker with the totals persisted in the file. A missing file starts the accounting from zero.
+func loadQuota(path string, resetDay int) (*quotaTracker, error) {
+	q := &quotaTracker{
+		path:     path,
+		resetDay: resetDay,
+		Users:    make(map[string]*quotaUsage),
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return q, err
+	}
+	if err := json.Unmarshal(content, q); err != nil {
+		q.PeriodStart = time.Time{}
+		q.Users = make(map[string]*quotaUsage)
+		return q, err
+	}
+	if q.Users == nil {
+		q.Users = make(map[string]*quotaUsage)
+	}
+	// The users persisted without the day they were last seen on count as seen today.
+	for _, usage := range q.Users {
+		if usage.Seen.IsZero() {
+			usage.Seen = seenDay(time.Now())
+		}
+	}
+	return q, nil
+}
+
+// seenDay returns the day in UTC that the time falls into. The users are recorded as seen once a day, so that the file
+// isn't rewritten in every parse cycle.
+func seenDay(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour)
+}
+
+// periodStart returns the start of the period that the time falls into.
+func periodStart(now time.Time, resetDay int) time.Time {
+	start := time.Date(now.Year(), now.Month(), resetDay, 0, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// add accounts the raw byte counter of the user and direction and returns the bytes accounted in the current period.
+// The first sample of a user is only remembered. A decreased counter is accounted like in counterDelta().
+func (q *quotaTracker) add(key string, bytes int64, now time.Time) int64 {
+	if start := periodStart(now, q.resetDay); !start.Equal(q.PeriodStart) {
+		q.PeriodStart = start
+		for _, usage := range q.Users {
+			usage.Bytes = 0
+		}
+		q.changed = true
+	}
+	usage, ok := q.Users[key]
+	if !ok {
+		q.Users[key] = &quotaUsage{Last: bytes, Seen: seenDay(now)}
+		q.changed = true
+		return 0
+	}
+	if day := seenDay(now); !usage.Seen.Equal(day) {
+		usage.Seen = day
+		q.changed = true
+	}
+	delta, _ := counterDelta(usage.Last, bytes)
+	if delta != 0 || usage.Last != bytes {
+		q.changed = true
+	}
+	usage.Bytes += delta
+	usage.Last = bytes
+	return usage.Bytes
+}
+
+// expire forgets the users that weren't seen since the day of the time, so that the file doesn't keep the names of the
+// users that left for good. Their usage starts from zero if they come back.
+func (q *quotaTracker) expire(before time.Time) {
+	oldest := seenDay(before)
+	for key, usage := range q.Users {
+		if usage.Seen.Before(oldest) {
+			delete(q.Users, key)
+			q.changed = true
+		}
+	}
+}
+
+// userBytes returns the bytes accounted for the user in both directions in the current period.
+func (q *quotaTracker) userBytes(name string) int64 {
+	var bytes int64
+	for _, direction := range []int{UploadDirection, DownloadDirection} {
+		if usage, ok := q.Users[(&UserClass{direction, name}).key()]; ok {
+			bytes += usage.Bytes
+		}
+	}
+	return bytes
+}
+
+// quotaLevel returns the highest of the quotaThresholds that the used bytes reached, zero if none.
+func quotaLevel(used, limit int64) int64 {
+	var level int64
+	for _, threshold := range quotaThresholds {
+		if used*100 >= threshold*limit {
+			level = threshold
+		}
+	}
+	return level
+}
+
+// save persists the totals if they changed since the last save. The file is replaced atomically, so that a crash
+// never leaves it truncated.
+func (q *quotaTracker) save() error {
+	if !q.changed {
+		return nil
+	}
+	content, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	tmpPath := q.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, quotaFileMode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		return err
+	}
+	q.changed = false
+	return nil
+}
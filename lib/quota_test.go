@@ -0,0 +1,225 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	testData := []struct {
+		desc     string
+		now      time.Time
+		resetDay int
+		want     time.Time
+	}{
+		{
+			desc:     "after the reset day",
+			now:      time.Date(2013, 5, 20, 12, 0, 0, 0, time.UTC),
+			resetDay: 15,
+			want:     time.Date(2013, 5, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			desc:     "on the reset day",
+			now:      time.Date(2013, 5, 15, 0, 0, 0, 0, time.UTC),
+			resetDay: 15,
+			want:     time.Date(2013, 5, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			desc:     "before the reset day",
+			now:      time.Date(2013, 5, 14, 23, 59, 0, 0, time.UTC),
+			resetDay: 15,
+			want:     time.Date(2013, 4, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			desc:     "before the reset day in January",
+			now:      time.Date(2013, 1, 3, 0, 0, 0, 0, time.UTC),
+			resetDay: 5,
+			want:     time.Date(2012, 12, 5, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := periodStart(tc.now, tc.resetDay); !got.Equal(tc.want) {
+				t.Errorf("periodStart(%s, %d) => got: %s, want: %s", tc.now, tc.resetDay, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQuotaTrackerAdd(t *testing.T) {
+	steps := []struct {
+		desc  string
+		key   string
+		bytes int64
+		now   time.Time
+		want  int64
+	}{
+		{
+			desc:  "first sample is only remembered",
+			key:   "user1:0",
+			bytes: 1000,
+			now:   time.Date(2013, 5, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			desc:  "increase is accounted",
+			key:   "user1:0",
+			bytes: 1500,
+			now:   time.Date(2013, 5, 2, 0, 0, 5, 0, time.UTC),
+			want:  500,
+		},
+		{
+			desc:  "counter reset is accounted from zero",
+			key:   "user1:0",
+			bytes: 100,
+			now:   time.Date(2013, 5, 2, 0, 0, 10, 0, time.UTC),
+			want:  600,
+		},
+		{
+			desc:  "users are accounted independently",
+			key:   "user1:1",
+			bytes: 7,
+			now:   time.Date(2013, 5, 2, 0, 0, 10, 0, time.UTC),
+		},
+		{
+			desc:  "new period starts from zero",
+			key:   "user1:0",
+			bytes: 300,
+			now:   time.Date(2013, 6, 1, 0, 0, 5, 0, time.UTC),
+			want:  200,
+		},
+	}
+
+	q := &quotaTracker{
+		resetDay: 1,
+		Users:    make(map[string]*quotaUsage),
+	}
+	for _, step := range steps {
+		if got := q.add(step.key, step.bytes, step.now); got != step.want {
+			t.Errorf("%s: add(%s, %d) => got: %d, want: %d", step.desc, step.key, step.bytes, got, step.want)
+		}
+	}
+}
+
+func TestQuotaTrackerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	now := time.Date(2013, 5, 2, 0, 0, 0, 0, time.Local)
+
+	q, err := loadQuota(path, 1)
+	if err != nil {
+		t.Fatalf("loadQuota of a missing file => unexpected error: %s", err)
+	}
+	q.add("user1:0", 1000, now)
+	q.add("user1:0", 1500, now)
+	if err := q.save(); err != nil {
+		t.Fatalf("save => unexpected error: %s", err)
+	}
+
+	// The accounting continues from the persisted totals after a restart.
+	q, err = loadQuota(path, 1)
+	if err != nil {
+		t.Fatalf("loadQuota => unexpected error: %s", err)
+	}
+	if got := q.add("user1:0", 1800, now); got != 800 {
+		t.Errorf("add after loadQuota => got: %d, want: 800", got)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage"), quotaFileMode); err != nil {
+		t.Fatalf("WriteFile => unexpected error: %s", err)
+	}
+	q, err = loadQuota(path, 1)
+	if err == nil {
+		t.Errorf("loadQuota of a corrupted file => got no error, want an error")
+	}
+	if len(q.Users) != 0 {
+		t.Errorf("loadQuota of a corrupted file => got users %v, want none", q.Users)
+	}
+}
+
+func TestQuotaTrackerExpire(t *testing.T) {
+	q := &quotaTracker{
+		resetDay: 1,
+		Users:    make(map[string]*quotaUsage),
+	}
+	q.add("user1:0", 1000, time.Date(2013, 5, 2, 12, 0, 0, 0, time.UTC))
+	q.add("user2:0", 1000, time.Date(2013, 5, 2, 12, 0, 0, 0, time.UTC))
+	q.add("user2:0", 1500, time.Date(2013, 5, 20, 23, 0, 0, 0, time.UTC))
+	q.changed = false
+
+	// The user seen on the day of the retention is kept.
+	q.expire(time.Date(2013, 5, 20, 8, 0, 0, 0, time.UTC))
+	if _, ok := q.Users["user1:0"]; ok {
+		t.Errorf("expire => got user1:0, want it forgotten")
+	}
+	if _, ok := q.Users["user2:0"]; !ok {
+		t.Errorf("expire => got no user2:0, want it kept")
+	}
+	if !q.changed {
+		t.Errorf("expire => got unchanged, want the file saved again")
+	}
+}
+
+func TestQuotaTrackerSeen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	// The totals persisted without the day the users were last seen on.
+	if err := ioutil.WriteFile(path, []byte(`{"periodStart": "2013-05-01T00:00:00Z", "users": {"user1:0": {"bytes": 500, "last": 1500}}}`), quotaFileMode); err != nil {
+		t.Fatalf("WriteFile => unexpected error: %s", err)
+	}
+	q, err := loadQuota(path, 1)
+	if err != nil {
+		t.Fatalf("loadQuota => unexpected error: %s", err)
+	}
+	q.expire(time.Now().Add(-time.Hour))
+	if _, ok := q.Users["user1:0"]; !ok {
+		t.Errorf("expire after loadQuota => got no user1:0, want it counted as seen today")
+	}
+
+	// The day is recorded once a day.
+	q.changed = false
+	q.add("user1:0", 1600, time.Now())
+	q.changed = false
+	if q.add("user1:0", 1600, time.Now()); q.changed {
+		t.Errorf("add of the same counter on the same day => got changed, want unchanged")
+	}
+}
+
+func TestQuotaLevel(t *testing.T) {
+	testData := []struct {
+		desc  string
+		used  int64
+		limit int64
+		want  int64
+	}{
+		{"below the thresholds", 79, 100, 0},
+		{"at the warning threshold", 80, 100, 80},
+		{"between the thresholds", 99, 100, 80},
+		{"at the quota", 100, 100, 100},
+		{"over the quota", 250, 100, 100},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := quotaLevel(tc.used, tc.limit); got != tc.want {
+				t.Errorf("quotaLevel(%d, %d) => got: %d, want: %d", tc.used, tc.limit, got, tc.want)
+			}
+		})
+	}
+}
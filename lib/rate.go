@@ -0,0 +1,111 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+rate.go keeps the recent byte counters of the Qdiscs / Classes and users in ring buffers and computes their moving
+average rates and the peak rate since start. The averages are smoother than the differences of single intervals.
+The samples are timed by the monotonic timestamps of the parse cycles, so that NTP steps and suspends don't distort
+the rates.
+*/
+
+package lib
+
+import (
+	"time"
+)
+
+// numRateWindows is the number of the exported moving average rates.
+const numRateWindows = 3
+
+// rateWindows are the windows of the exported moving average rates.
+var rateWindows = [numRateWindows]time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+// rateSample is the byte counter at the time of a parse cycle.
+type rateSample struct {
+	// bytes is the accumulated byte counter.
+	bytes int64
+
+	// at is the monotonic timestamp of the parse cycle, see monotonicNow.
+	at time.Duration
+}
+
+// rateRing is a ring buffer of the most recent rateSamples.
+type rateRing struct {
+	// samples hold the rateSamples, the oldest one is overwritten when it is full.
+	samples []rateSample
+
+	// next is the position in samples where the next rateSample is stored.
+	next int
+
+	// count is the number of stored rateSamples.
+	count int
+
+	// peak is the highest rate in bits per second between two consecutive rateSamples since start.
+	peak int64
+
+	// cycle is the parse cycle in which the last rateSample was added.
+	cycle int
+}
+
+// newRateRing creates a rateRing holding the size most recent rateSamples.
+func newRateRing(size int) *rateRing {
+	return &rateRing{
+		samples: make([]rateSample, size),
+	}
+}
+
+// at returns the i-th stored rateSample, zero is the oldest one.
+func (r *rateRing) at(i int) rateSample {
+	return r.samples[(r.next-r.count+i+len(r.samples))%len(r.samples)]
+}
+
+// add stores the byte counter at the monotonic timestamp and updates the peak rate.
+func (r *rateRing) add(bytes int64, at time.Duration) {
+	if r.count > 0 {
+		if rate, ok := bitRate(r.at(r.count-1), rateSample{bytes, at}); ok && rate > r.peak {
+			r.peak = rate
+		}
+	}
+	r.samples[r.next] = rateSample{bytes, at}
+	r.next = (r.next + 1) % len(r.samples)
+	if r.count < len(r.samples) {
+		r.count++
+	}
+}
+
+// average returns the average rate in bits per second over the window ending with the last rateSample. If the ring
+// doesn't reach back over the whole window, the average is computed over the stored rateSamples.
+// Returns false if there aren't two rateSamples within the window.
+func (r *rateRing) average(window time.Duration) (int64, bool) {
+	if r.count < 2 {
+		return 0, false
+	}
+	last := r.at(r.count - 1)
+	for i := 0; i < r.count-1; i++ {
+		if first := r.at(i); last.at-first.at <= window {
+			return bitRate(first, last)
+		}
+	}
+	return 0, false
+}
+
+// bitRate returns the rate in bits per second between the two rateSamples, false if no time elapsed between them.
+func bitRate(first, last rateSample) (int64, bool) {
+	elapsed := (last.at - first.at).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return int64(float64(last.bytes-first.bytes) * 8 / elapsed), true
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateRing(t *testing.T) {
+	start := time.Hour
+	testData := []struct {
+		desc     string
+		size     int
+		samples  []int64
+		interval time.Duration
+		window   time.Duration
+		want     int64
+		wantOk   bool
+		wantPeak int64
+	}{
+		{
+			desc:     "single sample",
+			size:     4,
+			samples:  []int64{1000},
+			interval: time.Second,
+			window:   time.Minute,
+		},
+		{
+			desc:     "average over all samples",
+			size:     4,
+			samples:  []int64{0, 1000, 3000},
+			interval: time.Second,
+			window:   time.Minute,
+			want:     12000,
+			wantOk:   true,
+			wantPeak: 16000,
+		},
+		{
+			desc:     "window shorter than the samples",
+			size:     4,
+			samples:  []int64{0, 1000, 3000, 3500},
+			interval: 30 * time.Second,
+			window:   time.Minute,
+			want:     333,
+			wantOk:   true,
+			wantPeak: 533,
+		},
+		{
+			desc:     "oldest samples are overwritten",
+			size:     2,
+			samples:  []int64{0, 10000, 10100, 10200},
+			interval: 10 * time.Second,
+			window:   time.Minute,
+			want:     80,
+			wantOk:   true,
+			wantPeak: 8000,
+		},
+		{
+			desc:     "window shorter than the interval",
+			size:     4,
+			samples:  []int64{0, 1000},
+			interval: 2 * time.Minute,
+			window:   time.Minute,
+			wantPeak: 66,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := newRateRing(tc.size)
+			for i, bytes := range tc.samples {
+				r.add(bytes, start+time.Duration(i)*tc.interval)
+			}
+			if got, ok := r.average(tc.window); got != tc.want || ok != tc.wantOk {
+				t.Errorf("average(%v) => got: %d, %v, want: %d, %v", tc.window, got, ok, tc.want, tc.wantOk)
+			}
+			if r.peak != tc.wantPeak {
+				t.Errorf("peak => got: %d, want: %d", r.peak, tc.wantPeak)
+			}
+		})
+	}
+}
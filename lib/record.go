@@ -0,0 +1,254 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+record.go saves the raw TC outputs of every parse cycle to a directory and feeds them back through the parser later.
+A recording taken on a production shaper reproduces its parsing problems, e.g. the lines that match no pattern, on a
+development machine without TC. Each parse cycle is a file named by the time of the cycle, so that the files sort in
+the order they were recorded.
+*/
+
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// recordDirMode are the permissions of the directory with the recorded parse cycles.
+	recordDirMode = 0755
+
+	// recordFileMode are the permissions of the files with the recorded parse cycles.
+	recordFileMode = 0644
+
+	// recordFileFormat is the name of the file with a recorded parse cycle, formatted with the Unix time of the cycle
+	// in nanoseconds.
+	recordFileFormat = "cycle-%020d.json"
+
+	// recordFilePattern matches the names of the files with the recorded parse cycles.
+	recordFilePattern = "cycle-*.json"
+)
+
+// recordedCycle is the persisted form of the TC outputs of a parse cycle.
+type recordedCycle struct {
+	// Time is when the TC commands were executed.
+	Time time.Time `json:"time"`
+
+	// Ifaces are the TC outputs of the interfaces in the order they were parsed.
+	Ifaces []recordedIface `json:"ifaces"`
+}
+
+// recordedIface is the persisted form of an ifaceOutput.
+type recordedIface struct {
+	// Iface is the name of the interface.
+	Iface string `json:"iface"`
+
+	// IfIndex is the kernel ifIndex of the interface, zero if it couldn't be resolved.
+	IfIndex int `json:"ifIndex"`
+
+	// Qdisc is the output of the TC command that shows the Qdisc statistics.
+	Qdisc string `json:"qdisc"`
+
+	// Class is the output of the TC command that shows the Class statistics.
+	Class string `json:"class"`
+}
+
+// record saves the TC outputs of the parse cycle executed at the time to the RecordDir. It reads and closes the
+// outputs, and returns the outputs to be parsed instead of them, split by the interfaces. The cycles where a TC
+// command failed aren't recorded, the returned outputs end with the failed one then.
+func (t *tcParser) record(now time.Time, outputs []ifaceOutput) []ifaceOutput {
+	defer func() {
+		for i := range outputs {
+			outputs[i].close()
+		}
+	}()
+	cycle := recordedCycle{Time: now}
+	recorded := make([]ifaceOutput, 0, len(outputs))
+	for _, output := range outputs {
+		ifaces, err := bufferOutput(&output)
+		if err != nil {
+			return append(recorded, ifaceOutput{iface: output.iface, ifIndex: output.ifIndex, err: err})
+		}
+		for _, iface := range ifaces {
+			cycle.Ifaces = append(cycle.Ifaces, iface)
+			recorded = append(recorded, iface.output())
+		}
+	}
+	if err := saveCycle(t.options.RecordDir, &cycle); err != nil {
+		t.logger.Err(fmt.Sprintf("record(): Unable to record the TC outputs to %s, error: %s", t.options.RecordDir, err))
+	}
+	return recorded
+}
+
+// bufferOutput reads and closes the outputs of the TC commands, the output of all the interfaces is split by its
+// members. The members without a Qdisc or Class in the output have empty outputs.
+func bufferOutput(output *ifaceOutput) ([]recordedIface, error) {
+	qdisc, err := readCommand(output.qdiscOutput, nil)
+	if err != nil {
+		return nil, err
+	}
+	class, err := readCommand(output.classOutput, nil)
+	if err != nil {
+		return nil, err
+	}
+	if output.members == nil {
+		return []recordedIface{{output.iface, output.ifIndex, readString(qdisc), readString(class)}}, nil
+	}
+	qdiscs, err := splitByIface(qdisc)
+	if err != nil {
+		return nil, err
+	}
+	classes, err := splitByIface(class)
+	if err != nil {
+		return nil, err
+	}
+	ifaces := make([]recordedIface, 0, len(output.members))
+	for _, member := range output.members {
+		iface := recordedIface{Iface: member.iface, IfIndex: member.ifIndex}
+		if output, ok := qdiscs[member.iface]; ok {
+			iface.Qdisc = output.String()
+		}
+		if output, ok := classes[member.iface]; ok {
+			iface.Class = output.String()
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+// readString returns the content of the reader returned by readCommand.
+func readString(output io.Reader) string {
+	content, _ := ioutil.ReadAll(output)
+	return string(content)
+}
+
+// saveCycle writes the recorded parse cycle to a new file in the directory, the directory is created if needed.
+func saveCycle(dir string, cycle *recordedCycle) error {
+	if err := os.MkdirAll(dir, recordDirMode); err != nil {
+		return err
+	}
+	content, err := json.Marshal(cycle)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf(recordFileFormat, cycle.Time.UnixNano()))
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, recordFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// loadRecording returns the parse cycles recorded in the directory in the order they were recorded. Returns an error
+// if there are none.
+func loadRecording(dir string) ([]recordedCycle, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, recordFilePattern))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no parse cycles are recorded in %s", dir)
+	}
+	sort.Strings(paths)
+	cycles := make([]recordedCycle, 0, len(paths))
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var cycle recordedCycle
+		if err := json.Unmarshal(content, &cycle); err != nil {
+			return nil, fmt.Errorf("invalid recorded parse cycle %s: %s", path, err)
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles, nil
+}
+
+// outputs returns the TC outputs of the recorded parse cycle.
+func (c *recordedCycle) outputs() []ifaceOutput {
+	outputs := make([]ifaceOutput, 0, len(c.Ifaces))
+	for _, iface := range c.Ifaces {
+		outputs = append(outputs, iface.output())
+	}
+	return outputs
+}
+
+// output returns the TC outputs of the recorded interface.
+func (r *recordedIface) output() ifaceOutput {
+	return ifaceOutput{
+		iface:       r.Iface,
+		ifIndex:     r.IfIndex,
+		qdiscOutput: ioutil.NopCloser(strings.NewReader(r.Qdisc)),
+		classOutput: ioutil.NopCloser(strings.NewReader(r.Class)),
+	}
+}
+
+// replay feeds the recorded parse cycles through the parser until the context is done. The pauses between the parse
+// cycles are the same as when they were recorded if ReplayRealtime is set, otherwise the cycles follow right after
+// each other. The ready channel is closed after the first parse cycle, or once the replay is canceled before it.
+func (t *tcParser) replay(ctx context.Context, cycles []recordedCycle) {
+	ready := t.ready
+	defer func() {
+		if ready != nil {
+			close(ready)
+		}
+	}()
+	for i := range cycles {
+		if i > 0 && t.options.ReplayRealtime {
+			timer := time.NewTimer(cycles[i].Time.Sub(cycles[i-1].Time))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		t.replayCycle(&cycles[i])
+		if ready != nil {
+			close(ready)
+			ready = nil
+		}
+	}
+	t.logger.Info(fmt.Sprintf("replay(): Replayed %d parse cycles from %s.", len(cycles), t.options.ReplayDir))
+}
+
+// replayCycle runs a parse cycle on the recorded TC outputs instead of executing the TC commands.
+func (t *tcParser) replayCycle(cycle *recordedCycle) {
+	t.parseMu.Lock()
+	t.replayed = cycle.outputs()
+	t.parseMu.Unlock()
+	t.parseTc()
+}
+
+// replayedIfaces returns the interfaces of the replayed TC outputs.
+func replayedIfaces(outputs []ifaceOutput) []string {
+	ifaces := make([]string, 0, len(outputs))
+	for _, output := range outputs {
+		ifaces = append(ifaces, output.iface)
+	}
+	return ifaces
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// newRecordParser returns a tcParser that executes the TC commands by the fakeExecuter and its fakeDataSink.
+func newRecordParser(options *TcParserOptions, fe *fakeExecuter) (*tcParser, *fakeDataSink) {
+	fsn := &fakeDataSink{}
+	return &tcParser{
+		logger:        &fakeSyslog{},
+		options:       options,
+		sink:          fsn,
+		executer:      fe,
+		ifaceReader:   &fakeIfaceReader{index: 2},
+		linkWatcher:   &fakeLinkWatcher{},
+		reQdiscHeader: regexp.MustCompile(reQdiscHeaderStr),
+		reClassHeader: regexp.MustCompile(reClassHeaderStr),
+		reStats:       regexp.MustCompile(reStatsStr),
+		ready:         make(chan struct{}),
+		resume:        make(chan struct{}, 1),
+	}, fsn
+}
+
+func TestTcParserRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	qdiscs := []string{
+		"qdisc htb 1: root refcnt 2 r2q 10 default 0\n Sent 100 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n",
+		"qdisc htb 1: root refcnt 2 r2q 10 default 0\n Sent 300 bytes 3 pkt (dropped 1, overlimits 0 requeues 0)\n",
+	}
+	classes := []string{
+		"class htb 1:10 parent 1:1 prio 0 rate 1Mbit\n Sent 50 bytes 1 pkt (dropped 0, overlimits 0 requeues 0)\n",
+		"class htb 1:10 parent 1:1 prio 0 rate 1Mbit\n Sent 150 bytes 2 pkt (dropped 0, overlimits 0 requeues 0)\n",
+	}
+	// The failed parse cycle in the middle isn't recorded.
+	recorder, recorded := newRecordParser(&TcParserOptions{Ifaces: []string{"eth0"}, MaxCommands: 1, RecordDir: dir}, &fakeExecuter{
+		output: []string{qdiscs[0], classes[0], "", qdiscs[1], classes[1]},
+		err:    []error{nil, nil, errors.New("tc failed"), nil, nil},
+	})
+	recorder.parseTc()
+	recorder.skipCycles = 0
+	recorder.parseTc()
+	recorder.skipCycles = 0
+	recorder.parseTc()
+
+	cycles, err := loadRecording(dir)
+	if err != nil {
+		t.Fatalf("loadRecording => unexpected error: %s", err)
+	}
+	if len(cycles) != 2 {
+		t.Fatalf("loadRecording => got %d parse cycles, want: 2", len(cycles))
+	}
+	for i, cycle := range cycles {
+		want := []recordedIface{{"eth0", 2, qdiscs[i], classes[i]}}
+		if !reflect.DeepEqual(cycle.Ifaces, want) {
+			t.Errorf("loadRecording => cycle %d got: %v, want: %v", i, cycle.Ifaces, want)
+		}
+	}
+	if !cycles[0].Time.Before(cycles[1].Time) {
+		t.Errorf("loadRecording => the cycles at %s and %s are out of order", cycles[0].Time, cycles[1].Time)
+	}
+
+	// The replay doesn't execute any TC commands and parses the same data.
+	replayer, replayed := newRecordParser(&TcParserOptions{Ifaces: []string{"eth1"}, ReplayDir: dir}, &fakeExecuter{})
+	if err := replayer.Start(context.Background()); err != nil {
+		t.Fatalf("Start => unexpected error: %s", err)
+	}
+	<-replayer.Ready()
+	replayer.Stop()
+	if !reflect.DeepEqual(replayed.data, recorded.data) {
+		t.Errorf("replay => got data: %v, want: %v", replayed.data, recorded.data)
+	}
+	if replayed.eraseCount != 2 {
+		t.Errorf("replay => eraseCount got: %d, want: 2", replayed.eraseCount)
+	}
+}
+
+func TestTcParserReplayRealtime(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now()
+	gap := 50 * time.Millisecond
+	for _, at := range []time.Time{start, start.Add(gap)} {
+		if err := saveCycle(dir, &recordedCycle{Time: at, Ifaces: []recordedIface{{Iface: "eth0"}}}); err != nil {
+			t.Fatalf("saveCycle => unexpected error: %s", err)
+		}
+	}
+	cycles, err := loadRecording(dir)
+	if err != nil {
+		t.Fatalf("loadRecording => unexpected error: %s", err)
+	}
+
+	for _, realtime := range []bool{false, true} {
+		p, fsn := newRecordParser(&TcParserOptions{ReplayDir: dir, ReplayRealtime: realtime}, &fakeExecuter{})
+		began := time.Now()
+		p.replay(context.Background(), cycles)
+		took := time.Since(began)
+		if fsn.eraseCount != 2 {
+			t.Errorf("replay(realtime: %t) => eraseCount got: %d, want: 2", realtime, fsn.eraseCount)
+		}
+		if realtime && took < gap {
+			t.Errorf("replay(realtime: %t) => took %s, want at least %s", realtime, took, gap)
+		}
+		select {
+		case <-p.ready:
+		default:
+			t.Errorf("replay(realtime: %t) => the ready channel wasn't closed", realtime)
+		}
+	}
+
+	// A canceled replay still closes the ready channel.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, _ := newRecordParser(&TcParserOptions{ReplayDir: dir}, &fakeExecuter{})
+	p.replay(ctx, cycles)
+	select {
+	case <-p.ready:
+	default:
+		t.Errorf("replay after cancel => the ready channel wasn't closed")
+	}
+}
+
+func TestLoadRecording(t *testing.T) {
+	testData := []struct {
+		desc    string
+		files   map[string]string
+		wantErr bool
+	}{
+		{
+			desc:    "empty directory",
+			wantErr: true,
+		},
+		{
+			desc:    "invalid parse cycle",
+			files:   map[string]string{"cycle-00000000000000000001.json": "garbage"},
+			wantErr: true,
+		},
+		{
+			desc: "other files are ignored",
+			files: map[string]string{
+				"cycle-00000000000000000001.json":     `{"time": "2013-05-02T00:00:00Z", "ifaces": []}`,
+				"cycle-00000000000000000002.json.tmp": "garbage",
+				"notes.txt":                           "garbage",
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), recordFileMode); err != nil {
+					t.Fatalf("WriteFile => unexpected error: %s", err)
+				}
+			}
+			_, err := loadRecording(dir)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("loadRecording => got error: %v, want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
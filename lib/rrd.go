@@ -0,0 +1,352 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+rrd.go creates and updates RRD files with rrdtool after each parse cycle, so that the classic MRTG / RRD setups can
+graph the Qdiscs / Classes, users and groups without polling SNMP.
+
+Every Qdisc / Class, user and group has its own file in a subdirectory of the configured directory named by its kind,
+e.g. "class/eth0_2_3.rrd" or "user/john.rrd". The characters other than letters, digits, "_", "-" and "." in the
+names are replaced with "_". The files have one DERIVE data source for each counter:
+class - bytes, pkt, dropped, overLimit.
+user  - downBytes, downPkt, downDropped, downOverLimit, upBytes, upPkt, upDropped, upOverLimit.
+group - bytes, pkt, dropped, overLimit.
+The files keep the averages and maximums of a day at the step, of a week at 30 minutes, of a month at 2 hours and of
+a year at 1 day. With the historyRetention the archives of the files of the users span at most the retention, the
+archives coarser than the retention are left out, and the files of the users that weren't updated for longer than the
+retention are removed, so that no history of a subscriber is kept longer.
+*/
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// rrdCmdPath is the default path to the rrdtool command.
+	rrdCmdPath = "/usr/bin/rrdtool"
+
+	// rrdQueueSize is the number of parse cycles waiting to be written, the further ones are dropped.
+	rrdQueueSize = 4
+
+	// rrdTimeout is the timeout of a single rrdtool command.
+	rrdTimeout = 10 * time.Second
+
+	// rrdUnknown is the value of the data sources without a value in the parse cycle.
+	rrdUnknown = "U"
+
+	// rrdExpireInterval is the period in which the files of the users are checked against the Retention.
+	rrdExpireInterval = time.Hour
+)
+
+var (
+	// rrdSources are the data sources of the files for the name leafs of the Qdiscs / Classes, users and groups.
+	rrdSources = map[int][]rrdSource{
+		tcNameLeaf: {
+			{"bytes", "sentBytes"},
+			{"pkt", "sentPkt"},
+			{"dropped", "droppedPkt"},
+			{"overLimit", "overLimitPkt"},
+		},
+		tcUserNameLeaf: {
+			{"downBytes", "userDownBytes"},
+			{"downPkt", "userDownPkt"},
+			{"downDropped", "userDownDroppedPkt"},
+			{"downOverLimit", "userDownOverLimitPkt"},
+			{"upBytes", "userUpBytes"},
+			{"upPkt", "userUpPkt"},
+			{"upDropped", "userUpDroppedPkt"},
+			{"upOverLimit", "userUpOverLimitPkt"},
+		},
+		tcGroupNameLeaf: {
+			{"bytes", "groupBytes"},
+			{"pkt", "groupPkt"},
+			{"dropped", "groupDroppedPkt"},
+			{"overLimit", "groupOverLimitPkt"},
+		},
+		tcIfaceNameLeaf: {
+			{"bytes", "ifaceBytes"},
+			{"pkt", "ifacePkt"},
+			{"dropped", "ifaceDroppedPkt"},
+			{"overLimit", "ifaceOverLimitPkt"},
+		},
+	}
+
+	// rrdArchives are the resolutions and the spans of the round robin archives, both in seconds.
+	rrdArchives = [][2]int{
+		{0, 24 * 3600},
+		{1800, 7 * 24 * 3600},
+		{2 * 3600, 31 * 24 * 3600},
+		{24 * 3600, 366 * 24 * 3600},
+	}
+
+	// reRrdUnsafe matches the characters that are replaced in the file names.
+	reRrdUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+)
+
+// rrdSource is a data source of the RRD files.
+type rrdSource struct {
+	// name is the name of the data source, at most 19 characters.
+	name string
+
+	// metric is the name of the metric stored in the data source, one of the namedMetrics.
+	metric string
+}
+
+// RrdOptions are the options of the RRD sink.
+type RrdOptions struct {
+	// Dir is the directory of the RRD files. The files aren't updated if this isn't set.
+	Dir string
+
+	// Step is the period between the updates, the parse interval. It is rounded up to whole seconds. Defaults to
+	// parseInterval.
+	Step time.Duration
+
+	// CmdPath is the path to the rrdtool command. Defaults to rrdCmdPath.
+	CmdPath string
+
+	// Retention is the time after which the history of the users is removed, see expire. The files keep a year if this
+	// isn't set.
+	Retention time.Duration
+}
+
+// step returns the configured step, or the default one if it wasn't set.
+func (o *RrdOptions) step() int {
+	if o.Step != 0 {
+		return ceilSeconds(o.Step)
+	}
+	return ceilSeconds(parseInterval)
+}
+
+// cmdPath returns the configured path to rrdtool, or the default one if it wasn't set.
+func (o *RrdOptions) cmdPath() string {
+	if o.CmdPath != "" {
+		return o.CmdPath
+	}
+	return rrdCmdPath
+}
+
+// rrdUpdate is the update of a single RRD file.
+type rrdUpdate struct {
+	// path is the path of the file.
+	path string
+
+	// nameLeaf tells the kind of the file, it selects the rrdSources.
+	nameLeaf int
+
+	// values are the values of the rrdSources, rrdUnknown if they are missing.
+	values []string
+}
+
+// rrdCycle are the updates of a parse cycle.
+type rrdCycle struct {
+	// at is the time the parse cycle started.
+	at time.Time
+
+	// updates are the updates of the files.
+	updates []rrdUpdate
+}
+
+// rrdSink implements metricSink.
+type rrdSink struct {
+	// options holds the configurable options.
+	options *RrdOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// executer runs rrdtool.
+	executer commandExecuter
+
+	// queue holds the parse cycles until they are written.
+	queue chan rrdCycle
+
+	// done is closed when all the queued parse cycles were written.
+	done chan struct{}
+
+	// expired is the time of the parse cycle the files of the users were last checked in, see expire.
+	expired time.Time
+}
+
+// newRrdSink creates a rrdSink and starts updating the files in the background.
+func newRrdSink(options *RrdOptions, logger sysLogger) *rrdSink {
+	r := &rrdSink{
+		options:  options,
+		logger:   logger,
+		executer: &systemCommand{},
+		queue:    make(chan rrdCycle, rrdQueueSize),
+		done:     make(chan struct{}),
+	}
+	go r.send()
+	return r
+}
+
+// flush groups the values by the files and queues them. The values are dropped if the queue is full.
+func (r *rrdSink) flush(values []metricValue, at time.Time) {
+	if len(values) == 0 {
+		return
+	}
+	select {
+	case r.queue <- rrdCycle{at, r.updates(values)}:
+	default:
+		r.logger.Err(fmt.Sprintf("flush(): Too many parse cycles waiting, dropping the updates of the RRD files in %s.", r.options.Dir))
+	}
+}
+
+// updates groups the values of the rrdSources by the files, in the order the files first appear in the values.
+func (r *rrdSink) updates(values []metricValue) []rrdUpdate {
+	var updates []rrdUpdate
+	positions := make(map[string]int)
+	for _, v := range values {
+		sources, ok := rrdSources[v.nameLeaf]
+		if !ok {
+			continue
+		}
+		for i, source := range sources {
+			if source.metric != v.metric {
+				continue
+			}
+			path := filepath.Join(r.options.Dir, v.kind(), reRrdUnsafe.ReplaceAllString(v.name, "_")+".rrd")
+			position, ok := positions[path]
+			if !ok {
+				position = len(updates)
+				positions[path] = position
+				update := rrdUpdate{path, v.nameLeaf, make([]string, len(sources))}
+				for j := range update.values {
+					update.values[j] = rrdUnknown
+				}
+				updates = append(updates, update)
+			}
+			updates[position].values[i] = fmt.Sprint(v.value)
+		}
+	}
+	return updates
+}
+
+// close writes the queued parse cycles.
+func (r *rrdSink) close(ctx context.Context) error {
+	close(r.queue)
+	return awaitSender(ctx, r.done)
+}
+
+// send writes the queued parse cycles until the queue is closed.
+func (r *rrdSink) send() {
+	defer close(r.done)
+	for cycle := range r.queue {
+		r.write(cycle)
+		if r.options.Retention > 0 && cycle.at.Sub(r.expired) >= rrdExpireInterval {
+			r.expired = cycle.at
+			r.expire(cycle.at.Add(-r.options.Retention))
+		}
+	}
+}
+
+// expire removes the files of the users that weren't updated since the time.
+func (r *rrdSink) expire(before time.Time) {
+	paths, _ := filepath.Glob(filepath.Join(r.options.Dir, metricValue{nameLeaf: tcUserNameLeaf}.kind(), "*.rrd"))
+	removed := 0
+	for _, path := range paths {
+		expired, err := expireFile(path, before)
+		if err != nil {
+			r.logger.Err(fmt.Sprintf("expire(): Unable to remove the expired RRD file %s, error: %s", path, err))
+			continue
+		}
+		if expired {
+			removed++
+		}
+	}
+	if removed > 0 {
+		r.logger.Info(fmt.Sprintf("expire(): Removed %d RRD files of the users in %s, they are older than the historyRetention.", removed, r.options.Dir))
+	}
+}
+
+// write creates the missing files and updates all the files of the parse cycle. Only the first error of the cycle
+// is logged, so that a missing rrdtool doesn't flood Syslog.
+func (r *rrdSink) write(cycle rrdCycle) {
+	var failed int
+	var firstErr error
+	for _, update := range cycle.updates {
+		if err := r.update(update, cycle.at); err != nil {
+			if failed == 0 {
+				firstErr = err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		r.logger.Err(fmt.Sprintf("write(): Unable to update %d of the RRD files in %s, error: %s", failed, r.options.Dir, firstErr))
+	}
+}
+
+// update creates the file if it doesn't exist and updates it with the values.
+func (r *rrdSink) update(update rrdUpdate, at time.Time) error {
+	if _, err := os.Stat(update.path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(update.path), 0755); err != nil {
+			return err
+		}
+		if err := r.run(r.createArgs(update, at)...); err != nil {
+			return err
+		}
+	}
+	return r.run("update", update.path, strconv.FormatInt(at.Unix(), 10)+":"+strings.Join(update.values, ":"))
+}
+
+// createArgs returns the arguments of rrdtool that create the file, the first update at the time must be accepted.
+// The archives of the files of the users are limited by the Retention.
+func (r *rrdSink) createArgs(update rrdUpdate, at time.Time) []string {
+	step := r.options.step()
+	retention := 0
+	if update.nameLeaf == tcUserNameLeaf && r.options.Retention > 0 {
+		retention = ceilSeconds(r.options.Retention)
+	}
+	args := []string{"create", update.path, "--start", strconv.FormatInt(at.Unix()-1, 10), "--step", strconv.Itoa(step)}
+	for _, source := range rrdSources[update.nameLeaf] {
+		args = append(args, fmt.Sprintf("DS:%s:DERIVE:%d:0:U", source.name, 2*step))
+	}
+	for _, cf := range []string{"AVERAGE", "MAX"} {
+		for i, archive := range rrdArchives {
+			steps := archive[0] / step
+			if steps < 1 {
+				steps = 1
+			}
+			span := archive[1]
+			if retention > 0 && i > 0 && steps*step > retention {
+				continue
+			}
+			if retention > 0 && retention < span {
+				span = retention
+			}
+			rows := (span + steps*step - 1) / (steps * step)
+			args = append(args, fmt.Sprintf("RRA:%s:0.5:%d:%d", cf, steps, rows))
+		}
+	}
+	return args
+}
+
+// run runs rrdtool with the arguments.
+func (r *rrdSink) run(args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rrdTimeout)
+	defer cancel()
+	_, err := readCommand(r.executer.Execute(ctx, r.options.cmdPath(), args...))
+	return err
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRrdSinkUpdates(t *testing.T) {
+	r := &rrdSink{options: &RrdOptions{Dir: "/rrd"}}
+	values := []metricValue{
+		{"droppedPkt", tcNameLeaf, "eth0:2:3", int64(1)},
+		{"efficiency", tcNameLeaf, "eth0:2:3", int64(97)},
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1500)},
+		{"userUpBytes", tcUserNameLeaf, "john doe", int64(10)},
+		{"groupPkt", tcGroupNameLeaf, "uplinks", int64(7)},
+	}
+	want := []rrdUpdate{
+		{"/rrd/class/eth0_2_3.rrd", tcNameLeaf, []string{"1500", "U", "1", "U"}},
+		{"/rrd/user/john_doe.rrd", tcUserNameLeaf, []string{"U", "U", "U", "U", "10", "U", "U", "U"}},
+		{"/rrd/group/uplinks.rrd", tcGroupNameLeaf, []string{"U", "7", "U", "U"}},
+	}
+	if got := r.updates(values); !reflect.DeepEqual(got, want) {
+		t.Errorf("updates => got: %v, want: %v", got, want)
+	}
+}
+
+func TestRrdSinkCreateArgs(t *testing.T) {
+	r := &rrdSink{options: &RrdOptions{Dir: "/rrd", Step: 10 * time.Second}}
+	got := r.createArgs(rrdUpdate{"/rrd/group/uplinks.rrd", tcGroupNameLeaf, nil}, time.Unix(1357000000, 0))
+	want := []string{
+		"create", "/rrd/group/uplinks.rrd", "--start", "1356999999", "--step", "10",
+		"DS:bytes:DERIVE:20:0:U", "DS:pkt:DERIVE:20:0:U", "DS:dropped:DERIVE:20:0:U", "DS:overLimit:DERIVE:20:0:U",
+		"RRA:AVERAGE:0.5:1:8640", "RRA:AVERAGE:0.5:180:336", "RRA:AVERAGE:0.5:720:372", "RRA:AVERAGE:0.5:8640:366",
+		"RRA:MAX:0.5:1:8640", "RRA:MAX:0.5:180:336", "RRA:MAX:0.5:720:372", "RRA:MAX:0.5:8640:366",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("createArgs => got: %q, want: %q", got, want)
+	}
+}
+
+func TestRrdSinkCreateArgsRetention(t *testing.T) {
+	r := &rrdSink{options: &RrdOptions{Dir: "/rrd", Step: 10 * time.Second, Retention: 12 * time.Hour}}
+	var got []string
+	for _, arg := range r.createArgs(rrdUpdate{"/rrd/user/john.rrd", tcUserNameLeaf, nil}, time.Unix(1357000000, 0)) {
+		if strings.HasPrefix(arg, "RRA:") {
+			got = append(got, arg)
+		}
+	}
+	want := []string{
+		"RRA:AVERAGE:0.5:1:4320", "RRA:AVERAGE:0.5:180:24", "RRA:AVERAGE:0.5:720:6",
+		"RRA:MAX:0.5:1:4320", "RRA:MAX:0.5:180:24", "RRA:MAX:0.5:720:6",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("createArgs of a user => got: %q, want: %q", got, want)
+	}
+
+	// The Qdiscs / Classes, groups and interfaces keep a year.
+	args := r.createArgs(rrdUpdate{"/rrd/group/uplinks.rrd", tcGroupNameLeaf, nil}, time.Unix(1357000000, 0))
+	if got := args[len(args)-1]; got != "RRA:MAX:0.5:8640:366" {
+		t.Errorf("createArgs of a group => got the last archive %s, want: RRA:MAX:0.5:8640:366", got)
+	}
+}
+
+func TestRrdSinkExpire(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	files := []struct {
+		path        string
+		updated     time.Time
+		wantRemoved bool
+	}{
+		{filepath.Join(dir, "user", "john.rrd"), old, true},
+		{filepath.Join(dir, "user", "jane.rrd"), time.Now(), false},
+		{filepath.Join(dir, "class", "eth0_1_1.rrd"), old, false},
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			t.Fatalf("MkdirAll => unexpected error: %s", err)
+		}
+		if err := os.WriteFile(f.path, nil, 0644); err != nil {
+			t.Fatalf("WriteFile => unexpected error: %s", err)
+		}
+		if err := os.Chtimes(f.path, f.updated, f.updated); err != nil {
+			t.Fatalf("Chtimes => unexpected error: %s", err)
+		}
+	}
+
+	logger := &fakeSyslog{}
+	r := &rrdSink{options: &RrdOptions{Dir: dir, Retention: time.Hour}, logger: logger}
+	r.expire(time.Now().Add(-time.Hour))
+	for _, f := range files {
+		_, err := os.Stat(f.path)
+		if removed := os.IsNotExist(err); removed != f.wantRemoved {
+			t.Errorf("expire => %s removed: %t, want: %t", f.path, removed, f.wantRemoved)
+		}
+	}
+	if len(logger.info) != 1 {
+		t.Errorf("expire => logged %q, want the number of the removed files", logger.info)
+	}
+}
+
+func TestRrdSinkWrite(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "class", "eth0_1_1.rrd")
+	missing := filepath.Join(dir, "class", "eth0_1_2.rrd")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatalf("MkdirAll => unexpected error: %s", err)
+	}
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatalf("WriteFile => unexpected error: %s", err)
+	}
+
+	logger := &fakeSyslog{}
+	executer := &fakeExecuter{
+		output: []string{"", "", ""},
+		err:    []error{nil, nil, errors.New("ERROR: illegal attempt to update")},
+	}
+	r := &rrdSink{
+		options:  &RrdOptions{Dir: dir, CmdPath: "/bin/rrdtool"},
+		logger:   logger,
+		executer: executer,
+	}
+	r.write(rrdCycle{time.Unix(1357000000, 0), []rrdUpdate{
+		{missing, tcNameLeaf, []string{"1", "2", "3", "4"}},
+		{existing, tcNameLeaf, []string{"5", "U", "U", "U"}},
+	}})
+
+	if len(executer.args) != 3 {
+		t.Fatalf("write => ran rrdtool %d times, want: 3, args: %q", len(executer.args), executer.args)
+	}
+	if got := executer.args[0][:2]; !reflect.DeepEqual(got, []string{"create", missing}) {
+		t.Errorf("write => first ran: %q, want to create %s", got, missing)
+	}
+	wantUpdates := [][]string{
+		{"update", missing, "1357000000:1:2:3:4"},
+		{"update", existing, "1357000000:5:U:U:U"},
+	}
+	if got := executer.args[1:]; !reflect.DeepEqual(got, wantUpdates) {
+		t.Errorf("write => ran: %q, want: %q", got, wantUpdates)
+	}
+	if executer.command[0] != "/bin/rrdtool" {
+		t.Errorf("write => ran: %s, want: /bin/rrdtool", executer.command[0])
+	}
+	wantErr := []string{"write(): Unable to update 1 of the RRD files in " + dir + ", error: ERROR: illegal attempt to update"}
+	if !reflect.DeepEqual(logger.err, wantErr) {
+		t.Errorf("write => logged: %q, want: %q", logger.err, wantErr)
+	}
+}
@@ -0,0 +1,355 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+samples.go appends the values of the metrics of each parse cycle to a file, either as CSV or as JSON lines, giving a
+historical record without any external dependency. The file is rotated to the same path with a ".1" suffix when it
+would grow over the configured size, the previous rotated file is overwritten. With the historyRetention the samples
+older than it are removed, see expire.
+
+Every value is written on its own row, the fields of the rows are configurable:
+time   - The time the parse cycle started, in RFC 3339.
+host   - The hostname.
+kind   - "class" for the Qdiscs / Classes, "user" for the users, "group" for the groups and "iface" for the interfaces.
+name   - The name of the Qdisc / Class, user, group or interface, e.g. "eth0:2:3".
+iface  - The interface of the Qdisc / Class or of the totals, "users" for the users and "groups" for the groups.
+class  - The Qdisc / Class without the interface, e.g. "2:3", or the name of the user or group.
+metric - The name of the metric as in the alert rules, e.g. "sentBytes".
+value  - The value of the metric.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// sampleFormatCSV writes the samples as CSV with a header row.
+	sampleFormatCSV = "csv"
+
+	// sampleFormatJSON writes the samples as JSON objects, one on each line.
+	sampleFormatJSON = "json"
+
+	// sampleQueueSize is the number of parse cycles waiting to be written, the further ones are dropped.
+	sampleQueueSize = 4
+
+	// sampleRotatedSuffix is appended to the path of the rotated file.
+	sampleRotatedSuffix = ".1"
+)
+
+var (
+	// sampleFields are the default fields of the samples.
+	sampleFields = []string{"time", "kind", "name", "metric", "value"}
+
+	// knownSampleFields are all the fields the samples can have.
+	knownSampleFields = map[string]bool{
+		"time":   true,
+		"host":   true,
+		"kind":   true,
+		"name":   true,
+		"iface":  true,
+		"class":  true,
+		"metric": true,
+		"value":  true,
+	}
+)
+
+// SampleFileOptions are the options of the sample file sink.
+type SampleFileOptions struct {
+	// Path is the path of the file the samples are appended to. The samples aren't written if this isn't set.
+	Path string
+
+	// Format is the format of the samples, either "csv" or "json". Defaults to "csv".
+	Format string
+
+	// MaxSize is the size in bytes over which the file is rotated. The file isn't rotated if this isn't set.
+	MaxSize int64
+
+	// Fields are the fields of the samples. Defaults to sampleFields.
+	Fields []string
+
+	// Retention is the time after which the samples are removed. The file is rotated whenever a new half of the
+	// Retention starts and the rotated file is removed once all of its samples are older. The samples are kept until
+	// the file is rotated by MaxSize if this isn't set.
+	Retention time.Duration
+}
+
+// format returns the configured format, or the default one if it wasn't set.
+func (o *SampleFileOptions) format() string {
+	if o.Format != "" {
+		return o.Format
+	}
+	return sampleFormatCSV
+}
+
+// fields returns the configured fields, or the default ones if they weren't set.
+func (o *SampleFileOptions) fields() []string {
+	if len(o.Fields) > 0 {
+		return o.Fields
+	}
+	return sampleFields
+}
+
+// validSampleFields returns an error if any of the fields is unknown.
+func validSampleFields(fields []string) error {
+	for _, field := range fields {
+		if !knownSampleFields[field] {
+			return fmt.Errorf("unknown sample field '%s'", field)
+		}
+	}
+	return nil
+}
+
+// sampleFileSink implements metricSink.
+type sampleFileSink struct {
+	// options holds the configurable options.
+	options *SampleFileOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// host is the hostname written in the host field.
+	host string
+
+	// file is the open file, nil if it isn't open.
+	file *os.File
+
+	// size is the current size of the file.
+	size int64
+
+	// period is the start of the period of the Retention that the file was last checked in, see expire.
+	period time.Time
+
+	// queue holds the encoded parse cycles until they are written.
+	queue chan []byte
+
+	// done is closed when all the queued parse cycles were written.
+	done chan struct{}
+}
+
+// newSampleFileSink creates a sampleFileSink and starts writing the samples in the background.
+// The file is opened when the first samples are written and reopened after errors.
+func newSampleFileSink(options *SampleFileOptions, logger sysLogger) *sampleFileSink {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Err(fmt.Sprintf("newSampleFileSink(): Unable to determine the hostname for the samples, error: %s", err))
+	}
+	f := &sampleFileSink{
+		options: options,
+		logger:  logger,
+		host:    hostname,
+		queue:   make(chan []byte, sampleQueueSize),
+		done:    make(chan struct{}),
+	}
+	go f.send()
+	return f
+}
+
+// flush encodes the values and queues them for writing. The values are dropped if the queue is full.
+func (f *sampleFileSink) flush(values []metricValue, at time.Time) {
+	if len(values) == 0 {
+		return
+	}
+	select {
+	case f.queue <- f.encode(values, at):
+	default:
+		f.logger.Err(fmt.Sprintf("flush(): Too many parse cycles waiting, dropping the samples for %s.", f.options.Path))
+	}
+}
+
+// fieldValue returns the field of the sample.
+func (f *sampleFileSink) fieldValue(field string, v metricValue, at time.Time) interface{} {
+	iface, class := v.components()
+	switch field {
+	case "time":
+		return at.Format(time.RFC3339)
+	case "host":
+		return f.host
+	case "kind":
+		return v.kind()
+	case "name":
+		return v.name
+	case "iface":
+		return iface
+	case "class":
+		return class
+	case "metric":
+		return v.metric
+	}
+	return v.value
+}
+
+// encode encodes the values in the configured format, one row for each.
+func (f *sampleFileSink) encode(values []metricValue, at time.Time) []byte {
+	var buf bytes.Buffer
+	fields := f.options.fields()
+	if f.options.format() == sampleFormatJSON {
+		for _, v := range values {
+			buf.WriteByte('{')
+			for i, field := range fields {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				// Strings and integers are always encoded.
+				name, _ := json.Marshal(field)
+				value, _ := json.Marshal(f.fieldValue(field, v, at))
+				buf.Write(name)
+				buf.WriteByte(':')
+				buf.Write(value)
+			}
+			buf.WriteString("}\n")
+		}
+		return buf.Bytes()
+	}
+
+	w := csv.NewWriter(&buf)
+	row := make([]string, len(fields))
+	for _, v := range values {
+		for i, field := range fields {
+			row[i] = fmt.Sprint(f.fieldValue(field, v, at))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// header returns the header row written at the start of a CSV file, nil for the other formats.
+func (f *sampleFileSink) header() []byte {
+	if f.options.format() != sampleFormatCSV {
+		return nil
+	}
+	return []byte(strings.Join(f.options.fields(), ",") + "\n")
+}
+
+// close writes the queued parse cycles and closes the file.
+func (f *sampleFileSink) close(ctx context.Context) error {
+	close(f.queue)
+	return awaitSender(ctx, f.done)
+}
+
+// send writes the queued parse cycles to the file until the queue is closed.
+func (f *sampleFileSink) send() {
+	defer close(f.done)
+	defer func() {
+		if f.file != nil {
+			f.file.Close()
+		}
+	}()
+	for rows := range f.queue {
+		if err := f.write(rows); err != nil {
+			f.logger.Err(fmt.Sprintf("send(): Unable to write the samples to %s, error: %s", f.options.Path, err))
+			if f.file != nil {
+				f.file.Close()
+				f.file = nil
+			}
+		}
+	}
+}
+
+// write appends the rows to the file, opening or rotating it first if needed.
+func (f *sampleFileSink) write(rows []byte) error {
+	if f.options.Retention > 0 {
+		if err := f.expire(time.Now()); err != nil {
+			return err
+		}
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.options.MaxSize > 0 && f.size > int64(len(f.header())) && f.size+int64(len(rows)) > f.options.MaxSize {
+		f.file.Close()
+		f.file = nil
+		if err := os.Rename(f.options.Path, f.options.Path+sampleRotatedSuffix); err != nil {
+			return err
+		}
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(rows)
+	f.size += int64(n)
+	return err
+}
+
+// expire enforces the Retention at the time. The time is split into the periods of half the Retention, the file holds
+// the samples of the current period and the rotated file those of the previous one. A file last written before the
+// previous period is removed, so that no sample is older than the Retention.
+func (f *sampleFileSink) expire(now time.Time) error {
+	length := f.options.Retention / 2
+	period := now.Truncate(length)
+	if period.Equal(f.period) {
+		return nil
+	}
+	f.period = period
+	previous := period.Add(-length)
+	rotated := f.options.Path + sampleRotatedSuffix
+	if info, err := os.Stat(rotated); err == nil && info.ModTime().Before(previous) {
+		if err := os.Remove(rotated); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	info, err := os.Stat(f.options.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.ModTime().Before(period) {
+		return nil
+	}
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	if info.ModTime().Before(previous) {
+		return os.Remove(f.options.Path)
+	}
+	return os.Rename(f.options.Path, rotated)
+}
+
+// open opens the file for appending and writes the header if the file is empty.
+func (f *sampleFileSink) open() error {
+	file, err := os.OpenFile(f.options.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	if f.size == 0 {
+		n, err := f.file.Write(f.header())
+		f.size += int64(n)
+		return err
+	}
+	return nil
+}
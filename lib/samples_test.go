@@ -0,0 +1,189 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidSampleFields(t *testing.T) {
+	testData := []struct {
+		fields  []string
+		wantErr string
+	}{
+		{[]string{"time", "host", "kind", "name", "iface", "class", "metric", "value"}, ""},
+		{[]string{"time", "bytes"}, "unknown sample field 'bytes'"},
+	}
+
+	for _, tc := range testData {
+		err := validSampleFields(tc.fields)
+		if (err == nil && tc.wantErr != "") || (err != nil && err.Error() != tc.wantErr) {
+			t.Errorf("validSampleFields(%q) => got error: %v, want: %q", tc.fields, err, tc.wantErr)
+		}
+	}
+}
+
+func TestSampleFileSinkEncode(t *testing.T) {
+	values := []metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1500)},
+		{"userQuotaUsed", tcUserNameLeaf, "john, doe", int64(42)},
+	}
+	at := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	testData := []struct {
+		desc    string
+		options *SampleFileOptions
+		want    string
+	}{
+		{
+			desc:    "default CSV",
+			options: &SampleFileOptions{},
+			want: "2013-01-01T00:00:00Z,class,eth0:2:3,sentBytes,1500\n" +
+				"2013-01-01T00:00:00Z,user,\"john, doe\",userQuotaUsed,42\n",
+		},
+		{
+			desc:    "CSV with the components",
+			options: &SampleFileOptions{Fields: []string{"host", "iface", "class", "value"}},
+			want: "router,eth0,2:3,1500\n" +
+				"router,users,\"john, doe\",42\n",
+		},
+		{
+			desc:    "JSON lines",
+			options: &SampleFileOptions{Format: "json", Fields: []string{"time", "name", "metric", "value"}},
+			want: `{"time":"2013-01-01T00:00:00Z","name":"eth0:2:3","metric":"sentBytes","value":1500}` + "\n" +
+				`{"time":"2013-01-01T00:00:00Z","name":"john, doe","metric":"userQuotaUsed","value":42}` + "\n",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			f := &sampleFileSink{options: tc.options, host: "router"}
+			if got := string(f.encode(values, at)); got != tc.want {
+				t.Errorf("encode => got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSampleFileSinkWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.csv")
+	f := &sampleFileSink{
+		options: &SampleFileOptions{Path: path, Fields: []string{"name", "value"}, MaxSize: 40},
+	}
+	for _, rows := range []string{"eth0:1:1,1\n", "eth0:1:1,2\n", "eth0:1:1,3\n"} {
+		if err := f.write([]byte(rows)); err != nil {
+			t.Fatalf("write => unexpected error: %s", err)
+		}
+	}
+	f.file.Close()
+
+	testData := []struct {
+		path string
+		want string
+	}{
+		{path + ".1", "name,value\neth0:1:1,1\neth0:1:1,2\n"},
+		{path, "name,value\neth0:1:1,3\n"},
+	}
+	for _, tc := range testData {
+		if got := readFile(t, tc.path); got != tc.want {
+			t.Errorf("ReadFile(%s) => got: %q, want: %q", tc.path, got, tc.want)
+		}
+	}
+
+	// Appending to an existing file doesn't repeat the header.
+	f = &sampleFileSink{
+		options: &SampleFileOptions{Path: path, Fields: []string{"name", "value"}},
+	}
+	if err := f.write([]byte("eth0:1:1,4\n")); err != nil {
+		t.Fatalf("write => unexpected error: %s", err)
+	}
+	f.file.Close()
+	if got, want := readFile(t, path), "name,value\neth0:1:1,3\neth0:1:1,4\n"; got != want {
+		t.Errorf("ReadFile(%s) => got: %q, want: %q", path, got, want)
+	}
+}
+
+func TestSampleFileSinkRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.csv")
+	rotated := path + sampleRotatedSuffix
+	f := &sampleFileSink{
+		options: &SampleFileOptions{Path: path, Fields: []string{"name", "value"}, Retention: 48 * time.Hour},
+	}
+	write := func(rows string) {
+		t.Helper()
+		if err := f.write([]byte(rows)); err != nil {
+			t.Fatalf("write => unexpected error: %s", err)
+		}
+	}
+	// age sets the time of the last write of the file and starts a new period.
+	age := func(path string, by time.Duration) {
+		t.Helper()
+		at := time.Now().Add(-by)
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatalf("Chtimes => unexpected error: %s", err)
+		}
+		f.period = time.Time{}
+	}
+
+	write("eth0:1:1,1\n")
+	write("eth0:1:1,2\n")
+	if got, want := readFile(t, path), "name,value\neth0:1:1,1\neth0:1:1,2\n"; got != want {
+		t.Errorf("ReadFile(%s) => got: %q, want: %q", path, got, want)
+	}
+
+	// The samples of the previous period are rotated.
+	age(path, 24*time.Hour)
+	write("eth0:1:1,3\n")
+	if got, want := readFile(t, rotated), "name,value\neth0:1:1,1\neth0:1:1,2\n"; got != want {
+		t.Errorf("ReadFile(%s) => got: %q, want: %q", rotated, got, want)
+	}
+	if got, want := readFile(t, path), "name,value\neth0:1:1,3\n"; got != want {
+		t.Errorf("ReadFile(%s) => got: %q, want: %q", path, got, want)
+	}
+
+	// The rotated samples older than the previous period are removed.
+	age(rotated, 72*time.Hour)
+	write("eth0:1:1,4\n")
+	if _, err := os.Stat(rotated); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s) => got: %v, want the file removed", rotated, err)
+	}
+
+	// The samples older than the previous period aren't rotated, e.g. after tc_reader was stopped.
+	f.file.Close()
+	f.file = nil
+	age(path, 72*time.Hour)
+	write("eth0:1:1,5\n")
+	if _, err := os.Stat(rotated); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s) => got: %v, want no rotated file", rotated, err)
+	}
+	if got, want := readFile(t, path), "name,value\neth0:1:1,5\n"; got != want {
+		t.Errorf("ReadFile(%s) => got: %q, want: %q", path, got, want)
+	}
+	f.file.Close()
+}
+
+// readFile returns the content of the file, it fails the test on errors.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) => unexpected error: %s", path, err)
+	}
+	return string(content)
+}
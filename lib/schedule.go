@@ -0,0 +1,73 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+schedule.go computes the start times of the periodic parse cycles. The cycles can be aligned to the wall-clock
+boundaries, e.g. :00, :05, :10 seconds for the parseInterval of 5 seconds, so that the samples line up with the polling
+windows of the NMS. A random jitter can delay every cycle, so that many routers polled together don't execute TC at
+exactly the same instant.
+*/
+
+package lib
+
+import (
+	"math/rand"
+	"time"
+)
+
+// cycleSchedule computes the start times of the periodic parse cycles.
+type cycleSchedule struct {
+	// interval is the period between the parse cycles.
+	interval time.Duration
+
+	// jitter is the upper bound of the random delay of every parse cycle, the cycles aren't delayed if it is zero.
+	jitter time.Duration
+
+	// base is the start time of the last parse cycle without its jitter.
+	base time.Time
+
+	// random returns a random number in [0, n), it is replaced in tests.
+	random func(n int64) int64
+}
+
+// newCycleSchedule returns the schedule of the parse cycles configured in the options, following the parse cycle
+// that started at the time. The cycles are aligned to the multiples of the parseInterval since the zero time, i.e.
+// to the UTC wall-clock boundaries, if AlignCycles is set.
+func newCycleSchedule(options *TcParserOptions, now time.Time) *cycleSchedule {
+	interval := options.parseInterval()
+	base := now
+	if options.AlignCycles {
+		base = now.Truncate(interval)
+	}
+	return &cycleSchedule{
+		interval: interval,
+		jitter:   options.ParseJitter,
+		base:     base,
+		random:   rand.Int63n,
+	}
+}
+
+// next returns the start time of the next parse cycle, at the time or later. The cycles missed while the previous one
+// was running are skipped, as with a time.Ticker.
+func (s *cycleSchedule) next(now time.Time) time.Time {
+	s.base = s.base.Add(s.interval)
+	if behind := now.Sub(s.base); behind > 0 {
+		s.base = s.base.Add((behind/s.interval + 1) * s.interval)
+	}
+	if s.jitter <= 0 {
+		return s.base
+	}
+	return s.base.Add(time.Duration(s.random(int64(s.jitter))))
+}
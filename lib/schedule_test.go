@@ -0,0 +1,79 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCycleScheduleNext(t *testing.T) {
+	at := func(milliseconds int) time.Time {
+		return time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(milliseconds) * time.Millisecond)
+	}
+
+	tests := []struct {
+		desc    string
+		options *TcParserOptions
+		// nows are the times next is called at, after the parse cycles complete.
+		nows []time.Time
+		want []time.Time
+	}{
+		{
+			desc:    "every parse interval after the start",
+			options: &TcParserOptions{ParseInterval: 5 * time.Second},
+			nows:    []time.Time{at(4000), at(9000)},
+			want:    []time.Time{at(8200), at(13200)},
+		},
+		{
+			desc:    "aligned to the wall-clock boundaries",
+			options: &TcParserOptions{ParseInterval: 5 * time.Second, AlignCycles: true},
+			nows:    []time.Time{at(4000), at(6000)},
+			want:    []time.Time{at(5000), at(10000)},
+		},
+		{
+			desc:    "sub-second interval aligned",
+			options: &TcParserOptions{ParseInterval: 500 * time.Millisecond, AlignCycles: true},
+			nows:    []time.Time{at(3300), at(3600)},
+			want:    []time.Time{at(3500), at(4000)},
+		},
+		{
+			desc:    "missed cycles are skipped",
+			options: &TcParserOptions{ParseInterval: 5 * time.Second, AlignCycles: true},
+			nows:    []time.Time{at(17000), at(21000)},
+			want:    []time.Time{at(20000), at(25000)},
+		},
+		{
+			desc:    "jitter delays the aligned cycles",
+			options: &TcParserOptions{ParseInterval: 5 * time.Second, AlignCycles: true, ParseJitter: time.Second},
+			nows:    []time.Time{at(4000), at(6000)},
+			want:    []time.Time{at(5500), at(10500)},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := newCycleSchedule(tc.options, at(3200))
+			s.random = func(n int64) int64 { return n / 2 }
+			for i, now := range tc.nows {
+				if got := s.next(now); !got.Equal(tc.want[i]) {
+					t.Errorf("next(%s) => got: %s, want: %s", now, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
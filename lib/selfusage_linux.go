@@ -0,0 +1,59 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+selfusage_linux.go reads the resident memory and the CPU time of tc_reader from the kernel.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// selfStatmPath is the file with the memory usage of this process in pages, see proc(5).
+const selfStatmPath = "/proc/self/statm"
+
+// readSelfUsage returns the resident set size in bytes and the user and system CPU time of this process.
+func readSelfUsage() (int64, time.Duration, error) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0, fmt.Errorf("unable to read the CPU time, error: %s", err)
+	}
+	cpu := time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	content, err := os.ReadFile(selfStatmPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	rss, err := parseStatm(string(content))
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to parse %s, error: %s", selfStatmPath, err)
+	}
+	return rss * int64(os.Getpagesize()), cpu, nil
+}
+
+// parseStatm returns the resident set size in pages, the second field of the content of the statm file.
+func parseStatm(content string) (int64, error) {
+	fields := strings.Fields(content)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("got %d fields, want at least 2", len(fields))
+	}
+	return strconv.ParseInt(fields[1], 10, 64)
+}
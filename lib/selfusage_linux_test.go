@@ -0,0 +1,56 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"testing"
+)
+
+func TestParseStatm(t *testing.T) {
+	testData := []struct {
+		content string
+		want    int64
+		wantErr bool
+	}{
+		{"5734 1320 1014 246 0 1530 0\n", 1320, false},
+		{"5734", 0, true},
+		{"5734 many 1014", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.content, func(t *testing.T) {
+			got, err := parseStatm(tc.content)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseStatm => got error: %v, want error: %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("parseStatm => got: %d, want: %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadSelfUsage(t *testing.T) {
+	rss, _, err := readSelfUsage()
+	if err != nil {
+		t.Fatalf("readSelfUsage => unexpected error: %s", err)
+	}
+	if rss <= 0 {
+		t.Errorf("readSelfUsage => got rss: %d, want positive", rss)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+selfusage_other.go is used on systems without /proc, the resident memory and the CPU time aren't exported there.
+*/
+
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+// readSelfUsage always fails, the resource usage is only read on Linux.
+func readSelfUsage() (int64, time.Duration, error) {
+	return 0, 0, errors.New("reading the resource usage is only supported on Linux")
+}
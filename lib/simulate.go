@@ -0,0 +1,264 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+simulate.go drives Listen over a pipe exactly as the SNMP daemon drives a pass_persist script: PING, get and getnext
+requests, a walk of the whole tree and malformed input. It verifies the whole protocol after a single parse cycle,
+e.g. after an upgrade, and prints one line per check:
+ok   PING is answered with PONG
+FAIL GET of the base OID returns the identity: got [], want the base OID, string and the identity
+
+Programs embedding the SNMP handler can drive it the same way, WithTalker connects it to any reader and writer.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// simulateTimeout is how long the simulated SNMP daemon waits for each response.
+const simulateTimeout = 5 * time.Second
+
+// snmpdSimulator sends the requests of the SNMP daemon to Listen and reads its responses.
+type snmpdSimulator struct {
+	// requests is where the requests are written, Listen reads them on the other end of the pipe.
+	requests io.Writer
+
+	// responses are the lines written by Listen, the channel is closed once Listen closes its output.
+	responses chan string
+
+	// timeout is how long the simulator waits for a request to be read or a response to be written.
+	timeout time.Duration
+}
+
+// newSnmpdSimulator creates new snmpdSimulator that writes the requests to the writer and reads the responses from
+// the reader.
+func newSnmpdSimulator(requests io.Writer, responses io.Reader) *snmpdSimulator {
+	d := &snmpdSimulator{
+		requests:  requests,
+		responses: make(chan string),
+		timeout:   simulateTimeout,
+	}
+	go func() {
+		defer close(d.responses)
+		scanner := bufio.NewScanner(responses)
+		for scanner.Scan() {
+			d.responses <- scanner.Text()
+		}
+	}()
+	return d
+}
+
+// send writes the lines of a request.
+func (d *snmpdSimulator) send(lines ...string) error {
+	written := make(chan error, 1)
+	go func() {
+		_, err := io.WriteString(d.requests, strings.Join(lines, newLine)+newLine)
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		return err
+	case <-time.After(d.timeout):
+		return fmt.Errorf("the request %q wasn't read within %s", lines, d.timeout)
+	}
+}
+
+// receive returns the next line of a response.
+func (d *snmpdSimulator) receive() (string, error) {
+	select {
+	case line, ok := <-d.responses:
+		if !ok {
+			return emptyLine, errors.New("the output was closed")
+		}
+		return line, nil
+	case <-time.After(d.timeout):
+		return emptyLine, fmt.Errorf("no response within %s", d.timeout)
+	}
+}
+
+// request sends the command with the OID and returns the response, the OID, the object type and the value of the
+// data, or a single empty line if there is no data.
+func (d *snmpdSimulator) request(command, oid string) ([]string, error) {
+	if err := d.send(command, oid); err != nil {
+		return nil, err
+	}
+	first, err := d.receive()
+	if err != nil || first == emptyLine {
+		return []string{first}, err
+	}
+	response := []string{first}
+	for len(response) < 3 {
+		line, err := d.receive()
+		if err != nil {
+			return response, err
+		}
+		response = append(response, line)
+	}
+	return response, nil
+}
+
+// expectNone returns an error unless the response is a single empty line.
+func expectNone(response []string, err error) error {
+	if err != nil {
+		return err
+	}
+	if len(response) != 1 || response[0] != emptyLine {
+		return fmt.Errorf("got %q, want an empty line", response)
+	}
+	return nil
+}
+
+// simulateCheck is a step of the simulation.
+type simulateCheck struct {
+	// desc describes the verified behavior.
+	desc string
+
+	// check returns an error if the behavior isn't as expected.
+	check func() error
+}
+
+// Simulate runs a single parse cycle into an SNMP handler created with the snmpOptions and drives its Listen over a
+// pipe as the SNMP daemon would. It writes a line with the result of each check to w and returns an error if any of
+// them failed.
+func Simulate(options *TcParserOptions, snmpOptions *SnmpOptions, logger Logger, w io.Writer) error {
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+	s, err := collectSnmp(options, snmpOptions, logger, WithTalker(requestReader, responseWriter))
+	if err != nil {
+		return err
+	}
+	listened := make(chan struct{})
+	go func() {
+		s.Listen()
+		responseWriter.Close()
+		close(listened)
+	}()
+	// Closing the requests ends Listen if the session broke before the empty line.
+	defer requestWriter.Close()
+	return s.simulate(newSnmpdSimulator(requestWriter, responseReader), listened, w)
+}
+
+// simulate runs the checks of the protocol by the simulator, listened is closed once Listen returns.
+func (s *snmp) simulate(d *snmpdSimulator, listened chan struct{}, w io.Writer) error {
+	base := s.options.baseOID()
+	var last string
+	checks := []simulateCheck{
+		{"PING is answered with PONG", func() error {
+			if err := d.send(pingRequst); err != nil {
+				return err
+			}
+			line, err := d.receive()
+			if err == nil && line != pingResponse {
+				err = fmt.Errorf("got %q, want %q", line, pingResponse)
+			}
+			return err
+		}},
+		{"GET of the base OID returns the identity", func() error {
+			response, err := d.request(getCommand, base)
+			if err == nil && (len(response) != 3 || response[0] != base || response[1] != "string") {
+				err = fmt.Errorf("got %q, want the base OID, string and the identity", response)
+			}
+			return err
+		}},
+		{"GET-NEXT walks the whole tree in order", func() error {
+			walked := 0
+			for current := base; ; walked++ {
+				response, err := d.request(getNextCommand, current)
+				if err != nil {
+					return err
+				}
+				if len(response) == 1 {
+					break
+				}
+				oid, objectType := response[0], response[1]
+				if !strings.HasPrefix(oid, base+".") || !oidLess(current, oid) {
+					return fmt.Errorf("GET-NEXT of %s returned %s, want an OID under %s after it", current, oid, base)
+				}
+				if _, ok := walkTypes[objectType]; !ok {
+					return fmt.Errorf("GET-NEXT of %s returned the unknown type %s", current, objectType)
+				}
+				current, last = oid, oid
+			}
+			if want := len(s.snapshot().data) - 1; walked != want {
+				return fmt.Errorf("walked %d OIDs, want %d", walked, want)
+			}
+			return nil
+		}},
+		{"GET-NEXT of the last OID returns nothing", func() error {
+			return expectNone(d.request(getNextCommand, last))
+		}},
+		{"GET of a missing OID returns nothing", func() error {
+			return expectNone(d.request(getCommand, base+".0.1"))
+		}},
+		{"GET-NEXT of a missing OID returns nothing", func() error {
+			return expectNone(d.request(getNextCommand, base+".0.1"))
+		}},
+		{"GET outside of the base OID returns nothing", func() error {
+			return expectNone(d.request(getCommand, ".1.3.6.1.2.1.1.1.0"))
+		}},
+		{"GET of an empty OID returns nothing", func() error {
+			return expectNone(d.request(getCommand, emptyLine))
+		}},
+		{"an unknown command is answered with an empty line", func() error {
+			if err := d.send("bogus"); err != nil {
+				return err
+			}
+			line, err := d.receive()
+			return expectNone([]string{line}, err)
+		}},
+		{"the session continues after the malformed input", func() error {
+			if err := d.send(pingRequst); err != nil {
+				return err
+			}
+			line, err := d.receive()
+			if err == nil && line != pingResponse {
+				err = fmt.Errorf("got %q, want %q", line, pingResponse)
+			}
+			return err
+		}},
+		{"an empty line ends the session", func() error {
+			if err := d.send(emptyLine); err != nil {
+				return err
+			}
+			select {
+			case <-listened:
+				return nil
+			case <-time.After(d.timeout):
+				return fmt.Errorf("Listen didn't return within %s", d.timeout)
+			}
+		}},
+	}
+
+	var failed int
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %s\n", c.desc, err)
+			continue
+		}
+		fmt.Fprintf(w, "ok   %s\n", c.desc)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnmpSimulate(t *testing.T) {
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+	defer requestWriter.Close()
+	s, err := NewSnmp(&fakeSyslog{}, WithTalker(requestReader, responseWriter))
+	if err != nil {
+		t.Fatalf("NewSnmp => unexpected error: %s", err)
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:3", 9, 10, 11, 12, nil, 7, 0})
+	s.commit()
+
+	listened := make(chan struct{})
+	go func() {
+		s.Listen()
+		responseWriter.Close()
+		close(listened)
+	}()
+	var output bytes.Buffer
+	if err := s.simulate(newSnmpdSimulator(requestWriter, responseReader), listened, &output); err != nil {
+		t.Errorf("simulate => unexpected error: %s, output:\n%s", err, output.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 11 {
+		t.Errorf("simulate => got %d checks, want: 11", len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "ok   ") {
+			t.Errorf("simulate => got %s, want the check to pass", line)
+		}
+	}
+}
+
+func TestSnmpSimulateNotListening(t *testing.T) {
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+	defer requestReader.Close()
+	defer responseWriter.Close()
+	s, err := NewSnmp(&fakeSyslog{}, WithTalker(requestReader, responseWriter))
+	if err != nil {
+		t.Fatalf("NewSnmp => unexpected error: %s", err)
+	}
+
+	// Nothing reads the requests, every check times out.
+	d := newSnmpdSimulator(requestWriter, responseReader)
+	d.timeout = time.Millisecond
+	var output bytes.Buffer
+	err = s.simulate(d, make(chan struct{}), &output)
+	if want := "11 of 11 checks failed"; err == nil || err.Error() != want {
+		t.Errorf("simulate => got error: %v, want: %s", err, want)
+	}
+	if !strings.HasPrefix(output.String(), "FAIL PING is answered with PONG: ") {
+		t.Errorf("simulate => got output:\n%s\nwant the PING check to fail", output.String())
+	}
+}
+
+func TestWithTalker(t *testing.T) {
+	if _, err := NewSnmp(&fakeSyslog{}, WithTalker(nil, &bytes.Buffer{})); err == nil {
+		t.Errorf("NewSnmp with a nil reader => got no error, want an error")
+	}
+
+	var output bytes.Buffer
+	s, err := NewSnmp(&fakeSyslog{}, WithTalker(strings.NewReader("PING\n"), &output))
+	if err != nil {
+		t.Fatalf("NewSnmp => unexpected error: %s", err)
+	}
+	s.Listen()
+	if got, want := output.String(), "PONG\n"; got != want {
+		t.Errorf("Listen => got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+sink.go defines the sinks of the data.
+
+The tcParser adds the parsed data of each parse cycle to a dataSink between begin() and commit(). The SNMP handler is
+one dataSink, more of them can be active at the same time, e.g. the Prometheus textfile.
+
+The SNMP handler passes the values of the metrics to the metricSinks after each parse cycle, e.g. the Graphite Carbon
+daemon, the MQTT broker or the sample file.
+
+The sinks name the values by templates, e.g. "tc.{host}.{iface}.{class}.{metric}", with the placeholders:
+{host}   - The hostname.
+{iface}  - The interface of a Qdisc / Class, "users" for the users and "groups" for the interface groups.
+{class}  - The Qdisc / Class without the interface, e.g. "2:3" for "eth0:2:3", or the name of the user or group.
+{metric} - The name of the metric as in the alert rules, e.g. "sentBytes".
+*/
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// reMetricPlaceholder matches the placeholders in the templates of the metric paths.
+var reMetricPlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// dataSink receives the parsed data from the tcParser.
+type dataSink interface {
+	// begin should be called by the tcParser before it starts adding newly parsed data.
+	begin()
+
+	// commit should be called by the tcParser after it finished adding parsed data.
+	commit()
+
+	// erase starts a new parse cycle, the stored data that isn't added again before commit is removed.
+	erase()
+
+	// addData adds parsed data. The data must not be retained after the call, the parser reuses it.
+	addData(data *parsedData)
+
+	// addGroupData adds summed data of an interface group, the name of the parsedData is the name of the group.
+	addGroupData(data *parsedData)
+
+	// addIfaceData adds summed data of an interface, the name of the parsedData is the name of the interface.
+	addIfaceData(data *parsedData)
+
+	// addXstats adds the xstats of a Qdisc / Class that was already added by addData.
+	addXstats(name string, xstats []xstat)
+
+	// addNetDevData adds the kernel counters of an interface. The stats must not be retained after the call.
+	addNetDevData(stats *netDevStats)
+
+	// addConntrackData adds the summed conntrack counters of an user, the userClass of the parsedData is the user and
+	// direction. The data must not be retained after the call.
+	addConntrackData(data *parsedData)
+
+	// addWarning records a non-fatal problem found while parsing the data.
+	addWarning(message string)
+
+	// setMaintenance marks the stored data as being in maintenance. The data isn't updated during the maintenance and
+	// the per-interval values are computed from fresh samples once it ends.
+	setMaintenance(active bool)
+
+	// setHealth records the number of consecutive failed parse cycles and the current backoff in seconds.
+	setHealth(failures int, backoff int)
+
+	// setUnmatchedLines records the number of lines of the TC output that matched neither the header nor the statistics
+	// pattern in the current parse cycle.
+	setUnmatchedLines(lines int)
+}
+
+// multiSink implements dataSink, it passes the data to all of its dataSinks in order.
+type multiSink []dataSink
+
+// newDataSink returns the only dataSink or a multiSink of all of them.
+func newDataSink(sinks ...dataSink) dataSink {
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	return multiSink(sinks)
+}
+
+// begin calls begin() of all the dataSinks.
+func (m multiSink) begin() {
+	for _, sink := range m {
+		sink.begin()
+	}
+}
+
+// commit calls commit() of all the dataSinks.
+func (m multiSink) commit() {
+	for _, sink := range m {
+		sink.commit()
+	}
+}
+
+// erase calls erase() of all the dataSinks.
+func (m multiSink) erase() {
+	for _, sink := range m {
+		sink.erase()
+	}
+}
+
+// addData calls addData() of all the dataSinks.
+func (m multiSink) addData(data *parsedData) {
+	for _, sink := range m {
+		sink.addData(data)
+	}
+}
+
+// addGroupData calls addGroupData() of all the dataSinks.
+func (m multiSink) addGroupData(data *parsedData) {
+	for _, sink := range m {
+		sink.addGroupData(data)
+	}
+}
+
+// addIfaceData calls addIfaceData() of all the dataSinks.
+func (m multiSink) addIfaceData(data *parsedData) {
+	for _, sink := range m {
+		sink.addIfaceData(data)
+	}
+}
+
+// addXstats calls addXstats() of all the dataSinks.
+func (m multiSink) addXstats(name string, xstats []xstat) {
+	for _, sink := range m {
+		sink.addXstats(name, xstats)
+	}
+}
+
+// addNetDevData calls addNetDevData() of all the dataSinks.
+func (m multiSink) addNetDevData(stats *netDevStats) {
+	for _, sink := range m {
+		sink.addNetDevData(stats)
+	}
+}
+
+// addConntrackData calls addConntrackData() of all the dataSinks.
+func (m multiSink) addConntrackData(data *parsedData) {
+	for _, sink := range m {
+		sink.addConntrackData(data)
+	}
+}
+
+// addWarning calls addWarning() of all the dataSinks.
+func (m multiSink) addWarning(message string) {
+	for _, sink := range m {
+		sink.addWarning(message)
+	}
+}
+
+// setMaintenance calls setMaintenance() of all the dataSinks.
+func (m multiSink) setMaintenance(active bool) {
+	for _, sink := range m {
+		sink.setMaintenance(active)
+	}
+}
+
+// setHealth calls setHealth() of all the dataSinks.
+func (m multiSink) setHealth(failures int, backoff int) {
+	for _, sink := range m {
+		sink.setHealth(failures, backoff)
+	}
+}
+
+// setUnmatchedLines calls setUnmatchedLines() of all the dataSinks.
+func (m multiSink) setUnmatchedLines(lines int) {
+	for _, sink := range m {
+		sink.setUnmatchedLines(lines)
+	}
+}
+
+// metricValue is the value of one of the namedMetrics of a Qdisc / Class, user or group.
+type metricValue struct {
+	// metric is the name of the metric.
+	metric string
+
+	// nameLeaf is the SNMP leaf number where the name is stored, it tells Qdiscs / Classes, users and groups apart.
+	nameLeaf int
+
+	// name is the name of the Qdisc / Class, user or group.
+	name string
+
+	// value is the value as stored in the SNMP tree.
+	value interface{}
+}
+
+// metricSink receives the values of the metrics after each parse cycle.
+type metricSink interface {
+	// flush sends the values of the parse cycle started at the time. It must not block, the values must not be modified.
+	flush(values []metricValue, at time.Time)
+
+	// close sends the values that are still queued and releases the resources. Returns an error if this doesn't finish
+	// before the context is done. flush must not be called after close.
+	close(ctx context.Context) error
+}
+
+// awaitSender waits until the goroutine sending the queued data closes done or until the context is done.
+func awaitSender(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// kind returns "class" for the Qdiscs / Classes, "user" for the users, "group" for the groups and "iface" for the
+// interfaces.
+func (v metricValue) kind() string {
+	switch v.nameLeaf {
+	case tcUserNameLeaf:
+		return "user"
+	case tcGroupNameLeaf:
+		return "group"
+	case tcIfaceNameLeaf:
+		return "iface"
+	}
+	return "class"
+}
+
+// counter indicates that the value only grows, see namedMetric.
+func (v metricValue) counter() bool {
+	return namedMetrics[v.metric].counter
+}
+
+// components returns the values of the {iface} and {class} placeholders.
+func (v metricValue) components() (iface, class string) {
+	switch v.nameLeaf {
+	case tcUserNameLeaf:
+		return "users", v.name
+	case tcGroupNameLeaf:
+		return "groups", v.name
+	case tcIfaceNameLeaf:
+		return v.name, ""
+	}
+	parts := strings.SplitN(v.name, ":", 2)
+	if len(parts) > 1 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+// validMetricPath returns an error if the template of the metric paths contains an unknown placeholder.
+func validMetricPath(template string) error {
+	for _, placeholder := range reMetricPlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{host}", "{iface}", "{class}", "{metric}":
+		default:
+			return fmt.Errorf("unknown placeholder %s in the metric path", placeholder)
+		}
+	}
+	return nil
+}
+
+// metricPath creates the path of the value from the template. The host is substituted as is, the interface and the
+// class are made safe for the sink by escape.
+func metricPath(template, host string, v metricValue, escape func(string) string) string {
+	iface, class := v.components()
+	return strings.NewReplacer(
+		"{host}", host,
+		"{iface}", escape(iface),
+		"{class}", escape(class),
+		"{metric}", v.metric,
+	).Replace(template)
+}
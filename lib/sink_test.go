@@ -0,0 +1,127 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidMetricPath(t *testing.T) {
+	testData := []struct {
+		template string
+		wantErr  string
+	}{
+		{"tc.{host}.{iface}.{class}.{metric}", ""},
+		{"shaping.{class}.bytes", ""},
+		{"tc.{name}.{metric}", "unknown placeholder {name} in the metric path"},
+	}
+
+	for _, tc := range testData {
+		err := validMetricPath(tc.template)
+		if (err == nil && tc.wantErr != "") || (err != nil && err.Error() != tc.wantErr) {
+			t.Errorf("validMetricPath(%s) => got error: %v, want: %q", tc.template, err, tc.wantErr)
+		}
+	}
+}
+
+func TestMetricPath(t *testing.T) {
+	escape := func(component string) string {
+		return strings.ReplaceAll(component, ":", "-")
+	}
+	testData := []struct {
+		desc     string
+		template string
+		value    metricValue
+		want     string
+	}{
+		{
+			desc:     "class",
+			template: "tc.{host}.{iface}.{class}.{metric}",
+			value:    metricValue{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1)},
+			want:     "tc.router.eth0.2-3.sentBytes",
+		},
+		{
+			desc:     "root Qdisc without a class",
+			template: "{iface}/{class}/{metric}",
+			value:    metricValue{"sentBytes", tcNameLeaf, "eth0", int64(1)},
+			want:     "eth0//sentBytes",
+		},
+		{
+			desc:     "user",
+			template: "tc.{host}.{iface}.{class}.{metric}",
+			value:    metricValue{"userDownBytes", tcUserNameLeaf, "john:doe", int64(1)},
+			want:     "tc.router.users.john-doe.userDownBytes",
+		},
+		{
+			desc:     "group without the metric placeholder",
+			template: "{iface}.{class}.bytes",
+			value:    metricValue{"groupBytes", tcGroupNameLeaf, "uplinks", int64(1)},
+			want:     "groups.uplinks.bytes",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := metricPath(tc.template, "router", tc.value, escape); got != tc.want {
+				t.Errorf("metricPath(%s) => got: %s, want: %s", tc.template, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMultiSink(t *testing.T) {
+	first, second := &fakeDataSink{}, &fakeDataSink{}
+	sink := newDataSink(first, second)
+	sink.begin()
+	sink.erase()
+	sink.addData(&parsedData{name: "eth0:1:1"})
+	sink.addGroupData(&parsedData{name: "office"})
+	sink.addIfaceData(&parsedData{name: "eth0"})
+	sink.addXstats("eth0:1:1", []xstat{{"lended", "1"}})
+	sink.addWarning("warning")
+	sink.setMaintenance(true)
+	sink.setHealth(1, 20)
+	sink.commit()
+
+	for i, fs := range []*fakeDataSink{first, second} {
+		if fs.beginCount != 1 || fs.commitCount != 1 || fs.eraseCount != 1 || len(fs.data) != 1 || len(fs.groups) != 1 || len(fs.ifaces) != 1 ||
+			len(fs.xstats) != 1 || len(fs.warnings) != 1 || len(fs.maintenance) != 1 || len(fs.health) != 1 {
+			t.Errorf("multiSink => dataSink %d got: %+v, want every call once", i, fs)
+		}
+	}
+
+	if got := newDataSink(first); got != dataSink(first) {
+		t.Errorf("newDataSink => got: %T, want the only dataSink", got)
+	}
+}
+
+func TestAwaitSender(t *testing.T) {
+	done := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := awaitSender(ctx, done); err != context.DeadlineExceeded {
+		t.Errorf("awaitSender of a running sender => got error: %v, want: %v", err, context.DeadlineExceeded)
+	}
+
+	close(done)
+	if err := awaitSender(context.Background(), done); err != nil {
+		t.Errorf("awaitSender of a finished sender => unexpected error: %s", err)
+	}
+}
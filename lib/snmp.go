@@ -21,12 +21,18 @@ package lib
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"log/syslog"
+	"io"
 	"os"
+	"path"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Package constants.
@@ -46,9 +52,21 @@ const (
 	// getNextCommand is the command that SNMPD sends on a GET-NEXT request.
 	getNextCommand = "getnext"
 
-	// myName is the identification of this process in the SNMP tree.
+	// myName is the default identification of this process in the SNMP tree.
 	myName = "tc_reader by mumak@"
 
+	// hostnamePlaceholder is replaced with the hostname in the identity template.
+	hostnamePlaceholder = "{hostname}"
+
+	// versionPlaceholder is replaced with the version of tc_reader in the identity template.
+	versionPlaceholder = "{version}"
+
+	// labelsPlaceholder is replaced with the configured labels in the identity template.
+	labelsPlaceholder = "{labels}"
+
+	// instancePlaceholder is replaced with the name of the instance in the identity template.
+	instancePlaceholder = "{instance}"
+
 	// myOID is the beginning of our OID tree.
 	myOID = ".1.3.6.1.4.1.2021.255"
 
@@ -106,33 +124,433 @@ const (
 
 	// tcUserUpOverLimitPktLeaf is the SNMP leaf number where we store user overlimit packets in the upload direction.
 	tcUserUpOverLimitPktLeaf = 18
+
+	// tcIfIndexLeaf is the SNMP leaf number where the kernel ifIndex of the interface for each tcIndex is stored.
+	tcIfIndexLeaf = 19
+
+	// tcWarningLeaf is the SNMP leaf number where the most recent non-fatal parse warnings are stored.
+	tcWarningLeaf = 20
+
+	// tcNumWarningsLeaf is the SNMP leaf number where the total number of parse warnings since start is stored.
+	tcNumWarningsLeaf = 21
+
+	// tcUserDownAvgPktSizeLeaf is the SNMP leaf number where we store user average packet size during the last interval in the download direction.
+	tcUserDownAvgPktSizeLeaf = 22
+
+	// tcUserUpAvgPktSizeLeaf is the SNMP leaf number where we store user average packet size during the last interval in the upload direction.
+	tcUserUpAvgPktSizeLeaf = 23
+
+	// tcUserDownSmallPktLeaf is the SNMP leaf number where we count intervals with small average packet size in the download direction.
+	tcUserDownSmallPktLeaf = 24
+
+	// tcUserDownMediumPktLeaf is the SNMP leaf number where we count intervals with medium average packet size in the download direction.
+	tcUserDownMediumPktLeaf = 25
+
+	// tcUserDownLargePktLeaf is the SNMP leaf number where we count intervals with large average packet size in the download direction.
+	tcUserDownLargePktLeaf = 26
+
+	// tcUserUpSmallPktLeaf is the SNMP leaf number where we count intervals with small average packet size in the upload direction.
+	tcUserUpSmallPktLeaf = 27
+
+	// tcUserUpMediumPktLeaf is the SNMP leaf number where we count intervals with medium average packet size in the upload direction.
+	tcUserUpMediumPktLeaf = 28
+
+	// tcUserUpLargePktLeaf is the SNMP leaf number where we count intervals with large average packet size in the upload direction.
+	tcUserUpLargePktLeaf = 29
+
+	// tcGroupIndexLeaf is the SNMP leaf number where list of indexes assigned to interface groups is stored.
+	tcGroupIndexLeaf = 30
+
+	// tcGroupNumIndexLeaf is the SNMP leaf number where the total number of available group indexes is stored.
+	tcGroupNumIndexLeaf = 31
+
+	// tcGroupNameLeaf is the SNMP leaf number where names for group indexes are stored.
+	tcGroupNameLeaf = 32
+
+	// tcGroupBytesLeaf is the SNMP leaf number where we store the summed bytes count of the group members.
+	tcGroupBytesLeaf = 33
+
+	// tcGroupPktLeaf is the SNMP leaf number where we store the summed packet count of the group members.
+	tcGroupPktLeaf = 34
+
+	// tcGroupDroppedPktLeaf is the SNMP leaf number where we store the summed dropped packets of the group members.
+	tcGroupDroppedPktLeaf = 35
+
+	// tcGroupOverLimitPktLeaf is the SNMP leaf number where we store the summed overlimit packets of the group members.
+	tcGroupOverLimitPktLeaf = 36
+
+	// tcEfficiencyLeaf is the SNMP leaf number where we store the percentage of sent packets out of the sent and over limit
+	// packets during the last interval for each tcIndex. Low values flag Qdiscs / Classes that are throttled most of the time.
+	tcEfficiencyLeaf = 37
+
+	// tcMaintenanceLeaf is the SNMP leaf number where we store 1 while the collection is paused during a blackout window, 0 otherwise.
+	tcMaintenanceLeaf = 38
+
+	// tcXstatIndexLeaf is the SNMP leaf number where list of indexes assigned to the xstats that don't have their own leaf is stored.
+	tcXstatIndexLeaf = 39
+
+	// tcXstatNumIndexLeaf is the SNMP leaf number where the total number of available xstat indexes is stored.
+	tcXstatNumIndexLeaf = 40
+
+	// tcXstatTcIndexLeaf is the SNMP leaf number where the tcIndex of the Qdisc / Class each xstat belongs to is stored.
+	tcXstatTcIndexLeaf = 41
+
+	// tcXstatNameLeaf is the SNMP leaf number where the names of the xstats are stored.
+	tcXstatNameLeaf = 42
+
+	// tcXstatValueLeaf is the SNMP leaf number where the values of the xstats are stored as printed by TC, including any units.
+	tcXstatValueLeaf = 43
+
+	// tcDropOverlimitLeaf is the SNMP leaf number where the drop_overlimit xstat of codel and fq_codel Qdiscs is stored for each tcIndex.
+	tcDropOverlimitLeaf = 44
+
+	// tcEcnMarkLeaf is the SNMP leaf number where the ecn_mark xstat of codel and fq_codel Qdiscs is stored for each tcIndex.
+	tcEcnMarkLeaf = 45
+
+	// tcNewFlowCountLeaf is the SNMP leaf number where the new_flow_count xstat of fq_codel Qdiscs is stored for each tcIndex.
+	tcNewFlowCountLeaf = 46
+
+	// tcLendedLeaf is the SNMP leaf number where the lended xstat of HTB Classes is stored for each tcIndex.
+	tcLendedLeaf = 47
+
+	// tcBorrowedLeaf is the SNMP leaf number where the borrowed xstat of HTB Classes is stored for each tcIndex.
+	tcBorrowedLeaf = 48
+
+	// tcGiantsLeaf is the SNMP leaf number where the giants xstat of HTB Classes is stored for each tcIndex.
+	tcGiantsLeaf = 49
+
+	// tcFailuresLeaf is the SNMP leaf number where we store the number of consecutive parse cycles in which TC failed, 0 when healthy.
+	tcFailuresLeaf = 50
+
+	// tcBackoffLeaf is the SNMP leaf number where we store the number of seconds between the collection attempts while backing
+	// off after TC failures, 0 when healthy.
+	tcBackoffLeaf = 51
+
+	// tcStaleLeaf is the SNMP leaf number where we store whether the Qdisc / Class disappeared from the TC output and is
+	// exported with its last values for each tcIndex.
+	tcStaleLeaf = 52
+
+	// tcUserDownMonthBytesLeaf is the SNMP leaf number where we store the downloaded bytes in the current quota period for each tcUserIndex.
+	tcUserDownMonthBytesLeaf = 53
+
+	// tcUserUpMonthBytesLeaf is the SNMP leaf number where we store the uploaded bytes in the current quota period for each tcUserIndex.
+	tcUserUpMonthBytesLeaf = 54
+
+	// tcUserQuotaExceededLeaf is the SNMP leaf number where we store whether the user exceeded the quota in the current period for each tcUserIndex.
+	tcUserQuotaExceededLeaf = 55
+
+	// tcUserQuotaUsedLeaf is the SNMP leaf number where we store the percentage of the quota used in the current period for each tcUserIndex.
+	tcUserQuotaUsedLeaf = 56
+
+	// tcDropRateLeaf is the SNMP leaf number where we store the percentage of dropped packets out of the sent and dropped
+	// packets during the last interval for each tcIndex.
+	tcDropRateLeaf = 57
+
+	// tcRate1mLeaf is the SNMP leaf number where we store the average rate over the last minute for each tcIndex.
+	tcRate1mLeaf = 58
+
+	// tcRate5mLeaf is the SNMP leaf number where we store the average rate over the last five minutes for each tcIndex.
+	tcRate5mLeaf = 59
+
+	// tcRate15mLeaf is the SNMP leaf number where we store the average rate over the last fifteen minutes for each tcIndex.
+	tcRate15mLeaf = 60
+
+	// tcPeakRateLeaf is the SNMP leaf number where we store the peak rate since start for each tcIndex.
+	tcPeakRateLeaf = 61
+
+	// tcUserDownRate1mLeaf is the SNMP leaf number where we store the average download rate over the last minute for each tcUserIndex.
+	tcUserDownRate1mLeaf = 62
+
+	// tcUserDownRate5mLeaf is the SNMP leaf number where we store the average download rate over the last five minutes for each tcUserIndex.
+	tcUserDownRate5mLeaf = 63
+
+	// tcUserDownRate15mLeaf is the SNMP leaf number where we store the average download rate over the last fifteen minutes for each tcUserIndex.
+	tcUserDownRate15mLeaf = 64
+
+	// tcUserDownPeakRateLeaf is the SNMP leaf number where we store the peak download rate since start for each tcUserIndex.
+	tcUserDownPeakRateLeaf = 65
+
+	// tcUserUpRate1mLeaf is the SNMP leaf number where we store the average upload rate over the last minute for each tcUserIndex.
+	tcUserUpRate1mLeaf = 66
+
+	// tcUserUpRate5mLeaf is the SNMP leaf number where we store the average upload rate over the last five minutes for each tcUserIndex.
+	tcUserUpRate5mLeaf = 67
+
+	// tcUserUpRate15mLeaf is the SNMP leaf number where we store the average upload rate over the last fifteen minutes for each tcUserIndex.
+	tcUserUpRate15mLeaf = 68
+
+	// tcUserUpPeakRateLeaf is the SNMP leaf number where we store the peak upload rate since start for each tcUserIndex.
+	tcUserUpPeakRateLeaf = 69
+
+	// tcVersionLeaf is the SNMP leaf number where we store the version, commit and build date of tc_reader.
+	tcVersionLeaf = 70
+
+	// tcLastUpdateLeaf is the SNMP leaf number where we store the timeticks since the last successful parse cycle.
+	tcLastUpdateLeaf = 71
+
+	// tcStatusLeaf is the SNMP leaf number where we store the entryStatus for each tcIndex.
+	tcStatusLeaf = 72
+
+	// tcUserStatusLeaf is the SNMP leaf number where we store the entryStatus for each tcUserIndex.
+	tcUserStatusLeaf = 73
+
+	// tcIfaceIndexLeaf is the SNMP leaf number where list of indexes assigned to the interface totals is stored.
+	tcIfaceIndexLeaf = 74
+
+	// tcIfaceNumIndexLeaf is the SNMP leaf number where the total number of available interface indexes is stored.
+	tcIfaceNumIndexLeaf = 75
+
+	// tcIfaceNameLeaf is the SNMP leaf number where names for interface indexes are stored.
+	tcIfaceNameLeaf = 76
+
+	// tcIfaceBytesLeaf is the SNMP leaf number where we store the summed bytes count of the interface.
+	tcIfaceBytesLeaf = 77
+
+	// tcIfacePktLeaf is the SNMP leaf number where we store the summed packet count of the interface.
+	tcIfacePktLeaf = 78
+
+	// tcIfaceDroppedPktLeaf is the SNMP leaf number where we store the summed dropped packets of the interface.
+	tcIfaceDroppedPktLeaf = 79
+
+	// tcIfaceOverLimitPktLeaf is the SNMP leaf number where we store the summed overlimit packets of the interface.
+	tcIfaceOverLimitPktLeaf = 80
+
+	// tcNameToIndexLeaf is the SNMP leaf number where we store the tcIndex of each tcName, keyed by the encoded name.
+	tcNameToIndexLeaf = 81
+
+	// tcUserNameToIndexLeaf is the SNMP leaf number where we store the tcUserIndex of each user, keyed by the encoded
+	// name.
+	tcUserNameToIndexLeaf = 82
+
+	// tcUnmatchedLinesLeaf is the SNMP leaf number where we store the number of lines of the TC output that matched
+	// neither the header nor the statistics pattern in the last parse cycle.
+	tcUnmatchedLinesLeaf = 83
+
+	// tcNetDevIndexLeaf is the SNMP leaf number where we store the kernel ifIndexes of the interfaces with the kernel
+	// counters, these are the indexes of the tcNetDev leaves.
+	tcNetDevIndexLeaf = 84
+
+	// tcNetDevNameLeaf is the SNMP leaf number where we store the name of the interface for each kernel ifIndex.
+	tcNetDevNameLeaf = 85
+
+	// tcNetDevRxBytesLeaf is the SNMP leaf number where we store the received bytes of the interface.
+	tcNetDevRxBytesLeaf = 86
+
+	// tcNetDevRxPktLeaf is the SNMP leaf number where we store the received packets of the interface.
+	tcNetDevRxPktLeaf = 87
+
+	// tcNetDevRxErrorsLeaf is the SNMP leaf number where we store the receive errors of the interface.
+	tcNetDevRxErrorsLeaf = 88
+
+	// tcNetDevRxDroppedLeaf is the SNMP leaf number where we store the received packets of the interface dropped by
+	// the kernel or the driver.
+	tcNetDevRxDroppedLeaf = 89
+
+	// tcNetDevTxBytesLeaf is the SNMP leaf number where we store the transmitted bytes of the interface.
+	tcNetDevTxBytesLeaf = 90
+
+	// tcNetDevTxPktLeaf is the SNMP leaf number where we store the transmitted packets of the interface.
+	tcNetDevTxPktLeaf = 91
+
+	// tcNetDevTxErrorsLeaf is the SNMP leaf number where we store the transmit errors of the interface.
+	tcNetDevTxErrorsLeaf = 92
+
+	// tcNetDevTxDroppedLeaf is the SNMP leaf number where we store the packets of the interface dropped by the kernel
+	// or the driver on transmit.
+	tcNetDevTxDroppedLeaf = 93
+
+	// tcNetDevRxMissedLeaf is the SNMP leaf number where we store the received packets of the interface missed by the
+	// NIC. These are only exported if the counters were collected by the ip command.
+	tcNetDevRxMissedLeaf = 94
+
+	// tcNetDevCarrierChangesLeaf is the SNMP leaf number where we store the carrier transitions of the interface.
+	// These are only exported if the counters were collected by the ip command.
+	tcNetDevCarrierChangesLeaf = 95
+
+	// tcUserDownConntrackBytesLeaf is the SNMP leaf number where we store the downloaded bytes of each user counted by
+	// conntrack.
+	tcUserDownConntrackBytesLeaf = 96
+
+	// tcUserUpConntrackBytesLeaf is the SNMP leaf number where we store the uploaded bytes of each user counted by
+	// conntrack.
+	tcUserUpConntrackBytesLeaf = 97
+
+	// tcRuntimeLeaf is the SNMP leaf number of the subtree where we store the statistics of the Go runtime.
+	tcRuntimeLeaf = 98
+
+	// tcConfigLeaf is the SNMP leaf number of the subtree where we store the running configuration.
+	tcConfigLeaf = 99
+
+	// tcDeltaBytesLeaf is the SNMP leaf number where we store the sent bytes of each Qdisc / Class during the last
+	// interval.
+	tcDeltaBytesLeaf = 100
+
+	// tcDeltaPktLeaf is the SNMP leaf number where we store the sent packets of each Qdisc / Class during the last
+	// interval.
+	tcDeltaPktLeaf = 101
+
+	// tcUserDownDeltaBytesLeaf is the SNMP leaf number where we store the downloaded bytes of each user during the last
+	// interval.
+	tcUserDownDeltaBytesLeaf = 102
+
+	// tcUserDownDeltaPktLeaf is the SNMP leaf number where we store the downloaded packets of each user during the
+	// last interval.
+	tcUserDownDeltaPktLeaf = 103
+
+	// tcUserUpDeltaBytesLeaf is the SNMP leaf number where we store the uploaded bytes of each user during the last
+	// interval.
+	tcUserUpDeltaBytesLeaf = 104
+
+	// tcUserUpDeltaPktLeaf is the SNMP leaf number where we store the uploaded packets of each user during the last
+	// interval.
+	tcUserUpDeltaPktLeaf = 105
+
+	// tcDeltaIntervalLeaf is the SNMP leaf number where we store the length of the last interval in milliseconds.
+	tcDeltaIntervalLeaf = 106
+
+	// tcMonotonicLeaf is the SNMP leaf number where we store the monotonic timestamp of the parse cycle of each
+	// Qdisc / Class in milliseconds.
+	tcMonotonicLeaf = 107
+
+	// tcUserMonotonicLeaf is the SNMP leaf number where we store the monotonic timestamp of the parse cycle of each
+	// user in milliseconds.
+	tcUserMonotonicLeaf = 108
+
+	// tcGroupMonotonicLeaf is the SNMP leaf number where we store the monotonic timestamp of the parse cycle of each
+	// group in milliseconds.
+	tcGroupMonotonicLeaf = 109
+
+	// tcIfaceMonotonicLeaf is the SNMP leaf number where we store the monotonic timestamp of the parse cycle of each
+	// interface in milliseconds.
+	tcIfaceMonotonicLeaf = 110
 )
 
-// The enumerated direction of traffic used in userClass.
+// The indexes in the tcRuntimeLeaf subtree.
 const (
-	uploadDirection = iota
-	downloadDirection
+	// runtimeHeapAllocIndex stores a gauge, the number of bytes of the allocated heap objects.
+	runtimeHeapAllocIndex = 1
+
+	// runtimeHeapSysIndex stores a gauge, the number of bytes of the heap memory obtained from the OS.
+	runtimeHeapSysIndex = 2
+
+	// runtimeHeapObjectsIndex stores a gauge, the number of the allocated heap objects.
+	runtimeHeapObjectsIndex = 3
+
+	// runtimeSysIndex stores a gauge, the total number of bytes of the memory obtained from the OS.
+	runtimeSysIndex = 4
+
+	// runtimeGoroutinesIndex stores a gauge, the number of the goroutines.
+	runtimeGoroutinesIndex = 5
+
+	// runtimeNumGCIndex stores a counter, the number of the completed garbage collections.
+	runtimeNumGCIndex = 6
+
+	// runtimeLastPauseIndex stores a gauge, the duration of the last garbage collection pause in microseconds.
+	runtimeLastPauseIndex = 7
+
+	// runtimeRssIndex stores a gauge, the number of bytes of the resident memory. It isn't exported if it can't be read.
+	runtimeRssIndex = 8
+
+	// runtimeCpuTimeIndex stores a counter, the user and system CPU time in milliseconds. It isn't exported if it
+	// can't be read.
+	runtimeCpuTimeIndex = 9
+
+	// runtimeUptimeIndex stores a timeticks, the time since tc_reader started.
+	runtimeUptimeIndex = 10
+)
+
+// The indexes in the tcConfigLeaf subtree.
+const (
+	// configIntervalIndex stores an integer, the parse interval in seconds.
+	configIntervalIndex = 1
+
+	// configIfacesIndex stores a string, the monitored interfaces separated by spaces.
+	configIfacesIndex = 2
+
+	// configUsersIndex stores an integer, the number of the configured users.
+	configUsersIndex = 3
+
+	// configFileIndex stores a string, the path of the config file.
+	configFileIndex = 4
+
+	// configChecksumIndex stores a string, the SHA-256 checksum of the content of the config files.
+	configChecksumIndex = 5
+
+	// configModifiedIndex stores a string, the most recent modification time of the config files.
+	configModifiedIndex = 6
+)
+
+// The entry statuses exported in tcStatusLeaf and tcUserStatusLeaf.
+const (
+	// entryActive marks the entries in the latest TC output.
+	entryActive = 1
+
+	// entryStale marks the Qdiscs / Classes that disappeared from the TC output and are exported with their last values.
+	entryStale = 2
+
+	// entryRemovePending marks the stale Qdiscs / Classes in the last cycle of their retention, these are removed in
+	// the next parse cycle.
+	entryRemovePending = 3
+)
+
+// maxEncodedName is the maximum length of the names that are encoded in the OIDs of tcNameToIndexLeaf and
+// tcUserNameToIndexLeaf, so that the OIDs stay within the 128 sub-identifiers that the SNMP daemon accepts.
+const maxEncodedName = 100
+
+// These variables are the default options used by snmp.
+var (
+	// maxWarnings is the default number of the most recent parse warnings that are exported.
+	maxWarnings = 10
+)
+
+// The enumerated direction of traffic used in UserClass.
+const (
+	// UploadDirection is the traffic sent by the user.
+	UploadDirection = iota
+
+	// DownloadDirection is the traffic received by the user.
+	DownloadDirection
 )
 
-// userClass stores the direction of traffic and the name of the user.
-type userClass struct {
-	direction int
-	name      string
+// knownXstats maps the names of the xstats that have their own leaf to the leaf numbers.
+var knownXstats = map[string]int{
+	"drop_overlimit": tcDropOverlimitLeaf,
+	"ecn_mark":       tcEcnMarkLeaf,
+	"new_flow_count": tcNewFlowCountLeaf,
+	"lended":         tcLendedLeaf,
+	"borrowed":       tcBorrowedLeaf,
+	"giants":         tcGiantsLeaf,
 }
 
-// snmpHandler stores parsed data from tcParser and and serves them to the SNMP daemon.
-type snmpHandler interface {
-	// lock should be called by the tcParser before it starts adding newly parsed data.
-	lock()
+// The enumerated packet size buckets used in pktSizeCounts.
+const (
+	smallPktBucket = iota
+	mediumPktBucket
+	largePktBucket
+	numPktBuckets
+)
+
+// UserClass stores the direction of traffic and the name of the user.
+type UserClass struct {
+	// Direction is the direction of the traffic, UploadDirection or DownloadDirection.
+	Direction int
 
-	// unlock should be called by the tcParser after it finished adding parsed data. Note, call to this method will also sort internally stored OIDs.
-	unlock()
+	// Name is the name of the user.
+	Name string
+}
+
+// key returns the key under which the counters of the user in this direction are tracked.
+func (u *UserClass) key() string {
+	return u.Name + ":" + strconv.Itoa(u.Direction)
+}
 
-	// erase clears out all stored data.
-	erase()
+// dataRefresher refreshes the stored data on demand.
+type dataRefresher interface {
+	// refresh re-parses the data if it is older than maxAge.
+	refresh(maxAge time.Duration)
 
-	// addData adds parsed data.
-	addData(data *parsedData)
+	// requested records that the SNMP daemon requested the data.
+	requested()
 }
 
 // snmpTalker reads one line from an input.
@@ -144,14 +562,17 @@ type snmpTalker interface {
 	putLine(line string)
 }
 
-// stdinTalker implements snmpTalker.
-type stdinTalker struct {
-	// reader reads lines from standard input.
+// streamTalker implements snmpTalker.
+type streamTalker struct {
+	// reader reads lines from the SNMP daemon, the standard input by default.
 	reader *bufio.Reader
+
+	// writer writes lines to the SNMP daemon, the standard output by default.
+	writer io.Writer
 }
 
-// getLine reads a single line from the standard input.
-func (s *stdinTalker) getLine() string {
+// getLine reads a single line from the reader.
+func (s *streamTalker) getLine() string {
 	line, isPrefix, err := s.reader.ReadLine()
 	var additional []byte
 	for isPrefix {
@@ -167,17 +588,17 @@ func (s *stdinTalker) getLine() string {
 	return string(line)
 }
 
-// putLine srites a single line to the standard output.
-func (s *stdinTalker) putLine(line string) {
-	fmt.Println(line)
+// putLine writes a single line to the writer.
+func (s *streamTalker) putLine(line string) {
+	fmt.Fprintln(s.writer, line)
 }
 
-// newStdinTalker creates new stdinTalker.
-func newStdinTalker() *stdinTalker {
-	s := &stdinTalker{
-		reader: bufio.NewReader(os.Stdin),
+// newStreamTalker creates new streamTalker that talks to the SNMP daemon over the reader and the writer.
+func newStreamTalker(r io.Reader, w io.Writer) *streamTalker {
+	return &streamTalker{
+		reader: bufio.NewReader(r),
+		writer: w,
 	}
-	return s
 }
 
 // parsedData is used to add parsed data by the tcParser.
@@ -198,7 +619,33 @@ type parsedData struct {
 	overLimitPkt int64
 
 	// userClass if present indicates that this parsedData holds information for a configured user name and not just generic Qdisc / Class.
-	userClass *userClass
+	userClass *UserClass
+
+	// ifIndex is the kernel ifIndex of the interface this Qdisc / Class is on, zero if it couldn't be resolved.
+	ifIndex int
+
+	// monotonic is the monotonic timestamp of the parse cycle the data was parsed in, see monotonicNow. It is zero if
+	// the data wasn't stamped.
+	monotonic time.Duration
+}
+
+// sample returns the counters of the parsedData.
+func (p *parsedData) sample() sample {
+	return sample{
+		bytes:        p.sentBytes,
+		pkt:          p.sentPkt,
+		droppedPkt:   p.droppedPkt,
+		overLimitPkt: p.overLimitPkt,
+	}
+}
+
+// retainedData is the last data of a Qdisc / Class that is kept for the retention.
+type retainedData struct {
+	// data is a copy of the last parsedData of the Qdisc / Class.
+	data parsedData
+
+	// cycle is the parse cycle in which the Qdisc / Class was last seen in the TC output.
+	cycle int
 }
 
 // snmpData represents data stored in the SNMP tree.
@@ -215,290 +662,1953 @@ type snmpData struct {
 	objectValue interface{}
 }
 
-type SnmpOptions struct {
-	// Debug determines whether we perform extensive logging to Syslog.
-	Debug bool
+// snapshot is a read-only copy of the stored data that is used to answer the SNMP daemon.
+// A new snapshot is published at the end of every update, so the reads never wait for the parser.
+type snapshot struct {
+	// data are copies of the stored snmpData ordered by their OIDs. The OIDs are looked up using binary search,
+	// so a single table serves both GET and GET-NEXT without a map.
+	data []snmpData
 }
 
-// snmp implements snmpHandler.
-type snmp struct {
-	// l is the lock surrounding access to the stored data.
-	l sync.Mutex
+// find returns the position of the OID in the snapshot and whether it was found.
+func (sn *snapshot) find(oid string) (int, bool) {
+	position := sort.Search(len(sn.data), func(i int) bool {
+		return !oidLess(sn.data[i].oid, oid)
+	})
+	return position, position < len(sn.data) && sn.data[position].oid == oid
+}
 
-	// snmpTalker reads lines from standard input, this is how SNMP daemon talks to us.
-	snmpTalker snmpTalker
+type SnmpOptions struct {
+	// Config is the running configuration exported under tcConfigLeaf, it isn't exported if this isn't set.
+	Config *ConfigInfo
 
-	// logger is the Writer used to log messages to Syslog.
-	logger sysLogger
+	// BaseOID is the OID the data and the notifications are exported under, e.g. ".1.3.6.1.4.1.2021.255".
+	// Defaults to myOID.
+	BaseOID string
 
-	// oidData is a map of OIDs to the snmpData objects holding the parsed data from TC.
-	oidData map[string]*snmpData
+	// Identity is the template of the identification of this process exported under myOID.
+	// Supports the hostnamePlaceholder, versionPlaceholder, labelsPlaceholder and instancePlaceholder.
+	Identity string
 
-	// oids is an ordered list of OIDs with data from tcParser.
-	oids []string
+	// Labels is a free form string substituted for the labelsPlaceholder in Identity.
+	Labels string
 
-	// options holds the configurable options.
-	options *SnmpOptions
+	// Instance is the name of this instance among the instances on the host, substituted for the instancePlaceholder
+	// in Identity, see leader.go.
+	Instance string
 
-	// tcLastNameIndex is the last assigned SNMP index to TC Queue / Class.
-	tcLastNameIndex int
+	// MaxWarnings is the number of the most recent parse warnings that are exported.
+	MaxWarnings int
 
-	// nameToIndex maps handle names to the assigned tcLastNameIndex.
-	nameToIndex map[string]int
+	// PktSizeBuckets are the two thresholds in bytes that split the average packet sizes into small, medium and large.
+	// An interval is small if its average packet size is below the first threshold and large if it is at least the second one.
+	// Packet size buckets aren't exported if this isn't set.
+	PktSizeBuckets []int
 
-	// tcLastUserIndex is the last assigned SNMP index to an user name.
-	tcLastUserIndex int
+	// MaxDataAge is the number of seconds after which the stored data is considered stale. A GET or GET-NEXT of stale
+	// data triggers an immediate re-parse before it is answered. Data is never refreshed on demand if this isn't set.
+	MaxDataAge int
 
-	// userToIndex maps user names to the assigned tcLastUserIndex.
-	userToIndex map[string]int
-}
+	// Retention is the number of parse cycles for which a Qdisc / Class that disappeared from the TC output keeps being
+	// exported with its last values. The disappeared Qdiscs / Classes are dropped immediately if this isn't set.
+	Retention int
 
-// NewSnmp creates new snmp.
-func NewSnmp(options *SnmpOptions, logger *syslog.Writer) *snmp {
-	s := &snmp{
-		snmpTalker: newStdinTalker(),
-		logger:     logger,
-		options:    options,
-	}
-	// Erase and initialize.
-	s.erase()
-	return s
-}
+	// ExportGeneric indicates whether the Qdiscs / Classes are exported, they are if this isn't set.
+	ExportGeneric *bool
 
-// logIfDebug logs a message into Syslog if the debug option is set.
-func (s *snmp) logIfDebug(message string) {
-	if s.options.Debug {
-		s.logger.Info(message)
-	}
-}
+	// ExportUsers indicates whether the users are exported, they are if this isn't set.
+	ExportUsers *bool
 
-// lock locks access to the stored data, no read will be allowed. This is used while updating the stored data.
-func (s *snmp) lock() {
-	s.l.Lock()
-}
+	// ExportMetrics are the names of the exported metrics as in the alert rules, the leaves of the other metrics are
+	// left out of the tree. All the metrics are exported if this isn't set.
+	ExportMetrics []string
 
-// unlock releases the lock that disallows access to the stored data and sorts the stored OIDs to the order expected by the SNMP daemon.
-func (s *snmp) unlock() {
-	// Sort the OIDs so that the SNMP daemon does not bark at us ...
-	s.sortOIDs()
-	s.l.Unlock()
-}
+	// ExportDeltas indicates whether the sent bytes and packets of the Qdiscs / Classes and users during the last
+	// interval are exported together with the length of the interval.
+	ExportDeltas bool
 
-// erase removes all stored data. Lock should be acquired by the caller before calling erase.
-func (s *snmp) erase() {
-	// Erase any old data.
-	s.oidData = make(map[string]*snmpData)
-	s.oids = make([]string, 0)
-	s.tcLastNameIndex = 0
-	s.nameToIndex = make(map[string]int)
-	s.tcLastUserIndex = 0
-	s.userToIndex = make(map[string]int)
+	// QuotaFile is the file the monthly byte totals of the users are persisted to. The totals aren't exported if this isn't set.
+	QuotaFile string
 
-	// Identify ourselves.
-	s.addSnmpData(myOID, "string", myName)
+	// CacheFile is the file the exported data is persisted to after each successful parse cycle. The persisted data is
+	// served after a restart until the first parse cycle completes. The data isn't persisted if this isn't set.
+	CacheFile string
 
-	// Identify the main parts of the output.
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcIndexLeaf), "string", "tcIndexLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcNameLeaf), "string", "tcNameLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, sentBytesLeaf), "string", "sentBytesLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, sentPktLeaf), "string", "sentPktLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, droppedPktLeaf), "string", "droppedPktLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, overLimitPktLeaf), "string", "overLimitPktLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserIndexLeaf), "string", "tcUserIndexLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserNameLeaf), "string", "tcUserNameLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserDownBytesLeaf), "string", "tcUserDownBytesLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserDownPktLeaf), "string", "tcUserDownPktLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserDownDroppedPktLeaf), "string", "tcUserDownDroppedPktLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserDownOverLimitPktLeaf), "string", "tcUserDownOverLimitPktLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserUpBytesLeaf), "string", "tcUserUpBytesLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserUpPktLeaf), "string", "tcUserUpPktLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserUpDroppedPktLeaf), "string", "tcUserUpDroppedPktLeaf")
-	s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserUpOverLimitPktLeaf), "string", "tcUserUpOverLimitPktLeaf")
-}
-
-// addSnmpData adds data stored in snmpData struct.
-func (s *snmp) addSnmpData(oid, objectType string, objectValue interface{}) {
-	data := &snmpData{
-		oid:         oid,
-		objectType:  objectType,
-		objectValue: objectValue,
-	}
-	s.oids = append(s.oids, oid)
-	s.oidData[oid] = data
-}
+	// HistoryRetention is the time after which the users that weren't seen are forgotten by the QuotaFile and after
+	// which a CacheFile that wasn't updated is removed instead of being served. These are kept if this isn't set.
+	HistoryRetention time.Duration
 
-// addGenericData stores the data from parsedData as data for generic Qdisc / Class.
-func (s *snmp) addGenericData(data *parsedData) {
-	tcIndex, ok := s.nameToIndex[data.name]
-	if !ok {
-		// Populate tcIndexLeaf.
-		s.tcLastNameIndex += 1
-		tcIndex = s.tcLastNameIndex
-		s.nameToIndex[data.name] = tcIndex
-		// Populate tcIndexLeaf.
-		tcIndexOID := fmt.Sprintf("%s.%d.%d", myOID, tcIndexLeaf, tcIndex)
-		s.addSnmpData(tcIndexOID, "integer", tcIndex)
+	// Quotas maps the user names to the numbers of bytes they can transfer in both directions per period. The users
+	// crossing the quotaThresholds are logged. The quotas are only checked if the QuotaFile is configured.
+	Quotas map[string]int64
 
-		// Populate tcNameLeaf.
-		tcNameOID := fmt.Sprintf("%s.%d.%d", myOID, tcNameLeaf, tcIndex)
-		s.addSnmpData(tcNameOID, "string", data.name)
+	// QuotaResetDay is the day of the month on which the monthly byte totals of the users start from zero.
+	QuotaResetDay int
 
-		// Populate tcNumIndexLeaf.
-		s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcNumIndexLeaf), "integer", s.tcLastNameIndex)
-	}
+	// Trap configures the notifications, these aren't sent if this isn't set.
+	Trap *TrapOptions
 
-	// Populate sentBytesLeaf.
-	tcSentBytesOID := fmt.Sprintf("%s.%d.%d", myOID, sentBytesLeaf, tcIndex)
-	s.addSnmpData(tcSentBytesOID, "counter64", data.sentBytes)
+	// TrapDropRate is the percentage of dropped packets of a Qdisc / Class during an interval above which the
+	// tcDropRateTrap is sent. The drop rate isn't checked if this isn't set.
+	TrapDropRate int
 
-	// Populate sentPktLeaf.
-	tcSentPktOID := fmt.Sprintf("%s.%d.%d", myOID, sentPktLeaf, tcIndex)
-	s.addSnmpData(tcSentPktOID, "counter64", data.sentPkt)
+	// RateSamples is the number of the most recent parse cycles kept for the moving average rates. The windows of the
+	// averages longer than RateSamples parse intervals are shortened. The rates aren't exported if this isn't set.
+	RateSamples int
 
-	// Populate droppedPktLeaf.
-	tcDroppedPktOID := fmt.Sprintf("%s.%d.%d", myOID, droppedPktLeaf, tcIndex)
-	s.addSnmpData(tcDroppedPktOID, "counter64", data.droppedPkt)
+	// Alerts are the thresholds on the exported metrics evaluated after each parse cycle.
+	Alerts []alertRule
 
-	// Populate overLimitPktLeaf.
-	tcOverlimitPktOID := fmt.Sprintf("%s.%d.%d", myOID, overLimitPktLeaf, tcIndex)
-	s.addSnmpData(tcOverlimitPktOID, "counter64", data.overLimitPkt)
+	// UserNames selects how the names of the users are sent to the sinks, in the notifications and to the alert
+	// actions, one of userNamesPlain, userNamesHash or userNamesPseudonym, see privacy.go. Defaults to userNamesPlain.
+	UserNames string
+
+	// UserNameKey is the key of the pseudonyms of the user names if UserNames is userNamesPseudonym.
+	UserNameKey string
+
+	// Graphite configures sending the metrics to a Graphite Carbon daemon after each parse cycle, nil if they aren't sent.
+	Graphite *GraphiteOptions
+
+	// Mqtt configures publishing the metrics to a MQTT broker after each parse cycle, nil if they aren't published.
+	Mqtt *MqttOptions
+
+	// SampleFile configures appending the metrics to a file after each parse cycle, nil if they aren't written.
+	SampleFile *SampleFileOptions
+
+	// Rrd configures updating RRD files after each parse cycle, nil if they aren't updated.
+	Rrd *RrdOptions
+
+	// Zabbix configures pushing the metrics to a Zabbix server after each parse cycle, nil if they aren't pushed.
+	Zabbix *ZabbixOptions
+
+	// Http configures the embedded HTTP server serving the metrics as JSON, nil if it isn't started.
+	Http *HttpOptions
+
+	// Grpc configures the gRPC server serving the metrics, nil if it isn't started.
+	Grpc *GrpcOptions
+
+	// Pprof configures the server of the Go profiles and runtime statistics, nil if it isn't started.
+	Pprof *PprofOptions
+
+	// RuntimeStats indicates whether the statistics of the Go runtime and the resource usage of tc_reader are exported
+	// under tcRuntimeLeaf.
+	RuntimeStats bool
+
+	// Version is the version of tc_reader substituted for the versionPlaceholder in Identity.
+	Version string
+
+	// BuildInfo is the version, commit and build date of tc_reader exported under tcVersionLeaf. Defaults to Version.
+	BuildInfo string
+
+	// Debug determines whether we perform extensive logging to Syslog.
+	Debug bool
 }
 
-// addUserData stores the data from parsedData as data for a configured user name.
-func (s *snmp) addUserData(data *parsedData) {
-	// Create new index for this user if we don't have it already.
-	tcUserIndex, ok := s.userToIndex[data.userClass.name]
-	if !ok {
-		// Populate tcUserIndexLeaf.
-		s.tcLastUserIndex += 1
-		tcUserIndex = s.tcLastUserIndex
-		s.userToIndex[data.userClass.name] = s.tcLastUserIndex
-		tcUserIndexOID := fmt.Sprintf("%s.%d.%d", myOID, tcUserIndexLeaf, tcUserIndex)
-		s.addSnmpData(tcUserIndexOID, "integer", tcUserIndex)
+// ConfigInfo describes the running configuration.
+type ConfigInfo struct {
+	// ParseInterval is the parse interval in seconds.
+	ParseInterval int
+
+	// Ifaces are the monitored interfaces.
+	Ifaces []string
+
+	// Users is the number of the configured users.
+	Users int
 
-		// Populate tcUserNameLeaf.
-		tcUserNameOID := fmt.Sprintf("%s.%d.%d", myOID, tcUserNameLeaf, tcUserIndex)
-		s.addSnmpData(tcUserNameOID, "string", data.userClass.name)
+	// File is the path of the config file.
+	File string
 
-		// Export the number of user indexes.
-		s.addSnmpData(fmt.Sprintf("%s.%d", myOID, tcUserNumIndexLeaf), "integer", s.tcLastUserIndex)
+	// Checksum is the hex encoded SHA-256 checksum of the content of the config files in the order they were read.
+	Checksum string
+
+	// Modified is the most recent modification time of the config files, zero if none was read.
+	Modified time.Time
+}
+
+// baseOID returns the configured base OID, or the default one if it wasn't set.
+func (o *SnmpOptions) baseOID() string {
+	if o != nil && o.BaseOID != "" {
+		return o.BaseOID
 	}
-	var tcUserBytesOID, tcUserPktOID, tcUserDroppedPktOID, tcUserOverLimitPktOID string
-	switch data.userClass.direction {
-	case uploadDirection:
-		tcUserBytesOID = fmt.Sprintf("%s.%d.%d", myOID, tcUserUpBytesLeaf, tcUserIndex)
-		tcUserPktOID = fmt.Sprintf("%s.%d.%d", myOID, tcUserUpPktLeaf, tcUserIndex)
-		tcUserDroppedPktOID = fmt.Sprintf("%s.%d.%d", myOID, tcUserUpDroppedPktLeaf, tcUserIndex)
-		tcUserOverLimitPktOID = fmt.Sprintf("%s.%d.%d", myOID, tcUserUpOverLimitPktLeaf, tcUserIndex)
-
-	case downloadDirection:
-		tcUserBytesOID = fmt.Sprintf("%s.%d.%d", myOID, tcUserDownBytesLeaf, tcUserIndex)
-		tcUserPktOID = fmt.Sprintf("%s.%d.%d", myOID, tcUserDownPktLeaf, tcUserIndex)
-		tcUserDroppedPktOID = fmt.Sprintf("%s.%d.%d", myOID, tcUserDownDroppedPktLeaf, tcUserIndex)
-		tcUserOverLimitPktOID = fmt.Sprintf("%s.%d.%d", myOID, tcUserDownOverLimitPktLeaf, tcUserIndex)
+	return myOID
+}
+
+// buildInfo returns the configured buildInfo, or the version if it wasn't set.
+func (o *SnmpOptions) buildInfo() string {
+	if o != nil && o.BuildInfo != "" {
+		return o.BuildInfo
 	}
-	// Populate tcUser*BytesLeaf.
-	if tcUserBytesOID != "" {
-		s.addSnmpData(tcUserBytesOID, "counter64", data.sentBytes)
+	if o != nil && o.Version != "" {
+		return o.Version
 	}
+	return "unknown"
+}
 
-	// Populate tcUser*PktLeaf.
-	if tcUserPktOID != "" {
-		s.addSnmpData(tcUserPktOID, "counter64", data.sentPkt)
+// identity returns the configured identity, or the default one if it wasn't set.
+func (o *SnmpOptions) identity() string {
+	if o != nil && o.Identity != "" {
+		return o.Identity
 	}
+	return myName
+}
 
-	// Populate tcUser*DroppedPktLeaf.
-	if tcUserDroppedPktOID != "" {
-		s.addSnmpData(tcUserDroppedPktOID, "counter64", data.droppedPkt)
+// maxWarnings returns the configured maxWarnings, or the default one if it wasn't set.
+func (o *SnmpOptions) maxWarnings() int {
+	if o != nil && o.MaxWarnings != 0 {
+		return o.MaxWarnings
 	}
+	return maxWarnings
+}
 
-	// Populate tcUser*OverLimitPktLeaf.
-	if tcUserOverLimitPktOID != "" {
-		s.addSnmpData(tcUserOverLimitPktOID, "counter64", data.overLimitPkt)
+// maxDataAge returns the configured maxDataAge, zero if the data shouldn't be refreshed on demand.
+func (o *SnmpOptions) maxDataAge() time.Duration {
+	if o != nil && o.MaxDataAge > 0 {
+		return time.Duration(o.MaxDataAge) * time.Second
 	}
+	return 0
 }
 
-// addData stores the content of parsedData so it can be served to the SNMP daemon.
-func (s *snmp) addData(data *parsedData) {
-	switch data.userClass {
-	// The data holds information about a generic Qdisc / Class.
-	case nil:
-		s.addGenericData(data)
-
-	// The data holds information about a configured user.
-	default:
-		s.addUserData(data)
+// retention returns the configured retention, zero if the disappeared Qdiscs / Classes should be dropped immediately.
+func (o *SnmpOptions) retention() int {
+	if o != nil && o.Retention > 0 {
+		return o.Retention
 	}
+	return 0
 }
 
-// snmpGet performs a SNMP get for the SNMP daemon.
-func (s *snmp) snmpGet(oid string) {
-	s.l.Lock()
-	defer s.l.Unlock()
+// exportGeneric returns true if the Qdiscs / Classes are exported.
+func (o *SnmpOptions) exportGeneric() bool {
+	return o == nil || o.ExportGeneric == nil || *o.ExportGeneric
+}
 
-	if snmpData, ok := s.oidData[oid]; ok {
-		s.printData(snmpData)
-	} else {
-		s.snmpTalker.putLine(emptyLine)
+// exportUsers returns true if the users are exported.
+func (o *SnmpOptions) exportUsers() bool {
+	return o == nil || o.ExportUsers == nil || *o.ExportUsers
+}
+
+// quotaResetDay returns the configured quotaResetDay, or the default one if it wasn't set or isn't a valid day.
+func (o *SnmpOptions) quotaResetDay() int {
+	if o != nil && o.QuotaResetDay > 0 && o.QuotaResetDay <= maxQuotaResetDay {
+		return o.QuotaResetDay
 	}
+	return quotaResetDay
 }
 
-// snmpGet performs a SNMP walk for the SNMP daemon.
-func (s *snmp) snmpGetNext(oid string) {
+// expandIdentity replaces the placeholders in the identity template.
+func expandIdentity(template, hostname, version, labels, instance string) string {
+	r := strings.NewReplacer(
+		hostnamePlaceholder, hostname,
+		versionPlaceholder, version,
+		labelsPlaceholder, labels,
+		instancePlaceholder, instance,
+	)
+	return r.Replace(template)
+}
+
+// snmp implements dataSink.
+type snmp struct {
+	// l is the lock surrounding updates of the stored data.
+	l sync.Mutex
+
+	// current is the most recently published snapshot of the stored data, the SNMP daemon is answered from it.
+	current atomic.Pointer[snapshot]
+
+	// snmpTalker reads lines from standard input, this is how SNMP daemon talks to us. See WithTalker.
+	snmpTalker snmpTalker
+
+	// refresher refreshes stale data before GET and GET-NEXT requests are answered, can be nil.
+	refresher dataRefresher
+
+	// debug turns the debug logging on or off at runtime.
+	debug debugSwitch
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// oidData is a map of OIDs to the snmpData objects holding the parsed data from TC.
+	oidData map[string]*snmpData
+
+	// hiddenLeaves are the leaves of the metrics that aren't exported.
+	hiddenLeaves map[int]bool
+
+	// oids is an ordered list of OIDs with data from tcParser.
+	oids []string
+
+	// newOIDs are the OIDs added since the last commit(), these are merged into oids by commit().
+	newOIDs []string
+
+	// cycle is the number of the current parse cycle, incremented by every erase().
+	cycle int
+
+	// oidCycle maps the OIDs to the parse cycle in which they were last added. The OIDs that weren't added in the
+	// current cycle are removed by commit().
+	oidCycle map[string]int
+
+	// options holds the configurable options.
+	options *SnmpOptions
+
+	// identity is the identification of this process exported under myOID.
+	identity string
+
+	// config is the running configuration exported under tcConfigLeaf.
+	config *ConfigInfo
+
+	// tcLastNameIndex is the highest SNMP index assigned to a TC Queue / Class.
+	tcLastNameIndex int
+
+	// nameToIndex maps handle names to their SNMP indexes, a name keeps its index until it isn't added in a parse
+	// cycle, see releaseIndexes. These are kept across erase().
+	nameToIndex map[string]int
+
+	// nameCycle maps handle names to the parse cycle in which they were last added.
+	nameCycle map[string]int
+
+	// freeNameIndexes are the released SNMP indexes below the tcLastNameIndex, sorted.
+	freeNameIndexes []int
+
+	// tcLastUserIndex is the highest SNMP index assigned to an user name.
+	tcLastUserIndex int
+
+	// userToIndex maps user names to their SNMP indexes, the same way as the nameToIndex.
+	userToIndex map[string]int
+
+	// userCycle maps user names to the parse cycle in which they were last added.
+	userCycle map[string]int
+
+	// freeUserIndexes are the released SNMP indexes below the tcLastUserIndex, sorted.
+	freeUserIndexes []int
+
+	// tcLastGroupIndex is the last assigned SNMP index to an interface group.
+	tcLastGroupIndex int
+
+	// tcLastIfaceIndex is the last assigned SNMP index to the totals of an interface.
+	tcLastIfaceIndex int
+
+	// tcLastXstatIndex is the last assigned SNMP index to an xstat that doesn't have its own leaf.
+	tcLastXstatIndex int
+
+	// warnings are the most recent parse warnings, the oldest one first. These are kept across erase().
+	warnings []string
+
+	// numWarnings is the total number of parse warnings since start.
+	numWarnings int64
+
+	// maintenance indicates that the collection is paused during a blackout window.
+	maintenance bool
+
+	// failures is the number of consecutive parse cycles in which TC failed, this is kept across erase().
+	failures int
+
+	// backoff is the number of seconds between the collection attempts after TC failures, this is kept across erase().
+	backoff int
+
+	// unmatchedLines is the number of lines of the TC output that matched no pattern in the last parse cycle, this is
+	// kept across erase().
+	unmatchedLines int
+
+	// tcCounters remembers the Qdisc / Class counters from the previous interval, these are kept across erase().
+	tcCounters counterTracker
+
+	// userCounters remembers the user counters from the previous interval, these are kept across erase().
+	userCounters counterTracker
+
+	// tcTotals accumulates the Qdisc / Class counters across counter resets, these are kept across erase().
+	tcTotals accumulator
+
+	// userTotals accumulates the user counters across counter resets, these are kept across erase().
+	userTotals accumulator
+
+	// groupTotals accumulates the interface group counters across counter resets, these are kept across erase().
+	groupTotals accumulator
+
+	// ifaceTotals accumulates the interface counters across counter resets, these are kept across erase().
+	ifaceTotals accumulator
+
+	// tcDeltas remembers the accumulated Qdisc / Class counters of the previous parse cycle, these are kept across
+	// erase().
+	tcDeltas deltaTracker
+
+	// userDeltas remembers the accumulated user counters of the previous parse cycle, these are kept across erase().
+	userDeltas deltaTracker
+
+	// retained maps the names of the Qdiscs / Classes to their last data when the retention is configured, these are
+	// kept across erase().
+	retained map[string]*retainedData
+
+	// quota accounts the monthly byte totals of the users, nil if the QuotaFile isn't configured.
+	quota *quotaTracker
+
+	// quotaLevels maps the user names to the highest of the quotaThresholds they reached, these are kept across erase().
+	quotaLevels map[string]int64
+
+	// notifier sends the notifications, nil if these aren't configured.
+	notifier notifier
+
+	// dropRateHigh maps the names of the Qdiscs / Classes whose drop rate is above the TrapDropRate to true, these are
+	// kept across erase().
+	dropRateHigh map[string]bool
+
+	// alertSamples maps the alert rules and names to the last evaluated values, these are kept across erase().
+	alertSamples map[string]alertSample
+
+	// alertsActive maps the alert rules and names whose comparison is true to true, these are kept across erase().
+	alertsActive map[string]bool
+
+	// alertCycle is the parse cycle in which the alerts were last evaluated.
+	alertCycle int
+
+	// runHook runs the hook scripts of the alertExec actions.
+	runHook func(command string, args ...string)
+
+	// sinks receive the values of the metrics after each parse cycle.
+	sinks []metricSink
+
+	// pseudonymizer replaces the names of the users in the values sent to the sinks, nil if these are kept.
+	pseudonymizer *pseudonymizer
+
+	// flushCycle is the parse cycle in which the sinks were last flushed.
+	flushCycle int
+
+	// cacheCycle is the parse cycle in which the data was last persisted to the CacheFile.
+	cacheCycle int
+
+	// cycleTime is the time the current parse cycle started, set by erase().
+	cycleTime time.Time
+
+	// cycleMonotonic is the monotonic timestamp of the current parse cycle, taken from the added data. It is zero until
+	// stamped data is added.
+	cycleMonotonic time.Duration
+
+	// previousCycleMonotonic is the monotonic timestamp of the previous parse cycle, zero if it isn't known.
+	previousCycleMonotonic time.Duration
+
+	// lastUpdate is the time the last successful parse cycle started, zero before the first one.
+	lastUpdate time.Time
+
+	// tcRates keep the recent byte counters of the Qdiscs / Classes for the moving average rates, these are kept across erase().
+	tcRates map[string]*rateRing
+
+	// userRates keep the recent byte counters of the users and directions for the moving average rates, these are kept across erase().
+	userRates map[string]*rateRing
+
+	// pktSizeCounts counts the intervals in each packet size bucket for every user and direction, these are kept across erase().
+	pktSizeCounts map[string]*[numPktBuckets]int64
+}
+
+// SnmpOption configures the snmp created by NewSnmp.
+type SnmpOption func(*snmp) error
+
+// WithSnmpOptions sets the configurable options of the snmp, the defaults are used without it.
+func WithSnmpOptions(options *SnmpOptions) SnmpOption {
+	return func(s *snmp) error {
+		if options == nil {
+			return errors.New("the SnmpOptions must not be nil")
+		}
+		s.options = options
+		return nil
+	}
+}
+
+// WithTalker serves the pass_persist protocol of the SNMP daemon over the reader and the writer instead of the standard
+// input and output, e.g. over a pipe to drive Listen from tests as the SNMP daemon would, see simulate.go.
+func WithTalker(r io.Reader, w io.Writer) SnmpOption {
+	return func(s *snmp) error {
+		if r == nil || w == nil {
+			return errors.New("the reader and the writer of the SNMP daemon must not be nil")
+		}
+		s.snmpTalker = newStreamTalker(r, w)
+		return nil
+	}
+}
+
+// NewSnmp creates new snmp, returns an error if a configured notification target, sink or server can't be set up.
+// The SNMP daemon is served only after Listen is called.
+func NewSnmp(logger Logger, opts ...SnmpOption) (*snmp, error) {
+	if logger == nil {
+		return nil, errors.New("a Logger is required")
+	}
+	s := &snmp{
+		snmpTalker: newStreamTalker(os.Stdin, os.Stdout),
+		logger:     logger,
+		options:    &SnmpOptions{},
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	options := s.options
+	if !validOID(options.baseOID()) {
+		return nil, fmt.Errorf("the base OID must be a numeric OID with a leading dot, got '%s'", options.baseOID())
+	}
+	s.debug.configure(options.Debug)
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Err(fmt.Sprintf("NewSnmp(): Unable to determine the hostname for the identity, error: %s", err))
+	}
+	s.identity = expandIdentity(options.identity(), hostname, options.Version, options.Labels, options.Instance)
+	s.config = options.Config
+	s.hiddenLeaves = hiddenLeaves(options.ExportMetrics)
+	if s.pseudonymizer, err = newPseudonymizer(options.UserNames, options.UserNameKey); err != nil {
+		return nil, err
+	}
+	if options.QuotaFile != "" {
+		s.quota, err = loadQuota(options.QuotaFile, options.quotaResetDay())
+		if err != nil {
+			logger.Err(fmt.Sprintf("NewSnmp(): Unable to load the quota totals from %s, starting from zero, error: %s", options.QuotaFile, err))
+		}
+	} else if len(options.Quotas) > 0 {
+		logger.Err("NewSnmp(): The quotas of the users are configured, but they aren't checked without the quotaFile.")
+	}
+	s.runHook = func(command string, args ...string) {
+		runHook(logger, command, args...)
+	}
+	if options.Trap != nil && options.Trap.Target != "" {
+		sender, err := newTrapSender(options.Trap, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to send notifications to %s: %s", options.Trap.Target, err)
+		}
+		sender.baseOID = options.baseOID()
+		s.notifier = sender
+	}
+	if options.Graphite != nil && options.Graphite.Target != "" {
+		s.sinks = append(s.sinks, newCarbonSink(options.Graphite, logger))
+	}
+	if options.Mqtt != nil && options.Mqtt.Broker != "" {
+		sink, err := newMqttSink(options.Mqtt, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to publish the metrics to %s: %s", options.Mqtt.Broker, err)
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+	if options.SampleFile != nil && options.SampleFile.Path != "" {
+		s.sinks = append(s.sinks, newSampleFileSink(options.SampleFile, logger))
+	}
+	if options.Rrd != nil && options.Rrd.Dir != "" {
+		s.sinks = append(s.sinks, newRrdSink(options.Rrd, logger))
+	}
+	if options.Zabbix != nil && options.Zabbix.Server != "" {
+		s.sinks = append(s.sinks, newZabbixSink(options.Zabbix, logger))
+	}
+	if options.Http != nil && options.Http.Listen != "" {
+		server, err := newHttpServer(options.Http, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to start the HTTP server on %s: %s", options.Http.Listen, err)
+		}
+		s.sinks = append(s.sinks, server)
+	}
+	if options.Grpc != nil && options.Grpc.Listen != "" {
+		server, err := newGrpcServer(options.Grpc, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to start the gRPC server on %s: %s", options.Grpc.Listen, err)
+		}
+		s.sinks = append(s.sinks, server)
+	}
+	if options.Pprof != nil && options.Pprof.Listen != "" {
+		server, err := newPprofServer(options.Pprof, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to start the pprof server on %s: %s", options.Pprof.Listen, err)
+		}
+		s.sinks = append(s.sinks, server)
+	}
+	// Erase and initialize.
+	s.begin()
+	s.erase()
+	s.commit()
+	if options.CacheFile != "" {
+		if options.HistoryRetention > 0 {
+			expired, err := expireFile(options.CacheFile, time.Now().Add(-options.HistoryRetention))
+			if err != nil {
+				logger.Err(fmt.Sprintf("NewSnmp(): Unable to remove the expired persisted data from %s, error: %s", options.CacheFile, err))
+			} else if expired {
+				logger.Info(fmt.Sprintf("NewSnmp(): Removed the persisted data from %s, it is older than the historyRetention.", options.CacheFile))
+			}
+		}
+		cached, err := loadCache(options.CacheFile)
+		if err != nil {
+			logger.Err(fmt.Sprintf("NewSnmp(): Unable to load the persisted data from %s, starting empty, error: %s", options.CacheFile, err))
+		} else if cached != nil {
+			// Served until the first parse cycle publishes its snapshot.
+			s.current.Store(cached)
+		}
+	}
+	return s, nil
+}
+
+// logIfDebug logs a debug message if the debug logging is on.
+func (s *snmp) logIfDebug(message string) {
+	if s.debug.enabled() {
+		logDebug(s.logger, message)
+	}
+}
+
+// SetDebug turns the debug logging on or off regardless of the Debug option.
+func (s *snmp) SetDebug(on bool) {
+	s.debug.set(on)
+}
+
+// SetConfig replaces the running configuration exported under tcConfigLeaf, e.g. after it was reloaded. It is
+// exported from the next parse cycle.
+func (s *snmp) SetConfig(config *ConfigInfo) {
 	s.l.Lock()
 	defer s.l.Unlock()
+	s.config = config
+}
+
+// begin locks the stored data for an update. The SNMP daemon is answered from the last published snapshot meanwhile.
+func (s *snmp) begin() {
+	s.l.Lock()
+}
+
+// commit finishes the update of the stored data and publishes a new snapshot of it. The OIDs that weren't added in the
+// current parse cycle are removed.
+func (s *snmp) commit() {
+	s.addLastUpdateData()
+	s.addDeltaIntervalData()
+	s.addWarningData()
+	s.addRuntimeData()
+	s.addRetainedData()
+	s.releaseIndexes()
+	s.addQuotaData()
+	s.pruneRates()
+	s.removeStaleOIDs()
+	s.evaluateAlerts(time.Now())
+	s.mergeNewOIDs()
+	s.publish()
+	s.flushSinks()
+	s.saveQuota()
+	s.saveCache()
+	s.l.Unlock()
+}
+
+// addMonotonicData exports the monotonic timestamp of the data in milliseconds into the leaf under the index and records
+// it as the timestamp of the current parse cycle. Nothing is exported for the data that wasn't stamped. Lock should be
+// acquired by the caller.
+func (s *snmp) addMonotonicData(leaf, index int, data *parsedData) {
+	if data.monotonic == 0 {
+		return
+	}
+	s.cycleMonotonic = data.monotonic
+	s.addSnmpData(indexOID(leaf, index), "counter64", data.monotonic.Milliseconds())
+}
+
+// addDeltaIntervalData exports the length of the interval the tcDeltaBytesLeaf and the related leaves were computed
+// over. Lock should be acquired by the caller.
+func (s *snmp) addDeltaIntervalData() {
+	if s.options.ExportDeltas && s.previousCycleMonotonic != 0 && s.cycleMonotonic > s.previousCycleMonotonic {
+		s.addSnmpData(leafOID(tcDeltaIntervalLeaf), "gauge", (s.cycleMonotonic - s.previousCycleMonotonic).Milliseconds())
+	}
+}
+
+// addLastUpdateData records the time of the parse cycle if it succeeded and exports the time since the last successful
+// one. The cycles in which the data wasn't erased, e.g. during a blackout window, and the initial empty cycle don't
+// count. Lock should be acquired by the caller.
+func (s *snmp) addLastUpdateData() {
+	if s.failures == 0 && s.cycle > 1 && s.cycleTime.After(s.lastUpdate) {
+		s.lastUpdate = s.cycleTime
+	}
+	if !s.lastUpdate.IsZero() {
+		s.addSnmpData(leafOID(tcLastUpdateLeaf), "timeticks", s.lastUpdate)
+	}
+}
+
+// addQuotaData exports the quota usage of the users with a configured quota that were added in this cycle and logs
+// the users that crossed one of the quotaThresholds. Lock should be acquired by the caller.
+func (s *snmp) addQuotaData() {
+	if s.quota == nil || len(s.options.Quotas) == 0 {
+		return
+	}
+	if s.quotaLevels == nil {
+		s.quotaLevels = make(map[string]int64)
+	}
+	names := make([]string, 0, len(s.userToIndex))
+	for name := range s.userToIndex {
+		if s.userCycle[name] == s.cycle {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		limit, ok := s.options.Quotas[name]
+		if !ok {
+			continue
+		}
+		tcUserIndex := s.userToIndex[name]
+		used := s.quota.userBytes(name)
+		quotaUsed := used * 100 / limit
+		s.addSnmpData(indexOID(tcUserQuotaExceededLeaf, tcUserIndex), "integer", boolToInt(used >= limit))
+		s.addSnmpData(indexOID(tcUserQuotaUsedLeaf, tcUserIndex), "gauge", quotaUsed)
+
+		level := quotaLevel(used, limit)
+		if level > s.quotaLevels[name] {
+			logWarning(s.logger, fmt.Sprintf("addQuotaData(): User %s used %d%% of the quota, %d of %d bytes since %s.", name, level, used, limit, s.quota.PeriodStart.Format("2006-01-02")))
+			if used >= limit {
+				// The variables are built from the computed values, the tcUserQuotaUsedLeaf may be hidden by the ExportMetrics.
+				s.notify(tcQuotaExceededTrap,
+					snmpData{indexOID(tcUserNameLeaf, tcUserIndex), "string", s.exportedUser(name)},
+					snmpData{indexOID(tcUserQuotaUsedLeaf, tcUserIndex), "gauge", quotaUsed},
+				)
+			}
+		}
+		s.quotaLevels[name] = level
+	}
+}
+
+// evaluateAlerts evaluates the alert rules once per parse cycle and fires the actions of the rules whose comparison
+// became true. Lock should be acquired by the caller.
+func (s *snmp) evaluateAlerts(now time.Time) {
+	if len(s.options.Alerts) == 0 || s.alertCycle == s.cycle {
+		return
+	}
+	s.alertCycle = s.cycle
+	if s.alertSamples == nil {
+		s.alertSamples = make(map[string]alertSample)
+		s.alertsActive = make(map[string]bool)
+	}
+
+	// seen are the evaluated alert rules and names, the state of the others is forgotten.
+	seen := make(map[string]bool)
+	for i, rule := range s.options.Alerts {
+		metric := namedMetrics[rule.metric]
+		for index := 1; index <= s.lastIndex(metric.nameLeaf); index++ {
+			nameData, ok := s.oidData[indexOID(metric.nameLeaf, index)]
+			if !ok {
+				continue
+			}
+			name, _ := nameData.objectValue.(string)
+			if matched, _ := path.Match(rule.pattern, name); !matched {
+				continue
+			}
+			data, ok := s.oidData[indexOID(metric.leaf, index)]
+			if !ok {
+				continue
+			}
+			value, ok := alertValue(data.objectValue)
+			if !ok {
+				continue
+			}
+			key := strconv.Itoa(i) + "/" + name
+			seen[key] = true
+			if rule.rate {
+				last, ok := s.alertSamples[key]
+				s.alertSamples[key] = alertSample{value, now}
+				elapsed := now.Sub(last.at).Seconds()
+				if !ok || elapsed <= 0 {
+					continue
+				}
+				value = (value - last.value) / elapsed
+			}
+
+			if !alertComparisons[rule.comparison](value, rule.threshold) {
+				delete(s.alertsActive, key)
+				continue
+			}
+			if !s.alertsActive[key] {
+				s.fireAlert(rule, name, value, *nameData, *data)
+			}
+			s.alertsActive[key] = true
+		}
+	}
+	for key := range s.alertSamples {
+		if !seen[key] {
+			delete(s.alertSamples, key)
+		}
+	}
+	for key := range s.alertsActive {
+		if !seen[key] {
+			delete(s.alertsActive, key)
+		}
+	}
+}
+
+// fireAlert performs the action of the alert rule whose comparison became true for the name.
+func (s *snmp) fireAlert(rule alertRule, name string, value float64, nameData, data snmpData) {
+	if namedMetrics[rule.metric].nameLeaf == tcUserNameLeaf {
+		name = s.exportedUser(name)
+		nameData.objectValue = name
+	}
+	switch rule.action {
+	case alertSyslog:
+		s.logger.Info(fmt.Sprintf("fireAlert(): Alert %s fired for %s, the value is %g.", rule, name, value))
+	case alertTrap:
+		s.notify(tcAlertTrap, nameData, data)
+	case alertExec:
+		s.runHook(rule.command, rule.metric, name, strconv.FormatFloat(value, 'g', -1, 64), strconv.FormatFloat(rule.threshold, 'g', -1, 64))
+	}
+}
+
+// lastIndex returns the last assigned index of the table whose names are stored in the leaf.
+func (s *snmp) lastIndex(nameLeaf int) int {
+	switch nameLeaf {
+	case tcNameLeaf:
+		return s.tcLastNameIndex
+	case tcUserNameLeaf:
+		return s.tcLastUserIndex
+	case tcGroupNameLeaf:
+		return s.tcLastGroupIndex
+	case tcIfaceNameLeaf:
+		return s.tcLastIfaceIndex
+	}
+	return 0
+}
+
+// metricValues returns the values of the namedMetrics, ordered by the metric and the index.
+// Lock should be acquired by the caller.
+func (s *snmp) metricValues() []metricValue {
+	metrics := make([]string, 0, len(namedMetrics))
+	for metric := range namedMetrics {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	var values []metricValue
+	for _, metric := range metrics {
+		m := namedMetrics[metric]
+		for index := 1; index <= s.lastIndex(m.nameLeaf); index++ {
+			nameData, ok := s.oidData[indexOID(m.nameLeaf, index)]
+			if !ok {
+				continue
+			}
+			data, ok := s.oidData[indexOID(m.leaf, index)]
+			if !ok {
+				continue
+			}
+			name, _ := nameData.objectValue.(string)
+			values = append(values, metricValue{metric, m.nameLeaf, name, data.objectValue})
+		}
+	}
+	return values
+}
+
+// flushSinks hands the values of the metrics of this cycle to the sinks, once per parse cycle so that the skipped
+// cycles aren't sent again. Lock should be acquired by the caller.
+func (s *snmp) flushSinks() {
+	if len(s.sinks) == 0 || s.flushCycle == s.cycle {
+		return
+	}
+	s.flushCycle = s.cycle
+	values := s.metricValues()
+	if s.pseudonymizer != nil {
+		s.pseudonymizer.values(values)
+	}
+	for _, sink := range s.sinks {
+		sink.flush(values, s.cycleTime)
+	}
+}
+
+// exportedUser returns the name of the user as it leaves the SNMP tree, e.g. in the notifications and the alert actions,
+// replaced by its hash or pseudonym if the UserNames say so.
+func (s *snmp) exportedUser(name string) string {
+	if s.pseudonymizer == nil {
+		return name
+	}
+	return s.pseudonymizer.name(name)
+}
+
+// saveQuota persists the monthly byte totals of the users if these are accounted. Lock should be acquired by the caller.
+func (s *snmp) saveQuota() {
+	if s.quota == nil {
+		return
+	}
+	if s.options.HistoryRetention > 0 {
+		s.quota.expire(time.Now().Add(-s.options.HistoryRetention))
+	}
+	if err := s.quota.save(); err != nil {
+		s.logger.Err(fmt.Sprintf("saveQuota(): Unable to persist the quota totals to %s, error: %s", s.quota.path, err))
+	}
+}
+
+// saveCache persists the published snapshot to the CacheFile once per successful parse cycle, so that a failed or the
+// initial empty cycle doesn't replace the persisted data. Lock should be acquired by the caller.
+func (s *snmp) saveCache() {
+	if s.options.CacheFile == "" || s.cacheCycle == s.cycle || s.lastUpdate != s.cycleTime {
+		return
+	}
+	s.cacheCycle = s.cycle
+	if err := saveCache(s.options.CacheFile, s.snapshot()); err != nil {
+		s.logger.Err(fmt.Sprintf("saveCache(): Unable to persist the data to %s, error: %s", s.options.CacheFile, err))
+	}
+}
+
+// publish atomically replaces the snapshot used to answer the SNMP daemon with a copy of the stored data.
+// Lock should be acquired by the caller.
+func (s *snmp) publish() {
+	next := &snapshot{
+		data: make([]snmpData, len(s.oids)),
+	}
+	for i, oid := range s.oids {
+		next.data[i] = *s.oidData[oid]
+	}
+	s.current.Store(next)
+}
+
+// snapshot returns the most recently published snapshot, or an empty one if nothing was published yet.
+func (s *snmp) snapshot() *snapshot {
+	if current := s.current.Load(); current != nil {
+		return current
+	}
+	return &snapshot{}
+}
+
+// erase starts a new parse cycle. Lock should be acquired by the caller before calling erase.
+// The stored data is kept so that the values of the known OIDs can be updated in place, the OIDs that aren't added
+// again before commit() are removed.
+func (s *snmp) erase() {
+	if s.oidData == nil {
+		s.oidData = make(map[string]*snmpData)
+		s.oidCycle = make(map[string]int)
+		s.nameToIndex = make(map[string]int)
+		s.nameCycle = make(map[string]int)
+		s.userToIndex = make(map[string]int)
+		s.userCycle = make(map[string]int)
+	}
+	s.pruneTrackers()
+	s.cycle += 1
+	s.previousCycleMonotonic, s.cycleMonotonic = s.cycleMonotonic, 0
+	s.cycleTime = time.Now()
+	s.tcLastGroupIndex = 0
+	s.tcLastIfaceIndex = 0
+	s.tcLastXstatIndex = 0
+
+	// Identify ourselves.
+	s.addSnmpData(myOID, "string", s.identity)
+
+	// Identify the main parts of the output.
+	s.addSnmpData(leafOID(tcIndexLeaf), "string", "tcIndexLeaf")
+	s.addSnmpData(leafOID(tcNameLeaf), "string", "tcNameLeaf")
+	s.addSnmpData(leafOID(sentBytesLeaf), "string", "sentBytesLeaf")
+	s.addSnmpData(leafOID(sentPktLeaf), "string", "sentPktLeaf")
+	s.addSnmpData(leafOID(droppedPktLeaf), "string", "droppedPktLeaf")
+	s.addSnmpData(leafOID(overLimitPktLeaf), "string", "overLimitPktLeaf")
+	s.addSnmpData(leafOID(tcUserIndexLeaf), "string", "tcUserIndexLeaf")
+	s.addSnmpData(leafOID(tcUserNameLeaf), "string", "tcUserNameLeaf")
+	s.addSnmpData(leafOID(tcUserDownBytesLeaf), "string", "tcUserDownBytesLeaf")
+	s.addSnmpData(leafOID(tcUserDownPktLeaf), "string", "tcUserDownPktLeaf")
+	s.addSnmpData(leafOID(tcUserDownDroppedPktLeaf), "string", "tcUserDownDroppedPktLeaf")
+	s.addSnmpData(leafOID(tcUserDownOverLimitPktLeaf), "string", "tcUserDownOverLimitPktLeaf")
+	s.addSnmpData(leafOID(tcUserUpBytesLeaf), "string", "tcUserUpBytesLeaf")
+	s.addSnmpData(leafOID(tcUserUpPktLeaf), "string", "tcUserUpPktLeaf")
+	s.addSnmpData(leafOID(tcUserUpDroppedPktLeaf), "string", "tcUserUpDroppedPktLeaf")
+	s.addSnmpData(leafOID(tcUserUpOverLimitPktLeaf), "string", "tcUserUpOverLimitPktLeaf")
+	s.addSnmpData(leafOID(tcIfIndexLeaf), "string", "tcIfIndexLeaf")
+	s.addSnmpData(leafOID(tcWarningLeaf), "string", "tcWarningLeaf")
+	s.addSnmpData(leafOID(tcUserDownAvgPktSizeLeaf), "string", "tcUserDownAvgPktSizeLeaf")
+	s.addSnmpData(leafOID(tcUserUpAvgPktSizeLeaf), "string", "tcUserUpAvgPktSizeLeaf")
+	s.addSnmpData(leafOID(tcGroupIndexLeaf), "string", "tcGroupIndexLeaf")
+	s.addSnmpData(leafOID(tcEfficiencyLeaf), "string", "tcEfficiencyLeaf")
+	s.addSnmpData(leafOID(tcMaintenanceLeaf), "integer", boolToInt(s.maintenance))
+	s.addSnmpData(leafOID(tcFailuresLeaf), "integer", s.failures)
+	s.addSnmpData(leafOID(tcBackoffLeaf), "integer", s.backoff)
+	s.addSnmpData(leafOID(tcUnmatchedLinesLeaf), "gauge", int64(s.unmatchedLines))
+	s.addSnmpData(leafOID(tcVersionLeaf), "string", s.options.buildInfo())
+	s.addSnmpData(leafOID(tcXstatIndexLeaf), "string", "tcXstatIndexLeaf")
+	s.addSnmpData(leafOID(tcXstatTcIndexLeaf), "string", "tcXstatTcIndexLeaf")
+	s.addSnmpData(leafOID(tcXstatNameLeaf), "string", "tcXstatNameLeaf")
+	s.addSnmpData(leafOID(tcXstatValueLeaf), "string", "tcXstatValueLeaf")
+	s.addSnmpData(leafOID(tcDropOverlimitLeaf), "string", "tcDropOverlimitLeaf")
+	s.addSnmpData(leafOID(tcEcnMarkLeaf), "string", "tcEcnMarkLeaf")
+	s.addSnmpData(leafOID(tcNewFlowCountLeaf), "string", "tcNewFlowCountLeaf")
+	s.addSnmpData(leafOID(tcLendedLeaf), "string", "tcLendedLeaf")
+	s.addSnmpData(leafOID(tcBorrowedLeaf), "string", "tcBorrowedLeaf")
+	s.addSnmpData(leafOID(tcGiantsLeaf), "string", "tcGiantsLeaf")
+	s.addSnmpData(leafOID(tcGroupNameLeaf), "string", "tcGroupNameLeaf")
+	s.addSnmpData(leafOID(tcGroupBytesLeaf), "string", "tcGroupBytesLeaf")
+	s.addSnmpData(leafOID(tcGroupPktLeaf), "string", "tcGroupPktLeaf")
+	s.addSnmpData(leafOID(tcGroupDroppedPktLeaf), "string", "tcGroupDroppedPktLeaf")
+	s.addSnmpData(leafOID(tcGroupOverLimitPktLeaf), "string", "tcGroupOverLimitPktLeaf")
+	s.addSnmpData(leafOID(tcIfaceIndexLeaf), "string", "tcIfaceIndexLeaf")
+	s.addSnmpData(leafOID(tcIfaceNameLeaf), "string", "tcIfaceNameLeaf")
+	s.addSnmpData(leafOID(tcIfaceBytesLeaf), "string", "tcIfaceBytesLeaf")
+	s.addSnmpData(leafOID(tcIfacePktLeaf), "string", "tcIfacePktLeaf")
+	s.addSnmpData(leafOID(tcIfaceDroppedPktLeaf), "string", "tcIfaceDroppedPktLeaf")
+	s.addSnmpData(leafOID(tcIfaceOverLimitPktLeaf), "string", "tcIfaceOverLimitPktLeaf")
+	if s.config != nil {
+		s.addSnmpData(leafOID(tcConfigLeaf), "string", "tcConfigLeaf")
+		s.addSnmpData(indexOID(tcConfigLeaf, configIntervalIndex), "integer", s.config.ParseInterval)
+		s.addSnmpData(indexOID(tcConfigLeaf, configIfacesIndex), "string", strings.Join(s.config.Ifaces, " "))
+		s.addSnmpData(indexOID(tcConfigLeaf, configUsersIndex), "integer", s.config.Users)
+		s.addSnmpData(indexOID(tcConfigLeaf, configFileIndex), "string", s.config.File)
+		s.addSnmpData(indexOID(tcConfigLeaf, configChecksumIndex), "string", s.config.Checksum)
+		modified := ""
+		if !s.config.Modified.IsZero() {
+			modified = s.config.Modified.Format(time.RFC3339)
+		}
+		s.addSnmpData(indexOID(tcConfigLeaf, configModifiedIndex), "string", modified)
+	}
+	if s.options.PktSizeBuckets != nil {
+		s.addSnmpData(leafOID(tcUserDownSmallPktLeaf), "string", "tcUserDownSmallPktLeaf")
+		s.addSnmpData(leafOID(tcUserDownMediumPktLeaf), "string", "tcUserDownMediumPktLeaf")
+		s.addSnmpData(leafOID(tcUserDownLargePktLeaf), "string", "tcUserDownLargePktLeaf")
+		s.addSnmpData(leafOID(tcUserUpSmallPktLeaf), "string", "tcUserUpSmallPktLeaf")
+		s.addSnmpData(leafOID(tcUserUpMediumPktLeaf), "string", "tcUserUpMediumPktLeaf")
+		s.addSnmpData(leafOID(tcUserUpLargePktLeaf), "string", "tcUserUpLargePktLeaf")
+	}
+}
+
+// addSnmpData updates the data stored for the OID in place, or adds it if it isn't stored yet.
+func (s *snmp) addSnmpData(oid, objectType string, objectValue interface{}) {
+	if len(s.hiddenLeaves) > 0 && s.hiddenLeaves[oidLeaf(oid)] {
+		return
+	}
+	s.oidCycle[oid] = s.cycle
+	if data, ok := s.oidData[oid]; ok {
+		data.objectType = objectType
+		data.objectValue = objectValue
+		return
+	}
+	s.oidData[oid] = &snmpData{
+		oid:         oid,
+		objectType:  objectType,
+		objectValue: objectValue,
+	}
+	s.newOIDs = append(s.newOIDs, oid)
+}
+
+// mergeNewOIDs sorts the OIDs added since the last commit() and merges them into the ordered oids, so that the
+// SNMP daemon does not bark at us ... Nothing is sorted if the set of OIDs didn't change.
+func (s *snmp) mergeNewOIDs() {
+	if len(s.newOIDs) == 0 {
+		return
+	}
+	sort.Slice(s.newOIDs, func(i, j int) bool {
+		return oidLess(s.newOIDs[i], s.newOIDs[j])
+	})
+	merged := make([]string, 0, len(s.oids)+len(s.newOIDs))
+	i, j := 0, 0
+	for i < len(s.oids) && j < len(s.newOIDs) {
+		if oidLess(s.newOIDs[j], s.oids[i]) {
+			merged = append(merged, s.newOIDs[j])
+			j++
+		} else {
+			merged = append(merged, s.oids[i])
+			i++
+		}
+	}
+	merged = append(merged, s.oids[i:]...)
+	merged = append(merged, s.newOIDs[j:]...)
+	s.oids = merged
+	s.newOIDs = s.newOIDs[:0]
+}
+
+// removeStaleOIDs removes the OIDs that weren't added in the current parse cycle, e.g. of a Class that was deleted.
+func (s *snmp) removeStaleOIDs() {
+	kept := s.oids[:0]
+	for _, oid := range s.oids {
+		if s.oidCycle[oid] == s.cycle {
+			kept = append(kept, oid)
+			continue
+		}
+		delete(s.oidData, oid)
+		delete(s.oidCycle, oid)
+	}
+	s.oids = kept
+}
+
+// leafOID returns the OID of a leaf under myOID.
+func leafOID(leaf int) string {
+	return myOID + "." + strconv.Itoa(leaf)
+}
+
+// nameOID returns the OID of a name in a leaf under myOID. The name is encoded as an SNMP string index, its length
+// followed by its bytes, e.g. "eth0" is encoded as 4.101.116.104.48. Returns false if the name is too long to encode.
+func nameOID(leaf int, name string) (string, bool) {
+	if len(name) > maxEncodedName {
+		return "", false
+	}
+	var oid strings.Builder
+	oid.WriteString(leafOID(leaf))
+	oid.WriteString("." + strconv.Itoa(len(name)))
+	for i := 0; i < len(name); i++ {
+		oid.WriteString("." + strconv.Itoa(int(name[i])))
+	}
+	return oid.String(), true
+}
+
+// oidLeaf returns the number of the leaf under myOID that the OID is in, zero for the OIDs outside of the leaves.
+func oidLeaf(oid string) int {
+	rest, ok := strings.CutPrefix(oid, myOID+".")
+	if !ok {
+		return 0
+	}
+	leaf, _, _ := strings.Cut(rest, ".")
+	number, err := strconv.Atoi(leaf)
+	if err != nil {
+		return 0
+	}
+	return number
+}
+
+// hiddenLeaves returns the leaves of the metrics that aren't among the exported ones, none if all the metrics are
+// exported.
+func hiddenLeaves(exported []string) map[int]bool {
+	if len(exported) == 0 {
+		return nil
+	}
+	hidden := make(map[int]bool)
+	for _, metric := range namedMetrics {
+		hidden[metric.leaf] = true
+	}
+	for _, name := range exported {
+		if metric, ok := namedMetrics[name]; ok {
+			delete(hidden, metric.leaf)
+		}
+	}
+	return hidden
+}
+
+// indexOID returns the OID of an index in a leaf under myOID.
+func indexOID(leaf, index int) string {
+	return myOID + "." + strconv.Itoa(leaf) + "." + strconv.Itoa(index)
+}
+
+// rebaseOID replaces the base OID from at the beginning of the OID with the base OID to. The OIDs outside of from are
+// returned unchanged.
+func rebaseOID(oid, from, to string) string {
+	if from == to {
+		return oid
+	}
+	if oid == from {
+		return to
+	}
+	if rest, ok := strings.CutPrefix(oid, from+"."); ok {
+		return to + "." + rest
+	}
+	return oid
+}
+
+// validOID returns whether the OID is numeric with a leading dot, e.g. ".1.3.6.1".
+func validOID(oid string) bool {
+	if oid == "" {
+		return false
+	}
+	for _, arc := range strings.Split(oid, ".")[1:] {
+		if _, err := strconv.ParseUint(arc, 10, 32); err != nil {
+			return false
+		}
+	}
+	return strings.HasPrefix(oid, ".")
+}
+
+// boolToInt converts a boolean to an integer that can be stored in the SNMP tree.
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// addGenericData stores the data from parsedData as data for generic Qdisc / Class.
+func (s *snmp) addGenericData(data *parsedData) {
+	tcIndex, ok := s.nameToIndex[data.name]
+	if !ok {
+		tcIndex, s.freeNameIndexes, s.tcLastNameIndex = takeIndex(s.freeNameIndexes, s.tcLastNameIndex)
+		s.nameToIndex[data.name] = tcIndex
+	}
+	if s.nameCycle[data.name] != s.cycle {
+		s.nameCycle[data.name] = s.cycle
+		// Populate tcIndexLeaf.
+		tcIndexOID := indexOID(tcIndexLeaf, tcIndex)
+		s.addSnmpData(tcIndexOID, "integer", tcIndex)
+
+		// Populate tcNameLeaf.
+		tcNameOID := indexOID(tcNameLeaf, tcIndex)
+		s.addSnmpData(tcNameOID, "string", data.name)
+
+		// Populate tcNumIndexLeaf.
+		s.addSnmpData(leafOID(tcNumIndexLeaf), "integer", s.tcLastNameIndex)
+
+		// Populate tcIfIndexLeaf.
+		tcIfIndexOID := indexOID(tcIfIndexLeaf, tcIndex)
+		s.addSnmpData(tcIfIndexOID, "integer", data.ifIndex)
+
+		// Populate tcNameToIndexLeaf.
+		if tcNameToIndexOID, ok := nameOID(tcNameToIndexLeaf, data.name); ok {
+			s.addSnmpData(tcNameToIndexOID, "integer", tcIndex)
+		}
+	}
+
+	// Populate tcMonotonicLeaf.
+	s.addMonotonicData(tcMonotonicLeaf, tcIndex, data)
+
+	// The counters are exported accumulated, so that they don't decrease when TC resets them.
+	current := data.sample()
+	total, ok := s.tcTotals.accumulate(data.name, current)
+	if !ok {
+		s.logIfDebug(fmt.Sprintf("addGenericData(): Counters of %s decreased, accumulating from the new values.", data.name))
+	}
+
+	// Populate sentBytesLeaf.
+	tcSentBytesOID := indexOID(sentBytesLeaf, tcIndex)
+	s.addSnmpData(tcSentBytesOID, "counter64", total.bytes)
+
+	// Populate sentPktLeaf.
+	tcSentPktOID := indexOID(sentPktLeaf, tcIndex)
+	s.addSnmpData(tcSentPktOID, "counter64", total.pkt)
+
+	// Populate droppedPktLeaf.
+	tcDroppedPktOID := indexOID(droppedPktLeaf, tcIndex)
+	s.addSnmpData(tcDroppedPktOID, "counter64", total.droppedPkt)
+
+	// Populate overLimitPktLeaf.
+	tcOverlimitPktOID := indexOID(overLimitPktLeaf, tcIndex)
+	s.addSnmpData(tcOverlimitPktOID, "counter64", total.overLimitPkt)
+
+	// Populate tcDeltaBytesLeaf and tcDeltaPktLeaf.
+	if s.options.ExportDeltas {
+		if delta, ok := s.tcDeltas.delta(data.name, total, s.cycle); ok {
+			s.addSnmpData(indexOID(tcDeltaBytesLeaf, tcIndex), "gauge", delta.bytes)
+			s.addSnmpData(indexOID(tcDeltaPktLeaf, tcIndex), "gauge", delta.pkt)
+		}
+	}
+
+	// Populate tcEfficiencyLeaf.
+	if delta, ok := s.tcCounters.delta(data.name, current); ok {
+		if efficiency, ok := efficiency(delta); ok {
+			tcEfficiencyOID := indexOID(tcEfficiencyLeaf, tcIndex)
+			s.addSnmpData(tcEfficiencyOID, "gauge", efficiency)
+		}
+		// Populate tcDropRateLeaf.
+		if dropRate, ok := dropRate(delta); ok {
+			tcDropRateOID := indexOID(tcDropRateLeaf, tcIndex)
+			s.addSnmpData(tcDropRateOID, "gauge", dropRate)
+			s.checkDropRate(data.name, tcIndex, dropRate)
+		}
+	}
 
-	// Do we have the requested OID?
-	if _, ok := s.oidData[oid]; !ok {
-		s.snmpTalker.putLine(emptyLine)
+	// Populate tcRate1mLeaf, tcRate5mLeaf, tcRate15mLeaf and tcPeakRateLeaf.
+	if s.options.RateSamples > 0 {
+		if s.tcRates == nil {
+			s.tcRates = make(map[string]*rateRing)
+		}
+		s.addRateData(s.tcRates, data.name, total.bytes, data.monotonic, tcIndex, [...]int{tcRate1mLeaf, tcRate5mLeaf, tcRate15mLeaf, tcPeakRateLeaf})
+	}
+}
+
+// addRateData adds the accumulated byte counter to the rateRing of the key and exports the moving average rates
+// and the peak rate into the leafs, the peak rate is the last one. Lock should be acquired by the caller.
+func (s *snmp) addRateData(rates map[string]*rateRing, key string, bytes int64, monotonic time.Duration, index int, leafs [numRateWindows + 1]int) {
+	ring, ok := rates[key]
+	if !ok {
+		ring = newRateRing(s.options.RateSamples)
+		rates[key] = ring
+	}
+	ring.add(bytes, monotonic)
+	ring.cycle = s.cycle
+	for i, window := range rateWindows {
+		if rate, ok := ring.average(window); ok {
+			s.addSnmpData(indexOID(leafs[i], index), "gauge", rate)
+		}
+	}
+	if ring.count > 1 {
+		s.addSnmpData(indexOID(leafs[numRateWindows], index), "gauge", ring.peak)
+	}
+}
+
+// pruneRates forgets the rateRings of the Qdiscs / Classes and users that weren't added in the current cycle.
+// Lock should be acquired by the caller.
+func (s *snmp) pruneRates() {
+	for _, rates := range []map[string]*rateRing{s.tcRates, s.userRates} {
+		for key, ring := range rates {
+			if ring.cycle != s.cycle {
+				delete(rates, key)
+			}
+		}
+	}
+}
+
+// pruneTrackers forgets the counters of the Qdiscs / Classes, users, groups and interfaces that weren't added since the
+// previous prune, e.g. the Classes of the users that logged out. The retained Qdiscs / Classes are added until their
+// retention expires. Nothing is pruned after a failed parse cycle, its data is missing rather than gone. Lock should be
+// acquired by the caller.
+func (s *snmp) pruneTrackers() {
+	if s.failures > 0 {
+		return
+	}
+	s.tcCounters.prune()
+	s.userCounters.prune()
+	s.tcTotals.prune()
+	s.userTotals.prune()
+	s.groupTotals.prune()
+	s.ifaceTotals.prune()
+	s.tcDeltas.prune()
+	s.userDeltas.prune()
+}
+
+// releaseIndexes releases the SNMP indexes of the Qdiscs / Classes and users that weren't added in the current cycle,
+// so that the indexes of the others don't shift when a name disappears. The retained Qdiscs / Classes are added until
+// their retention expires. Nothing is released in a failed parse cycle. Lock should be acquired by the caller.
+func (s *snmp) releaseIndexes() {
+	if s.failures > 0 {
 		return
 	}
+	lastName, lastUser := s.tcLastNameIndex, s.tcLastUserIndex
+	s.freeNameIndexes, s.tcLastNameIndex = releaseIndexes(s.nameToIndex, s.nameCycle, s.cycle, s.freeNameIndexes, s.tcLastNameIndex)
+	s.freeUserIndexes, s.tcLastUserIndex = releaseIndexes(s.userToIndex, s.userCycle, s.cycle, s.freeUserIndexes, s.tcLastUserIndex)
+	// The number of the indexes exported during the cycle shrinks with the released ones at the end.
+	if s.tcLastNameIndex != lastName && s.tcLastNameIndex > 0 {
+		s.addSnmpData(leafOID(tcNumIndexLeaf), "integer", s.tcLastNameIndex)
+	}
+	if s.tcLastUserIndex != lastUser && s.tcLastUserIndex > 0 {
+		s.addSnmpData(leafOID(tcUserNumIndexLeaf), "integer", s.tcLastUserIndex)
+	}
+}
 
-	var targetPosition int
-	for i, storedOID := range s.oids {
-		if oid == storedOID {
-			// snmpGetNext should get the next value after the requested OID.
-			targetPosition = i + 1
+// releaseIndexes removes the names that weren't added in the cycle and returns the free indexes with theirs added and
+// the highest index still in use.
+func releaseIndexes(indexes, cycles map[string]int, cycle int, free []int, last int) ([]int, int) {
+	for name, index := range indexes {
+		if cycles[name] != cycle {
+			delete(indexes, name)
+			delete(cycles, name)
+			free = append(free, index)
 		}
 	}
+	sort.Ints(free)
+	for len(free) > 0 && free[len(free)-1] == last {
+		free = free[:len(free)-1]
+		last--
+	}
+	return free, last
+}
+
+// takeIndex returns the index for a new name, the lowest of the free indexes or the one after the last index, with
+// the free and the last indexes updated.
+func takeIndex(free []int, last int) (int, []int, int) {
+	if len(free) > 0 {
+		return free[0], free[1:], last
+	}
+	return last + 1, free, last + 1
+}
 
-	// Do we have the next OID?
-	nextPosition := targetPosition + 1
-	if len(s.oids) >= nextPosition {
-		requestedOID := s.oids[targetPosition]
-		s.printData(s.oidData[requestedOID])
+// checkDropRate sends the tcDropRateTrap when the drop rate of the Qdisc / Class rises above the TrapDropRate.
+// The notification isn't repeated until the drop rate falls back to or below the TrapDropRate.
+func (s *snmp) checkDropRate(name string, tcIndex int, dropRate int64) {
+	if s.options.TrapDropRate <= 0 {
+		return
+	}
+	high := dropRate > int64(s.options.TrapDropRate)
+	if high && !s.dropRateHigh[name] {
+		// The variables are built from the computed values, the tcDropRateLeaf may be hidden by the ExportMetrics.
+		s.notify(tcDropRateTrap,
+			snmpData{indexOID(tcNameLeaf, tcIndex), "string", name},
+			snmpData{indexOID(tcDropRateLeaf, tcIndex), "gauge", dropRate},
+		)
+	}
+	if s.dropRateHigh == nil {
+		s.dropRateHigh = make(map[string]bool)
+	}
+	if high {
+		s.dropRateHigh[name] = true
+	} else {
+		delete(s.dropRateHigh, name)
+	}
+}
+
+// notify sends the notification with the variables if the notifications are configured.
+func (s *snmp) notify(trap int, variables ...snmpData) {
+	if s.notifier != nil {
+		s.notifier.notify(trap, variables)
+	}
+}
+
+// retain remembers the data of a Qdisc / Class seen in the TC output if the retention is configured and marks it as not stale.
+func (s *snmp) retain(data *parsedData) {
+	if s.options.retention() == 0 {
+		return
+	}
+	if s.retained == nil {
+		s.retained = make(map[string]*retainedData)
+	}
+	s.retained[data.name] = &retainedData{
+		data:  *data,
+		cycle: s.cycle,
+	}
+	s.addSnmpData(indexOID(tcStaleLeaf, s.nameToIndex[data.name]), "integer", 0)
+}
+
+// addRetainedData exports the last data of the Qdiscs / Classes that disappeared from the TC output in this cycle and
+// are still within the retention, these are marked as stale. The ones past the retention are forgotten.
+func (s *snmp) addRetainedData() {
+	var names []string
+	for name, retained := range s.retained {
+		if retained.cycle == s.cycle {
+			continue
+		}
+		if s.cycle-retained.cycle > s.options.retention() {
+			s.logIfDebug(fmt.Sprintf("addRetainedData(): %s disappeared %d cycles ago, dropping it.", name, s.cycle-retained.cycle))
+			delete(s.retained, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	// The retained Qdiscs / Classes keep their indexes, these are added in a stable order.
+	sort.Strings(names)
+	for _, name := range names {
+		s.addGenericData(&s.retained[name].data)
+		s.addSnmpData(indexOID(tcStaleLeaf, s.nameToIndex[name]), "integer", 1)
+		status := entryStale
+		if s.cycle-s.retained[name].cycle == s.options.retention() {
+			status = entryRemovePending
+		}
+		s.addSnmpData(indexOID(tcStatusLeaf, s.nameToIndex[name]), "integer", status)
+	}
+}
+
+// efficiency returns the percentage of sent packets out of the sent and over limit packets in the delta.
+// Returns false if there were neither sent nor over limit packets.
+func efficiency(delta sample) (int64, bool) {
+	events := delta.pkt + delta.overLimitPkt
+	if events == 0 {
+		return 0, false
+	}
+	return delta.pkt * 100 / events, true
+}
+
+// dropRate returns the percentage of dropped packets out of the sent and dropped packets in the delta.
+// Returns false if there were neither sent nor dropped packets.
+func dropRate(delta sample) (int64, bool) {
+	events := delta.pkt + delta.droppedPkt
+	if events == 0 {
+		return 0, false
+	}
+	return delta.droppedPkt * 100 / events, true
+}
+
+// userIndex returns the tcUserIndex of the user, a new index is assigned to the user if it doesn't have one yet.
+func (s *snmp) userIndex(name string) int {
+	tcUserIndex, ok := s.userToIndex[name]
+	if !ok {
+		tcUserIndex, s.freeUserIndexes, s.tcLastUserIndex = takeIndex(s.freeUserIndexes, s.tcLastUserIndex)
+		s.userToIndex[name] = tcUserIndex
+	}
+	if s.userCycle[name] == s.cycle {
+		return tcUserIndex
+	}
+	s.userCycle[name] = s.cycle
+
+	// Populate tcUserIndexLeaf.
+	tcUserIndexOID := indexOID(tcUserIndexLeaf, tcUserIndex)
+	s.addSnmpData(tcUserIndexOID, "integer", tcUserIndex)
+
+	// Populate tcUserNameLeaf.
+	tcUserNameOID := indexOID(tcUserNameLeaf, tcUserIndex)
+	s.addSnmpData(tcUserNameOID, "string", name)
+
+	// Export the number of user indexes.
+	s.addSnmpData(leafOID(tcUserNumIndexLeaf), "integer", s.tcLastUserIndex)
+
+	// Populate tcUserNameToIndexLeaf.
+	if tcUserNameToIndexOID, ok := nameOID(tcUserNameToIndexLeaf, name); ok {
+		s.addSnmpData(tcUserNameToIndexOID, "integer", tcUserIndex)
+	}
+	return tcUserIndex
+}
+
+// addUserData stores the data from parsedData as data for a configured user name.
+func (s *snmp) addUserData(data *parsedData) {
+	// Create new index for this user if we don't have it already.
+	tcUserIndex := s.userIndex(data.userClass.Name)
+	var tcUserBytesOID, tcUserPktOID, tcUserDroppedPktOID, tcUserOverLimitPktOID string
+	var tcUserAvgPktSizeLeaf, tcUserMonthBytesLeaf, tcUserDeltaBytesLeaf, tcUserDeltaPktLeaf int
+	var tcUserPktSizeLeafs [numPktBuckets]int
+	var tcUserRateLeafs [numRateWindows + 1]int
+	switch data.userClass.Direction {
+	case UploadDirection:
+		tcUserMonthBytesLeaf = tcUserUpMonthBytesLeaf
+		tcUserDeltaBytesLeaf, tcUserDeltaPktLeaf = tcUserUpDeltaBytesLeaf, tcUserUpDeltaPktLeaf
+		tcUserAvgPktSizeLeaf = tcUserUpAvgPktSizeLeaf
+		tcUserPktSizeLeafs = [numPktBuckets]int{tcUserUpSmallPktLeaf, tcUserUpMediumPktLeaf, tcUserUpLargePktLeaf}
+		tcUserRateLeafs = [...]int{tcUserUpRate1mLeaf, tcUserUpRate5mLeaf, tcUserUpRate15mLeaf, tcUserUpPeakRateLeaf}
+		tcUserBytesOID = indexOID(tcUserUpBytesLeaf, tcUserIndex)
+		tcUserPktOID = indexOID(tcUserUpPktLeaf, tcUserIndex)
+		tcUserDroppedPktOID = indexOID(tcUserUpDroppedPktLeaf, tcUserIndex)
+		tcUserOverLimitPktOID = indexOID(tcUserUpOverLimitPktLeaf, tcUserIndex)
+
+	case DownloadDirection:
+		tcUserMonthBytesLeaf = tcUserDownMonthBytesLeaf
+		tcUserDeltaBytesLeaf, tcUserDeltaPktLeaf = tcUserDownDeltaBytesLeaf, tcUserDownDeltaPktLeaf
+		tcUserAvgPktSizeLeaf = tcUserDownAvgPktSizeLeaf
+		tcUserPktSizeLeafs = [numPktBuckets]int{tcUserDownSmallPktLeaf, tcUserDownMediumPktLeaf, tcUserDownLargePktLeaf}
+		tcUserRateLeafs = [...]int{tcUserDownRate1mLeaf, tcUserDownRate5mLeaf, tcUserDownRate15mLeaf, tcUserDownPeakRateLeaf}
+		tcUserBytesOID = indexOID(tcUserDownBytesLeaf, tcUserIndex)
+		tcUserPktOID = indexOID(tcUserDownPktLeaf, tcUserIndex)
+		tcUserDroppedPktOID = indexOID(tcUserDownDroppedPktLeaf, tcUserIndex)
+		tcUserOverLimitPktOID = indexOID(tcUserDownOverLimitPktLeaf, tcUserIndex)
+	}
+	// Populate tcUserMonotonicLeaf.
+	s.addMonotonicData(tcUserMonotonicLeaf, tcUserIndex, data)
+
+	// The counters are exported accumulated, so that they don't decrease when TC resets them.
+	total, ok := s.userTotals.accumulate(data.userClass.key(), data.sample())
+	if !ok {
+		s.logIfDebug(fmt.Sprintf("addUserData(): Counters of user %s decreased, accumulating from the new values.", data.userClass.Name))
+	}
+
+	// Populate tcUser*BytesLeaf.
+	if tcUserBytesOID != "" {
+		s.addSnmpData(tcUserBytesOID, "counter64", total.bytes)
+	}
+
+	// Populate tcUser*PktLeaf.
+	if tcUserPktOID != "" {
+		s.addSnmpData(tcUserPktOID, "counter64", total.pkt)
+	}
+
+	// Populate tcUser*DroppedPktLeaf.
+	if tcUserDroppedPktOID != "" {
+		s.addSnmpData(tcUserDroppedPktOID, "counter64", total.droppedPkt)
+	}
+
+	// Populate tcUser*OverLimitPktLeaf.
+	if tcUserOverLimitPktOID != "" {
+		s.addSnmpData(tcUserOverLimitPktOID, "counter64", total.overLimitPkt)
+	}
+
+	// Populate tcUser*DeltaBytesLeaf and tcUser*DeltaPktLeaf.
+	if s.options.ExportDeltas && tcUserDeltaBytesLeaf != 0 {
+		if delta, ok := s.userDeltas.delta(data.userClass.key(), total, s.cycle); ok {
+			s.addSnmpData(indexOID(tcUserDeltaBytesLeaf, tcUserIndex), "gauge", delta.bytes)
+			s.addSnmpData(indexOID(tcUserDeltaPktLeaf, tcUserIndex), "gauge", delta.pkt)
+		}
+	}
+
+	// Populate tcUser*Rate*Leaf and tcUser*PeakRateLeaf.
+	if s.options.RateSamples > 0 && tcUserRateLeafs[0] != 0 {
+		if s.userRates == nil {
+			s.userRates = make(map[string]*rateRing)
+		}
+		s.addRateData(s.userRates, data.userClass.key(), total.bytes, data.monotonic, tcUserIndex, tcUserRateLeafs)
+	}
+
+	// Populate tcUser*MonthBytesLeaf.
+	if s.quota != nil && tcUserMonthBytesLeaf != 0 {
+		monthBytes := s.quota.add(data.userClass.key(), data.sentBytes, time.Now())
+		s.addSnmpData(indexOID(tcUserMonthBytesLeaf, tcUserIndex), "counter64", monthBytes)
+	}
+
+	// Populate tcUser*AvgPktSizeLeaf and tcUser*PktLeaf for the packet size buckets.
+	if tcUserAvgPktSizeLeaf != 0 {
+		s.addPktSizeData(data, tcUserIndex, tcUserAvgPktSizeLeaf, tcUserPktSizeLeafs)
+	}
+}
+
+// addPktSizeData computes the average packet size of the user during the last interval and exports it together with the packet size buckets.
+// Nothing is exported for the avgLeaf until there are two consecutive samples with some sent packets.
+func (s *snmp) addPktSizeData(data *parsedData, tcUserIndex, avgLeaf int, bucketLeafs [numPktBuckets]int) {
+	key := data.userClass.key()
+	delta, ok := s.userCounters.delta(key, data.sample())
+	if ok && delta.pkt > 0 {
+		avgPktSize := delta.bytes / delta.pkt
+		s.addSnmpData(indexOID(avgLeaf, tcUserIndex), "gauge", avgPktSize)
+		if s.options.PktSizeBuckets != nil {
+			s.countPktSize(key, avgPktSize)
+		}
+	}
+
+	if s.options.PktSizeBuckets == nil {
+		return
+	}
+	counts := s.pktSizeCounts[key]
+	if counts == nil {
+		counts = &[numPktBuckets]int64{}
+	}
+	for bucket, leaf := range bucketLeafs {
+		s.addSnmpData(indexOID(leaf, tcUserIndex), "counter64", counts[bucket])
+	}
+}
+
+// countPktSize increments the counter of the packet size bucket the avgPktSize falls into.
+func (s *snmp) countPktSize(key string, avgPktSize int64) {
+	if s.pktSizeCounts == nil {
+		s.pktSizeCounts = make(map[string]*[numPktBuckets]int64)
+	}
+	counts, ok := s.pktSizeCounts[key]
+	if !ok {
+		counts = &[numPktBuckets]int64{}
+		s.pktSizeCounts[key] = counts
+	}
+	switch {
+	case avgPktSize < int64(s.options.PktSizeBuckets[0]):
+		counts[smallPktBucket] += 1
+	case avgPktSize < int64(s.options.PktSizeBuckets[1]):
+		counts[mediumPktBucket] += 1
+	default:
+		counts[largePktBucket] += 1
+	}
+}
+
+// addGroupData stores the summed data of an interface group. Each group should be added only once per parse cycle.
+func (s *snmp) addGroupData(data *parsedData) {
+	// Populate tcGroupIndexLeaf.
+	s.tcLastGroupIndex += 1
+	tcGroupIndex := s.tcLastGroupIndex
+	tcGroupIndexOID := indexOID(tcGroupIndexLeaf, tcGroupIndex)
+	s.addSnmpData(tcGroupIndexOID, "integer", tcGroupIndex)
+
+	// Populate tcGroupNameLeaf.
+	tcGroupNameOID := indexOID(tcGroupNameLeaf, tcGroupIndex)
+	s.addSnmpData(tcGroupNameOID, "string", data.name)
+
+	// Export the number of group indexes.
+	s.addSnmpData(leafOID(tcGroupNumIndexLeaf), "integer", s.tcLastGroupIndex)
+
+	// Populate tcGroupMonotonicLeaf.
+	s.addMonotonicData(tcGroupMonotonicLeaf, tcGroupIndex, data)
+
+	// The counters are exported accumulated, so that they don't decrease when TC resets them.
+	total, ok := s.groupTotals.accumulate(data.name, data.sample())
+	if !ok {
+		s.logIfDebug(fmt.Sprintf("addGroupData(): Counters of group %s decreased, accumulating from the new values.", data.name))
+	}
+
+	// Populate tcGroupBytesLeaf.
+	tcGroupBytesOID := indexOID(tcGroupBytesLeaf, tcGroupIndex)
+	s.addSnmpData(tcGroupBytesOID, "counter64", total.bytes)
+
+	// Populate tcGroupPktLeaf.
+	tcGroupPktOID := indexOID(tcGroupPktLeaf, tcGroupIndex)
+	s.addSnmpData(tcGroupPktOID, "counter64", total.pkt)
+
+	// Populate tcGroupDroppedPktLeaf.
+	tcGroupDroppedPktOID := indexOID(tcGroupDroppedPktLeaf, tcGroupIndex)
+	s.addSnmpData(tcGroupDroppedPktOID, "counter64", total.droppedPkt)
+
+	// Populate tcGroupOverLimitPktLeaf.
+	tcGroupOverLimitPktOID := indexOID(tcGroupOverLimitPktLeaf, tcGroupIndex)
+	s.addSnmpData(tcGroupOverLimitPktOID, "counter64", total.overLimitPkt)
+}
+
+// addIfaceData stores the summed data of an interface. Each interface should be added only once per parse cycle.
+func (s *snmp) addIfaceData(data *parsedData) {
+	// Populate tcIfaceIndexLeaf.
+	s.tcLastIfaceIndex += 1
+	tcIfaceIndex := s.tcLastIfaceIndex
+	tcIfaceIndexOID := indexOID(tcIfaceIndexLeaf, tcIfaceIndex)
+	s.addSnmpData(tcIfaceIndexOID, "integer", tcIfaceIndex)
+
+	// Populate tcIfaceNameLeaf.
+	tcIfaceNameOID := indexOID(tcIfaceNameLeaf, tcIfaceIndex)
+	s.addSnmpData(tcIfaceNameOID, "string", data.name)
+
+	// Export the number of interface indexes.
+	s.addSnmpData(leafOID(tcIfaceNumIndexLeaf), "integer", s.tcLastIfaceIndex)
+
+	// Populate tcIfaceMonotonicLeaf.
+	s.addMonotonicData(tcIfaceMonotonicLeaf, tcIfaceIndex, data)
+
+	// The counters are exported accumulated, so that they don't decrease when TC resets them.
+	total, ok := s.ifaceTotals.accumulate(data.name, data.sample())
+	if !ok {
+		s.logIfDebug(fmt.Sprintf("addIfaceData(): Counters of interface %s decreased, accumulating from the new values.", data.name))
+	}
+
+	// Populate tcIfaceBytesLeaf.
+	tcIfaceBytesOID := indexOID(tcIfaceBytesLeaf, tcIfaceIndex)
+	s.addSnmpData(tcIfaceBytesOID, "counter64", total.bytes)
+
+	// Populate tcIfacePktLeaf.
+	tcIfacePktOID := indexOID(tcIfacePktLeaf, tcIfaceIndex)
+	s.addSnmpData(tcIfacePktOID, "counter64", total.pkt)
+
+	// Populate tcIfaceDroppedPktLeaf.
+	tcIfaceDroppedPktOID := indexOID(tcIfaceDroppedPktLeaf, tcIfaceIndex)
+	s.addSnmpData(tcIfaceDroppedPktOID, "counter64", total.droppedPkt)
+
+	// Populate tcIfaceOverLimitPktLeaf.
+	tcIfaceOverLimitPktOID := indexOID(tcIfaceOverLimitPktLeaf, tcIfaceIndex)
+	s.addSnmpData(tcIfaceOverLimitPktOID, "counter64", total.overLimitPkt)
+}
+
+// addNetDevData stores the kernel counters of an interface indexed by its kernel ifIndex. The counters are exported as
+// read, the kernel doesn't reset them while the interface exists. Lock should be acquired by the caller.
+func (s *snmp) addNetDevData(stats *netDevStats) {
+	s.addSnmpData(leafOID(tcNetDevIndexLeaf), "string", "tcNetDevIndexLeaf")
+	s.addSnmpData(leafOID(tcNetDevNameLeaf), "string", "tcNetDevNameLeaf")
+	s.addSnmpData(leafOID(tcNetDevRxBytesLeaf), "string", "tcNetDevRxBytesLeaf")
+	s.addSnmpData(leafOID(tcNetDevRxPktLeaf), "string", "tcNetDevRxPktLeaf")
+	s.addSnmpData(leafOID(tcNetDevRxErrorsLeaf), "string", "tcNetDevRxErrorsLeaf")
+	s.addSnmpData(leafOID(tcNetDevRxDroppedLeaf), "string", "tcNetDevRxDroppedLeaf")
+	s.addSnmpData(leafOID(tcNetDevTxBytesLeaf), "string", "tcNetDevTxBytesLeaf")
+	s.addSnmpData(leafOID(tcNetDevTxPktLeaf), "string", "tcNetDevTxPktLeaf")
+	s.addSnmpData(leafOID(tcNetDevTxErrorsLeaf), "string", "tcNetDevTxErrorsLeaf")
+	s.addSnmpData(leafOID(tcNetDevTxDroppedLeaf), "string", "tcNetDevTxDroppedLeaf")
+
+	index := stats.ifIndex
+	s.addSnmpData(indexOID(tcNetDevIndexLeaf, index), "integer", index)
+	s.addSnmpData(indexOID(tcNetDevNameLeaf, index), "string", stats.iface)
+	s.addSnmpData(indexOID(tcNetDevRxBytesLeaf, index), "counter64", stats.rxBytes)
+	s.addSnmpData(indexOID(tcNetDevRxPktLeaf, index), "counter64", stats.rxPkt)
+	s.addSnmpData(indexOID(tcNetDevRxErrorsLeaf, index), "counter64", stats.rxErrors)
+	s.addSnmpData(indexOID(tcNetDevRxDroppedLeaf, index), "counter64", stats.rxDropped)
+	s.addSnmpData(indexOID(tcNetDevTxBytesLeaf, index), "counter64", stats.txBytes)
+	s.addSnmpData(indexOID(tcNetDevTxPktLeaf, index), "counter64", stats.txPkt)
+	s.addSnmpData(indexOID(tcNetDevTxErrorsLeaf, index), "counter64", stats.txErrors)
+	s.addSnmpData(indexOID(tcNetDevTxDroppedLeaf, index), "counter64", stats.txDropped)
+	if stats.linkStats {
+		s.addSnmpData(leafOID(tcNetDevRxMissedLeaf), "string", "tcNetDevRxMissedLeaf")
+		s.addSnmpData(leafOID(tcNetDevCarrierChangesLeaf), "string", "tcNetDevCarrierChangesLeaf")
+		s.addSnmpData(indexOID(tcNetDevRxMissedLeaf, index), "counter64", stats.rxMissed)
+		s.addSnmpData(indexOID(tcNetDevCarrierChangesLeaf, index), "counter64", stats.carrierChanges)
+	}
+}
+
+// addConntrackData stores the bytes of an user counted by conntrack next to the counters of its Qdiscs / Classes, the
+// user gets an index if it has none. The counters are exported as summed by the tcParser, these don't decrease while
+// tc_reader runs. Lock should be acquired by the caller.
+func (s *snmp) addConntrackData(data *parsedData) {
+	if !s.options.exportUsers() {
+		return
+	}
+	tcUserIndex := s.userIndex(data.userClass.Name)
+	s.addSnmpData(indexOID(tcUserStatusLeaf, tcUserIndex), "integer", entryActive)
+
+	// Populate tcUser*ConntrackBytesLeaf.
+	leaf := tcUserUpConntrackBytesLeaf
+	if data.userClass.Direction == DownloadDirection {
+		leaf = tcUserDownConntrackBytesLeaf
+	}
+	s.addSnmpData(indexOID(leaf, tcUserIndex), "counter64", data.sentBytes)
+}
+
+// addXstats stores the xstats of a Qdisc / Class. The known xstats are stored in their own leaves,
+// all the others are stored in the generic table of names and values.
+func (s *snmp) addXstats(name string, xstats []xstat) {
+	tcIndex, ok := s.nameToIndex[name]
+	if !ok {
+		s.logIfDebug(fmt.Sprintf("addXstats(): Ignoring xstats of %s, it has no tcIndex.", name))
+		return
+	}
+	for _, x := range xstats {
+		if leaf, ok := knownXstats[x.name]; ok {
+			if value, err := strconv.ParseInt(x.value, 10, 64); err == nil && value >= 0 {
+				s.addSnmpData(indexOID(leaf, tcIndex), "counter64", value)
+				continue
+			}
+		}
+
+		// Populate tcXstatIndexLeaf.
+		s.tcLastXstatIndex += 1
+		tcXstatIndex := s.tcLastXstatIndex
+		s.addSnmpData(indexOID(tcXstatIndexLeaf, tcXstatIndex), "integer", tcXstatIndex)
+
+		// Populate tcXstatTcIndexLeaf, tcXstatNameLeaf and tcXstatValueLeaf.
+		s.addSnmpData(indexOID(tcXstatTcIndexLeaf, tcXstatIndex), "integer", tcIndex)
+		s.addSnmpData(indexOID(tcXstatNameLeaf, tcXstatIndex), "string", x.name)
+		s.addSnmpData(indexOID(tcXstatValueLeaf, tcXstatIndex), "string", x.value)
+
+		// Export the number of xstat indexes.
+		s.addSnmpData(leafOID(tcXstatNumIndexLeaf), "integer", s.tcLastXstatIndex)
+	}
+}
+
+// addWarning records a non-fatal parse warning, only the most recent maxWarnings are kept.
+func (s *snmp) addWarning(message string) {
+	s.logIfDebug(fmt.Sprintf("addWarning(): %s", message))
+	s.numWarnings += 1
+	s.warnings = append(s.warnings, message)
+	if excess := len(s.warnings) - s.options.maxWarnings(); excess > 0 {
+		s.warnings = s.warnings[excess:]
+	}
+}
+
+// addWarningData populates tcWarningLeaf and tcNumWarningsLeaf with the recorded warnings.
+func (s *snmp) addWarningData() {
+	for i, warning := range s.warnings {
+		tcWarningOID := indexOID(tcWarningLeaf, i+1)
+		s.addSnmpData(tcWarningOID, "string", warning)
+	}
+	s.addSnmpData(leafOID(tcNumWarningsLeaf), "counter64", s.numWarnings)
+}
+
+// addRuntimeData populates tcRuntimeLeaf with the statistics of the Go runtime and the resource usage of tc_reader if
+// RuntimeStats is set. These are updated in every parse cycle, including the skipped ones. Lock should be acquired by the caller.
+func (s *snmp) addRuntimeData() {
+	if !s.options.RuntimeStats {
+		return
+	}
+	stats := readRuntimeStats()
+	s.addSnmpData(leafOID(tcRuntimeLeaf), "string", "tcRuntimeLeaf")
+	s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeHeapAllocIndex), "gauge", int64(stats.HeapAlloc))
+	s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeHeapSysIndex), "gauge", int64(stats.HeapSys))
+	s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeHeapObjectsIndex), "gauge", int64(stats.HeapObjects))
+	s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeSysIndex), "gauge", int64(stats.Sys))
+	s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeGoroutinesIndex), "gauge", int64(stats.Goroutines))
+	s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeNumGCIndex), "counter64", int64(stats.NumGC))
+	s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeLastPauseIndex), "gauge", int64(stats.LastPause))
+	if stats.Rss > 0 {
+		s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeRssIndex), "gauge", stats.Rss)
+		s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeCpuTimeIndex), "counter64", stats.CpuTime)
+	}
+	s.addSnmpData(indexOID(tcRuntimeLeaf, runtimeUptimeIndex), "timeticks", processStart)
+}
+
+// setMaintenance marks the stored data as being in maintenance. Lock should be acquired by the caller.
+// The samples from before the maintenance are forgotten, so that e.g. a reload of the shaper doesn't produce bogus per-interval values.
+func (s *snmp) setMaintenance(active bool) {
+	if active && !s.maintenance {
+		s.logIfDebug("setMaintenance(): Entering maintenance.")
+		s.tcCounters = counterTracker{}
+		s.userCounters = counterTracker{}
+	}
+	s.maintenance = active
+	s.addSnmpData(leafOID(tcMaintenanceLeaf), "integer", boolToInt(active))
+}
+
+// setHealth records the number of consecutive failed parse cycles and the current backoff in seconds.
+// The tcParseFailureTrap is sent when TC starts failing. Lock should be acquired by the caller.
+func (s *snmp) setHealth(failures int, backoff int) {
+	startedFailing := failures > 0 && s.failures == 0
+	s.failures = failures
+	s.backoff = backoff
+	// Nothing is stored before the first parse cycle, erase() adds the leaves with the recorded health.
+	if s.oidData != nil {
+		s.addSnmpData(leafOID(tcFailuresLeaf), "integer", failures)
+		s.addSnmpData(leafOID(tcBackoffLeaf), "integer", backoff)
+	}
+	if startedFailing {
+		// The variables are built from the computed values, the leaves may not be stored yet.
+		s.notify(tcParseFailureTrap,
+			snmpData{leafOID(tcFailuresLeaf), "integer", failures},
+			snmpData{leafOID(tcBackoffLeaf), "integer", backoff},
+		)
+	}
+}
+
+// setUnmatchedLines records the number of lines of the TC output that matched no pattern in this parse cycle.
+func (s *snmp) setUnmatchedLines(lines int) {
+	s.unmatchedLines = lines
+	s.addSnmpData(leafOID(tcUnmatchedLinesLeaf), "gauge", int64(lines))
+}
+
+// addData stores the content of parsedData so it can be served to the SNMP daemon. The Qdiscs / Classes or the users
+// aren't stored if their export is disabled.
+func (s *snmp) addData(data *parsedData) {
+	switch {
+	case data.userClass == nil && !s.options.exportGeneric():
+		return
+	case data.userClass != nil && !s.options.exportUsers():
+		return
+	}
+
+	switch data.userClass {
+	// The data holds information about a generic Qdisc / Class.
+	case nil:
+		s.addGenericData(data)
+		s.addSnmpData(indexOID(tcStatusLeaf, s.nameToIndex[data.name]), "integer", entryActive)
+		s.retain(data)
+
+	// The data holds information about a configured user.
+	default:
+		s.addUserData(data)
+		s.addSnmpData(indexOID(tcUserStatusLeaf, s.userToIndex[data.userClass.Name]), "integer", entryActive)
+	}
+}
+
+// snmpGet performs a SNMP get for the SNMP daemon.
+func (s *snmp) snmpGet(oid string) {
+	if data, ok := s.get(oid); ok {
+		s.printData(data)
+	} else {
+		s.snmpTalker.putLine(emptyLine)
+	}
+}
+
+// get returns the data of the requested OID under the base OID, false if it isn't stored.
+func (s *snmp) get(oid string) (*snmpData, bool) {
+	oid, ok := s.internalOID(oid)
+	if !ok {
+		return nil, false
+	}
+	current := s.snapshot()
+	if position, ok := current.find(oid); ok {
+		return &current.data[position], true
+	}
+	return nil, false
+}
+
+// snmpGet performs a SNMP walk for the SNMP daemon.
+func (s *snmp) snmpGetNext(oid string) {
+	if data, ok := s.next(oid); ok {
+		s.printData(data)
 	} else {
 		s.snmpTalker.putLine(emptyLine)
 	}
 }
 
+// next returns the data following the requested OID under the base OID, false if the requested OID isn't stored or
+// if it is the last one.
+func (s *snmp) next(oid string) (*snmpData, bool) {
+	oid, ok := s.internalOID(oid)
+	if !ok {
+		return nil, false
+	}
+	current := s.snapshot()
+
+	// Do we have the requested OID?
+	position, ok := current.find(oid)
+	if !ok {
+		return nil, false
+	}
+
+	// snmpGetNext should get the next value after the requested OID.
+	targetPosition := position + 1
+
+	// Do we have the next OID?
+	if targetPosition < len(current.data) {
+		return &current.data[targetPosition], true
+	}
+	return nil, false
+}
+
+// internalOID returns the OID under myOID that stores the requested OID under the base OID, false if the requested OID
+// isn't under the base OID.
+func (s *snmp) internalOID(oid string) (string, bool) {
+	base := s.options.baseOID()
+	if oid != base && !strings.HasPrefix(oid, base+".") {
+		return "", false
+	}
+	return rebaseOID(oid, base, myOID), true
+}
+
 // printData prints out data for a single OID in format understandable by the SNMP daemon.
 func (s *snmp) printData(data *snmpData) {
-	s.snmpTalker.putLine(data.oid)
-	s.snmpTalker.putLine(data.objectType)
+	oid, objectType, value := s.formatData(data)
+	s.snmpTalker.putLine(oid)
+	s.snmpTalker.putLine(objectType)
+	s.snmpTalker.putLine(value)
+}
+
+// formatData returns the OID under the base OID, the object type and the value of the data as printed for the SNMP
+// daemon. The value is empty if it doesn't match the object type.
+func (s *snmp) formatData(data *snmpData) (string, string, string) {
+	return formatSnmpData(data, s.options.baseOID())
+}
 
+// formatSnmpData returns the OID under the baseOID, the object type and the value of the data stored under myOID as
+// printed for the SNMP daemon. The value is empty if it doesn't match the object type.
+func formatSnmpData(data *snmpData, baseOID string) (string, string, string) {
+	oid := rebaseOID(data.oid, myOID, baseOID)
+	value := emptyLine
 	switch objectType := data.objectType; objectType {
 	case "string":
-		if value, ok := data.objectValue.(string); !ok {
-			s.snmpTalker.putLine(emptyLine)
-		} else {
-			s.snmpTalker.putLine(value)
+		if v, ok := data.objectValue.(string); ok {
+			value = v
 		}
-	case "counter64":
-		if value, ok := data.objectValue.(int64); !ok {
-			s.snmpTalker.putLine(emptyLine)
-		} else {
-			s.snmpTalker.putLine(strconv.FormatInt(value, 10))
+	case "counter64", "gauge":
+		if v, ok := data.objectValue.(int64); ok {
+			value = strconv.FormatInt(v, 10)
 		}
 	case "integer":
-		if value, ok := data.objectValue.(int); !ok {
-			s.snmpTalker.putLine(emptyLine)
-		} else {
-			s.snmpTalker.putLine(strconv.FormatInt(int64(value), 10))
+		if v, ok := data.objectValue.(int); ok {
+			value = strconv.FormatInt(int64(v), 10)
+		}
+	case "timeticks":
+		// The timeticks are the hundredths of a second elapsed since the stored time at the time of the request.
+		if v, ok := data.objectValue.(time.Time); ok {
+			value = strconv.FormatInt(int64(time.Since(v)/(10*time.Millisecond)), 10)
 		}
-	default:
-		s.snmpTalker.putLine(emptyLine)
 	}
+	return oid, data.objectType, value
 }
 
 // Start starts listening to commands from the SNMP daemon and performing the necessary actions.
@@ -518,25 +2628,60 @@ func (s *snmp) Listen() {
 		case getCommand:
 			oid := s.snmpTalker.getLine()
 			s.logIfDebug(fmt.Sprintf("Listen(): processing SNMP GET for oid %s", oid))
+			s.requested()
+			s.refreshIfStale()
 			s.snmpGet(oid)
 
 		case getNextCommand:
 			oid := s.snmpTalker.getLine()
 			s.logIfDebug(fmt.Sprintf("Listen(): processing SNMP GET-NEXT for oid %s", oid))
+			s.requested()
+			s.refreshIfStale()
 			s.snmpGetNext(oid)
 
 		default:
-			s.logger.Info(fmt.Sprintf("Listen(): got an unexpected command %s", command))
+			logWarning(s.logger, fmt.Sprintf("Listen(): got an unexpected command %s", command))
 			s.snmpTalker.putLine(emptyLine)
 		}
 
 	}
 }
 
-// sortOIDs sorts the SNMP OIDs.
-func (s *snmp) sortOIDs() {
-	sorter := &oidSorter{
-		oids: &s.oids,
+// Close saves the quota totals, sends the queued notifications and metrics and stops the servers. Returns the errors
+// of the ones that didn't finish before the context is done. The stored data must not be updated afterwards.
+func (s *snmp) Close(ctx context.Context) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.saveQuota()
+	var errs []error
+	if s.notifier != nil {
+		if err := s.notifier.close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("unable to send the notifications: %s", err))
+		}
+		s.notifier = nil
+	}
+	for _, sink := range s.sinks {
+		if err := sink.close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("unable to close a sink: %s", err))
+		}
+	}
+	s.sinks = nil
+	return errors.Join(errs...)
+}
+
+// requested tells the refresher about the SNMP request, so that the collection paused for the lack of requests
+// resumes.
+func (s *snmp) requested() {
+	if s.refresher != nil {
+		s.refresher.requested()
+	}
+}
+
+// refreshIfStale asks the refresher to re-parse the data if it is older than maxDataAge.
+// The lock must not be held by the caller, the refresher acquires it while adding the data.
+func (s *snmp) refreshIfStale() {
+	if s.refresher == nil || s.options.maxDataAge() == 0 {
+		return
 	}
-	sort.Sort(sorter)
+	s.refresher.refresh(s.options.maxDataAge())
 }
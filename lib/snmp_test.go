@@ -17,11 +17,16 @@ limitations under the License.
 package lib
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kylelemons/godebug/pretty"
 )
@@ -47,6 +52,7 @@ func TestSnmpLogIfDebug(t *testing.T) {
 			logger:  fs,
 			options: o,
 		}
+		s.debug.configure(o.Debug)
 		s.logIfDebug(params.message)
 		if !reflect.DeepEqual(fs.info, params.expInfo) {
 			t.Errorf("TestSnmpLogIfDebug(testCase %d) got: '%v' want: '%v'", i, fs.info, params.expInfo)
@@ -54,6 +60,59 @@ func TestSnmpLogIfDebug(t *testing.T) {
 	}
 }
 
+func TestSnmpOptionsIdentity(t *testing.T) {
+	testData := []struct {
+		in  string
+		out string
+	}{
+		{"", myName},
+		{"tc_reader on {hostname}", "tc_reader on {hostname}"},
+	}
+
+	var o *SnmpOptions
+	for i, params := range testData {
+		o = &SnmpOptions{
+			Identity: params.in,
+		}
+		if !reflect.DeepEqual(o.identity(), params.out) {
+			t.Errorf("TestSnmpOptionsIdentity(testCase %d) got: '%v' want: '%v'", i, o.identity(), params.out)
+		}
+	}
+}
+
+func TestExpandIdentity(t *testing.T) {
+	testData := []struct {
+		desc     string
+		template string
+		want     string
+	}{
+		{
+			desc:     "no placeholders",
+			template: myName,
+			want:     myName,
+		},
+		{
+			desc:     "all placeholders",
+			template: "tc_reader {version} on {hostname}/{instance} [{labels}]",
+			want:     "tc_reader 1.2.3 on router1/tenant1 [site=ams]",
+		},
+		{
+			desc:     "repeated placeholders",
+			template: "{hostname}/{hostname}",
+			want:     "router1/router1",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := expandIdentity(tc.template, "router1", "1.2.3", "site=ams", "tenant1")
+			if got != tc.want {
+				t.Errorf("expandIdentity(%q) => got: %q, want: %q", tc.template, got, tc.want)
+			}
+		})
+	}
+}
+
 // compareSnmpData the received a map of OIDs to snmpData to the expected one, dereferencing pointers and making the output human readable.
 func compareSnmpData(received map[string]*snmpData, expected map[string]snmpData) error {
 	for k, v := range received {
@@ -77,6 +136,70 @@ func compareSnmpData(received map[string]*snmpData, expected map[string]snmpData
 	return nil
 }
 
+func TestNewSnmp(t *testing.T) {
+	testData := []struct {
+		desc    string
+		logger  Logger
+		opts    []SnmpOption
+		wantErr string
+	}{
+		{
+			desc:   "default options",
+			logger: &fakeSyslog{},
+		},
+		{
+			desc:   "identity",
+			logger: &fakeSyslog{},
+			opts:   []SnmpOption{WithSnmpOptions(&SnmpOptions{Identity: "router"})},
+		},
+		{
+			desc:    "no logger",
+			wantErr: "a Logger is required",
+		},
+		{
+			desc:    "nil options",
+			logger:  &fakeSyslog{},
+			opts:    []SnmpOption{WithSnmpOptions(nil)},
+			wantErr: "the SnmpOptions must not be nil",
+		},
+		{
+			desc:   "base OID",
+			logger: &fakeSyslog{},
+			opts:   []SnmpOption{WithSnmpOptions(&SnmpOptions{BaseOID: ".1.3.6.1.4.1.99999.1"})},
+		},
+		{
+			desc:    "invalid base OID",
+			logger:  &fakeSyslog{},
+			opts:    []SnmpOption{WithSnmpOptions(&SnmpOptions{BaseOID: "1.3.6.1.4.1.99999.1"})},
+			wantErr: "the base OID must be a numeric OID with a leading dot",
+		},
+		{
+			desc:    "invalid HTTP listen address",
+			logger:  &fakeSyslog{},
+			opts:    []SnmpOption{WithSnmpOptions(&SnmpOptions{Http: &HttpOptions{Listen: "localhost:-1"}})},
+			wantErr: "unable to start the HTTP server on localhost:-1",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			s, err := NewSnmp(tc.logger, tc.opts...)
+			if tc.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.wantErr) {
+					t.Fatalf("NewSnmp => got error: %v, want prefix: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewSnmp => unexpected error: %s", err)
+			}
+			if s.current.Load() == nil {
+				t.Errorf("NewSnmp => expected an initial snapshot to be published")
+			}
+		})
+	}
+}
+
 func TestSnmpAddData(t *testing.T) {
 	// These common OIDs are present in every test case.
 	var commonOIDs map[string]snmpData = map[string]snmpData{
@@ -97,6 +220,39 @@ func TestSnmpAddData(t *testing.T) {
 		".1.3.6.1.4.1.2021.255.16": {".1.3.6.1.4.1.2021.255.16", "string", "tcUserUpPktLeaf"},
 		".1.3.6.1.4.1.2021.255.17": {".1.3.6.1.4.1.2021.255.17", "string", "tcUserUpDroppedPktLeaf"},
 		".1.3.6.1.4.1.2021.255.18": {".1.3.6.1.4.1.2021.255.18", "string", "tcUserUpOverLimitPktLeaf"},
+		".1.3.6.1.4.1.2021.255.19": {".1.3.6.1.4.1.2021.255.19", "string", "tcIfIndexLeaf"},
+		".1.3.6.1.4.1.2021.255.20": {".1.3.6.1.4.1.2021.255.20", "string", "tcWarningLeaf"},
+		".1.3.6.1.4.1.2021.255.21": {".1.3.6.1.4.1.2021.255.21", "counter64", int64(0)},
+		".1.3.6.1.4.1.2021.255.22": {".1.3.6.1.4.1.2021.255.22", "string", "tcUserDownAvgPktSizeLeaf"},
+		".1.3.6.1.4.1.2021.255.23": {".1.3.6.1.4.1.2021.255.23", "string", "tcUserUpAvgPktSizeLeaf"},
+		".1.3.6.1.4.1.2021.255.30": {".1.3.6.1.4.1.2021.255.30", "string", "tcGroupIndexLeaf"},
+		".1.3.6.1.4.1.2021.255.32": {".1.3.6.1.4.1.2021.255.32", "string", "tcGroupNameLeaf"},
+		".1.3.6.1.4.1.2021.255.33": {".1.3.6.1.4.1.2021.255.33", "string", "tcGroupBytesLeaf"},
+		".1.3.6.1.4.1.2021.255.34": {".1.3.6.1.4.1.2021.255.34", "string", "tcGroupPktLeaf"},
+		".1.3.6.1.4.1.2021.255.35": {".1.3.6.1.4.1.2021.255.35", "string", "tcGroupDroppedPktLeaf"},
+		".1.3.6.1.4.1.2021.255.36": {".1.3.6.1.4.1.2021.255.36", "string", "tcGroupOverLimitPktLeaf"},
+		".1.3.6.1.4.1.2021.255.37": {".1.3.6.1.4.1.2021.255.37", "string", "tcEfficiencyLeaf"},
+		".1.3.6.1.4.1.2021.255.38": {".1.3.6.1.4.1.2021.255.38", "integer", 0},
+		".1.3.6.1.4.1.2021.255.39": {".1.3.6.1.4.1.2021.255.39", "string", "tcXstatIndexLeaf"},
+		".1.3.6.1.4.1.2021.255.41": {".1.3.6.1.4.1.2021.255.41", "string", "tcXstatTcIndexLeaf"},
+		".1.3.6.1.4.1.2021.255.42": {".1.3.6.1.4.1.2021.255.42", "string", "tcXstatNameLeaf"},
+		".1.3.6.1.4.1.2021.255.43": {".1.3.6.1.4.1.2021.255.43", "string", "tcXstatValueLeaf"},
+		".1.3.6.1.4.1.2021.255.44": {".1.3.6.1.4.1.2021.255.44", "string", "tcDropOverlimitLeaf"},
+		".1.3.6.1.4.1.2021.255.45": {".1.3.6.1.4.1.2021.255.45", "string", "tcEcnMarkLeaf"},
+		".1.3.6.1.4.1.2021.255.46": {".1.3.6.1.4.1.2021.255.46", "string", "tcNewFlowCountLeaf"},
+		".1.3.6.1.4.1.2021.255.47": {".1.3.6.1.4.1.2021.255.47", "string", "tcLendedLeaf"},
+		".1.3.6.1.4.1.2021.255.48": {".1.3.6.1.4.1.2021.255.48", "string", "tcBorrowedLeaf"},
+		".1.3.6.1.4.1.2021.255.49": {".1.3.6.1.4.1.2021.255.49", "string", "tcGiantsLeaf"},
+		".1.3.6.1.4.1.2021.255.50": {".1.3.6.1.4.1.2021.255.50", "integer", 0},
+		".1.3.6.1.4.1.2021.255.51": {".1.3.6.1.4.1.2021.255.51", "integer", 0},
+		".1.3.6.1.4.1.2021.255.70": {".1.3.6.1.4.1.2021.255.70", "string", "unknown"},
+		".1.3.6.1.4.1.2021.255.74": {".1.3.6.1.4.1.2021.255.74", "string", "tcIfaceIndexLeaf"},
+		".1.3.6.1.4.1.2021.255.76": {".1.3.6.1.4.1.2021.255.76", "string", "tcIfaceNameLeaf"},
+		".1.3.6.1.4.1.2021.255.77": {".1.3.6.1.4.1.2021.255.77", "string", "tcIfaceBytesLeaf"},
+		".1.3.6.1.4.1.2021.255.78": {".1.3.6.1.4.1.2021.255.78", "string", "tcIfacePktLeaf"},
+		".1.3.6.1.4.1.2021.255.79": {".1.3.6.1.4.1.2021.255.79", "string", "tcIfaceDroppedPktLeaf"},
+		".1.3.6.1.4.1.2021.255.80": {".1.3.6.1.4.1.2021.255.80", "string", "tcIfaceOverLimitPktLeaf"},
+		".1.3.6.1.4.1.2021.255.83": {".1.3.6.1.4.1.2021.255.83", "gauge", int64(0)},
 	}
 
 	testData := []struct {
@@ -131,6 +287,39 @@ func TestSnmpAddData(t *testing.T) {
 				".1.3.6.1.4.1.2021.255.16",
 				".1.3.6.1.4.1.2021.255.17",
 				".1.3.6.1.4.1.2021.255.18",
+				".1.3.6.1.4.1.2021.255.19",
+				".1.3.6.1.4.1.2021.255.20",
+				".1.3.6.1.4.1.2021.255.21",
+				".1.3.6.1.4.1.2021.255.22",
+				".1.3.6.1.4.1.2021.255.23",
+				".1.3.6.1.4.1.2021.255.30",
+				".1.3.6.1.4.1.2021.255.32",
+				".1.3.6.1.4.1.2021.255.33",
+				".1.3.6.1.4.1.2021.255.34",
+				".1.3.6.1.4.1.2021.255.35",
+				".1.3.6.1.4.1.2021.255.36",
+				".1.3.6.1.4.1.2021.255.37",
+				".1.3.6.1.4.1.2021.255.38",
+				".1.3.6.1.4.1.2021.255.39",
+				".1.3.6.1.4.1.2021.255.41",
+				".1.3.6.1.4.1.2021.255.42",
+				".1.3.6.1.4.1.2021.255.43",
+				".1.3.6.1.4.1.2021.255.44",
+				".1.3.6.1.4.1.2021.255.45",
+				".1.3.6.1.4.1.2021.255.46",
+				".1.3.6.1.4.1.2021.255.47",
+				".1.3.6.1.4.1.2021.255.48",
+				".1.3.6.1.4.1.2021.255.49",
+				".1.3.6.1.4.1.2021.255.50",
+				".1.3.6.1.4.1.2021.255.51",
+				".1.3.6.1.4.1.2021.255.70",
+				".1.3.6.1.4.1.2021.255.74",
+				".1.3.6.1.4.1.2021.255.76",
+				".1.3.6.1.4.1.2021.255.77",
+				".1.3.6.1.4.1.2021.255.78",
+				".1.3.6.1.4.1.2021.255.79",
+				".1.3.6.1.4.1.2021.255.80",
+				".1.3.6.1.4.1.2021.255.83",
 			},
 			0,
 			map[string]int{},
@@ -141,16 +330,19 @@ func TestSnmpAddData(t *testing.T) {
 		// A test case with single generic parsedData.
 		{
 			[]*parsedData{
-				{"eth0:2:3", 1, 2, 3, 4, nil},
+				{"eth0:2:3", 1, 2, 3, 4, nil, 7, 0},
 			},
 			map[string]snmpData{
-				".1.3.6.1.4.1.2021.255.1.1": {".1.3.6.1.4.1.2021.255.1.1", "integer", 1},
-				".1.3.6.1.4.1.2021.255.2":   {".1.3.6.1.4.1.2021.255.2", "integer", 1},
-				".1.3.6.1.4.1.2021.255.3.1": {".1.3.6.1.4.1.2021.255.3.1", "string", "eth0:2:3"},
-				".1.3.6.1.4.1.2021.255.4.1": {".1.3.6.1.4.1.2021.255.4.1", "counter64", int64(1)},
-				".1.3.6.1.4.1.2021.255.5.1": {".1.3.6.1.4.1.2021.255.5.1", "counter64", int64(2)},
-				".1.3.6.1.4.1.2021.255.6.1": {".1.3.6.1.4.1.2021.255.6.1", "counter64", int64(3)},
-				".1.3.6.1.4.1.2021.255.7.1": {".1.3.6.1.4.1.2021.255.7.1", "counter64", int64(4)},
+				".1.3.6.1.4.1.2021.255.1.1":                             {".1.3.6.1.4.1.2021.255.1.1", "integer", 1},
+				".1.3.6.1.4.1.2021.255.2":                               {".1.3.6.1.4.1.2021.255.2", "integer", 1},
+				".1.3.6.1.4.1.2021.255.3.1":                             {".1.3.6.1.4.1.2021.255.3.1", "string", "eth0:2:3"},
+				".1.3.6.1.4.1.2021.255.4.1":                             {".1.3.6.1.4.1.2021.255.4.1", "counter64", int64(1)},
+				".1.3.6.1.4.1.2021.255.5.1":                             {".1.3.6.1.4.1.2021.255.5.1", "counter64", int64(2)},
+				".1.3.6.1.4.1.2021.255.6.1":                             {".1.3.6.1.4.1.2021.255.6.1", "counter64", int64(3)},
+				".1.3.6.1.4.1.2021.255.7.1":                             {".1.3.6.1.4.1.2021.255.7.1", "counter64", int64(4)},
+				".1.3.6.1.4.1.2021.255.19.1":                            {".1.3.6.1.4.1.2021.255.19.1", "integer", 7},
+				".1.3.6.1.4.1.2021.255.72.1":                            {".1.3.6.1.4.1.2021.255.72.1", "integer", 1},
+				".1.3.6.1.4.1.2021.255.81.8.101.116.104.48.58.50.58.51": {".1.3.6.1.4.1.2021.255.81.8.101.116.104.48.58.50.58.51", "integer", 1},
 			},
 			[]string{
 				".1.3.6.1.4.1.2021.255",
@@ -177,6 +369,42 @@ func TestSnmpAddData(t *testing.T) {
 				".1.3.6.1.4.1.2021.255.16",
 				".1.3.6.1.4.1.2021.255.17",
 				".1.3.6.1.4.1.2021.255.18",
+				".1.3.6.1.4.1.2021.255.19",
+				".1.3.6.1.4.1.2021.255.19.1",
+				".1.3.6.1.4.1.2021.255.20",
+				".1.3.6.1.4.1.2021.255.21",
+				".1.3.6.1.4.1.2021.255.22",
+				".1.3.6.1.4.1.2021.255.23",
+				".1.3.6.1.4.1.2021.255.30",
+				".1.3.6.1.4.1.2021.255.32",
+				".1.3.6.1.4.1.2021.255.33",
+				".1.3.6.1.4.1.2021.255.34",
+				".1.3.6.1.4.1.2021.255.35",
+				".1.3.6.1.4.1.2021.255.36",
+				".1.3.6.1.4.1.2021.255.37",
+				".1.3.6.1.4.1.2021.255.38",
+				".1.3.6.1.4.1.2021.255.39",
+				".1.3.6.1.4.1.2021.255.41",
+				".1.3.6.1.4.1.2021.255.42",
+				".1.3.6.1.4.1.2021.255.43",
+				".1.3.6.1.4.1.2021.255.44",
+				".1.3.6.1.4.1.2021.255.45",
+				".1.3.6.1.4.1.2021.255.46",
+				".1.3.6.1.4.1.2021.255.47",
+				".1.3.6.1.4.1.2021.255.48",
+				".1.3.6.1.4.1.2021.255.49",
+				".1.3.6.1.4.1.2021.255.50",
+				".1.3.6.1.4.1.2021.255.51",
+				".1.3.6.1.4.1.2021.255.70",
+				".1.3.6.1.4.1.2021.255.72.1",
+				".1.3.6.1.4.1.2021.255.74",
+				".1.3.6.1.4.1.2021.255.76",
+				".1.3.6.1.4.1.2021.255.77",
+				".1.3.6.1.4.1.2021.255.78",
+				".1.3.6.1.4.1.2021.255.79",
+				".1.3.6.1.4.1.2021.255.80",
+				".1.3.6.1.4.1.2021.255.83",
+				".1.3.6.1.4.1.2021.255.81.8.101.116.104.48.58.50.58.51",
 			},
 			1,
 			map[string]int{"eth0:2:3": 1},
@@ -187,21 +415,23 @@ func TestSnmpAddData(t *testing.T) {
 		// A test case with single user parsedData (both upload and download).
 		{
 			[]*parsedData{
-				{"eth0:2:3", 1, 2, 3, 4, &userClass{0, "username"}},
-				{"eth1:2:3", 5, 6, 7, 8, &userClass{1, "username"}},
+				{"eth0:2:3", 1, 2, 3, 4, &UserClass{0, "username"}, 7, 0},
+				{"eth1:2:3", 5, 6, 7, 8, &UserClass{1, "username"}, 7, 0},
 			},
 			map[string]snmpData{
-				".1.3.6.1.4.1.2021.255.8.1":  {".1.3.6.1.4.1.2021.255.8.1", "integer", 1},
-				".1.3.6.1.4.1.2021.255.9":    {".1.3.6.1.4.1.2021.255.9", "integer", 1},
-				".1.3.6.1.4.1.2021.255.10.1": {".1.3.6.1.4.1.2021.255.10.1", "string", "username"},
-				".1.3.6.1.4.1.2021.255.11.1": {".1.3.6.1.4.1.2021.255.11.1", "counter64", int64(5)},
-				".1.3.6.1.4.1.2021.255.12.1": {".1.3.6.1.4.1.2021.255.12.1", "counter64", int64(6)},
-				".1.3.6.1.4.1.2021.255.13.1": {".1.3.6.1.4.1.2021.255.13.1", "counter64", int64(7)},
-				".1.3.6.1.4.1.2021.255.14.1": {".1.3.6.1.4.1.2021.255.14.1", "counter64", int64(8)},
-				".1.3.6.1.4.1.2021.255.15.1": {".1.3.6.1.4.1.2021.255.15.1", "counter64", int64(1)},
-				".1.3.6.1.4.1.2021.255.16.1": {".1.3.6.1.4.1.2021.255.16.1", "counter64", int64(2)},
-				".1.3.6.1.4.1.2021.255.17.1": {".1.3.6.1.4.1.2021.255.17.1", "counter64", int64(3)},
-				".1.3.6.1.4.1.2021.255.18.1": {".1.3.6.1.4.1.2021.255.18.1", "counter64", int64(4)},
+				".1.3.6.1.4.1.2021.255.8.1":                                 {".1.3.6.1.4.1.2021.255.8.1", "integer", 1},
+				".1.3.6.1.4.1.2021.255.9":                                   {".1.3.6.1.4.1.2021.255.9", "integer", 1},
+				".1.3.6.1.4.1.2021.255.10.1":                                {".1.3.6.1.4.1.2021.255.10.1", "string", "username"},
+				".1.3.6.1.4.1.2021.255.11.1":                                {".1.3.6.1.4.1.2021.255.11.1", "counter64", int64(5)},
+				".1.3.6.1.4.1.2021.255.12.1":                                {".1.3.6.1.4.1.2021.255.12.1", "counter64", int64(6)},
+				".1.3.6.1.4.1.2021.255.13.1":                                {".1.3.6.1.4.1.2021.255.13.1", "counter64", int64(7)},
+				".1.3.6.1.4.1.2021.255.14.1":                                {".1.3.6.1.4.1.2021.255.14.1", "counter64", int64(8)},
+				".1.3.6.1.4.1.2021.255.15.1":                                {".1.3.6.1.4.1.2021.255.15.1", "counter64", int64(1)},
+				".1.3.6.1.4.1.2021.255.16.1":                                {".1.3.6.1.4.1.2021.255.16.1", "counter64", int64(2)},
+				".1.3.6.1.4.1.2021.255.17.1":                                {".1.3.6.1.4.1.2021.255.17.1", "counter64", int64(3)},
+				".1.3.6.1.4.1.2021.255.18.1":                                {".1.3.6.1.4.1.2021.255.18.1", "counter64", int64(4)},
+				".1.3.6.1.4.1.2021.255.73.1":                                {".1.3.6.1.4.1.2021.255.73.1", "integer", 1},
+				".1.3.6.1.4.1.2021.255.82.8.117.115.101.114.110.97.109.101": {".1.3.6.1.4.1.2021.255.82.8.117.115.101.114.110.97.109.101", "integer", 1},
 			},
 			[]string{
 				".1.3.6.1.4.1.2021.255",
@@ -232,6 +462,41 @@ func TestSnmpAddData(t *testing.T) {
 				".1.3.6.1.4.1.2021.255.17.1",
 				".1.3.6.1.4.1.2021.255.18",
 				".1.3.6.1.4.1.2021.255.18.1",
+				".1.3.6.1.4.1.2021.255.19",
+				".1.3.6.1.4.1.2021.255.20",
+				".1.3.6.1.4.1.2021.255.21",
+				".1.3.6.1.4.1.2021.255.22",
+				".1.3.6.1.4.1.2021.255.23",
+				".1.3.6.1.4.1.2021.255.30",
+				".1.3.6.1.4.1.2021.255.32",
+				".1.3.6.1.4.1.2021.255.33",
+				".1.3.6.1.4.1.2021.255.34",
+				".1.3.6.1.4.1.2021.255.35",
+				".1.3.6.1.4.1.2021.255.36",
+				".1.3.6.1.4.1.2021.255.37",
+				".1.3.6.1.4.1.2021.255.38",
+				".1.3.6.1.4.1.2021.255.39",
+				".1.3.6.1.4.1.2021.255.41",
+				".1.3.6.1.4.1.2021.255.42",
+				".1.3.6.1.4.1.2021.255.43",
+				".1.3.6.1.4.1.2021.255.44",
+				".1.3.6.1.4.1.2021.255.45",
+				".1.3.6.1.4.1.2021.255.46",
+				".1.3.6.1.4.1.2021.255.47",
+				".1.3.6.1.4.1.2021.255.48",
+				".1.3.6.1.4.1.2021.255.49",
+				".1.3.6.1.4.1.2021.255.50",
+				".1.3.6.1.4.1.2021.255.51",
+				".1.3.6.1.4.1.2021.255.70",
+				".1.3.6.1.4.1.2021.255.73.1",
+				".1.3.6.1.4.1.2021.255.74",
+				".1.3.6.1.4.1.2021.255.76",
+				".1.3.6.1.4.1.2021.255.77",
+				".1.3.6.1.4.1.2021.255.78",
+				".1.3.6.1.4.1.2021.255.79",
+				".1.3.6.1.4.1.2021.255.80",
+				".1.3.6.1.4.1.2021.255.83",
+				".1.3.6.1.4.1.2021.255.82.8.117.115.101.114.110.97.109.101",
 			},
 			0,
 			map[string]int{},
@@ -242,29 +507,34 @@ func TestSnmpAddData(t *testing.T) {
 		// A test case with both generic and user parsedData (both upload and download).
 		{
 			[]*parsedData{
-				{"eth0:2:3", 1, 2, 3, 4, &userClass{0, "username"}},
-				{"eth1:2:3", 5, 6, 7, 8, &userClass{1, "username"}},
-				{"eth0:1:3", 9, 10, 11, 12, nil},
+				{"eth0:2:3", 1, 2, 3, 4, &UserClass{0, "username"}, 7, 0},
+				{"eth1:2:3", 5, 6, 7, 8, &UserClass{1, "username"}, 7, 0},
+				{"eth0:1:3", 9, 10, 11, 12, nil, 7, 0},
 			},
 			map[string]snmpData{
-				".1.3.6.1.4.1.2021.255.1.1":  {".1.3.6.1.4.1.2021.255.1.1", "integer", 1},
-				".1.3.6.1.4.1.2021.255.2":    {".1.3.6.1.4.1.2021.255.2", "integer", 1},
-				".1.3.6.1.4.1.2021.255.3.1":  {".1.3.6.1.4.1.2021.255.3.1", "string", "eth0:1:3"},
-				".1.3.6.1.4.1.2021.255.4.1":  {".1.3.6.1.4.1.2021.255.4.1", "counter64", int64(9)},
-				".1.3.6.1.4.1.2021.255.5.1":  {".1.3.6.1.4.1.2021.255.5.1", "counter64", int64(10)},
-				".1.3.6.1.4.1.2021.255.6.1":  {".1.3.6.1.4.1.2021.255.6.1", "counter64", int64(11)},
-				".1.3.6.1.4.1.2021.255.7.1":  {".1.3.6.1.4.1.2021.255.7.1", "counter64", int64(12)},
-				".1.3.6.1.4.1.2021.255.8.1":  {".1.3.6.1.4.1.2021.255.8.1", "integer", 1},
-				".1.3.6.1.4.1.2021.255.9":    {".1.3.6.1.4.1.2021.255.9", "integer", 1},
-				".1.3.6.1.4.1.2021.255.10.1": {".1.3.6.1.4.1.2021.255.10.1", "string", "username"},
-				".1.3.6.1.4.1.2021.255.11.1": {".1.3.6.1.4.1.2021.255.11.1", "counter64", int64(5)},
-				".1.3.6.1.4.1.2021.255.12.1": {".1.3.6.1.4.1.2021.255.12.1", "counter64", int64(6)},
-				".1.3.6.1.4.1.2021.255.13.1": {".1.3.6.1.4.1.2021.255.13.1", "counter64", int64(7)},
-				".1.3.6.1.4.1.2021.255.14.1": {".1.3.6.1.4.1.2021.255.14.1", "counter64", int64(8)},
-				".1.3.6.1.4.1.2021.255.15.1": {".1.3.6.1.4.1.2021.255.15.1", "counter64", int64(1)},
-				".1.3.6.1.4.1.2021.255.16.1": {".1.3.6.1.4.1.2021.255.16.1", "counter64", int64(2)},
-				".1.3.6.1.4.1.2021.255.17.1": {".1.3.6.1.4.1.2021.255.17.1", "counter64", int64(3)},
-				".1.3.6.1.4.1.2021.255.18.1": {".1.3.6.1.4.1.2021.255.18.1", "counter64", int64(4)},
+				".1.3.6.1.4.1.2021.255.1.1":                                 {".1.3.6.1.4.1.2021.255.1.1", "integer", 1},
+				".1.3.6.1.4.1.2021.255.2":                                   {".1.3.6.1.4.1.2021.255.2", "integer", 1},
+				".1.3.6.1.4.1.2021.255.3.1":                                 {".1.3.6.1.4.1.2021.255.3.1", "string", "eth0:1:3"},
+				".1.3.6.1.4.1.2021.255.4.1":                                 {".1.3.6.1.4.1.2021.255.4.1", "counter64", int64(9)},
+				".1.3.6.1.4.1.2021.255.5.1":                                 {".1.3.6.1.4.1.2021.255.5.1", "counter64", int64(10)},
+				".1.3.6.1.4.1.2021.255.6.1":                                 {".1.3.6.1.4.1.2021.255.6.1", "counter64", int64(11)},
+				".1.3.6.1.4.1.2021.255.7.1":                                 {".1.3.6.1.4.1.2021.255.7.1", "counter64", int64(12)},
+				".1.3.6.1.4.1.2021.255.8.1":                                 {".1.3.6.1.4.1.2021.255.8.1", "integer", 1},
+				".1.3.6.1.4.1.2021.255.9":                                   {".1.3.6.1.4.1.2021.255.9", "integer", 1},
+				".1.3.6.1.4.1.2021.255.10.1":                                {".1.3.6.1.4.1.2021.255.10.1", "string", "username"},
+				".1.3.6.1.4.1.2021.255.11.1":                                {".1.3.6.1.4.1.2021.255.11.1", "counter64", int64(5)},
+				".1.3.6.1.4.1.2021.255.12.1":                                {".1.3.6.1.4.1.2021.255.12.1", "counter64", int64(6)},
+				".1.3.6.1.4.1.2021.255.13.1":                                {".1.3.6.1.4.1.2021.255.13.1", "counter64", int64(7)},
+				".1.3.6.1.4.1.2021.255.14.1":                                {".1.3.6.1.4.1.2021.255.14.1", "counter64", int64(8)},
+				".1.3.6.1.4.1.2021.255.15.1":                                {".1.3.6.1.4.1.2021.255.15.1", "counter64", int64(1)},
+				".1.3.6.1.4.1.2021.255.16.1":                                {".1.3.6.1.4.1.2021.255.16.1", "counter64", int64(2)},
+				".1.3.6.1.4.1.2021.255.17.1":                                {".1.3.6.1.4.1.2021.255.17.1", "counter64", int64(3)},
+				".1.3.6.1.4.1.2021.255.18.1":                                {".1.3.6.1.4.1.2021.255.18.1", "counter64", int64(4)},
+				".1.3.6.1.4.1.2021.255.19.1":                                {".1.3.6.1.4.1.2021.255.19.1", "integer", 7},
+				".1.3.6.1.4.1.2021.255.72.1":                                {".1.3.6.1.4.1.2021.255.72.1", "integer", 1},
+				".1.3.6.1.4.1.2021.255.73.1":                                {".1.3.6.1.4.1.2021.255.73.1", "integer", 1},
+				".1.3.6.1.4.1.2021.255.81.8.101.116.104.48.58.49.58.51":     {".1.3.6.1.4.1.2021.255.81.8.101.116.104.48.58.49.58.51", "integer", 1},
+				".1.3.6.1.4.1.2021.255.82.8.117.115.101.114.110.97.109.101": {".1.3.6.1.4.1.2021.255.82.8.117.115.101.114.110.97.109.101", "integer", 1},
 			},
 			[]string{
 				".1.3.6.1.4.1.2021.255",
@@ -302,6 +572,44 @@ func TestSnmpAddData(t *testing.T) {
 				".1.3.6.1.4.1.2021.255.17.1",
 				".1.3.6.1.4.1.2021.255.18",
 				".1.3.6.1.4.1.2021.255.18.1",
+				".1.3.6.1.4.1.2021.255.19",
+				".1.3.6.1.4.1.2021.255.19.1",
+				".1.3.6.1.4.1.2021.255.20",
+				".1.3.6.1.4.1.2021.255.21",
+				".1.3.6.1.4.1.2021.255.22",
+				".1.3.6.1.4.1.2021.255.23",
+				".1.3.6.1.4.1.2021.255.30",
+				".1.3.6.1.4.1.2021.255.32",
+				".1.3.6.1.4.1.2021.255.33",
+				".1.3.6.1.4.1.2021.255.34",
+				".1.3.6.1.4.1.2021.255.35",
+				".1.3.6.1.4.1.2021.255.36",
+				".1.3.6.1.4.1.2021.255.37",
+				".1.3.6.1.4.1.2021.255.38",
+				".1.3.6.1.4.1.2021.255.39",
+				".1.3.6.1.4.1.2021.255.41",
+				".1.3.6.1.4.1.2021.255.42",
+				".1.3.6.1.4.1.2021.255.43",
+				".1.3.6.1.4.1.2021.255.44",
+				".1.3.6.1.4.1.2021.255.45",
+				".1.3.6.1.4.1.2021.255.46",
+				".1.3.6.1.4.1.2021.255.47",
+				".1.3.6.1.4.1.2021.255.48",
+				".1.3.6.1.4.1.2021.255.49",
+				".1.3.6.1.4.1.2021.255.50",
+				".1.3.6.1.4.1.2021.255.51",
+				".1.3.6.1.4.1.2021.255.70",
+				".1.3.6.1.4.1.2021.255.72.1",
+				".1.3.6.1.4.1.2021.255.73.1",
+				".1.3.6.1.4.1.2021.255.74",
+				".1.3.6.1.4.1.2021.255.76",
+				".1.3.6.1.4.1.2021.255.77",
+				".1.3.6.1.4.1.2021.255.78",
+				".1.3.6.1.4.1.2021.255.79",
+				".1.3.6.1.4.1.2021.255.80",
+				".1.3.6.1.4.1.2021.255.83",
+				".1.3.6.1.4.1.2021.255.81.8.101.116.104.48.58.49.58.51",
+				".1.3.6.1.4.1.2021.255.82.8.117.115.101.114.110.97.109.101",
 			},
 			1,
 			map[string]int{"eth0:1:3": 1},
@@ -314,21 +622,30 @@ func TestSnmpAddData(t *testing.T) {
 	fs := &fakeSyslog{}
 	o := &SnmpOptions{}
 	s := &snmp{
-		logger:  fs,
-		options: o,
+		logger:   fs,
+		options:  o,
+		identity: myName,
 	}
 	for i, params := range testData {
-		s.lock()
+		s.begin()
 		s.erase()
 		for _, data := range params.p {
 			s.addData(data)
 		}
-		s.unlock()
+		s.commit()
 
 		// Add the common OIDs into the expected data.
 		for k, v := range commonOIDs {
 			params.expectedOIDData[k] = v
 		}
+		// The time of the last successful parse cycle varies, it is checked by TestSnmpLastUpdate.
+		if data, ok := s.oidData[leafOID(tcLastUpdateLeaf)]; ok {
+			params.expectedOIDData[leafOID(tcLastUpdateLeaf)] = *data
+			params.expectedOIDs = append(params.expectedOIDs, leafOID(tcLastUpdateLeaf))
+			sort.Slice(params.expectedOIDs, func(i, j int) bool {
+				return oidLess(params.expectedOIDs[i], params.expectedOIDs[j])
+			})
+		}
 		if err := compareSnmpData(s.oidData, params.expectedOIDData); err != nil {
 			t.Errorf("TestSnmpAddData(testCase %d) snmpData: %s", i, err)
 		}
@@ -350,129 +667,2289 @@ func TestSnmpAddData(t *testing.T) {
 	}
 }
 
-// testTalker implements snmpTalker and is used in tests.
-type testTalker struct {
-	// input is a list of strings that should be returned by getLine().
-	input []string
+func TestSnmpAddPktSizeData(t *testing.T) {
+	testData := []struct {
+		desc           string
+		pktSizeBuckets []int
+		cycles         [][]*parsedData
+		want           map[string]snmpData
+		wantMissing    []string
+	}{
+		{
+			desc: "no average packet size after the first interval",
+			cycles: [][]*parsedData{
+				{{"eth0:2:3", 1000, 10, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+			},
+			wantMissing: []string{".1.3.6.1.4.1.2021.255.23.1", ".1.3.6.1.4.1.2021.255.27.1"},
+		},
+		{
+			desc: "average packet size of the last interval",
+			cycles: [][]*parsedData{
+				{
+					{"eth0:2:3", 1000, 10, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0},
+					{"eth1:2:3", 1000, 10, 0, 0, &UserClass{DownloadDirection, "user1"}, 3, 0},
+				},
+				{
+					{"eth0:2:3", 4000, 20, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0},
+					{"eth1:2:3", 16000, 20, 0, 0, &UserClass{DownloadDirection, "user1"}, 3, 0},
+				},
+			},
+			want: map[string]snmpData{
+				".1.3.6.1.4.1.2021.255.22.1": {".1.3.6.1.4.1.2021.255.22.1", "gauge", int64(1500)},
+				".1.3.6.1.4.1.2021.255.23.1": {".1.3.6.1.4.1.2021.255.23.1", "gauge", int64(300)},
+			},
+			wantMissing: []string{".1.3.6.1.4.1.2021.255.24", ".1.3.6.1.4.1.2021.255.27.1"},
+		},
+		{
+			desc: "no average packet size without packets",
+			cycles: [][]*parsedData{
+				{{"eth0:2:3", 1000, 10, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+				{{"eth0:2:3", 1000, 10, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+			},
+			wantMissing: []string{".1.3.6.1.4.1.2021.255.23.1"},
+		},
+		{
+			desc: "no average packet size after a counter reset",
+			cycles: [][]*parsedData{
+				{{"eth0:2:3", 1000, 10, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+				{{"eth0:2:3", 100, 1, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+			},
+			wantMissing: []string{".1.3.6.1.4.1.2021.255.23.1"},
+		},
+		{
+			desc:           "intervals are counted in the packet size buckets",
+			pktSizeBuckets: []int{100, 1000},
+			cycles: [][]*parsedData{
+				{{"eth0:2:3", 0, 0, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+				{{"eth0:2:3", 500, 10, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+				{{"eth0:2:3", 1000, 20, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+				{{"eth0:2:3", 2000, 21, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+				{{"eth0:2:3", 3000, 22, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0}},
+			},
+			want: map[string]snmpData{
+				".1.3.6.1.4.1.2021.255.23.1": {".1.3.6.1.4.1.2021.255.23.1", "gauge", int64(1000)},
+				".1.3.6.1.4.1.2021.255.24":   {".1.3.6.1.4.1.2021.255.24", "string", "tcUserDownSmallPktLeaf"},
+				".1.3.6.1.4.1.2021.255.27.1": {".1.3.6.1.4.1.2021.255.27.1", "counter64", int64(2)},
+				".1.3.6.1.4.1.2021.255.28.1": {".1.3.6.1.4.1.2021.255.28.1", "counter64", int64(0)},
+				".1.3.6.1.4.1.2021.255.29.1": {".1.3.6.1.4.1.2021.255.29.1", "counter64", int64(2)},
+			},
+			wantMissing: []string{".1.3.6.1.4.1.2021.255.24.1"},
+		},
+	}
 
-	// output is the list of strings that were added by calling putLine()
-	output []string
-}
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &snmp{
+				logger: &fakeSyslog{},
+				options: &SnmpOptions{
+					PktSizeBuckets: tc.pktSizeBuckets,
+				},
+				identity: myName,
+			}
+			for _, cycle := range tc.cycles {
+				s.begin()
+				s.erase()
+				for _, data := range cycle {
+					s.addData(data)
+				}
+				s.commit()
+			}
 
-// getLine returns a single line from the predefined output.
-func (tr *testTalker) getLine() string {
-	if len(tr.input) < 1 {
-		panic("getLine(): unexpected call to getLine, got no more input data.")
+			for oid, want := range tc.want {
+				got, ok := s.oidData[oid]
+				if !ok {
+					t.Errorf("oidData[%s] => not found, want: %v", oid, want)
+					continue
+				}
+				if *got != want {
+					t.Errorf("oidData[%s] => got: %v, want: %v", oid, *got, want)
+				}
+			}
+			for _, oid := range tc.wantMissing {
+				if got, ok := s.oidData[oid]; ok {
+					t.Errorf("oidData[%s] => got: %v, want it missing", oid, *got)
+				}
+			}
+		})
 	}
-	input := tr.input[0]
-	tr.input = tr.input[1:]
-	return input
 }
 
-// putLine stores a single line inside testTalker struct.
-func (tr *testTalker) putLine(line string) {
-	tr.output = append(tr.output, line)
-}
+func TestSnmpAddGroupData(t *testing.T) {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+	}
+	s.begin()
+	s.erase()
+	s.addGroupData(&parsedData{"all", 3000, 30, 3, 6, nil, 0, 0})
+	s.addGroupData(&parsedData{"wan", 2000, 20, 2, 4, nil, 0, 0})
+	s.commit()
 
-// erase deletes all stored inputs and output.
-func (tr *testTalker) erase() {
-	tr.input = make([]string, 0)
-	tr.output = make([]string, 0)
+	want := map[string]snmpData{
+		".1.3.6.1.4.1.2021.255.30.1": {".1.3.6.1.4.1.2021.255.30.1", "integer", 1},
+		".1.3.6.1.4.1.2021.255.30.2": {".1.3.6.1.4.1.2021.255.30.2", "integer", 2},
+		".1.3.6.1.4.1.2021.255.31":   {".1.3.6.1.4.1.2021.255.31", "integer", 2},
+		".1.3.6.1.4.1.2021.255.32.1": {".1.3.6.1.4.1.2021.255.32.1", "string", "all"},
+		".1.3.6.1.4.1.2021.255.32.2": {".1.3.6.1.4.1.2021.255.32.2", "string", "wan"},
+		".1.3.6.1.4.1.2021.255.33.1": {".1.3.6.1.4.1.2021.255.33.1", "counter64", int64(3000)},
+		".1.3.6.1.4.1.2021.255.33.2": {".1.3.6.1.4.1.2021.255.33.2", "counter64", int64(2000)},
+		".1.3.6.1.4.1.2021.255.34.1": {".1.3.6.1.4.1.2021.255.34.1", "counter64", int64(30)},
+		".1.3.6.1.4.1.2021.255.35.1": {".1.3.6.1.4.1.2021.255.35.1", "counter64", int64(3)},
+		".1.3.6.1.4.1.2021.255.36.2": {".1.3.6.1.4.1.2021.255.36.2", "counter64", int64(4)},
+	}
+	for oid, w := range want {
+		got, ok := s.oidData[oid]
+		if !ok {
+			t.Errorf("oidData[%s] => not found, want: %v", oid, w)
+			continue
+		}
+		if *got != w {
+			t.Errorf("oidData[%s] => got: %v, want: %v", oid, *got, w)
+		}
+	}
 }
 
-func TestSnmpListen(t *testing.T) {
-	// Store some data.
-	var p []*parsedData = []*parsedData{
-		{"eth0:2:3", 1, 2, 3, 4, &userClass{0, "username"}},
-		{"eth1:2:3", 5, 6, 7, 8, &userClass{1, "username"}},
-		{"eth0:1:3", 9, 10, math.MaxInt32, math.MaxInt32 + 1, nil},
-	}
-	tr := &testTalker{}
-	fs := &fakeSyslog{}
-	o := &SnmpOptions{}
+func TestSnmpAddIfaceData(t *testing.T) {
 	s := &snmp{
-		snmpTalker: tr,
-		logger:     fs,
-		options:    o,
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
 	}
-	s.lock()
+	s.begin()
 	s.erase()
-	for _, data := range p {
-		s.addData(data)
+	s.addIfaceData(&parsedData{"eth0", 3000, 30, 3, 6, nil, 0, 0})
+	s.addIfaceData(&parsedData{"eth1", 2000, 20, 2, 4, nil, 0, 0})
+	s.commit()
+
+	want := map[string]snmpData{
+		".1.3.6.1.4.1.2021.255.74.1": {".1.3.6.1.4.1.2021.255.74.1", "integer", 1},
+		".1.3.6.1.4.1.2021.255.75":   {".1.3.6.1.4.1.2021.255.75", "integer", 2},
+		".1.3.6.1.4.1.2021.255.76.2": {".1.3.6.1.4.1.2021.255.76.2", "string", "eth1"},
+		".1.3.6.1.4.1.2021.255.77.1": {".1.3.6.1.4.1.2021.255.77.1", "counter64", int64(3000)},
+		".1.3.6.1.4.1.2021.255.78.2": {".1.3.6.1.4.1.2021.255.78.2", "counter64", int64(20)},
+		".1.3.6.1.4.1.2021.255.79.1": {".1.3.6.1.4.1.2021.255.79.1", "counter64", int64(3)},
+		".1.3.6.1.4.1.2021.255.80.2": {".1.3.6.1.4.1.2021.255.80.2", "counter64", int64(4)},
+	}
+	for oid, w := range want {
+		got, ok := s.oidData[oid]
+		if !ok {
+			t.Errorf("oidData[%s] => not found, want: %v", oid, w)
+			continue
+		}
+		if *got != w {
+			t.Errorf("oidData[%s] => got: %v, want: %v", oid, *got, w)
+		}
 	}
-	s.unlock()
+}
 
+func TestEfficiency(t *testing.T) {
 	testData := []struct {
-		desc     string
-		commands []string
-		want     []string
+		desc   string
+		delta  sample
+		want   int64
+		wantOk bool
 	}{
-
-		{
-			desc:     "simple PING-PONG exchange",
-			commands: []string{"PING", ""},
-			want:     []string{"PONG"},
-		},
-		{
-			desc:     "standard SNMP GET for a known OID - a string",
-			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.3.1", ""},
-			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.3.1", "string", "eth0:1:3"},
-		},
-		{
-			desc:     "standard SNMP GET for a known OID - an integer",
-			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.1.1", ""},
-			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.1.1", "integer", "1"},
-		},
 		{
-			desc:     "standard SNMP GET for a known OID - a counter64",
-			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.4.1", ""},
-			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.4.1", "counter64", "9"},
+			desc: "no packets",
 		},
 		{
-			desc:     "standard SNMP GET for a known OID - a counter64, and the value is at the math.MaxInt32",
-			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.6.1", ""},
-			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.6.1", "counter64", strconv.Itoa(math.MaxInt32)},
+			desc:   "never throttled",
+			delta:  sample{pkt: 10},
+			want:   100,
+			wantOk: true,
 		},
 		{
-			desc:     "standard SNMP GET for a known OID - a counter64, and the value is larger than math.MaxInt32",
-			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.7.1", ""},
-			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.7.1", "counter64", strconv.Itoa(math.MaxInt32 + 1)},
+			desc:   "throttled most of the time",
+			delta:  sample{pkt: 1, overLimitPkt: 3},
+			want:   25,
+			wantOk: true,
 		},
 		{
-			desc:     "standard SNMP GET for unknown OID",
-			commands: []string{"PING", "get", ".1.3.7", ""},
-			want:     []string{"PONG", ""},
+			desc:   "only over limit packets",
+			delta:  sample{overLimitPkt: 3},
+			want:   0,
+			wantOk: true,
 		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, ok := efficiency(tc.delta)
+			if got != tc.want || ok != tc.wantOk {
+				t.Errorf("efficiency(%v) => got: %d, %v, want: %d, %v", tc.delta, got, ok, tc.want, tc.wantOk)
+			}
+		})
+	}
+}
+
+func TestDropRate(t *testing.T) {
+	testData := []struct {
+		desc   string
+		delta  sample
+		want   int64
+		wantOk bool
+	}{
 		{
-			desc:     "standard SNMP GET-NEXT for two known OIDs",
-			commands: []string{"PING", "getnext", ".1.3.6.1.4.1.2021.255.9", "getnext", ".1.3.6.1.4.1.2021.255.10", ""},
-			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.10", "string", "tcUserNameLeaf", ".1.3.6.1.4.1.2021.255.10.1", "string", "username"},
+			desc:   "no traffic",
+			delta:  sample{},
+			wantOk: false,
 		},
 		{
-			desc:     "standard SNMP GET-NEXT for the last OID",
-			commands: []string{"PING", "getnext", ".1.3.6.1.4.1.2021.255.18.1", ""},
-			want:     []string{"PONG", ""},
+			desc:   "nothing dropped",
+			delta:  sample{pkt: 10},
+			want:   0,
+			wantOk: true,
 		},
 		{
-			desc:     "standard SNMP GET-NEXT for unknown OID",
-			commands: []string{"PING", "getnext", ".1.3.7", ""},
-			want:     []string{"PONG", ""},
+			desc:   "some dropped",
+			delta:  sample{pkt: 30, droppedPkt: 10},
+			want:   25,
+			wantOk: true,
 		},
 		{
-			desc:     "unknown command",
-			commands: []string{"PING", "set", ""},
-			want:     []string{"PONG", ""},
+			desc:   "only dropped packets",
+			delta:  sample{droppedPkt: 3},
+			want:   100,
+			wantOk: true,
 		},
 	}
 
 	for _, tc := range testData {
 		t.Run(tc.desc, func(t *testing.T) {
-			tr.erase()
-			tr.input = tc.commands
-			s.Listen()
-			if diff := pretty.Compare(tc.want, tr.output); diff != "" {
-				t.Errorf("Listen => unexpected output, diff (-want, +got)\n%s", diff)
+			got, ok := dropRate(tc.delta)
+			if got != tc.want || ok != tc.wantOk {
+				t.Errorf("dropRate(%v) => got: %d, %v, want: %d, %v", tc.delta, got, ok, tc.want, tc.wantOk)
 			}
 		})
 	}
 }
+
+func TestSnmpAddEfficiencyData(t *testing.T) {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+	}
+	cycles := [][]*parsedData{
+		{{"eth0:1:10", 1000, 10, 0, 5, nil, 2, 0}},
+		{{"eth0:1:10", 2000, 20, 0, 35, nil, 2, 0}},
+	}
+	wantOIDs := []map[string]bool{
+		{".1.3.6.1.4.1.2021.255.37.1": false},
+		{".1.3.6.1.4.1.2021.255.37.1": true},
+	}
+	for i, cycle := range cycles {
+		s.begin()
+		s.erase()
+		for _, data := range cycle {
+			s.addData(data)
+		}
+		s.commit()
+		for oid, want := range wantOIDs[i] {
+			if _, ok := s.oidData[oid]; ok != want {
+				t.Errorf("cycle %d: oidData[%s] => found: %v, want: %v", i, oid, ok, want)
+			}
+		}
+	}
+	want := snmpData{".1.3.6.1.4.1.2021.255.37.1", "gauge", int64(25)}
+	if got := s.oidData[".1.3.6.1.4.1.2021.255.37.1"]; got == nil || *got != want {
+		t.Errorf("oidData[%s] => got: %v, want: %v", want.oid, got, want)
+	}
+}
+
+func TestSnmpSetMaintenance(t *testing.T) {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+	}
+	maintenanceOID := ".1.3.6.1.4.1.2021.255.38"
+	efficiencyOID := ".1.3.6.1.4.1.2021.255.37.1"
+
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:10", 1000, 10, 0, 0, nil, 2, 0})
+	s.commit()
+
+	// The collection is paused, the data stays as it was.
+	s.begin()
+	s.setMaintenance(true)
+	s.commit()
+	s.begin()
+	s.setMaintenance(true)
+	s.commit()
+	if got := s.oidData[maintenanceOID].objectValue; got != 1 {
+		t.Errorf("setMaintenance(true) => %s got: %v, want: 1", maintenanceOID, got)
+	}
+	seen := make(map[string]bool)
+	for _, oid := range s.oids {
+		if seen[oid] {
+			t.Errorf("setMaintenance(true) => duplicate OID %s", oid)
+		}
+		seen[oid] = true
+	}
+
+	// The first cycle after the maintenance has no previous sample.
+	s.begin()
+	s.setMaintenance(false)
+	s.erase()
+	s.addData(&parsedData{"eth0:1:10", 2000, 20, 0, 0, nil, 2, 0})
+	s.commit()
+	if got := s.oidData[maintenanceOID].objectValue; got != 0 {
+		t.Errorf("setMaintenance(false) => %s got: %v, want: 0", maintenanceOID, got)
+	}
+	if _, ok := s.oidData[efficiencyOID]; ok {
+		t.Errorf("setMaintenance(false) => %s found, want it missing after the maintenance", efficiencyOID)
+	}
+
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:10", 3000, 30, 0, 0, nil, 2, 0})
+	s.commit()
+	if _, ok := s.oidData[efficiencyOID]; !ok {
+		t.Errorf("second cycle after the maintenance => %s missing, want it found", efficiencyOID)
+	}
+}
+
+func TestSnmpIncrementalUpdate(t *testing.T) {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+	}
+	sentBytesOID := ".1.3.6.1.4.1.2021.255.4.1"
+	removedOID := ".1.3.6.1.4.1.2021.255.4.2"
+
+	// The efficiency is exported from the second cycle on.
+	for i := int64(1); i <= 2; i++ {
+		s.begin()
+		s.erase()
+		s.addData(&parsedData{"eth0:1:0", i * 1000, i * 10, 0, 0, nil, 2, 0})
+		s.addData(&parsedData{"eth0:1:10", i * 500, i * 5, 0, 0, nil, 2, 0})
+		s.commit()
+	}
+	stored := s.oidData[sentBytesOID]
+	numOIDs := len(s.oids)
+
+	// The same Qdiscs / Classes update the values in place.
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:0", 3000, 30, 0, 0, nil, 2, 0})
+	s.addData(&parsedData{"eth0:1:10", 1500, 15, 0, 0, nil, 2, 0})
+	if len(s.newOIDs) != 0 {
+		t.Errorf("addData of known names => newOIDs got: %v, want none", s.newOIDs)
+	}
+	s.commit()
+	if s.oidData[sentBytesOID] != stored {
+		t.Errorf("addData of known names => %s was reallocated, want it updated in place", sentBytesOID)
+	}
+	if got := stored.objectValue; got != int64(3000) {
+		t.Errorf("addData of known names => %s got: %v, want: 3000", sentBytesOID, got)
+	}
+
+	// The removed Class disappears from the tree.
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:0", 4000, 40, 0, 0, nil, 2, 0})
+	s.commit()
+	if _, ok := s.oidData[removedOID]; ok {
+		t.Errorf("addData after a Class was removed => %s found, want it missing", removedOID)
+	}
+	if len(s.oids) >= numOIDs {
+		t.Errorf("addData after a Class was removed => got %d OIDs, want less than %d", len(s.oids), numOIDs)
+	}
+	sorter := &oidSorter{oids: &s.oids}
+	seen := make(map[string]bool)
+	for i, oid := range s.oids {
+		if _, ok := s.oidData[oid]; !ok {
+			t.Errorf("addData after a Class was removed => %s has no data", oid)
+		}
+		if seen[oid] {
+			t.Errorf("addData after a Class was removed => duplicate OID %s", oid)
+		}
+		seen[oid] = true
+		if i > 0 && !sorter.Less(i-1, i) {
+			t.Errorf("addData after a Class was removed => OIDs %s and %s aren't sorted", s.oids[i-1], oid)
+		}
+	}
+}
+
+func TestSnmpSnapshot(t *testing.T) {
+	tr := &testTalker{}
+	s := &snmp{
+		snmpTalker: tr,
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{},
+		identity:   myName,
+	}
+	sentBytesOID := ".1.3.6.1.4.1.2021.255.4.1"
+
+	// Nothing is answered before the first snapshot is published.
+	s.snmpGet(sentBytesOID)
+	if want := []string{""}; !reflect.DeepEqual(tr.output, want) {
+		t.Errorf("snmpGet before the first update => got: %q, want: %q", tr.output, want)
+	}
+
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:0", 1000, 10, 0, 0, nil, 2, 0})
+	s.commit()
+
+	// Reads are answered from the previous snapshot while the data is being updated.
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:0", 2000, 20, 0, 0, nil, 2, 0})
+	tr.erase()
+	s.snmpGet(sentBytesOID)
+	s.snmpGetNext(".1.3.6.1.4.1.2021.255.4")
+	want := []string{sentBytesOID, "counter64", "1000", sentBytesOID, "counter64", "1000"}
+	if !reflect.DeepEqual(tr.output, want) {
+		t.Errorf("snmpGet during an update => got: %q, want: %q", tr.output, want)
+	}
+	s.commit()
+
+	tr.erase()
+	s.snmpGet(sentBytesOID)
+	want = []string{sentBytesOID, "counter64", "2000"}
+	if !reflect.DeepEqual(tr.output, want) {
+		t.Errorf("snmpGet after the update => got: %q, want: %q", tr.output, want)
+	}
+}
+
+func TestSnmpGetNextWalk(t *testing.T) {
+	tr := &testTalker{}
+	s := &snmp{
+		snmpTalker: tr,
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{},
+		identity:   myName,
+	}
+	s.begin()
+	s.erase()
+	for i := 0; i < 1000; i++ {
+		s.addData(&parsedData{fmt.Sprintf("eth0:1:%x", i), int64(i), int64(i), 0, 0, nil, 2, 0})
+	}
+	s.commit()
+
+	// Walk the whole tree, every GET-NEXT must return the OID that follows the requested one.
+	current := s.snapshot()
+	oid := myOID
+	for i := 1; i < len(current.data); i++ {
+		tr.erase()
+		s.snmpGetNext(oid)
+		if len(tr.output) != 3 || tr.output[0] != current.data[i].oid {
+			t.Fatalf("snmpGetNext(%s) => got: %q, want the OID %s", oid, tr.output, current.data[i].oid)
+		}
+		oid = tr.output[0]
+	}
+	tr.erase()
+	s.snmpGetNext(oid)
+	if want := []string{""}; !reflect.DeepEqual(tr.output, want) {
+		t.Errorf("snmpGetNext(%s) for the last OID => got: %q, want: %q", oid, tr.output, want)
+	}
+}
+
+func TestSnmpBaseOID(t *testing.T) {
+	const baseOID = ".1.3.6.1.4.1.99999.1"
+	tr := &testTalker{}
+	s := &snmp{
+		snmpTalker: tr,
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{BaseOID: baseOID},
+		identity:   myName,
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:0", 100, 10, 0, 0, nil, 2, 0})
+	s.commit()
+
+	testData := []struct {
+		desc string
+		get  func(oid string)
+		oid  string
+		want []string
+	}{
+		{"GET under the base OID", s.snmpGet, baseOID + ".3.1", []string{baseOID + ".3.1", "string", "eth0:1:0"}},
+		{"GET-NEXT of the base OID", s.snmpGetNext, baseOID, []string{baseOID + ".1", "string", "tcIndexLeaf"}},
+		{"GET under the default OID", s.snmpGet, myOID + ".3.1", []string{""}},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			tr.erase()
+			tc.get(tc.oid)
+			if !reflect.DeepEqual(tr.output, tc.want) {
+				t.Errorf("%s => got: %q, want: %q", tc.oid, tr.output, tc.want)
+			}
+		})
+	}
+}
+
+func TestSnmpLastUpdate(t *testing.T) {
+	s := &snmp{
+		snmpTalker: &testTalker{},
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{},
+		identity:   myName,
+	}
+	cycle := func(update func()) {
+		s.begin()
+		update()
+		s.commit()
+	}
+
+	// The initial empty cycle doesn't count.
+	cycle(s.erase)
+	if _, ok := s.snapshot().find(leafOID(tcLastUpdateLeaf)); ok {
+		t.Fatalf("tcLastUpdateLeaf => exported before the first parse cycle")
+	}
+
+	cycle(s.erase)
+	parsed := s.lastUpdate
+	if parsed.IsZero() || parsed != s.cycleTime {
+		t.Fatalf("lastUpdate => got: %v, want the time of the parse cycle %v", parsed, s.cycleTime)
+	}
+
+	// Neither the failed cycles nor the ones without new data update the time.
+	cycle(func() {
+		s.erase()
+		s.setHealth(1, 5)
+	})
+	cycle(func() {
+		s.setMaintenance(true)
+	})
+	if s.lastUpdate != parsed {
+		t.Errorf("lastUpdate => got: %v, want: %v", s.lastUpdate, parsed)
+	}
+	if position, ok := s.snapshot().find(leafOID(tcLastUpdateLeaf)); !ok || s.snapshot().data[position].objectValue != parsed {
+		t.Errorf("tcLastUpdateLeaf => not exported with the time of the last successful parse cycle")
+	}
+
+	// The timeticks are computed when the OID is requested.
+	tr := &testTalker{}
+	s.snmpTalker = tr
+	s.printData(&snmpData{leafOID(tcLastUpdateLeaf), "timeticks", time.Now().Add(-5 * time.Second)})
+	if len(tr.output) != 3 || tr.output[1] != "timeticks" {
+		t.Fatalf("printData => got: %q, want the timeticks", tr.output)
+	}
+	if ticks, err := strconv.Atoi(tr.output[2]); err != nil || ticks < 500 || ticks > 600 {
+		t.Errorf("printData => got timeticks: %s, want about 500", tr.output[2])
+	}
+}
+
+func TestSnmpOptionsBuildInfo(t *testing.T) {
+	testData := []struct {
+		desc    string
+		options *SnmpOptions
+		want    string
+	}{
+		{"no options", nil, "unknown"},
+		{"version only", &SnmpOptions{Version: "1.2.3"}, "1.2.3"},
+		{"build info", &SnmpOptions{Version: "1.2.3", BuildInfo: "1.2.3 (commit abc1234, built 2026-10-01T12:00:00Z, go1.27)"}, "1.2.3 (commit abc1234, built 2026-10-01T12:00:00Z, go1.27)"},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := tc.options.buildInfo(); got != tc.want {
+				t.Errorf("buildInfo => got: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSnmpConfig(t *testing.T) {
+	modified := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	s := &snmp{
+		snmpTalker: &testTalker{},
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{},
+		identity:   myName,
+		config: &ConfigInfo{
+			ParseInterval: 5,
+			Ifaces:        []string{"eth0", "ppp*"},
+			Users:         2,
+			File:          "/etc/tc_reader.conf",
+			Checksum:      "0123abcd",
+			Modified:      modified,
+		},
+	}
+	s.begin()
+	s.erase()
+	s.commit()
+
+	get := func(index int) interface{} {
+		current := s.snapshot()
+		if position, ok := current.find(indexOID(tcConfigLeaf, index)); ok {
+			return current.data[position].objectValue
+		}
+		return nil
+	}
+	want := map[int]interface{}{
+		configIntervalIndex: 5,
+		configIfacesIndex:   "eth0 ppp*",
+		configUsersIndex:    2,
+		configFileIndex:     "/etc/tc_reader.conf",
+		configChecksumIndex: "0123abcd",
+		configModifiedIndex: "2026-10-01T12:00:00Z",
+	}
+	for index, value := range want {
+		if got := get(index); got != value {
+			t.Errorf("config index %d => got: %v, want: %v", index, got, value)
+		}
+	}
+
+	// The reloaded configuration is exported from the next parse cycle.
+	s.SetConfig(&ConfigInfo{ParseInterval: 10})
+	s.begin()
+	s.erase()
+	s.commit()
+	if got := get(configIntervalIndex); got != 10 {
+		t.Errorf("reloaded parse interval => got: %v, want: 10", got)
+	}
+	if got := get(configModifiedIndex); got != "" {
+		t.Errorf("reloaded modification time => got: %v, want empty", got)
+	}
+
+	// Nothing is exported without the configuration.
+	s.SetConfig(nil)
+	s.begin()
+	s.erase()
+	s.commit()
+	if _, ok := s.snapshot().find(leafOID(tcConfigLeaf)); ok {
+		t.Errorf("tcConfigLeaf => exported without the configuration")
+	}
+}
+
+func TestSnmpRuntimeStats(t *testing.T) {
+	s := &snmp{
+		snmpTalker: &testTalker{},
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{RuntimeStats: true},
+		identity:   myName,
+	}
+	s.begin()
+	s.erase()
+	s.commit()
+
+	current := s.snapshot()
+	for _, index := range []int{runtimeHeapAllocIndex, runtimeHeapSysIndex, runtimeHeapObjectsIndex, runtimeSysIndex, runtimeGoroutinesIndex} {
+		position, ok := current.find(indexOID(tcRuntimeLeaf, index))
+		if !ok {
+			t.Errorf("runtime index %d => not exported", index)
+			continue
+		}
+		if value, _ := current.data[position].objectValue.(int64); value <= 0 {
+			t.Errorf("runtime index %d => got: %v, want a positive int64", index, current.data[position].objectValue)
+		}
+	}
+
+	if position, ok := current.find(indexOID(tcRuntimeLeaf, runtimeUptimeIndex)); !ok {
+		t.Errorf("runtime uptime => not exported")
+	} else if got := current.data[position].objectValue; got != processStart {
+		t.Errorf("runtime uptime => got: %v, want the time tc_reader started: %v", got, processStart)
+	}
+
+	// The statistics are updated in the cycles that don't execute the TC commands too.
+	s.begin()
+	s.commit()
+	if _, ok := s.snapshot().find(indexOID(tcRuntimeLeaf, runtimeNumGCIndex)); !ok {
+		t.Errorf("runtime statistics => not exported in a skipped parse cycle")
+	}
+
+	// Nothing is exported unless RuntimeStats is set.
+	s.options.RuntimeStats = false
+	s.begin()
+	s.erase()
+	s.commit()
+	if _, ok := s.snapshot().find(leafOID(tcRuntimeLeaf)); ok {
+		t.Errorf("tcRuntimeLeaf => exported without RuntimeStats")
+	}
+}
+
+func TestSnmpAddNetDevData(t *testing.T) {
+	s := &snmp{
+		snmpTalker: &testTalker{},
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{},
+		identity:   myName,
+	}
+	s.begin()
+	s.erase()
+	s.addNetDevData(&netDevStats{iface: "eth0", ifIndex: 2, rxBytes: 1500, rxPkt: 10, rxErrors: 1, rxDropped: 2, txBytes: 3000, txPkt: 20, txErrors: 3, txDropped: 4})
+	s.commit()
+
+	want := map[string]interface{}{
+		indexOID(tcNetDevIndexLeaf, 2):     2,
+		indexOID(tcNetDevNameLeaf, 2):      "eth0",
+		indexOID(tcNetDevRxBytesLeaf, 2):   int64(1500),
+		indexOID(tcNetDevRxPktLeaf, 2):     int64(10),
+		indexOID(tcNetDevRxErrorsLeaf, 2):  int64(1),
+		indexOID(tcNetDevRxDroppedLeaf, 2): int64(2),
+		indexOID(tcNetDevTxBytesLeaf, 2):   int64(3000),
+		indexOID(tcNetDevTxPktLeaf, 2):     int64(20),
+		indexOID(tcNetDevTxErrorsLeaf, 2):  int64(3),
+		indexOID(tcNetDevTxDroppedLeaf, 2): int64(4),
+	}
+	current := s.snapshot()
+	for oid, value := range want {
+		position, ok := current.find(oid)
+		if !ok {
+			t.Errorf("%s => not exported", oid)
+			continue
+		}
+		if got := current.data[position].objectValue; got != value {
+			t.Errorf("%s => got: %v, want: %v", oid, got, value)
+		}
+	}
+
+	if _, ok := current.find(leafOID(tcNetDevRxMissedLeaf)); ok {
+		t.Errorf("tcNetDevRxMissedLeaf => exported without the counters of the ip command")
+	}
+
+	// The missed packets and the carrier transitions are exported if the counters were collected by the ip command.
+	s.begin()
+	s.erase()
+	s.addNetDevData(&netDevStats{iface: "eth0", ifIndex: 2, linkStats: true, rxMissed: 5, carrierChanges: 6})
+	s.commit()
+	current = s.snapshot()
+	for oid, value := range map[string]int64{indexOID(tcNetDevRxMissedLeaf, 2): 5, indexOID(tcNetDevCarrierChangesLeaf, 2): 6} {
+		if position, ok := current.find(oid); !ok || current.data[position].objectValue != value {
+			t.Errorf("%s => not exported with the value %d", oid, value)
+		}
+	}
+
+	// The counters of an interface that isn't added again are removed.
+	s.begin()
+	s.erase()
+	s.commit()
+	if _, ok := s.snapshot().find(leafOID(tcNetDevIndexLeaf)); ok {
+		t.Errorf("tcNetDevIndexLeaf => exported without any kernel counters")
+	}
+}
+
+func TestSnmpAddConntrackData(t *testing.T) {
+	s := &snmp{
+		snmpTalker: &testTalker{},
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{},
+		identity:   myName,
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{name: "user1", sentBytes: 700, sentPkt: 7, userClass: &UserClass{DownloadDirection, "user1"}})
+	s.addConntrackData(&parsedData{name: "user1", sentBytes: 800, sentPkt: 8, userClass: &UserClass{DownloadDirection, "user1"}})
+	s.addConntrackData(&parsedData{name: "user2", sentBytes: 300, sentPkt: 3, userClass: &UserClass{UploadDirection, "user2"}})
+	s.commit()
+
+	want := map[string]interface{}{
+		indexOID(tcUserDownBytesLeaf, 1):          int64(700),
+		indexOID(tcUserDownConntrackBytesLeaf, 1): int64(800),
+		indexOID(tcUserNameLeaf, 2):               "user2",
+		indexOID(tcUserUpConntrackBytesLeaf, 2):   int64(300),
+		indexOID(tcUserStatusLeaf, 2):             entryActive,
+	}
+	current := s.snapshot()
+	for oid, value := range want {
+		position, ok := current.find(oid)
+		if !ok {
+			t.Errorf("%s => not exported", oid)
+			continue
+		}
+		if got := current.data[position].objectValue; got != value {
+			t.Errorf("%s => got: %v, want: %v", oid, got, value)
+		}
+	}
+	if _, ok := current.find(indexOID(tcUserUpBytesLeaf, 2)); ok {
+		t.Errorf("tcUserUpBytesLeaf => exported for an user counted only by conntrack")
+	}
+}
+
+func TestRebaseOID(t *testing.T) {
+	testData := []struct {
+		oid  string
+		want string
+	}{
+		{myOID, ".1.3.6.1.4.1.99999"},
+		{myOID + ".3.1", ".1.3.6.1.4.1.99999.3.1"},
+		{myOID + "5.1", myOID + "5.1"},
+		{".1.3.6.1.2.1.1.3.0", ".1.3.6.1.2.1.1.3.0"},
+	}
+
+	for _, tc := range testData {
+		if got := rebaseOID(tc.oid, myOID, ".1.3.6.1.4.1.99999"); got != tc.want {
+			t.Errorf("rebaseOID(%s) => got: %s, want: %s", tc.oid, got, tc.want)
+		}
+	}
+}
+
+func TestSnmpMergeNewOIDs(t *testing.T) {
+	s := &snmp{
+		oidData:  make(map[string]*snmpData),
+		oidCycle: make(map[string]int),
+	}
+	for _, oid := range []string{".1.3.6.10", ".1.3.6.2.1", ".1.3.6.2", ".1.3.6.9.3", ".1.3.6.2.1", ".1.3.6.1"} {
+		s.addSnmpData(oid, "integer", 1)
+	}
+	s.mergeNewOIDs()
+	s.addSnmpData(".1.3.6.3", "integer", 1)
+	s.addSnmpData(".1.3.6.11", "integer", 1)
+	s.addSnmpData(".1.3.6.0", "integer", 1)
+	s.mergeNewOIDs()
+	want := []string{".1.3.6.0", ".1.3.6.1", ".1.3.6.2", ".1.3.6.2.1", ".1.3.6.3", ".1.3.6.9.3", ".1.3.6.10", ".1.3.6.11"}
+	if !reflect.DeepEqual(s.oids, want) {
+		t.Errorf("mergeNewOIDs => oids got: %v, want: %v", s.oids, want)
+	}
+	if len(s.newOIDs) != 0 {
+		t.Errorf("mergeNewOIDs => newOIDs got: %v, want none", s.newOIDs)
+	}
+}
+
+func TestSnmpConcurrentReads(t *testing.T) {
+	s := &snmp{
+		snmpTalker: &testTalker{},
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{},
+		identity:   myName,
+	}
+	s.begin()
+	s.erase()
+	s.commit()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(0); i < 100; i++ {
+			s.begin()
+			s.erase()
+			s.addData(&parsedData{"eth0:1:0", i, i, 0, 0, nil, 2, 0})
+			s.addData(&parsedData{fmt.Sprintf("eth0:1:%d", i%3), i, i, 0, 0, nil, 2, 0})
+			s.commit()
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		current := s.snapshot()
+		for i := 1; i < len(current.data); i++ {
+			if !oidLess(current.data[i-1].oid, current.data[i].oid) {
+				t.Fatalf("snapshot() => OIDs %s and %s aren't sorted", current.data[i-1].oid, current.data[i].oid)
+			}
+		}
+	}
+}
+
+func TestSnmpCounterReset(t *testing.T) {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+	}
+	user := &UserClass{UploadDirection, "user1"}
+	sentBytesOIDs := []string{
+		".1.3.6.1.4.1.2021.255.4.1",
+		".1.3.6.1.4.1.2021.255.15.1",
+		".1.3.6.1.4.1.2021.255.33.1",
+	}
+
+	// The Qdisc is replaced in the second cycle, which resets its counters.
+	for i, sentBytes := range []int64{1000, 100, 300} {
+		s.begin()
+		s.erase()
+		s.addData(&parsedData{"eth0:1:0", sentBytes, 10, 0, 0, nil, 2, 0})
+		s.addData(&parsedData{"eth0:1:0", sentBytes, 10, 0, 0, user, 2, 0})
+		s.addGroupData(&parsedData{name: "wan", sentBytes: sentBytes, sentPkt: 10})
+		s.commit()
+
+		want := []int64{1000, 1100, 1300}[i]
+		for _, oid := range sentBytesOIDs {
+			if got := s.oidData[oid].objectValue; got != want {
+				t.Errorf("cycle %d with sent bytes %d => %s got: %v, want: %d", i+1, sentBytes, oid, got, want)
+			}
+		}
+	}
+}
+
+func TestSnmpRetention(t *testing.T) {
+	const (
+		nameOID   = ".1.3.6.1.4.1.2021.255.3.2"
+		bytesOID  = ".1.3.6.1.4.1.2021.255.4.2"
+		staleOID  = ".1.3.6.1.4.1.2021.255.52.2"
+		statusOID = ".1.3.6.1.4.1.2021.255.72.2"
+	)
+	// cycles are the Classes in the TC output of each cycle, eth0:1:10 disappears after the first one and comes back in the last one.
+	cycles := [][]string{
+		{"eth0:1:0", "eth0:1:10"},
+		{"eth0:1:0"},
+		{"eth0:1:0"},
+		{"eth0:1:0"},
+		{"eth0:1:0", "eth0:1:10"},
+	}
+	testData := []struct {
+		desc      string
+		retention int
+		// want are the values of nameOID, bytesOID, staleOID and statusOID after each cycle, nil if the OID shouldn't exist.
+		want [][]interface{}
+	}{
+		{
+			desc: "dropped immediately without retention",
+			want: [][]interface{}{
+				{"eth0:1:10", int64(100), nil, entryActive},
+				{nil, nil, nil, nil},
+				{nil, nil, nil, nil},
+				{nil, nil, nil, nil},
+				{"eth0:1:10", int64(100), nil, entryActive},
+			},
+		},
+		{
+			desc:      "retained with the last values",
+			retention: 2,
+			want: [][]interface{}{
+				{"eth0:1:10", int64(100), 0, entryActive},
+				{"eth0:1:10", int64(100), 1, entryStale},
+				{"eth0:1:10", int64(100), 1, entryRemovePending},
+				{nil, nil, nil, nil},
+				{"eth0:1:10", int64(100), 0, entryActive},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &snmp{
+				logger:   &fakeSyslog{},
+				options:  &SnmpOptions{Retention: tc.retention},
+				identity: myName,
+			}
+			for i, names := range cycles {
+				s.begin()
+				s.erase()
+				for _, name := range names {
+					s.addData(&parsedData{name, 100, 1, 0, 0, nil, 2, 0})
+				}
+				s.commit()
+
+				for j, oid := range []string{nameOID, bytesOID, staleOID, statusOID} {
+					var got interface{}
+					if data, ok := s.oidData[oid]; ok {
+						got = data.objectValue
+					}
+					if want := tc.want[i][j]; got != want {
+						t.Errorf("cycle %d => %s got: %v, want: %v", i+1, oid, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSnmpStableIndexes(t *testing.T) {
+	const nameLeafOID = ".1.3.6.1.4.1.2021.255.3"
+	// cycles are the Classes in the TC output of each cycle, eth0:1:20 disappears and eth0:1:40 appears in the middle.
+	cycles := [][]string{
+		{"eth0:1:10", "eth0:1:20", "eth0:1:30"},
+		{"eth0:1:10", "eth0:1:30"},
+		{"eth0:1:10", "eth0:1:30", "eth0:1:40"},
+	}
+	testData := []struct {
+		desc      string
+		retention int
+		// want are the walked names by their OIDs after each cycle.
+		want []map[string]string
+	}{
+		{
+			desc: "the released index is reused",
+			want: []map[string]string{
+				{nameLeafOID + ".1": "eth0:1:10", nameLeafOID + ".2": "eth0:1:20", nameLeafOID + ".3": "eth0:1:30"},
+				{nameLeafOID + ".1": "eth0:1:10", nameLeafOID + ".3": "eth0:1:30"},
+				{nameLeafOID + ".1": "eth0:1:10", nameLeafOID + ".2": "eth0:1:40", nameLeafOID + ".3": "eth0:1:30"},
+			},
+		},
+		{
+			desc:      "the index is kept during the retention",
+			retention: 1,
+			want: []map[string]string{
+				{nameLeafOID + ".1": "eth0:1:10", nameLeafOID + ".2": "eth0:1:20", nameLeafOID + ".3": "eth0:1:30"},
+				{nameLeafOID + ".1": "eth0:1:10", nameLeafOID + ".2": "eth0:1:20", nameLeafOID + ".3": "eth0:1:30"},
+				{nameLeafOID + ".1": "eth0:1:10", nameLeafOID + ".3": "eth0:1:30", nameLeafOID + ".4": "eth0:1:40"},
+			},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			tr := &testTalker{}
+			s := &snmp{
+				snmpTalker: tr,
+				logger:     &fakeSyslog{},
+				options:    &SnmpOptions{Retention: tc.retention},
+				identity:   myName,
+			}
+			for i, names := range cycles {
+				s.begin()
+				s.erase()
+				for _, name := range names {
+					s.addData(&parsedData{name, 100, 1, 0, 0, nil, 2, 0})
+				}
+				s.commit()
+
+				got := make(map[string]string)
+				for oid := nameLeafOID; ; {
+					tr.erase()
+					s.snmpGetNext(oid)
+					if len(tr.output) != 3 || !strings.HasPrefix(tr.output[0], nameLeafOID+".") {
+						break
+					}
+					oid = tr.output[0]
+					got[oid] = tr.output[2]
+				}
+				if diff := pretty.Compare(tc.want[i], got); diff != "" {
+					t.Errorf("cycle %d => unexpected names walked, diff(-want, +got):\n%s", i+1, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestSnmpPruneTrackers(t *testing.T) {
+	testData := []struct {
+		desc      string
+		retention int
+		// failed marks the cycles in which TC failed, these add no data.
+		failed []bool
+		// want is whether the state of eth0:1:10, which disappears after the first cycle, is kept after each cycle.
+		want []bool
+	}{
+		{
+			desc:   "dropped after the cycle it disappeared in",
+			failed: []bool{false, false, false, false},
+			want:   []bool{true, true, false, false},
+		},
+		{
+			desc:      "dropped once the retention expires",
+			retention: 2,
+			failed:    []bool{false, false, false, false, false},
+			want:      []bool{true, true, true, true, false},
+		},
+		{
+			desc:   "kept over the failed cycles",
+			failed: []bool{false, true, true, false, false},
+			want:   []bool{true, true, true, true, false},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &snmp{
+				logger:   &fakeSyslog{},
+				options:  &SnmpOptions{Retention: tc.retention},
+				identity: myName,
+			}
+			john := &UserClass{DownloadDirection, "john"}
+			for i, failed := range tc.failed {
+				s.begin()
+				s.erase()
+				switch {
+				case failed:
+					s.setHealth(1, 5)
+				case i == 0:
+					s.setHealth(0, 0)
+					s.addData(&parsedData{"eth0:1:0", 100, 1, 0, 0, nil, 2, 0})
+					s.addData(&parsedData{"eth0:1:10", 100, 1, 0, 0, nil, 2, 0})
+					s.addData(&parsedData{"eth0:1:10", 100, 1, 0, 0, john, 2, 0})
+				default:
+					s.setHealth(0, 0)
+					s.addData(&parsedData{"eth0:1:0", 100, 1, 0, 0, nil, 2, 0})
+				}
+				s.commit()
+
+				_, inTotals := s.tcTotals.last["eth0:1:10"]
+				_, inCounters := s.tcCounters.last["eth0:1:10"]
+				_, inUsers := s.userTotals.last[john.key()]
+				if inTotals != tc.want[i] || inCounters != tc.want[i] {
+					t.Errorf("cycle %d => the state of eth0:1:10 is kept: %v, %v, want: %v", i+1, inTotals, inCounters, tc.want[i])
+				}
+				// The users aren't retained.
+				if tc.retention == 0 && inUsers != tc.want[i] {
+					t.Errorf("cycle %d => the state of john is kept: %v, want: %v", i+1, inUsers, tc.want[i])
+				}
+				if _, ok := s.tcTotals.last["eth0:1:0"]; !ok {
+					t.Errorf("cycle %d => the state of eth0:1:0 was dropped", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestSnmpExport(t *testing.T) {
+	const (
+		nameOID     = ".1.3.6.1.4.1.2021.255.3.1"
+		userNameOID = ".1.3.6.1.4.1.2021.255.10.1"
+	)
+	disabled := false
+	testData := []struct {
+		desc     string
+		options  *SnmpOptions
+		wantName bool
+		wantUser bool
+	}{
+		{
+			desc:     "both exported by default",
+			options:  &SnmpOptions{},
+			wantName: true,
+			wantUser: true,
+		},
+		{
+			desc:     "only the users",
+			options:  &SnmpOptions{ExportGeneric: &disabled},
+			wantUser: true,
+		},
+		{
+			desc:     "only the Qdiscs / Classes",
+			options:  &SnmpOptions{ExportUsers: &disabled},
+			wantName: true,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &snmp{
+				logger:   &fakeSyslog{},
+				options:  tc.options,
+				identity: myName,
+			}
+			s.begin()
+			s.erase()
+			s.addData(&parsedData{"eth0:1:0", 100, 1, 0, 0, nil, 2, 0})
+			s.addData(&parsedData{"eth0:1:10", 100, 1, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0})
+			s.commit()
+
+			if _, got := s.oidData[nameOID]; got != tc.wantName {
+				t.Errorf("addData => %s exported: %v, want: %v", nameOID, got, tc.wantName)
+			}
+			if _, got := s.oidData[userNameOID]; got != tc.wantUser {
+				t.Errorf("addData => %s exported: %v, want: %v", userNameOID, got, tc.wantUser)
+			}
+		})
+	}
+}
+
+func TestSnmpExportMetrics(t *testing.T) {
+	s := &snmp{
+		logger:       &fakeSyslog{},
+		options:      &SnmpOptions{},
+		identity:     myName,
+		hiddenLeaves: hiddenLeaves([]string{"sentBytes", "userUpBytes"}),
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:0", 100, 1, 0, 0, nil, 2, 0})
+	s.addData(&parsedData{"eth0:1:10", 100, 1, 0, 0, &UserClass{UploadDirection, "user1"}, 2, 0})
+	s.commit()
+
+	for _, oid := range []string{".1.3.6.1.4.1.2021.255.3.1", ".1.3.6.1.4.1.2021.255.4.1", ".1.3.6.1.4.1.2021.255.15.1"} {
+		if _, ok := s.oidData[oid]; !ok {
+			t.Errorf("addData => %s not exported, want it exported", oid)
+		}
+	}
+	for _, oid := range []string{".1.3.6.1.4.1.2021.255.5.1", ".1.3.6.1.4.1.2021.255.7.1", ".1.3.6.1.4.1.2021.255.16.1"} {
+		if _, ok := s.oidData[oid]; ok {
+			t.Errorf("addData => %s exported, want it left out", oid)
+		}
+	}
+}
+
+func TestNameOID(t *testing.T) {
+	testData := []struct {
+		name   string
+		want   string
+		wantOk bool
+	}{
+		{"eth0:2:3", ".1.3.6.1.4.1.2021.255.81.8.101.116.104.48.58.50.58.51", true},
+		{"", ".1.3.6.1.4.1.2021.255.81.0", true},
+		{strings.Repeat("x", maxEncodedName+1), "", false},
+	}
+
+	for _, tc := range testData {
+		got, ok := nameOID(tcNameToIndexLeaf, tc.name)
+		if got != tc.want || ok != tc.wantOk {
+			t.Errorf("nameOID(%q) => got: %s, %v, want: %s, %v", tc.name, got, ok, tc.want, tc.wantOk)
+		}
+	}
+}
+
+func TestOidLeaf(t *testing.T) {
+	testData := []struct {
+		oid  string
+		want int
+	}{
+		{".1.3.6.1.4.1.2021.255", 0},
+		{".1.3.6.1.4.1.2021.255.4", 4},
+		{".1.3.6.1.4.1.2021.255.44.12", 44},
+		{".1.3.6.1.4.1.2021.255.99.1", 99},
+		{".1.3.6.1.4.1.2021.2550.4", 0},
+	}
+
+	for _, tc := range testData {
+		if got := oidLeaf(tc.oid); got != tc.want {
+			t.Errorf("oidLeaf(%s) => got: %d, want: %d", tc.oid, got, tc.want)
+		}
+	}
+}
+
+func TestSnmpUserQuota(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	quota, err := loadQuota(path, 1)
+	if err != nil {
+		t.Fatalf("loadQuota => unexpected error: %s", err)
+	}
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+		quota:    quota,
+	}
+	const (
+		upMonthBytesOID   = ".1.3.6.1.4.1.2021.255.54.1"
+		downMonthBytesOID = ".1.3.6.1.4.1.2021.255.53.1"
+	)
+	for _, sentBytes := range []int64{1000, 1500} {
+		s.begin()
+		s.erase()
+		s.addData(&parsedData{"user1", sentBytes, 10, 0, 0, &UserClass{UploadDirection, "user1"}, 0, 0})
+		s.addData(&parsedData{"user1", sentBytes * 2, 10, 0, 0, &UserClass{DownloadDirection, "user1"}, 0, 0})
+		s.commit()
+	}
+	if got := s.oidData[upMonthBytesOID].objectValue; got != int64(500) {
+		t.Errorf("addData => %s got: %v, want: 500", upMonthBytesOID, got)
+	}
+	if got := s.oidData[downMonthBytesOID].objectValue; got != int64(1000) {
+		t.Errorf("addData => %s got: %v, want: 1000", downMonthBytesOID, got)
+	}
+
+	persisted, err := loadQuota(path, 1)
+	if err != nil {
+		t.Fatalf("loadQuota of the persisted totals => unexpected error: %s", err)
+	}
+	if got := persisted.Users["user1:0"]; got == nil || got.Bytes != 500 || got.Last != 1500 {
+		t.Errorf("loadQuota of the persisted totals => user1:0 got: %v, want: {500 1500}", got)
+	}
+}
+
+func TestSnmpQuotaData(t *testing.T) {
+	quota, err := loadQuota(filepath.Join(t.TempDir(), "quota.json"), 1)
+	if err != nil {
+		t.Fatalf("loadQuota => unexpected error: %s", err)
+	}
+	logger := &fakeSyslog{}
+	s := &snmp{
+		logger:   logger,
+		options:  &SnmpOptions{Quotas: map[string]int64{"user1": 1000}},
+		identity: myName,
+		quota:    quota,
+	}
+	const (
+		exceededOID = ".1.3.6.1.4.1.2021.255.55.1"
+		usedOID     = ".1.3.6.1.4.1.2021.255.56.1"
+	)
+	steps := []struct {
+		upBytes      int64
+		downBytes    int64
+		wantExceeded int
+		wantUsed     int64
+		wantLogged   int
+	}{
+		{0, 0, 0, 0, 0},
+		{300, 400, 0, 70, 0},
+		{400, 500, 0, 90, 1},
+		{400, 550, 0, 95, 1},
+		{500, 600, 1, 110, 2},
+	}
+	for i, step := range steps {
+		s.begin()
+		s.erase()
+		s.addData(&parsedData{"user1", step.upBytes, 1, 0, 0, &UserClass{UploadDirection, "user1"}, 0, 0})
+		s.addData(&parsedData{"user1", step.downBytes, 1, 0, 0, &UserClass{DownloadDirection, "user1"}, 0, 0})
+		s.addData(&parsedData{"user2", step.downBytes, 1, 0, 0, &UserClass{DownloadDirection, "user2"}, 0, 0})
+		s.commit()
+
+		if got := s.oidData[exceededOID].objectValue; got != step.wantExceeded {
+			t.Errorf("step %d => %s got: %v, want: %d", i, exceededOID, got, step.wantExceeded)
+		}
+		if got := s.oidData[usedOID].objectValue; got != step.wantUsed {
+			t.Errorf("step %d => %s got: %v, want: %d", i, usedOID, got, step.wantUsed)
+		}
+		if got := len(logger.info); got != step.wantLogged {
+			t.Errorf("step %d => logged %d messages, want: %d, messages: %q", i, got, step.wantLogged, logger.info)
+		}
+	}
+	if _, ok := s.oidData[".1.3.6.1.4.1.2021.255.55.2"]; ok {
+		t.Errorf("user without a quota => found the quota exceeded leaf, want it missing")
+	}
+}
+
+// fakeNotifier implements notifier.
+type fakeNotifier struct {
+	// traps are the sent notifications.
+	traps []int
+
+	// variables are the variables of the sent notifications.
+	variables [][]snmpData
+
+	// closed is set when close() was called.
+	closed bool
+
+	// closeErr is the error returned by close().
+	closeErr error
+}
+
+func (f *fakeNotifier) notify(trap int, variables []snmpData) {
+	f.traps = append(f.traps, trap)
+	f.variables = append(f.variables, variables)
+}
+
+func (f *fakeNotifier) close(ctx context.Context) error {
+	f.closed = true
+	return f.closeErr
+}
+
+func TestSnmpParseFailureTrap(t *testing.T) {
+	notifier := &fakeNotifier{}
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+		notifier: notifier,
+	}
+	for _, failures := range []int{0, 1, 2, 0, 1} {
+		s.begin()
+		s.erase()
+		s.setHealth(failures, failures*10)
+		s.commit()
+	}
+	if want := []int{tcParseFailureTrap, tcParseFailureTrap}; !reflect.DeepEqual(notifier.traps, want) {
+		t.Fatalf("setHealth => sent: %v, want: %v", notifier.traps, want)
+	}
+	want := []snmpData{
+		{".1.3.6.1.4.1.2021.255.50", "integer", 1},
+		{".1.3.6.1.4.1.2021.255.51", "integer", 10},
+	}
+	if !reflect.DeepEqual(notifier.variables[0], want) {
+		t.Errorf("setHealth => sent variables: %v, want: %v", notifier.variables[0], want)
+	}
+}
+
+func TestSnmpSetHealthBeforeFirstCycle(t *testing.T) {
+	notifier := &fakeNotifier{}
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+		notifier: notifier,
+	}
+	s.setHealth(1, 10)
+	if want := []int{tcParseFailureTrap}; !reflect.DeepEqual(notifier.traps, want) {
+		t.Fatalf("setHealth => sent: %v, want: %v", notifier.traps, want)
+	}
+	s.begin()
+	s.erase()
+	s.commit()
+	for oid, want := range map[string]int{".1.3.6.1.4.1.2021.255.50": 1, ".1.3.6.1.4.1.2021.255.51": 10} {
+		if got := s.oidData[oid]; got == nil || got.objectValue != want {
+			t.Errorf("erase after setHealth => %s holds %v, want: %d", oid, got, want)
+		}
+	}
+}
+
+func TestSnmpDropRateTrap(t *testing.T) {
+	tests := []struct {
+		desc          string
+		exportMetrics []string
+	}{
+		{
+			desc: "all the metrics exported",
+		},
+		{
+			desc:          "the tcDropRateLeaf hidden by the exportMetrics",
+			exportMetrics: []string{"sentBytes"},
+		},
+	}
+	steps := []struct {
+		sentPkt    int64
+		droppedPkt int64
+		wantTraps  int
+	}{
+		{100, 0, 0},
+		// 10% isn't above the threshold.
+		{190, 10, 0},
+		{260, 40, 1},
+		// The drop rate stays high.
+		{300, 60, 1},
+		{400, 60, 1},
+		{450, 80, 2},
+	}
+	want := []snmpData{
+		{".1.3.6.1.4.1.2021.255.3.1", "string", "eth0:1:10"},
+		{".1.3.6.1.4.1.2021.255.57.1", "gauge", int64(30)},
+	}
+	for _, tc := range tests {
+		notifier := &fakeNotifier{}
+		s := &snmp{
+			logger:       &fakeSyslog{},
+			options:      &SnmpOptions{TrapDropRate: 10, ExportMetrics: tc.exportMetrics},
+			identity:     myName,
+			notifier:     notifier,
+			hiddenLeaves: hiddenLeaves(tc.exportMetrics),
+		}
+		for i, step := range steps {
+			s.begin()
+			s.erase()
+			s.addData(&parsedData{"eth0:1:10", 0, step.sentPkt, step.droppedPkt, 0, nil, 2, 0})
+			s.commit()
+			if got := len(notifier.traps); got != step.wantTraps {
+				t.Errorf("%s: step %d => sent %d notifications, want: %d", tc.desc, i, got, step.wantTraps)
+			}
+		}
+		if len(notifier.variables) == 0 || !reflect.DeepEqual(notifier.variables[0], want) {
+			t.Errorf("%s: addData => sent variables: %v, want: %v", tc.desc, notifier.variables, want)
+		}
+	}
+}
+
+func TestSnmpQuotaExceededTrap(t *testing.T) {
+	tests := []struct {
+		desc          string
+		exportMetrics []string
+	}{
+		{
+			desc: "all the metrics exported",
+		},
+		{
+			desc:          "the tcUserQuotaUsedLeaf hidden by the exportMetrics",
+			exportMetrics: []string{"sentBytes"},
+		},
+	}
+	want := []snmpData{
+		{".1.3.6.1.4.1.2021.255.10.1", "string", "user1"},
+		{".1.3.6.1.4.1.2021.255.56.1", "gauge", int64(110)},
+	}
+	for _, tc := range tests {
+		quota, err := loadQuota(filepath.Join(t.TempDir(), "quota.json"), 1)
+		if err != nil {
+			t.Fatalf("%s: loadQuota => unexpected error: %s", tc.desc, err)
+		}
+		notifier := &fakeNotifier{}
+		s := &snmp{
+			logger:       &fakeSyslog{},
+			options:      &SnmpOptions{Quotas: map[string]int64{"user1": 1000}, ExportMetrics: tc.exportMetrics},
+			identity:     myName,
+			quota:        quota,
+			notifier:     notifier,
+			hiddenLeaves: hiddenLeaves(tc.exportMetrics),
+		}
+		for _, upBytes := range []int64{0, 900, 1100, 1200} {
+			s.begin()
+			s.erase()
+			s.addData(&parsedData{"user1", upBytes, 1, 0, 0, &UserClass{UploadDirection, "user1"}, 0, 0})
+			s.commit()
+		}
+		if want := []int{tcQuotaExceededTrap}; !reflect.DeepEqual(notifier.traps, want) {
+			t.Errorf("%s: addData => sent: %v, want: %v", tc.desc, notifier.traps, want)
+			continue
+		}
+		if !reflect.DeepEqual(notifier.variables[0], want) {
+			t.Errorf("%s: addData => sent variables: %v, want: %v", tc.desc, notifier.variables[0], want)
+		}
+	}
+}
+
+func TestSnmpEvaluateAlerts(t *testing.T) {
+	logger := &fakeSyslog{}
+	notifier := &fakeNotifier{}
+	var hooks [][]string
+	s := &snmp{
+		logger: logger,
+		options: &SnmpOptions{
+			Alerts: []alertRule{
+				{metric: "droppedPkt", pattern: "eth0:2:*", rate: true, comparison: ">", threshold: 10, action: alertSyslog},
+				{metric: "sentBytes", pattern: "eth0:2:1", comparison: ">=", threshold: 1000, action: alertTrap},
+				{metric: "sentBytes", pattern: "eth0:1:*", comparison: ">", threshold: 100, action: alertExec, command: "/bin/hook"},
+			},
+		},
+		identity: myName,
+		notifier: notifier,
+		runHook: func(command string, args ...string) {
+			hooks = append(hooks, append([]string{command}, args...))
+		},
+	}
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		class1Bytes   int64
+		class2Bytes   int64
+		class2Dropped int64
+		wantLogged    int
+		wantTraps     int
+		wantHooks     int
+	}{
+		{50, 500, 0, 0, 0, 0},
+		// 5 dropped packets per second.
+		{50, 1500, 50, 0, 1, 0},
+		// 25 dropped packets per second.
+		{200, 2000, 300, 1, 1, 1},
+		// 10 dropped packets per second aren't above the threshold.
+		{300, 2500, 400, 1, 1, 1},
+		{300, 2500, 800, 2, 1, 1},
+	}
+	for i, step := range steps {
+		s.begin()
+		s.erase()
+		s.addData(&parsedData{"eth0:1:1", step.class1Bytes, 1, 0, 0, nil, 2, 0})
+		s.addData(&parsedData{"eth0:2:1", step.class2Bytes, 1, step.class2Dropped, 0, nil, 2, 0})
+		s.evaluateAlerts(start.Add(time.Duration(i) * 10 * time.Second))
+		s.commit()
+
+		var logged int
+		for _, message := range logger.info {
+			if strings.HasPrefix(message, "fireAlert()") {
+				logged++
+			}
+		}
+		if logged != step.wantLogged {
+			t.Errorf("step %d => logged %d alerts, want: %d, messages: %q", i, logged, step.wantLogged, logger.info)
+		}
+		if got := len(notifier.traps); got != step.wantTraps {
+			t.Errorf("step %d => sent %d notifications, want: %d", i, got, step.wantTraps)
+		}
+		if got := len(hooks); got != step.wantHooks {
+			t.Errorf("step %d => ran %d hooks, want: %d", i, got, step.wantHooks)
+		}
+	}
+
+	wantVariables := []snmpData{
+		{".1.3.6.1.4.1.2021.255.3.2", "string", "eth0:2:1"},
+		{".1.3.6.1.4.1.2021.255.4.2", "counter64", int64(1500)},
+	}
+	if len(notifier.variables) == 0 || !reflect.DeepEqual(notifier.variables[0], wantVariables) {
+		t.Errorf("evaluateAlerts => sent variables: %v, want: %v", notifier.variables, wantVariables)
+	}
+	if want := [][]string{{"/bin/hook", "sentBytes", "eth0:1:1", "200", "100"}}; !reflect.DeepEqual(hooks, want) {
+		t.Errorf("evaluateAlerts => ran hooks: %q, want: %q", hooks, want)
+	}
+}
+
+func TestSnmpRates(t *testing.T) {
+	s := &snmp{
+		logger: &fakeSyslog{},
+		options: &SnmpOptions{
+			RateSamples: 20,
+		},
+		identity: myName,
+	}
+	start := time.Hour
+	cycles := []struct {
+		classBytes int64
+		userBytes  int64
+	}{
+		{0, 0},
+		{7500, 600},
+		{15000, 1200},
+		{60000, 1800},
+	}
+	for i, cycle := range cycles {
+		s.begin()
+		s.erase()
+		monotonic := start + time.Duration(i)*time.Minute
+		s.addData(&parsedData{"eth0:1:1", cycle.classBytes, 1, 0, 0, nil, 2, monotonic})
+		s.addData(&parsedData{"eth0:2:3", cycle.userBytes, 1, 0, 0, &UserClass{0, "username"}, 2, monotonic})
+		s.commit()
+	}
+
+	want := map[string]snmpData{
+		".1.3.6.1.4.1.2021.255.58.1": {".1.3.6.1.4.1.2021.255.58.1", "gauge", int64(6000)},
+		".1.3.6.1.4.1.2021.255.59.1": {".1.3.6.1.4.1.2021.255.59.1", "gauge", int64(2666)},
+		".1.3.6.1.4.1.2021.255.60.1": {".1.3.6.1.4.1.2021.255.60.1", "gauge", int64(2666)},
+		".1.3.6.1.4.1.2021.255.61.1": {".1.3.6.1.4.1.2021.255.61.1", "gauge", int64(6000)},
+		".1.3.6.1.4.1.2021.255.66.1": {".1.3.6.1.4.1.2021.255.66.1", "gauge", int64(80)},
+		".1.3.6.1.4.1.2021.255.67.1": {".1.3.6.1.4.1.2021.255.67.1", "gauge", int64(80)},
+		".1.3.6.1.4.1.2021.255.68.1": {".1.3.6.1.4.1.2021.255.68.1", "gauge", int64(80)},
+		".1.3.6.1.4.1.2021.255.69.1": {".1.3.6.1.4.1.2021.255.69.1", "gauge", int64(80)},
+	}
+	for oid, want := range want {
+		got, ok := s.oidData[oid]
+		if !ok {
+			t.Errorf("oidData[%s] => not found, want: %v", oid, want)
+			continue
+		}
+		if *got != want {
+			t.Errorf("oidData[%s] => got: %v, want: %v", oid, *got, want)
+		}
+	}
+	if got, ok := s.oidData[".1.3.6.1.4.1.2021.255.62.1"]; ok {
+		t.Errorf("oidData[.1.3.6.1.4.1.2021.255.62.1] => got: %v, want it missing", *got)
+	}
+
+	// The rates of a vanished Class are forgotten.
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:2:3", 2400, 1, 0, 0, &UserClass{0, "username"}, 2, start + 4*time.Minute})
+	s.commit()
+	if _, ok := s.tcRates["eth0:1:1"]; ok {
+		t.Errorf("tcRates[eth0:1:1] => found, want it pruned")
+	}
+	if got, ok := s.oidData[".1.3.6.1.4.1.2021.255.58.1"]; ok {
+		t.Errorf("oidData[.1.3.6.1.4.1.2021.255.58.1] => got: %v, want it missing", *got)
+	}
+	if got := s.userRates["username:0"].count; got != 5 {
+		t.Errorf("userRates[username:0].count => got: %d, want: 5", got)
+	}
+}
+
+func TestSnmpDeltas(t *testing.T) {
+	s := &snmp{
+		logger: &fakeSyslog{},
+		options: &SnmpOptions{
+			ExportDeltas: true,
+		},
+		identity: myName,
+	}
+	start := time.Hour
+	cycles := []struct {
+		classBytes int64
+		userBytes  int64
+	}{
+		{1000, 100},
+		{7500, 600},
+		// The counters of the Class were reset, the bytes are counted again from zero.
+		{500, 1600},
+	}
+	for i, cycle := range cycles {
+		s.begin()
+		s.erase()
+		monotonic := start + time.Duration(i)*1500*time.Millisecond
+		s.addData(&parsedData{"eth0:1:1", cycle.classBytes, int64(i + 1), 0, 0, nil, 2, monotonic})
+		s.addData(&parsedData{"eth0:2:3", cycle.userBytes, int64(i + 1), 0, 0, &UserClass{DownloadDirection, "username"}, 2, monotonic})
+		s.commit()
+	}
+
+	want := map[string]snmpData{
+		".1.3.6.1.4.1.2021.255.100.1": {".1.3.6.1.4.1.2021.255.100.1", "gauge", int64(500)},
+		".1.3.6.1.4.1.2021.255.101.1": {".1.3.6.1.4.1.2021.255.101.1", "gauge", int64(1)},
+		".1.3.6.1.4.1.2021.255.102.1": {".1.3.6.1.4.1.2021.255.102.1", "gauge", int64(1000)},
+		".1.3.6.1.4.1.2021.255.103.1": {".1.3.6.1.4.1.2021.255.103.1", "gauge", int64(1)},
+		".1.3.6.1.4.1.2021.255.106":   {".1.3.6.1.4.1.2021.255.106", "gauge", int64(1500)},
+	}
+	for oid, want := range want {
+		got, ok := s.oidData[oid]
+		if !ok {
+			t.Errorf("oidData[%s] => not found, want: %v", oid, want)
+			continue
+		}
+		if *got != want {
+			t.Errorf("oidData[%s] => got: %v, want: %v", oid, *got, want)
+		}
+	}
+	for _, oid := range []string{".1.3.6.1.4.1.2021.255.104.1", ".1.3.6.1.4.1.2021.255.105.1"} {
+		if got, ok := s.oidData[oid]; ok {
+			t.Errorf("oidData[%s] => got: %v, want it missing", oid, *got)
+		}
+	}
+}
+
+func TestSnmpMonotonic(t *testing.T) {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+	}
+	monotonic := 90*time.Minute + 1500*time.Millisecond
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:1", 100, 1, 0, 0, nil, 2, monotonic})
+	s.addData(&parsedData{"eth0:2:3", 50, 1, 0, 0, &UserClass{DownloadDirection, "username"}, 2, monotonic})
+	s.addGroupData(&parsedData{name: "office", sentBytes: 100, sentPkt: 1, monotonic: monotonic})
+	s.addIfaceData(&parsedData{name: "eth0", sentBytes: 100, sentPkt: 1, monotonic: monotonic})
+	// The data that wasn't stamped has no timestamp.
+	s.addData(&parsedData{"eth0:1:2", 100, 1, 0, 0, nil, 2, 0})
+	s.commit()
+
+	for _, oid := range []string{".1.3.6.1.4.1.2021.255.107.1", ".1.3.6.1.4.1.2021.255.108.1", ".1.3.6.1.4.1.2021.255.109.1", ".1.3.6.1.4.1.2021.255.110.1"} {
+		want := snmpData{oid, "counter64", int64(5401500)}
+		got, ok := s.oidData[oid]
+		if !ok {
+			t.Errorf("oidData[%s] => not found, want: %v", oid, want)
+			continue
+		}
+		if *got != want {
+			t.Errorf("oidData[%s] => got: %v, want: %v", oid, *got, want)
+		}
+	}
+	if got, ok := s.oidData[".1.3.6.1.4.1.2021.255.107.2"]; ok {
+		t.Errorf("oidData[.1.3.6.1.4.1.2021.255.107.2] => got: %v, want it missing", *got)
+	}
+}
+
+// fakeSink implements metricSink.
+type fakeSink struct {
+	values [][]metricValue
+
+	// closed is set when close() was called.
+	closed bool
+
+	// closeErr is the error returned by close().
+	closeErr error
+}
+
+func (f *fakeSink) flush(values []metricValue, at time.Time) {
+	f.values = append(f.values, values)
+}
+
+func (f *fakeSink) close(ctx context.Context) error {
+	f.closed = true
+	return f.closeErr
+}
+
+func TestSnmpFlushSinks(t *testing.T) {
+	sink := &fakeSink{}
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+		sinks:    []metricSink{sink},
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:1", 100, 2, 1, 0, nil, 2, 0})
+	s.addData(&parsedData{"eth0:2:3", 50, 1, 0, 0, &UserClass{0, "username"}, 2, 0})
+	s.commit()
+	// The skipped cycles aren't flushed again.
+	s.begin()
+	s.commit()
+
+	if len(sink.values) != 1 {
+		t.Fatalf("flushSinks => flushed %d times, want: 1", len(sink.values))
+	}
+	want := []metricValue{
+		{"droppedPkt", tcNameLeaf, "eth0:1:1", int64(1)},
+		{"overLimitPkt", tcNameLeaf, "eth0:1:1", int64(0)},
+		{"sentBytes", tcNameLeaf, "eth0:1:1", int64(100)},
+		{"sentPkt", tcNameLeaf, "eth0:1:1", int64(2)},
+		{"userUpBytes", tcUserNameLeaf, "username", int64(50)},
+		{"userUpDroppedPkt", tcUserNameLeaf, "username", int64(0)},
+		{"userUpOverLimitPkt", tcUserNameLeaf, "username", int64(0)},
+		{"userUpPkt", tcUserNameLeaf, "username", int64(1)},
+	}
+	if !reflect.DeepEqual(sink.values[0], want) {
+		t.Errorf("flushSinks => got: %v, want: %v", sink.values[0], want)
+	}
+}
+
+func TestSnmpFlushSinksUserNames(t *testing.T) {
+	sink := &fakeSink{}
+	s, err := NewSnmp(&fakeSyslog{}, WithSnmpOptions(&SnmpOptions{UserNames: userNamesPseudonym, UserNameKey: "secret"}))
+	if err != nil {
+		t.Fatalf("NewSnmp => unexpected error: %s", err)
+	}
+	s.sinks = []metricSink{sink}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:2:3", 50, 1, 0, 0, &UserClass{0, "john"}, 2, 0})
+	s.commit()
+
+	if len(sink.values) != 1 {
+		t.Fatalf("flushSinks => flushed %d times, want: 1", len(sink.values))
+	}
+	for _, v := range sink.values[0] {
+		if v.name != "337e3f715bf0aaed" {
+			t.Errorf("flushSinks => got the user %s, want the pseudonym 337e3f715bf0aaed", v.name)
+		}
+	}
+	// The SNMP tree keeps the names.
+	if got := s.oidData[indexOID(tcUserNameLeaf, 1)].objectValue; got != "john" {
+		t.Errorf("oidData[tcUserNameLeaf.1] => got: %v, want: john", got)
+	}
+
+	if _, err := NewSnmp(&fakeSyslog{}, WithSnmpOptions(&SnmpOptions{UserNames: userNamesPseudonym})); err == nil {
+		t.Errorf("NewSnmp with the pseudonyms without a key => got no error, want an error")
+	}
+}
+
+func TestSnmpFireAlertUserNames(t *testing.T) {
+	notifier := &fakeNotifier{}
+	var hooks [][]string
+	s := &snmp{
+		logger:        &fakeSyslog{},
+		options:       &SnmpOptions{},
+		notifier:      notifier,
+		pseudonymizer: &pseudonymizer{},
+		runHook: func(command string, args ...string) {
+			hooks = append(hooks, append([]string{command}, args...))
+		},
+	}
+	nameData := snmpData{indexOID(tcUserNameLeaf, 1), "string", "john"}
+	data := snmpData{indexOID(tcUserDownBytesLeaf, 1), "counter64", int64(2000)}
+	s.fireAlert(alertRule{metric: "userDownBytes", comparison: ">", threshold: 1000, action: alertTrap}, "john", 2000, nameData, data)
+	s.fireAlert(alertRule{metric: "userDownBytes", comparison: ">", threshold: 1000, action: alertExec, command: "/bin/hook"}, "john", 2000, nameData, data)
+
+	if len(notifier.variables) != 1 || notifier.variables[0][0].objectValue != "96d9632f363564cc" {
+		t.Errorf("fireAlert => got the notifications %v, want the pseudonym 96d9632f363564cc of the user", notifier.variables)
+	}
+	want := [][]string{{"/bin/hook", "userDownBytes", "96d9632f363564cc", "2000", "1000"}}
+	if !reflect.DeepEqual(hooks, want) {
+		t.Errorf("fireAlert => got the hooks %v, want: %v", hooks, want)
+	}
+}
+
+func TestSnmpClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	quota, err := loadQuota(path, 1)
+	if err != nil {
+		t.Fatalf("loadQuota => unexpected error: %s", err)
+	}
+	quota.add("user1:0", 1000, time.Now())
+	notifier := &fakeNotifier{}
+	sinks := []*fakeSink{{closeErr: context.DeadlineExceeded}, {}}
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		quota:    quota,
+		notifier: notifier,
+		sinks:    []metricSink{sinks[0], sinks[1]},
+	}
+
+	err = s.Close(context.Background())
+	if want := "unable to close a sink: context deadline exceeded"; err == nil || err.Error() != want {
+		t.Errorf("Close => got error: %v, want: %s", err, want)
+	}
+	if !notifier.closed {
+		t.Errorf("Close => the notifier wasn't closed")
+	}
+	for i, sink := range sinks {
+		if !sink.closed {
+			t.Errorf("Close => the sink %d wasn't closed", i)
+		}
+	}
+	if s.notifier != nil || s.sinks != nil {
+		t.Errorf("Close => the notifier and the sinks are still set")
+	}
+	persisted, err := loadQuota(path, 1)
+	if err != nil {
+		t.Fatalf("loadQuota => unexpected error: %s", err)
+	}
+	if _, ok := persisted.Users["user1:0"]; !ok {
+		t.Errorf("Close => the quota totals weren't saved, got users: %v", persisted.Users)
+	}
+}
+
+func TestSnmpSetUnmatchedLines(t *testing.T) {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+	}
+	unmatchedOID := ".1.3.6.1.4.1.2021.255.83"
+
+	s.begin()
+	s.erase()
+	s.setUnmatchedLines(4)
+	s.commit()
+	if got := s.oidData[unmatchedOID].objectValue; got != int64(4) {
+		t.Errorf("setUnmatchedLines(4) => %s got: %v, want: 4", unmatchedOID, got)
+	}
+
+	// The count is kept across erase, e.g. in the cycles skipped while backing off.
+	s.begin()
+	s.erase()
+	s.commit()
+	if got := s.oidData[unmatchedOID].objectValue; got != int64(4) {
+		t.Errorf("erase() => %s got: %v, want: 4", unmatchedOID, got)
+	}
+}
+
+func TestSnmpSetHealth(t *testing.T) {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+	}
+	failuresOID := ".1.3.6.1.4.1.2021.255.50"
+	backoffOID := ".1.3.6.1.4.1.2021.255.51"
+
+	s.begin()
+	s.erase()
+	s.setHealth(3, 20)
+	s.commit()
+	if got := s.oidData[failuresOID].objectValue; got != 3 {
+		t.Errorf("setHealth(3, 20) => %s got: %v, want: 3", failuresOID, got)
+	}
+	if got := s.oidData[backoffOID].objectValue; got != 20 {
+		t.Errorf("setHealth(3, 20) => %s got: %v, want: 20", backoffOID, got)
+	}
+
+	// The health is kept across erase.
+	s.begin()
+	s.erase()
+	s.commit()
+	if got := s.oidData[failuresOID].objectValue; got != 3 {
+		t.Errorf("erase() => %s got: %v, want: 3", failuresOID, got)
+	}
+
+	s.begin()
+	s.setHealth(0, 0)
+	s.commit()
+	if got := s.oidData[backoffOID].objectValue; got != 0 {
+		t.Errorf("setHealth(0, 0) => %s got: %v, want: 0", backoffOID, got)
+	}
+	seen := make(map[string]bool)
+	for _, oid := range s.oids {
+		if seen[oid] {
+			t.Errorf("setHealth() => duplicate OID %s", oid)
+		}
+		seen[oid] = true
+	}
+}
+
+func TestSnmpAddXstats(t *testing.T) {
+	s := &snmp{
+		logger:   &fakeSyslog{},
+		options:  &SnmpOptions{},
+		identity: myName,
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:0", 1000, 10, 0, 0, nil, 2, 0})
+	s.addData(&parsedData{"eth0:10:0", 1000, 10, 0, 0, nil, 2, 0})
+	s.addXstats("eth0:10:0", []xstat{{"drop_overlimit", "2"}, {"ecn_mark", "7"}, {"maxpacket", "1514"}, {"ldelay", "2us"}})
+	s.addXstats("eth0:20:0", []xstat{{"drop_overlimit", "5"}})
+	s.commit()
+
+	want := map[string]snmpData{
+		".1.3.6.1.4.1.2021.255.39.1": {".1.3.6.1.4.1.2021.255.39.1", "integer", 1},
+		".1.3.6.1.4.1.2021.255.39.2": {".1.3.6.1.4.1.2021.255.39.2", "integer", 2},
+		".1.3.6.1.4.1.2021.255.40":   {".1.3.6.1.4.1.2021.255.40", "integer", 2},
+		".1.3.6.1.4.1.2021.255.41.1": {".1.3.6.1.4.1.2021.255.41.1", "integer", 2},
+		".1.3.6.1.4.1.2021.255.41.2": {".1.3.6.1.4.1.2021.255.41.2", "integer", 2},
+		".1.3.6.1.4.1.2021.255.42.1": {".1.3.6.1.4.1.2021.255.42.1", "string", "maxpacket"},
+		".1.3.6.1.4.1.2021.255.42.2": {".1.3.6.1.4.1.2021.255.42.2", "string", "ldelay"},
+		".1.3.6.1.4.1.2021.255.43.1": {".1.3.6.1.4.1.2021.255.43.1", "string", "1514"},
+		".1.3.6.1.4.1.2021.255.43.2": {".1.3.6.1.4.1.2021.255.43.2", "string", "2us"},
+		".1.3.6.1.4.1.2021.255.44.2": {".1.3.6.1.4.1.2021.255.44.2", "counter64", int64(2)},
+		".1.3.6.1.4.1.2021.255.45.2": {".1.3.6.1.4.1.2021.255.45.2", "counter64", int64(7)},
+	}
+	for oid, w := range want {
+		got, ok := s.oidData[oid]
+		if !ok {
+			t.Errorf("oidData[%s] => not found, want: %v", oid, w)
+			continue
+		}
+		if *got != w {
+			t.Errorf("oidData[%s] => got: %v, want: %v", oid, *got, w)
+		}
+	}
+	for _, oid := range []string{".1.3.6.1.4.1.2021.255.39.3", ".1.3.6.1.4.1.2021.255.44.1", ".1.3.6.1.4.1.2021.255.44.3"} {
+		if got, ok := s.oidData[oid]; ok {
+			t.Errorf("oidData[%s] => got: %v, want it missing", oid, *got)
+		}
+	}
+}
+
+func TestSnmpAddWarning(t *testing.T) {
+	testData := []struct {
+		desc            string
+		maxWarnings     int
+		warnings        []string
+		wantOIDData     map[string]snmpData
+		wantNumWarnings int64
+	}{
+		{
+			desc: "no warnings",
+			wantOIDData: map[string]snmpData{
+				".1.3.6.1.4.1.2021.255.21": {".1.3.6.1.4.1.2021.255.21", "counter64", int64(0)},
+			},
+		},
+		{
+			desc:     "warnings are exported in order",
+			warnings: []string{"first", "second"},
+			wantOIDData: map[string]snmpData{
+				".1.3.6.1.4.1.2021.255.20.1": {".1.3.6.1.4.1.2021.255.20.1", "string", "first"},
+				".1.3.6.1.4.1.2021.255.20.2": {".1.3.6.1.4.1.2021.255.20.2", "string", "second"},
+				".1.3.6.1.4.1.2021.255.21":   {".1.3.6.1.4.1.2021.255.21", "counter64", int64(2)},
+			},
+			wantNumWarnings: 2,
+		},
+		{
+			desc:        "only the most recent warnings are kept",
+			maxWarnings: 2,
+			warnings:    []string{"first", "second", "third"},
+			wantOIDData: map[string]snmpData{
+				".1.3.6.1.4.1.2021.255.20.1": {".1.3.6.1.4.1.2021.255.20.1", "string", "second"},
+				".1.3.6.1.4.1.2021.255.20.2": {".1.3.6.1.4.1.2021.255.20.2", "string", "third"},
+				".1.3.6.1.4.1.2021.255.21":   {".1.3.6.1.4.1.2021.255.21", "counter64", int64(3)},
+			},
+			wantNumWarnings: 3,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &snmp{
+				logger: &fakeSyslog{},
+				options: &SnmpOptions{
+					MaxWarnings: tc.maxWarnings,
+				},
+				oidData:  make(map[string]*snmpData),
+				oidCycle: make(map[string]int),
+			}
+			for _, warning := range tc.warnings {
+				s.addWarning(warning)
+			}
+			s.addWarningData()
+			if err := compareSnmpData(s.oidData, tc.wantOIDData); err != nil {
+				t.Errorf("addWarning => unexpected snmpData: %s", err)
+			}
+			if s.numWarnings != tc.wantNumWarnings {
+				t.Errorf("addWarning => numWarnings got: %d, want: %d", s.numWarnings, tc.wantNumWarnings)
+			}
+		})
+	}
+}
+
+// testTalker implements snmpTalker and is used in tests.
+type testTalker struct {
+	// input is a list of strings that should be returned by getLine().
+	input []string
+
+	// output is the list of strings that were added by calling putLine()
+	output []string
+}
+
+// getLine returns a single line from the predefined output.
+func (tr *testTalker) getLine() string {
+	if len(tr.input) < 1 {
+		panic("getLine(): unexpected call to getLine, got no more input data.")
+	}
+	input := tr.input[0]
+	tr.input = tr.input[1:]
+	return input
+}
+
+// putLine stores a single line inside testTalker struct.
+func (tr *testTalker) putLine(line string) {
+	tr.output = append(tr.output, line)
+}
+
+// erase deletes all stored inputs and output.
+func (tr *testTalker) erase() {
+	tr.input = make([]string, 0)
+	tr.output = make([]string, 0)
+}
+
+func TestSnmpListen(t *testing.T) {
+	// Store some data.
+	var p []*parsedData = []*parsedData{
+		{"eth0:2:3", 1, 2, 3, 4, &UserClass{0, "username"}, 7, 0},
+		{"eth1:2:3", 5, 6, 7, 8, &UserClass{1, "username"}, 7, 0},
+		{"eth0:1:3", 9, 10, math.MaxInt32, math.MaxInt32 + 1, nil, 7, 0},
+	}
+	tr := &testTalker{}
+	fs := &fakeSyslog{}
+	o := &SnmpOptions{}
+	s := &snmp{
+		snmpTalker: tr,
+		logger:     fs,
+		options:    o,
+		identity:   myName,
+	}
+	// The previous interval is needed to compute the average packet size.
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:2:3", 0, 0, 0, 0, &UserClass{0, "username"}, 7, 0})
+	s.commit()
+
+	s.begin()
+	s.erase()
+	for _, data := range p {
+		s.addData(data)
+	}
+	s.commit()
+
+	testData := []struct {
+		desc     string
+		commands []string
+		want     []string
+	}{
+
+		{
+			desc:     "simple PING-PONG exchange",
+			commands: []string{"PING", ""},
+			want:     []string{"PONG"},
+		},
+		{
+			desc:     "standard SNMP GET for a known OID - a string",
+			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.3.1", ""},
+			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.3.1", "string", "eth0:1:3"},
+		},
+		{
+			desc:     "standard SNMP GET for a known OID - an integer",
+			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.1.1", ""},
+			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.1.1", "integer", "1"},
+		},
+		{
+			desc:     "standard SNMP GET for a known OID - a counter64",
+			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.4.1", ""},
+			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.4.1", "counter64", "9"},
+		},
+		{
+			desc:     "standard SNMP GET for a known OID - a counter64, and the value is at the math.MaxInt32",
+			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.6.1", ""},
+			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.6.1", "counter64", strconv.Itoa(math.MaxInt32)},
+		},
+		{
+			desc:     "standard SNMP GET for a known OID - a counter64, and the value is larger than math.MaxInt32",
+			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.7.1", ""},
+			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.7.1", "counter64", strconv.Itoa(math.MaxInt32 + 1)},
+		},
+		{
+			desc:     "standard SNMP GET for a known OID - a gauge",
+			commands: []string{"PING", "get", ".1.3.6.1.4.1.2021.255.23.1", ""},
+			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.23.1", "gauge", "0"},
+		},
+		{
+			desc:     "standard SNMP GET for unknown OID",
+			commands: []string{"PING", "get", ".1.3.7", ""},
+			want:     []string{"PONG", ""},
+		},
+		{
+			desc:     "standard SNMP GET-NEXT for two known OIDs",
+			commands: []string{"PING", "getnext", ".1.3.6.1.4.1.2021.255.9", "getnext", ".1.3.6.1.4.1.2021.255.10", ""},
+			want:     []string{"PONG", ".1.3.6.1.4.1.2021.255.10", "string", "tcUserNameLeaf", ".1.3.6.1.4.1.2021.255.10.1", "string", "username"},
+		},
+		{
+			desc:     "standard SNMP GET-NEXT for the last OID",
+			commands: []string{"PING", "getnext", ".1.3.6.1.4.1.2021.255.83", ""},
+			want:     []string{"PONG", ""},
+		},
+		{
+			desc:     "standard SNMP GET-NEXT for unknown OID",
+			commands: []string{"PING", "getnext", ".1.3.7", ""},
+			want:     []string{"PONG", ""},
+		},
+		{
+			desc:     "unknown command",
+			commands: []string{"PING", "set", ""},
+			want:     []string{"PONG", ""},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			tr.erase()
+			tr.input = tc.commands
+			s.Listen()
+			if diff := pretty.Compare(tc.want, tr.output); diff != "" {
+				t.Errorf("Listen => unexpected output, diff (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+// fakeRefresher implements dataRefresher and is used in tests.
+type fakeRefresher struct {
+	// maxAges are the values of maxAge passed to refresh().
+	maxAges []time.Duration
+
+	// requests is the number of calls to requested().
+	requests int
+}
+
+func (fr *fakeRefresher) refresh(maxAge time.Duration) {
+	fr.maxAges = append(fr.maxAges, maxAge)
+}
+
+func (fr *fakeRefresher) requested() {
+	fr.requests++
+}
+
+func TestSnmpListenRefresh(t *testing.T) {
+	testData := []struct {
+		desc         string
+		maxDataAge   int
+		commands     []string
+		want         []time.Duration
+		wantRequests int
+	}{
+		{
+			desc:         "data isn't refreshed unless configured",
+			commands:     []string{"get", ".1.3.6.1.4.1.2021.255", "getnext", ".1.3.6.1.4.1.2021.255", ""},
+			wantRequests: 2,
+		},
+		{
+			desc:       "PING doesn't refresh the data",
+			maxDataAge: 10,
+			commands:   []string{"PING", ""},
+		},
+		{
+			desc:         "GET and GET-NEXT refresh stale data",
+			maxDataAge:   10,
+			commands:     []string{"get", ".1.3.6.1.4.1.2021.255", "getnext", ".1.3.6.1.4.1.2021.255", ""},
+			want:         []time.Duration{10 * time.Second, 10 * time.Second},
+			wantRequests: 2,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fr := &fakeRefresher{}
+			s := &snmp{
+				snmpTalker: &testTalker{input: tc.commands},
+				logger:     &fakeSyslog{},
+				options:    &SnmpOptions{MaxDataAge: tc.maxDataAge},
+				identity:   myName,
+				refresher:  fr,
+			}
+			s.begin()
+			s.erase()
+			s.commit()
+			s.Listen()
+			if !reflect.DeepEqual(fr.maxAges, tc.want) {
+				t.Errorf("Listen => refresh calls got: %v, want: %v", fr.maxAges, tc.want)
+			}
+			if fr.requests != tc.wantRequests {
+				t.Errorf("Listen => requested calls got: %d, want: %d", fr.requests, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func BenchmarkSnmpWalk10kClasses(b *testing.B) {
+	tr := &testTalker{}
+	s := &snmp{
+		snmpTalker: tr,
+		logger:     &fakeSyslog{},
+		options:    &SnmpOptions{},
+		identity:   myName,
+	}
+	s.begin()
+	s.erase()
+	for i := 0; i < 10000; i++ {
+		s.addData(&parsedData{fmt.Sprintf("eth0:1:%x", i), int64(i), int64(i), 0, 0, nil, 2, 0})
+	}
+	s.commit()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// A full snmpwalk, GET-NEXT is requested for every returned OID until the end of the tree.
+		oid := myOID
+		for {
+			tr.output = tr.output[:0]
+			s.snmpGetNext(oid)
+			if len(tr.output) != 3 {
+				break
+			}
+			oid = tr.output[0]
+		}
+	}
+}
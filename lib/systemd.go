@@ -0,0 +1,278 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+systemd.go integrates tc_reader with systemd as a service of Type=notify. The service manager is notified when the
+first parse cycle completed and pinged by the watchdog after every completed parse cycle, so that systemd restarts a
+tc_reader whose parse cycles got stuck. The parse cycles skipped during a blackout window, the idle pause or the
+backoff after TC failures ping the watchdog too, these are expected and restarting wouldn't help.
+
+SystemdUnit generates the unit that runs tc_reader as an exporter with the watchdog enabled.
+*/
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// notifySocketEnv is the environment variable with the socket of the service manager.
+	notifySocketEnv = "NOTIFY_SOCKET"
+
+	// watchdogUsecEnv is the environment variable with the timeout of the watchdog in microseconds.
+	watchdogUsecEnv = "WATCHDOG_USEC"
+
+	// watchdogPidEnv is the environment variable with the PID of the process the watchdog is enabled for.
+	watchdogPidEnv = "WATCHDOG_PID"
+
+	// minWatchdogSec is the shortest timeout of the watchdog in the generated unit.
+	minWatchdogSec = 30
+
+	// watchdogIntervals is the number of parse intervals without a completed parse cycle before the generated unit
+	// restarts tc_reader. The first two TC failures in a row are retried without a skipped parse cycle in between.
+	watchdogIntervals = 5
+)
+
+// The status messages reported to the service manager.
+const (
+	// statusCollecting is reported while the parse cycles succeed.
+	statusCollecting = "Collecting the TC statistics."
+
+	// statusMaintenance is reported during a blackout window.
+	statusMaintenance = "The collection is paused during a blackout window."
+
+	// statusFailingFormat is reported while backing off after TC failures, formatted with the number of failures.
+	statusFailingFormat = "TC failed %d times in a row, backing off."
+)
+
+// systemdUnitTemplate is the unit of the tc_reader service. It is formatted with the command line of tc_reader and
+// the timeout of the watchdog in seconds.
+const systemdUnitTemplate = `# The tc_reader service, generated by tc_reader -install-systemd.
+[Unit]
+Description=tc_reader exporter of the TC statistics
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s
+# The watchdog restarts tc_reader if no parse cycle completed for this long.
+WatchdogSec=%d
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// systemdNotifier implements dataSink, it notifies the service manager about the parse cycles. The tcParser calls it
+// under its lock, so it isn't safe for concurrent use otherwise.
+type systemdNotifier struct {
+	// socket is the socket of the service manager.
+	socket string
+
+	// watchdog indicates that the service manager expects the pings of the watchdog.
+	watchdog bool
+
+	// logger logs the failures to notify the service manager.
+	logger sysLogger
+
+	// erased indicates that the current parse cycle executed the TC commands.
+	erased bool
+
+	// failures is the number of consecutive failed parse cycles.
+	failures int
+
+	// maintenance indicates that the collection is paused during a blackout window.
+	maintenance bool
+
+	// ready indicates that the service manager was notified that tc_reader started up.
+	ready bool
+
+	// status is the status last reported to the service manager.
+	status string
+
+	// broken indicates that the last notification failed, the failures are logged once until one succeeds.
+	broken bool
+}
+
+// NewSystemdNotifier returns the notifier of the service manager if tc_reader was started by systemd as a service of
+// Type=notify, nil otherwise. The environment variables of the notifications are removed so that the commands run by
+// tc_reader don't inherit them.
+func NewSystemdNotifier(logger Logger) *systemdNotifier {
+	socket, usec, pid := os.Getenv(notifySocketEnv), os.Getenv(watchdogUsecEnv), os.Getenv(watchdogPidEnv)
+	for _, env := range []string{notifySocketEnv, watchdogUsecEnv, watchdogPidEnv} {
+		os.Unsetenv(env)
+	}
+	return newSystemdNotifier(socket, usec, pid, os.Getpid(), logger)
+}
+
+// newSystemdNotifier creates the notifier of the service manager listening on the socket, nil if the socket is empty.
+// The watchdog is enabled if the timeout in microseconds is set and the PID of the watchdog is either empty or ours.
+func newSystemdNotifier(socket, watchdogUsec, watchdogPid string, pid int, logger sysLogger) *systemdNotifier {
+	if socket == emptyString {
+		return nil
+	}
+	n := &systemdNotifier{
+		socket: socket,
+		logger: logger,
+	}
+	if usec, err := strconv.ParseInt(watchdogUsec, 10, 64); err == nil && usec > 0 {
+		n.watchdog = watchdogPid == emptyString || watchdogPid == strconv.Itoa(pid)
+	}
+	return n
+}
+
+// WithSystemdNotifier notifies the service manager about the parse cycles.
+func WithSystemdNotifier(notifier *systemdNotifier) TcParserOption {
+	return func(s *tcParserSetup) error {
+		if notifier == nil {
+			return errors.New("the systemd notifier must not be nil")
+		}
+		s.sinks = append(s.sinks, notifier)
+		return nil
+	}
+}
+
+// begin starts a parse cycle, it doesn't necessarily execute the TC commands.
+func (n *systemdNotifier) begin() {
+	n.erased = false
+}
+
+// commit notifies the service manager once the parse cycle completed, unless the TC commands failed in it. The first
+// completed parse cycle reports that tc_reader started up, the following ones ping the watchdog.
+func (n *systemdNotifier) commit() {
+	if n.erased && n.failures > 0 {
+		return
+	}
+	var state []string
+	if !n.ready {
+		state = append(state, "READY=1")
+	} else if n.watchdog {
+		state = append(state, "WATCHDOG=1")
+	}
+	if status := n.currentStatus(); status != n.status {
+		state = append(state, "STATUS="+status)
+	}
+	if len(state) > 0 && n.notify(strings.Join(state, "\n")) {
+		n.ready = true
+		n.status = n.currentStatus()
+	}
+}
+
+// erase starts a parse cycle that executes the TC commands.
+func (n *systemdNotifier) erase() {
+	n.erased = true
+}
+
+// addData ignores the data.
+func (n *systemdNotifier) addData(data *parsedData) {}
+
+// addGroupData ignores the data.
+func (n *systemdNotifier) addGroupData(data *parsedData) {}
+
+// addIfaceData ignores the data.
+func (n *systemdNotifier) addIfaceData(data *parsedData) {}
+
+// addXstats ignores the xstats.
+func (n *systemdNotifier) addXstats(name string, xstats []xstat) {}
+
+// addNetDevData ignores the kernel counters.
+func (n *systemdNotifier) addNetDevData(stats *netDevStats) {}
+
+// addConntrackData ignores the conntrack counters.
+func (n *systemdNotifier) addConntrackData(data *parsedData) {}
+
+// addWarning ignores the warning.
+func (n *systemdNotifier) addWarning(message string) {}
+
+// setMaintenance records whether the collection is paused during a blackout window.
+func (n *systemdNotifier) setMaintenance(active bool) {
+	n.maintenance = active
+}
+
+// setHealth records the number of consecutive failed parse cycles.
+func (n *systemdNotifier) setHealth(failures int, backoff int) {
+	n.failures = failures
+}
+
+// setUnmatchedLines ignores the unmatched lines.
+func (n *systemdNotifier) setUnmatchedLines(lines int) {}
+
+// Close notifies the service manager that tc_reader is stopping.
+func (n *systemdNotifier) Close() error {
+	if err := sdNotify(n.socket, "STOPPING=1"); err != nil {
+		return fmt.Errorf("unable to notify systemd on %s, error: %s", n.socket, err)
+	}
+	return nil
+}
+
+// currentStatus returns the status of the collection.
+func (n *systemdNotifier) currentStatus() string {
+	switch {
+	case n.maintenance:
+		return statusMaintenance
+	case n.failures > 0:
+		return fmt.Sprintf(statusFailingFormat, n.failures)
+	}
+	return statusCollecting
+}
+
+// notify sends the state to the service manager, returns whether it succeeded.
+func (n *systemdNotifier) notify(state string) bool {
+	if err := sdNotify(n.socket, state); err != nil {
+		if !n.broken {
+			n.logger.Err(fmt.Sprintf("notify(): Unable to notify systemd on %s, error: %s", n.socket, err))
+		}
+		n.broken = true
+		return false
+	}
+	n.broken = false
+	return true
+}
+
+// sdNotify sends the state to the socket of the service manager, see sd_notify(3). The sockets starting with '@' are
+// in the abstract namespace.
+func sdNotify(socket, state string) error {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// SystemdUnit returns the unit of the tc_reader service that runs the command as an exporter with the config file. The
+// watchdog restarts it if no parse cycle completed for five parse intervals of the options, but at least 30 seconds.
+func SystemdUnit(command, configFile string, options *TcParserOptions) string {
+	args := []string{command, "-exporter", "-config", configFile}
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t\"'\\") {
+			args[i] = strconv.Quote(arg)
+		}
+	}
+	watchdogSec := watchdogIntervals * options.parseIntervalSeconds()
+	if watchdogSec < minWatchdogSec {
+		watchdogSec = minWatchdogSec
+	}
+	return fmt.Sprintf(systemdUnitTemplate, strings.Join(args, " "), watchdogSec)
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenNotify listens on a socket of the service manager in a temporary directory.
+func listenNotify(t *testing.T) (string, *net.UnixConn) {
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram => unexpected error: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return socket, conn
+}
+
+// receiveNotify returns the state the service manager received, empty if none arrived.
+func receiveNotify(t *testing.T, conn *net.UnixConn) string {
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+func TestSystemdNotifier(t *testing.T) {
+	socket, conn := listenNotify(t)
+	n := newSystemdNotifier(socket, "30000000", "", 1, &fakeSyslog{})
+	if n == nil {
+		t.Fatalf("newSystemdNotifier => got nil, want a notifier")
+	}
+
+	testData := []struct {
+		desc  string
+		cycle func()
+		want  string
+	}{
+		{
+			desc:  "the first parse cycle reports the start up",
+			cycle: func() { n.erase() },
+			want:  "READY=1\nSTATUS=" + statusCollecting,
+		},
+		{
+			desc:  "a parse cycle pings the watchdog",
+			cycle: func() { n.erase() },
+			want:  "WATCHDOG=1",
+		},
+		{
+			desc:  "a failed parse cycle doesn't ping the watchdog",
+			cycle: func() { n.erase(); n.setHealth(1, 10) },
+		},
+		{
+			desc:  "a parse cycle skipped while backing off pings the watchdog",
+			cycle: func() {},
+			want:  "WATCHDOG=1\nSTATUS=TC failed 1 times in a row, backing off.",
+		},
+		{
+			desc:  "a recovered parse cycle reports the status",
+			cycle: func() { n.erase(); n.setHealth(0, 0) },
+			want:  "WATCHDOG=1\nSTATUS=" + statusCollecting,
+		},
+		{
+			desc:  "a parse cycle skipped during a blackout window pings the watchdog",
+			cycle: func() { n.setMaintenance(true) },
+			want:  "WATCHDOG=1\nSTATUS=" + statusMaintenance,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			n.begin()
+			tc.cycle()
+			n.commit()
+			if got := receiveNotify(t, conn); got != tc.want {
+				t.Errorf("commit => got state %q, want: %q", got, tc.want)
+			}
+		})
+	}
+
+	if err := n.Close(); err != nil {
+		t.Fatalf("Close => unexpected error: %s", err)
+	}
+	if got, want := receiveNotify(t, conn), "STOPPING=1"; got != want {
+		t.Errorf("Close => got state %q, want: %q", got, want)
+	}
+}
+
+func TestNewSystemdNotifier(t *testing.T) {
+	testData := []struct {
+		desc         string
+		socket       string
+		watchdogUsec string
+		watchdogPid  string
+		wantNil      bool
+		wantWatchdog bool
+	}{
+		{
+			desc:    "not started by systemd",
+			wantNil: true,
+		},
+		{
+			desc:   "without the watchdog",
+			socket: "@notify",
+		},
+		{
+			desc:         "with the watchdog",
+			socket:       "@notify",
+			watchdogUsec: "30000000",
+			wantWatchdog: true,
+		},
+		{
+			desc:         "with the watchdog of our PID",
+			socket:       "@notify",
+			watchdogUsec: "30000000",
+			watchdogPid:  "42",
+			wantWatchdog: true,
+		},
+		{
+			desc:         "with the watchdog of another PID",
+			socket:       "@notify",
+			watchdogUsec: "30000000",
+			watchdogPid:  "43",
+		},
+		{
+			desc:         "with an invalid watchdog timeout",
+			socket:       "@notify",
+			watchdogUsec: "never",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			n := newSystemdNotifier(tc.socket, tc.watchdogUsec, tc.watchdogPid, 42, &fakeSyslog{})
+			if (n == nil) != tc.wantNil {
+				t.Fatalf("newSystemdNotifier => got: %v, want nil: %t", n, tc.wantNil)
+			}
+			if n != nil && n.watchdog != tc.wantWatchdog {
+				t.Errorf("newSystemdNotifier => got watchdog: %t, want: %t", n.watchdog, tc.wantWatchdog)
+			}
+		})
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	testData := []struct {
+		desc         string
+		command      string
+		configFile   string
+		options      *TcParserOptions
+		wantExec     string
+		wantWatchdog string
+	}{
+		{
+			desc:         "default parse interval",
+			command:      "/usr/sbin/tc_reader",
+			configFile:   "/etc/tc_reader.conf",
+			wantExec:     "ExecStart=/usr/sbin/tc_reader -exporter -config /etc/tc_reader.conf\n",
+			wantWatchdog: "WatchdogSec=30\n",
+		},
+		{
+			desc:         "long parse interval",
+			command:      "/usr/sbin/tc_reader",
+			configFile:   "/etc/tc_reader.conf",
+			options:      &TcParserOptions{ParseInterval: time.Minute},
+			wantExec:     "ExecStart=/usr/sbin/tc_reader -exporter -config /etc/tc_reader.conf\n",
+			wantWatchdog: "WatchdogSec=300\n",
+		},
+		{
+			desc:         "paths with spaces",
+			command:      "/opt/tc reader/tc_reader",
+			configFile:   "/etc/tc reader.conf",
+			wantExec:     "ExecStart=\"/opt/tc reader/tc_reader\" -exporter -config \"/etc/tc reader.conf\"\n",
+			wantWatchdog: "WatchdogSec=30\n",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			unit := SystemdUnit(tc.command, tc.configFile, tc.options)
+			for _, want := range []string{"Type=notify\n", tc.wantExec, tc.wantWatchdog} {
+				if !strings.Contains(unit, want) {
+					t.Errorf("SystemdUnit => got:\n%s\nwant it to contain: %q", unit, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,825 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+toml.go reads the config files with the ".toml" extension. They are written in the subset of TOML without dates, inline
+tables, multi-line strings and dotted keys. The table headers can have quoted keys, e.g. [interfaces."eth0.100"]:
+
+	parseInterval = 5
+
+	[interfaces]
+	ifaces = ["ppp*"]
+
+	[interfaces.eth0]
+	groups = ["wan"]
+
+	[[interfaces.eth0.alerts]]
+	metric = "droppedPkt"
+	classes = "2:*"
+	condition = "rate > 100/s"
+	action = "syslog"
+
+	[sinks.graphite]
+	target = "graphite.example.com:2003"
+
+	[users.user1]
+	upload = ["eth0:2:3", "eth0:2:4"]
+	download = "eth1:2:3"
+	quota = "100G"
+
+	[[users.user1.alerts]]
+	metric = "userQuotaUsed"
+	condition = ">= 90"
+	action = "exec"
+	command = "/usr/local/bin/quota_hook"
+
+Every key is translated to the line of the option with the same name and the value in its format, so the options and
+their validation are the same as in the line format. The tables only group the options, except that the keys of the
+tables named after a prefix of the options, e.g. [trap] or [sinks.mqtt], get the prefix, e.g. "target" in [trap] is
+trapTarget and "TLS" in [sinks.mqtt] is mqttTLS. The arrays of strings are joined for the options that take lists, e.g.
+ifaces, and one line is written for each string of the repeated options, e.g. blackout.
+
+The interfaces, the users and the groups are configured in the tables keyed by their names:
+[interfaces.<iface>]          - groups are the groups the interface is a member of. The interface is added to ifaces
+                                unless it is "auto", so that ifaces only needs the patterns, e.g. "ppp*".
+[users.<name>]                - upload and download as in user, addrs as in userAddr, uploadCounters and
+                                downloadCounters as in userCounter, conntrack as in userConntrack, quota as in quota.
+[groups.<name>]               - ifaces as in group.
+[[<kind>.<name>.alerts]]      - the thresholds of the interface, user or group: metric, condition
+                                ("[rate] comparison threshold[/s]"), action and command as in alert. The pattern of the
+                                alert is the name of the user or group, or the interface followed by classes, the
+                                pattern of its Qdiscs / Classes, "*" by default.
+The users and groups can also be written as arrays of tables, but not mixed with the keyed tables of the same kind:
+[[users]]                     - name and the keys of [users.<name>].
+[[groups]]                    - name and ifaces.
+[[alerts]]                    - metric, pattern, condition, action and command as in alert.
+*/
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// tomlExtension is the extension of the config files written in TOML.
+const tomlExtension = ".toml"
+
+// tomlPrefixTables are the tables whose keys are the options without the name of the table as their prefix.
+var tomlPrefixTables = map[string]bool{
+	"trap":       true,
+	"graphite":   true,
+	"mqtt":       true,
+	"sample":     true,
+	"rrd":        true,
+	"zabbix":     true,
+	"http":       true,
+	"grpc":       true,
+	"prometheus": true,
+	"agent":      true,
+	"aggregator": true,
+	"quota":      true,
+	"log":        true,
+	"syslog":     true,
+}
+
+// tomlBareOptions are the options with string values that are written without quotes.
+var tomlBareOptions = map[string]bool{
+	"trapVersion":         true,
+	"sampleFormat":        true,
+	"sampleMaxSize":       true,
+	"userNames":           true,
+	"aggregatorConflicts": true,
+	"logLevel":            true,
+	"syslogFacility":      true,
+}
+
+// tomlListOptions are the options whose arrays are joined by spaces.
+var tomlListOptions = map[string]bool{
+	"ifaces":         true,
+	"execWrapper":    true,
+	"tcQdiscStats":   true,
+	"tcClassStats":   true,
+	"tcFilterShow":   true,
+	"sampleFields":   true,
+	"exportMetrics":  true,
+	"pktSizeBuckets": true,
+}
+
+// tomlQuotedListOptions are the options whose arrays are written as separate quoted strings.
+var tomlQuotedListOptions = map[string]bool{
+	"trapUser":    true,
+	"trapContext": true,
+	"mqttUser":    true,
+	"runAs":       true,
+}
+
+// tomlRepeatedOptions are the options written on a separate line for each string of their arrays.
+var tomlRepeatedOptions = map[string]bool{
+	"blackout": true,
+}
+
+// The kinds of the tables keyed by the names of the interfaces, users and groups, e.g. [users.user1].
+const (
+	tomlInterfaces = "interfaces"
+	tomlUsers      = "users"
+	tomlGroups     = "groups"
+)
+
+// tomlEntityKeys are the keys of the keyed tables of every kind.
+var tomlEntityKeys = map[string][]string{
+	tomlInterfaces: {"groups"},
+	tomlUsers:      {"upload", "download", "addrs", "uploadCounters", "downloadCounters", "conntrack", "quota"},
+	tomlGroups:     {"ifaces"},
+}
+
+// tomlAlertKeys are the keys of the alerts of the keyed tables of every kind.
+var tomlAlertKeys = map[string][]string{
+	tomlInterfaces: {"metric", "classes", "condition", "action", "command"},
+	tomlUsers:      {"metric", "condition", "action", "command"},
+	tomlGroups:     {"metric", "condition", "action", "command"},
+}
+
+// tomlTable is a table of a TOML document.
+type tomlTable struct {
+	// keys are the keys in the header of the table, nil for the root table.
+	keys []string
+
+	// array indicates that the table is an element of an array of tables.
+	array bool
+
+	// line is the zero based number of the line of the header.
+	line int
+
+	// entries are the keys of the table in the order they were defined.
+	entries []tomlEntry
+}
+
+// tomlEntry is a key of a TOML table with its value.
+type tomlEntry struct {
+	// key is the name of the key.
+	key string
+
+	// value is a string, an int64, a float64, a bool or a []interface{} of them.
+	value interface{}
+
+	// line is the zero based number of the line of the key.
+	line int
+}
+
+// tomlError is an error in a TOML document.
+type tomlError struct {
+	// line is the zero based number of the line of the error.
+	line int
+
+	// message describes the error.
+	message string
+}
+
+// Error implements error.
+func (e *tomlError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line+1, e.message)
+}
+
+// name returns the dotted name of the table with the keys that aren't bare quoted, empty for the root table.
+func (t *tomlTable) name() string {
+	var keys []string
+	for _, key := range t.keys {
+		if !validTomlKey(key) {
+			key = strconv.Quote(key)
+		}
+		keys = append(keys, key)
+	}
+	return strings.Join(keys, ".")
+}
+
+// header returns the header of the table, e.g. "[sinks.mqtt]" or "[[users]]".
+func (t *tomlTable) header() string {
+	if t.array {
+		return "[[" + t.name() + "]]"
+	}
+	return "[" + t.name() + "]"
+}
+
+// section returns the last key of the name of the table.
+func (t *tomlTable) section() string {
+	if len(t.keys) == 0 {
+		return ""
+	}
+	return t.keys[len(t.keys)-1]
+}
+
+// entity returns the kind and the name of the interface, user or group of the keyed table, e.g. "users" and "user1"
+// for [users.user1] and [[users.user1.alerts]]. ok is false for the other tables.
+func (t *tomlTable) entity() (kind string, name string, ok bool) {
+	if len(t.keys) < 2 || tomlEntityKeys[t.keys[0]] == nil {
+		return "", "", false
+	}
+	if len(t.keys) == 2 && !t.array || len(t.keys) == 3 && t.array && t.keys[2] == "alerts" {
+		return t.keys[0], t.keys[1], true
+	}
+	return "", "", false
+}
+
+// get returns the value of the key, nil if it isn't set.
+func (t *tomlTable) get(key string) interface{} {
+	for _, entry := range t.entries {
+		if entry.key == key {
+			return entry.value
+		}
+	}
+	return nil
+}
+
+// join formats the value of the key, the values of an array joined by the separator, empty if the key isn't set.
+func (t *tomlTable) join(key string, separator string) string {
+	if values, ok := t.get(key).([]interface{}); ok {
+		return tomlJoin(values, separator)
+	}
+	if value := t.get(key); value != nil {
+		return tomlScalar(value)
+	}
+	return ""
+}
+
+// checkKeys returns an error if the table has a key other than the known ones.
+func (t *tomlTable) checkKeys(known []string) error {
+	for _, entry := range t.entries {
+		if !slices.Contains(known, entry.key) {
+			return fmt.Errorf("unknown key %s in %s", entry.key, t.header())
+		}
+	}
+	return nil
+}
+
+// tomlParser parses a TOML document.
+type tomlParser struct {
+	// input is the document.
+	input string
+
+	// pos is the position of the next character.
+	pos int
+
+	// line is the zero based number of the current line.
+	line int
+}
+
+// parseToml parses the TOML document into its tables, the root table first. The tables nested in the arrays of tables
+// aren't supported.
+func parseToml(content string) ([]*tomlTable, error) {
+	p := &tomlParser{input: content}
+	root := &tomlTable{}
+	tables := []*tomlTable{root}
+	defined := map[string]bool{}
+	// arrays tells for the name of every explicitly or implicitly defined table whether it is an array of tables.
+	arrays := map[string]bool{}
+	current := root
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return tables, nil
+		}
+		switch p.peek() {
+		case '\n', '\r', '#':
+			p.skipLine()
+			continue
+
+		case '[':
+			table, err := p.parseHeader()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.defineTable(table, defined, arrays); err != nil {
+				return nil, err
+			}
+			tables = append(tables, table)
+			current = table
+
+		default:
+			entry, err := p.parseEntry()
+			if err != nil {
+				return nil, err
+			}
+			if current.get(entry.key) != nil {
+				return nil, p.errorf("duplicate key %s", entry.key)
+			}
+			current.entries = append(current.entries, entry)
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// defineTable checks that the table doesn't redefine a table and isn't nested in an array of tables, and adds it with
+// its parents to the defined tables.
+func (p *tomlParser) defineTable(table *tomlTable, defined map[string]bool, arrays map[string]bool) error {
+	for i := 1; i < len(table.keys); i++ {
+		parent := &tomlTable{keys: table.keys[:i]}
+		if arrays[parent.name()] {
+			return p.errorf("%s in the array of tables [[%s]] isn't supported", table.header(), parent.name())
+		}
+		arrays[parent.name()] = false
+	}
+	name := table.name()
+	array, exists := arrays[name]
+	switch {
+	case table.array && exists && !array:
+		return p.errorf("[[%s]] is already defined as a table", name)
+	case !table.array && array:
+		return p.errorf("[%s] is already defined as an array of tables", name)
+	case !table.array && defined[name]:
+		return p.errorf("duplicate table [%s]", name)
+	}
+	defined[name] = true
+	arrays[name] = table.array
+	return nil
+}
+
+// errorf returns a tomlError on the current line.
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return &tomlError{p.line, fmt.Sprintf(format, args...)}
+}
+
+// eof returns whether the whole document was parsed.
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+// peek returns the next character.
+func (p *tomlParser) peek() byte {
+	return p.input[p.pos]
+}
+
+// skipSpace skips the spaces and tabs.
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipLine skips the rest of the current line including the newline.
+func (p *tomlParser) skipLine() {
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+	if !p.eof() {
+		p.pos++
+		p.line++
+	}
+}
+
+// skipBlank skips the spaces, the newlines and the comments, e.g. between the values of an array.
+func (p *tomlParser) skipBlank() {
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return
+		}
+		switch p.peek() {
+		case '\n', '\r', '#':
+			p.skipLine()
+		default:
+			return
+		}
+	}
+}
+
+// endLine skips the rest of the line after a header or an entry, only a comment is allowed there.
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+	if p.eof() {
+		return nil
+	}
+	switch p.peek() {
+	case '\n', '\r', '#':
+		p.skipLine()
+		return nil
+	}
+	return p.errorf("unexpected %q after the value", p.peek())
+}
+
+// parseHeader parses the header of a table, e.g. "[sinks.mqtt]", "[interfaces."eth0.100"]" or "[[users]]".
+func (p *tomlParser) parseHeader() (*tomlTable, error) {
+	table := &tomlTable{line: p.line}
+	closing := "]"
+	if strings.HasPrefix(p.input[p.pos:], "[[") {
+		table.array = true
+		closing = "]]"
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	for {
+		p.skipSpace()
+		key, err := p.parseHeaderKey()
+		if err != nil {
+			return nil, err
+		}
+		table.keys = append(table.keys, key)
+		p.skipSpace()
+		switch {
+		case strings.HasPrefix(p.input[p.pos:], closing):
+			p.pos += len(closing)
+			return table, nil
+		case p.eof() || p.peek() == '\n' || p.peek() == '\r':
+			return nil, p.errorf("unterminated table header")
+		case p.peek() != '.':
+			return nil, p.errorf("unexpected %q in the table header", p.peek())
+		}
+		p.pos++
+	}
+}
+
+// parseHeaderKey parses a bare or a quoted key of a table header.
+func (p *tomlParser) parseHeaderKey() (string, error) {
+	if p.eof() || p.peek() == '\n' || p.peek() == '\r' {
+		return "", p.errorf("unterminated table header")
+	}
+	if p.peek() == '"' || p.peek() == '\'' {
+		value, err := p.parseValue()
+		if err != nil {
+			return "", err
+		}
+		if value == "" {
+			return "", p.errorf("empty key in the table header")
+		}
+		return value.(string), nil
+	}
+	start := p.pos
+	for !p.eof() && validTomlKey(p.input[p.pos:p.pos+1]) {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("unexpected %q in the table header", p.peek())
+	}
+	return p.input[start:p.pos], nil
+}
+
+// parseEntry parses a "key = value" entry.
+func (p *tomlParser) parseEntry() (tomlEntry, error) {
+	start, line := p.pos, p.line
+	for !p.eof() && strings.IndexByte(" \t=\n", p.peek()) < 0 {
+		p.pos++
+	}
+	key := p.input[start:p.pos]
+	if !validTomlKey(key) {
+		return tomlEntry{}, p.errorf("invalid key %q, only bare keys are supported", key)
+	}
+	p.skipSpace()
+	if p.eof() || p.peek() != '=' {
+		return tomlEntry{}, p.errorf("missing = after the key %s", key)
+	}
+	p.pos++
+	p.skipSpace()
+	value, err := p.parseValue()
+	if err != nil {
+		return tomlEntry{}, err
+	}
+	return tomlEntry{key, value, line}, nil
+}
+
+// parseValue parses a string, an integer, a float, a boolean or an array.
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.eof() {
+		return nil, p.errorf("missing value")
+	}
+	switch p.peek() {
+	case '"':
+		end := p.pos + 1
+		for end < len(p.input) && p.input[end] != '"' && p.input[end] != '\n' {
+			if p.input[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.input) || p.input[end] != '"' {
+			return nil, p.errorf("unterminated string")
+		}
+		value, err := strconv.Unquote(p.input[p.pos : end+1])
+		if err != nil {
+			return nil, p.errorf("invalid string %s", p.input[p.pos:end+1])
+		}
+		p.pos = end + 1
+		return value, nil
+
+	case '\'':
+		end := strings.IndexAny(p.input[p.pos+1:], "'\n")
+		if end < 0 || p.input[p.pos+1+end] != '\'' {
+			return nil, p.errorf("unterminated string")
+		}
+		value := p.input[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return value, nil
+
+	case '[':
+		p.pos++
+		var values []interface{}
+		for {
+			p.skipBlank()
+			if p.eof() {
+				return nil, p.errorf("unterminated array")
+			}
+			if p.peek() == ']' {
+				p.pos++
+				return values, nil
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			p.skipBlank()
+			if p.eof() {
+				return nil, p.errorf("unterminated array")
+			}
+			if p.peek() == ',' {
+				p.pos++
+			} else if p.peek() != ']' {
+				return nil, p.errorf("missing , between the values of the array")
+			}
+		}
+	}
+
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n,]#", p.peek()) < 0 {
+		p.pos++
+	}
+	token := p.input[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.ReplaceAll(token, "_", "")
+	if value, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return value, nil
+	}
+	if value, err := strconv.ParseFloat(number, 64); err == nil {
+		return value, nil
+	}
+	return nil, p.errorf("unsupported value %q", token)
+}
+
+// validTomlKey returns whether the key is a bare key.
+func validTomlKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// tomlLine is a line in the line format translated from a TOML document.
+type tomlLine struct {
+	// n is the zero based number of the line of the TOML document it was translated from.
+	n int
+
+	// line is the translated line.
+	line string
+}
+
+// parseToml parses the content of a config file in TOML. All the tables are parsed if the document is valid, the
+// returned error joins the errors of the invalid options.
+func (c *config) parseToml(content string) error {
+	tables, err := parseToml(content)
+	if err != nil {
+		e := err.(*tomlError)
+		return fmt.Errorf("Error in config file %s on line %d: %s", c.filename, e.line+1, e.message)
+	}
+	var errs []error
+	for _, table := range tables {
+		lines, err := tomlTableLines(table)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error in config file %s on line %d: %s", c.filename, table.line+1, err))
+			continue
+		}
+		for _, line := range lines {
+			if err := c.parseLine(line.n, line.line); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	c.addTomlIfaces(tables)
+	return errors.Join(errs...)
+}
+
+// addTomlIfaces adds the interfaces of the keyed tables, e.g. [interfaces.eth0], to the monitored interfaces unless
+// they are discovered, and to the members of their groups.
+func (c *config) addTomlIfaces(tables []*tomlTable) {
+	for _, table := range tables {
+		kind, iface, ok := table.entity()
+		if !ok || kind != tomlInterfaces || table.array {
+			continue
+		}
+		if !(len(c.Ifaces) == 1 && c.Ifaces[0] == autoIfaces) && !slices.Contains(c.Ifaces, iface) {
+			c.Ifaces = append(c.Ifaces, iface)
+		}
+		for _, group := range strings.Fields(table.join("groups", " ")) {
+			if c.Groups == nil {
+				c.Groups = make(map[string][]string)
+			}
+			if !slices.Contains(c.Groups[group], iface) {
+				c.Groups[group] = append(c.Groups[group], iface)
+			}
+		}
+	}
+}
+
+// tomlTableLines translates the table into the lines of the options.
+func tomlTableLines(table *tomlTable) ([]tomlLine, error) {
+	kind, name, keyed := table.entity()
+	if keyed || table.array {
+		var lines []string
+		var err error
+		switch {
+		case keyed && table.array:
+			err = table.checkKeys(tomlAlertKeys[kind])
+		case keyed:
+			err = table.checkKeys(tomlEntityKeys[kind])
+		}
+		if err != nil {
+			return nil, err
+		}
+		if keyed {
+			lines, err = tomlEntityLines(kind, name, table)
+		} else {
+			lines, err = tomlArrayLines(table)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var translated []tomlLine
+		for _, line := range lines {
+			translated = append(translated, tomlLine{table.line, line})
+		}
+		return translated, nil
+	}
+	if len(table.keys) > 1 && tomlEntityKeys[table.keys[0]] != nil {
+		return nil, fmt.Errorf("unsupported table %s", table.header())
+	}
+	var translated []tomlLine
+	for _, entry := range table.entries {
+		option := entry.key
+		if tomlPrefixTables[table.section()] {
+			option = table.section() + strings.ToUpper(option[:1]) + option[1:]
+		}
+		lines, err := tomlOptionLines(option, entry.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", entry.key, err)
+		}
+		for _, line := range lines {
+			translated = append(translated, tomlLine{entry.line, line})
+		}
+	}
+	return translated, nil
+}
+
+// tomlOptionLines formats the value of the option as its lines.
+func tomlOptionLines(option string, value interface{}) ([]string, error) {
+	values, isArray := value.([]interface{})
+	switch {
+	case tomlRepeatedOptions[option]:
+		if !isArray {
+			values = []interface{}{value}
+		}
+		var lines []string
+		for _, v := range values {
+			lines = append(lines, fmt.Sprintf("%s = \"%s\"", option, tomlScalar(v)))
+		}
+		return lines, nil
+
+	case tomlQuotedListOptions[option] && isArray:
+		var quoted []string
+		for _, v := range values {
+			quoted = append(quoted, fmt.Sprintf("\"%s\"", tomlScalar(v)))
+		}
+		return []string{fmt.Sprintf("%s = %s", option, strings.Join(quoted, " "))}, nil
+
+	case tomlListOptions[option] && isArray:
+		return []string{fmt.Sprintf("%s = \"%s\"", option, tomlJoin(values, " "))}, nil
+
+	case isArray:
+		return nil, fmt.Errorf("%s doesn't take an array", option)
+	}
+
+	switch v := value.(type) {
+	case string:
+		if tomlBareOptions[option] {
+			return []string{fmt.Sprintf("%s = %s", option, v)}, nil
+		}
+		return []string{fmt.Sprintf("%s = \"%s\"", option, v)}, nil
+	}
+	return []string{fmt.Sprintf("%s = %s", option, tomlScalar(value))}, nil
+}
+
+// tomlArrayLines translates an element of the arrays of tables with the users, groups or alerts into its lines.
+func tomlArrayLines(table *tomlTable) ([]string, error) {
+	switch table.section() {
+	case tomlUsers, tomlGroups:
+		if err := table.checkKeys(append([]string{"name"}, tomlEntityKeys[table.section()]...)); err != nil {
+			return nil, err
+		}
+		return tomlEntityLines(table.section(), table.join("name", ""), table)
+
+	case "alerts":
+		if err := table.checkKeys([]string{"metric", "pattern", "condition", "action", "command"}); err != nil {
+			return nil, err
+		}
+		return []string{tomlAlertLine(table.join("pattern", ""), table)}, nil
+	}
+	return nil, fmt.Errorf("unknown array of tables %s", table.header())
+}
+
+// tomlEntityLines translates the table of the interface, user or group of the kind, or of its alert, into its lines.
+func tomlEntityLines(kind, name string, table *tomlTable) ([]string, error) {
+	if table.array && table.section() == "alerts" {
+		pattern := name
+		if kind == tomlInterfaces {
+			classes := table.join("classes", "")
+			if classes == "" {
+				classes = "*"
+			}
+			pattern = name + ":" + classes
+		}
+		return []string{tomlAlertLine(pattern, table)}, nil
+	}
+
+	switch kind {
+	case tomlUsers:
+		var lines []string
+		if table.get("upload") != nil || table.get("download") != nil {
+			lines = append(lines, fmt.Sprintf("user = \"%s\" \"%s\" \"%s\"", name, table.join("upload", ","), table.join("download", ",")))
+		}
+		if table.get("addrs") != nil {
+			lines = append(lines, fmt.Sprintf("userAddr = \"%s\" \"%s\"", name, table.join("addrs", ",")))
+		}
+		if table.get("uploadCounters") != nil || table.get("downloadCounters") != nil {
+			lines = append(lines, fmt.Sprintf("userCounter = \"%s\" \"%s\" \"%s\"", name, table.join("uploadCounters", ","), table.join("downloadCounters", ",")))
+		}
+		if table.get("conntrack") != nil {
+			lines = append(lines, fmt.Sprintf("userConntrack = \"%s\" \"%s\"", name, table.join("conntrack", ",")))
+		}
+		if table.get("quota") != nil {
+			lines = append(lines, fmt.Sprintf("quota = \"%s\" \"%s\"", name, table.join("quota", "")))
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("the user %s has no upload, download, addrs, counters or conntrack", name)
+		}
+		return lines, nil
+
+	case tomlGroups:
+		return []string{fmt.Sprintf("group = \"%s\" \"%s\"", name, table.join("ifaces", " "))}, nil
+	}
+	// The interfaces are added to the config by addTomlIfaces.
+	return nil, nil
+}
+
+// tomlAlertLine translates the alert of an element of an array of tables with the pattern into its line.
+func tomlAlertLine(pattern string, table *tomlTable) string {
+	line := fmt.Sprintf("alert = \"%s\" \"%s\" %s \"%s\"", table.join("metric", ""), pattern, table.join("condition", ""), table.join("action", ""))
+	if command := table.join("command", ""); command != "" {
+		line += fmt.Sprintf(" \"%s\"", command)
+	}
+	return line
+}
+
+// tomlScalar formats a string, a number or a boolean.
+func tomlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// tomlJoin formats the values and joins them by the separator.
+func tomlJoin(values []interface{}, separator string) string {
+	var formatted []string
+	for _, value := range values {
+		formatted = append(formatted, tomlScalar(value))
+	}
+	return strings.Join(formatted, separator)
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseToml(t *testing.T) {
+	testData := []struct {
+		desc    string
+		content string
+		want    []*tomlTable
+		wantErr string
+	}{
+		{
+			desc:    "values",
+			content: "a = \"x \\\"y\\\"\"\nb = 'c:\\d'\nc = 1_000\nd = -2.5\ne = true # comment\n",
+			want: []*tomlTable{{entries: []tomlEntry{
+				{"a", "x \"y\"", 0},
+				{"b", "c:\\d", 1},
+				{"c", int64(1000), 2},
+				{"d", -2.5, 3},
+				{"e", true, 4},
+			}}},
+		},
+		{
+			desc:    "tables and arrays",
+			content: "[sinks.mqtt]\nbroker = \"localhost:1883\"\n\n[[users]]\nname = \"u1\"\nupload = [\n  \"a\", # first\n  \"b\",\n]\n[[users]]\nname = \"u2\"\n",
+			want: []*tomlTable{
+				{},
+				{keys: []string{"sinks", "mqtt"}, entries: []tomlEntry{{"broker", "localhost:1883", 1}}},
+				{keys: []string{"users"}, array: true, line: 3, entries: []tomlEntry{
+					{"name", "u1", 4},
+					{"upload", []interface{}{"a", "b"}, 5},
+				}},
+				{keys: []string{"users"}, array: true, line: 9, entries: []tomlEntry{{"name", "u2", 10}}},
+			},
+		},
+		{
+			desc:    "quoted keys in the headers",
+			content: "[interfaces.\"eth0.100\"]\n[[ interfaces . 'eth0.100' . alerts ]]\n",
+			want: []*tomlTable{
+				{},
+				{keys: []string{"interfaces", "eth0.100"}},
+				{keys: []string{"interfaces", "eth0.100", "alerts"}, array: true, line: 1},
+			},
+		},
+		{
+			desc:    "duplicate key",
+			content: "a = 1\na = 2\n",
+			wantErr: "line 2: duplicate key a",
+		},
+		{
+			desc:    "duplicate table",
+			content: "[trap]\n[trap]\n",
+			wantErr: "line 2: duplicate table [trap]",
+		},
+		{
+			desc:    "duplicate table with a quoted key",
+			content: "[interfaces.eth0]\n[interfaces.\"eth0\"]\n",
+			wantErr: "line 2: duplicate table [interfaces.eth0]",
+		},
+		{
+			desc:    "keyed table in an array of tables",
+			content: "[[users]]\nname = \"u1\"\n[users.u1]\n",
+			wantErr: "line 3: [users.u1] in the array of tables [[users]] isn't supported",
+		},
+		{
+			desc:    "array of tables defined as a table",
+			content: "[users.u1]\n[[users]]\n",
+			wantErr: "line 2: [[users]] is already defined as a table",
+		},
+		{
+			desc:    "unterminated header",
+			content: "[interfaces.eth0\n",
+			wantErr: "line 1: unterminated table header",
+		},
+		{
+			desc:    "invalid header",
+			content: "[interfaces eth0]\n",
+			wantErr: "line 1: unexpected 'e' in the table header",
+		},
+		{
+			desc:    "dotted key",
+			content: "trap.target = \"x\"\n",
+			wantErr: "line 1: invalid key \"trap.target\", only bare keys are supported",
+		},
+		{
+			desc:    "unterminated string",
+			content: "a = \"x\n",
+			wantErr: "line 1: unterminated string",
+		},
+		{
+			desc:    "unterminated array",
+			content: "a = [1, 2\n",
+			wantErr: "line 2: unterminated array",
+		},
+		{
+			desc:    "garbage after the value",
+			content: "a = 1 2\n",
+			wantErr: "line 1: unexpected '2' after the value",
+		},
+		{
+			desc:    "inline table",
+			content: "a = {b = 1}\n",
+			wantErr: "line 1: unsupported value \"{b\"",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := parseToml(tc.content)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("parseToml => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseToml => unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseToml => got: %+v, want: %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigParseToml(t *testing.T) {
+	testData := []struct {
+		desc    string
+		content string
+		get     func(c *config) interface{}
+		want    interface{}
+		wantErr string
+	}{
+		{
+			desc:    "prefixed table",
+			content: "[sinks.mqtt]\nbroker = \"localhost:1883\"\nTLS = true\nuser = [\"tc\", \"secret\"]\n",
+			get: func(c *config) interface{} {
+				return []interface{}{c.MqttBroker, c.MqttTLS, c.MqttUser, c.MqttPassword}
+			},
+			want: []interface{}{"localhost:1883", true, "tc", "secret"},
+		},
+		{
+			desc:    "prefixed table with a quoted list",
+			content: "[trap]\ncontext = [\"shapers\", \"0x800007e504\"]\n",
+			get:     func(c *config) interface{} { return []interface{}{c.TrapContext, c.TrapContextEngineID} },
+			want:    []interface{}{"shapers", []byte{0x80, 0x00, 0x07, 0xe5, 0x04}},
+		},
+		{
+			desc:    "bare option",
+			content: "[log]\nlevel = \"warning\"\n[syslog]\nfacility = \"local0\"\n",
+			get:     func(c *config) interface{} { return []string{c.LogLevel, c.SyslogFacility} },
+			want:    []string{"warning", "local0"},
+		},
+		{
+			desc:    "repeated option",
+			content: "blackout = [\"02:00-03:00\", \"12:00-12:30\"]\n",
+			get:     func(c *config) interface{} { return len(c.Blackouts) },
+			want:    2,
+		},
+		{
+			desc:    "users with addresses and quota",
+			content: "[[users]]\nname = \"user1\"\naddrs = [\"10.0.0.7\", \"2001:db8::8\"]\nquota = \"100G\"\n",
+			get: func(c *config) interface{} {
+				return []interface{}{len(c.AddrUsers), c.Quotas["user1"]}
+			},
+			want: []interface{}{2, int64(100000000000)},
+		},
+		{
+			desc:    "users with counters",
+			content: "[[users]]\nname = \"user1\"\nuploadCounters = [\"nft:inet:filter:up1\", \"ipt:mangle:ACCT:up1\"]\n",
+			get:     func(c *config) interface{} { return len(c.CounterUsers) },
+			want:    2,
+		},
+		{
+			desc:    "groups",
+			content: "[[groups]]\nname = \"wan\"\nifaces = [\"ppp0\", \"eth1\"]\n",
+			get:     func(c *config) interface{} { return c.Groups },
+			want:    map[string][]string{"wan": {"ppp0", "eth1"}},
+		},
+		{
+			desc:    "alerts",
+			content: "[[alerts]]\nmetric = \"droppedPkt\"\npattern = \"eth0:2:*\"\ncondition = \"rate > 100/s\"\naction = \"syslog\"\n",
+			get:     func(c *config) interface{} { return len(c.Alerts) },
+			want:    1,
+		},
+		{
+			desc: "keyed users",
+			content: "[users.user1]\nupload = \"eth0:2:3\"\ndownload = [\"eth1:2:3\", \"eth1:2:4\"]\nquota = \"1G\"\n" +
+				"[users.\"user.2\"]\nconntrack = [\"10.0.0.0/28\", \"10.0.1.7\"]\n",
+			get: func(c *config) interface{} {
+				return []interface{}{len(c.UserNameClass), c.Quotas["user1"], c.ConntrackUsers["10.0.1.7/32"]}
+			},
+			want: []interface{}{3, int64(1000000000), "user.2"},
+		},
+		{
+			desc: "keyed interfaces are monitored with the patterns",
+			content: "[interfaces]\nifaces = [\"ppp*\"]\n" +
+				"[interfaces.eth0]\ngroups = [\"wan\", \"all\"]\n[interfaces.\"eth0.100\"]\ngroups = \"all\"\n" +
+				"[groups.lan]\nifaces = [\"eth1\", \"eth2\"]\n",
+			get: func(c *config) interface{} { return []interface{}{c.Ifaces, c.Groups} },
+			want: []interface{}{
+				[]string{"ppp*", "eth0", "eth0.100"},
+				map[string][]string{"wan": {"eth0"}, "all": {"eth0", "eth0.100"}, "lan": {"eth1", "eth2"}},
+			},
+		},
+		{
+			desc:    "keyed interfaces with discovery",
+			content: "[interfaces]\nifaces = \"auto\"\n[interfaces.eth0]\ngroups = \"wan\"\n",
+			get:     func(c *config) interface{} { return []interface{}{c.Ifaces, c.Groups} },
+			want:    []interface{}{[]string{"auto"}, map[string][]string{"wan": {"eth0"}}},
+		},
+		{
+			desc: "alerts of the keyed tables",
+			content: "[[interfaces.eth0.alerts]]\nmetric = \"droppedPkt\"\ncondition = \"rate > 100/s\"\naction = \"syslog\"\n" +
+				"[[interfaces.eth0.alerts]]\nmetric = \"sentBytes\"\nclasses = \"2:*\"\ncondition = \"> 0\"\naction = \"trap\"\n" +
+				"[[users.user1.alerts]]\nmetric = \"userQuotaUsed\"\ncondition = \">= 90\"\naction = \"exec\"\ncommand = \"/bin/hook\"\n",
+			get: func(c *config) interface{} {
+				var rules []string
+				for _, rule := range c.Alerts {
+					rules = append(rules, rule.metric+" "+rule.pattern)
+				}
+				return rules
+			},
+			want: []string{"droppedPkt eth0:*", "sentBytes eth0:2:*", "userQuotaUsed user1"},
+		},
+		{
+			desc:    "unknown key of a keyed user",
+			content: "\n[users.user1]\nname = \"user1\"\n",
+			wantErr: "Error in config file test.toml on line 2: unknown key name in [users.user1]",
+		},
+		{
+			desc:    "unknown key of an alert of a keyed user",
+			content: "[[users.user1.alerts]]\nclasses = \"2:*\"\n",
+			wantErr: "Error in config file test.toml on line 1: unknown key classes in [[users.user1.alerts]]",
+		},
+		{
+			desc:    "unsupported keyed table",
+			content: "[users.user1.limits]\nquota = \"1G\"\n",
+			wantErr: "Error in config file test.toml on line 1: unsupported table [users.user1.limits]",
+		},
+		{
+			desc:    "invalid value of an option",
+			content: "\n[trap]\ndropRate = 101\n",
+			wantErr: "Error in config file test.toml on line 3: the drop rate must be between 1 and 100. Line: 'trapDropRate = 101'",
+		},
+		{
+			desc:    "array of a scalar option",
+			content: "identity = [\"a\", \"b\"]\n",
+			wantErr: "Error in config file test.toml on line 1: identity: identity doesn't take an array",
+		},
+		{
+			desc:    "unknown array of tables",
+			content: "[[routers]]\nname = \"r1\"\n",
+			wantErr: "Error in config file test.toml on line 1: unknown array of tables [[routers]]",
+		},
+		{
+			desc:    "unknown key of an user",
+			content: "[[users]]\nname = \"user1\"\nup = \"eth0:2:3\"\n",
+			wantErr: "Error in config file test.toml on line 1: unknown key up in [[users]]",
+		},
+		{
+			desc:    "all the invalid options are reported",
+			content: "parseInterval = \"often\"\n[[routers]]\nname = \"r1\"\n",
+			wantErr: "Error in config file test.toml on line 0: cannot parse this line: 'parseInterval = \"often\"'\nError in config file test.toml on line 2: unknown array of tables [[routers]]",
+		},
+		{
+			desc:    "syntax error",
+			content: "a = \n",
+			wantErr: "Error in config file test.toml on line 1: unsupported value \"\"",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := newConfig("test.toml")
+			err := c.parseToml(tc.content)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("parseToml => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseToml => unexpected error: %s", err)
+			}
+			if got := tc.get(c); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseToml => got: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
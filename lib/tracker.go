@@ -0,0 +1,214 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+tracker.go remembers counters from the previous parse cycles so we can compute per-interval values and monotonic totals.
+*/
+
+package lib
+
+// counterRange32 is the range of a 32-bit counter, older kernels keep the packet counters in 32 bits.
+const counterRange32 = 1 << 32
+
+// sample is a snapshot of the counters of a Qdisc / Class or an user in one direction.
+type sample struct {
+	// bytes is the number of sent bytes.
+	bytes int64
+
+	// pkt is the number of sent packets.
+	pkt int64
+
+	// droppedPkt is the number of dropped packets.
+	droppedPkt int64
+
+	// overLimitPkt is the number of over limit packets.
+	overLimitPkt int64
+}
+
+// sub returns the difference between two samples.
+func (s sample) sub(other sample) sample {
+	return sample{
+		bytes:        s.bytes - other.bytes,
+		pkt:          s.pkt - other.pkt,
+		droppedPkt:   s.droppedPkt - other.droppedPkt,
+		overLimitPkt: s.overLimitPkt - other.overLimitPkt,
+	}
+}
+
+// add returns the sum of two samples.
+func (s sample) add(other sample) sample {
+	return sample{
+		bytes:        s.bytes + other.bytes,
+		pkt:          s.pkt + other.pkt,
+		droppedPkt:   s.droppedPkt + other.droppedPkt,
+		overLimitPkt: s.overLimitPkt + other.overLimitPkt,
+	}
+}
+
+// seenKeys are the keys recorded by a tracker since it was last pruned.
+type seenKeys map[string]bool
+
+// counterTracker remembers the last sample for every key. The zero value is ready to use.
+type counterTracker struct {
+	// last maps keys to the last recorded sample.
+	last map[string]sample
+
+	// seen are the keys recorded since the last prune.
+	seen seenKeys
+}
+
+// delta records the current sample and returns its difference against the previously recorded one.
+// Returns false if there is no previous sample or if any counter decreased, e.g. because the Qdisc was replaced.
+func (c *counterTracker) delta(key string, current sample) (sample, bool) {
+	if c.last == nil {
+		c.last = make(map[string]sample)
+	}
+	c.seen = c.seen.add(key)
+	previous, ok := c.last[key]
+	c.last[key] = current
+	if !ok {
+		return sample{}, false
+	}
+	d := current.sub(previous)
+	if d.bytes < 0 || d.pkt < 0 || d.droppedPkt < 0 || d.overLimitPkt < 0 {
+		return sample{}, false
+	}
+	return d, true
+}
+
+// prune forgets the keys that weren't recorded since the previous prune.
+func (c *counterTracker) prune() {
+	for key := range c.last {
+		if !c.seen[key] {
+			delete(c.last, key)
+		}
+	}
+	c.seen = nil
+}
+
+// add marks the key as seen, the keys are allocated if needed.
+func (k seenKeys) add(key string) seenKeys {
+	if k == nil {
+		k = make(seenKeys)
+	}
+	k[key] = true
+	return k
+}
+
+// trackedTotal are the accumulated counters of a key recorded in a parse cycle.
+type trackedTotal struct {
+	// total are the accumulated counters.
+	total sample
+
+	// cycle is the parse cycle the counters were recorded in.
+	cycle int
+}
+
+// deltaTracker remembers the accumulated counters of every key from the previous parse cycle, so that their increase
+// during the last interval can be exported. The zero value is ready to use.
+type deltaTracker struct {
+	// last maps keys to the last recorded accumulated counters.
+	last map[string]trackedTotal
+
+	// seen are the keys recorded since the last prune.
+	seen seenKeys
+}
+
+// delta records the accumulated counters of the key in the parse cycle and returns their increase since the previous
+// parse cycle. Returns false if the key wasn't recorded in the previous parse cycle, e.g. because it is new.
+func (d *deltaTracker) delta(key string, total sample, cycle int) (sample, bool) {
+	if d.last == nil {
+		d.last = make(map[string]trackedTotal)
+	}
+	d.seen = d.seen.add(key)
+	previous, ok := d.last[key]
+	d.last[key] = trackedTotal{total, cycle}
+	if !ok || previous.cycle != cycle-1 {
+		return sample{}, false
+	}
+	return total.sub(previous.total), true
+}
+
+// prune forgets the keys that weren't recorded since the previous prune.
+func (d *deltaTracker) prune() {
+	for key := range d.last {
+		if !d.seen[key] {
+			delete(d.last, key)
+		}
+	}
+	d.seen = nil
+}
+
+// counterDelta returns the increase of a counter between two samples and false if the counter decreased.
+// A decreased counter either wrapped around 32 bits, if the wrapped increase is less than half of the range,
+// or it was reset, e.g. because the Qdisc was replaced, and counted again from zero.
+func counterDelta(previous, current int64) (int64, bool) {
+	if current >= previous {
+		return current - previous, true
+	}
+	if previous < counterRange32 {
+		if wrapped := current + counterRange32 - previous; wrapped < counterRange32/2 {
+			return wrapped, false
+		}
+	}
+	return current, false
+}
+
+// accumulator turns the raw counters of every key into monotonic 64-bit counters that survive counter resets and wraps.
+// The zero value is ready to use.
+type accumulator struct {
+	// last maps keys to the last recorded raw sample.
+	last map[string]sample
+
+	// total maps keys to the accumulated counters.
+	total map[string]sample
+
+	// seen are the keys recorded since the last prune.
+	seen seenKeys
+}
+
+// accumulate records the current raw sample and returns the accumulated counters.
+// The first sample of a key is accumulated as is. Returns false if any of the counters decreased since the previous sample.
+func (a *accumulator) accumulate(key string, current sample) (sample, bool) {
+	if a.last == nil {
+		a.last = make(map[string]sample)
+		a.total = make(map[string]sample)
+	}
+	a.seen = a.seen.add(key)
+	previous, ok := a.last[key]
+	a.last[key] = current
+	if !ok {
+		a.total[key] = current
+		return current, true
+	}
+	bytes, bytesOk := counterDelta(previous.bytes, current.bytes)
+	pkt, pktOk := counterDelta(previous.pkt, current.pkt)
+	droppedPkt, droppedPktOk := counterDelta(previous.droppedPkt, current.droppedPkt)
+	overLimitPkt, overLimitPktOk := counterDelta(previous.overLimitPkt, current.overLimitPkt)
+	total := a.total[key].add(sample{bytes, pkt, droppedPkt, overLimitPkt})
+	a.total[key] = total
+	return total, bytesOk && pktOk && droppedPktOk && overLimitPktOk
+}
+
+// prune forgets the keys that weren't recorded since the previous prune, a key recorded again starts a new total.
+func (a *accumulator) prune() {
+	for key := range a.last {
+		if !a.seen[key] {
+			delete(a.last, key)
+			delete(a.total, key)
+		}
+	}
+	a.seen = nil
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"testing"
+)
+
+func TestCounterTrackerDelta(t *testing.T) {
+	steps := []struct {
+		desc    string
+		key     string
+		current sample
+		want    sample
+		wantOk  bool
+	}{
+		{
+			desc:    "first sample has no delta",
+			key:     "eth0:1:0",
+			current: sample{100, 10, 1, 2},
+		},
+		{
+			desc:    "second sample returns the difference",
+			key:     "eth0:1:0",
+			current: sample{150, 15, 1, 4},
+			want:    sample{50, 5, 0, 2},
+			wantOk:  true,
+		},
+		{
+			desc:    "keys are tracked independently",
+			key:     "eth0:2:0",
+			current: sample{1, 1, 1, 1},
+		},
+		{
+			desc:    "decreasing counters have no delta",
+			key:     "eth0:1:0",
+			current: sample{10, 1, 0, 0},
+		},
+		{
+			desc:    "the sample after a decrease is compared to the decreased one",
+			key:     "eth0:1:0",
+			current: sample{20, 2, 0, 0},
+			want:    sample{10, 1, 0, 0},
+			wantOk:  true,
+		},
+	}
+
+	var c counterTracker
+	for _, step := range steps {
+		got, ok := c.delta(step.key, step.current)
+		if ok != step.wantOk {
+			t.Errorf("%s: delta => ok got: %v, want: %v", step.desc, ok, step.wantOk)
+		}
+		if got != step.want {
+			t.Errorf("%s: delta => got: %v, want: %v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestDeltaTrackerDelta(t *testing.T) {
+	steps := []struct {
+		desc   string
+		key    string
+		total  sample
+		cycle  int
+		want   sample
+		wantOk bool
+	}{
+		{
+			desc:  "first cycle has no delta",
+			key:   "eth0:1:0",
+			total: sample{100, 10, 1, 2},
+			cycle: 1,
+		},
+		{
+			desc:   "next cycle returns the difference",
+			key:    "eth0:1:0",
+			total:  sample{150, 15, 1, 4},
+			cycle:  2,
+			want:   sample{50, 5, 0, 2},
+			wantOk: true,
+		},
+		{
+			desc:  "keys are tracked independently",
+			key:   "eth0:2:0",
+			total: sample{1, 1, 1, 1},
+			cycle: 2,
+		},
+		{
+			desc:  "a skipped cycle has no delta",
+			key:   "eth0:1:0",
+			total: sample{200, 20, 1, 4},
+			cycle: 4,
+		},
+		{
+			desc:   "the cycle after the skipped one is compared to it",
+			key:    "eth0:1:0",
+			total:  sample{210, 21, 1, 4},
+			cycle:  5,
+			want:   sample{10, 1, 0, 0},
+			wantOk: true,
+		},
+	}
+
+	var d deltaTracker
+	for _, step := range steps {
+		got, ok := d.delta(step.key, step.total, step.cycle)
+		if ok != step.wantOk {
+			t.Errorf("%s: delta => ok got: %v, want: %v", step.desc, ok, step.wantOk)
+		}
+		if got != step.want {
+			t.Errorf("%s: delta => got: %v, want: %v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	testData := []struct {
+		desc     string
+		previous int64
+		current  int64
+		want     int64
+		wantOk   bool
+	}{
+		{"increased counter", 100, 150, 50, true},
+		{"unchanged counter", 100, 100, 0, true},
+		{"counter reset to zero", 1000, 0, 0, false},
+		{"counter reset and counted again", 1000, 20, 20, false},
+		{"32-bit counter wrapped", counterRange32 - 10, 5, 15, false},
+		{"implausible 32-bit wrap is a reset", counterRange32 / 4, 5, 5, false},
+		{"64-bit counter reset", counterRange32 * 2, 5, 5, false},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, ok := counterDelta(tc.previous, tc.current)
+			if got != tc.want || ok != tc.wantOk {
+				t.Errorf("counterDelta(%d, %d) => got: %d, %v, want: %d, %v", tc.previous, tc.current, got, ok, tc.want, tc.wantOk)
+			}
+		})
+	}
+}
+
+func TestAccumulatorAccumulate(t *testing.T) {
+	steps := []struct {
+		desc    string
+		key     string
+		current sample
+		want    sample
+		wantOk  bool
+	}{
+		{
+			desc:    "first sample is accumulated as is",
+			key:     "eth0:1:0",
+			current: sample{100, 10, 1, 2},
+			want:    sample{100, 10, 1, 2},
+			wantOk:  true,
+		},
+		{
+			desc:    "increasing counters are accumulated",
+			key:     "eth0:1:0",
+			current: sample{150, 15, 1, 4},
+			want:    sample{150, 15, 1, 4},
+			wantOk:  true,
+		},
+		{
+			desc:    "keys are accumulated independently",
+			key:     "eth0:2:0",
+			current: sample{1, 1, 1, 1},
+			want:    sample{1, 1, 1, 1},
+			wantOk:  true,
+		},
+		{
+			desc:    "reset counters keep increasing",
+			key:     "eth0:1:0",
+			current: sample{10, 1, 0, 0},
+			want:    sample{160, 16, 1, 4},
+		},
+		{
+			desc:    "counters after a reset are accumulated from the new values",
+			key:     "eth0:1:0",
+			current: sample{20, 2, 0, 1},
+			want:    sample{170, 17, 1, 5},
+			wantOk:  true,
+		},
+	}
+
+	var a accumulator
+	for _, step := range steps {
+		got, ok := a.accumulate(step.key, step.current)
+		if ok != step.wantOk {
+			t.Errorf("%s: accumulate => ok got: %v, want: %v", step.desc, ok, step.wantOk)
+		}
+		if got != step.want {
+			t.Errorf("%s: accumulate => got: %v, want: %v", step.desc, got, step.want)
+		}
+	}
+}
+
+func TestTrackersPrune(t *testing.T) {
+	var c counterTracker
+	var d deltaTracker
+	var a accumulator
+	record := func(keys ...string) {
+		for _, key := range keys {
+			c.delta(key, sample{100, 10, 0, 0})
+			d.delta(key, sample{100, 10, 0, 0}, 1)
+			a.accumulate(key, sample{100, 10, 0, 0})
+		}
+	}
+	prune := func() {
+		c.prune()
+		d.prune()
+		a.prune()
+	}
+
+	record("eth0:1:10", "eth0:1:11")
+	prune()
+	record("eth0:1:10")
+	prune()
+	for _, key := range []string{"eth0:1:10", "eth0:1:11"} {
+		_, inCounters := c.last[key]
+		_, inDeltas := d.last[key]
+		_, inLast := a.last[key]
+		_, inTotal := a.total[key]
+		want := key == "eth0:1:10"
+		if inCounters != want || inDeltas != want || inLast != want || inTotal != want {
+			t.Errorf("prune => %s is kept by the trackers: %v, %v, %v, %v, want: %v", key, inCounters, inDeltas, inLast, inTotal, want)
+		}
+	}
+
+	// A pruned key starts from scratch when it comes back.
+	if _, ok := c.delta("eth0:1:11", sample{200, 20, 0, 0}); ok {
+		t.Errorf("delta of a pruned key => got a delta, want none")
+	}
+	if got, _ := a.accumulate("eth0:1:11", sample{50, 5, 0, 0}); got != (sample{50, 5, 0, 0}) {
+		t.Errorf("accumulate of a pruned key => got: %v, want: %v", got, sample{50, 5, 0, 0})
+	}
+}
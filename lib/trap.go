@@ -0,0 +1,447 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+trap.go sends SNMPv2c and SNMPv3 notifications (traps) to the configured target, so that short events between the
+polling intervals aren't missed. The notifications are encoded in BER directly and sent over UDP.
+
+The notifications are defined under myOID.0:
+myOID.0.1 - tcParseFailureTrap  - TC started failing, carries tcFailuresLeaf and tcBackoffLeaf.
+myOID.0.2 - tcDropRateTrap      - The drop rate of a Qdisc / Class rose above the threshold, carries tcNameLeaf and tcDropRateLeaf.
+myOID.0.3 - tcQuotaExceededTrap - An user exceeded the quota, carries tcUserNameLeaf and tcUserQuotaUsedLeaf.
+myOID.0.4 - tcAlertTrap         - An alert rule with the trap action fired, carries the name and the metric of the alert.
+*/
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// trapLeaf is the leaf under myOID that the notifications are defined under.
+	trapLeaf = 0
+
+	// tcParseFailureTrap is sent when TC starts failing.
+	tcParseFailureTrap = 1
+
+	// tcDropRateTrap is sent when the drop rate of a Qdisc / Class rises above the threshold.
+	tcDropRateTrap = 2
+
+	// tcQuotaExceededTrap is sent when an user exceeds the quota.
+	tcQuotaExceededTrap = 3
+
+	// tcAlertTrap is sent when an alert rule with the trap action fires.
+	tcAlertTrap = 4
+
+	// sysUpTimeOID is the OID of sysUpTime.0, the first variable of every notification.
+	sysUpTimeOID = ".1.3.6.1.2.1.1.3.0"
+
+	// snmpTrapOID is the OID of snmpTrapOID.0, the second variable of every notification.
+	snmpTrapOID = ".1.3.6.1.6.3.1.1.4.1.0"
+
+	// trapVersion2c selects SNMPv2c notifications.
+	trapVersion2c = "2c"
+
+	// trapVersion3 selects SNMPv3 notifications.
+	trapVersion3 = "3"
+
+	// trapPort is the port the notifications are sent to if the target doesn't specify one.
+	trapPort = "162"
+
+	// trapQueueSize is the number of notifications waiting to be sent, the notifications are dropped when it is full.
+	trapQueueSize = 64
+)
+
+// The BER tags used in the notifications.
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berObjectID    = 0x06
+	berSequence    = 0x30
+	berGauge32     = 0x42
+	berTimeTicks   = 0x43
+	berCounter64   = 0x46
+	berTrapV2      = 0xa7
+)
+
+var (
+	// trapCommunity is the default community of SNMPv2c notifications.
+	trapCommunity = "public"
+
+	// maxGauge32 is the largest value of a Gauge32, the larger gauges are capped to it.
+	maxGauge32 int64 = 1<<32 - 1
+)
+
+// TrapOptions configures the notifications.
+type TrapOptions struct {
+	// Target is the host and port the notifications are sent to, e.g. "nms.example.com:162".
+	// The notifications aren't sent if this isn't set.
+	Target string
+
+	// Version is the SNMP version of the notifications, either "2c" or "3". Defaults to "2c".
+	Version string
+
+	// Community is the community of SNMPv2c notifications.
+	Community string
+
+	// User is the USM user name of SNMPv3 notifications.
+	User string
+
+	// AuthProtocol is the authentication protocol of SNMPv3 notifications, "MD5", "SHA" or empty for noAuthNoPriv.
+	AuthProtocol string
+
+	// AuthPassword is the authentication password of SNMPv3 notifications.
+	AuthPassword string
+
+	// PrivProtocol is the privacy protocol of SNMPv3 notifications, "AES" or empty for no privacy.
+	PrivProtocol string
+
+	// PrivPassword is the privacy password of SNMPv3 notifications.
+	PrivPassword string
+
+	// EngineID is the authoritative engine ID of SNMPv3 notifications, the receiver must know the user under it.
+	// Defaults to an engine ID derived from the hostname.
+	EngineID []byte
+
+	// Context is the SNMPv3 context name of the notifications, e.g. for receivers that separate the devices by
+	// context. Defaults to the empty default context.
+	Context string
+
+	// ContextEngineID is the SNMPv3 context engine ID of the notifications. Defaults to the EngineID.
+	ContextEngineID []byte
+}
+
+// version returns the configured version, or the default one if it wasn't set.
+func (o *TrapOptions) version() string {
+	if o.Version != "" {
+		return o.Version
+	}
+	return trapVersion2c
+}
+
+// community returns the configured community, or the default one if it wasn't set.
+func (o *TrapOptions) community() string {
+	if o.Community != "" {
+		return o.Community
+	}
+	return trapCommunity
+}
+
+// target returns the configured target with the default port if it doesn't specify one.
+func (o *TrapOptions) target() string {
+	if _, _, err := net.SplitHostPort(o.Target); err != nil {
+		return net.JoinHostPort(o.Target, trapPort)
+	}
+	return o.Target
+}
+
+// notifier sends notifications.
+type notifier interface {
+	// notify sends the notification with the variables. It must not block, the variables must not be retained.
+	notify(trap int, variables []snmpData)
+
+	// close sends the queued notifications and releases the resources. Returns an error if this doesn't finish before
+	// the context is done. notify must not be called after close.
+	close(ctx context.Context) error
+}
+
+// trapSender implements notifier.
+type trapSender struct {
+	// options holds the configurable options.
+	options *TrapOptions
+
+	// conn is the UDP connection to the target.
+	conn net.Conn
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// start is the time the sender was created, the sysUpTime is measured from it.
+	start time.Time
+
+	// baseOID is the OID the notifications and their variables are sent under, myOID if it isn't set.
+	baseOID string
+
+	// requestID is the request ID of the last notification.
+	requestID atomic.Int32
+
+	// usm secures the SNMPv3 notifications, nil for SNMPv2c.
+	usm *usm
+
+	// queue holds the encoded notifications until they are sent.
+	queue chan []byte
+
+	// done is closed when all the queued notifications were sent.
+	done chan struct{}
+}
+
+// newTrapSender creates a trapSender and starts sending the notifications in the background.
+func newTrapSender(options *TrapOptions, logger sysLogger) (*trapSender, error) {
+	t := &trapSender{
+		options: options,
+		logger:  logger,
+		start:   time.Now(),
+		queue:   make(chan []byte, trapQueueSize),
+		done:    make(chan struct{}),
+	}
+	switch options.version() {
+	case trapVersion2c:
+	case trapVersion3:
+		engineID := options.EngineID
+		if engineID == nil {
+			hostname, _ := os.Hostname()
+			engineID = defaultEngineID(hostname)
+		}
+		var err error
+		if t.usm, err = newUSM(options, engineID, t.start); err != nil {
+			return nil, err
+		}
+		logger.Info(fmt.Sprintf("newTrapSender(): Sending SNMPv3 notifications with the engine ID 0x%x.", engineID))
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version of the notifications: %s", options.Version)
+	}
+	conn, err := net.Dial("udp", options.target())
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	go t.send()
+	return t, nil
+}
+
+// notify encodes the notification and queues it for sending. The notification is dropped if the queue is full.
+func (t *trapSender) notify(trap int, variables []snmpData) {
+	message, err := t.message(trap, variables, time.Now())
+	if err != nil {
+		t.logger.Err(fmt.Sprintf("notify(): Unable to encode the notification %d, error: %s", trap, err))
+		return
+	}
+	select {
+	case t.queue <- message:
+	default:
+		t.logger.Err(fmt.Sprintf("notify(): Too many notifications waiting, dropping the notification %d.", trap))
+	}
+}
+
+// close sends the queued notifications and closes the connection.
+func (t *trapSender) close(ctx context.Context) error {
+	close(t.queue)
+	return awaitSender(ctx, t.done)
+}
+
+// send sends the queued notifications to the target until the queue is closed.
+func (t *trapSender) send() {
+	defer close(t.done)
+	defer t.conn.Close()
+	for message := range t.queue {
+		if _, err := t.conn.Write(message); err != nil {
+			t.logger.Err(fmt.Sprintf("send(): Unable to send a notification to %s, error: %s", t.options.target(), err))
+		}
+	}
+}
+
+// message encodes the notification with the variables into a SNMP message.
+func (t *trapSender) message(trap int, variables []snmpData, now time.Time) ([]byte, error) {
+	upTime := now.Sub(t.start).Milliseconds() / 10
+	varBinds := make([][]byte, 0, len(variables)+2)
+	for _, variable := range append([]snmpData{
+		{sysUpTimeOID, "timeticks", upTime},
+		{snmpTrapOID, "objectid", t.rebase(trapOID(trap))},
+	}, variables...) {
+		variable.oid = t.rebase(variable.oid)
+		varBind, err := berVarBind(variable)
+		if err != nil {
+			return nil, err
+		}
+		varBinds = append(varBinds, varBind)
+	}
+	requestID := int64(t.requestID.Add(1))
+	pdu := berTLV(berTrapV2,
+		berInt(berInteger, requestID),
+		berInt(berInteger, 0),
+		berInt(berInteger, 0),
+		berTLV(berSequence, varBinds...),
+	)
+	if t.usm != nil {
+		return t.usm.message(requestID, pdu, now)
+	}
+	return berTLV(berSequence,
+		berInt(berInteger, 1),
+		berTLV(berOctetString, []byte(t.options.community())),
+		pdu,
+	), nil
+}
+
+// rebase moves the OID from myOID to the configured base OID.
+func (t *trapSender) rebase(oid string) string {
+	if t.baseOID == "" {
+		return oid
+	}
+	return rebaseOID(oid, myOID, t.baseOID)
+}
+
+// trapOID returns the OID of the notification.
+func trapOID(trap int) string {
+	return indexOID(trapLeaf, trap)
+}
+
+// defaultEngineID returns the engine ID derived from the hostname in the RFC 3411 text format under the enterprise of myOID.
+func defaultEngineID(hostname string) []byte {
+	const maxText = 27
+	if len(hostname) > maxText {
+		hostname = hostname[:maxText]
+	}
+	return append([]byte{0x80, 0x00, 0x07, 0xe5, 0x04}, hostname...)
+}
+
+// berVarBind encodes the snmpData into a BER variable binding.
+func berVarBind(data snmpData) ([]byte, error) {
+	oid, err := berOID(data.oid)
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	switch data.objectType {
+	case "integer":
+		v, ok := data.objectValue.(int)
+		if !ok {
+			return nil, fmt.Errorf("%s: integer value isn't int", data.oid)
+		}
+		value = berInt(berInteger, int64(v))
+	case "gauge", "counter64", "timeticks":
+		v, ok := data.objectValue.(int64)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s value isn't int64", data.oid, data.objectType)
+		}
+		switch data.objectType {
+		case "gauge":
+			if v > maxGauge32 {
+				v = maxGauge32
+			}
+			value = berUint(berGauge32, uint64(v))
+		case "counter64":
+			value = berUint(berCounter64, uint64(v))
+		default:
+			value = berUint(berTimeTicks, uint64(v))
+		}
+	case "string":
+		v, ok := data.objectValue.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: string value isn't string", data.oid)
+		}
+		value = berTLV(berOctetString, []byte(v))
+	case "objectid":
+		v, ok := data.objectValue.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: objectid value isn't string", data.oid)
+		}
+		if value, err = berOID(v); err != nil {
+			return nil, err
+		}
+	default:
+		value = berTLV(berNull)
+	}
+	return berTLV(berSequence, oid, value), nil
+}
+
+// berTLV encodes the contents with the tag and the length.
+func berTLV(tag byte, contents ...[]byte) []byte {
+	var length int
+	for _, content := range contents {
+		length += len(content)
+	}
+	encoded := append([]byte{tag}, berLength(length)...)
+	for _, content := range contents {
+		encoded = append(encoded, content...)
+	}
+	return encoded
+}
+
+// berLength encodes the length of contents, in the short form if it fits into 7 bits.
+func berLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var encoded []byte
+	for ; length > 0; length >>= 8 {
+		encoded = append([]byte{byte(length)}, encoded...)
+	}
+	return append([]byte{0x80 | byte(len(encoded))}, encoded...)
+}
+
+// berInt encodes the signed integer in the minimal number of bytes.
+func berInt(tag byte, value int64) []byte {
+	var encoded []byte
+	for {
+		encoded = append([]byte{byte(value)}, encoded...)
+		// The remaining bytes are only the sign extension once the value fits into the last encoded byte.
+		if value >= -0x80 && value < 0x80 {
+			return berTLV(tag, encoded)
+		}
+		value >>= 8
+	}
+}
+
+// berUint encodes the unsigned integer in the minimal number of bytes.
+func berUint(tag byte, value uint64) []byte {
+	encoded := []byte{byte(value)}
+	for value >>= 8; value != 0; value >>= 8 {
+		encoded = append([]byte{byte(value)}, encoded...)
+	}
+	if encoded[0]&0x80 != 0 {
+		encoded = append([]byte{0}, encoded...)
+	}
+	return berTLV(tag, encoded)
+}
+
+// berOID encodes the OID in the dotted form, e.g. ".1.3.6.1".
+func berOID(oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%s: OID needs at least two parts", oid)
+	}
+	arcs := make([]uint64, len(parts))
+	for i, part := range parts {
+		arc, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid OID, error: %s", oid, err)
+		}
+		arcs[i] = arc
+	}
+	if arcs[0] > 2 || arcs[0] < 2 && arcs[1] >= 40 {
+		return nil, errors.New(oid + ": invalid first arcs of the OID")
+	}
+	arcs = append([]uint64{arcs[0]*40 + arcs[1]}, arcs[2:]...)
+
+	var encoded []byte
+	for _, arc := range arcs {
+		part := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			part = append([]byte{0x80 | byte(arc&0x7f)}, part...)
+		}
+		encoded = append(encoded, part...)
+	}
+	return berTLV(berObjectID, encoded), nil
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBerEncoding(t *testing.T) {
+	testData := []struct {
+		desc    string
+		encoded func() ([]byte, error)
+		want    []byte
+	}{
+		{
+			desc:    "zero integer",
+			encoded: func() ([]byte, error) { return berInt(berInteger, 0), nil },
+			want:    []byte{0x02, 0x01, 0x00},
+		},
+		{
+			desc:    "largest single byte integer",
+			encoded: func() ([]byte, error) { return berInt(berInteger, 127), nil },
+			want:    []byte{0x02, 0x01, 0x7f},
+		},
+		{
+			desc:    "integer that needs a leading zero",
+			encoded: func() ([]byte, error) { return berInt(berInteger, 128), nil },
+			want:    []byte{0x02, 0x02, 0x00, 0x80},
+		},
+		{
+			desc:    "negative integer",
+			encoded: func() ([]byte, error) { return berInt(berInteger, -129), nil },
+			want:    []byte{0x02, 0x02, 0xff, 0x7f},
+		},
+		{
+			desc:    "largest gauge",
+			encoded: func() ([]byte, error) { return berUint(berGauge32, 1<<32-1), nil },
+			want:    []byte{0x42, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff},
+		},
+		{
+			desc:    "long form of the length",
+			encoded: func() ([]byte, error) { return berTLV(berOctetString, make([]byte, 200))[:3], nil },
+			want:    []byte{0x04, 0x81, 0xc8},
+		},
+		{
+			desc:    "OID",
+			encoded: func() ([]byte, error) { return berOID(myOID) },
+			want:    []byte{0x06, 0x09, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x8f, 0x65, 0x81, 0x7f},
+		},
+		{
+			desc:    "gauge capped to 32 bits",
+			encoded: func() ([]byte, error) { return berVarBind(snmpData{".1.3", "gauge", int64(1 << 40)}) },
+			want:    []byte{0x30, 0x0a, 0x06, 0x01, 0x2b, 0x42, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff},
+		},
+		{
+			desc:    "counter64",
+			encoded: func() ([]byte, error) { return berVarBind(snmpData{".1.3", "counter64", int64(1 << 40)}) },
+			want:    []byte{0x30, 0x0b, 0x06, 0x01, 0x2b, 0x46, 0x06, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			desc:    "string",
+			encoded: func() ([]byte, error) { return berVarBind(snmpData{".1.3", "string", "eth0"}) },
+			want:    []byte{0x30, 0x09, 0x06, 0x01, 0x2b, 0x04, 0x04, 'e', 't', 'h', '0'},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := tc.encoded()
+			if err != nil {
+				t.Fatalf("encoding => unexpected error: %s", err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("encoding => got: % x, want: % x", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBerEncodingErrors(t *testing.T) {
+	testData := []struct {
+		desc string
+		data snmpData
+	}{
+		{
+			desc: "OID with a single part",
+			data: snmpData{".1", "integer", 1},
+		},
+		{
+			desc: "OID with an invalid part",
+			data: snmpData{".1.3.a", "integer", 1},
+		},
+		{
+			desc: "OID with invalid first arcs",
+			data: snmpData{".1.40", "integer", 1},
+		},
+		{
+			desc: "value of a wrong type",
+			data: snmpData{".1.3", "counter64", 1},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := berVarBind(tc.data); err == nil {
+				t.Errorf("berVarBind(%v) => got no error, want an error", tc.data)
+			}
+		})
+	}
+}
+
+func TestTrapSenderMessage(t *testing.T) {
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	sender := &trapSender{
+		options: &TrapOptions{},
+		start:   start,
+	}
+	got, err := sender.message(tcParseFailureTrap, []snmpData{{leafOID(tcFailuresLeaf), "integer", 3}}, start.Add(1500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("message => unexpected error: %s", err)
+	}
+	want := []byte{
+		0x30, 0x54, // Message.
+		0x02, 0x01, 0x01, // Version 2c.
+		0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c', // Community.
+		0xa7, 0x47, // SNMPv2-Trap-PDU.
+		0x02, 0x01, 0x01, // Request ID.
+		0x02, 0x01, 0x00, // Error status.
+		0x02, 0x01, 0x00, // Error index.
+		0x30, 0x3c, // Variable bindings.
+		0x30, 0x0e, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x03, 0x00, 0x43, 0x02, 0x00, 0x96, // sysUpTime.0
+		0x30, 0x19, 0x06, 0x0a, 0x2b, 0x06, 0x01, 0x06, 0x03, 0x01, 0x01, 0x04, 0x01, 0x00, // snmpTrapOID.0
+		0x06, 0x0b, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x8f, 0x65, 0x81, 0x7f, 0x00, 0x01,
+		0x30, 0x0f, 0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x8f, 0x65, 0x81, 0x7f, 0x32, 0x02, 0x01, 0x03, // tcFailuresLeaf
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("message =>\n got: % x\nwant: % x", got, want)
+	}
+}
+
+func TestNewTrapSender(t *testing.T) {
+	receiver, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket => unexpected error: %s", err)
+	}
+	defer receiver.Close()
+
+	testData := []struct {
+		desc    string
+		options *TrapOptions
+		wantErr string
+	}{
+		{
+			desc:    "SNMPv2c",
+			options: &TrapOptions{Community: "secret"},
+		},
+		{
+			desc:    "SNMPv3 authPriv",
+			options: &TrapOptions{Version: "3", User: "user", AuthProtocol: "SHA", AuthPassword: "authpassword", PrivProtocol: "AES", PrivPassword: "privpassword"},
+		},
+		{
+			desc:    "unsupported version",
+			options: &TrapOptions{Version: "1"},
+			wantErr: "unsupported SNMP version of the notifications: 1",
+		},
+		{
+			desc:    "SNMPv3 without an user",
+			options: &TrapOptions{Version: "3"},
+			wantErr: "SNMPv3 notifications need an user",
+		},
+		{
+			desc:    "SNMPv3 privacy without authentication",
+			options: &TrapOptions{Version: "3", User: "user", PrivProtocol: "AES", PrivPassword: "privpassword"},
+			wantErr: "SNMPv3 privacy needs an authentication protocol",
+		},
+		{
+			desc:    "SNMPv3 short password",
+			options: &TrapOptions{Version: "3", User: "user", AuthProtocol: "MD5", AuthPassword: "short"},
+			wantErr: "the SNMPv3 authentication password must have at least 8 characters",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.options.Target = receiver.LocalAddr().String()
+			sender, err := newTrapSender(tc.options, &fakeSyslog{})
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("newTrapSender => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newTrapSender => unexpected error: %s", err)
+			}
+
+			sender.notify(tcParseFailureTrap, []snmpData{{leafOID(tcFailuresLeaf), "integer", 1}})
+			receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 1500)
+			n, _, err := receiver.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("ReadFrom => unexpected error: %s", err)
+			}
+			if buf[0] != berSequence {
+				t.Errorf("notify => got a message starting with 0x%x, want 0x%x", buf[0], berSequence)
+			}
+			if tc.options.version() == trapVersion2c && !bytes.Contains(buf[:n], []byte(tc.options.Community)) {
+				t.Errorf("notify => got message % x without the community %s", buf[:n], tc.options.Community)
+			}
+		})
+	}
+}
+
+func TestTrapOptionsTarget(t *testing.T) {
+	for target, want := range map[string]string{
+		"nms":          "nms:162",
+		"nms:1162":     "nms:1162",
+		"[::1]:162":    "[::1]:162",
+		"192.168.1.10": "192.168.1.10:162",
+	} {
+		if got := (&TrapOptions{Target: target}).target(); got != want {
+			t.Errorf("target() of %s => got: %s, want: %s", target, got, want)
+		}
+	}
+}
+
+func TestDefaultEngineID(t *testing.T) {
+	got := defaultEngineID(strings.Repeat("h", 40))
+	if len(got) != 32 {
+		t.Errorf("defaultEngineID => got length %d, want 32", len(got))
+	}
+	if want := []byte{0x80, 0x00, 0x07, 0xe5, 0x04, 'h'}; !bytes.Equal(got[:6], want) {
+		t.Errorf("defaultEngineID => got prefix % x, want % x", got[:6], want)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+usm.go secures the SNMPv3 notifications with the User-based Security Model (RFC 3414). The messages are authenticated
+with HMAC-MD5-96 or HMAC-SHA-96 and optionally encrypted with AES-128 in CFB mode (RFC 3826).
+tc_reader is the authoritative engine of the notifications, so the keys are localized with its own engine ID.
+*/
+
+package lib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// authMD5 selects the HMAC-MD5-96 authentication.
+	authMD5 = "MD5"
+
+	// authSHA selects the HMAC-SHA-96 authentication.
+	authSHA = "SHA"
+
+	// privAES selects the AES-128 privacy.
+	privAES = "AES"
+
+	// authParamsLen is the length of the truncated HMAC in the messages.
+	authParamsLen = 12
+
+	// passwordToKeyLen is the number of bytes of the repeated password hashed into a key, see RFC 3414 A.2.
+	passwordToKeyLen = 1024 * 1024
+
+	// minPasswordLen is the shortest password accepted by RFC 3414.
+	minPasswordLen = 8
+
+	// usmMaxMessageSize is the maximum message size announced in the messages.
+	usmMaxMessageSize = 65507
+
+	// usmSecurityModel is the number of the User-based Security Model.
+	usmSecurityModel = 3
+
+	// usmEngineBoots is the number of times the engine restarted, tc_reader doesn't persist it.
+	usmEngineBoots = 1
+)
+
+// The message flags of SNMPv3 messages.
+const (
+	usmFlagAuth = 0x01
+	usmFlagPriv = 0x02
+)
+
+// usm encodes SNMPv3 messages secured with the User-based Security Model.
+type usm struct {
+	// user is the name of the user.
+	user string
+
+	// engineID is the authoritative engine ID.
+	engineID []byte
+
+	// contextEngineID is the context engine ID of the scoped PDU.
+	contextEngineID []byte
+
+	// contextName is the context name of the scoped PDU.
+	contextName string
+
+	// start is the time the engine started, the engine time is measured from it.
+	start time.Time
+
+	// authHash creates the hash of the authentication protocol, nil for noAuthNoPriv.
+	authHash func() hash.Hash
+
+	// authKey is the localized authentication key.
+	authKey []byte
+
+	// privKey is the localized privacy key, nil for no privacy.
+	privKey []byte
+
+	// salt is the last salt of the privacy, it is incremented for every message.
+	salt atomic.Uint64
+}
+
+// newUSM creates an usm for the user in the options.
+func newUSM(options *TrapOptions, engineID []byte, start time.Time) (*usm, error) {
+	if options.User == "" {
+		return nil, errors.New("SNMPv3 notifications need an user")
+	}
+	u := &usm{
+		user:            options.User,
+		engineID:        engineID,
+		contextEngineID: engineID,
+		contextName:     options.Context,
+		start:           start,
+	}
+	if options.ContextEngineID != nil {
+		u.contextEngineID = options.ContextEngineID
+	}
+	switch options.AuthProtocol {
+	case "":
+		if options.PrivProtocol != "" {
+			return nil, errors.New("SNMPv3 privacy needs an authentication protocol")
+		}
+		return u, nil
+	case authMD5:
+		u.authHash = md5.New
+	case authSHA:
+		u.authHash = sha1.New
+	default:
+		return nil, fmt.Errorf("unsupported SNMPv3 authentication protocol: %s", options.AuthProtocol)
+	}
+	if len(options.AuthPassword) < minPasswordLen {
+		return nil, fmt.Errorf("the SNMPv3 authentication password must have at least %d characters", minPasswordLen)
+	}
+	u.authKey = localizeKey(passwordToKey(options.AuthPassword, u.authHash), engineID, u.authHash)
+
+	switch options.PrivProtocol {
+	case "":
+		return u, nil
+	case privAES:
+	default:
+		return nil, fmt.Errorf("unsupported SNMPv3 privacy protocol: %s", options.PrivProtocol)
+	}
+	if len(options.PrivPassword) < minPasswordLen {
+		return nil, fmt.Errorf("the SNMPv3 privacy password must have at least %d characters", minPasswordLen)
+	}
+	u.privKey = localizeKey(passwordToKey(options.PrivPassword, u.authHash), engineID, u.authHash)[:aes.BlockSize]
+	var salt [8]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return nil, err
+	}
+	u.salt.Store(binary.BigEndian.Uint64(salt[:]))
+	return u, nil
+}
+
+// passwordToKey converts the password into a key, see RFC 3414 A.2.
+func passwordToKey(password string, newHash func() hash.Hash) []byte {
+	h := newHash()
+	repeated := make([]byte, 0, passwordToKeyLen+len(password))
+	for len(repeated) < passwordToKeyLen {
+		repeated = append(repeated, password...)
+	}
+	h.Write(repeated[:passwordToKeyLen])
+	return h.Sum(nil)
+}
+
+// localizeKey localizes the key for the engine ID, see RFC 3414 A.2.
+func localizeKey(key, engineID []byte, newHash func() hash.Hash) []byte {
+	h := newHash()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// message encodes the PDU into a secured SNMPv3 message.
+func (u *usm) message(msgID int64, pdu []byte, now time.Time) ([]byte, error) {
+	engineTime := int64(now.Sub(u.start).Seconds())
+	scopedPDU := berTLV(berSequence,
+		berTLV(berOctetString, u.contextEngineID),
+		berTLV(berOctetString, []byte(u.contextName)),
+		pdu,
+	)
+
+	var flags byte
+	var authParams, privParams []byte
+	msgData := scopedPDU
+	if u.authHash != nil {
+		flags |= usmFlagAuth
+		authParams = make([]byte, authParamsLen)
+	}
+	if u.privKey != nil {
+		flags |= usmFlagPriv
+		privParams = make([]byte, 8)
+		binary.BigEndian.PutUint64(privParams, u.salt.Add(1))
+		encrypted, err := u.encrypt(scopedPDU, engineTime, privParams)
+		if err != nil {
+			return nil, err
+		}
+		msgData = berTLV(berOctetString, encrypted)
+	}
+
+	// The authentication parameters are zero while the HMAC is computed. These end right before the privacy
+	// parameters, which end the security parameters followed by the message data.
+	privTLV := berTLV(berOctetString, privParams)
+	securityParams := berTLV(berSequence,
+		berTLV(berOctetString, u.engineID),
+		berInt(berInteger, usmEngineBoots),
+		berInt(berInteger, engineTime),
+		berTLV(berOctetString, []byte(u.user)),
+		berTLV(berOctetString, authParams),
+		privTLV,
+	)
+	message := berTLV(berSequence,
+		berInt(berInteger, 3),
+		berTLV(berSequence,
+			berInt(berInteger, msgID),
+			berInt(berInteger, usmMaxMessageSize),
+			berTLV(berOctetString, []byte{flags}),
+			berInt(berInteger, usmSecurityModel),
+		),
+		berTLV(berOctetString, securityParams),
+		msgData,
+	)
+
+	if u.authHash != nil {
+		offset := len(message) - len(msgData) - len(privTLV) - authParamsLen
+		mac := hmac.New(u.authHash, u.authKey)
+		mac.Write(message)
+		copy(message[offset:], mac.Sum(nil)[:authParamsLen])
+	}
+	return message, nil
+}
+
+// encrypt encrypts the scoped PDU with AES-128 in CFB mode, see RFC 3826.
+func (u *usm) encrypt(scopedPDU []byte, engineTime int64, salt []byte) ([]byte, error) {
+	block, err := aes.NewCipher(u.privKey)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv[0:], usmEngineBoots)
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	encrypted := make([]byte, len(scopedPDU))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted, scopedPDU)
+	return encrypted, nil
+}
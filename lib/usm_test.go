@@ -0,0 +1,169 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"testing"
+	"time"
+)
+
+// The keys from RFC 3414 A.3.
+func TestLocalizedKey(t *testing.T) {
+	engineID := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+	testData := []struct {
+		desc    string
+		newHash func() hash.Hash
+		wantKey string
+		want    string
+	}{
+		{
+			desc:    "MD5",
+			newHash: md5.New,
+			wantKey: "9faf3283884e92834ebc9847d8edd963",
+			want:    "526f5eed9fcce26f8964c2930787d82b",
+		},
+		{
+			desc:    "SHA",
+			newHash: sha1.New,
+			wantKey: "9fb5cc0381497b3793528939ff788d5d79145211",
+			want:    "6695febc9288e36282235fc7151f128497b38f3f",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			key := passwordToKey("maplesyrup", tc.newHash)
+			if got := hex.EncodeToString(key); got != tc.wantKey {
+				t.Errorf("passwordToKey => got: %s, want: %s", got, tc.wantKey)
+			}
+			if got := hex.EncodeToString(localizeKey(key, engineID, tc.newHash)); got != tc.want {
+				t.Errorf("localizeKey => got: %s, want: %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUSMMessage(t *testing.T) {
+	start := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	engineID := defaultEngineID("host")
+	pdu := berTLV(berTrapV2, berInt(berInteger, 1))
+
+	testData := []struct {
+		desc      string
+		options   *TrapOptions
+		wantFlags byte
+	}{
+		{
+			desc:      "noAuthNoPriv",
+			options:   &TrapOptions{User: "user"},
+			wantFlags: 0,
+		},
+		{
+			desc:      "authNoPriv",
+			options:   &TrapOptions{User: "user", AuthProtocol: "MD5", AuthPassword: "authpassword"},
+			wantFlags: usmFlagAuth,
+		},
+		{
+			desc:      "authPriv",
+			options:   &TrapOptions{User: "user", AuthProtocol: "SHA", AuthPassword: "authpassword", PrivProtocol: "AES", PrivPassword: "privpassword"},
+			wantFlags: usmFlagAuth | usmFlagPriv,
+		},
+		{
+			desc:      "authPriv with a context",
+			options:   &TrapOptions{User: "user", AuthProtocol: "SHA", AuthPassword: "authpassword", PrivProtocol: "AES", PrivPassword: "privpassword", Context: "shapers", ContextEngineID: []byte{0x80, 0x00, 0x07, 0xe5, 0x04}},
+			wantFlags: usmFlagAuth | usmFlagPriv,
+		},
+		{
+			desc:      "noAuthNoPriv with a context",
+			options:   &TrapOptions{User: "user", Context: "shapers"},
+			wantFlags: 0,
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			u, err := newUSM(tc.options, engineID, start)
+			if err != nil {
+				t.Fatalf("newUSM => unexpected error: %s", err)
+			}
+			message, err := u.message(7, pdu, start.Add(90*time.Second))
+			if err != nil {
+				t.Fatalf("message => unexpected error: %s", err)
+			}
+
+			flagsPosition := bytes.Index(message, []byte{0x04, 0x01})
+			if flagsPosition < 0 || message[flagsPosition+2] != tc.wantFlags {
+				t.Fatalf("message => got: % x, want flags 0x%x", message, tc.wantFlags)
+			}
+
+			// The user name is followed by the authentication parameters, the privacy parameters and the message data.
+			userTLV := berTLV(berOctetString, []byte(tc.options.User))
+			position := bytes.Index(message, userTLV) + len(userTLV)
+			contextEngineID := engineID
+			if tc.options.ContextEngineID != nil {
+				contextEngineID = tc.options.ContextEngineID
+			}
+			scopedPDU := berTLV(berSequence, berTLV(berOctetString, contextEngineID), berTLV(berOctetString, []byte(tc.options.Context)), pdu)
+			if tc.wantFlags == 0 {
+				if want := append([]byte{0x04, 0x00, 0x04, 0x00}, scopedPDU...); !bytes.Equal(message[position:], want) {
+					t.Errorf("message => got: % x, want it to end with: % x", message, want)
+				}
+				return
+			}
+
+			authParams := append([]byte{}, message[position+2:position+2+authParamsLen]...)
+			copy(message[position+2:], make([]byte, authParamsLen))
+			mac := hmac.New(u.authHash, u.authKey)
+			mac.Write(message)
+			if want := mac.Sum(nil)[:authParamsLen]; !bytes.Equal(authParams, want) {
+				t.Errorf("message => got authentication parameters: % x, want: % x", authParams, want)
+			}
+
+			position += 2 + authParamsLen
+			if tc.wantFlags&usmFlagPriv == 0 {
+				if want := append([]byte{0x04, 0x00}, scopedPDU...); !bytes.Equal(message[position:], want) {
+					t.Errorf("message => got: % x, want it to end with: % x", message, want)
+				}
+				return
+			}
+			salt := message[position+2 : position+10]
+			encrypted := message[position+12:]
+			iv := make([]byte, aes.BlockSize)
+			binary.BigEndian.PutUint32(iv[0:], usmEngineBoots)
+			binary.BigEndian.PutUint32(iv[4:], 90)
+			copy(iv[8:], salt)
+			block, err := aes.NewCipher(u.privKey)
+			if err != nil {
+				t.Fatalf("NewCipher => unexpected error: %s", err)
+			}
+			decrypted := make([]byte, len(encrypted))
+			cipher.NewCFBDecrypter(block, iv).XORKeyStream(decrypted, encrypted)
+			if !bytes.Equal(decrypted, scopedPDU) {
+				t.Errorf("message => got decrypted scoped PDU: % x, want: % x", decrypted, scopedPDU)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+walk.go runs a single parse cycle and walks the exported tree by the same GET-NEXT requests that the SNMP daemon
+sends, printing the answers like snmpwalk does, e.g.:
+.1.3.6.1.4.1.2021.255.3.1 = STRING: "eth0:2:3"
+.1.3.6.1.4.1.2021.255.4.1 = Counter64: 1500
+
+It shows what the pollers would get without configuring the pass_persist script in the SNMP daemon.
+*/
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// walkTypes are the names of the object types in the output of snmpwalk.
+var walkTypes = map[string]string{
+	"string":    "STRING",
+	"integer":   "INTEGER",
+	"counter64": "Counter64",
+	"gauge":     "Gauge32",
+	"timeticks": "Timeticks",
+}
+
+// Walk runs a single parse cycle into an SNMP handler created with the snmpOptions and writes the data under the OID
+// that the SNMP daemon would get by a walk, in the format of snmpwalk. The walk starts at the base OID if the OID is
+// empty. Returns an error if the options are invalid, if the TC commands failed or if the OID isn't exported.
+func Walk(options *TcParserOptions, snmpOptions *SnmpOptions, logger Logger, oid string, w io.Writer) error {
+	s, err := collectSnmp(options, snmpOptions, logger)
+	if err != nil {
+		return err
+	}
+	return s.walk(oid, w)
+}
+
+// collectSnmp runs a single parse cycle into an SNMP handler created with the snmpOptions and the additional
+// SnmpOptions. Nil snmpOptions use the defaults. Returns an error if the options are invalid or if the TC commands
+// failed.
+func collectSnmp(options *TcParserOptions, snmpOptions *SnmpOptions, logger Logger, opts ...SnmpOption) (*snmp, error) {
+	if logger == nil {
+		return nil, errors.New("a Logger is required")
+	}
+	once, err := onceOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	if snmpOptions == nil {
+		snmpOptions = &SnmpOptions{}
+	}
+	s, err := NewSnmp(logger, append([]SnmpOption{WithSnmpOptions(snmpOptions)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	sink := newOnceSink(nil, logger)
+	newTcParser(once, logger, s, sink).parseTc()
+	if sink.failed {
+		return nil, errors.New("the TC commands failed, see the log")
+	}
+	return s, nil
+}
+
+// walk writes the data under the OID returned by repeated GET-NEXT requests, starting at the base OID if the OID is
+// empty. The data of the OID itself is written if there is nothing under it, like snmpwalk does.
+func (s *snmp) walk(oid string, w io.Writer) error {
+	if oid == emptyString {
+		oid = s.options.baseOID()
+	}
+	internal, ok := s.internalOID(oid)
+	if !ok {
+		return fmt.Errorf("the OID %s isn't under the base OID %s", oid, s.options.baseOID())
+	}
+	tree := s.snapshot()
+	position, ok := tree.find(internal)
+	if !ok {
+		return fmt.Errorf("the OID %s isn't exported", oid)
+	}
+
+	var walked int
+	for current := oid; ; walked++ {
+		data, ok := s.next(current)
+		if !ok {
+			break
+		}
+		next, objectType, value := s.formatData(data)
+		if !strings.HasPrefix(next, oid+".") {
+			break
+		}
+		writeWalkLine(w, next, objectType, value)
+		current = next
+	}
+	if walked == 0 {
+		oid, objectType, value := s.formatData(&tree.data[position])
+		writeWalkLine(w, oid, objectType, value)
+	}
+	return nil
+}
+
+// writeWalkLine writes the data in the format of snmpwalk.
+func writeWalkLine(w io.Writer, oid, objectType, value string) {
+	switch objectType {
+	case "string":
+		value = fmt.Sprintf("%q", value)
+	case "timeticks":
+		value = fmt.Sprintf("(%s)", value)
+	}
+	fmt.Fprintf(w, "%s = %s: %s\n", oid, walkTypes[objectType], value)
+}
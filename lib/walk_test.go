@@ -0,0 +1,134 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSnmpWalk(t *testing.T) {
+	s, err := NewSnmp(&fakeSyslog{}, WithSnmpOptions(&SnmpOptions{BaseOID: ".1.3.6.1.4.1.2021.254"}))
+	if err != nil {
+		t.Fatalf("NewSnmp => unexpected error: %s", err)
+	}
+	s.begin()
+	s.erase()
+	s.addData(&parsedData{"eth0:1:3", 9, 10, 11, 12, nil, 7, 0})
+	s.commit()
+
+	testData := []struct {
+		desc    string
+		oid     string
+		want    []string
+		wantErr string
+	}{
+		{
+			desc: "subtree",
+			oid:  ".1.3.6.1.4.1.2021.254.3",
+			want: []string{`.1.3.6.1.4.1.2021.254.3.1 = STRING: "eth0:1:3"`},
+		},
+		{
+			desc: "leaf",
+			oid:  ".1.3.6.1.4.1.2021.254.4.1",
+			want: []string{".1.3.6.1.4.1.2021.254.4.1 = Counter64: 9"},
+		},
+		{
+			desc: "whole tree",
+			want: []string{
+				`.1.3.6.1.4.1.2021.254.1 = STRING: "tcIndexLeaf"`,
+				".1.3.6.1.4.1.2021.254.1.1 = INTEGER: 1",
+				".1.3.6.1.4.1.2021.254.2 = INTEGER: 1",
+			},
+		},
+		{
+			desc:    "outside of the base OID",
+			oid:     ".1.3.6.1.4.1.2021.255",
+			wantErr: "the OID .1.3.6.1.4.1.2021.255 isn't under the base OID .1.3.6.1.4.1.2021.254",
+		},
+		{
+			desc:    "not exported",
+			oid:     ".1.3.6.1.4.1.2021.254.3.2",
+			wantErr: "the OID .1.3.6.1.4.1.2021.254.3.2 isn't exported",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			var output bytes.Buffer
+			err := s.walk(tc.oid, &output)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("walk => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("walk => unexpected error: %s", err)
+			}
+			lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+			if len(lines) < len(tc.want) {
+				t.Fatalf("walk => got %d lines, want at least %d", len(lines), len(tc.want))
+			}
+			for i, want := range tc.want {
+				if lines[i] != want {
+					t.Errorf("walk => line %d got: %s, want: %s", i, lines[i], want)
+				}
+			}
+			if tc.oid != "" && len(lines) != len(tc.want) {
+				t.Errorf("walk => got %d lines, want: %d", len(lines), len(tc.want))
+			}
+		})
+	}
+}
+
+func TestWriteWalkLine(t *testing.T) {
+	testData := []struct {
+		objectType string
+		value      string
+		want       string
+	}{
+		{"string", `say "hi"`, `.1.2 = STRING: "say \"hi\""` + "\n"},
+		{"integer", "1", ".1.2 = INTEGER: 1\n"},
+		{"counter64", "15", ".1.2 = Counter64: 15\n"},
+		{"gauge", "80", ".1.2 = Gauge32: 80\n"},
+		{"timeticks", "500", ".1.2 = Timeticks: (500)\n"},
+	}
+
+	for _, tc := range testData {
+		var output bytes.Buffer
+		writeWalkLine(&output, ".1.2", tc.objectType, tc.value)
+		if got := output.String(); got != tc.want {
+			t.Errorf("writeWalkLine(%s, %s) => got: %q, want: %q", tc.objectType, tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestWalkErrors(t *testing.T) {
+	var output bytes.Buffer
+	if err := Walk(nil, nil, nil, "", &output); err == nil {
+		t.Errorf("Walk without a Logger => got no error, want an error")
+	}
+	err := Walk(&TcParserOptions{TcCmdPath: "/nonexistent/tc", Ifaces: []string{"eth0"}}, nil, &fakeSyslog{}, "", &output)
+	if err == nil || err.Error() != "the TC commands failed, see the log" {
+		t.Errorf("Walk with a failing TC => got error: %v, want: the TC commands failed, see the log", err)
+	}
+	if output.Len() != 0 {
+		t.Errorf("Walk => got output %q, want none", output.String())
+	}
+}
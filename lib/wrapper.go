@@ -0,0 +1,167 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+wrapper.go executes the TC and ip commands through the ExecWrapper, a command prefix like "sudo -n" or
+"ip netns exec blue". The interfaces are then listed by the ip command through the ExecWrapper too, instead of reading
+/sys/class/net, so that they are looked up where the TC commands run, e.g. in another network namespace.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ipLinkMaxAge is how long the interfaces listed by the ip command are reused, so that the lookups of all the
+// interfaces in a parse cycle execute it once.
+const ipLinkMaxAge = time.Second
+
+// reIpLink matches the line of an interface in the output of "ip -o link show", e.g. "2: eth0: <BROADCAST,...>" or
+// "5: veth0@if4: <BROADCAST,...>".
+var reIpLink = regexp.MustCompile(`^(?P<ifIndex>[0-9]+): (?P<iface>[^:@ ]+)(?:@[^: ]*)?: `)
+
+// executeFunc executes a command and returns a reader of its standard output.
+type executeFunc func(name string, arg ...string) (io.Reader, error)
+
+// wrapCommand returns the command and its arguments prefixed by the wrapper.
+func wrapCommand(wrapper []string, name string, arg []string) (string, []string) {
+	if len(wrapper) == 0 {
+		return name, arg
+	}
+	args := make([]string, 0, len(wrapper)+len(arg))
+	args = append(args, wrapper[1:]...)
+	args = append(args, name)
+	return wrapper[0], append(args, arg...)
+}
+
+// wrappedExecute returns an executeFunc that executes the commands through the ExecWrapper of the options by the
+// executer, the commands are killed after the CommandTimeout.
+func wrappedExecute(options *TcParserOptions, executer commandExecuter) executeFunc {
+	return func(name string, arg ...string) (io.Reader, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), options.commandTimeout())
+		defer cancel()
+		name, arg = wrapCommand(options.ExecWrapper, name, arg)
+		return readCommand(executer.Execute(ctx, name, arg...))
+	}
+}
+
+// checkWrapper executes "tc -V" through the ExecWrapper unless a recording is replayed, returns an error if it fails,
+// e.g. when sudo asks for a password or the network namespace doesn't exist.
+func (t *tcParser) checkWrapper() error {
+	if len(t.options.ExecWrapper) == 0 || t.options.ReplayDir != emptyString {
+		return nil
+	}
+	if _, err := t.execute(t.options.tcCmdPath(), "-V"); err != nil {
+		return fmt.Errorf("unable to execute %s through the exec wrapper '%s', error: %s", t.options.tcCmdPath(), strings.Join(t.options.ExecWrapper, " "), err)
+	}
+	return nil
+}
+
+// ipLinkReader implements ifaceReader by listing the interfaces with the ip command.
+type ipLinkReader struct {
+	// execute executes the ip command.
+	execute executeFunc
+
+	// ipCmdPath is the path of the ip command.
+	ipCmdPath string
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// ifIndexes are the kernel ifIndexes of the listed interfaces.
+	ifIndexes map[string]int
+
+	// ifaces are the names of the listed interfaces in the order of the output.
+	ifaces []string
+
+	// listedAt is when the interfaces were listed.
+	listedAt time.Time
+}
+
+// newIpLinkReader returns an ipLinkReader that executes the ip command by the executeFunc.
+func newIpLinkReader(execute executeFunc, ipCmdPath string) *ipLinkReader {
+	return &ipLinkReader{
+		execute:   execute,
+		ipCmdPath: ipCmdPath,
+	}
+}
+
+// ifIndex returns the kernel ifIndex of the interface.
+func (r *ipLinkReader) ifIndex(iface string) (int, error) {
+	ifIndexes, _, err := r.links()
+	if err != nil {
+		return 0, err
+	}
+	index, ok := ifIndexes[iface]
+	if !ok {
+		return 0, fmt.Errorf("the interface %s isn't present", iface)
+	}
+	return index, nil
+}
+
+// list returns the names of all the interfaces.
+func (r *ipLinkReader) list() ([]string, error) {
+	_, ifaces, err := r.links()
+	return ifaces, err
+}
+
+// present determines whether the interface is present, it isn't if the interfaces can't be listed.
+func (r *ipLinkReader) present(iface string) bool {
+	_, err := r.ifIndex(iface)
+	return err == nil
+}
+
+// links returns the ifIndexes and the names of the interfaces, listed by the ip command unless they were listed less
+// than ipLinkMaxAge ago.
+func (r *ipLinkReader) links() (map[string]int, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ifIndexes != nil && time.Since(r.listedAt) < ipLinkMaxAge {
+		return r.ifIndexes, r.ifaces, nil
+	}
+	output, err := r.execute(r.ipCmdPath, "-o", "link", "show")
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to list the interfaces, error: %s", err)
+	}
+	ifIndexes := make(map[string]int)
+	var ifaces []string
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		match := reIpLink.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		index, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		ifIndexes[match[2]] = index
+		ifaces = append(ifaces, match[2])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("unable to list the interfaces, error: %s", err)
+	}
+	r.ifIndexes, r.ifaces, r.listedAt = ifIndexes, ifaces, time.Now()
+	return ifIndexes, ifaces, nil
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTcParserExecWrapper(t *testing.T) {
+	testData := []struct {
+		desc        string
+		wrapper     []string
+		wantCommand string
+		wantArgs    []string
+	}{
+		{
+			desc:        "no wrapper",
+			wantCommand: "/sbin/tc",
+			wantArgs:    []string{"-s", "qdisc", "show", "dev", "eth0"},
+		},
+		{
+			desc:        "sudo",
+			wrapper:     []string{"sudo", "-n"},
+			wantCommand: "sudo",
+			wantArgs:    []string{"-n", "/sbin/tc", "-s", "qdisc", "show", "dev", "eth0"},
+		},
+		{
+			desc:        "network namespace",
+			wrapper:     []string{"ip", "netns", "exec", "blue"},
+			wantCommand: "ip",
+			wantArgs:    []string{"netns", "exec", "blue", "/sbin/tc", "-s", "qdisc", "show", "dev", "eth0"},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			fe := &fakeExecuter{output: []string{""}, err: []error{nil}}
+			p := &tcParser{
+				options:  &TcParserOptions{ExecWrapper: tc.wrapper},
+				executer: fe,
+			}
+			if _, err := p.execute("/sbin/tc", "-s", "qdisc", "show", "dev", "eth0"); err != nil {
+				t.Fatalf("execute => unexpected error: %s", err)
+			}
+			if fe.command[0] != tc.wantCommand || !reflect.DeepEqual(fe.args[0], tc.wantArgs) {
+				t.Errorf("execute => got: %s %q, want: %s %q", fe.command[0], fe.args[0], tc.wantCommand, tc.wantArgs)
+			}
+		})
+	}
+}
+
+func TestIpLinkReader(t *testing.T) {
+	output := "1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN mode DEFAULT group default qlen 1000\\    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00\n" +
+		"2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc htb state UP mode DEFAULT group default qlen 1000\\    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff\n" +
+		"5: veth0@if4: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP mode DEFAULT group default qlen 1000\\    link/ether 0a:58:0a:f4:00:01 brd ff:ff:ff:ff:ff:ff link-netnsid 0\n"
+	fe := &fakeExecuter{output: []string{output}, err: []error{nil}}
+	p := &tcParser{options: &TcParserOptions{ExecWrapper: []string{"ip", "netns", "exec", "blue"}}, executer: fe}
+	r := newIpLinkReader(p.execute, "/sbin/ip")
+
+	ifaces, err := r.list()
+	if err != nil {
+		t.Fatalf("list => unexpected error: %s", err)
+	}
+	if want := []string{"lo", "eth0", "veth0"}; !reflect.DeepEqual(ifaces, want) {
+		t.Errorf("list => got: %q, want: %q", ifaces, want)
+	}
+	if index, err := r.ifIndex("veth0"); err != nil || index != 5 {
+		t.Errorf("ifIndex(veth0) => got: %d, %v, want: 5, nil", index, err)
+	}
+	if !r.present("eth0") {
+		t.Errorf("present(eth0) => got: false, want: true")
+	}
+	if r.present("eth1") {
+		t.Errorf("present(eth1) => got: true, want: false")
+	}
+	// The lookups reuse the listed interfaces, the fakeExecuter would panic on another command.
+	if len(fe.command) != 1 {
+		t.Errorf("ipLinkReader => executed %d commands, want: 1", len(fe.command))
+	}
+	if want := []string{"netns", "exec", "blue", "/sbin/ip", "-o", "link", "show"}; !reflect.DeepEqual(fe.args[0], want) {
+		t.Errorf("ipLinkReader => got arguments: %q, want: %q", fe.args[0], want)
+	}
+}
+
+func TestIpLinkReaderError(t *testing.T) {
+	// The failures aren't reused, every lookup executes the ip command again.
+	failure := errors.New("Cannot open network namespace \"blue\"")
+	fe := &fakeExecuter{output: []string{"", ""}, err: []error{failure, failure}}
+	r := newIpLinkReader((&tcParser{options: &TcParserOptions{}, executer: fe}).execute, "/sbin/ip")
+	if _, err := r.list(); err == nil {
+		t.Errorf("list => got no error, want one")
+	}
+	if r.present("eth0") {
+		t.Errorf("present(eth0) => got: true, want: false")
+	}
+}
+
+func TestNewTcParserExecWrapper(t *testing.T) {
+	testData := []struct {
+		desc    string
+		wrapper []string
+		wantErr string
+	}{
+		{
+			desc:    "the wrapper executes TC",
+			wrapper: []string{"env"},
+		},
+		{
+			desc:    "the wrapper fails",
+			wrapper: []string{"false"},
+			wantErr: "unable to execute true through the exec wrapper 'false', error: exit status 1",
+		},
+		{
+			desc:    "empty argument",
+			wrapper: []string{"sudo", ""},
+			wantErr: "the arguments of the exec wrapper must not be empty",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			options := &TcParserOptions{TcCmdPath: "true", ExecWrapper: tc.wrapper, Prometheus: &PrometheusOptions{File: t.TempDir() + "/tc.prom"}}
+			p, err := NewTcParser(&fakeSyslog{}, WithTcParserOptions(options))
+			if tc.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.wantErr) {
+					t.Errorf("NewTcParser => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewTcParser => unexpected error: %s", err)
+			}
+			if _, ok := p.ifaceReader.(*ipLinkReader); !ok {
+				t.Errorf("NewTcParser => got ifaceReader: %T, want: *ipLinkReader", p.ifaceReader)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+xstats.go parses the extended statistics (xstats) that TC prints for some Qdiscs and Classes after the standard statistics.
+
+Example of HTB Class xstats:
+ lended: 4250 borrowed: 0 giants: 0
+ tokens: 444750 ctokens: 444750
+
+Example of fq_codel Qdisc xstats:
+  maxpacket 1514 drop_overlimit 0 new_flow_count 42 ecn_mark 0
+  new_flows_len 0 old_flows_len 0
+*/
+
+package lib
+
+import (
+	"strings"
+)
+
+// standardStatsPrefixes are the prefixes of lines with the standard statistics that follow the sent statistics, these aren't xstats.
+var standardStatsPrefixes = []string{"rate ", "backlog "}
+
+// xstat is a single extended statistic of a Qdisc / Class.
+type xstat struct {
+	// name is the name of the statistic as printed by TC, e.g. "drop_overlimit".
+	name string
+
+	// value is the value as printed by TC, it can include units, e.g. "2us".
+	value string
+}
+
+// parseXstatsLine parses a line of xstats into the name / value pairs.
+// Both "name value" and "name: value" pairs are supported, names without a value (flags like "dropping") are skipped.
+// Returns nil if the line doesn't contain xstats.
+func parseXstatsLine(line string) []xstat {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == emptyString {
+		return nil
+	}
+	for _, prefix := range standardStatsPrefixes {
+		if strings.HasPrefix(trimmed, prefix) {
+			return nil
+		}
+	}
+
+	fields := strings.Fields(trimmed)
+	var xstats []xstat
+	for i := 0; i < len(fields); i++ {
+		if i+1 < len(fields) && isXstatValue(fields[i+1]) {
+			xstats = append(xstats, xstat{
+				name:  strings.TrimSuffix(fields[i], ":"),
+				value: fields[i+1],
+			})
+			i++
+		}
+	}
+	return xstats
+}
+
+// isXstatValue determines whether the field is a value, i.e. a number optionally followed by units.
+func isXstatValue(field string) bool {
+	field = strings.TrimPrefix(field, "-")
+	return field != emptyString && field[0] >= '0' && field[0] <= '9'
+}
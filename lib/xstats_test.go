@@ -0,0 +1,66 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseXstatsLine(t *testing.T) {
+	testData := []struct {
+		desc string
+		line string
+		want []xstat
+	}{
+		{
+			desc: "empty line",
+			line: "",
+		},
+		{
+			desc: "rate line isn't xstats",
+			line: " rate 8536bit 5pps backlog 0b 0p requeues 0 ",
+		},
+		{
+			desc: "backlog line isn't xstats",
+			line: " backlog 0b 0p requeues 0 ",
+		},
+		{
+			desc: "htb xstats with colons",
+			line: " lended: 4250 borrowed: 0 giants: 0",
+			want: []xstat{{"lended", "4250"}, {"borrowed", "0"}, {"giants", "0"}},
+		},
+		{
+			desc: "fq_codel xstats without colons",
+			line: "  maxpacket 1514 drop_overlimit 0 new_flow_count 42 ecn_mark 0",
+			want: []xstat{{"maxpacket", "1514"}, {"drop_overlimit", "0"}, {"new_flow_count", "42"}, {"ecn_mark", "0"}},
+		},
+		{
+			desc: "values with units and a flag without value",
+			line: "  count 1 lastcount 0 ldelay 2us dropping drop_next -3us",
+			want: []xstat{{"count", "1"}, {"lastcount", "0"}, {"ldelay", "2us"}, {"drop_next", "-3us"}},
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := parseXstatsLine(tc.line); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseXstatsLine(%q) => got: %v, want: %v", tc.line, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+
+zabbix.go pushes the exported metrics to a Zabbix server or proxy after each parse cycle, using the sender (trapper)
+protocol of zabbix_sender.
+
+The item keys are created from a template, e.g. "tc.{metric}[{iface},{class}]", see sink.go for the placeholders.
+The interface and the class are quoted as item key parameters where needed, e.g. tc.sentBytes[eth0,2:3].
+
+The interfaces, Qdiscs / Classes, users and groups are sent for low-level discovery to the trapper items
+"tc.discovery[iface]", "tc.discovery[class]", "tc.discovery[user]" and "tc.discovery[group]" with the macros {#NAME},
+{#IFACE} and {#CLASS} having the same values as in the item keys. The discovery is sent when it changes and at least
+once every zabbixDiscoveryInterval.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// zabbixPort is the default port of the Zabbix trapper.
+	zabbixPort = "10051"
+
+	// zabbixQueueSize is the number of parse cycles waiting to be sent, the further ones are dropped.
+	zabbixQueueSize = 4
+
+	// zabbixTimeout is the timeout of a request to the Zabbix server.
+	zabbixTimeout = 10 * time.Second
+
+	// zabbixMaxResponse is the size of the largest response accepted from the Zabbix server.
+	zabbixMaxResponse = 1 << 20
+
+	// zabbixDiscoveryInterval is the longest time after which the discovery is sent again even if it didn't change.
+	zabbixDiscoveryInterval = time.Hour
+
+	// zabbixDiscoveryKey is the key of the discovery items, the kind is its parameter.
+	zabbixDiscoveryKey = "tc.discovery[%s]"
+)
+
+var (
+	// zabbixKey is the default template of the item keys.
+	zabbixKey = "tc.{metric}[{iface},{class}]"
+
+	// zabbixHeader starts the requests and the responses of the Zabbix protocol, followed by the length of the data.
+	zabbixHeader = []byte("ZBXD\x01")
+
+	// zabbixKinds are the kinds of the discovery, in the order they are sent.
+	zabbixKinds = []string{"iface", "class", "user", "group"}
+
+	// reZabbixFailed matches the number of the failed items in the info of the response.
+	reZabbixFailed = regexp.MustCompile(`failed: ([0-9]+)`)
+)
+
+// ZabbixOptions are the options of the Zabbix sink.
+type ZabbixOptions struct {
+	// Server is the host and port of the Zabbix server or proxy, e.g. "zabbix.example.com:10051".
+	// The metrics aren't sent if this isn't set.
+	Server string
+
+	// Host is the name of the host in Zabbix that the items belong to. Defaults to the hostname.
+	Host string
+
+	// Key is the template of the item keys. Defaults to zabbixKey.
+	Key string
+}
+
+// server returns the configured server with the default port if it doesn't specify one.
+func (o *ZabbixOptions) server() string {
+	if _, _, err := net.SplitHostPort(o.Server); err != nil {
+		return net.JoinHostPort(o.Server, zabbixPort)
+	}
+	return o.Server
+}
+
+// host returns the configured host, or the hostname if it wasn't set.
+func (o *ZabbixOptions) host(hostname string) string {
+	if o.Host != "" {
+		return o.Host
+	}
+	return hostname
+}
+
+// key returns the configured template of the item keys, or the default one if it wasn't set.
+func (o *ZabbixOptions) key() string {
+	if o.Key != "" {
+		return o.Key
+	}
+	return zabbixKey
+}
+
+// zabbixItem is a value of an item sent to Zabbix.
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+// zabbixRequest is the request of the sender protocol.
+type zabbixRequest struct {
+	Request string       `json:"request"`
+	Data    []zabbixItem `json:"data"`
+	Clock   int64        `json:"clock"`
+}
+
+// zabbixResponse is the response of the sender protocol.
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+// zabbixSink implements metricSink.
+type zabbixSink struct {
+	// options holds the configurable options.
+	options *ZabbixOptions
+
+	// logger is the Writer used to log messages to Syslog.
+	logger sysLogger
+
+	// host is the name of the host in Zabbix.
+	host string
+
+	// discovery is the last sent discovery of each kind.
+	discovery map[string]string
+
+	// discoveredAt is the time the discovery was last sent.
+	discoveredAt time.Time
+
+	// queue holds the encoded requests until they are sent.
+	queue chan []byte
+
+	// done is closed when all the queued requests were sent.
+	done chan struct{}
+}
+
+// newZabbixSink creates a zabbixSink and starts sending the metrics in the background.
+func newZabbixSink(options *ZabbixOptions, logger sysLogger) *zabbixSink {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Err(fmt.Sprintf("newZabbixSink(): Unable to determine the hostname for Zabbix, error: %s", err))
+	}
+	z := &zabbixSink{
+		options:   options,
+		logger:    logger,
+		host:      options.host(hostname),
+		discovery: make(map[string]string),
+		queue:     make(chan []byte, zabbixQueueSize),
+		done:      make(chan struct{}),
+	}
+	go z.send()
+	return z
+}
+
+// flush encodes the discovery and the values into a request and queues it. The request is dropped if the queue is
+// full, the discovery is then sent again in the next parse cycle.
+func (z *zabbixSink) flush(values []metricValue, at time.Time) {
+	if len(values) == 0 {
+		return
+	}
+	items := z.discoveryItems(values, at)
+	for _, v := range values {
+		items = append(items, zabbixItem{
+			Host:  z.host,
+			Key:   metricPath(z.options.key(), z.host, v, zabbixParam),
+			Value: fmt.Sprint(v.value),
+			Clock: at.Unix(),
+		})
+	}
+	request, err := zabbixMessage(zabbixRequest{"sender data", items, at.Unix()})
+	if err != nil {
+		z.logger.Err(fmt.Sprintf("flush(): Unable to encode the metrics for Zabbix, error: %s", err))
+		return
+	}
+	select {
+	case z.queue <- request:
+	default:
+		z.discovery = make(map[string]string)
+		z.logger.Err(fmt.Sprintf("flush(): Too many parse cycles waiting, dropping the metrics for %s.", z.options.server()))
+	}
+}
+
+// discoveryItems returns the discovery of the kinds that changed, or of all the kinds once every
+// zabbixDiscoveryInterval.
+func (z *zabbixSink) discoveryItems(values []metricValue, at time.Time) []zabbixItem {
+	all := at.Sub(z.discoveredAt) >= zabbixDiscoveryInterval
+	if all {
+		z.discoveredAt = at
+	}
+	discovery := zabbixDiscovery(values)
+	var items []zabbixItem
+	for _, kind := range zabbixKinds {
+		if !all && z.discovery[kind] == discovery[kind] {
+			continue
+		}
+		z.discovery[kind] = discovery[kind]
+		items = append(items, zabbixItem{
+			Host:  z.host,
+			Key:   fmt.Sprintf(zabbixDiscoveryKey, kind),
+			Value: discovery[kind],
+			Clock: at.Unix(),
+		})
+	}
+	return items
+}
+
+// zabbixDiscovery returns the low-level discovery JSON of each of the zabbixKinds.
+func zabbixDiscovery(values []metricValue) map[string]string {
+	type macros map[string]string
+	rows := make(map[string][]macros)
+	seen := make(map[string]bool)
+	for _, v := range values {
+		iface, class := v.components()
+		kind := v.kind()
+		if key := kind + "/" + v.name; !seen[key] {
+			seen[key] = true
+			rows[kind] = append(rows[kind], macros{"{#NAME}": v.name, "{#IFACE}": iface, "{#CLASS}": class})
+		}
+		if key := "iface/" + iface; kind == "class" && !seen[key] {
+			seen[key] = true
+			rows["iface"] = append(rows["iface"], macros{"{#IFACE}": iface})
+		}
+	}
+
+	discovery := make(map[string]string)
+	for _, kind := range zabbixKinds {
+		data := rows[kind]
+		if data == nil {
+			data = []macros{}
+		}
+		// Maps of strings are always encoded.
+		encoded, _ := json.Marshal(struct {
+			Data []macros `json:"data"`
+		}{data})
+		discovery[kind] = string(encoded)
+	}
+	return discovery
+}
+
+// close sends the queued requests.
+func (z *zabbixSink) close(ctx context.Context) error {
+	close(z.queue)
+	return awaitSender(ctx, z.done)
+}
+
+// send sends the queued requests to the Zabbix server until the queue is closed.
+func (z *zabbixSink) send() {
+	defer close(z.done)
+	for request := range z.queue {
+		response, err := z.request(request)
+		if err != nil {
+			z.logger.Err(fmt.Sprintf("send(): Unable to send the metrics to %s, error: %s", z.options.server(), err))
+			continue
+		}
+		if response.Response != "success" {
+			z.logger.Err(fmt.Sprintf("send(): %s refused the metrics, response: %s, info: %s", z.options.server(), response.Response, response.Info))
+			continue
+		}
+		if match := reZabbixFailed.FindStringSubmatch(response.Info); match != nil && match[1] != "0" {
+			z.logger.Err(fmt.Sprintf("send(): %s didn't accept some of the metrics, check the items of the host %s, info: %s", z.options.server(), z.host, response.Info))
+		}
+	}
+}
+
+// request sends the request to the Zabbix server and reads the response.
+func (z *zabbixSink) request(request []byte) (*zabbixResponse, error) {
+	conn, err := net.DialTimeout("tcp", z.options.server(), zabbixTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(zabbixTimeout))
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(zabbixHeader)], zabbixHeader) {
+		return nil, fmt.Errorf("unexpected response header %q", header[:len(zabbixHeader)])
+	}
+	length := binary.LittleEndian.Uint64(header[len(zabbixHeader):])
+	if length > zabbixMaxResponse {
+		return nil, fmt.Errorf("response of %d bytes is too long", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	response := &zabbixResponse{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// zabbixMessage encodes the request with the header of the Zabbix protocol.
+func zabbixMessage(request zabbixRequest) ([]byte, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, len(zabbixHeader)+8, len(zabbixHeader)+8+len(data))
+	copy(message, zabbixHeader)
+	binary.LittleEndian.PutUint64(message[len(zabbixHeader):], uint64(len(data)))
+	return append(message, data...), nil
+}
+
+// zabbixParam quotes the item key parameter if it contains characters that aren't allowed in unquoted parameters.
+func zabbixParam(param string) string {
+	if !strings.ContainsAny(param, ",]\"[ ") {
+		return param
+	}
+	return `"` + strings.ReplaceAll(param, `"`, `\"`) + `"`
+}
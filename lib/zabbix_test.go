@@ -0,0 +1,225 @@
+/*
+Copyright 2013 Google Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestZabbixOptions(t *testing.T) {
+	testData := []struct {
+		options    *ZabbixOptions
+		wantServer string
+		wantHost   string
+		wantKey    string
+	}{
+		{&ZabbixOptions{Server: "zabbix"}, "zabbix:10051", "router", "tc.{metric}[{iface},{class}]"},
+		{&ZabbixOptions{Server: "zabbix:10151", Host: "shaper", Key: "tc.{metric}[{class}]"}, "zabbix:10151", "shaper", "tc.{metric}[{class}]"},
+	}
+
+	for _, tc := range testData {
+		if got := tc.options.server(); got != tc.wantServer {
+			t.Errorf("server(%+v) => got: %s, want: %s", tc.options, got, tc.wantServer)
+		}
+		if got := tc.options.host("router"); got != tc.wantHost {
+			t.Errorf("host(%+v) => got: %s, want: %s", tc.options, got, tc.wantHost)
+		}
+		if got := tc.options.key(); got != tc.wantKey {
+			t.Errorf("key(%+v) => got: %s, want: %s", tc.options, got, tc.wantKey)
+		}
+	}
+}
+
+func TestZabbixParam(t *testing.T) {
+	testData := []struct {
+		param string
+		want  string
+	}{
+		{"eth0", "eth0"},
+		{"2:3", "2:3"},
+		{"", ""},
+		{"john doe", `"john doe"`},
+		{`a,"b"]`, `"a,\"b\"]"`},
+	}
+
+	for _, tc := range testData {
+		if got := zabbixParam(tc.param); got != tc.want {
+			t.Errorf("zabbixParam(%s) => got: %s, want: %s", tc.param, got, tc.want)
+		}
+	}
+}
+
+func TestZabbixDiscovery(t *testing.T) {
+	values := []metricValue{
+		{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1)},
+		{"sentPkt", tcNameLeaf, "eth0:2:3", int64(1)},
+		{"sentBytes", tcNameLeaf, "eth0:2:4", int64(1)},
+		{"userUpBytes", tcUserNameLeaf, "john", int64(1)},
+	}
+	want := map[string]string{
+		"iface": `{"data":[{"{#IFACE}":"eth0"}]}`,
+		"class": `{"data":[{"{#CLASS}":"2:3","{#IFACE}":"eth0","{#NAME}":"eth0:2:3"},{"{#CLASS}":"2:4","{#IFACE}":"eth0","{#NAME}":"eth0:2:4"}]}`,
+		"user":  `{"data":[{"{#CLASS}":"john","{#IFACE}":"users","{#NAME}":"john"}]}`,
+		"group": `{"data":[]}`,
+	}
+	if got := zabbixDiscovery(values); !reflect.DeepEqual(got, want) {
+		t.Errorf("zabbixDiscovery => got: %v, want: %v", got, want)
+	}
+}
+
+func TestZabbixSinkDiscoveryItems(t *testing.T) {
+	z := &zabbixSink{host: "router", discovery: make(map[string]string)}
+	start := time.Unix(1357000000, 0)
+	class := []metricValue{{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1)}}
+	user := []metricValue{{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1)}, {"userUpBytes", tcUserNameLeaf, "john", int64(1)}}
+	steps := []struct {
+		values   []metricValue
+		at       time.Time
+		wantKeys []string
+	}{
+		{class, start, []string{"tc.discovery[iface]", "tc.discovery[class]", "tc.discovery[user]", "tc.discovery[group]"}},
+		{class, start.Add(time.Minute), nil},
+		{user, start.Add(2 * time.Minute), []string{"tc.discovery[user]"}},
+		{user, start.Add(time.Hour), []string{"tc.discovery[iface]", "tc.discovery[class]", "tc.discovery[user]", "tc.discovery[group]"}},
+	}
+
+	for i, step := range steps {
+		var keys []string
+		for _, item := range z.discoveryItems(step.values, step.at) {
+			keys = append(keys, item.Key)
+		}
+		if !reflect.DeepEqual(keys, step.wantKeys) {
+			t.Errorf("step %d => discoveryItems got keys: %q, want: %q", i, keys, step.wantKeys)
+		}
+	}
+}
+
+func TestZabbixSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen => unexpected error: %s", err)
+	}
+	defer listener.Close()
+
+	z := newZabbixSink(&ZabbixOptions{Server: listener.Addr().String(), Host: "router"}, &fakeSyslog{})
+	z.flush([]metricValue{{"sentBytes", tcNameLeaf, "eth0:2:3", int64(1500)}}, time.Unix(1357000000, 0))
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept => unexpected error: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("ReadFull => unexpected error: %s", err)
+	}
+	if !bytes.Equal(header[:5], []byte("ZBXD\x01")) {
+		t.Fatalf("zabbixSink => sent header: %q, want: ZBXD\\x01", header[:5])
+	}
+	data := make([]byte, binary.LittleEndian.Uint64(header[5:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatalf("ReadFull => unexpected error: %s", err)
+	}
+	var request zabbixRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatalf("Unmarshal => unexpected error: %s", err)
+	}
+	if request.Request != "sender data" || len(request.Data) != 5 {
+		t.Fatalf("zabbixSink => sent request: %s, want sender data with 4 discovery items and 1 value", data)
+	}
+	want := zabbixItem{"router", "tc.sentBytes[eth0,2:3]", "1500", 1357000000}
+	if got := request.Data[4]; got != want {
+		t.Errorf("zabbixSink => sent item: %+v, want: %+v", got, want)
+	}
+
+	if _, err := conn.Write(zabbixFrame(`{"response":"success","info":"processed: 5; failed: 0; total: 5"}`)); err != nil {
+		t.Fatalf("Write => unexpected error: %s", err)
+	}
+}
+
+func TestZabbixSinkRequest(t *testing.T) {
+	testData := []struct {
+		desc     string
+		response []byte
+		want     *zabbixResponse
+		wantErr  string
+	}{
+		{
+			desc:     "success",
+			response: zabbixFrame(`{"response":"success","info":"processed: 1; failed: 0; total: 1"}`),
+			want:     &zabbixResponse{Response: "success", Info: "processed: 1; failed: 0; total: 1"},
+		},
+		{
+			desc:     "bad header",
+			response: []byte("HTTP/1.1 400 Bad"),
+			wantErr:  `unexpected response header "HTTP/"`,
+		},
+		{
+			desc:     "too long",
+			response: []byte("ZBXD\x01\x00\x00\x00\x01\x00\x00\x00\x00"),
+			wantErr:  "response of 16777216 bytes is too long",
+		},
+	}
+
+	for _, tc := range testData {
+		t.Run(tc.desc, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen => unexpected error: %s", err)
+			}
+			defer listener.Close()
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.Write(tc.response)
+			}()
+
+			z := &zabbixSink{options: &ZabbixOptions{Server: listener.Addr().String()}}
+			got, err := z.request([]byte("ZBXD\x01\x00\x00\x00\x00\x00\x00\x00\x00"))
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("request => got error: %v, want: %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request => unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("request => got: %+v, want: %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// zabbixFrame prefixes the data with the header of the Zabbix protocol.
+func zabbixFrame(data string) []byte {
+	frame := append([]byte("ZBXD\x01"), make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(frame[5:], uint64(len(data)))
+	return append(frame, data...)
+}
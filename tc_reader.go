@@ -18,16 +18,23 @@ Package main starts tc_reader that acts as a pass_persist script for the Net-SNM
 
 It extends SNMP information by adding data under the configured myOID (defaults to .1.3.6.1.4.1.2021.255)
 
+myOID itself stores a string identifying this process, see the identity option in the config file.
+
 myOID data will be in this hierarchy:
 myOID.1 - tcIndexLeaf                   - Stores integers, the SNMP indexes assigned to Qdiscs and Classes.
 myOID.2 - tcNumIndexLeaf                - Stores an integer, the count of indexes assigned to Qdiscs and Classes.
 myOID.3 - tcNameLeaf                    - Stores strings, the names of Qdiscs and Classes. Names are in the form "eth0:2:3", which means interface eth0, Qdisc 2, Class 3.
+                                          Duplicate names, e.g. of the child Qdiscs of a multiqueue Qdisc, get a "#2", "#3", ... suffix in the order of the TC output.
 myOID.4 - sentBytesLeaf                 - Stores counter32, the sent bytes for each tcIndex.
 myOID.5 - sentPktLeaf                   - Stores counter32, the sent packets for each tcIndex.
 myOID.6 - droppedPktLeaf                - Stores counter32, the dropped packets for each tcIndex.
 myOID.7 - overLimitPktLeaf              - Stores counter32, the over limit packets for each tcIndex.
 
-You can further configure user names, by assigning two specific tcNames to user names. One as upload and the other one as download direction. If this is configured, the output will further contain:
+The counters of the Qdiscs / Classes, users and groups are accumulated in 64 bits since start. They don't decrease
+when TC resets them, e.g. after a Qdisc was replaced, or when a 32-bit counter of an older kernel wraps.
+
+You can further configure user names, by assigning tcNames to user names in upload and download direction. The counters of multiple tcNames in one direction are summed.
+Users can also be defined by IP addresses, the Classes that the TC filters direct the addresses to are counted for the users. If this is configured, the output will further contain:
 myOID.8 - tcUserIndexLeaf               - Stores integers, the SNMP indexes assigned to the configured user names.
 myOID.9 - tcUserNumIndexLeaf            - Stores an integer, the count of indexes assigned to the configured user names.
 myOID.10 - tcUserNameLeaf               - Stores strings, the names of the configured user names.
@@ -39,12 +46,283 @@ myOID.15 - tcUserUpBytesLeaf            - Stores counter32, the uploaded bytes f
 myOID.16 - tcUserUpPktLeaf              - Stores counter32, the uploaded packets for each tcUserIndex.
 myOID.17 - tcUserUpDroppedPktLeaf       - Stores counter32, the dropped packets in upload direction for each tcUserIndex.
 myOID.18 - tcUserUpOverLimitPktLeaf     - Stores counter32, the over limit packets in upload direction for each tcUserIndex.
+myOID.19 - tcIfIndexLeaf                - Stores integers, the kernel ifIndex of the interface for each tcIndex. Can be used to join with the IF-MIB ifTable.
+
+The Qdiscs / Classes or the users can be left out of the tree by the exportGeneric and exportUsers options, e.g. on
+deployments that only need the accounting of the users. The exportMetrics option leaves out the leaves of the
+metrics that aren't needed, e.g. the packets and over limit packets.
+
+Non-fatal problems found while parsing the TC output (e.g. unrecognized header lines) are exported as:
+myOID.20 - tcWarningLeaf                - Stores strings, the most recent parse warnings, the oldest one first.
+myOID.21 - tcNumWarningsLeaf            - Stores counter64, the total number of parse warnings since start.
+
+The average packet size of the configured users is computed from the difference of the bytes and packets against the previous parse interval:
+myOID.22 - tcUserDownAvgPktSizeLeaf     - Stores gauges, the average packet size in download direction during the last interval for each tcUserIndex.
+myOID.23 - tcUserUpAvgPktSizeLeaf       - Stores gauges, the average packet size in upload direction during the last interval for each tcUserIndex.
+
+If the pktSizeBuckets option is configured, the intervals are further counted by their average packet size:
+myOID.24 - tcUserDownSmallPktLeaf       - Stores counter64, the intervals with small average packet size in download direction for each tcUserIndex.
+myOID.25 - tcUserDownMediumPktLeaf      - Stores counter64, the intervals with medium average packet size in download direction for each tcUserIndex.
+myOID.26 - tcUserDownLargePktLeaf       - Stores counter64, the intervals with large average packet size in download direction for each tcUserIndex.
+myOID.27 - tcUserUpSmallPktLeaf         - Stores counter64, the intervals with small average packet size in upload direction for each tcUserIndex.
+myOID.28 - tcUserUpMediumPktLeaf        - Stores counter64, the intervals with medium average packet size in upload direction for each tcUserIndex.
+myOID.29 - tcUserUpLargePktLeaf         - Stores counter64, the intervals with large average packet size in upload direction for each tcUserIndex.
+
+You can further configure interface groups, the counters of the root Qdiscs on the member interfaces are summed. If this is configured, the output will further contain:
+myOID.30 - tcGroupIndexLeaf             - Stores integers, the SNMP indexes assigned to the configured groups.
+myOID.31 - tcGroupNumIndexLeaf          - Stores an integer, the count of indexes assigned to the configured groups.
+myOID.32 - tcGroupNameLeaf              - Stores strings, the names of the configured groups.
+myOID.33 - tcGroupBytesLeaf             - Stores counter64, the sent bytes summed over the group members for each tcGroupIndex.
+myOID.34 - tcGroupPktLeaf               - Stores counter64, the sent packets summed over the group members for each tcGroupIndex.
+myOID.35 - tcGroupDroppedPktLeaf        - Stores counter64, the dropped packets summed over the group members for each tcGroupIndex.
+myOID.36 - tcGroupOverLimitPktLeaf      - Stores counter64, the over limit packets summed over the group members for each tcGroupIndex.
+
+The efficiency of each Qdisc / Class is computed from the difference of the counters against the previous parse interval:
+myOID.37 - tcEfficiencyLeaf             - Stores gauges, the percentage of sent packets out of the sent and over limit packets during the last interval for each tcIndex.
+                                          Values close to 0 flag Qdiscs / Classes that spend most of their time throttled. Missing if there was no traffic.
+
+The collection is paused during the configured blackout windows:
+myOID.38 - tcMaintenanceLeaf            - Stores an integer, 1 while the collection is paused during a configured blackout window, 0 otherwise.
+                                          The data isn't updated during the blackout window.
+
+If the xstats option is enabled, the extended statistics that TC prints for some Qdiscs and Classes are exported.
+The known xstats have their own leaves, all the others are exported in a generic table of names and values:
+myOID.39 - tcXstatIndexLeaf             - Stores integers, the SNMP indexes assigned to the xstats without their own leaf.
+myOID.40 - tcXstatNumIndexLeaf          - Stores an integer, the count of indexes assigned to the xstats without their own leaf.
+myOID.41 - tcXstatTcIndexLeaf           - Stores integers, the tcIndex of the Qdisc / Class for each tcXstatIndex.
+myOID.42 - tcXstatNameLeaf              - Stores strings, the name of the xstat for each tcXstatIndex, e.g. "maxpacket".
+myOID.43 - tcXstatValueLeaf             - Stores strings, the value of the xstat as printed by TC including any units for each tcXstatIndex, e.g. "2us".
+myOID.44 - tcDropOverlimitLeaf          - Stores counter64, the drop_overlimit xstat of codel and fq_codel Qdiscs for each tcIndex.
+myOID.45 - tcEcnMarkLeaf                - Stores counter64, the ecn_mark xstat of codel and fq_codel Qdiscs for each tcIndex.
+myOID.46 - tcNewFlowCountLeaf           - Stores counter64, the new_flow_count xstat of fq_codel Qdiscs for each tcIndex.
+myOID.47 - tcLendedLeaf                 - Stores counter64, the lended xstat of HTB Classes for each tcIndex.
+myOID.48 - tcBorrowedLeaf               - Stores counter64, the borrowed xstat of HTB Classes for each tcIndex.
+myOID.49 - tcGiantsLeaf                 - Stores counter64, the giants xstat of HTB Classes for each tcIndex.
+myOID.50 - tcFailuresLeaf               - Stores an integer, the number of consecutive parse cycles in which TC failed, 0 when healthy.
+myOID.51 - tcBackoffLeaf                - Stores an integer, the seconds between the collection attempts while backing off after TC failures, 0 when healthy.
+
+If the retention option is set, the Qdiscs / Classes that disappeared from the TC output keep being exported with their last values for the configured number of cycles:
+myOID.52 - tcStaleLeaf                  - Stores integers, 1 if the Qdisc / Class disappeared from the TC output and is exported with its last values, 0 otherwise for each tcIndex.
+
+If the quotaFile option is set, the bytes of the users are accounted in monthly periods starting on the quotaResetDay and persisted across restarts:
+myOID.53 - tcUserDownMonthBytesLeaf     - Stores counter64, the downloaded bytes since the start of the current period for each tcUserIndex. Starts from zero in every period.
+myOID.54 - tcUserUpMonthBytesLeaf       - Stores counter64, the uploaded bytes since the start of the current period for each tcUserIndex. Starts from zero in every period.
+
+For the users with a configured quota, the bytes in both directions are checked against it. The users that used 80% and 100% of their quota are logged:
+myOID.55 - tcUserQuotaExceededLeaf      - Stores integers, 1 if the user exceeded the quota in the current period, 0 otherwise for each tcUserIndex.
+myOID.56 - tcUserQuotaUsedLeaf          - Stores gauges, the percentage of the quota used in the current period for each tcUserIndex.
+
+The drop rate of each Qdisc / Class is computed from the difference of the counters against the previous parse interval:
+myOID.57 - tcDropRateLeaf               - Stores gauges, the percentage of dropped packets out of the sent and dropped packets during the last interval for each tcIndex.
+                                          Missing if there was no traffic.
+
+If the rateSamples option is set, the moving average rates in bits per second are computed from the byte counters of the most recent parse cycles.
+The windows longer than rateSamples parse intervals are shortened to the kept cycles:
+myOID.58 - tcRate1mLeaf                 - Stores gauges, the average rate over the last minute for each tcIndex.
+myOID.59 - tcRate5mLeaf                 - Stores gauges, the average rate over the last five minutes for each tcIndex.
+myOID.60 - tcRate15mLeaf                - Stores gauges, the average rate over the last fifteen minutes for each tcIndex.
+myOID.61 - tcPeakRateLeaf               - Stores gauges, the highest rate during a parse interval since start for each tcIndex.
+myOID.62 - tcUserDownRate1mLeaf         - Stores gauges, the average download rate over the last minute for each tcUserIndex.
+myOID.63 - tcUserDownRate5mLeaf         - Stores gauges, the average download rate over the last five minutes for each tcUserIndex.
+myOID.64 - tcUserDownRate15mLeaf        - Stores gauges, the average download rate over the last fifteen minutes for each tcUserIndex.
+myOID.65 - tcUserDownPeakRateLeaf       - Stores gauges, the highest download rate during a parse interval since start for each tcUserIndex.
+myOID.66 - tcUserUpRate1mLeaf           - Stores gauges, the average upload rate over the last minute for each tcUserIndex.
+myOID.67 - tcUserUpRate5mLeaf           - Stores gauges, the average upload rate over the last five minutes for each tcUserIndex.
+myOID.68 - tcUserUpRate15mLeaf          - Stores gauges, the average upload rate over the last fifteen minutes for each tcUserIndex.
+myOID.69 - tcUserUpPeakRateLeaf         - Stores gauges, the highest upload rate during a parse interval since start for each tcUserIndex.
+
+The build information is exported for auditing the versions across a fleet:
+myOID.70 - tcVersionLeaf                - Stores a string, the version, commit and build date of tc_reader, see tc_reader -version.
+
+The freshness of the data is exported so that pollers can discard the counters of a wedged collector:
+myOID.71 - tcLastUpdateLeaf             - Stores timeticks, the time since the last successful parse cycle. Missing before the first one.
+
+If the cacheFile option is set, the exported data is persisted after each successful parse cycle. After a restart the
+persisted data is served until the first parse cycle completes, the tcLastUpdateLeaf tells how old it is.
+
+The status of the entries tells the current Qdiscs / Classes from the retained ones, see the retention option:
+myOID.72 - tcStatusLeaf                 - Stores integers, 1 if the Qdisc / Class is in the latest TC output, 2 if it is retained with its last values and 3 if it is removed in the next parse cycle for each tcIndex.
+myOID.73 - tcUserStatusLeaf             - Stores integers, 1 for each tcUserIndex. Users are exported only while their Classes are in the TC output
+                                          or while they are counted by conntrack.
+
+If the ifaceTotals option is set, the counters of each interface are summed, either of its root Qdiscs or of its leaf Classes:
+myOID.74 - tcIfaceIndexLeaf             - Stores integers, the SNMP indexes assigned to the interfaces.
+myOID.75 - tcIfaceNumIndexLeaf          - Stores an integer, the count of indexes assigned to the interfaces.
+myOID.76 - tcIfaceNameLeaf              - Stores strings, the names of the interfaces.
+myOID.77 - tcIfaceBytesLeaf             - Stores counter64, the sent bytes summed over the interface for each tcIfaceIndex.
+myOID.78 - tcIfacePktLeaf               - Stores counter64, the sent packets summed over the interface for each tcIfaceIndex.
+myOID.79 - tcIfaceDroppedPktLeaf        - Stores counter64, the dropped packets summed over the interface for each tcIfaceIndex.
+myOID.80 - tcIfaceOverLimitPktLeaf      - Stores counter64, the over limit packets summed over the interface for each tcIfaceIndex.
+
+The indexes can be looked up by the names without walking the name tables. The names are encoded as SNMP string
+indexes, the length followed by the bytes, e.g. the tcIndex of "eth0:2:3" is myOID.81.8.101.116.104.48.58.50.58.51:
+myOID.81 - tcNameToIndexLeaf            - Stores integers, the tcIndex of each Qdisc / Class keyed by its encoded name.
+myOID.82 - tcUserNameToIndexLeaf        - Stores integers, the tcUserIndex of each user keyed by its encoded name.
+                                          Names longer than 100 bytes aren't encoded.
+
+The lines of the TC output that should have matched the patterns but didn't, i.e. the lines that aren't indented and
+the statistics lines, are counted. These are logged when the debug logging is on:
+myOID.83 - tcUnmatchedLinesLeaf         - Stores a gauge, the number of unmatched lines of the TC output in the last parse cycle.
+
+If the netDev option is set, the kernel counters of the monitored interfaces are read from /proc/net/dev right after
+the TC commands in the same parse cycle, so that the packets lost by the NIC or the driver can be told apart from the
+ones dropped by the shaping. These are exported as read, indexed by the kernel ifIndex:
+myOID.84 - tcNetDevIndexLeaf            - Stores integers, the kernel ifIndexes of the interfaces, these are the indexes of the netDev leaves.
+myOID.85 - tcNetDevNameLeaf             - Stores strings, the name of the interface for each ifIndex.
+myOID.86 - tcNetDevRxBytesLeaf          - Stores counter64, the received bytes for each ifIndex.
+myOID.87 - tcNetDevRxPktLeaf            - Stores counter64, the received packets for each ifIndex.
+myOID.88 - tcNetDevRxErrorsLeaf         - Stores counter64, the receive errors for each ifIndex.
+myOID.89 - tcNetDevRxDroppedLeaf        - Stores counter64, the received packets dropped by the kernel or the driver for each ifIndex.
+myOID.90 - tcNetDevTxBytesLeaf          - Stores counter64, the transmitted bytes for each ifIndex.
+myOID.91 - tcNetDevTxPktLeaf            - Stores counter64, the transmitted packets for each ifIndex.
+myOID.92 - tcNetDevTxErrorsLeaf         - Stores counter64, the transmit errors for each ifIndex.
+myOID.93 - tcNetDevTxDroppedLeaf        - Stores counter64, the packets dropped by the kernel or the driver on transmit for each ifIndex.
+
+If the linkStats option is set, which implies netDev, the counters are read by "ip -s -s link show" instead, which adds:
+myOID.94 - tcNetDevRxMissedLeaf         - Stores counter64, the received packets missed by the NIC for each ifIndex.
+myOID.95 - tcNetDevCarrierChangesLeaf   - Stores counter64, the carrier transitions, i.e. the link going down or up, for each ifIndex.
+
+If the userConntrack option is set, the traffic of the users is also counted from the flows tracked by conntrack, as a
+cross-check of the counters of their Qdiscs / Classes:
+myOID.96 - tcUserDownConntrackBytesLeaf - Stores counter64, the downloaded bytes of the flows of the user since start for each tcUserIndex.
+myOID.97 - tcUserUpConntrackBytesLeaf   - Stores counter64, the uploaded bytes of the flows of the user since start for each tcUserIndex.
+
+If the runtimeStats option is set, the statistics of the Go runtime and the resource usage of tc_reader are exported
+after each parse cycle, so that leaks or runaway parse cycles are caught by the same poll as the TC statistics:
+myOID.98 - tcRuntimeLeaf                - Stores a string, "tcRuntimeLeaf".
+myOID.98.1                              - Stores a gauge, the bytes of the allocated heap objects.
+myOID.98.2                              - Stores a gauge, the bytes of the heap memory obtained from the OS.
+myOID.98.3                              - Stores a gauge, the number of the allocated heap objects.
+myOID.98.4                              - Stores a gauge, the total bytes of the memory obtained from the OS.
+myOID.98.5                              - Stores a gauge, the number of the goroutines.
+myOID.98.6                              - Stores counter64, the number of the completed garbage collections.
+myOID.98.7                              - Stores a gauge, the duration of the last garbage collection pause in microseconds.
+myOID.98.8                              - Stores a gauge, the bytes of the resident memory. Only exported on Linux.
+myOID.98.9                              - Stores counter64, the user and system CPU time in milliseconds. Only exported on Linux.
+myOID.98.10                             - Stores timeticks, the time since tc_reader started.
+
+The running configuration is exported so that remote pollers can verify it, it is updated when the configuration is reloaded:
+myOID.99 - tcConfigLeaf                 - Stores a string, "tcConfigLeaf".
+myOID.99.1                              - Stores an integer, the parse interval in seconds.
+myOID.99.2                              - Stores a string, the monitored interfaces separated by spaces.
+myOID.99.3                              - Stores an integer, the number of the configured users.
+myOID.99.4                              - Stores a string, the path of the config file.
+myOID.99.5                              - Stores a string, the hex encoded SHA-256 checksum of the content of the config files.
+myOID.99.6                              - Stores a string, the most recent modification time of the config files in RFC 3339, empty if none was read.
+
+If the exportDeltas option is set, the increase of the counters during the last interval is exported for the billing
+pipelines that want neither a rate nor a cumulative counter. Missing for the entries that weren't in the previous parse
+cycle:
+myOID.100 - tcDeltaBytesLeaf            - Stores gauges, the sent bytes during the last interval for each tcIndex.
+myOID.101 - tcDeltaPktLeaf              - Stores gauges, the sent packets during the last interval for each tcIndex.
+myOID.102 - tcUserDownDeltaBytesLeaf    - Stores gauges, the downloaded bytes during the last interval for each tcUserIndex.
+myOID.103 - tcUserDownDeltaPktLeaf      - Stores gauges, the downloaded packets during the last interval for each tcUserIndex.
+myOID.104 - tcUserUpDeltaBytesLeaf      - Stores gauges, the uploaded bytes during the last interval for each tcUserIndex.
+myOID.105 - tcUserUpDeltaPktLeaf        - Stores gauges, the uploaded packets during the last interval for each tcUserIndex.
+myOID.106 - tcDeltaIntervalLeaf         - Stores a gauge, the length of the last interval in milliseconds.
+
+Every entry carries the monotonic timestamp of the parse cycle it was parsed in, in milliseconds since the boot including
+the time the system was suspended. It isn't stepped by NTP, so the differences between the timestamps of two parse
+cycles are the real intervals between them, e.g. for computing the rates. The rates and the intervals exported by
+tc_reader are computed from these timestamps:
+myOID.107 - tcMonotonicLeaf             - Stores counter64, the monotonic timestamp of the parse cycle for each tcIndex.
+myOID.108 - tcUserMonotonicLeaf         - Stores counter64, the monotonic timestamp of the parse cycle for each tcUserIndex.
+myOID.109 - tcGroupMonotonicLeaf        - Stores counter64, the monotonic timestamp of the parse cycle for each tcGroupIndex.
+myOID.110 - tcIfaceMonotonicLeaf        - Stores counter64, the monotonic timestamp of the parse cycle for each tcIfaceIndex.
+
+If the trapTarget option is set, SNMPv2c or SNMPv3 notifications are sent when:
+myOID.0.1 - tcParseFailureTrap          - TC started failing, carries tcFailuresLeaf and tcBackoffLeaf.
+myOID.0.2 - tcDropRateTrap              - The drop rate of a Qdisc / Class rose above the trapDropRate, carries tcNameLeaf and tcDropRateLeaf.
+myOID.0.3 - tcQuotaExceededTrap         - An user exceeded the quota, carries tcUserNameLeaf and tcUserQuotaUsedLeaf.
+myOID.0.4 - tcAlertTrap                 - An alert rule with the trap action fired, carries the name and the metric of the alert.
+Each notification is sent once when the condition starts, it is sent again only after the condition ended.
+
+tc_reader exits on SIGTERM and SIGINT after the running parse cycle was canceled, the quota totals were saved and the queued
+notifications and metrics were sent.
+
+tc_reader logs to Syslog by default, the logTarget and logLevel options select the standard error, the systemd journal or
+a file and the least severe logged level. The syslogFacility and syslogTag options, or the -syslog-facility and
+-syslog-tag flags, set the facility and the tag of the messages in Syslog, e.g. to tell multiple instances apart.
+
+If the runAs option is set, tc_reader switches from root to the unprivileged account once it started up, e.g. when the
+SNMP daemon runs it as root. It retains the CAP_NET_ADMIN capability and passes it to the TC commands. The capabilities
+are set on all the threads, which needs tc_reader built with CGO_ENABLED=0.
+
+SIGUSR2 toggles the debug logging of the running tc_reader, e.g. during an incident, until it is restarted or reloaded.
+
+If the controlSocket option is set, the running tc_reader can be inspected and controlled with e.g.:
+tc_reader ctl status
+tc_reader ctl help lists the commands.
 
 tc_reader reads configuration from file named tc_reader.conf
 This configuration file should be located in one of these directories (sorted by order of preference):
 1) ./tc_reader.conf (e.g the current working directory)
 2) /etc/tc_reader.conf
 If the configuration file cannot be located in any of these two locations, tc_reader will use its internal defaults, which probably isn't what you want.
+The -config flag, or else the TC_READER_CONFIG environment variable, sets the path of the configuration file instead,
+tc_reader exits if it can't be read. The files matching *.conf in the directory named after the configuration file with
+a .d suffix, e.g. /etc/tc_reader.conf.d, are read after it in lexical order, so that packaging and provisioning tools
+can drop in partial configurations. The path can also be the directory itself.
+The configuration files with the .toml extension are read as TOML, see tc_reader.toml for an example.
+All the invalid lines of the configuration files are reported at once. The unknown options, e.g. those of a newer
+version, are ignored with a warning.
+
+Some options can be overridden by flags, e.g. to try a configuration without editing the file:
+tc_reader -config /etc/tc_reader/wan.conf -ifaces "eth1 ppp0" -interval 500ms -debug -log-target stderr
+tc_reader -help lists the flags. The -exporter flag runs tc_reader without the SNMP daemon, only feeding the configured
+outputs like the HTTP server or the Prometheus textfile until it receives SIGTERM or SIGINT.
+
+The -check-config flag validates the configuration, e.g. in a provisioning pipeline. It prints the options set by the
+configuration files, the environment and the flags, checks that the TC command is executable, that the interfaces are
+present and that the users reference the classes of the monitored interfaces, and exits non-zero on any problem:
+tc_reader -config /etc/tc_reader.conf -check-config
+
+The -init flag writes a starter configuration file for a new deployment, with the TC command and the interfaces that
+have a Qdisc installed found on this system:
+tc_reader -config /etc/tc_reader.conf -init
+
+tc_reader can run as a systemd service of Type=notify. It reports to systemd once the first parse cycle completed and
+pings the systemd watchdog after every completed parse cycle, so that systemd restarts a tc_reader that got stuck. The
+-install-systemd flag writes the unit of a service running tc_reader with -exporter, the config file and the watchdog:
+tc_reader -config /etc/tc_reader.conf -install-systemd
+
+The -once flag runs a single parse cycle, prints the parsed Qdiscs / Classes, users, interfaces and groups to the
+standard output and exits, e.g. for a quick sanity check or in scripts. The SNMP daemon isn't needed and the messages
+are logged to the standard error. The -format flag selects json, table (the default) or prom:
+tc_reader -once -format json | jq '.users[] | select(.sentBytes > 0)'
+
+The -walk flag runs a single parse cycle and prints the OIDs and values under the OID given after the flags, or under
+the base OID, as the SNMP daemon would get them by a walk. It verifies the exported tree without configuring the SNMP
+daemon:
+tc_reader -walk .1.3.6.1.4.1.2021.255.3
+
+The simulate command drives tc_reader over a pipe exactly as the SNMP daemon would after a single parse cycle: PING,
+get and getnext requests, a walk of the whole tree and malformed input. It prints the result of every check and exits
+non-zero if any of them failed:
+tc_reader -config /etc/tc_reader.conf simulate
+
+Where the long running pass_persist scripts aren't allowed, the SNMP daemon can run tc_reader for every request by
+the simple pass protocol. The requests are answered from the cacheFile, which a tc_reader running with -exporter keeps
+up to date. The line in snmpd.conf is:
+pass .1.3.6.1.4.1.2021.255 /path/to/tc_reader
+
+Several instances can run on one host, e.g. one per network namespace or per tenant, each with its own config file
+setting the instance name, a distinct baseOID under a common OID and a cacheFile. The instances run with -exporter and
+register in the leaderDir, and a single leader registered with the SNMP daemon answers for all of them:
+pass_persist .1.3.6.1.4.1.2021.255 /path/to/tc_reader -config /etc/tc_reader/leader.conf -leader
+The name of the instance is included in the identity by the {instance} placeholder and labels the series of the
+Prometheus textfile.
+
+The -record flag saves the raw TC outputs of every parse cycle with their timestamps to a directory. The -replay flag
+feeds a recording back through the parser instead of executing the TC commands, e.g. to reproduce a parsing problem of
+a production shaper on a development machine. The parse cycles are replayed as fast as possible, or with their original
+timing with -replay-realtime:
+tc_reader -exporter -record /var/tmp/tc_recording
+tc_reader -exporter -replay /var/tmp/tc_recording -replay-realtime -debug -log-target stderr
+
+Any option that isn't repeated can also be overridden by an environment variable named after it, e.g. in containers:
+TC_READER_IFACES="eth1 ppp0" TC_READER_INTERVAL=10 TC_READER_DEBUG=true TC_READER_BASE_OID=.1.3.6.1.4.1.2021.254 tc_reader
+The flags take precedence over the environment variables.
 
 Example output:
 user@host:~# snmpwalk -v2c -c public localhost .1.3.6.1.4.1.2021.255
@@ -98,15 +376,27 @@ iso.3.6.1.4.1.2021.255.17.2 = Counter32: 0
 iso.3.6.1.4.1.2021.255.18 = STRING: "tcUserUpOverLimitPktLeaf"
 iso.3.6.1.4.1.2021.255.18.1 = Counter32: 0
 iso.3.6.1.4.1.2021.255.18.2 = Counter32: 0
+iso.3.6.1.4.1.2021.255.19 = STRING: "tcIfIndexLeaf"
+iso.3.6.1.4.1.2021.255.19.1 = INTEGER: 2
+iso.3.6.1.4.1.2021.255.19.2 = INTEGER: 2
 */
 
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log/syslog"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mum4k/tc_reader/lib"
 )
@@ -120,51 +410,792 @@ const (
 
 	// configPath is the defaut paths to the directory that contains the config file.
 	configPath = "/etc"
+
+	// configEnv is the environment variable with the path of the config file, the -config flag takes precedence.
+	configEnv = "TC_READER_CONFIG"
+
+	// systemdUnitPath is where -install-systemd writes the unit of the tc_reader service.
+	systemdUnitPath = "/etc/systemd/system/tc_reader.service"
+
+	// shutdownTimeout is how long the queued notifications and metrics are sent after SIGTERM or SIGINT.
+	shutdownTimeout = 10 * time.Second
+)
+
+// The build information, it can be set at build time using:
+// go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	// version is the version of tc_reader.
+	version = "unknown"
+
+	// commit is the commit tc_reader was built from.
+	commit = "unknown"
+
+	// buildDate is the time tc_reader was built.
+	buildDate = "unknown"
 )
 
+// The command line flags, they override the options of the config file.
+var (
+	// configFlag is the path of the config file, it replaces the search in the default locations.
+	configFlag = flag.String("config", "", "The path of the config file or of a directory of its fragments. Defaults to $TC_READER_CONFIG, then ./tc_reader.conf, then /etc/tc_reader.conf.")
+
+	// baseOIDFlag overrides the baseOID option.
+	baseOIDFlag = flag.String("base-oid", "", "The OID the data is exported under, e.g. .1.3.6.1.4.1.2021.255. Overrides the baseOID option.")
+
+	// instanceFlag overrides the instance option.
+	instanceFlag = flag.String("instance", "", "The name of this instance among the instances on the host, e.g. tenant1. Overrides the instance option.")
+
+	// ifacesFlag overrides the ifaces option.
+	ifacesFlag = flag.String("ifaces", "", "The monitored interfaces separated by spaces, or \"auto\". Overrides the ifaces option.")
+
+	// intervalFlag overrides the parseInterval option.
+	intervalFlag = durationVar("interval", "The interval in which TC is parsed, a number of seconds or a `duration` like 500ms or 2m. Overrides the parseInterval option.")
+
+	// debugFlag overrides the debug option.
+	debugFlag = flag.Bool("debug", false, "Enables the debug logging. Overrides the debug option.")
+
+	// logTargetFlag overrides the logTarget option.
+	logTargetFlag = flag.String("log-target", "", "Where the log messages are written: syslog, stderr, journald or file:<path>. Overrides the logTarget option.")
+
+	// exporterFlag runs tc_reader without the SNMP daemon.
+	exporterFlag = flag.Bool("exporter", false, "Runs as a standalone exporter for the HTTP, gRPC, Prometheus and other outputs instead of a pass_persist script, the commands of the SNMP daemon aren't read from the standard input.")
+
+	// syslogFacilityFlag overrides the syslogFacility option.
+	syslogFacilityFlag = flag.String("syslog-facility", "", "The Syslog facility of the log messages, e.g. local0. Overrides the syslogFacility option.")
+
+	// checkConfigFlag validates the configuration instead of starting up.
+	checkConfigFlag = flag.Bool("check-config", false, "Validates the configuration on this system, prints the options it sets and exits non-zero if there are problems.")
+
+	// versionFlag prints the build information instead of starting up.
+	versionFlag = flag.Bool("version", false, "Prints the version, commit and build date and exits.")
+
+	// initFlag generates a starter config file instead of starting up.
+	initFlag = flag.Bool("init", false, "Probes this system and writes a starter config file to the path set by -config, or to ./tc_reader.conf. An existing file isn't overwritten.")
+
+	// installSystemdFlag generates the unit of the tc_reader service instead of starting up.
+	installSystemdFlag = flag.Bool("install-systemd", false, "Writes the unit of a tc_reader service running as -exporter with the config file and the systemd watchdog to "+systemdUnitPath+". An existing file isn't overwritten.")
+
+	// recordFlag saves the TC outputs of every parse cycle to a directory.
+	recordFlag = flag.String("record", "", "Saves the raw TC outputs of every parse cycle with their timestamps to the directory, for -replay.")
+
+	// replayFlag parses the recorded TC outputs instead of executing the TC commands.
+	replayFlag = flag.String("replay", "", "Feeds the TC outputs recorded by -record in the directory through the parser instead of executing the TC commands.")
+
+	// replayRealtimeFlag keeps the original timing of the replayed parse cycles.
+	replayRealtimeFlag = flag.Bool("replay-realtime", false, "Replays the parse cycles with the pauses between them when they were recorded, instead of as fast as possible.")
+
+	// onceFlag prints the data of a single parse cycle instead of starting up.
+	onceFlag = flag.Bool("once", false, "Runs a single parse cycle, prints the parsed Qdiscs / Classes and users to the standard output in the -format and exits.")
+
+	// formatFlag is the format of the output of -once.
+	formatFlag = flag.String("format", lib.OnceTable, "The format of the output of -once: json, table or prom.")
+
+	// walkFlag prints the exported tree as the SNMP daemon would get it instead of starting up.
+	walkFlag = flag.Bool("walk", false, "Runs a single parse cycle and prints what the SNMP daemon would get by a walk of the OID given after the flags, or of the base OID, like snmpwalk, and exits.")
+
+	// passGetFlag answers a GET request of the pass protocol instead of starting up.
+	passGetFlag = flag.String("g", "", "Answers the GET request of the OID by the pass protocol of the SNMP daemon from the cacheFile and exits.")
+
+	// passGetNextFlag answers a GET-NEXT request of the pass protocol instead of starting up.
+	passGetNextFlag = flag.String("n", "", "Answers the GET-NEXT request of the OID by the pass protocol of the SNMP daemon from the cacheFile and exits.")
+
+	// leaderFlag answers the SNMP daemon for the registered instances instead of starting up.
+	leaderFlag = flag.Bool("leader", false, "Answers the SNMP daemon by the pass_persist protocol for the instances registered in the leaderDir from their cacheFiles, instead of parsing TC.")
+
+	// passSetFlag rejects a SET request of the pass protocol instead of starting up.
+	passSetFlag = flag.String("s", "", "Rejects the SET request of the OID by the pass protocol of the SNMP daemon, the data isn't writable.")
+
+	// syslogTagFlag overrides the syslogTag option.
+	syslogTagFlag = flag.String("syslog-tag", "", "The tag of the log messages in Syslog and in the journal. Overrides the syslogTag option.")
+)
+
+// durationFlag is the value of a flag that is either a number of seconds or a duration like "500ms" or "2m", the same
+// way as the duration options of the config file.
+type durationFlag time.Duration
+
+// durationVar defines a durationFlag with the name and the usage.
+func durationVar(name, usage string) *durationFlag {
+	d := new(durationFlag)
+	flag.Var(d, name, usage)
+	return d
+}
+
+// String returns the duration formatted by time.Duration.
+func (d *durationFlag) String() string {
+	return time.Duration(*d).String()
+}
+
+// Set parses the value of the flag.
+func (d *durationFlag) Set(value string) error {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		*d = durationFlag(time.Duration(seconds) * time.Second)
+		return nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.New("want a number of seconds or a duration like 500ms")
+	}
+	*d = durationFlag(duration)
+	return nil
+}
+
 // The exit codes.
 const (
 	exitOk = iota
-	exitSyslogError
+	exitLogError
+	exitInitError
+	exitCtlError
+	exitCheckError
+	exitInitConfigError
+	exitOnceError
+	exitInstallError
 )
 
-// main starts up tc_reader.
+// main starts up tc_reader, or sends a command to the control socket of the running tc_reader if the first argument
+// after the flags is "ctl", or simulates the SNMP daemon if it is "simulate".
 func main() {
-	logger, err := syslog.New(syslog.LOG_INFO, syslogTag)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s: Cannot open connection to Syslog, err: %s", syslogTag, err)
-		os.Exit(exitSyslogError)
+	flag.Parse()
+	if flag.Arg(0) == "ctl" {
+		os.Exit(ctl(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "simulate" {
+		os.Exit(simulate())
+	}
+	if *passGetFlag != "" || *passGetNextFlag != "" || *passSetFlag != "" {
+		os.Exit(pass())
+	}
+	if *leaderFlag {
+		os.Exit(leader())
+	}
+	if *versionFlag {
+		fmt.Printf("%s %s\n", syslogTag, buildInfo())
+		os.Exit(exitOk)
 	}
+	if *checkConfigFlag {
+		os.Exit(checkConfig())
+	}
+	if *initFlag {
+		os.Exit(initConfig())
+	}
+	if *installSystemdFlag {
+		os.Exit(installSystemd())
+	}
+	if *onceFlag {
+		os.Exit(once())
+	}
+	if *walkFlag {
+		os.Exit(walk())
+	}
+
+	// Load the config file and override its options by the environment and the flags.
+	c, configFile, configErr := loadConfig()
+	overrideErr := overrideConfig(c)
 
-	// Try to load the config file.
-	c, err := lib.NewConfig(configName)
+	// Open the configured log target.
+	logger, err := lib.NewLogger(c.LogOptions())
 	if err != nil {
-		fileName := filepath.Join(configPath, configName)
-		c, err = lib.NewConfig(fileName)
-		if err != nil {
-			logger.Info(fmt.Sprintf("Cannot locate tc_reader config file. Tried %s and %s. Using the defaults.", configName, fileName))
+		fmt.Fprintf(os.Stderr, "%s: Cannot open the log target, err: %s\n", syslogTag, err)
+		os.Exit(exitLogError)
+	}
+	for _, warning := range c.Warnings() {
+		logger.Warning(warning)
+	}
+	if configErr != nil && configOverride() != "" {
+		logger.Err(fmt.Sprintf("Unable to read the config file %s, error: %s", configFile, configErr))
+		os.Exit(exitInitError)
+	}
+	if overrideErr != nil {
+		logger.Err(overrideErr.Error())
+		os.Exit(exitInitError)
+	}
+	if configErr != nil {
+		logger.Warning(fmt.Sprintf("Cannot locate tc_reader config file. Tried %s and %s. Using the defaults.", configName, configFile))
+	}
+
+	// Configure the notifications.
+	var trap *lib.TrapOptions
+	if c.TrapTarget != "" {
+		trap = &lib.TrapOptions{
+			Target:          c.TrapTarget,
+			Version:         c.TrapVersion,
+			Community:       c.TrapCommunity,
+			EngineID:        c.TrapEngineID,
+			Context:         c.TrapContext,
+			ContextEngineID: c.TrapContextEngineID,
+		}
+		if c.TrapUser != nil {
+			trap.User = c.TrapUser[0]
+			trap.AuthProtocol, trap.AuthPassword = c.TrapUser[1], c.TrapUser[2]
+			trap.PrivProtocol, trap.PrivPassword = c.TrapUser[3], c.TrapUser[4]
+		}
+	}
+
+	// Configure the Graphite sink.
+	var graphite *lib.GraphiteOptions
+	if c.GraphiteTarget != "" {
+		graphite = &lib.GraphiteOptions{
+			Target: c.GraphiteTarget,
+			Path:   c.GraphitePath,
+		}
+	}
+
+	// Configure the MQTT sink.
+	var mqtt *lib.MqttOptions
+	if c.MqttBroker != "" {
+		mqtt = &lib.MqttOptions{
+			Broker:   c.MqttBroker,
+			Topic:    c.MqttTopic,
+			Retain:   c.MqttRetain,
+			TLS:      c.MqttTLS,
+			CAFile:   c.MqttCAFile,
+			User:     c.MqttUser,
+			Password: c.MqttPassword,
+			ClientID: c.MqttClientID,
+		}
+	}
+
+	// Configure the sample file.
+	var sampleFile *lib.SampleFileOptions
+	if c.SampleFile != "" {
+		sampleFile = &lib.SampleFileOptions{
+			Path:      c.SampleFile,
+			Format:    c.SampleFormat,
+			MaxSize:   c.SampleMaxSize,
+			Fields:    c.SampleFields,
+			Retention: c.HistoryRetention,
+		}
+	}
+
+	// Configure the RRD files, they are updated once every parse interval.
+	var rrd *lib.RrdOptions
+	if c.RrdDir != "" {
+		rrd = &lib.RrdOptions{
+			Dir:       c.RrdDir,
+			Step:      c.ParseInterval,
+			CmdPath:   c.RrdCmdPath,
+			Retention: c.HistoryRetention,
+		}
+	}
+
+	// Configure the Zabbix sink.
+	var zabbix *lib.ZabbixOptions
+	if c.ZabbixServer != "" {
+		zabbix = &lib.ZabbixOptions{
+			Server: c.ZabbixServer,
+			Host:   c.ZabbixHost,
+			Key:    c.ZabbixKey,
+		}
+	}
+
+	// Configure the HTTP server.
+	var http *lib.HttpOptions
+	if c.HttpListen != "" {
+		http = &lib.HttpOptions{
+			Listen: c.HttpListen,
+		}
+	}
+
+	// Configure the gRPC server.
+	var grpc *lib.GrpcOptions
+	if c.GrpcListen != "" {
+		grpc = &lib.GrpcOptions{
+			Listen: c.GrpcListen,
+		}
+	}
+
+	// Configure the pprof server.
+	var pprof *lib.PprofOptions
+	if c.PprofListen != "" {
+		pprof = &lib.PprofOptions{
+			Listen: c.PprofListen,
 		}
 	}
 
 	// Configure the SNMP handler.
+	tpo := c.TcParserOptions()
+	applyRecordFlags(tpo)
 	so := &lib.SnmpOptions{
-		Debug: c.Debug,
+		Config:           c.Info(),
+		BaseOID:          c.BaseOID,
+		Identity:         c.Identity,
+		Labels:           c.Labels,
+		Instance:         c.Instance,
+		MaxWarnings:      c.MaxWarnings,
+		PktSizeBuckets:   c.PktSizeBuckets,
+		MaxDataAge:       c.MaxDataAge,
+		Retention:        c.Retention,
+		ExportGeneric:    c.ExportGeneric,
+		ExportUsers:      c.ExportUsers,
+		ExportMetrics:    c.ExportMetrics,
+		ExportDeltas:     c.ExportDeltas,
+		RateSamples:      c.RateSamples,
+		QuotaFile:        c.QuotaFile,
+		CacheFile:        c.CacheFile,
+		HistoryRetention: c.HistoryRetention,
+		QuotaResetDay:    c.QuotaResetDay,
+		Quotas:           c.Quotas,
+		Trap:             trap,
+		TrapDropRate:     c.TrapDropRate,
+		Alerts:           c.Alerts,
+		UserNames:        c.UserNames,
+		UserNameKey:      c.UserNameKey,
+		Graphite:         graphite,
+		Mqtt:             mqtt,
+		SampleFile:       sampleFile,
+		Rrd:              rrd,
+		Zabbix:           zabbix,
+		Http:             http,
+		Grpc:             grpc,
+		Pprof:            pprof,
+		RuntimeStats:     c.RuntimeStats,
+		Version:          version,
+		BuildInfo:        buildInfo(),
+		Debug:            tpo.Debug,
+	}
+	s, err := lib.NewSnmp(logger, lib.WithSnmpOptions(so))
+	if err != nil {
+		logger.Err(fmt.Sprintf("Unable to create the SNMP handler, error: %s", err))
+		os.Exit(exitInitError)
 	}
-	s := lib.NewSnmp(so, logger)
 
-	// Configure the TC parser.
-	tpo := &lib.TcParserOptions{
-		TcCmdPath:     c.TcCmdPath,
-		ParseInterval: c.ParseInterval,
-		TcQdiscStats:  c.TcQdiscStats,
-		TcClassStats:  c.TcClassStats,
-		Ifaces:        c.Ifaces,
-		UserNameClass: c.UserNameClass,
-		Debug:         c.Debug,
+	// Configure the TC parser, it notifies systemd if it runs tc_reader as a service of Type=notify.
+	parserOpts := []lib.TcParserOption{lib.WithTcParserOptions(tpo), lib.WithSnmp(s)}
+	notifier := lib.NewSystemdNotifier(logger)
+	if notifier != nil {
+		parserOpts = append(parserOpts, lib.WithSystemdNotifier(notifier))
+	}
+	tp, err := lib.NewTcParser(logger, parserOpts...)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Unable to create the TC parser, error: %s", err))
+		os.Exit(exitInitError)
 	}
-	lib.NewTcParser(tpo, s, logger)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	if err := tp.Start(ctx); err != nil {
+		logger.Err(fmt.Sprintf("Unable to start the TC parser, error: %s", err))
+		os.Exit(exitInitError)
+	}
+
+	// Toggle the debug logging on SIGUSR2.
+	toggle := make(chan os.Signal, 1)
+	signal.Notify(toggle, syscall.SIGUSR2)
+	go func() {
+		for range toggle {
+			on := !tp.DebugEnabled()
+			tp.SetDebug(on)
+			s.SetDebug(on)
+			state := "off"
+			if on {
+				state = "on"
+			}
+			logger.Info(fmt.Sprintf("Received SIGUSR2, the debug logging is %s.", state))
+		}
+	}()
 
-	// Listen to commands from SNMP daemon.
-	s.Listen()
+	// Serve the control socket.
+	var control io.Closer
+	if c.ControlSocket != "" {
+		reload := func() error {
+			c, err := lib.NewConfig(configFile)
+			if err != nil {
+				return err
+			}
+			if err := overrideConfig(c); err != nil {
+				return err
+			}
+			for _, warning := range c.Warnings() {
+				logger.Warning(warning)
+			}
+			options := c.TcParserOptions()
+			applyRecordFlags(options)
+			if err := tp.Reload(options); err != nil {
+				return err
+			}
+			s.SetDebug(options.Debug)
+			s.SetConfig(c.Info())
+			return nil
+		}
+		control, err = lib.NewControlServer(&lib.ControlOptions{Socket: c.ControlSocket, Reload: reload}, logger, s, tp)
+		if err != nil {
+			logger.Err(fmt.Sprintf("Unable to serve the control socket %s, error: %s", c.ControlSocket, err))
+			os.Exit(exitInitError)
+		}
+	}
+
+	// Switch to the unprivileged account once the log target, the sockets and the listeners are open.
+	if c.RunAs != "" {
+		if err := lib.DropPrivileges(c.RunAs, c.RunAsGroup); err != nil {
+			logger.Err(fmt.Sprintf("Unable to drop the privileges, error: %s", err))
+			os.Exit(exitInitError)
+		}
+		logger.Info(fmt.Sprintf("Switched to the user %s, retaining CAP_NET_ADMIN.", c.RunAs))
+	}
+
+	// Register with the leader answering the SNMP daemon for the instances on this host.
+	if c.LeaderDir != "" {
+		registration := &lib.InstanceRegistration{
+			Instance:  c.Instance,
+			BaseOID:   c.BaseOID,
+			CacheFile: c.CacheFile,
+			Pid:       os.Getpid(),
+		}
+		if err := lib.RegisterInstance(c.LeaderDir, registration); err != nil {
+			logger.Err(fmt.Sprintf("Unable to register the instance in %s, error: %s", c.LeaderDir, err))
+			os.Exit(exitInitError)
+		}
+	}
+
+	// Listen to commands from SNMP daemon until it exits or a signal arrives. The exporter only waits for the signal.
+	listened := make(chan struct{})
+	if !*exporterFlag {
+		go func() {
+			s.Listen()
+			close(listened)
+		}()
+	}
+	select {
+	case <-listened:
+	case <-ctx.Done():
+		logger.Info("Received a signal, shutting down.")
+	}
+	stop()
+
+	// Finish the running parse cycle and flush the state before exiting.
+	if notifier != nil {
+		if err := notifier.Close(); err != nil {
+			logger.Err(err.Error())
+		}
+	}
+	if control != nil {
+		control.Close()
+	}
+	if c.LeaderDir != "" {
+		if err := lib.UnregisterInstance(c.LeaderDir, c.Instance); err != nil {
+			logger.Err(fmt.Sprintf("Unable to unregister the instance from %s, error: %s", c.LeaderDir, err))
+		}
+	}
+	tp.Stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := s.Close(shutdownCtx); err != nil {
+		logger.Err(fmt.Sprintf("Unable to shut down cleanly, error: %s", err))
+	}
+	cancel()
+	logger.Close()
 	os.Exit(exitOk)
 }
+
+// applyRecordFlags sets the options recording or replaying the TC outputs, these are only set on the command line.
+func applyRecordFlags(o *lib.TcParserOptions) {
+	o.RecordDir = *recordFlag
+	o.ReplayDir = *replayFlag
+	o.ReplayRealtime = *replayRealtimeFlag
+}
+
+// ctl sends the command to the control socket configured in the config file, prints its output and returns the exit
+// code.
+func ctl(args []string) int {
+	if len(args) == 0 {
+		args = []string{"help"}
+	}
+	c, configFile, err := loadConfig()
+	if err == nil {
+		if err := overrideConfig(c); err != nil {
+			fmt.Fprintf(os.Stderr, "%s ctl: %s\n", syslogTag, err)
+			return exitCtlError
+		}
+	}
+	if err != nil || c.ControlSocket == "" {
+		fmt.Fprintf(os.Stderr, "%s ctl: The controlSocket isn't set in %s.\n", syslogTag, configFile)
+		return exitCtlError
+	}
+	output, err := lib.ControlCommand(context.Background(), c.ControlSocket, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s ctl: %s\n", syslogTag, err)
+		return exitCtlError
+	}
+	fmt.Print(output)
+	return exitOk
+}
+
+// buildInfo returns the version, commit and build date of tc_reader and the version of Go it was built with.
+func buildInfo() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s)", version, commit, buildDate, runtime.Version())
+}
+
+// checkConfig validates the configuration, prints the options it sets and the problems found, and returns the exit
+// code.
+func checkConfig() int {
+	c, configFile, err := loadConfig()
+	if err == nil {
+		err = overrideConfig(c)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to read the config file %s, error: %s\n", syslogTag, configFile, err)
+		return exitCheckError
+	}
+	for _, warning := range c.Warnings() {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", syslogTag, warning)
+	}
+	fmt.Printf("# %s\n%s", configFile, c.Dump())
+	problems := c.Check()
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "%s: Problem: %s\n", syslogTag, problem)
+	}
+	if len(problems) > 0 {
+		return exitCheckError
+	}
+	return exitOk
+}
+
+// initConfig writes a starter config file for this system and returns the exit code.
+func initConfig() int {
+	configFile := *configFlag
+	if configFile == "" {
+		configFile = configName
+	}
+	logger, err := lib.NewLogger(&lib.LogOptions{Target: "stderr", Level: "warning"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Cannot open the log target, err: %s\n", syslogTag, err)
+		return exitLogError
+	}
+	defer logger.Close()
+	content, err := lib.InitConfig(logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to probe the system, error: %s\n", syslogTag, err)
+		return exitInitConfigError
+	}
+	file, err := os.OpenFile(configFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to create the config file, error: %s\n", syslogTag, err)
+		return exitInitConfigError
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		fmt.Fprintf(os.Stderr, "%s: Unable to write the config file %s, error: %s\n", syslogTag, configFile, err)
+		return exitInitConfigError
+	}
+	if err := file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to write the config file %s, error: %s\n", syslogTag, configFile, err)
+		return exitInitConfigError
+	}
+	fmt.Printf("Wrote the starter config file %s.\n", configFile)
+	return exitOk
+}
+
+// installSystemd writes the unit of the tc_reader service running with the loaded config file and returns the exit
+// code.
+func installSystemd() int {
+	c, configFile, err := loadConfig()
+	if err == nil {
+		err = overrideConfig(c)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: The service needs a valid config file, unable to read %s, error: %s\n", syslogTag, configFile, err)
+		return exitInstallError
+	}
+	if configFile, err = filepath.Abs(configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to locate the config file, error: %s\n", syslogTag, err)
+		return exitInstallError
+	}
+	command, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to locate the tc_reader binary, error: %s\n", syslogTag, err)
+		return exitInstallError
+	}
+	file, err := os.OpenFile(systemdUnitPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to create the unit, error: %s\n", syslogTag, err)
+		return exitInstallError
+	}
+	if _, err := file.WriteString(lib.SystemdUnit(command, configFile, c.TcParserOptions())); err != nil {
+		file.Close()
+		fmt.Fprintf(os.Stderr, "%s: Unable to write the unit %s, error: %s\n", syslogTag, systemdUnitPath, err)
+		return exitInstallError
+	}
+	if err := file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to write the unit %s, error: %s\n", syslogTag, systemdUnitPath, err)
+		return exitInstallError
+	}
+	fmt.Printf("Wrote the unit %s, start the service with:\nsystemctl daemon-reload && systemctl enable --now tc_reader\n", systemdUnitPath)
+	return exitOk
+}
+
+// once prints the data of a single parse cycle in the format set by -format and returns the exit code.
+func once() int {
+	return runOnce(func(c *lib.Config, logger lib.Logger) error {
+		return lib.CollectOnce(c.TcParserOptions(), logger, *formatFlag, os.Stdout)
+	})
+}
+
+// walk prints the data under the OID given as the first argument after the flags, or under the base OID, that the
+// SNMP daemon would get after a single parse cycle, and returns the exit code.
+func walk() int {
+	return runOnce(func(c *lib.Config, logger lib.Logger) error {
+		return lib.Walk(c.TcParserOptions(), onceSnmpOptions(c), logger, flag.Arg(0), os.Stdout)
+	})
+}
+
+// simulate drives the SNMP handler as the SNMP daemon would after a single parse cycle, prints the results of the
+// checks and returns the exit code.
+func simulate() int {
+	return runOnce(func(c *lib.Config, logger lib.Logger) error {
+		return lib.Simulate(c.TcParserOptions(), onceSnmpOptions(c), logger, os.Stdout)
+	})
+}
+
+// onceSnmpOptions returns the options of the SNMP handler for a single parse cycle, without the notifications, the
+// outputs and the persisted files.
+func onceSnmpOptions(c *lib.Config) *lib.SnmpOptions {
+	return &lib.SnmpOptions{
+		Config:         c.Info(),
+		BaseOID:        c.BaseOID,
+		Identity:       c.Identity,
+		Labels:         c.Labels,
+		Instance:       c.Instance,
+		MaxWarnings:    c.MaxWarnings,
+		PktSizeBuckets: c.PktSizeBuckets,
+		ExportGeneric:  c.ExportGeneric,
+		ExportUsers:    c.ExportUsers,
+		ExportMetrics:  c.ExportMetrics,
+		ExportDeltas:   c.ExportDeltas,
+		RateSamples:    c.RateSamples,
+		Quotas:         c.Quotas,
+		Version:        version,
+		BuildInfo:      buildInfo(),
+	}
+}
+
+// leader answers the SNMP daemon by the pass_persist protocol for the instances registered in the leaderDir until the
+// SNMP daemon exits, and returns the exit code. The messages are logged to the configured log target.
+func leader() int {
+	c, configFile, err := loadConfig()
+	if err != nil && configOverride() != "" {
+		fmt.Fprintf(os.Stderr, "%s: Unable to read the config file %s, error: %s\n", syslogTag, configFile, err)
+		return exitInitError
+	}
+	if err := overrideConfig(c); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", syslogTag, err)
+		return exitInitError
+	}
+	logger, err := lib.NewLogger(c.LogOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Cannot open the log target, err: %s\n", syslogTag, err)
+		return exitLogError
+	}
+	defer logger.Close()
+	if c.LeaderDir == "" {
+		logger.Err("The leader answers for the instances registered in the leaderDir, which isn't set.")
+		return exitInitError
+	}
+	l, err := lib.NewLeader(c.LeaderDir, logger, os.Stdin, os.Stdout)
+	if err != nil {
+		logger.Err(fmt.Sprintf("Unable to read the registrations in %s, error: %s", c.LeaderDir, err))
+		return exitInitError
+	}
+	l.Listen()
+	return exitOk
+}
+
+// pass answers the request of the pass protocol of the SNMP daemon set by the -g, -n or -s flag and returns the exit
+// code.
+func pass() int {
+	if *passSetFlag != "" {
+		fmt.Println("not-writable")
+		return exitOk
+	}
+	return runOnce(func(c *lib.Config, logger lib.Logger) error {
+		so := onceSnmpOptions(c)
+		so.CacheFile = c.CacheFile
+		if *passGetFlag != "" {
+			return lib.Pass(so, logger, lib.PassGet, *passGetFlag, os.Stdout)
+		}
+		return lib.Pass(so, logger, lib.PassGetNext, *passGetNextFlag, os.Stdout)
+	})
+}
+
+// runOnce runs the function with the configuration, e.g. a single parse cycle, and returns the exit code. The messages
+// are logged to the standard error instead of the configured log target.
+func runOnce(run func(c *lib.Config, logger lib.Logger) error) int {
+	c, configFile, err := loadConfig()
+	if err != nil && configOverride() != "" {
+		fmt.Fprintf(os.Stderr, "%s: Unable to read the config file %s, error: %s\n", syslogTag, configFile, err)
+		return exitOnceError
+	}
+	if err := overrideConfig(c); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", syslogTag, err)
+		return exitOnceError
+	}
+	level := "warning"
+	if c.Debug {
+		level = "debug"
+	}
+	logger, err := lib.NewLogger(&lib.LogOptions{Target: "stderr", Level: level})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Cannot open the log target, err: %s\n", syslogTag, err)
+		return exitLogError
+	}
+	defer logger.Close()
+	for _, warning := range c.Warnings() {
+		logger.Warning(warning)
+	}
+	if err := run(c, logger); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", syslogTag, err)
+		return exitOnceError
+	}
+	return exitOk
+}
+
+// configOverride returns the path of the config file set by the -config flag or by the environment variable, empty if
+// the config file is searched in the default locations.
+func configOverride() string {
+	if *configFlag != "" {
+		return *configFlag
+	}
+	return os.Getenv(configEnv)
+}
+
+// loadConfig reads the config file set by the -config flag or by the environment variable, or the first one found in
+// the default locations. Returns the path of the last file tried.
+func loadConfig() (*lib.Config, string, error) {
+	configFile := configOverride()
+	if configFile == "" {
+		configFile = configName
+	}
+	c, err := lib.NewConfig(configFile)
+	if err != nil && configOverride() == "" {
+		configFile = filepath.Join(configPath, configName)
+		c, err = lib.NewConfig(configFile)
+	}
+	return c, configFile, err
+}
+
+// overrideConfig overrides the options of the config file by the TC_READER_ environment variables and those by the
+// flags set on the command line. The flags are applied even if an environment variable is invalid.
+func overrideConfig(c *lib.Config) error {
+	err := c.ApplyEnv(os.Environ())
+	applyFlags(c)
+	return err
+}
+
+// applyFlags overrides the options of the config file by the flags set on the command line.
+func applyFlags(c *lib.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "base-oid":
+			c.BaseOID = *baseOIDFlag
+		case "instance":
+			c.Instance = *instanceFlag
+		case "ifaces":
+			c.Ifaces = strings.Fields(*ifacesFlag)
+		case "interval":
+			c.ParseInterval = time.Duration(*intervalFlag)
+		case "debug":
+			c.Debug = *debugFlag
+		case "log-target":
+			c.LogTarget = *logTargetFlag
+		case "syslog-facility":
+			c.SyslogFacility = *syslogFacilityFlag
+		case "syslog-tag":
+			c.SyslogTag = *syslogTagFlag
+		}
+	})
+}